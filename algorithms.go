@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"strings"
+)
+
+// algorithmList is a comma-separated list of SSH algorithms of a kind offered
+// instead of x/crypto/ssh's defaults, in order of preference, e.g. to match
+// the KEXINIT of a given OpenSSH version. It implements flag.Value.
+type algorithmList struct {
+	kind       string
+	supported  []string
+	algorithms []string
+}
+
+// newAlgorithmList returns an empty list of algorithms of kind, which may be
+// any of supported and insecure.
+func newAlgorithmList(kind string, supported, insecure []string) *algorithmList {
+	return &algorithmList{kind: kind, supported: append(supported, insecure...)}
+}
+
+func (list *algorithmList) String() string {
+	if list == nil {
+		return ""
+	}
+	return strings.Join(list.algorithms, ",")
+}
+
+// Set implements flag.Value.
+func (list *algorithmList) Set(text string) error {
+	algorithms := []string{}
+	for _, algorithm := range strings.Split(text, ",") {
+		if !list.supports(algorithm) {
+			return fmt.Errorf("unsupported %v algorithm %q, must be one of %v", list.kind, algorithm, strings.Join(list.supported, ", "))
+		}
+		algorithms = append(algorithms, algorithm)
+	}
+	list.algorithms = algorithms
+	return nil
+}
+
+func (list *algorithmList) supports(algorithm string) bool {
+	for _, supported := range list.supported {
+		if supported == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+// algorithmFlags are the algorithms offered in the server's KEXINIT and
+// EXT_INFO messages.
+type algorithmFlags struct {
+	keyExchanges, ciphers, macs, publicKeyAuths *algorithmList
+}
+
+func newAlgorithmFlags() algorithmFlags {
+	supported, insecure := ssh.SupportedAlgorithms(), ssh.InsecureAlgorithms()
+	return algorithmFlags{
+		keyExchanges:   newAlgorithmList("key exchange", supported.KeyExchanges, insecure.KeyExchanges),
+		ciphers:        newAlgorithmList("cipher", supported.Ciphers, insecure.Ciphers),
+		macs:           newAlgorithmList("MAC", supported.MACs, insecure.MACs),
+		publicKeyAuths: newAlgorithmList("public key authentication", supported.PublicKeyAuths, insecure.PublicKeyAuths),
+	}
+}
+
+// apply sets the algorithms that were set in sshConfig, leaving the others
+// to x/crypto/ssh's defaults.
+func (flags algorithmFlags) apply(sshConfig *ssh.ServerConfig) {
+	sshConfig.KeyExchanges = flags.keyExchanges.algorithms
+	sshConfig.Ciphers = flags.ciphers.algorithms
+	sshConfig.MACs = flags.macs.algorithms
+	sshConfig.PublicKeyAuthAlgorithms = flags.publicKeyAuths.algorithms
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// kexInit returns the name-lists of the KEXINIT message sshConfig's server
+// sends, in the order of RFC 4253 section 7.1.
+func kexInit(t *testing.T, sshConfig *ssh.ServerConfig) [][]string {
+	t.Helper()
+	clientEnd, serverEnd := net.Pipe()
+	defer clientEnd.Close()
+	go func() {
+		defer serverEnd.Close()
+		ssh.NewServerConn(serverEnd, sshConfig)
+	}()
+	// The server waits for the client's identification before sending
+	// KEXINIT, but not for net.Pipe's writes to be read, so it's written
+	// from a goroutine.
+	go clientEnd.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+	reader := bufio.NewReader(clientEnd)
+	if _, err := reader.ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		t.Fatal(err)
+	}
+	packet := make([]byte, binary.BigEndian.Uint32(header)-1)
+	if _, err := io.ReadFull(reader, packet); err != nil {
+		t.Fatal(err)
+	}
+	payload := packet[:len(packet)-int(header[4])]
+	if payload[0] != 20 {
+		t.Fatalf("server sent message %v, want KEXINIT", payload[0])
+	}
+	payload = payload[17:]
+	lists := [][]string{}
+	for i := 0; i < 10; i++ {
+		length := binary.BigEndian.Uint32(payload)
+		lists = append(lists, strings.Split(string(payload[4:4+length]), ","))
+		payload = payload[4+length:]
+	}
+	return lists
+}
+
+// newHostKey returns a new ed25519 host key.
+func newHostKey(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromSigner(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func TestAlgorithmsOffered(t *testing.T) {
+	algorithms := newAlgorithmFlags()
+	for list, text := range map[*algorithmList]string{
+		algorithms.keyExchanges:   "curve25519-sha256,ecdh-sha2-nistp256,diffie-hellman-group14-sha256",
+		algorithms.ciphers:        "chacha20-poly1305@openssh.com,aes128-ctr",
+		algorithms.macs:           "hmac-sha2-256-etm@openssh.com,hmac-sha2-256",
+		algorithms.publicKeyAuths: "ssh-ed25519,rsa-sha2-512",
+	} {
+		if err := list.Set(text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sshConfig := &ssh.ServerConfig{NoClientAuth: true}
+	algorithms.apply(sshConfig)
+	sshConfig.AddHostKey(newHostKey(t))
+	lists := kexInit(t, sshConfig)
+
+	// x/crypto/ssh adds the libssh alias of curve25519-sha256 and strict key
+	// exchange, which can't be left out.
+	if want := []string{"curve25519-sha256", "curve25519-sha256@libssh.org", "ecdh-sha2-nistp256", "diffie-hellman-group14-sha256", "kex-strict-s-v00@openssh.com"}; !reflect.DeepEqual(lists[0], want) {
+		t.Errorf("KEXINIT offers the key exchanges %v, want %v", lists[0], want)
+	}
+	for i, want := range map[int][]string{
+		1: {"ssh-ed25519"},
+		2: {"chacha20-poly1305@openssh.com", "aes128-ctr"},
+		3: {"chacha20-poly1305@openssh.com", "aes128-ctr"},
+		4: {"hmac-sha2-256-etm@openssh.com", "hmac-sha2-256"},
+		5: {"hmac-sha2-256-etm@openssh.com", "hmac-sha2-256"},
+		6: {"none"},
+		7: {"none"},
+	} {
+		if !reflect.DeepEqual(lists[i], want) {
+			t.Errorf("KEXINIT name-list %v is %v, want %v", i, lists[i], want)
+		}
+	}
+	if want := []string{"ssh-ed25519", "rsa-sha2-512"}; !reflect.DeepEqual(sshConfig.PublicKeyAuthAlgorithms, want) {
+		t.Errorf("server-sig-algs are %v, want %v", sshConfig.PublicKeyAuthAlgorithms, want)
+	}
+
+	// Algorithms left unset are x/crypto/ssh's defaults.
+	defaults := &ssh.ServerConfig{NoClientAuth: true}
+	newAlgorithmFlags().apply(defaults)
+	defaults.AddHostKey(newHostKey(t))
+	if supported := ssh.SupportedAlgorithms(); !reflect.DeepEqual(kexInit(t, defaults)[2], supported.Ciphers) {
+		t.Errorf("KEXINIT offers the ciphers %v by default, want %v", kexInit(t, defaults)[2], supported.Ciphers)
+	}
+}
+
+func TestAlgorithmListInvalid(t *testing.T) {
+	for _, text := range []string{"aes128-ctr,rot13", "", "aes128-ctr,"} {
+		list := newAlgorithmFlags().ciphers
+		if err := list.Set(text); err == nil {
+			t.Errorf("ciphers %q accepted as %v", text, list)
+		}
+	}
+	// Insecure algorithms can be offered to pass for older versions.
+	if err := newAlgorithmFlags().keyExchanges.Set("diffie-hellman-group1-sha1"); err != nil {
+		t.Errorf("insecure key exchange rejected: %v", err)
+	}
+}
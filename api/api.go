@@ -0,0 +1,50 @@
+// Package api serves the HTTP endpoints used to monitor a running server.
+package api
+
+import (
+	"encoding/json"
+	"github.com/longkeyy/sshesame/auth"
+	"github.com/longkeyy/sshesame/output"
+	"github.com/longkeyy/sshesame/stats"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// topCount is how many credentials and hosts /api/stats returns.
+const topCount = 10
+
+// Handler returns the HTTP handler serving every API endpoint. The denylist
+// can only be managed, and events streamed, with token.
+func Handler(health *Health, dispatcher *output.Dispatcher, events *output.RecentSink, stream *output.StreamSink, aggregates *stats.Stats, denylist *auth.Denylist, token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", health.serveLiveness)
+	mux.HandleFunc("/readyz", health.serveReadiness)
+	mux.HandleFunc("/api/runtime", func(w http.ResponseWriter, r *http.Request) {
+		serveJSON(w, readRuntime(health.Build, health.Started, aggregates.Active(), aggregates.ActiveChannels(), dispatcher.Dropped()))
+	})
+	handleData(mux, events, aggregates)
+	handleDenylist(mux, denylist, token)
+	handleStream(mux, stream, token)
+	return mux
+}
+
+// handleData registers the endpoints serving recorded data on mux.
+func handleData(mux *http.ServeMux, events *output.RecentSink, aggregates *stats.Stats) {
+	mux.HandleFunc("/api/events", func(w http.ResponseWriter, r *http.Request) {
+		serveJSON(w, events.Events())
+	})
+	mux.HandleFunc("/api/stats", func(w http.ResponseWriter, r *http.Request) {
+		serveJSON(w, struct {
+			ActiveConnections int                `json:"active_connections"`
+			TopCredentials    []stats.Credential `json:"top_credentials"`
+			TopHosts          []stats.HostCount  `json:"top_hosts"`
+		}{aggregates.Active(), aggregates.TopCredentials(topCount), aggregates.TopHosts(topCount)})
+	})
+}
+
+func serveJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Warning("Failed to write API response:", err.Error())
+	}
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"github.com/longkeyy/sshesame/output"
+	"github.com/longkeyy/sshesame/stats"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxRecordings bounds how many recordings /api/recordings lists.
+const maxRecordings = 100
+
+// dashboard is a self-contained page, it loads nothing but the data endpoints
+// so that it works without internet access.
+//
+//go:embed dashboard.html
+var dashboard []byte
+
+// Recording is an asciicast recording listed by the dashboard.
+type Recording struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// DashboardHandler returns the HTTP handler serving the dashboard along with
+// the data endpoints it polls and the asciicast recordings of recordingDir,
+// if set, for replay. Every request must authenticate as user with password
+// over HTTP basic authentication, unless password is empty.
+func DashboardHandler(events *output.RecentSink, aggregates *stats.Stats, sessions *output.SessionsSink, recordingDir, user, password string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(dashboard)
+	})
+	handleData(mux, events, aggregates)
+	mux.HandleFunc("/api/sessions", func(w http.ResponseWriter, r *http.Request) {
+		serveJSON(w, struct {
+			Sessions     []output.LiveSession  `json:"sessions"`
+			TopCountries []output.CountryCount `json:"top_countries"`
+		}{sessions.Sessions(), sessions.TopCountries(topCount)})
+	})
+	if recordingDir != "" {
+		handleRecordings(mux, recordingDir)
+	}
+	if password == "" {
+		return mux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestUser, requestPassword, ok := r.BasicAuth()
+		// Both are compared whatever the user, to take the same time.
+		userMatches := subtle.ConstantTimeCompare([]byte(requestUser), []byte(user))
+		passwordMatches := subtle.ConstantTimeCompare([]byte(requestPassword), []byte(password))
+		if !ok || userMatches&passwordMatches != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="sshesame", charset="UTF-8"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// handleRecordings registers the endpoints listing and serving the asciicast
+// recordings of dir on mux.
+//
+//	GET /api/recordings lists the most recent ones, newest first
+//	GET /api/recordings/<name> serves one
+func handleRecordings(mux *http.ServeMux, dir string) {
+	mux.HandleFunc("/api/recordings", func(w http.ResponseWriter, r *http.Request) {
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			http.Error(w, "failed to list recordings", http.StatusInternalServerError)
+			return
+		}
+		recordings := []Recording{}
+		for _, info := range infos {
+			if info.Mode().IsRegular() && strings.HasSuffix(info.Name(), ".cast") {
+				recordings = append(recordings, Recording{info.Name(), info.Size(), info.ModTime()})
+			}
+		}
+		sort.Slice(recordings, func(i, j int) bool {
+			return recordings[i].Modified.After(recordings[j].Modified)
+		})
+		if len(recordings) > maxRecordings {
+			recordings = recordings[:maxRecordings]
+		}
+		serveJSON(w, recordings)
+	})
+	mux.HandleFunc("/api/recordings/", func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/api/recordings/")
+		if name != filepath.Base(name) || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".cast") {
+			http.NotFound(w, r)
+			return
+		}
+		file, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-asciicast")
+		http.ServeContent(w, r, name, info.ModTime(), file)
+	})
+}
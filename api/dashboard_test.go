@@ -0,0 +1,132 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/longkeyy/sshesame/output"
+	"github.com/longkeyy/sshesame/stats"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newDashboard returns the dashboard handler of an accepted password from
+// 192.0.2.1, serving the recordings of recordingDir and requiring password.
+func newDashboard(t *testing.T, recordingDir, password string) http.Handler {
+	t.Helper()
+	events := output.NewRecentSink(10)
+	events.Emit(output.Event{Time: time.Now(), Level: log.InfoLevel, Message: "Password authentication accepted", Fields: log.Fields{
+		"client":   &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 50000},
+		"user":     "root",
+		"password": "123456",
+	}})
+	aggregates := stats.New()
+	aggregates.RecordConnection(&net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 50000})
+	aggregates.RecordPassword("root", "123456")
+	return DashboardHandler(events, aggregates, output.NewSessionsSink(), recordingDir, "admin", password)
+}
+
+func TestDashboardServed(t *testing.T) {
+	handler := newDashboard(t, t.TempDir(), "")
+	code, page := get(t, handler.ServeHTTP, "/")
+	if code != http.StatusOK || !strings.Contains(page, "<html") {
+		t.Fatalf("/ = %v %q, want the dashboard", code, page)
+	}
+	if external := regexp.MustCompile(`(?:src|href)="(?:https?:)?//`).FindString(page); external != "" {
+		t.Errorf("dashboard loads %v, want nothing from other hosts", external)
+	}
+	if code, _ := get(t, handler.ServeHTTP, "/missing"); code != http.StatusNotFound {
+		t.Errorf("/missing = %v, want %v", code, http.StatusNotFound)
+	}
+	// Every endpoint polled by the page answers with JSON.
+	endpoints := regexp.MustCompile(`get\("(api/\w+)"\)`).FindAllStringSubmatch(page, -1)
+	if len(endpoints) == 0 {
+		t.Fatal("dashboard polls no endpoint")
+	}
+	for _, match := range endpoints {
+		code, body := get(t, handler.ServeHTTP, "/"+match[1])
+		var value interface{}
+		if code != http.StatusOK || json.Unmarshal([]byte(body), &value) != nil {
+			t.Errorf("/%v = %v %q, want JSON", match[1], code, body)
+		}
+	}
+}
+
+func TestDashboardData(t *testing.T) {
+	handler := newDashboard(t, "", "")
+	_, body := get(t, handler.ServeHTTP, "/api/events")
+	var events []output.RecentEvent
+	if err := json.Unmarshal([]byte(body), &events); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Message != "Password authentication accepted" || events[0].Fields["client"] != "192.0.2.1:50000" {
+		t.Errorf("/api/events = %q, want the password authentication", body)
+	}
+	_, body = get(t, handler.ServeHTTP, "/api/stats")
+	var aggregates struct {
+		TopCredentials []stats.Credential `json:"top_credentials"`
+		TopHosts       []stats.HostCount  `json:"top_hosts"`
+	}
+	if err := json.Unmarshal([]byte(body), &aggregates); err != nil {
+		t.Fatal(err)
+	}
+	if len(aggregates.TopCredentials) != 1 || aggregates.TopCredentials[0].Password != "123456" || len(aggregates.TopHosts) != 1 || aggregates.TopHosts[0].Address != "192.0.2.1" {
+		t.Errorf("/api/stats = %q, want root:123456 tried from 192.0.2.1", body)
+	}
+	if code, _ := get(t, handler.ServeHTTP, "/api/recordings"); code != http.StatusNotFound {
+		t.Errorf("/api/recordings without a recording directory = %v, want %v", code, http.StatusNotFound)
+	}
+}
+
+func TestDashboardRecordings(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "session.cast"), []byte("{\"version\": 2}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	handler := newDashboard(t, dir, "")
+	if _, body := get(t, handler.ServeHTTP, "/api/recordings"); !strings.Contains(body, `"session.cast"`) || strings.Contains(body, "notes.txt") {
+		t.Errorf("/api/recordings = %q, want only session.cast", body)
+	}
+	if code, body := get(t, handler.ServeHTTP, "/api/recordings/session.cast"); code != http.StatusOK || body != "{\"version\": 2}\n" {
+		t.Errorf("/api/recordings/session.cast = %v %q", code, body)
+	}
+	for _, path := range []string{"/api/recordings/notes.txt", "/api/recordings/..%2Fsession.cast", "/api/recordings/missing.cast"} {
+		if code, _ := get(t, handler.ServeHTTP, path); code != http.StatusNotFound {
+			t.Errorf("%v = %v, want %v", path, code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestDashboardAuthentication(t *testing.T) {
+	handler := newDashboard(t, "", "hunter2")
+	for _, test := range []struct {
+		user, password string
+		code           int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"admin", "wrong", http.StatusUnauthorized},
+		{"root", "hunter2", http.StatusUnauthorized},
+		{"admin", "hunter2", http.StatusOK},
+	} {
+		for _, path := range []string{"/", "/api/events"} {
+			request := httptest.NewRequest("GET", path, nil)
+			if test.user != "" {
+				request.SetBasicAuth(test.user, test.password)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			if recorder.Code != test.code {
+				t.Errorf("%v as %v:%v = %v, want %v", path, test.user, test.password, recorder.Code, test.code)
+			}
+		}
+	}
+}
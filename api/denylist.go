@@ -0,0 +1,70 @@
+package api
+
+import (
+	"crypto/subtle"
+	"github.com/longkeyy/sshesame/auth"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// handleDenylist registers the endpoint managing denylist on mux. Requests
+// must carry token as a bearer token, the endpoint is disabled if it's empty.
+//
+//	GET /api/denylist lists the denied addresses and networks
+//	POST /api/denylist?entry=<address or network> denies one
+//	DELETE /api/denylist?entry=<address or network> stops denying one
+func handleDenylist(mux *http.ServeMux, denylist *auth.Denylist, token string) {
+	if denylist == nil || token == "" {
+		return
+	}
+	mux.HandleFunc("/api/denylist", func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		entry := r.URL.Query().Get("entry")
+		switch r.Method {
+		case http.MethodGet:
+			serveJSON(w, denylist.Entries())
+		case http.MethodPost:
+			added, err := denylist.Add(entry)
+			if added == "" {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			log.WithFields(log.Fields{
+				"entry":       added,
+				"remote_addr": r.RemoteAddr,
+			}).Info("Denylist entry added")
+			if err != nil {
+				log.Warning("Failed to persist denylist:", err.Error())
+				http.Error(w, "failed to persist denylist", http.StatusInternalServerError)
+				return
+			}
+			serveJSON(w, denylist.Entries())
+		case http.MethodDelete:
+			removed, err := denylist.Remove(entry)
+			if err != nil && !removed {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if !removed {
+				http.Error(w, "not denied", http.StatusNotFound)
+				return
+			}
+			log.WithFields(log.Fields{
+				"entry":       entry,
+				"remote_addr": r.RemoteAddr,
+			}).Info("Denylist entry removed")
+			if err != nil {
+				log.Warning("Failed to persist denylist:", err.Error())
+				http.Error(w, "failed to persist denylist", http.StatusInternalServerError)
+				return
+			}
+			serveJSON(w, denylist.Entries())
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
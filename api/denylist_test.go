@@ -0,0 +1,166 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"github.com/longkeyy/sshesame/auth"
+	"github.com/longkeyy/sshesame/config"
+	"github.com/longkeyy/sshesame/honeypot"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveHoneypot serves a honeypot accepting any password, refusing the
+// clients of denylist, on a new loopback listener until the end of the test,
+// returning its address.
+func serveHoneypot(t *testing.T, denylist *auth.Denylist) string {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromSigner(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshConfig := &ssh.ServerConfig{ServerVersion: "SSH-2.0-OpenSSH_8.9p1"}
+	sshConfig.AddHostKey(signer)
+	server := honeypot.NewServer(&config.Config{Auth: config.Auth{Methods: config.Methods{"password"}}}, sshConfig)
+	server.Denylist = denylist
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shutdown := make(chan struct{})
+	go server.Serve(listener, shutdown)
+	t.Cleanup(func() {
+		close(shutdown)
+		listener.Close()
+		server.Drain(5 * time.Second)
+	})
+	return listener.Addr().String()
+}
+
+// connects reports whether an SSH client can log in to address.
+func connects(address string) bool {
+	client, err := ssh.Dial("tcp", address, &ssh.ClientConfig{
+		User:            "root",
+		Auth:            []ssh.AuthMethod{ssh.Password("password")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+	if err != nil {
+		return false
+	}
+	client.Close()
+	return true
+}
+
+// request makes a request to handler with token, returning its response.
+func request(handler http.Handler, method, path, token string) (int, string) {
+	request := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder.Code, recorder.Body.String()
+}
+
+func TestDenylistManaged(t *testing.T) {
+	out := log.StandardLogger().Out
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(out)
+	path := filepath.Join(t.TempDir(), "denylist")
+	denylist, err := auth.LoadDenylist(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	handleDenylist(mux, denylist, "s3cret")
+	address := serveHoneypot(t, denylist)
+	if !connects(address) {
+		t.Fatal("client refused before being denied")
+	}
+
+	if code, body := request(mux, "POST", "/api/denylist?entry=127.0.0.1", "s3cret"); code != http.StatusOK || body != "[\"127.0.0.1\"]\n" {
+		t.Fatalf("POST = %v %q, want the denylist", code, body)
+	}
+	if connects(address) {
+		t.Error("denied client connected")
+	}
+	if data, err := ioutil.ReadFile(path); err != nil || string(data) != "127.0.0.1\n" {
+		t.Errorf("persisted %q, %v", data, err)
+	}
+	if reloaded, err := auth.LoadDenylist(path); err != nil || !reloaded.Denied(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}) {
+		t.Errorf("reloaded denylist = %v, %v, want the client still denied", reloaded, err)
+	}
+
+	if code, body := request(mux, "DELETE", "/api/denylist?entry=127.0.0.1", "s3cret"); code != http.StatusOK || body != "[]\n" {
+		t.Fatalf("DELETE = %v %q, want an empty denylist", code, body)
+	}
+	if !connects(address) {
+		t.Error("client refused after being allowed again")
+	}
+	if data, err := ioutil.ReadFile(path); err != nil || string(data) != "\n" {
+		t.Errorf("persisted %q, %v, want no entries", data, err)
+	}
+}
+
+func TestDenylistRequests(t *testing.T) {
+	out := log.StandardLogger().Out
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(out)
+	denylist, err := auth.LoadDenylist("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	handleDenylist(mux, denylist, "s3cret")
+	for _, test := range []struct {
+		method, path, token string
+		code                int
+		body                string
+	}{
+		{"GET", "/api/denylist", "", http.StatusUnauthorized, ""},
+		{"POST", "/api/denylist?entry=192.0.2.1", "wrong", http.StatusUnauthorized, ""},
+		{"POST", "/api/denylist?entry=192.0.2.0/255", "s3cret", http.StatusBadRequest, ""},
+		{"POST", "/api/denylist?entry=198.51.100.7/24", "s3cret", http.StatusOK, "[\"198.51.100.0/24\"]\n"},
+		{"POST", "/api/denylist?entry=2001:db8::1", "s3cret", http.StatusOK, "[\"198.51.100.0/24\",\"2001:db8::1\"]\n"},
+		{"GET", "/api/denylist", "s3cret", http.StatusOK, "[\"198.51.100.0/24\",\"2001:db8::1\"]\n"},
+		{"DELETE", "/api/denylist?entry=192.0.2.1", "s3cret", http.StatusNotFound, ""},
+		{"DELETE", "/api/denylist?entry=nonsense", "s3cret", http.StatusBadRequest, ""},
+		{"PUT", "/api/denylist", "s3cret", http.StatusMethodNotAllowed, ""},
+	} {
+		code, body := request(mux, test.method, test.path, test.token)
+		if code != test.code || (test.body != "" && body != test.body) {
+			t.Errorf("%v %v = %v %q, want %v %q", test.method, test.path, code, body, test.code, test.body)
+		}
+	}
+	if !denylist.Denied(&net.TCPAddr{IP: net.IPv4(198, 51, 100, 200)}) || denylist.Denied(&net.TCPAddr{IP: net.IPv4(198, 51, 101, 1)}) {
+		t.Error("198.51.100.0/24 not denied as a network")
+	}
+	if strings.Contains(strings.Join(denylist.Entries(), ","), "192.0.2") {
+		t.Error("unauthorized or invalid entry added")
+	}
+}
+
+func TestDenylistDisabledWithoutToken(t *testing.T) {
+	denylist, err := auth.LoadDenylist("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	handleDenylist(mux, denylist, "")
+	if code, _ := request(mux, "GET", "/api/denylist", ""); code != http.StatusNotFound {
+		t.Errorf("GET without a token configured = %v, want %v", code, http.StatusNotFound)
+	}
+}
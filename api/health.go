@@ -0,0 +1,92 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Health tracks whether the server is able to accept and record connections.
+type Health struct {
+	// Build is reported along with liveness.
+	Build BuildInfo
+	// Started is when the server started.
+	Started time.Time
+
+	mu           sync.RWMutex
+	listening    bool
+	shuttingDown bool
+	checks       map[string]func() error
+}
+
+// SetListening records whether the SSH listeners are up.
+func (health *Health) SetListening(listening bool) {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	health.listening = listening
+}
+
+// SetShuttingDown marks the server as no longer ready for new connections.
+func (health *Health) SetShuttingDown() {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	health.shuttingDown = true
+}
+
+// AddCheck registers a readiness check, such as one reporting whether an
+// output sink is reachable. Checks must be cheap and must not block.
+func (health *Health) AddCheck(name string, check func() error) {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	if health.checks == nil {
+		health.checks = map[string]func() error{}
+	}
+	health.checks[name] = check
+}
+
+// Ready returns nil if the server is ready, or an error describing why not.
+func (health *Health) Ready() error {
+	health.mu.RLock()
+	defer health.mu.RUnlock()
+	if health.shuttingDown {
+		return fmt.Errorf("shutting down")
+	}
+	if !health.listening {
+		return fmt.Errorf("not listening")
+	}
+	names := make([]string, 0, len(health.checks))
+	for name := range health.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := health.checks[name](); err != nil {
+			return fmt.Errorf("%v: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (health *Health) serveLiveness(w http.ResponseWriter, r *http.Request) {
+	health.mu.RLock()
+	shuttingDown := health.shuttingDown
+	health.mu.RUnlock()
+	if shuttingDown {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	serveJSON(w, struct {
+		Status string `json:"status"`
+		BuildInfo
+	}{"ok", health.Build})
+}
+
+func (health *Health) serveReadiness(w http.ResponseWriter, r *http.Request) {
+	if err := health.Ready(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// get returns the status code and body handler answers a GET of path with.
+func get(t *testing.T, handler http.HandlerFunc, path string) (int, string) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest("GET", path, nil))
+	return recorder.Code, recorder.Body.String()
+}
+
+func TestReadiness(t *testing.T) {
+	health := &Health{}
+	if code, _ := get(t, health.serveReadiness, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz before listening = %v, want %v", code, http.StatusServiceUnavailable)
+	}
+	health.SetListening(true)
+	if code, body := get(t, health.serveReadiness, "/readyz"); code != http.StatusOK || body != "ok\n" {
+		t.Errorf("/readyz = %v %q, want %v \"ok\\n\"", code, body, http.StatusOK)
+	}
+	if code, _ := get(t, health.serveLiveness, "/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz = %v, want %v", code, http.StatusOK)
+	}
+	health.SetShuttingDown()
+	for path, handler := range map[string]http.HandlerFunc{"/readyz": health.serveReadiness, "/healthz": health.serveLiveness} {
+		if code, _ := get(t, handler, path); code != http.StatusServiceUnavailable {
+			t.Errorf("%v while shutting down = %v, want %v", path, code, http.StatusServiceUnavailable)
+		}
+	}
+}
+
+func TestReadinessChecks(t *testing.T) {
+	health := &Health{}
+	health.SetListening(true)
+	var sinkErr error
+	health.AddCheck("sink file", func() error { return sinkErr })
+	if code, _ := get(t, health.serveReadiness, "/readyz"); code != http.StatusOK {
+		t.Errorf("/readyz with a passing check = %v, want %v", code, http.StatusOK)
+	}
+	sinkErr = errors.New("disk full")
+	code, body := get(t, health.serveReadiness, "/readyz")
+	if code != http.StatusServiceUnavailable || !strings.Contains(body, "sink file: disk full") {
+		t.Errorf("/readyz with a failing check = %v %q, want %v naming the check", code, body, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,20 @@
+package api
+
+import (
+	"github.com/longkeyy/sshesame/output"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// MetricsHandler returns the HTTP handler serving metrics on /metrics in the
+// Prometheus text exposition format.
+func MetricsHandler(metrics *output.MetricsSink) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := metrics.WriteMetrics(w); err != nil {
+			log.Warning("Failed to write metrics:", err.Error())
+		}
+	})
+	return mux
+}
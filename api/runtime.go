@@ -0,0 +1,43 @@
+package api
+
+import (
+	"runtime"
+	"time"
+)
+
+// BuildInfo identifies the running build.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	GoVersion string `json:"go_version"`
+}
+
+// Runtime is a snapshot of the state of the running process.
+type Runtime struct {
+	Build             BuildInfo `json:"build"`
+	Uptime            string    `json:"uptime"`
+	Goroutines        int       `json:"goroutines"`
+	HeapAllocBytes    uint64    `json:"heap_alloc_bytes"`
+	SysBytes          uint64    `json:"sys_bytes"`
+	NumGC             uint32    `json:"num_gc"`
+	ActiveConnections int       `json:"active_connections"`
+	ActiveChannels    int       `json:"active_channels"`
+	// DroppedEvents are the numbers of events dropped for each sink.
+	DroppedEvents map[string]uint64 `json:"dropped_events"`
+}
+
+func readRuntime(build BuildInfo, started time.Time, activeConnections, activeChannels int, droppedEvents map[string]uint64) Runtime {
+	var memory runtime.MemStats
+	runtime.ReadMemStats(&memory)
+	return Runtime{
+		Build:             build,
+		Uptime:            time.Since(started).Truncate(time.Second).String(),
+		Goroutines:        runtime.NumGoroutine(),
+		HeapAllocBytes:    memory.HeapAlloc,
+		SysBytes:          memory.Sys,
+		NumGC:             memory.NumGC,
+		ActiveConnections: activeConnections,
+		ActiveChannels:    activeChannels,
+		DroppedEvents:     droppedEvents,
+	}
+}
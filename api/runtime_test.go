@@ -0,0 +1,38 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestRuntime(t *testing.T) {
+	build := BuildInfo{Version: "v1.2.3", Commit: "0123abc", GoVersion: runtime.Version()}
+	state := readRuntime(build, time.Now().Add(-90*time.Second), 3, 5, map[string]uint64{"file": 2})
+	if state.Build != build || state.Uptime != "1m30s" {
+		t.Errorf("runtime is of build %v up for %v, want %v up for 1m30s", state.Build, state.Uptime, build)
+	}
+	if state.Goroutines < 1 || state.HeapAllocBytes == 0 || state.SysBytes < state.HeapAllocBytes {
+		t.Errorf("runtime has %v goroutines, %v heap bytes out of %v", state.Goroutines, state.HeapAllocBytes, state.SysBytes)
+	}
+	if state.ActiveConnections != 3 || state.ActiveChannels != 5 || state.DroppedEvents["file"] != 2 {
+		t.Errorf("runtime has %v connections, %v channels and dropped %v", state.ActiveConnections, state.ActiveChannels, state.DroppedEvents)
+	}
+}
+
+func TestLivenessBuild(t *testing.T) {
+	health := &Health{Build: BuildInfo{Version: "v1.2.3", Commit: "0123abc", GoVersion: runtime.Version()}}
+	code, body := get(t, health.serveLiveness, "/healthz")
+	var liveness struct {
+		Status string `json:"status"`
+		BuildInfo
+	}
+	if err := json.Unmarshal([]byte(body), &liveness); err != nil {
+		t.Fatal(err)
+	}
+	if code != http.StatusOK || liveness.Status != "ok" || liveness.BuildInfo != health.Build {
+		t.Errorf("/healthz = %v %q, want ok with the build", code, body)
+	}
+}
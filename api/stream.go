@@ -0,0 +1,306 @@
+package api
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"github.com/longkeyy/sshesame/output"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebSocket protocol, see RFC 6455.
+const (
+	websocketGUID         = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	websocketText         = 0x1
+	websocketClose        = 0x8
+	websocketPing         = 0x9
+	websocketPong         = 0xa
+	websocketMaxFrame     = 64 << 10
+	websocketPingInterval = 30 * time.Second
+	streamWriteTimeout    = 10 * time.Second
+)
+
+// handleStream registers the endpoint streaming events on mux as they are
+// emitted, as JSON lines or WebSocket text messages if the request upgrades
+// to WebSocket. Requests must carry token as a bearer token or a token
+// parameter, for browsers, the endpoint is disabled if it's empty. Repeated
+// or comma-separated parameters filter events:
+//
+//	GET /api/stream?message=<message>&type=<type>&category=<category>&cidr=<network>
+func handleStream(mux *http.ServeMux, stream *output.StreamSink, token string) {
+	if token == "" {
+		return
+	}
+	mux.HandleFunc("/api/stream", func(w http.ResponseWriter, r *http.Request) {
+		authorized := subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) == 1 ||
+			subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(token)) == 1
+		if !authorized {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		filter, err := parseStreamFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// The token isn't logged.
+		query := r.URL.Query()
+		query.Del("token")
+		fields := log.Fields{
+			"remote_addr": r.RemoteAddr,
+			"filter":      query.Encode(),
+		}
+		if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			fields["websocket"] = true
+		}
+		log.WithFields(fields).Info("Event stream subscribed")
+		subscription := stream.Subscribe(filter)
+		defer func() {
+			stream.Unsubscribe(subscription)
+			log.WithFields(log.Fields{
+				"remote_addr": r.RemoteAddr,
+				"dropped":     subscription.Dropped(),
+			}).Info("Event stream unsubscribed")
+		}()
+		if fields["websocket"] == true {
+			serveWebSocket(w, r, subscription)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+		for {
+			select {
+			case line, ok := <-subscription.Events:
+				if !ok {
+					return
+				}
+				if _, err := w.Write(append(line, '\n')); err != nil {
+					return
+				}
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+}
+
+// parseStreamFilter parses the filter of a stream request.
+func parseStreamFilter(r *http.Request) (output.StreamFilter, error) {
+	query := r.URL.Query()
+	values := func(name string, split bool) map[string]bool {
+		set := map[string]bool{}
+		for _, value := range query[name] {
+			if !split {
+				set[value] = true
+				continue
+			}
+			for _, part := range strings.Split(value, ",") {
+				if part = strings.TrimSpace(part); part != "" {
+					set[part] = true
+				}
+			}
+		}
+		return set
+	}
+	filter := output.StreamFilter{
+		// Messages may hold commas.
+		Messages:   values("message", false),
+		Types:      values("type", true),
+		Categories: values("category", true),
+	}
+	for network := range values("cidr", true) {
+		if !strings.Contains(network, "/") {
+			if ip := net.ParseIP(network); ip != nil && ip.To4() != nil {
+				network += "/32"
+			} else {
+				network += "/128"
+			}
+		}
+		_, parsed, err := net.ParseCIDR(network)
+		if err != nil {
+			return output.StreamFilter{}, err
+		}
+		filter.Networks = append(filter.Networks, parsed)
+	}
+	return filter, nil
+}
+
+// serveWebSocket upgrades r to WebSocket and sends events of subscription as
+// text messages until either side closes the connection. Messages clients
+// send are ignored.
+func serveWebSocket(w http.ResponseWriter, r *http.Request, subscription *output.Subscription) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || key == "" || r.Header.Get("Sec-WebSocket-Version") != "13" || !headerContains(r.Header, "Connection", "upgrade") {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "invalid WebSocket handshake", http.StatusBadRequest)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket unsupported", http.StatusInternalServerError)
+		return
+	}
+	conn, buffered, err := hijacker.Hijack()
+	if err != nil {
+		log.Warning("Failed to upgrade to WebSocket:", err.Error())
+		return
+	}
+	defer conn.Close()
+	accept := sha1.Sum([]byte(key + websocketGUID))
+	socket := &websocketConn{conn: conn}
+	if err := socket.write([]byte("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(accept[:]) + "\r\n\r\n")); err != nil {
+		return
+	}
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		socket.readFrames(buffered.Reader)
+	}()
+	ping := time.NewTicker(websocketPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case line, ok := <-subscription.Events:
+			if !ok {
+				socket.writeFrame(websocketClose, []byte{0x03, 0xe9}) // going away
+				return
+			}
+			if err := socket.writeFrame(websocketText, line); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := socket.writeFrame(websocketPing, nil); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// headerContains reports whether the comma-separated header called name
+// holds token, ignoring case.
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// websocketConn is the server side of a WebSocket connection.
+type websocketConn struct {
+	conn net.Conn
+	// mu serializes writes, as pings are answered while events are sent.
+	mu sync.Mutex
+}
+
+func (socket *websocketConn) write(data []byte) error {
+	socket.mu.Lock()
+	defer socket.mu.Unlock()
+	socket.conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+	_, err := socket.conn.Write(data)
+	return err
+}
+
+// writeFrame writes a final, unmasked frame, as servers send them.
+func (socket *websocketConn) writeFrame(opcode byte, payload []byte) error {
+	frame := []byte{0x80 | opcode}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, 126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+	default:
+		frame = append(frame, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(len(payload)))
+	}
+	return socket.write(append(frame, payload...))
+}
+
+// readFrames reads the frames the client sends, answering pings, until it
+// closes the connection or sends an invalid frame.
+func (socket *websocketConn) readFrames(reader *bufio.Reader) {
+	for {
+		opcode, payload, err := readWebSocketFrame(reader)
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case websocketClose:
+			socket.writeFrame(websocketClose, payload)
+			return
+		case websocketPing:
+			if socket.writeFrame(websocketPong, payload) != nil {
+				return
+			}
+		}
+	}
+}
+
+// readWebSocketFrame reads a frame, which clients must mask, returning its
+// opcode and unmasked payload. Larger frames than websocketMaxFrame are
+// refused.
+func readWebSocketFrame(reader *bufio.Reader) (byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return 0, nil, err
+	}
+	if header[1]&0x80 == 0 {
+		return 0, nil, errors.New("unmasked client frame")
+	}
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		extended := make([]byte, 2)
+		if _, err := io.ReadFull(reader, extended); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended))
+	case 127:
+		extended := make([]byte, 8)
+		if _, err := io.ReadFull(reader, extended); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended)
+	}
+	if length > websocketMaxFrame {
+		return 0, nil, errors.New("frame too large")
+	}
+	mask := make([]byte, 4)
+	if _, err := io.ReadFull(reader, mask); err != nil {
+		return 0, nil, err
+	}
+	payload, err := ioutil.ReadAll(io.LimitReader(reader, int64(length)))
+	if err != nil {
+		return 0, nil, err
+	}
+	if uint64(len(payload)) != length {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return header[0] & 0x0f, payload, nil
+}
@@ -0,0 +1,213 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/longkeyy/sshesame/output"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newStream serves the stream endpoint with token, returning the sink it
+// streams and the server's address.
+func newStream(t *testing.T, token string) (*output.StreamSink, string) {
+	t.Helper()
+	stream := output.NewStreamSink(output.Timestamps{})
+	mux := http.NewServeMux()
+	handleStream(mux, stream, token)
+	server := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		stream.Close()
+		server.Close()
+	})
+	return stream, server.Listener.Addr().String()
+}
+
+// emitOnceSubscribed emits an event with message once stream has a
+// subscriber.
+func emitOnceSubscribed(t *testing.T, stream *output.StreamSink, message string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for stream.Subscribers() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("nobody subscribed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	stream.Emit(output.Event{Time: time.Now(), Level: log.InfoLevel, Message: message, Fields: log.Fields{"client": "192.0.2.1:50000"}})
+}
+
+func TestStreamDisabledWithoutToken(t *testing.T) {
+	_, address := newStream(t, "")
+	response, err := http.Get("http://" + address + "/api/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusNotFound {
+		t.Errorf("GET without a token configured = %v, want %v", response.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestStreamUnauthorized(t *testing.T) {
+	_, address := newStream(t, "secret")
+	for _, path := range []string{"/api/stream", "/api/stream?token=wrong"} {
+		response, err := http.Get("http://" + address + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusUnauthorized {
+			t.Errorf("GET %v = %v, want %v", path, response.StatusCode, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestStreamJSONLines(t *testing.T) {
+	stream, address := newStream(t, "secret")
+	request, _ := http.NewRequest("GET", "http://"+address+"/api/stream?message=Command+executed", nil)
+	request.Header.Set("Authorization", "Bearer secret")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK || response.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("GET = %v with %v", response.StatusCode, response.Header.Get("Content-Type"))
+	}
+	emitOnceSubscribed(t, stream, "Password authentication rejected")
+	stream.Emit(output.Event{Time: time.Now(), Level: log.InfoLevel, Message: "Command executed"})
+	line, err := bufio.NewReader(response.Body).ReadString('\n')
+	if err != nil || !strings.Contains(line, `"msg":"Command executed"`) {
+		t.Errorf("streamed %q, %v, want the command alone", line, err)
+	}
+}
+
+func TestWebSocketFramesWritten(t *testing.T) {
+	// The examples of RFC 6455 section 5.7, as servers send them.
+	for _, test := range []struct {
+		opcode  byte
+		payload []byte
+		header  []byte
+	}{
+		{websocketText, []byte("Hello"), []byte{0x81, 0x05}},
+		{websocketPing, []byte("Hello"), []byte{0x89, 0x05}},
+		{websocketText, bytes.Repeat([]byte{'x'}, 256), []byte{0x81, 0x7e, 0x01, 0x00}},
+		{websocketText, bytes.Repeat([]byte{'x'}, 65536), []byte{0x81, 0x7f, 0, 0, 0, 0, 0, 0x01, 0x00, 0x00}},
+	} {
+		client, server := net.Pipe()
+		go func() {
+			(&websocketConn{conn: server}).writeFrame(test.opcode, test.payload)
+			server.Close()
+		}()
+		frame, err := ioutil.ReadAll(client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := append(test.header, test.payload...); !bytes.Equal(frame, want) {
+			t.Errorf("%v bytes with opcode %v framed as % x..., want % x...", len(test.payload), test.opcode, frame[:len(test.header)], test.header)
+		}
+	}
+}
+
+func TestWebSocketFramesRead(t *testing.T) {
+	for _, test := range []struct {
+		frame   []byte
+		opcode  byte
+		payload string
+		invalid bool
+	}{
+		// The masked examples of RFC 6455 section 5.7.
+		{[]byte{0x81, 0x85, 0x37, 0xfa, 0x21, 0x3d, 0x7f, 0x9f, 0x4d, 0x51, 0x58}, websocketText, "Hello", false},
+		{[]byte{0x8a, 0x85, 0x37, 0xfa, 0x21, 0x3d, 0x7f, 0x9f, 0x4d, 0x51, 0x58}, websocketPong, "Hello", false},
+		{[]byte{0x88, 0x80, 0x01, 0x02, 0x03, 0x04}, websocketClose, "", false},
+		// Unmasked, oversized and truncated frames are refused.
+		{[]byte{0x81, 0x05, 0x48, 0x65, 0x6c, 0x6c, 0x6f}, 0, "", true},
+		{[]byte{0x81, 0xff, 0, 0, 0x01, 0, 0, 0, 0, 0, 0, 0, 0, 0}, 0, "", true},
+		{[]byte{0x81, 0xfe, 0xff, 0xff, 0, 0, 0, 0}, 0, "", true},
+		{[]byte{0x81, 0x85, 0x37, 0xfa, 0x21, 0x3d, 0x7f}, 0, "", true},
+		{[]byte{0x81}, 0, "", true},
+	} {
+		opcode, payload, err := readWebSocketFrame(bufio.NewReader(bytes.NewReader(test.frame)))
+		if test.invalid {
+			if err == nil {
+				t.Errorf("% x read as opcode %v with %q", test.frame, opcode, payload)
+			}
+			continue
+		}
+		if err != nil || opcode != test.opcode || string(payload) != test.payload {
+			t.Errorf("% x read as opcode %v with %q, %v, want %v with %q", test.frame, opcode, payload, err, test.opcode, test.payload)
+		}
+	}
+}
+
+func TestWebSocketStream(t *testing.T) {
+	stream, address := newStream(t, "secret")
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	// The handshake of RFC 6455 section 1.3, with the token as a parameter
+	// as browsers can't set headers.
+	io.WriteString(conn, "GET /api/stream?token=secret HTTP/1.1\r\nHost: "+address+"\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusSwitchingProtocols || response.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("upgrade answered with %v and %v", response.Status, response.Header)
+	}
+
+	emitOnceSubscribed(t, stream, "Command executed")
+	frame := make([]byte, 2)
+	if _, err := io.ReadFull(reader, frame); err != nil {
+		t.Fatal(err)
+	}
+	payload := make([]byte, frame[1])
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		t.Fatal(err)
+	}
+	if frame[0] != 0x80|websocketText || !strings.Contains(string(payload), `"msg":"Command executed"`) {
+		t.Errorf("sent the frame %x with %q, want the event as text", frame, payload)
+	}
+
+	// Pings are answered, and closing frames echoed.
+	conn.Write([]byte{0x89, 0x85, 0x37, 0xfa, 0x21, 0x3d, 0x7f, 0x9f, 0x4d, 0x51, 0x58})
+	pong := make([]byte, 7)
+	if _, err := io.ReadFull(reader, pong); err != nil || string(pong) != "\x8a\x05Hello" {
+		t.Errorf("ping answered with %q, %v", pong, err)
+	}
+	conn.Write([]byte{0x88, 0x82, 0x01, 0x02, 0x03, 0x04, 0x03 ^ 0x01, 0xe8 ^ 0x02})
+	echo := make([]byte, 4)
+	if _, err := io.ReadFull(reader, echo); err != nil || string(echo) != "\x88\x02\x03\xe8" {
+		t.Errorf("close answered with %q, %v", echo, err)
+	}
+}
+
+func TestWebSocketHandshakeInvalid(t *testing.T) {
+	_, address := newStream(t, "secret")
+	request, _ := http.NewRequest("GET", "http://"+address+"/api/stream", nil)
+	request.Header.Set("Authorization", "Bearer secret")
+	request.Header.Set("Upgrade", "websocket")
+	request.Header.Set("Connection", "Upgrade")
+	request.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	request.Header.Set("Sec-WebSocket-Version", "8")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusBadRequest || response.Header.Get("Sec-WebSocket-Version") != "13" {
+		t.Errorf("an unsupported version answered with %v and %v", response.StatusCode, response.Header)
+	}
+}
@@ -0,0 +1,338 @@
+// Package auth decides the outcome of authentication attempts.
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/longkeyy/sshesame/config"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"strings"
+)
+
+// ErrRejected is returned by authentication callbacks that reject an attempt.
+var ErrRejected = errors.New("rejected")
+
+// Connection holds the authentication state of a single connection. Its
+// callbacks are meant to be installed on a per-connection copy of the
+// ssh.ServerConfig.
+type Connection struct {
+	cfg config.Auth
+	// offeredKeys records the fingerprints of every distinct key offered.
+	offeredKeys map[string]bool
+	// keyDecisions remembers the decision made for every user and key, as the
+	// public key callback can be called both when a key is queried and when
+	// it's used to sign.
+	keyDecisions map[string]Decision
+	decider      AuthDecider
+	// sshConfig is the configuration the callbacks are installed on, whose
+	// callbacks clients continue with after partial success.
+	sshConfig *ssh.ServerConfig
+	blocker   *Blocker
+	history   *History
+	attempted bool
+	// invalidUsers are the invalid users already logged.
+	invalidUsers map[string]bool
+	// steps is the number of methods clients must authenticate with in
+	// turn, if chained, stepsPassed how many the client passed and stepUser
+	// the user it last attempted to authenticate as, with any method.
+	steps, stepsPassed int
+	stepUser           string
+	// delayed is set once the pre-authentication delay was waited for.
+	delayed bool
+	// attempts and failures count the attempts of every method, and failed
+	// the failures counted toward MaxAuthTries.
+	attempts, failures map[string]int
+	failed             int
+}
+
+// NewConnection returns the authentication state of a new connection, whose
+// attempts are decided by decider, a ConfigDecider if nil, or a DecoyDecider
+// in decoy mode. Failed attempts are recorded to blocker and history, and
+// decisions to history in StickyDecisions mode, which may both be nil.
+func NewConnection(cfg config.Auth, blocker *Blocker, history *History, decider AuthDecider) *Connection {
+	switch {
+	case cfg.Decoy:
+		decider = DecoyDecider{}
+	case decider == nil:
+		decider = ConfigDecider{Auth: cfg}
+	}
+	return &Connection{
+		cfg:          cfg,
+		offeredKeys:  map[string]bool{},
+		keyDecisions: map[string]Decision{},
+		decider:      decider,
+		blocker:      blocker,
+		history:      history,
+		invalidUsers: map[string]bool{},
+		attempts:     map[string]int{},
+		failures:     map[string]int{},
+	}
+}
+
+// CredentialExtension is the extension of the permissions of authenticated
+// connections holding the credential they authenticated with.
+const CredentialExtension = "credential"
+
+// CredentialPermissions returns the permissions of a connection authenticated
+// with credential.
+func CredentialPermissions(credential string) *ssh.Permissions {
+	return &ssh.Permissions{Extensions: map[string]string{CredentialExtension: credential}}
+}
+
+// PublicKeyCallback implements ssh.ServerConfig.PublicKeyCallback.
+func (connection *Connection) PublicKeyCallback(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	fingerprint := ssh.FingerprintSHA256(key)
+	decisionKey := conn.User() + " " + fingerprint
+	decision, decided := connection.keyDecisions[decisionKey]
+	if !decided {
+		connection.offeredKeys[fingerprint] = true
+		decision = Decision{Outcome: Reject, Reason: "invalid user"}
+		if connection.cfg.Users.Valid(conn.User()) {
+			decision = connection.decider.PublicKey(connection.attempt(conn), key)
+		}
+		connection.keyDecisions[decisionKey] = decision
+		connection.LogDecision(log.Fields{
+			"client":      conn.RemoteAddr(),
+			"user":        conn.User(),
+			"key_type":    key.Type(),
+			"fingerprint": fingerprint,
+			"public_key":  base64.StdEncoding.EncodeToString(key.Marshal()),
+			"version":     string(conn.ClientVersion()),
+		}, "Public key", decision)
+	}
+	return connection.decide("publickey", decision, "publickey "+fingerprint)
+}
+
+// KeyboardInteractiveCallback implements
+// ssh.ServerConfig.KeyboardInteractiveCallback, asking the configured prompts,
+// a challenge per round, and deciding the answer to the password prompt like
+// for password authentication. Without a password prompt, it's decided like
+// an empty password. The answers are logged even if the client gives up
+// before answering every round.
+func (connection *Connection) KeyboardInteractiveCallback(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+	prompts := connection.cfg.Prompts
+	if len(prompts) == 0 {
+		prompts = config.DefaultPrompts
+	}
+	responses := map[string]string{}
+	var err error
+	for start := 0; start < len(prompts) && err == nil; {
+		end := start + 1
+		for end < len(prompts) && !prompts[end].Round {
+			end++
+		}
+		questions := make([]string, end-start)
+		echos := make([]bool, end-start)
+		for i, prompt := range prompts[start:end] {
+			questions[i] = prompt.Text
+			echos[i] = prompt.Echo
+		}
+		var answers []string
+		answers, err = client("", "", questions, echos)
+		for i, answer := range answers {
+			responses[prompts[start+i].Name] = answer
+		}
+		start = end
+	}
+	var decision Decision
+	switch {
+	case err != nil:
+		decision = Decision{Outcome: Reject, Reason: "unanswered prompts"}
+	case !connection.cfg.Users.Valid(conn.User()):
+		decision = Decision{Outcome: Reject, Reason: "invalid user"}
+	default:
+		decision = connection.decidePassword(conn, responses["password"], func(attempt Attempt) Decision {
+			return connection.decider.KeyboardInteractive(attempt, responses)
+		})
+	}
+	connection.LogDecision(log.Fields{
+		"client":    conn.RemoteAddr(),
+		"user":      conn.User(),
+		"responses": responses,
+		"version":   string(conn.ClientVersion()),
+	}, "Keyboard-interactive", decision)
+	if err != nil {
+		return nil, err
+	}
+	return connection.decide("keyboard-interactive", decision, "password "+responses["password"])
+}
+
+// DecidePassword decides a password authentication attempt, which is logged
+// by the caller.
+func (connection *Connection) DecidePassword(conn ssh.ConnMetadata, password string) Decision {
+	if !connection.cfg.Users.Valid(conn.User()) {
+		return Decision{Outcome: Reject, Reason: "invalid user"}
+	}
+	return connection.decidePassword(conn, password, func(attempt Attempt) Decision {
+		return connection.decider.Password(attempt, password)
+	})
+}
+
+// attempt returns what is known of conn for deciding an attempt.
+func (connection *Connection) attempt(conn ssh.ConnMetadata) Attempt {
+	return Attempt{
+		Conn:        conn,
+		OfferedKeys: len(connection.offeredKeys),
+		Failures:    connection.history.Failures(conn.RemoteAddr()),
+	}
+}
+
+// decidePassword decides an attempt with password with decide, or like the
+// last time the address of the client tried the same user and password in
+// StickyDecisions mode.
+func (connection *Connection) decidePassword(conn ssh.ConnMetadata, password string, decide func(attempt Attempt) Decision) Decision {
+	if !connection.cfg.StickyDecisions {
+		return decide(connection.attempt(conn))
+	}
+	if outcome, ok := connection.history.Decision(conn.RemoteAddr(), conn.User(), password); ok {
+		return Decision{Outcome: outcome, Reason: "sticky"}
+	}
+	decision := decide(connection.attempt(conn))
+	connection.history.RecordDecision(conn.RemoteAddr(), conn.User(), password, decision.Outcome)
+	return decision
+}
+
+// PasswordPermissions returns the result of the password callback given
+// decision.
+func (connection *Connection) PasswordPermissions(decision Decision, password string) (*ssh.Permissions, error) {
+	return connection.decide("password", decision, "password "+password)
+}
+
+// LogDecision logs the decision on an attempt with method, e.g. as
+// "Public key authentication accepted", along with the number of failed
+// attempts before it.
+func (connection *Connection) LogDecision(fields log.Fields, method string, decision Decision) {
+	fields["failed_attempts"] = connection.failed
+	if decision.Reason != "" {
+		fields["reason"] = decision.Reason
+	}
+	logger := log.WithFields(fields)
+	switch decision.Outcome {
+	case Accept:
+		logger.Info(method + " authentication accepted")
+	case Partial:
+		logger.Info(method + " authentication partially succeeded")
+	default:
+		logger.Info(method + " authentication rejected")
+	}
+	connection.logHoneytoken(fields, method, decision)
+}
+
+// logHoneytoken logs the use of a honeytoken in an attempt logged with
+// fields.
+func (connection *Connection) logHoneytoken(fields log.Fields, method string, decision Decision) {
+	user := fmt.Sprint(fields["user"])
+	var token config.Honeytoken
+	var ok bool
+	if fingerprint, isKey := fields["fingerprint"].(string); isKey {
+		token, ok = connection.cfg.Honeytokens.MatchKey(user, fingerprint)
+	} else if password, isPassword := fields["password"].(string); isPassword {
+		token, ok = connection.cfg.Honeytokens.MatchPassword(user, password)
+	} else if responses, isKeyboardInteractive := fields["responses"].(map[string]string); isKeyboardInteractive {
+		token, ok = connection.cfg.Honeytokens.MatchPassword(user, responses["password"])
+	}
+	if !ok {
+		return
+	}
+	log.WithFields(log.Fields{
+		"client":   fields["client"],
+		"user":     user,
+		"method":   strings.ToLower(method),
+		"token":    token.String(),
+		"name":     token.Name,
+		"accepted": decision.Outcome != Reject,
+		"category": "honeytoken_credential",
+	}).Warning("Honeytoken credential used")
+}
+
+// NoClientAuthCallback implements ssh.ServerConfig.NoClientAuthCallback,
+// logging attempts to authenticate with the none method, which clients and
+// scanners usually try first. They are rejected unless AcceptNone is set,
+// and always in decoy mode.
+func (connection *Connection) NoClientAuthCallback(conn ssh.ConnMetadata) (*ssh.Permissions, error) {
+	logger := log.WithFields(log.Fields{
+		"client":  conn.RemoteAddr(),
+		"user":    conn.User(),
+		"method":  "none",
+		"version": string(conn.ClientVersion()),
+	})
+	if !connection.cfg.AcceptNone || connection.cfg.Decoy || !connection.cfg.Users.Valid(conn.User()) {
+		logger.Info("None authentication rejected")
+		return nil, ErrRejected
+	}
+	logger.Info("None authentication accepted")
+	return CredentialPermissions("none"), nil
+}
+
+// AuthLogCallback implements ssh.ServerConfig.AuthLogCallback, recording
+// failed attempts for blocking and logging attempts to use methods that aren't
+// offered, which are otherwise invisible. Failed none attempts aren't
+// recorded, nearly every client starts with one, nor are steps of
+// auth_steps that passed, which x/crypto/ssh reports as partial successes.
+func (connection *Connection) AuthLogCallback(conn ssh.ConnMetadata, method string, err error) {
+	connection.attempted = true
+	connection.stepUser = conn.User()
+	connection.count(method, err)
+	if !connection.cfg.Users.Valid(conn.User()) && !connection.invalidUsers[conn.User()] {
+		connection.invalidUsers[conn.User()] = true
+		log.WithFields(log.Fields{
+			"client":  conn.RemoteAddr(),
+			"user":    conn.User(),
+			"version": string(conn.ClientVersion()),
+		}).Info("Invalid user")
+	}
+	_, partial := err.(*ssh.PartialSuccessError)
+	if err != nil && !partial && method != "none" {
+		connection.blocker.RecordFailure(conn.RemoteAddr())
+		connection.history.RecordFailure(conn.RemoteAddr())
+	}
+	if method == "none" || connection.cfg.Methods.Enabled(method) {
+		return
+	}
+	log.WithFields(log.Fields{
+		"client":  conn.RemoteAddr(),
+		"user":    conn.User(),
+		"method":  method,
+		"version": string(conn.ClientVersion()),
+	}).Info("Authentication with disabled method rejected")
+}
+
+// BannerCallback implements ssh.ServerConfig.BannerCallback, showing the
+// banner, or that of decoy mode, before the first authentication attempt.
+func (connection *Connection) BannerCallback(conn ssh.ConnMetadata) string {
+	if !connection.cfg.Decoy {
+		return connection.cfg.Banner
+	}
+	log.WithFields(log.Fields{
+		"client":  conn.RemoteAddr(),
+		"user":    conn.User(),
+		"version": string(conn.ClientVersion()),
+	}).Info("Decoy banner sent")
+	return connection.cfg.DecoyBanner
+}
+
+// Attempted reports whether the client attempted to authenticate at all. It
+// must only be called once the handshake is over.
+func (connection *Connection) Attempted() bool {
+	return connection.attempted
+}
+
+// Install sets the callbacks of the enabled methods on a per-connection copy
+// of a server configuration, and the banner.
+func (connection *Connection) Install(sshConfig *ssh.ServerConfig) {
+	connection.sshConfig = sshConfig
+	if connection.cfg.Decoy && connection.cfg.DecoyBanner != "" || !connection.cfg.Decoy && connection.cfg.Banner != "" {
+		sshConfig.BannerCallback = connection.BannerCallback
+	}
+	sshConfig.AuthLogCallback = connection.AuthLogCallback
+	sshConfig.NoClientAuth = true
+	sshConfig.NoClientAuthCallback = connection.NoClientAuthCallback
+	if connection.cfg.Methods.Enabled("publickey") {
+		sshConfig.PublicKeyCallback = connection.PublicKeyCallback
+	}
+	if connection.cfg.Methods.Enabled("keyboard-interactive") {
+		sshConfig.KeyboardInteractiveCallback = connection.KeyboardInteractiveCallback
+	}
+}
@@ -0,0 +1,245 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/longkeyy/sshesame/config"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// testConn is the metadata of a connection of user from 192.0.2.1.
+type testConn string
+
+func (conn testConn) User() string          { return string(conn) }
+func (conn testConn) SessionID() []byte     { return []byte("session") }
+func (conn testConn) ClientVersion() []byte { return []byte("SSH-2.0-OpenSSH_9.6") }
+func (conn testConn) ServerVersion() []byte { return []byte("SSH-2.0-OpenSSH_8.9p1") }
+func (conn testConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 50000}
+}
+func (conn testConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 22}
+}
+
+// newKey returns a new ed25519 public key.
+func newKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// captureLog records the entries logged for the rest of the test instead
+// of printing them.
+func captureLog(t *testing.T) *logtest.Hook {
+	hook := logtest.NewGlobal()
+	out := log.StandardLogger().Out
+	log.SetOutput(ioutil.Discard)
+	t.Cleanup(func() {
+		log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+		log.SetOutput(out)
+	})
+	return hook
+}
+
+// messages returns the messages of the entries hook recorded.
+func messages(hook *logtest.Hook) []string {
+	messages := []string{}
+	for _, entry := range hook.AllEntries() {
+		messages = append(messages, entry.Message)
+	}
+	return messages
+}
+
+func TestPublicKeyAcceptsFingerprint(t *testing.T) {
+	hook := captureLog(t)
+	accepted, other := newKey(t), newKey(t)
+	cfg := config.Auth{PublicKeyRules: config.PublicKeyRules{{Fingerprint: ssh.FingerprintSHA256(accepted), Accept: true}}}
+	connection := NewConnection(cfg, nil, nil, nil)
+	if _, err := connection.PublicKeyCallback(testConn("root"), other); err != ErrRejected {
+		t.Errorf("PublicKeyCallback of another key = %v, want %v", err, ErrRejected)
+	}
+	// Clients query a key, then sign with it, both deciding it the same.
+	for i := 0; i < 2; i++ {
+		permissions, err := connection.PublicKeyCallback(testConn("root"), accepted)
+		if err != nil {
+			t.Fatalf("PublicKeyCallback of the accepted key = %v", err)
+		}
+		if credential := permissions.Extensions[CredentialExtension]; credential != "publickey "+ssh.FingerprintSHA256(accepted) {
+			t.Errorf("credential = %q, want the key's", credential)
+		}
+	}
+	entries := hook.AllEntries()
+	if len(entries) != 2 || entries[0].Message != "Public key authentication rejected" || entries[1].Message != "Public key authentication accepted" {
+		t.Fatalf("logged %q, want each key's decision logged once", messages(hook))
+	}
+	if reason := entries[1].Data["reason"]; reason != "rule "+cfg.PublicKeyRules[0].String() {
+		t.Errorf("reason = %v, want the matched rule", reason)
+	}
+}
+
+func TestPublicKeyLoggedInFull(t *testing.T) {
+	hook := captureLog(t)
+	// The public key of RFC 8032 section 7.1, test 1.
+	public, _ := hex.DecodeString("d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a")
+	key, err := ssh.NewPublicKey(ed25519.PublicKey(public))
+	if err != nil {
+		t.Fatal(err)
+	}
+	connection := NewConnection(config.Auth{}, nil, nil, nil)
+	for i := 0; i < 2; i++ {
+		connection.PublicKeyCallback(testConn("root"), key)
+	}
+	want := map[string]interface{}{
+		"client":          "192.0.2.1:50000",
+		"user":            "root",
+		"key_type":        "ssh-ed25519",
+		"fingerprint":     "SHA256:bbXpuKG6zhzdmnxq256TlqzFBzRl2f6OOg722cYNbU8",
+		"public_key":      "AAAAC3NzaC1lZDI1NTE5AAAAINdamAGCsQq31Uv+08lkBzoO4XLz2qYjJa8CGmj3B1Ea",
+		"version":         "SSH-2.0-OpenSSH_9.6",
+		"failed_attempts": 0,
+	}
+	entries := hook.AllEntries()
+	if len(entries) != 1 {
+		t.Fatalf("logged %q, want the key once", messages(hook))
+	}
+	for key, value := range want {
+		if fmt.Sprint(entries[0].Data[key]) != fmt.Sprint(value) {
+			t.Errorf("%v = %v, want %v", key, entries[0].Data[key], value)
+		}
+	}
+}
+
+func TestPublicKeyRejectsAllKeys(t *testing.T) {
+	captureLog(t)
+	cfg := config.Auth{PublicKeyRules: config.PublicKeyRules{{Accept: false}}}
+	connection := NewConnection(cfg, nil, nil, nil)
+	for i := 0; i < 3; i++ {
+		if _, err := connection.PublicKeyCallback(testConn("root"), newKey(t)); err != ErrRejected {
+			t.Errorf("PublicKeyCallback of key %v = %v, want %v", i, err, ErrRejected)
+		}
+	}
+}
+
+func TestPublicKeyAcceptsAfterKeys(t *testing.T) {
+	captureLog(t)
+	cfg := config.Auth{PublicKeyRules: config.PublicKeyRules{{KeyType: ssh.KeyAlgoED25519, Accept: true, AfterKeys: 3}}}
+	connection := NewConnection(cfg, nil, nil, nil)
+	first := newKey(t)
+	for i, key := range []ssh.PublicKey{first, newKey(t), first} {
+		if _, err := connection.PublicKeyCallback(testConn("root"), key); err != ErrRejected {
+			t.Errorf("PublicKeyCallback of attempt %v = %v, want %v", i, err, ErrRejected)
+		}
+	}
+	if _, err := connection.PublicKeyCallback(testConn("root"), newKey(t)); err != nil {
+		t.Errorf("PublicKeyCallback of the third distinct key = %v, want it accepted", err)
+	}
+}
+
+func TestKeyboardInteractivePrompts(t *testing.T) {
+	hook := captureLog(t)
+	var prompts config.Prompts
+	for _, prompt := range []string{"login,echo=login: ", "password=Password: ", "otp,round=Verification code: "} {
+		if err := prompts.Set(prompt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	connection := NewConnection(config.Auth{Prompts: prompts}, nil, nil, nil)
+	answers := map[string]string{"login: ": "alice", "Password: ": "hunter2", "Verification code: ": "492817"}
+	type challenge struct {
+		questions []string
+		echos     []bool
+	}
+	challenges := []challenge{}
+	_, err := connection.KeyboardInteractiveCallback(testConn("root"), func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		challenges = append(challenges, challenge{questions, echos})
+		responses := make([]string, len(questions))
+		for i, question := range questions {
+			responses[i] = answers[question]
+		}
+		return responses, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []challenge{{[]string{"login: ", "Password: "}, []bool{true, false}}, {[]string{"Verification code: "}, []bool{false}}}; !reflect.DeepEqual(challenges, want) {
+		t.Errorf("asked %v, want %v", challenges, want)
+	}
+	entry := hook.LastEntry()
+	if entry == nil || entry.Message != "Keyboard-interactive authentication accepted" {
+		t.Fatalf("logged %q, want the accepted attempt", messages(hook))
+	}
+	if want := map[string]string{"login": "alice", "password": "hunter2", "otp": "492817"}; !reflect.DeepEqual(entry.Data["responses"], want) {
+		t.Errorf("responses = %v, want %v", entry.Data["responses"], want)
+	}
+}
+
+func TestKeyboardInteractivePartialAnswers(t *testing.T) {
+	hook := captureLog(t)
+	prompts := config.Prompts{{Name: "password", Text: "Password: "}, {Name: "otp", Text: "Verification code: ", Round: true}}
+	connection := NewConnection(config.Auth{Prompts: prompts}, nil, nil, nil)
+	gaveUp := errors.New("gave up")
+	rounds := 0
+	// The client answers the password, then gives up on the code.
+	_, err := connection.KeyboardInteractiveCallback(testConn("root"), func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		rounds++
+		if rounds > 1 {
+			return nil, gaveUp
+		}
+		return []string{"hunter2"}, nil
+	})
+	if err != gaveUp || rounds != 2 {
+		t.Errorf("KeyboardInteractiveCallback = %v after %v rounds, want %v after 2", err, rounds, gaveUp)
+	}
+	entry := hook.LastEntry()
+	if entry == nil || entry.Message != "Keyboard-interactive authentication rejected" || entry.Data["reason"] != "unanswered prompts" {
+		t.Fatalf("logged %q, want the rejected attempt", messages(hook))
+	}
+	if want := map[string]string{"password": "hunter2"}; !reflect.DeepEqual(entry.Data["responses"], want) {
+		t.Errorf("responses = %v, want %v", entry.Data["responses"], want)
+	}
+}
+
+func TestBanner(t *testing.T) {
+	hook := captureLog(t)
+	for _, test := range []struct {
+		cfg    config.Auth
+		banner string
+	}{
+		{config.Auth{}, ""},
+		{config.Auth{Banner: "Authorized access only\n"}, "Authorized access only\n"},
+		// Decoy mode shows its own banner, if any.
+		{config.Auth{Banner: "Authorized access only\n", Decoy: true, DecoyBanner: "Down for maintenance\n"}, "Down for maintenance\n"},
+		{config.Auth{Banner: "Authorized access only\n", Decoy: true}, ""},
+	} {
+		sshConfig := &ssh.ServerConfig{}
+		NewConnection(test.cfg, nil, nil, nil).Install(sshConfig)
+		if sshConfig.BannerCallback == nil {
+			if test.banner != "" {
+				t.Errorf("%+v showed no banner, want %q", test.cfg, test.banner)
+			}
+			continue
+		}
+		if banner := sshConfig.BannerCallback(testConn("root")); banner != test.banner {
+			t.Errorf("%+v showed %q, want %q", test.cfg, banner, test.banner)
+		}
+	}
+	if got := messages(hook); !reflect.DeepEqual(got, []string{"Decoy banner sent"}) {
+		t.Errorf("logged %q", got)
+	}
+}
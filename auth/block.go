@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"github.com/longkeyy/sshesame/config"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"sync"
+	"time"
+)
+
+// maxTrackedHosts bounds the number of addresses a Blocker tracks; once
+// reached, expired entries are swept and new addresses are ignored until some
+// expire.
+const maxTrackedHosts = 100000
+
+type hostFailures struct {
+	failures     []time.Time
+	blockedUntil time.Time
+}
+
+// Blocker temporarily blocks addresses after repeated authentication
+// failures. It is shared by every connection.
+type Blocker struct {
+	cfg   config.Auth
+	mu    sync.Mutex
+	hosts map[string]*hostFailures
+}
+
+// NewBlocker returns a Blocker using the thresholds in cfg. It never blocks if
+// cfg.BlockFailures is 0.
+func NewBlocker(cfg config.Auth) *Blocker {
+	return &Blocker{cfg: cfg, hosts: map[string]*hostFailures{}}
+}
+
+func blockKey(addr net.Addr) string {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// Blocked reports whether addr is blocked, and until when.
+func (blocker *Blocker) Blocked(addr net.Addr) (bool, time.Time) {
+	if blocker == nil || blocker.cfg.BlockFailures <= 0 {
+		return false, time.Time{}
+	}
+	blocker.mu.Lock()
+	defer blocker.mu.Unlock()
+	host, ok := blocker.hosts[blockKey(addr)]
+	if !ok || !time.Now().Before(host.blockedUntil) {
+		return false, time.Time{}
+	}
+	return true, host.blockedUntil
+}
+
+// RecordFailure records a failed authentication attempt from addr, blocking it
+// once BlockFailures attempts failed within BlockWindow.
+func (blocker *Blocker) RecordFailure(addr net.Addr) {
+	if blocker == nil || blocker.cfg.BlockFailures <= 0 {
+		return
+	}
+	now := time.Now()
+	key := blockKey(addr)
+	blocker.mu.Lock()
+	defer blocker.mu.Unlock()
+	host, ok := blocker.hosts[key]
+	if !ok {
+		if len(blocker.hosts) >= maxTrackedHosts {
+			blocker.sweep(now)
+			if len(blocker.hosts) >= maxTrackedHosts {
+				return
+			}
+		}
+		host = &hostFailures{}
+		blocker.hosts[key] = host
+	}
+	if now.Before(host.blockedUntil) {
+		return
+	}
+	host.failures = append(recentFailures(host.failures, now.Add(-blocker.cfg.BlockWindow)), now)
+	if len(host.failures) < blocker.cfg.BlockFailures {
+		return
+	}
+	host.failures = nil
+	host.blockedUntil = now.Add(blocker.cfg.BlockCooldown)
+	log.WithFields(log.Fields{
+		"client":   addr,
+		"failures": blocker.cfg.BlockFailures,
+		"window":   blocker.cfg.BlockWindow.String(),
+		"until":    host.blockedUntil.Format(time.RFC3339),
+	}).Warning("Client temporarily blocked")
+}
+
+// recentFailures drops the failures before since.
+func recentFailures(failures []time.Time, since time.Time) []time.Time {
+	for len(failures) > 0 && failures[0].Before(since) {
+		failures = failures[1:]
+	}
+	return failures
+}
+
+// sweep forgets hosts that are neither blocked nor failed recently.
+func (blocker *Blocker) sweep(now time.Time) {
+	since := now.Add(-blocker.cfg.BlockWindow)
+	for key, host := range blocker.hosts {
+		host.failures = recentFailures(host.failures, since)
+		if len(host.failures) == 0 && !now.Before(host.blockedUntil) {
+			delete(blocker.hosts, key)
+		}
+	}
+}
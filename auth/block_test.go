@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"github.com/longkeyy/sshesame/config"
+	"net"
+	"testing"
+	"time"
+)
+
+var blockedAddr = &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 50000}
+
+func TestBlockAfterFailures(t *testing.T) {
+	hook := captureLog(t)
+	const cooldown = 50 * time.Millisecond
+	blocker := NewBlocker(config.Auth{BlockFailures: 3, BlockWindow: time.Minute, BlockCooldown: cooldown})
+	for i := 0; i < 2; i++ {
+		blocker.RecordFailure(blockedAddr)
+		if blocked, _ := blocker.Blocked(blockedAddr); blocked {
+			t.Fatalf("blocked after %v failures, want 3", i+1)
+		}
+	}
+	blocker.RecordFailure(blockedAddr)
+	blocked, until := blocker.Blocked(blockedAddr)
+	if !blocked || time.Until(until) > cooldown {
+		t.Fatalf("Blocked = %v, %v after 3 failures, want blocked for %v", blocked, until, cooldown)
+	}
+	// Blocked by address, whatever the port.
+	if blocked, _ := blocker.Blocked(&net.TCPAddr{IP: blockedAddr.IP, Port: 50001}); !blocked {
+		t.Error("another port of a blocked address isn't blocked")
+	}
+	if blocked, _ := blocker.Blocked(&net.TCPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 50000}); blocked {
+		t.Error("another address is blocked")
+	}
+	if entry := hook.LastEntry(); entry == nil || entry.Message != "Client temporarily blocked" || entry.Data["failures"] != 3 {
+		t.Errorf("logged %v, want the block", entry)
+	}
+
+	time.Sleep(cooldown)
+	if blocked, _ := blocker.Blocked(blockedAddr); blocked {
+		t.Error("still blocked after the cooldown")
+	}
+	// Failures start over after a block.
+	blocker.RecordFailure(blockedAddr)
+	if blocked, _ := blocker.Blocked(blockedAddr); blocked {
+		t.Error("blocked again by a single failure after the cooldown")
+	}
+}
+
+func TestBlockWindow(t *testing.T) {
+	captureLog(t)
+	const window = 30 * time.Millisecond
+	blocker := NewBlocker(config.Auth{BlockFailures: 2, BlockWindow: window, BlockCooldown: time.Minute})
+	blocker.RecordFailure(blockedAddr)
+	time.Sleep(window)
+	blocker.RecordFailure(blockedAddr)
+	if blocked, _ := blocker.Blocked(blockedAddr); blocked {
+		t.Error("blocked by failures further apart than the window")
+	}
+	blocker.RecordFailure(blockedAddr)
+	if blocked, _ := blocker.Blocked(blockedAddr); !blocked {
+		t.Error("not blocked by failures within the window")
+	}
+}
+
+func TestBlockDisabled(t *testing.T) {
+	blocker := NewBlocker(config.Auth{BlockWindow: time.Minute, BlockCooldown: time.Minute})
+	for i := 0; i < 100; i++ {
+		blocker.RecordFailure(blockedAddr)
+	}
+	if blocked, _ := blocker.Blocked(blockedAddr); blocked {
+		t.Error("blocked with BlockFailures 0")
+	}
+	var none *Blocker
+	none.RecordFailure(blockedAddr)
+	if blocked, _ := none.Blocked(blockedAddr); blocked {
+		t.Error("nil Blocker blocked")
+	}
+}
+
+func TestBlockSweepsExpired(t *testing.T) {
+	blocker := NewBlocker(config.Auth{BlockFailures: 2, BlockWindow: time.Millisecond, BlockCooldown: time.Minute})
+	for i := 0; i < maxTrackedHosts; i++ {
+		blocker.RecordFailure(&net.TCPAddr{IP: net.IP{10, byte(i >> 16), byte(i >> 8), byte(i)}, Port: 50000})
+	}
+	time.Sleep(2 * time.Millisecond)
+	blocker.RecordFailure(blockedAddr)
+	if len(blocker.hosts) != 1 {
+		t.Errorf("tracking %v hosts, want the expired ones forgotten", len(blocker.hosts))
+	}
+}
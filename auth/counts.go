@@ -0,0 +1,45 @@
+package auth
+
+import (
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// count records an authentication attempt with method that ended with err.
+// Failures are counted toward MaxAuthTries like sshd and x/crypto/ssh do:
+// across methods, except partial successes and the first none attempt, which
+// nearly every client starts with.
+func (connection *Connection) count(method string, err error) {
+	connection.attempts[method]++
+	if err == nil {
+		return
+	}
+	if _, partial := err.(*ssh.PartialSuccessError); partial {
+		return
+	}
+	connection.failures[method]++
+	if method == "none" && connection.failed == 0 && connection.attempts[method] == 1 {
+		return
+	}
+	connection.failed++
+}
+
+// AddCounts adds the attempts and failures of the connection by method, and
+// the number of distinct public keys offered, to fields, showing how clients
+// mix methods.
+func (connection *Connection) AddCounts(fields log.Fields) {
+	if len(connection.attempts) == 0 {
+		return
+	}
+	attempts := map[string]int{}
+	for method, count := range connection.attempts {
+		attempts[method] = count
+	}
+	fields["auth_attempts"] = attempts
+	failures := map[string]int{}
+	for method, count := range connection.failures {
+		failures[method] = count
+	}
+	fields["auth_failures"] = failures
+	fields["keys_offered"] = len(connection.offeredKeys)
+}
@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"github.com/longkeyy/sshesame/config"
+	"golang.org/x/crypto/ssh"
+	"math/rand"
+)
+
+// Outcome is how an authentication attempt ends.
+type Outcome int
+
+const (
+	// Reject fails the attempt.
+	Reject Outcome = iota
+	// Accept authenticates the client.
+	Accept
+	// Partial accepts the attempt, but the client must go on authenticating
+	// with another of the enabled methods.
+	Partial
+)
+
+// Decision is the decision of an AuthDecider on an attempt.
+type Decision struct {
+	Outcome Outcome
+	// Reason, if set, explains the decision and is logged with the attempt.
+	Reason string
+}
+
+// Attempt is what is known of the connection making an authentication
+// attempt.
+type Attempt struct {
+	Conn ssh.ConnMetadata
+	// OfferedKeys is the number of distinct public keys the client offered
+	// so far, including the one being decided.
+	OfferedKeys int
+	// Failures is the number of failed attempts of the address of the client
+	// remembered, across connections.
+	Failures int
+}
+
+// AuthDecider decides authentication attempts, letting custom logic, such as
+// consulting a threat feed or time-of-day rules, be compiled in without
+// changing the callbacks, which still log attempts, record failures and apply
+// valid_users, blocking and multi-step authentication. Its methods may be
+// called from several connections at once.
+type AuthDecider interface {
+	Password(attempt Attempt, password string) Decision
+	PublicKey(attempt Attempt, key ssh.PublicKey) Decision
+	// KeyboardInteractive decides the answers to the keyboard-interactive
+	// prompts, by prompt name.
+	KeyboardInteractive(attempt Attempt, responses map[string]string) Decision
+}
+
+// ConfigDecider is the AuthDecider driven by the configured credentials and
+// public key rules.
+type ConfigDecider struct {
+	Auth config.Auth
+}
+
+// Password implements AuthDecider, applying the first matching password
+// rule, or else accepting the configured credentials, or every password if
+// there are none. The file and line of the credential matched are the reason
+// of the decision.
+func (decider ConfigDecider) Password(attempt Attempt, password string) Decision {
+	if rule, ok := decider.Auth.PasswordRules.Match(attempt.Conn.User(), password); ok {
+		decision := Decision{Outcome: Reject, Reason: "rule " + rule.String()}
+		if rule.Accept && attempt.Failures >= rule.AfterFailures && (rule.Probability == 0 || rand.Float64() < rule.Probability) {
+			decision.Outcome = Accept
+		}
+		return decision
+	}
+	credential, ok := decider.Auth.AcceptPassword(attempt.Conn.User(), password)
+	if !ok {
+		return Decision{Outcome: Reject}
+	}
+	decision := Decision{Outcome: Accept}
+	if credential.Source != "" {
+		decision.Reason = "credential " + credential.Source
+	}
+	return decision
+}
+
+// PublicKey implements AuthDecider, applying the first matching public key
+// rule. Keys not matching any rule are rejected.
+func (decider ConfigDecider) PublicKey(attempt Attempt, key ssh.PublicKey) Decision {
+	fingerprint := ssh.FingerprintSHA256(key)
+	for _, rule := range decider.Auth.PublicKeyRules {
+		if rule.KeyType != "" && rule.KeyType != key.Type() {
+			continue
+		}
+		if rule.Fingerprint != "" && rule.Fingerprint != fingerprint {
+			continue
+		}
+		decision := Decision{Outcome: Reject, Reason: "rule " + rule.String()}
+		if rule.Accept && attempt.OfferedKeys >= rule.AfterKeys {
+			decision.Outcome = Accept
+		}
+		return decision
+	}
+	return Decision{Outcome: Reject}
+}
+
+// KeyboardInteractive implements AuthDecider, deciding the answer to the
+// password prompt like a password. Without a password prompt, it's decided
+// like an empty password.
+func (decider ConfigDecider) KeyboardInteractive(attempt Attempt, responses map[string]string) Decision {
+	return decider.Password(attempt, responses["password"])
+}
+
+// DecoyDecider is the AuthDecider of decoy mode, rejecting every attempt.
+type DecoyDecider struct{}
+
+// Password implements AuthDecider.
+func (DecoyDecider) Password(attempt Attempt, password string) Decision {
+	return Decision{Outcome: Reject, Reason: "decoy"}
+}
+
+// PublicKey implements AuthDecider.
+func (DecoyDecider) PublicKey(attempt Attempt, key ssh.PublicKey) Decision {
+	return Decision{Outcome: Reject, Reason: "decoy"}
+}
+
+// KeyboardInteractive implements AuthDecider.
+func (DecoyDecider) KeyboardInteractive(attempt Attempt, responses map[string]string) Decision {
+	return Decision{Outcome: Reject, Reason: "decoy"}
+}
+
+// decide turns decision into the result of an authentication callback for
+// method, authenticating with credential if accepted. Partial success lets the
+// client continue with the other enabled methods.
+func (connection *Connection) decide(method string, decision Decision, credential string) (*ssh.Permissions, error) {
+	if connection.cfg.Decoy {
+		decision.Outcome = Reject
+	}
+	switch decision.Outcome {
+	case Accept:
+		return CredentialPermissions(credential), nil
+	case Partial:
+		next := ssh.ServerAuthCallbacks{}
+		if connection.sshConfig != nil {
+			if method != "password" {
+				next.PasswordCallback = connection.sshConfig.PasswordCallback
+			}
+			if method != "publickey" {
+				next.PublicKeyCallback = connection.sshConfig.PublicKeyCallback
+			}
+			if method != "keyboard-interactive" {
+				next.KeyboardInteractiveCallback = connection.sshConfig.KeyboardInteractiveCallback
+			}
+		}
+		return nil, &ssh.PartialSuccessError{Next: next}
+	default:
+		if connection.cfg.Decoy && connection.cfg.DecoyMessage != "" {
+			return nil, &ssh.BannerError{Err: ErrRejected, Message: connection.cfg.DecoyMessage}
+		}
+		return nil, ErrRejected
+	}
+}
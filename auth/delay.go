@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"golang.org/x/crypto/ssh"
+	"math/rand"
+	"time"
+)
+
+// Delay makes the first authentication attempt of the connection wait for
+// the configured pre-authentication delay before being answered, like sshd
+// waiting on PAM, DNS or GSSAPI before its first reply. Later attempts are
+// answered right away. Only the connection's own goroutine waits, so other
+// connections are still accepted meanwhile.
+func (connection *Connection) Delay(sshConfig *ssh.ServerConfig) {
+	if connection.cfg.PreAuthDelay <= 0 && connection.cfg.PreAuthJitter <= 0 {
+		return
+	}
+	if callback := sshConfig.NoClientAuthCallback; callback != nil {
+		sshConfig.NoClientAuthCallback = func(conn ssh.ConnMetadata) (*ssh.Permissions, error) {
+			connection.wait()
+			return callback(conn)
+		}
+	}
+	if callback := sshConfig.PasswordCallback; callback != nil {
+		sshConfig.PasswordCallback = func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			connection.wait()
+			return callback(conn, password)
+		}
+	}
+	if callback := sshConfig.PublicKeyCallback; callback != nil {
+		sshConfig.PublicKeyCallback = func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			connection.wait()
+			return callback(conn, key)
+		}
+	}
+	if callback := sshConfig.KeyboardInteractiveCallback; callback != nil {
+		sshConfig.KeyboardInteractiveCallback = func(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			connection.wait()
+			return callback(conn, client)
+		}
+	}
+}
+
+// wait sleeps for the pre-authentication delay the first time it's called.
+// Authentication callbacks of a connection are never called concurrently.
+func (connection *Connection) wait() {
+	if connection.delayed {
+		return
+	}
+	connection.delayed = true
+	duration := connection.cfg.PreAuthDelay
+	if connection.cfg.PreAuthJitter > 0 {
+		duration += time.Duration(rand.Int63n(int64(connection.cfg.PreAuthJitter)))
+	}
+	time.Sleep(duration)
+}
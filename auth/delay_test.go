@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"github.com/longkeyy/sshesame/config"
+	"golang.org/x/crypto/ssh"
+	"testing"
+	"time"
+)
+
+func TestPreAuthDelay(t *testing.T) {
+	for _, test := range []struct {
+		delay, jitter time.Duration
+	}{
+		{100 * time.Millisecond, 0},
+		{50 * time.Millisecond, 50 * time.Millisecond},
+	} {
+		connection := NewConnection(config.Auth{PreAuthDelay: test.delay, PreAuthJitter: test.jitter}, nil, nil, nil)
+		sshConfig := &ssh.ServerConfig{
+			NoClientAuthCallback: func(conn ssh.ConnMetadata) (*ssh.Permissions, error) { return nil, ErrRejected },
+			PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+				return nil, ErrRejected
+			},
+		}
+		connection.Delay(sshConfig)
+		start := time.Now()
+		sshConfig.NoClientAuthCallback(testConn("root"))
+		if waited := time.Since(start); waited < test.delay || waited > test.delay+test.jitter+time.Second {
+			t.Errorf("delay %v, jitter %v: first attempt answered after %v", test.delay, test.jitter, waited)
+		}
+		// Later attempts, whatever the method, are answered right away.
+		for _, password := range []string{"123456", "admin"} {
+			start := time.Now()
+			sshConfig.PasswordCallback(testConn("root"), []byte(password))
+			if waited := time.Since(start); waited >= test.delay {
+				t.Errorf("delay %v: later attempt answered after %v", test.delay, waited)
+			}
+		}
+	}
+}
+
+func TestPreAuthDelayDisabled(t *testing.T) {
+	sshConfig := &ssh.ServerConfig{}
+	NewConnection(config.Auth{}, nil, nil, nil).Delay(sshConfig)
+	if sshConfig.NoClientAuthCallback != nil || sshConfig.PasswordCallback != nil {
+		t.Error("Delay installed callbacks without a delay")
+	}
+}
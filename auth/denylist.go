@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Denylist holds addresses and networks whose connections are refused. It can
+// be changed while the server runs and is persisted to a file, one entry per
+// line.
+type Denylist struct {
+	path    string
+	mu      sync.RWMutex
+	entries map[string]*net.IPNet
+}
+
+// LoadDenylist returns the denylist persisted at path, which may not exist
+// yet. If path is empty, changes aren't persisted.
+func LoadDenylist(path string) (*Denylist, error) {
+	denylist := &Denylist{path: path, entries: map[string]*net.IPNet{}}
+	if path == "" {
+		return denylist, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return denylist, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		entry, network, err := parseDenylistEntry(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", line, err)
+		}
+		denylist.entries[entry] = network
+	}
+	return denylist, scanner.Err()
+}
+
+// parseDenylistEntry parses an IP address or CIDR network, returning it in
+// its canonical form.
+func parseDenylistEntry(text string) (string, *net.IPNet, error) {
+	if !strings.Contains(text, "/") {
+		ip := net.ParseIP(text)
+		if ip == nil {
+			return "", nil, fmt.Errorf("invalid address %q", text)
+		}
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		text = fmt.Sprintf("%v/%v", ip, bits)
+	}
+	_, network, err := net.ParseCIDR(text)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid network %q", text)
+	}
+	entry := network.String()
+	if ones, bits := network.Mask.Size(); ones == bits {
+		entry = network.IP.String()
+	}
+	return entry, network, nil
+}
+
+// Denied reports whether connections from addr are refused.
+func (denylist *Denylist) Denied(addr net.Addr) bool {
+	if denylist == nil {
+		return false
+	}
+	ip := net.ParseIP(blockKey(addr))
+	if ip == nil {
+		return false
+	}
+	denylist.mu.RLock()
+	defer denylist.mu.RUnlock()
+	for _, network := range denylist.entries {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add denies an address or network, returning its canonical form.
+func (denylist *Denylist) Add(text string) (string, error) {
+	entry, network, err := parseDenylistEntry(text)
+	if err != nil {
+		return "", err
+	}
+	denylist.mu.Lock()
+	defer denylist.mu.Unlock()
+	denylist.entries[entry] = network
+	return entry, denylist.save()
+}
+
+// Remove stops denying an address or network, reporting whether it was
+// denied.
+func (denylist *Denylist) Remove(text string) (bool, error) {
+	entry, _, err := parseDenylistEntry(text)
+	if err != nil {
+		return false, err
+	}
+	denylist.mu.Lock()
+	defer denylist.mu.Unlock()
+	if _, ok := denylist.entries[entry]; !ok {
+		return false, nil
+	}
+	delete(denylist.entries, entry)
+	return true, denylist.save()
+}
+
+// Entries returns the denied addresses and networks, sorted.
+func (denylist *Denylist) Entries() []string {
+	denylist.mu.RLock()
+	defer denylist.mu.RUnlock()
+	return denylist.sorted()
+}
+
+func (denylist *Denylist) sorted() []string {
+	entries := make([]string, 0, len(denylist.entries))
+	for entry := range denylist.entries {
+		entries = append(entries, entry)
+	}
+	sort.Strings(entries)
+	return entries
+}
+
+// save writes the entries to a temporary file renamed over the denylist file,
+// so that it's never left half-written.
+func (denylist *Denylist) save() error {
+	if denylist.path == "" {
+		return nil
+	}
+	temp, err := ioutil.TempFile(filepath.Dir(denylist.path), filepath.Base(denylist.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	data := strings.Join(denylist.sorted(), "\n") + "\n"
+	if _, err := temp.WriteString(data); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	return os.Rename(temp.Name(), denylist.path)
+}
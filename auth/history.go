@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// maxDecisions bounds the decisions remembered of an address, further ones
+// being decided anew every time.
+const maxDecisions = 1024
+
+// hostHistory is what is remembered of the attempts of an address.
+type hostHistory struct {
+	failures int
+	// decisions are the outcomes of the users and passwords tried, by user
+	// and password separated by a NUL.
+	decisions map[string]Outcome
+	last      time.Time
+}
+
+// History remembers the failed attempts of addresses and the decisions on
+// the passwords they tried, until HistoryWindow after their last attempt, so
+// that password rules can depend on them across connections. It is shared by
+// every connection.
+type History struct {
+	window time.Duration
+	mu     sync.Mutex
+	hosts  map[string]*hostHistory
+}
+
+// NewHistory returns a History forgetting addresses window after their last
+// attempt.
+func NewHistory(window time.Duration) *History {
+	return &History{window: window, hosts: map[string]*hostHistory{}}
+}
+
+// host returns the history of addr, nil if too many addresses are tracked.
+// history.mu must be held.
+func (history *History) host(addr net.Addr, now time.Time) *hostHistory {
+	key := blockKey(addr)
+	host, ok := history.hosts[key]
+	if ok && now.Sub(host.last) > history.window {
+		ok = false
+	}
+	if !ok {
+		if len(history.hosts) >= maxTrackedHosts {
+			history.sweep(now)
+			if len(history.hosts) >= maxTrackedHosts {
+				return nil
+			}
+		}
+		host = &hostHistory{decisions: map[string]Outcome{}}
+		history.hosts[key] = host
+	}
+	host.last = now
+	return host
+}
+
+// sweep forgets addresses that didn't attempt anything within the window.
+func (history *History) sweep(now time.Time) {
+	for key, host := range history.hosts {
+		if now.Sub(host.last) > history.window {
+			delete(history.hosts, key)
+		}
+	}
+}
+
+// RecordFailure records a failed authentication attempt from addr.
+func (history *History) RecordFailure(addr net.Addr) {
+	if history == nil {
+		return
+	}
+	history.mu.Lock()
+	defer history.mu.Unlock()
+	if host := history.host(addr, time.Now()); host != nil {
+		host.failures++
+	}
+}
+
+// Failures returns the number of failed attempts remembered of addr.
+func (history *History) Failures(addr net.Addr) int {
+	if history == nil {
+		return 0
+	}
+	history.mu.Lock()
+	defer history.mu.Unlock()
+	host, ok := history.hosts[blockKey(addr)]
+	if !ok || time.Since(host.last) > history.window {
+		return 0
+	}
+	return host.failures
+}
+
+// Decision returns the outcome remembered for user and password tried by
+// addr, if any.
+func (history *History) Decision(addr net.Addr, user, password string) (Outcome, bool) {
+	if history == nil {
+		return Reject, false
+	}
+	history.mu.Lock()
+	defer history.mu.Unlock()
+	host, ok := history.hosts[blockKey(addr)]
+	if !ok || time.Since(host.last) > history.window {
+		return Reject, false
+	}
+	outcome, ok := host.decisions[user+"\x00"+password]
+	return outcome, ok
+}
+
+// RecordDecision remembers the outcome of user and password tried by addr.
+func (history *History) RecordDecision(addr net.Addr, user, password string, outcome Outcome) {
+	if history == nil {
+		return
+	}
+	history.mu.Lock()
+	defer history.mu.Unlock()
+	if host := history.host(addr, time.Now()); host != nil && len(host.decisions) < maxDecisions {
+		host.decisions[user+"\x00"+password] = outcome
+	}
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"github.com/longkeyy/sshesame/config"
+	"net"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	const window = 50 * time.Millisecond
+	history := NewHistory(window)
+	addr := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 50000}
+	history.RecordFailure(addr)
+	// Remembered by address, whatever the port.
+	history.RecordFailure(&net.TCPAddr{IP: addr.IP, Port: 50001})
+	history.RecordDecision(addr, "root", "123456", Accept)
+	if failures := history.Failures(addr); failures != 2 {
+		t.Errorf("Failures = %v, want 2", failures)
+	}
+	if failures := history.Failures(&net.TCPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 50000}); failures != 0 {
+		t.Errorf("Failures of another address = %v, want 0", failures)
+	}
+	if outcome, ok := history.Decision(addr, "root", "123456"); !ok || outcome != Accept {
+		t.Errorf("Decision = %v, %v, want %v", outcome, ok, Accept)
+	}
+	// The user and password are told apart, whatever they contain.
+	if outcome, ok := history.Decision(addr, "root\x00123", "456"); ok {
+		t.Errorf("Decision of another user = %v", outcome)
+	}
+
+	time.Sleep(window + 10*time.Millisecond)
+	if failures := history.Failures(addr); failures != 0 {
+		t.Errorf("Failures after the window = %v, want 0", failures)
+	}
+	if outcome, ok := history.Decision(addr, "root", "123456"); ok {
+		t.Errorf("Decision after the window = %v", outcome)
+	}
+	history.RecordFailure(addr)
+	if failures := history.Failures(addr); failures != 1 {
+		t.Errorf("Failures of a forgotten address = %v, want 1", failures)
+	}
+
+	// Connections without history remember nothing.
+	var none *History
+	none.RecordFailure(addr)
+	none.RecordDecision(addr, "root", "123456", Accept)
+	if _, ok := none.Decision(addr, "root", "123456"); ok || none.Failures(addr) != 0 {
+		t.Error("nil history remembered attempts")
+	}
+}
+
+func TestPasswordRuleAfterFailures(t *testing.T) {
+	history := NewHistory(time.Hour)
+	cfg := config.Auth{PasswordRules: config.PasswordRules{
+		{User: regexp.MustCompile("^root$"), Password: regexp.MustCompile("^[0-9]+$"), Accept: true, AfterFailures: 2},
+	}}
+	connection := NewConnection(cfg, nil, history, nil)
+	for failures, want := range []Outcome{Reject, Reject, Accept} {
+		decision := connection.DecidePassword(testConn("root"), "123456")
+		if decision.Outcome != want || decision.Reason != "rule accept,user=^root$,password=^[0-9]+$,after=2" {
+			t.Errorf("after %v failures decided %+v, want %v", failures, decision, want)
+		}
+		history.RecordFailure(testConn("root").RemoteAddr())
+	}
+	// Passwords matching no rule are decided by the credentials.
+	if decision := connection.DecidePassword(testConn("root"), "hunter2"); decision.Outcome != Accept || decision.Reason != "" {
+		t.Errorf("decided %+v, want accepted as any password", decision)
+	}
+}
+
+func TestStickyDecisions(t *testing.T) {
+	history := NewHistory(time.Hour)
+	cfg := config.Auth{
+		PasswordRules:   config.PasswordRules{{Accept: true, Probability: 0.5}},
+		StickyDecisions: true,
+	}
+	first := NewConnection(cfg, nil, history, nil).DecidePassword(testConn("root"), "123456")
+	if first.Reason != "rule accept,probability=0.5" {
+		t.Errorf("first decided %+v, want by the rule", first)
+	}
+	// Later connections of the address decide the same, however random.
+	for i := 0; i < 20; i++ {
+		decision := NewConnection(cfg, nil, history, nil).DecidePassword(testConn("root"), "123456")
+		if decision != (Decision{Outcome: first.Outcome, Reason: "sticky"}) {
+			t.Fatalf("decided %+v, then %+v", first, decision)
+		}
+	}
+}
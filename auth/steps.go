@@ -0,0 +1,91 @@
+package auth
+
+import (
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"net"
+)
+
+// Chain makes clients authenticate with every method of steps in turn, like
+// OpenSSH servers with AuthenticationMethods set, using the callbacks already
+// installed on sshConfig to decide each step. Steps before the last end in
+// partial success, so the credentials of every one of them are logged.
+func (connection *Connection) Chain(sshConfig *ssh.ServerConfig, steps []string) {
+	callbacks := ssh.ServerAuthCallbacks{
+		PasswordCallback:            sshConfig.PasswordCallback,
+		PublicKeyCallback:           sshConfig.PublicKeyCallback,
+		KeyboardInteractiveCallback: sshConfig.KeyboardInteractiveCallback,
+	}
+	next := ssh.ServerAuthCallbacks{}
+	for i := len(steps) - 1; i >= 0; i-- {
+		next = connection.step(callbacks, steps, i, next)
+	}
+	sshConfig.PasswordCallback = next.PasswordCallback
+	sshConfig.PublicKeyCallback = next.PublicKeyCallback
+	sshConfig.KeyboardInteractiveCallback = next.KeyboardInteractiveCallback
+	connection.steps = len(steps)
+}
+
+// step returns the callbacks of step i, only offering its method and
+// continuing with next if it isn't the last one.
+func (connection *Connection) step(callbacks ssh.ServerAuthCallbacks, steps []string, i int, next ssh.ServerAuthCallbacks) ssh.ServerAuthCallbacks {
+	method := steps[i]
+	decide := func(conn ssh.ConnMetadata, permissions *ssh.Permissions, err error) (*ssh.Permissions, error) {
+		if err != nil {
+			return nil, err
+		}
+		connection.stepsPassed = i + 1
+		final := i == len(steps)-1
+		log.WithFields(log.Fields{
+			"client":          conn.RemoteAddr(),
+			"user":            conn.User(),
+			"method":          method,
+			"step":            i + 1,
+			"steps":           len(steps),
+			"partial_success": !final,
+		}).Info("Authentication step passed")
+		if final {
+			return permissions, nil
+		}
+		return nil, &ssh.PartialSuccessError{Next: next}
+	}
+	step := ssh.ServerAuthCallbacks{}
+	switch method {
+	case "password":
+		step.PasswordCallback = func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			permissions, err := callbacks.PasswordCallback(conn, password)
+			return decide(conn, permissions, err)
+		}
+	case "publickey":
+		step.PublicKeyCallback = func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			permissions, err := callbacks.PublicKeyCallback(conn, key)
+			return decide(conn, permissions, err)
+		}
+	case "keyboard-interactive":
+		step.KeyboardInteractiveCallback = func(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			permissions, err := callbacks.KeyboardInteractiveCallback(conn, client)
+			return decide(conn, permissions, err)
+		}
+	}
+	return step
+}
+
+// LogSteps logs whether the client from remoteAddr, if it had to authenticate
+// in several steps, completed all of them, given the error of the connection's
+// handshake.
+func (connection *Connection) LogSteps(remoteAddr net.Addr, err error) {
+	if connection.steps == 0 {
+		return
+	}
+	logger := log.WithFields(log.Fields{
+		"client":       remoteAddr,
+		"user":         connection.stepUser,
+		"steps":        connection.steps,
+		"steps_passed": connection.stepsPassed,
+	})
+	if err != nil {
+		logger.Info("Multi-step authentication failed")
+		return
+	}
+	logger.Info("Multi-step authentication succeeded")
+}
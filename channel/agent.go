@@ -0,0 +1,29 @@
+package channel
+
+import (
+	"github.com/longkeyy/sshesame/session"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"io"
+)
+
+// serveFakeAgent speaks the agent protocol over an auth-agent@openssh.com
+// channel of a client answered with a fake agent, until it closes the
+// channel.
+func serveFakeAgent(sess *session.Session, newChannel ssh.NewChannel, fakeAgent agent.ExtendedAgent) {
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		log.Warning("Failed to accept channel:", err.Error())
+		return
+	}
+	defer channel.Close()
+	go ssh.DiscardRequests(requests)
+	log.WithFields(log.Fields{
+		"client":  sess.RemoteAddr,
+		"channel": newChannel.ChannelType(),
+	}).Info("Fake agent channel opened")
+	if err := agent.ServeAgent(fakeAgent, channel); err != nil && err != io.EOF {
+		log.Warning("Failed to serve fake agent:", err.Error())
+	}
+}
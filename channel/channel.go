@@ -1,11 +1,18 @@
 package channel
 
 import (
+	"bufio"
+	"context"
 	"fmt"
-	log "github.com/sirupsen/logrus"
+	"github.com/longkeyy/sshesame/config"
+	"github.com/longkeyy/sshesame/forward"
 	"github.com/longkeyy/sshesame/request"
+	"github.com/longkeyy/sshesame/session"
+	"github.com/longkeyy/sshesame/shadow"
+	"github.com/longkeyy/sshesame/shell"
+	"github.com/longkeyy/sshesame/tracing"
+	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
-	"golang.org/x/crypto/ssh/terminal"
 	"io"
 	"net"
 	"strconv"
@@ -34,14 +41,19 @@ func (payload tcpip) String() string {
 		net.JoinHostPort(payload.DestinationAddress, strconv.Itoa(int(payload.DestinationPort))))
 }
 
-func Handle(remoteAddr net.Addr, newChannel ssh.NewChannel) {
+func Handle(sess *session.Session, cfg *config.Config, limits *Limits, newChannel ssh.NewChannel) {
+	if cfg.Limits.MaxPayloadSize > 0 && len(newChannel.ExtraData()) > cfg.Limits.MaxPayloadSize {
+		rejectMalformed(sess, newChannel, fmt.Errorf("payload of %v bytes exceeds the limit of %v", len(newChannel.ExtraData()), cfg.Limits.MaxPayloadSize))
+		return
+	}
 	var payload interface{} = newChannel.ExtraData()
+	var parseErr error
 	switch newChannel.ChannelType() {
 	case "x11":
 		parsedPayload := x11{}
 		err := ssh.Unmarshal(newChannel.ExtraData(), &parsedPayload)
 		if err != nil {
-			log.Warning("Failed to parse payload:", err.Error())
+			parseErr = err
 			break
 		}
 		payload = parsedPayload
@@ -53,53 +65,152 @@ func Handle(remoteAddr net.Addr, newChannel ssh.NewChannel) {
 		parsedPayload := tcpip{}
 		err := ssh.Unmarshal(newChannel.ExtraData(), &parsedPayload)
 		if err != nil {
-			log.Warning("Failed to parse payload:", err.Error())
+			parseErr = err
 			break
 		}
 		payload = parsedPayload
 	}
+	if parseErr != nil {
+		rejectMalformed(sess, newChannel, parseErr)
+		return
+	}
+	if fakeAgent := sess.Agent(); fakeAgent != nil && newChannel.ChannelType() == "auth-agent@openssh.com" {
+		serveFakeAgent(sess, newChannel, fakeAgent)
+		return
+	}
+	if newChannel.ChannelType() == "auth-agent@openssh.com" {
+		// Agent channels are opened by servers to clients that forwarded
+		// their agent, never the other way round.
+		log.WithFields(log.Fields{
+			"client":  sess.RemoteAddr,
+			"channel": newChannel.ChannelType(),
+		}).Warning("Agent channel from client rejected")
+		if err := newChannel.Reject(ssh.Prohibited, "agent channels are only opened by servers"); err != nil {
+			log.Warning("Failed to reject channel:", err.Error())
+		}
+		return
+	}
 	log.WithFields(log.Fields{
-		"client":  remoteAddr,
+		"client":  sess.RemoteAddr,
 		"channel": newChannel.ChannelType(),
 		"payload": payload,
 	}).Info("Channel requested")
+	if tcpipPayload, ok := payload.(tcpip); ok && newChannel.ChannelType() == "direct-tcpip" && cfg.Jump.SSHPort(tcpipPayload.DestinationPort) {
+		if handleJump(sess, cfg.Jump, newChannel, tcpipPayload) {
+			return
+		}
+	}
 	channel, channelRequests, err := newChannel.Accept()
 	if err != nil {
 		log.Warning("Failed to accept channel:", err.Error())
 		return
 	}
+	channel, channelRequests = limits.wrap(newChannel.ChannelType(), channel, channelRequests)
 	defer channel.Close()
-	go request.Handle(remoteAddr, newChannel.ChannelType(), channelRequests)
+	span := sess.Span.Child("channel", map[string]string{"channel": newChannel.ChannelType()})
+	defer span.End()
+	if newChannel.ChannelType() == "session" && cfg.Shadow.Backend != "" {
+		backend, err := shadow.Dial(cfg.Shadow)
+		if err == nil {
+			defer backend.Close()
+			if err := backend.Relay(sess, channel, channelRequests); err != nil {
+				log.Warning("Failed to shadow session:", err.Error())
+			}
+			return
+		}
+		log.Warning("Failed to connect to shadow backend, emulating session:", err.Error())
+	}
+	idle := newIdleTimeout(sess, newChannel.ChannelType(), channel, cfg.Limits.ChannelIdleTimeout)
+	defer idle.stop()
 	if newChannel.ChannelType() == "session" {
-		terminal := terminal.NewTerminal(channel, "$ ")
-		for {
-			line, err := terminal.ReadLine()
+		programs := make(chan request.Program, 1)
+		go request.Handle(sess, cfg, newChannel.ChannelType(), idle.watch(channelRequests), programs)
+		program, ok := <-programs
+		// Programs only end once the client is done with them.
+		idle.stop()
+		if !ok {
+			return
+		}
+		if program.Subsystem != "" {
+			fields := log.Fields{
+				"client":    sess.RemoteAddr,
+				"channel":   newChannel.ChannelType(),
+				"subsystem": program.Subsystem,
+			}
+			if program.Type == "exec" {
+				fields["command"] = program.Name
+				log.WithFields(fields).Info("File transfer command routed to subsystem")
+			}
+			status, err := request.ServeSubsystem(sess, program, channel, cfg)
 			if err != nil {
-				if err == io.EOF {
-					log.WithFields(log.Fields{
-						"client":  remoteAddr,
-						"channel": newChannel.ChannelType(),
-					}).Info("Terminal closed")
-					request.SendExitStatus(channel)
-				} else {
-					log.Warning("Failed to read from terminal:", err.Error())
-				}
-				break
+				log.Warning("Failed to serve subsystem:", err.Error())
+				return
 			}
+			log.WithFields(fields).Info("Subsystem closed")
+			request.SendExitStatus(channel, status)
+			return
+		}
+		span.SetAttribute("program", program.Type)
+		ctx, cancel := sess.Context(tracing.ContextWithSpan(context.Background(), span))
+		defer cancel()
+		if program.Type == "exec" {
+			exit, err := shell.Exec(ctx, sess, cfg.Shell, program.Name, channel)
+			if err != nil {
+				log.Warning("Failed to execute command:", err.Error())
+				return
+			}
+			if exit.Signal == "" {
+				request.SendExitStatus(channel, exit.Status)
+				return
+			}
+			log.WithFields(log.Fields{
+				"client":        sess.RemoteAddr,
+				"channel":       newChannel.ChannelType(),
+				"signal":        exit.Signal,
+				"core_dumped":   exit.CoreDumped,
+				"error_message": exit.ErrorMessage,
+			}).Info("Exit signal sent")
+			request.SendExitSignal(channel, exit.Signal, exit.CoreDumped, exit.ErrorMessage)
+			return
+		}
+		err := shell.Run(ctx, sess, cfg.Shell, channel)
+		if err == nil || err == io.EOF {
 			log.WithFields(log.Fields{
-				"client":  remoteAddr,
+				"client":  sess.RemoteAddr,
 				"channel": newChannel.ChannelType(),
-				"line":    line,
-			}).Info("Channel input received")
+			}).Info("Terminal closed")
+			request.SendExitStatus(channel, 0)
+		} else {
+			log.Warning("Failed to read from terminal:", err.Error())
 		}
 	} else {
+		go request.Handle(sess, cfg, newChannel.ChannelType(), idle.watch(channelRequests), nil)
+		var input io.Reader = channel
+		if tcpipPayload, ok := payload.(tcpip); ok && newChannel.ChannelType() == "direct-tcpip" && cfg.Forwarding.Sniff {
+			sniffed := bufio.NewReader(idleReader{channel, idle})
+			destination := net.JoinHostPort(tcpipPayload.DestinationAddress, strconv.Itoa(int(tcpipPayload.DestinationPort)))
+			handled, err := forward.Sniff(sess.RemoteAddr, destination, tcpipPayload.DestinationPort, sniffed, channel)
+			if err != nil {
+				log.Warning("Failed to sniff channel:", err.Error())
+				return
+			}
+			if handled {
+				log.WithFields(log.Fields{
+					"client":  sess.RemoteAddr,
+					"channel": newChannel.ChannelType(),
+				}).Info("Channel closed")
+				return
+			}
+			input = sniffed
+		}
 		data := make([]byte, 256)
 		for {
-			length, err := channel.Read(data)
+			length, err := input.Read(data)
+			idle.reset()
 			if err != nil {
 				if err == io.EOF {
 					log.WithFields(log.Fields{
-						"client":  remoteAddr,
+						"client":  sess.RemoteAddr,
 						"channel": newChannel.ChannelType(),
 					}).Info("Channel closed")
 				} else {
@@ -108,10 +219,24 @@ func Handle(remoteAddr net.Addr, newChannel ssh.NewChannel) {
 				break
 			}
 			log.WithFields(log.Fields{
-				"client":  remoteAddr,
+				"client":  sess.RemoteAddr,
 				"channel": newChannel.ChannelType(),
 				"data":    string(data[:length]),
 			}).Info("Channel input received")
 		}
 	}
 }
+
+// rejectMalformed logs and rejects a channel whose extra data is too large or
+// can't be parsed.
+func rejectMalformed(sess *session.Session, newChannel ssh.NewChannel, err error) {
+	log.WithFields(log.Fields{
+		"client":       sess.RemoteAddr,
+		"channel":      newChannel.ChannelType(),
+		"payload_size": len(newChannel.ExtraData()),
+		"error":        err.Error(),
+	}).Warning("Malformed channel request rejected")
+	if err := newChannel.Reject(ssh.ConnectionFailed, "malformed request"); err != nil {
+		log.Warning("Failed to reject channel:", err.Error())
+	}
+}
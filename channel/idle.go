@@ -0,0 +1,102 @@
+package channel
+
+import (
+	"github.com/longkeyy/sshesame/session"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"sync"
+	"time"
+)
+
+// idleTimeout closes a channel that receives neither requests nor data for a
+// while, so that clients opening channels and leaving them idle don't tie up
+// their goroutines. A nil idleTimeout never closes anything.
+type idleTimeout struct {
+	timeout time.Duration
+
+	mu    sync.Mutex
+	timer *time.Timer
+	// stopped is set once the channel doesn't time out anymore, either
+	// because it did or because it started doing something.
+	stopped bool
+}
+
+// newIdleTimeout returns an idleTimeout closing channel after timeout, or nil
+// if timeout is 0.
+func newIdleTimeout(sess *session.Session, channelType string, channel ssh.Channel, timeout time.Duration) *idleTimeout {
+	if timeout <= 0 {
+		return nil
+	}
+	idle := &idleTimeout{timeout: timeout}
+	idle.timer = time.AfterFunc(timeout, func() {
+		idle.mu.Lock()
+		stopped := idle.stopped
+		idle.stopped = true
+		idle.mu.Unlock()
+		if stopped {
+			return
+		}
+		log.WithFields(log.Fields{
+			"client":   sess.RemoteAddr,
+			"channel":  channelType,
+			"timeout":  timeout.String(),
+			"category": "channel_idle_timeout",
+		}).Info("Idle channel closed")
+		if err := channel.Close(); err != nil {
+			log.Warning("Failed to close idle channel:", err.Error())
+		}
+	})
+	return idle
+}
+
+// reset restarts the timeout, as the channel just received something.
+func (idle *idleTimeout) reset() {
+	if idle == nil {
+		return
+	}
+	idle.mu.Lock()
+	defer idle.mu.Unlock()
+	if !idle.stopped {
+		idle.timer.Reset(idle.timeout)
+	}
+}
+
+// stop keeps the channel from timing out from now on.
+func (idle *idleTimeout) stop() {
+	if idle == nil {
+		return
+	}
+	idle.mu.Lock()
+	defer idle.mu.Unlock()
+	idle.stopped = true
+	idle.timer.Stop()
+}
+
+// watch returns requests, restarting the timeout whenever one is received.
+func (idle *idleTimeout) watch(requests <-chan *ssh.Request) <-chan *ssh.Request {
+	if idle == nil {
+		return requests
+	}
+	watched := make(chan *ssh.Request)
+	go func() {
+		defer close(watched)
+		for request := range requests {
+			idle.reset()
+			watched <- request
+		}
+	}()
+	return watched
+}
+
+// idleReader restarts the timeout of its channel whenever it reads data.
+type idleReader struct {
+	io.Reader
+	idle *idleTimeout
+}
+
+func (reader idleReader) Read(data []byte) (int, error) {
+	length, err := reader.Reader.Read(data)
+	reader.idle.reset()
+	return length, err
+}
@@ -0,0 +1,108 @@
+package channel_test
+
+import (
+	"github.com/longkeyy/sshesame/config"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+// directTCPIP is the payload of a direct-tcpip channel to 198.51.100.1:80.
+var directTCPIP = ssh.Marshal(struct {
+	DestinationAddress string
+	DestinationPort    uint32
+	SourceAddress      string
+	SourcePort         uint32
+}{"198.51.100.1", 80, "127.0.0.1", 50000})
+
+func TestIdleChannelClosed(t *testing.T) {
+	hook := captureLog(t)
+	for _, test := range []struct {
+		channelType string
+		payload     []byte
+	}{
+		{"session", nil},
+		{"direct-tcpip", directTCPIP},
+	} {
+		hook.Reset()
+		client := dial(t, config.Limits{ChannelIdleTimeout: 100 * time.Millisecond})
+		start := time.Now()
+		channel, requests, err := client.OpenChannel(test.channelType, test.payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go ssh.DiscardRequests(requests)
+		if _, err := channel.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("%v: read = %v, want the channel closed", test.channelType, err)
+		}
+		if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+			t.Errorf("%v: closed after %v, before the timeout", test.channelType, elapsed)
+		}
+		entry := waitFor(t, hook, "Idle channel closed")
+		if entry.Data["channel"] != test.channelType || entry.Data["category"] != "channel_idle_timeout" || entry.Data["timeout"] != "100ms" {
+			t.Errorf("%v: logged %v", test.channelType, entry.Data)
+		}
+	}
+}
+
+func TestIdleChannelKeptOpenByShell(t *testing.T) {
+	hook := captureLog(t)
+	client := dial(t, config.Limits{ChannelIdleTimeout: 100 * time.Millisecond})
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := &lockedBuffer{}
+	session.Stdout = stdout
+	session.Stderr = ioutil.Discard
+	if err := session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+	// A shell waiting for its input is in use, however long it waits.
+	time.Sleep(300 * time.Millisecond)
+	io.WriteString(stdin, "echo hello\n")
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(stdout.String(), "hello") {
+		if time.Now().After(deadline) {
+			t.Fatalf("shell wrote %q, want it still running", stdout.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Idle channel closed" {
+			t.Error("closed the channel of a running shell")
+		}
+	}
+}
+
+func TestIdleChannelKeptOpenByData(t *testing.T) {
+	hook := captureLog(t)
+	client := dial(t, config.Limits{ChannelIdleTimeout: 100 * time.Millisecond})
+	channel, requests, err := client.OpenChannel("direct-tcpip", directTCPIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ssh.DiscardRequests(requests)
+	// A channel in the middle of a transfer stays open.
+	for i := 0; i < 6; i++ {
+		if _, err := io.WriteString(channel, "GET / HTTP/1.1\r\n"); err != nil {
+			t.Fatalf("write %v = %v, want the channel open", i, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Idle channel closed" {
+			t.Fatal("closed a channel receiving data")
+		}
+	}
+	// Once the data stops, it times out.
+	waitFor(t, hook, "Idle channel closed")
+}
@@ -0,0 +1,44 @@
+package channel
+
+import (
+	"github.com/longkeyy/sshesame/config"
+	"github.com/longkeyy/sshesame/session"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"strconv"
+)
+
+// handleJump logs a direct-tcpip channel to an SSH port, as clients using the
+// host as a jump host open, and serves another fake host over it if jumps are
+// emulated. It reports whether it handled the channel.
+func handleJump(sess *session.Session, cfg config.Jump, newChannel ssh.NewChannel, payload tcpip) bool {
+	target := net.JoinHostPort(payload.DestinationAddress, strconv.Itoa(int(payload.DestinationPort)))
+	fields := log.Fields{
+		"client":     sess.RemoteAddr,
+		"target":     target,
+		"jump_depth": sess.Depth,
+		"category":   "jump_attempt",
+	}
+	log.WithFields(fields).Info("Jump host connection requested")
+	if !cfg.Emulate || sess.Jumper == nil {
+		return false
+	}
+	if sess.Depth >= cfg.MaxDepth {
+		fields["max_depth"] = cfg.MaxDepth
+		log.WithFields(fields).Info("Jump host depth limit reached")
+		if err := newChannel.Reject(ssh.ConnectionFailed, "Connection refused"); err != nil {
+			log.Warning("Failed to reject channel:", err.Error())
+		}
+		return true
+	}
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		log.Warning("Failed to accept channel:", err.Error())
+		return true
+	}
+	defer channel.Close()
+	go ssh.DiscardRequests(requests)
+	sess.Jumper.Jump(sess, channel, target)
+	return true
+}
@@ -0,0 +1,206 @@
+package channel
+
+import (
+	"errors"
+	"github.com/longkeyy/sshesame/config"
+	"github.com/longkeyy/sshesame/request"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errChannelBytesExceeded = errors.New("channel data limit exceeded")
+
+// Limits disconnects a connection once its session exceeds a limit: its
+// duration, how long its channels go without receiving anything, how many
+// bytes one of them reads or how many it opens. x/crypto/ssh can't send an
+// SSH_MSG_DISCONNECT once keys are exchanged, so the disconnect message of
+// the limit is written to the stderr of the open session channels instead,
+// where clients show it, and every open channel is ended, sessions with a
+// TERM exit signal, before the connection is closed. A nil Limits enforces
+// nothing.
+type Limits struct {
+	conn        ssh.Conn
+	remoteAddr  net.Addr
+	limits      config.Limits
+	disconnects config.Disconnects
+
+	mu       sync.Mutex
+	channels int
+	// open are the types of the open channels, ended before the connection
+	// is closed.
+	open     map[ssh.Channel]string
+	idle     *time.Timer
+	duration *time.Timer
+	exceeded bool
+}
+
+// NewLimits returns the limits of the session of conn, or nil if all of
+// limits are disabled.
+func NewLimits(conn ssh.Conn, remoteAddr net.Addr, limits config.Limits, disconnects config.Disconnects) *Limits {
+	if limits.MaxSessionDuration <= 0 && limits.SessionIdleTimeout <= 0 && limits.MaxChannelBytes <= 0 && limits.MaxChannelsPerConnection <= 0 {
+		return nil
+	}
+	sessionLimits := &Limits{
+		conn:        conn,
+		remoteAddr:  remoteAddr,
+		limits:      limits,
+		disconnects: disconnects,
+		open:        map[ssh.Channel]string{},
+	}
+	if limits.MaxSessionDuration > 0 {
+		sessionLimits.duration = time.AfterFunc(limits.MaxSessionDuration, func() {
+			sessionLimits.exceed("session_duration", limits.MaxSessionDuration.String())
+		})
+	}
+	if limits.SessionIdleTimeout > 0 {
+		sessionLimits.idle = time.AfterFunc(limits.SessionIdleTimeout, func() {
+			sessionLimits.exceed("session_idle", limits.SessionIdleTimeout.String())
+		})
+	}
+	return sessionLimits
+}
+
+// OpenChannel counts a channel the client opens, reporting whether it's
+// within MaxChannelsPerConnection. The connection is disconnected if not.
+func (limits *Limits) OpenChannel() bool {
+	if limits == nil {
+		return true
+	}
+	limits.touch()
+	limits.mu.Lock()
+	limits.channels++
+	channels := limits.channels
+	limits.mu.Unlock()
+	if limits.limits.MaxChannelsPerConnection <= 0 || channels <= limits.limits.MaxChannelsPerConnection {
+		return true
+	}
+	limits.exceed("max_channels", limits.limits.MaxChannelsPerConnection)
+	return false
+}
+
+// Stop stops the timeouts once the connection is closed.
+func (limits *Limits) Stop() {
+	if limits == nil {
+		return
+	}
+	limits.mu.Lock()
+	defer limits.mu.Unlock()
+	limits.exceeded = true
+	if limits.duration != nil {
+		limits.duration.Stop()
+	}
+	if limits.idle != nil {
+		limits.idle.Stop()
+	}
+}
+
+// touch restarts the idle timeout, as a channel just received something.
+func (limits *Limits) touch() {
+	if limits == nil || limits.idle == nil {
+		return
+	}
+	limits.mu.Lock()
+	defer limits.mu.Unlock()
+	if !limits.exceeded {
+		limits.idle.Reset(limits.limits.SessionIdleTimeout)
+	}
+}
+
+// exceed logs that the session exceeded the limit of reason, tells its
+// session channels, ends its open channels and closes the connection, once.
+func (limits *Limits) exceed(reason string, limit interface{}) {
+	limits.mu.Lock()
+	if limits.exceeded {
+		limits.mu.Unlock()
+		return
+	}
+	limits.exceeded = true
+	open := make(map[ssh.Channel]string, len(limits.open))
+	for channel, channelType := range limits.open {
+		open[channel] = channelType
+	}
+	limits.mu.Unlock()
+	disconnect := limits.disconnects[reason]
+	log.WithFields(log.Fields{
+		"client":   limits.remoteAddr,
+		"reason":   reason,
+		"limit":    limit,
+		"code":     disconnect.Code,
+		"message":  disconnect.Message,
+		"category": "session_limit",
+	}).Warning("Session limit exceeded")
+	for channel, channelType := range open {
+		if channelType == "session" {
+			channel.Stderr().Write([]byte(disconnect.Message + "\r\n"))
+			request.SendExitSignal(channel, "TERM", false, "")
+		}
+		channel.CloseWrite()
+	}
+	if err := limits.conn.Close(); err != nil {
+		log.Warning("Failed to close connection:", err.Error())
+	}
+}
+
+// wrap returns channel, counting the data it reads and the requests it
+// receives against the limits. Requests still received once it's closed are
+// rejected.
+func (limits *Limits) wrap(channelType string, channel ssh.Channel, requests <-chan *ssh.Request) (ssh.Channel, <-chan *ssh.Request) {
+	if limits == nil {
+		return channel, requests
+	}
+	limits.mu.Lock()
+	limits.open[channel] = channelType
+	limits.mu.Unlock()
+	limited := &limitedChannel{Channel: channel, limits: limits, closed: make(chan struct{})}
+	watched := make(chan *ssh.Request)
+	go func() {
+		defer close(watched)
+		for req := range requests {
+			limits.touch()
+			select {
+			case watched <- req:
+			case <-limited.closed:
+				if req.WantReply {
+					req.Reply(false, nil)
+				}
+			}
+		}
+	}()
+	return limited, watched
+}
+
+// limitedChannel reads from a channel within the limits of its session.
+type limitedChannel struct {
+	ssh.Channel
+	limits *Limits
+	bytes  int64
+	// closed is closed once the channel is.
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (channel *limitedChannel) Read(data []byte) (int, error) {
+	length, err := channel.Channel.Read(data)
+	if length == 0 {
+		return length, err
+	}
+	channel.limits.touch()
+	maxBytes := channel.limits.limits.MaxChannelBytes
+	if total := atomic.AddInt64(&channel.bytes, int64(length)); maxBytes > 0 && total > maxBytes {
+		channel.limits.exceed("channel_bytes", maxBytes)
+		return length, errChannelBytesExceeded
+	}
+	return length, err
+}
+
+func (channel *limitedChannel) Close() error {
+	channel.limits.mu.Lock()
+	delete(channel.limits.open, channel.Channel)
+	channel.limits.mu.Unlock()
+	channel.closeOnce.Do(func() { close(channel.closed) })
+	return channel.Channel.Close()
+}
@@ -0,0 +1,170 @@
+package channel_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"github.com/longkeyy/sshesame/channel"
+	"github.com/longkeyy/sshesame/config"
+	"github.com/longkeyy/sshesame/honeypot"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// captureLog records the entries logged for the rest of the test instead
+// of printing them.
+func captureLog(t *testing.T) *logtest.Hook {
+	hook := logtest.NewGlobal()
+	out := log.StandardLogger().Out
+	log.SetOutput(ioutil.Discard)
+	t.Cleanup(func() {
+		log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+		log.SetOutput(out)
+	})
+	return hook
+}
+
+// waitFor returns the first entry logged with message, waiting for the
+// server to log it.
+func waitFor(t *testing.T, hook *logtest.Hook, message string) *log.Entry {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		for _, entry := range hook.AllEntries() {
+			if entry.Message == message {
+				return entry
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%q wasn't logged", message)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// dial logs in as root to a new honeypot enforcing limits, closing the
+// connection at the end of the test and waiting for the honeypot to be done
+// with it.
+func dial(t *testing.T, limits config.Limits) *ssh.Client {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromSigner(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshConfig := &ssh.ServerConfig{ServerVersion: "SSH-2.0-OpenSSH_8.9p1"}
+	sshConfig.AddHostKey(signer)
+	cfg := &config.Config{Auth: config.Auth{Methods: config.Methods{"password"}}, Limits: limits, Disconnects: config.DefaultDisconnects()}
+	server := honeypot.NewServer(cfg, sshConfig)
+	clientEnd, serverEnd := honeypot.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.HandleConn(serverEnd)
+	}()
+	t.Cleanup(func() {
+		clientEnd.Close()
+		<-done
+	})
+	conn, channels, requests, err := ssh.NewClientConn(clientEnd, serverEnd.LocalAddr().String(), &ssh.ClientConfig{
+		User:            "root",
+		Auth:            []ssh.AuthMethod{ssh.Password("password")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ssh.NewClient(conn, channels, requests)
+}
+
+// lockedBuffer is a buffer written to by the SSH client while the test reads
+// it.
+type lockedBuffer struct {
+	mu     sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (buffer *lockedBuffer) Write(data []byte) (int, error) {
+	buffer.mu.Lock()
+	defer buffer.mu.Unlock()
+	return buffer.buffer.Write(data)
+}
+
+func (buffer *lockedBuffer) String() string {
+	buffer.mu.Lock()
+	defer buffer.mu.Unlock()
+	return buffer.buffer.String()
+}
+
+func TestSessionLimitDisconnects(t *testing.T) {
+	hook := captureLog(t)
+	for _, test := range []struct {
+		reason, message string
+		limits          config.Limits
+		exceed          func(client *ssh.Client, stdin io.Writer)
+	}{
+		{"session_duration", "Session time limit exceeded", config.Limits{MaxSessionDuration: 100 * time.Millisecond}, func(client *ssh.Client, stdin io.Writer) {}},
+		{"session_idle", "Timeout, session idle", config.Limits{SessionIdleTimeout: 100 * time.Millisecond}, func(client *ssh.Client, stdin io.Writer) {}},
+		{"channel_bytes", "Channel data limit exceeded", config.Limits{MaxChannelBytes: 16}, func(client *ssh.Client, stdin io.Writer) {
+			io.WriteString(stdin, strings.Repeat("echo hello\n", 4))
+		}},
+		{"max_channels", "Too many channels", config.Limits{MaxChannelsPerConnection: 1}, func(client *ssh.Client, stdin io.Writer) {
+			client.NewSession()
+		}},
+	} {
+		hook.Reset()
+		client := dial(t, test.limits)
+		session, err := client.NewSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The shell runs until the limit is exceeded, as its input is left
+		// open.
+		stdin, err := session.StdinPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stderr := &lockedBuffer{}
+		session.Stderr = stderr
+		session.Stdout = ioutil.Discard
+		if err := session.Shell(); err != nil {
+			t.Fatal(err)
+		}
+		test.exceed(client, stdin)
+		err = session.Wait()
+		if exit, ok := err.(*ssh.ExitError); !ok || exit.Signal() != "TERM" {
+			t.Errorf("%v: shell ended with %v, want a TERM signal", test.reason, err)
+		}
+		if stderr.String() != test.message+"\r\n" {
+			t.Errorf("%v: stderr = %q, want %q", test.reason, stderr.String(), test.message)
+		}
+		entry := waitFor(t, hook, "Session limit exceeded")
+		if entry.Data["reason"] != test.reason || entry.Data["code"] != uint32(11) || entry.Data["message"] != test.message {
+			t.Errorf("%v: logged %v, want what was sent", test.reason, entry.Data)
+		}
+	}
+}
+
+func TestLimitsDisabled(t *testing.T) {
+	var limits *channel.Limits
+	if limits = channel.NewLimits(nil, nil, config.Limits{MaxConnectionsPerIP: 1}, config.DefaultDisconnects()); limits != nil {
+		t.Error("limits enforced without a session limit")
+	}
+	// Nil limits enforce nothing.
+	for i := 0; i < 3; i++ {
+		if !limits.OpenChannel() {
+			t.Error("channel refused")
+		}
+	}
+	limits.Stop()
+}
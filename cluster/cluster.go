@@ -0,0 +1,76 @@
+// Package cluster forwards the events of sensors, sshesame instances facing
+// clients, to a central collector, another one aggregating them.
+//
+// Sensors connect to the collector over TLS, both authenticating with
+// certificates of the same CA, and send every event as a JSON line:
+//
+//	{"stream":"<stream>","seq":<seq>,"event":<event>}
+//
+// stream identifies a run of the sensor and seq numbers its events from 1,
+// event being the JSON line log_file would contain. The collector
+// acknowledges events with {"seq":<seq>} lines, for the last one it read
+// whenever it caught up. Sensors spool the events not acknowledged yet and
+// send them again once they reconnect, so that the collector drops those it
+// already had.
+package cluster
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+const (
+	// timeout bounds connecting, handshakes and every write.
+	timeout = 10 * time.Second
+	// maxLineSize is the longest line read from a sensor or the collector.
+	maxLineSize = 16 * 1024 * 1024
+	// timestampLayout is how sensors send event times, whatever the
+	// timestamp_format of their logs.
+	timestampLayout = time.RFC3339Nano
+)
+
+// envelope frames an event sent by a sensor.
+type envelope struct {
+	Stream string          `json:"stream"`
+	Seq    uint64          `json:"seq"`
+	Event  json.RawMessage `json:"event"`
+}
+
+// ack acknowledges the events of a stream up to Seq.
+type ack struct {
+	Seq uint64 `json:"seq"`
+}
+
+// TLSConfig returns the TLS configuration of a sensor or the collector,
+// presenting the certificate chain in certFile with the key in keyFile and
+// trusting the peers whose certificates the CA certificates in caFile
+// issued.
+func TLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	if certFile == "" || keyFile == "" || caFile == "" {
+		return nil, errors.New("a certificate, key and CA are required")
+	}
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	caPEM, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no CA certificate found in %v", caFile)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		RootCAs:      pool,
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
@@ -0,0 +1,179 @@
+package cluster
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/longkeyy/sshesame/output"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCA issues the certificates of sensors and collectors.
+type testCA struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	dir         string
+	file        string
+}
+
+func newCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "sshesame CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	ca := &testCA{certificate: certificate, key: key, dir: dir, file: filepath.Join(dir, "ca.pem")}
+	if err := ioutil.WriteFile(ca.file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return ca
+}
+
+// config returns the TLS configuration of a peer named name, whose
+// certificate is valid for 127.0.0.1.
+func (ca *testCA) config(t *testing.T, name string) *tls.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.certificate, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(ca.dir, name+".pem"), filepath.Join(ca.dir, name+"-key.pem")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := TLSConfig(certFile, keyFile, ca.file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func captureLog(t *testing.T) *logtest.Hook {
+	hook := logtest.NewGlobal()
+	out := log.StandardLogger().Out
+	log.SetOutput(ioutil.Discard)
+	t.Cleanup(func() {
+		log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+		log.SetOutput(out)
+	})
+	return hook
+}
+
+// waitEntry waits for an entry whose message starts with prefix to be
+// logged.
+func waitEntry(t *testing.T, hook *logtest.Hook, prefix string) *log.Entry {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, entry := range hook.AllEntries() {
+			if strings.HasPrefix(entry.Message, prefix) {
+				return entry
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%q not logged", prefix)
+	return nil
+}
+
+// serveCollector serves a collector with the certificate of ca on a
+// loopback address until the end of the test, returning the address.
+func serveCollector(t *testing.T, ca *testCA) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shutdown := make(chan struct{})
+	go NewCollector(ca.config(t, "collector")).Serve(listener, shutdown)
+	t.Cleanup(func() { close(shutdown) })
+	return listener.Addr().String()
+}
+
+func TestTLSConfig(t *testing.T) {
+	ca := newCA(t)
+	config := ca.config(t, "sensor-1")
+	if len(config.Certificates) != 1 || config.ClientAuth != tls.RequireAndVerifyClientCert || config.MinVersion != tls.VersionTLS12 {
+		t.Errorf("configured %+v", config)
+	}
+	certFile, keyFile := filepath.Join(ca.dir, "sensor-1.pem"), filepath.Join(ca.dir, "sensor-1-key.pem")
+	for _, files := range [][3]string{
+		{certFile, keyFile, ""},
+		{certFile, certFile, ca.file},
+		{certFile, keyFile, filepath.Join(ca.dir, "missing.pem")},
+		// No CA certificate in a key file.
+		{certFile, keyFile, keyFile},
+	} {
+		if _, err := TLSConfig(files[0], files[1], files[2]); err == nil {
+			t.Errorf("configured TLS with %v", files)
+		}
+	}
+}
+
+func TestClusterForwardsEvents(t *testing.T) {
+	hook := captureLog(t)
+	ca := newCA(t)
+	address := serveCollector(t, ca)
+	sink, err := NewSensorSink(address, ca.config(t, "sensor-1"), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventTime := time.Date(2026, 10, 14, 12, 0, 0, 500000000, time.UTC)
+	sink.Emit(output.Event{Time: eventTime, Level: log.WarnLevel, Message: "Command executed", Fields: log.Fields{"client": "192.0.2.1:50000", "command": "id"}})
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	waitEntry(t, hook, "Sensor disconnected")
+	entry := waitEntry(t, hook, "Command executed")
+	if entry.Level != log.WarnLevel || !entry.Time.Equal(eventTime) || entry.Data["sensor"] != "sensor-1" || entry.Data["client"] != "192.0.2.1:50000" || entry.Data["command"] != "id" {
+		t.Errorf("logged %v at %v with %v", entry.Level, entry.Time, entry.Data)
+	}
+}
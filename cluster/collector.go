@@ -0,0 +1,189 @@
+package cluster
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Collector receives the events of sensors and logs them again, with the
+// sensor they came from as the sensor field, so that they reach its own
+// sinks, dashboard and API. Sensors are named by the common name of their
+// certificate.
+type Collector struct {
+	config *tls.Config
+
+	mu sync.Mutex
+	// streams are the last event received of every stream, by sensor and
+	// stream.
+	streams map[string]uint64
+	conns   map[net.Conn]bool
+}
+
+// NewCollector returns a collector accepting sensors with config.
+func NewCollector(config *tls.Config) *Collector {
+	config = config.Clone()
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	return &Collector{config: config, streams: map[string]uint64{}, conns: map[net.Conn]bool{}}
+}
+
+// Serve accepts sensors on listener until shutdown is closed.
+func (collector *Collector) Serve(listener net.Listener, shutdown <-chan struct{}) {
+	go func() {
+		<-shutdown
+		listener.Close()
+		collector.mu.Lock()
+		defer collector.mu.Unlock()
+		for conn := range collector.conns {
+			conn.Close()
+		}
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-shutdown:
+				return
+			default:
+			}
+			log.Warning("Failed to accept sensor connection:", err.Error())
+			time.Sleep(time.Second)
+			continue
+		}
+		go collector.handle(conn)
+	}
+}
+
+func (collector *Collector) handle(conn net.Conn) {
+	defer conn.Close()
+	tlsConn := tls.Server(conn, collector.config)
+	tlsConn.SetDeadline(time.Now().Add(timeout))
+	if err := tlsConn.Handshake(); err != nil {
+		log.WithFields(log.Fields{
+			"address": conn.RemoteAddr().String(),
+		}).Warning("Failed to authenticate sensor:", err.Error())
+		return
+	}
+	tlsConn.SetDeadline(time.Time{})
+	collector.mu.Lock()
+	collector.conns[tlsConn] = true
+	collector.mu.Unlock()
+	defer func() {
+		collector.mu.Lock()
+		delete(collector.conns, tlsConn)
+		collector.mu.Unlock()
+	}()
+	sensor := tlsConn.ConnectionState().PeerCertificates[0].Subject.CommonName
+	log.WithFields(log.Fields{
+		"sensor":  sensor,
+		"address": conn.RemoteAddr().String(),
+	}).Info("Sensor connected")
+	received, duplicates, err := collector.receive(sensor, tlsConn)
+	fields := log.Fields{
+		"sensor":     sensor,
+		"address":    conn.RemoteAddr().String(),
+		"events":     received,
+		"duplicates": duplicates,
+	}
+	if err != nil && err != io.EOF {
+		fields["error"] = err.Error()
+	}
+	log.WithFields(fields).Info("Sensor disconnected")
+}
+
+// receive logs the events sensor sends over conn, acknowledging them, and
+// returns how many it received and how many of those it already had.
+func (collector *Collector) receive(sensor string, conn net.Conn) (int, int, error) {
+	reader := bufio.NewReaderSize(conn, 64*1024)
+	received, duplicates := 0, 0
+	for {
+		line, err := readLine(reader)
+		if err != nil {
+			return received, duplicates, err
+		}
+		var framed envelope
+		if err := json.Unmarshal(line, &framed); err != nil || framed.Stream == "" || framed.Seq == 0 {
+			return received, duplicates, fmt.Errorf("invalid event %.64q", line)
+		}
+		received++
+		key := sensor + "/" + framed.Stream
+		collector.mu.Lock()
+		duplicate := framed.Seq <= collector.streams[key]
+		if !duplicate {
+			collector.streams[key] = framed.Seq
+		}
+		collector.mu.Unlock()
+		if duplicate {
+			duplicates++
+		} else if err := logEvent(sensor, framed.Event); err != nil {
+			return received, duplicates, err
+		}
+		// Events are acknowledged once the sensor's writes are caught up
+		// with rather than one by one.
+		if reader.Buffered() == 0 {
+			acknowledgement, _ := json.Marshal(ack{framed.Seq})
+			conn.SetWriteDeadline(time.Now().Add(timeout))
+			if _, err := conn.Write(append(acknowledgement, '\n')); err != nil {
+				return received, duplicates, err
+			}
+		}
+	}
+}
+
+// readLine reads a line of at most maxLineSize bytes, without its line
+// break.
+func readLine(reader *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > maxLineSize {
+			return nil, errors.New("event too long")
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return line[:len(line)-1], nil
+	}
+}
+
+// logEvent logs an event of sensor as it was logged there.
+func logEvent(sensor string, event []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(event))
+	decoder.UseNumber()
+	fields := log.Fields{}
+	if err := decoder.Decode(&fields); err != nil {
+		return fmt.Errorf("invalid event %.64q: %v", event, err)
+	}
+	message, _ := fields[log.FieldKeyMsg].(string)
+	eventTime, err := time.Parse(timestampLayout, fmt.Sprint(fields[log.FieldKeyTime]))
+	if message == "" || err != nil {
+		return fmt.Errorf("invalid event %.64q", event)
+	}
+	level, err := log.ParseLevel(fmt.Sprint(fields[log.FieldKeyLevel]))
+	if err != nil {
+		level = log.InfoLevel
+	}
+	// A panic or fatal event of a sensor mustn't end the collector.
+	if level < log.ErrorLevel {
+		level = log.ErrorLevel
+	}
+	// The collector computes severities itself.
+	for _, key := range []string{log.FieldKeyMsg, log.FieldKeyLevel, log.FieldKeyTime, "severity"} {
+		delete(fields, key)
+	}
+	fields["sensor"] = sensor
+	log.WithFields(fields).WithTime(eventTime).Log(level, message)
+	return nil
+}
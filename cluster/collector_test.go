@@ -0,0 +1,118 @@
+package cluster
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"testing"
+	"time"
+)
+
+// dialCollector connects to the collector at address as a sensor with
+// config.
+func dialCollector(t *testing.T, address string, config *tls.Config) (*tls.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := tls.Dial("tcp", address, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	t.Cleanup(func() { conn.Close() })
+	return conn, bufio.NewReader(conn)
+}
+
+func TestCollectorAcknowledges(t *testing.T) {
+	hook := captureLog(t)
+	ca := newCA(t)
+	address := serveCollector(t, ca)
+	conn, reader := dialCollector(t, address, ca.config(t, "sensor-1"))
+	// Events are acknowledged once the collector caught up, after the last
+	// of those written together.
+	for _, test := range []struct {
+		lines string
+		ack   string
+	}{
+		{`{"stream":"a1","seq":1,"event":{"client":"192.0.2.1:50000","command":"id","level":"warning","msg":"Command executed","port":22,"severity":"high","time":"2026-10-14T12:00:00.5Z"}}` + "\n" +
+			`{"stream":"a1","seq":2,"event":{"level":"panic","msg":"Sensor failing","time":"2026-10-14T12:00:01Z"}}` + "\n", `{"seq":2}` + "\n"},
+		// Events sent again after reconnecting are dropped.
+		{`{"stream":"a1","seq":2,"event":{"level":"panic","msg":"Sensor failing","time":"2026-10-14T12:00:01Z"}}` + "\n" +
+			`{"stream":"a1","seq":3,"event":{"level":"info","msg":"Client disconnected","time":"2026-10-14T12:00:02Z"}}` + "\n", `{"seq":3}` + "\n"},
+	} {
+		io.WriteString(conn, test.lines)
+		if line, err := reader.ReadString('\n'); err != nil || line != test.ack {
+			t.Errorf("acknowledged %q with %q, %v, want %q", test.lines, line, err, test.ack)
+		}
+	}
+	conn.Close()
+
+	entry := waitEntry(t, hook, "Sensor disconnected")
+	if entry.Data["sensor"] != "sensor-1" || entry.Data["events"] != 4 || entry.Data["duplicates"] != 1 || entry.Data["error"] != nil {
+		t.Errorf("logged the disconnection with %v", entry.Data)
+	}
+	entry = waitEntry(t, hook, "Command executed")
+	// The collector computes severities itself, fields being decoded like
+	// those of events it logs.
+	if entry.Level != log.WarnLevel || !entry.Time.Equal(time.Date(2026, 10, 14, 12, 0, 0, 500000000, time.UTC)) || entry.Data["sensor"] != "sensor-1" || entry.Data["port"] != json.Number("22") || entry.Data["severity"] != nil {
+		t.Errorf("logged %v at %v with %v", entry.Level, entry.Time, entry.Data)
+	}
+	// Fatal and panic events of sensors don't end the collector.
+	if entry := waitEntry(t, hook, "Sensor failing"); entry.Level != log.ErrorLevel {
+		t.Errorf("logged the panic of a sensor at %v", entry.Level)
+	}
+	count := 0
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Sensor failing" {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("logged %v events sent twice", count)
+	}
+}
+
+func TestCollectorInvalidEvents(t *testing.T) {
+	hook := captureLog(t)
+	ca := newCA(t)
+	address := serveCollector(t, ca)
+	for _, line := range []string{
+		`{"stream":"a1","event":{"msg":"Command executed","time":"2026-10-14T12:00:00Z"}}`,
+		`{"seq":1,"event":{"msg":"Command executed","time":"2026-10-14T12:00:00Z"}}`,
+		`{"stream":"a2","seq":1,"event":{"msg":"Command executed"}}`,
+		`{"stream":"a3","seq":1,"event":{"time":"2026-10-14T12:00:00Z"}}`,
+		`not JSON`,
+	} {
+		hook.Reset()
+		conn, reader := dialCollector(t, address, ca.config(t, "sensor-1"))
+		io.WriteString(conn, line+"\n")
+		if acknowledgement, err := reader.ReadString('\n'); err == nil {
+			t.Errorf("%v acknowledged with %q", line, acknowledgement)
+		}
+		if entry := waitEntry(t, hook, "Sensor disconnected"); entry.Data["error"] == nil {
+			t.Errorf("%v: disconnected with %v", line, entry.Data)
+		}
+	}
+}
+
+func TestCollectorUntrustedSensor(t *testing.T) {
+	hook := captureLog(t)
+	ca := newCA(t)
+	address := serveCollector(t, ca)
+	// A certificate of another CA.
+	config := newCA(t).config(t, "sensor-1")
+	config.RootCAs = ca.config(t, "other").RootCAs
+	conn, err := tls.Dial("tcp", address, config)
+	// TLS 1.3 clients only learn their certificate was refused once they
+	// read.
+	if err == nil {
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Read(make([]byte, 1)); err == nil {
+			t.Error("sensor with an untrusted certificate accepted")
+		}
+		conn.Close()
+	}
+	if entry := waitEntry(t, hook, "Failed to authenticate sensor:"); entry.Level != log.WarnLevel {
+		t.Errorf("refused the sensor at %v", entry.Level)
+	}
+}
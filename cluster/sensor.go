@@ -0,0 +1,253 @@
+package cluster
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/longkeyy/sshesame/outbound"
+	"github.com/longkeyy/sshesame/output"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	sensorRetryDelay    = time.Second
+	sensorMaxRetryDelay = time.Minute
+)
+
+// spooled is an event waiting to be acknowledged by the collector.
+type spooled struct {
+	seq  uint64
+	line []byte
+}
+
+// SensorSink forwards events to a collector. It spools up to a number of
+// events not acknowledged yet, across reconnections, dropping the oldest
+// ones once full.
+type SensorSink struct {
+	address   string
+	config    *tls.Config
+	spoolSize int
+	formatter log.Formatter
+	stream    string
+
+	mu      sync.Mutex
+	seq     uint64
+	spool   []spooled
+	dropped uint64
+	// err is the error of the last connection attempt that failed, returned
+	// by the next Emit.
+	err error
+
+	// wake is signalled when events are spooled, acked when some are
+	// acknowledged.
+	wake    chan struct{}
+	acked   chan struct{}
+	closing chan struct{}
+	done    chan struct{}
+}
+
+// NewSensorSink returns a sink forwarding events to the collector at
+// address, spooling up to spoolSize of them.
+func NewSensorSink(address string, config *tls.Config, spoolSize int) (*SensorSink, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid collector address %q: %v", address, err)
+	}
+	if spoolSize <= 0 {
+		return nil, fmt.Errorf("invalid spool size %v, must be positive", spoolSize)
+	}
+	config = config.Clone()
+	config.ServerName = host
+	random := make([]byte, 8)
+	rand.Read(random)
+	sink := &SensorSink{
+		address:   address,
+		config:    config,
+		spoolSize: spoolSize,
+		formatter: output.Timestamps{Layout: timestampLayout}.Formatter(true),
+		stream:    hex.EncodeToString(random),
+		wake:      make(chan struct{}, 1),
+		acked:     make(chan struct{}, 1),
+		closing:   make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go sink.run()
+	return sink, nil
+}
+
+// Emit implements output.Sink.
+func (sink *SensorSink) Emit(event output.Event) error {
+	line, err := sink.formatter.Format(&log.Entry{
+		Logger:  log.StandardLogger(),
+		Data:    event.Fields,
+		Time:    event.Time,
+		Level:   event.Level,
+		Message: event.Message,
+	})
+	if err != nil {
+		return err
+	}
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	sink.seq++
+	line, err = json.Marshal(envelope{Stream: sink.stream, Seq: sink.seq, Event: line[:len(line)-1]})
+	if err != nil {
+		return err
+	}
+	sink.spool = append(sink.spool, spooled{sink.seq, append(line, '\n')})
+	if len(sink.spool) > sink.spoolSize {
+		sink.spool = sink.spool[1:]
+		sink.dropped++
+	}
+	signal(sink.wake)
+	if err := sink.err; err != nil {
+		sink.err = nil
+		return err
+	}
+	return nil
+}
+
+// signal signals channel without blocking.
+func signal(channel chan struct{}) {
+	select {
+	case channel <- struct{}{}:
+	default:
+	}
+}
+
+// run keeps a connection to the collector, sending spooled events over it,
+// until the sink is closed.
+func (sink *SensorSink) run() {
+	defer close(sink.done)
+	delay := sensorRetryDelay
+	for {
+		conn, err := sink.connect()
+		if err == nil {
+			delay = sensorRetryDelay
+			err = sink.send(conn)
+			conn.Close()
+		}
+		select {
+		case <-sink.closing:
+			return
+		default:
+		}
+		sink.mu.Lock()
+		sink.err = fmt.Errorf("failed to forward events to collector %v, %v spooled and %v dropped: %v", sink.address, len(sink.spool), sink.dropped, err)
+		sink.mu.Unlock()
+		select {
+		case <-time.After(delay):
+		case <-sink.closing:
+			return
+		}
+		if delay *= 2; delay > sensorMaxRetryDelay {
+			delay = sensorMaxRetryDelay
+		}
+	}
+}
+
+func (sink *SensorSink) connect() (*tls.Conn, error) {
+	conn, err := outbound.Dial("tcp", sink.address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	tlsConn := tls.Client(conn, sink.config)
+	tlsConn.SetDeadline(time.Now().Add(timeout))
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+// send sends every spooled event over conn, those sent over a previous
+// connection again, until it fails or the sink is closed.
+func (sink *SensorSink) send(conn net.Conn) error {
+	failed := make(chan error, 1)
+	go func() {
+		failed <- sink.readAcks(conn)
+	}()
+	var sent uint64
+	for {
+		sink.mu.Lock()
+		var batch []byte
+		for _, event := range sink.spool {
+			if event.seq > sent {
+				batch = append(batch, event.line...)
+				sent = event.seq
+			}
+		}
+		sink.mu.Unlock()
+		if len(batch) != 0 {
+			conn.SetWriteDeadline(time.Now().Add(timeout))
+			if _, err := conn.Write(batch); err != nil {
+				return err
+			}
+			continue
+		}
+		select {
+		case <-sink.wake:
+		case err := <-failed:
+			return err
+		case <-sink.closing:
+			return nil
+		}
+	}
+}
+
+// readAcks unspools the events the collector acknowledges over conn.
+func (sink *SensorSink) readAcks(conn net.Conn) error {
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var acknowledged ack
+		if err := json.Unmarshal(scanner.Bytes(), &acknowledged); err != nil {
+			return fmt.Errorf("invalid acknowledgement %q", scanner.Bytes())
+		}
+		sink.mu.Lock()
+		count := 0
+		for count < len(sink.spool) && sink.spool[count].seq <= acknowledged.Seq {
+			count++
+		}
+		sink.spool = sink.spool[count:]
+		sink.mu.Unlock()
+		signal(sink.acked)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("collector closed the connection")
+}
+
+// Close implements output.Sink, waiting up to the timeout for the collector
+// to acknowledge the spooled events.
+func (sink *SensorSink) Close() error {
+	deadline := time.After(timeout)
+	unacked := 0
+wait:
+	for {
+		sink.mu.Lock()
+		unacked = len(sink.spool)
+		sink.mu.Unlock()
+		if unacked == 0 {
+			break
+		}
+		select {
+		case <-sink.acked:
+		case <-deadline:
+			break wait
+		}
+	}
+	close(sink.closing)
+	<-sink.done
+	if unacked != 0 {
+		return fmt.Errorf("%v events not acknowledged by collector %v", unacked, sink.address)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package cluster
+
+import (
+	"bufio"
+	"crypto/tls"
+	"github.com/longkeyy/sshesame/output"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// acceptSensor accepts the next sensor on listener, returning its
+// connection.
+func acceptSensor(t *testing.T, listener net.Listener) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	t.Cleanup(func() { conn.Close() })
+	return conn, bufio.NewReader(conn)
+}
+
+func TestSensorSendsEnvelopes(t *testing.T) {
+	ca := newCA(t)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", ca.config(t, "collector"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	sink, err := NewSensorSink(listener.Addr().String(), ca.config(t, "sensor-1"), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := []output.Event{
+		{Time: time.Date(2026, 10, 14, 12, 0, 0, 500000000, time.UTC), Level: log.InfoLevel, Message: "Command executed", Fields: log.Fields{"command": "id"}},
+		{Time: time.Date(2026, 10, 14, 12, 0, 1, 0, time.UTC), Level: log.WarnLevel, Message: "Client disconnected"},
+	}
+	lines := []string{
+		`{"stream":"` + sink.stream + `","seq":1,"event":{"command":"id","level":"info","msg":"Command executed","time":"2026-10-14T12:00:00.5Z"}}` + "\n",
+		`{"stream":"` + sink.stream + `","seq":2,"event":{"level":"warning","msg":"Client disconnected","time":"2026-10-14T12:00:01Z"}}` + "\n",
+	}
+	if len(sink.stream) != 16 {
+		t.Errorf("stream %q isn't 8 random bytes", sink.stream)
+	}
+	sink.Emit(events[0])
+	conn, reader := acceptSensor(t, listener)
+	if line, err := reader.ReadString('\n'); err != nil || line != lines[0] {
+		t.Errorf("sent %q, %v, want %q", line, err, lines[0])
+	}
+	// Events not acknowledged are sent again once the sensor reconnects.
+	conn.Close()
+	sink.Emit(events[1])
+	conn, reader = acceptSensor(t, listener)
+	for _, want := range lines {
+		if line, err := reader.ReadString('\n'); err != nil || line != want {
+			t.Errorf("sent %q, %v after reconnecting, want %q", line, err, want)
+		}
+	}
+	io.WriteString(conn, `{"seq":1}`+"\n")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		sink.mu.Lock()
+		spooled := len(sink.spool)
+		sink.mu.Unlock()
+		if spooled == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%v events spooled after acknowledging 1 of 2", spooled)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	io.WriteString(conn, `{"seq":2}`+"\n")
+	if err := sink.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSensorSpoolBounded(t *testing.T) {
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	sink, err := NewSensorSink(closed.Addr().String(), newCA(t).config(t, "sensor-1"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		close(sink.closing)
+		<-sink.done
+	}()
+	for i := 0; i < 3; i++ {
+		sink.Emit(output.Event{Time: time.Now(), Level: log.InfoLevel, Message: "Command executed"})
+	}
+	sink.mu.Lock()
+	if len(sink.spool) != 2 || sink.spool[0].seq != 2 || sink.spool[1].seq != 3 || sink.dropped != 1 {
+		t.Errorf("spooled %v events, the oldest %v, and dropped %v", len(sink.spool), sink.spool[0].seq, sink.dropped)
+	}
+	sink.mu.Unlock()
+	// Failures to connect are returned by the next event.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := sink.Emit(output.Event{Time: time.Now(), Level: log.InfoLevel, Message: "Command executed"})
+		if err != nil {
+			if !strings.HasPrefix(err.Error(), "failed to forward events to collector "+closed.Addr().String()) {
+				t.Errorf("Emit = %v", err)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("failing to connect isn't reported")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNewSensorSinkInvalid(t *testing.T) {
+	config := newCA(t).config(t, "sensor-1")
+	for _, test := range []struct {
+		address   string
+		spoolSize int
+	}{
+		{"collector.example.com", 16},
+		{"collector.example.com:7000", 0},
+	} {
+		if _, err := NewSensorSink(test.address, config, test.spoolSize); err == nil {
+			t.Errorf("sensor sink created for %v with a spool of %v", test.address, test.spoolSize)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"strings"
+)
+
+// AgentKey is a decoy key the fake agent of clients that forward their agent
+// holds.
+type AgentKey struct {
+	Signer ssh.Signer
+	// Comment is what ssh-add -l shows for the key.
+	Comment string
+}
+
+// LoadAgentKeys reads the private keys at paths, unencrypted, commented like
+// ssh-add comments them: by the comment of the public key next to them, if
+// any, or their path.
+func LoadAgentKeys(paths []string) ([]AgentKey, error) {
+	keys := []AgentKey{}
+	for _, path := range paths {
+		keyBytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(keyBytes)
+		if err != nil {
+			return nil, err
+		}
+		comment := path
+		if publicBytes, err := ioutil.ReadFile(path + ".pub"); err == nil {
+			if _, publicComment, _, _, err := ssh.ParseAuthorizedKey(publicBytes); err == nil && publicComment != "" {
+				comment = publicComment
+			}
+		}
+		keys = append(keys, AgentKey{signer, strings.TrimSpace(comment)})
+	}
+	return keys, nil
+}
+
+// GenerateAgentKey generates an Ed25519 decoy key commented with comment.
+func GenerateAgentKey(comment string) (AgentKey, error) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return AgentKey{}, err
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		return AgentKey{}, err
+	}
+	return AgentKey{signer, comment}, nil
+}
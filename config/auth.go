@@ -0,0 +1,470 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Auth configures how authentication attempts are answered.
+type Auth struct {
+	// Methods are the authentication methods offered to clients.
+	Methods Methods
+	// Steps, if set, are methods clients must authenticate with in turn,
+	// like OpenSSH's AuthenticationMethods.
+	Steps Methods
+	// AcceptNone grants access to clients authenticating with the none
+	// method.
+	AcceptNone bool
+	// Users, if set, are the only users that can authenticate, whatever the
+	// method. Others are rejected as invalid users.
+	Users Users
+	// Credentials, if set, are the only passwords accepted.
+	Credentials    *CredentialSources
+	PublicKeyRules PublicKeyRules
+	// PasswordRules, if set, decide passwords before Credentials, which only
+	// decide those matching no rule.
+	PasswordRules PasswordRules
+	// Honeytokens are credentials whose use is alerted on, whatever the
+	// decision.
+	Honeytokens Honeytokens
+	// HistoryWindow is how long the failed attempts of an address, which the
+	// AfterFailures of password rules count, and the decisions on its
+	// passwords are remembered after its last attempt.
+	HistoryWindow time.Duration
+	// StickyDecisions decides a user and password the same way every time an
+	// address tries them, while they're remembered, so that random password
+	// rules look consistent to a client.
+	StickyDecisions bool
+	// Prompts are asked in order during keyboard-interactive authentication,
+	// DefaultPrompts if empty.
+	Prompts Prompts
+	// After BlockFailures failed authentication attempts from an address
+	// within BlockWindow, its connections are closed for BlockCooldown. 0
+	// disables blocking.
+	BlockFailures int
+	BlockWindow   time.Duration
+	BlockCooldown time.Duration
+	// MaxAuthTries is the number of failed attempts, across methods, after
+	// which clients are disconnected like by sshd. 0 disables the limit.
+	MaxAuthTries int
+	// PreAuthDelay, plus up to PreAuthJitter at random, is how long the first
+	// authentication attempt of a connection waits before being answered.
+	PreAuthDelay  time.Duration
+	PreAuthJitter time.Duration
+	// Banner, if set, is shown to clients before they authenticate, unless
+	// in decoy mode.
+	Banner string
+	// Decoy rejects every authentication attempt, whatever the method and
+	// credentials, after showing DecoyBanner, so clients never get a
+	// session. DecoyMessage, if set, is sent to clients with every rejection.
+	Decoy        bool
+	DecoyBanner  string
+	DecoyMessage string
+}
+
+// AcceptPassword reports whether a password authentication attempt succeeds,
+// and returns the credential it matched if credentials are set.
+func (auth Auth) AcceptPassword(user, password string) (Credential, bool) {
+	if !auth.Users.Valid(user) {
+		return Credential{}, false
+	}
+	if auth.Credentials == nil {
+		return Credential{}, true
+	}
+	return auth.Credentials.Match(user, password)
+}
+
+// Users is a list of user name patterns as understood by path.Match. It
+// implements flag.Value, parsing a comma-separated list.
+type Users []string
+
+// Valid reports whether user matches any of the patterns, or whether there
+// are none.
+func (users Users) Valid(user string) bool {
+	if len(users) == 0 {
+		return true
+	}
+	for _, pattern := range users {
+		if matched, _ := path.Match(pattern, user); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (users *Users) String() string {
+	if users == nil {
+		return ""
+	}
+	return strings.Join(*users, ",")
+}
+
+// Set implements flag.Value.
+func (users *Users) Set(text string) error {
+	parsed := Users{}
+	for _, pattern := range strings.Split(text, ",") {
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid user pattern %q", pattern)
+		}
+		parsed = append(parsed, pattern)
+	}
+	*users = parsed
+	return nil
+}
+
+// knownMethods are the authentication methods that can be offered.
+var knownMethods = []string{"password", "publickey", "keyboard-interactive"}
+
+// Methods is a set of authentication methods. It implements flag.Value,
+// parsing a comma-separated list.
+type Methods []string
+
+// Enabled reports whether method is in methods.
+func (methods Methods) Enabled(method string) bool {
+	for _, enabled := range methods {
+		if enabled == method {
+			return true
+		}
+	}
+	return false
+}
+
+func (methods *Methods) String() string {
+	if methods == nil {
+		return ""
+	}
+	return strings.Join(*methods, ",")
+}
+
+// Set implements flag.Value.
+func (methods *Methods) Set(text string) error {
+	parsed := Methods{}
+	for _, method := range strings.Split(text, ",") {
+		if method == "" {
+			continue
+		}
+		if !Methods(knownMethods).Enabled(method) {
+			return fmt.Errorf("unknown authentication method %q, must be one of %v", method, strings.Join(knownMethods, ", "))
+		}
+		if !parsed.Enabled(method) {
+			parsed = append(parsed, method)
+		}
+	}
+	*methods = parsed
+	return nil
+}
+
+// PublicKeyRule decides the outcome of public key authentication attempts
+// using matching keys. Empty match fields match any key.
+type PublicKeyRule struct {
+	// Fingerprint is the SHA256 fingerprint of the key, as printed by ssh-keygen -l.
+	Fingerprint string
+	// KeyType is the SSH key type, for example ssh-ed25519.
+	KeyType string
+	Accept  bool
+	// AfterKeys, if non-zero, only accepts a key once the client has offered
+	// at least this many distinct keys, including the matching one.
+	AfterKeys int
+}
+
+func (rule PublicKeyRule) String() string {
+	parts := []string{"reject"}
+	if rule.Accept {
+		parts[0] = "accept"
+	}
+	if rule.KeyType != "" {
+		parts = append(parts, "type="+rule.KeyType)
+	}
+	if rule.Fingerprint != "" {
+		parts = append(parts, "fingerprint="+rule.Fingerprint)
+	}
+	if rule.AfterKeys != 0 {
+		parts = append(parts, "after="+strconv.Itoa(rule.AfterKeys))
+	}
+	return strings.Join(parts, ",")
+}
+
+// ParsePublicKeyRule parses a rule of the form
+// accept|reject[,type=<key type>][,fingerprint=<fingerprint>][,after=<keys>].
+func ParsePublicKeyRule(text string) (PublicKeyRule, error) {
+	parts := strings.Split(text, ",")
+	rule := PublicKeyRule{}
+	switch parts[0] {
+	case "accept":
+		rule.Accept = true
+	case "reject":
+	default:
+		return rule, fmt.Errorf("invalid action %q, must be accept or reject", parts[0])
+	}
+	for _, part := range parts[1:] {
+		keyValue := strings.SplitN(part, "=", 2)
+		if len(keyValue) != 2 {
+			return rule, fmt.Errorf("invalid match %q, must be key=value", part)
+		}
+		switch keyValue[0] {
+		case "type":
+			rule.KeyType = keyValue[1]
+		case "fingerprint":
+			rule.Fingerprint = keyValue[1]
+		case "after":
+			after, err := strconv.Atoi(keyValue[1])
+			if err != nil || after < 0 {
+				return rule, fmt.Errorf("invalid key count %q", keyValue[1])
+			}
+			rule.AfterKeys = after
+		default:
+			return rule, fmt.Errorf("unknown match %q", keyValue[0])
+		}
+	}
+	return rule, nil
+}
+
+// PublicKeyRules is an ordered list of rules, the first matching of which
+// applies. It implements flag.Value, appending a rule every time it's set.
+type PublicKeyRules []PublicKeyRule
+
+func (rules *PublicKeyRules) String() string {
+	if rules == nil {
+		return ""
+	}
+	texts := make([]string, len(*rules))
+	for i, rule := range *rules {
+		texts[i] = rule.String()
+	}
+	return strings.Join(texts, " ")
+}
+
+// Set implements flag.Value.
+func (rules *PublicKeyRules) Set(text string) error {
+	rule, err := ParsePublicKeyRule(text)
+	if err != nil {
+		return err
+	}
+	*rules = append(*rules, rule)
+	return nil
+}
+
+// PasswordRule decides the outcome of password authentication attempts
+// matching it. Empty match fields match any attempt.
+type PasswordRule struct {
+	// User and Password are regular expressions the user and password must
+	// match, anywhere unless anchored.
+	User, Password *regexp.Regexp
+	Accept         bool
+	// AfterFailures, if non-zero, only accepts a password once the address
+	// of the client failed that many attempts before, like a target of a
+	// long brute-force attack.
+	AfterFailures int
+	// Probability, if non-zero, only accepts a password at random with this
+	// probability.
+	Probability float64
+}
+
+func (rule PasswordRule) String() string {
+	parts := []string{"reject"}
+	if rule.Accept {
+		parts[0] = "accept"
+	}
+	if rule.User != nil {
+		parts = append(parts, "user="+rule.User.String())
+	}
+	if rule.Password != nil {
+		parts = append(parts, "password="+rule.Password.String())
+	}
+	if rule.AfterFailures != 0 {
+		parts = append(parts, "after="+strconv.Itoa(rule.AfterFailures))
+	}
+	if rule.Probability != 0 {
+		parts = append(parts, "probability="+strconv.FormatFloat(rule.Probability, 'g', -1, 64))
+	}
+	return strings.Join(parts, ",")
+}
+
+// Match reports whether user and password match rule.
+func (rule PasswordRule) Match(user, password string) bool {
+	return (rule.User == nil || rule.User.MatchString(user)) && (rule.Password == nil || rule.Password.MatchString(password))
+}
+
+// passwordRuleKeys are the keys of password rules. A comma not followed by
+// one of them is part of the value before it, so that regular expressions can
+// hold commas.
+var passwordRuleKeys = map[string]bool{"user": true, "password": true, "after": true, "probability": true}
+
+// ParsePasswordRule parses a rule of the form
+// accept|reject[,user=<regexp>][,password=<regexp>][,after=<failed attempts>][,probability=<0-1>].
+func ParsePasswordRule(text string) (PasswordRule, error) {
+	parts := []string{}
+	for i, part := range strings.Split(text, ",") {
+		keyValue := strings.SplitN(part, "=", 2)
+		if i > 1 && (len(keyValue) != 2 || !passwordRuleKeys[keyValue[0]]) {
+			parts[len(parts)-1] += "," + part
+			continue
+		}
+		parts = append(parts, part)
+	}
+	rule := PasswordRule{}
+	switch parts[0] {
+	case "accept":
+		rule.Accept = true
+	case "reject":
+	default:
+		return rule, fmt.Errorf("invalid action %q, must be accept or reject", parts[0])
+	}
+	for _, part := range parts[1:] {
+		keyValue := strings.SplitN(part, "=", 2)
+		if len(keyValue) != 2 {
+			return rule, fmt.Errorf("invalid match %q, must be key=value", part)
+		}
+		switch keyValue[0] {
+		case "user", "password":
+			pattern, err := regexp.Compile(keyValue[1])
+			if err != nil {
+				return rule, fmt.Errorf("invalid %v pattern %q: %w", keyValue[0], keyValue[1], err)
+			}
+			if keyValue[0] == "user" {
+				rule.User = pattern
+			} else {
+				rule.Password = pattern
+			}
+		case "after":
+			after, err := strconv.Atoi(keyValue[1])
+			if err != nil || after < 0 {
+				return rule, fmt.Errorf("invalid failed attempt count %q", keyValue[1])
+			}
+			rule.AfterFailures = after
+		case "probability":
+			probability, err := strconv.ParseFloat(keyValue[1], 64)
+			if err != nil || probability < 0 || probability > 1 {
+				return rule, fmt.Errorf("invalid probability %q, must be between 0 and 1", keyValue[1])
+			}
+			rule.Probability = probability
+		default:
+			return rule, fmt.Errorf("unknown match %q", keyValue[0])
+		}
+	}
+	return rule, nil
+}
+
+// PasswordRules is an ordered list of rules, the first matching of which
+// applies. It implements flag.Value, appending a rule every time it's set.
+type PasswordRules []PasswordRule
+
+func (rules *PasswordRules) String() string {
+	if rules == nil {
+		return ""
+	}
+	texts := make([]string, len(*rules))
+	for i, rule := range *rules {
+		texts[i] = rule.String()
+	}
+	return strings.Join(texts, " ")
+}
+
+// Set implements flag.Value.
+func (rules *PasswordRules) Set(text string) error {
+	rule, err := ParsePasswordRule(text)
+	if err != nil {
+		return err
+	}
+	*rules = append(*rules, rule)
+	return nil
+}
+
+// Match returns the first of the rules user and password match, if any.
+func (rules PasswordRules) Match(user, password string) (PasswordRule, bool) {
+	for _, rule := range rules {
+		if rule.Match(user, password) {
+			return rule, true
+		}
+	}
+	return PasswordRule{}, false
+}
+
+// DefaultPrompts ask for a password only.
+var DefaultPrompts = Prompts{{Name: "password", Text: "Password: "}}
+
+// Prompt is a question asked during keyboard-interactive authentication. The
+// answer to the prompt named password is decided like a password.
+type Prompt struct {
+	// Name identifies the answer in the logs.
+	Name string
+	Text string
+	// Echo shows the answer as it's typed.
+	Echo bool
+	// Round asks the prompt in a new challenge, after the previous prompts
+	// were answered, like PAM asking a verification code after the password.
+	Round bool
+}
+
+func (prompt Prompt) String() string {
+	name := prompt.Name
+	if prompt.Echo {
+		name += ",echo"
+	}
+	if prompt.Round {
+		name += ",round"
+	}
+	return name + "=" + prompt.Text
+}
+
+// ParsePrompt parses a prompt of the form <name>[,echo][,round]=<text>.
+func ParsePrompt(text string) (Prompt, error) {
+	nameText := strings.SplitN(text, "=", 2)
+	if len(nameText) != 2 {
+		return Prompt{}, fmt.Errorf("invalid prompt %q, must be name=text", text)
+	}
+	parts := strings.Split(nameText[0], ",")
+	prompt := Prompt{Name: parts[0], Text: nameText[1]}
+	if prompt.Name == "" {
+		return prompt, fmt.Errorf("invalid prompt %q, the name is empty", text)
+	}
+	for _, option := range parts[1:] {
+		switch option {
+		case "echo":
+			prompt.Echo = true
+		case "round":
+			prompt.Round = true
+		default:
+			return prompt, fmt.Errorf("unknown prompt option %q", option)
+		}
+	}
+	return prompt, nil
+}
+
+// Prompts is an ordered list of prompts, asked at once but for those starting
+// a new round. It implements flag.Value, appending a prompt every time it's
+// set.
+type Prompts []Prompt
+
+func (prompts *Prompts) String() string {
+	if prompts == nil {
+		return ""
+	}
+	texts := make([]string, len(*prompts))
+	for i, prompt := range *prompts {
+		texts[i] = prompt.String()
+	}
+	return strings.Join(texts, " ")
+}
+
+// Set implements flag.Value.
+func (prompts *Prompts) Set(text string) error {
+	prompt, err := ParsePrompt(text)
+	if err != nil {
+		return err
+	}
+	for _, existing := range *prompts {
+		if existing.Name == prompt.Name {
+			return fmt.Errorf("duplicate prompt name %q", prompt.Name)
+		}
+	}
+	*prompts = append(*prompts, prompt)
+	return nil
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestParsePublicKeyRule(t *testing.T) {
+	for _, text := range []string{"accept", "reject,type=ssh-rsa", "accept,fingerprint=SHA256:abc,after=2"} {
+		rule, err := ParsePublicKeyRule(text)
+		if err != nil {
+			t.Errorf("ParsePublicKeyRule(%q) = %v", text, err)
+			continue
+		}
+		if rule.String() != text {
+			t.Errorf("ParsePublicKeyRule(%q) = %q", text, rule.String())
+		}
+	}
+	for _, text := range []string{"allow", "accept,after=-1", "accept,size=4096", "accept,type"} {
+		if _, err := ParsePublicKeyRule(text); err == nil {
+			t.Errorf("ParsePublicKeyRule(%q) succeeded, want an error", text)
+		}
+	}
+}
+
+// patternString returns the source of pattern, empty if nil.
+func patternString(pattern *regexp.Regexp) string {
+	if pattern == nil {
+		return ""
+	}
+	return pattern.String()
+}
+
+func TestParsePasswordRule(t *testing.T) {
+	for _, test := range []struct {
+		text, user, password string
+		after                int
+		probability          float64
+	}{
+		{"accept", "", "", 0, 0},
+		{"reject,user=^root$", "^root$", "", 0, 0},
+		// Commas not followed by a key are part of the pattern before them.
+		{"accept,password=^(a,b|c)$,after=3,probability=0.25", "", "^(a,b|c)$", 3, 0.25},
+		{"accept,user=a,b=c,password=x{1,2}", "a,b=c", "x{1,2}", 0, 0},
+	} {
+		rule, err := ParsePasswordRule(test.text)
+		if err != nil {
+			t.Errorf("ParsePasswordRule(%q) = %v", test.text, err)
+			continue
+		}
+		if patternString(rule.User) != test.user || patternString(rule.Password) != test.password || rule.AfterFailures != test.after || rule.Probability != test.probability {
+			t.Errorf("ParsePasswordRule(%q) = %+v", test.text, rule)
+		}
+		if rule.String() != test.text {
+			t.Errorf("ParsePasswordRule(%q) formatted as %q", test.text, rule.String())
+		}
+	}
+	for _, text := range []string{"allow", "accept,user=(", "accept,after=-1", "accept,probability=1.5", "accept,size=4", "accept,user"} {
+		if _, err := ParsePasswordRule(text); err == nil {
+			t.Errorf("ParsePasswordRule(%q) succeeded, want an error", text)
+		}
+	}
+}
+
+func TestPasswordRulesMatch(t *testing.T) {
+	rules := PasswordRules{}
+	for _, text := range []string{"reject,user=^root$,password=^root$", "accept,password=^[0-9]+$", "accept,user=^admin"} {
+		if err := rules.Set(text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, test := range []struct {
+		user, password string
+		rule           string
+	}{
+		{"root", "root", "reject,user=^root$,password=^root$"},
+		{"root", "123456", "accept,password=^[0-9]+$"},
+		{"administrator", "root", "accept,user=^admin"},
+		{"ubuntu", "ubuntu", ""},
+	} {
+		rule, ok := rules.Match(test.user, test.password)
+		if ok != (test.rule != "") || ok && rule.String() != test.rule {
+			t.Errorf("%v:%v matched %q, %v, want %q", test.user, test.password, rule.String(), ok, test.rule)
+		}
+	}
+}
+
+func TestParsePrompt(t *testing.T) {
+	for _, test := range []struct {
+		text   string
+		prompt Prompt
+	}{
+		{"password=Password: ", Prompt{Name: "password", Text: "Password: "}},
+		{"login,echo=login: ", Prompt{Name: "login", Text: "login: ", Echo: true}},
+		{"otp,echo,round=Code=", Prompt{Name: "otp", Text: "Code=", Echo: true, Round: true}},
+	} {
+		prompt, err := ParsePrompt(test.text)
+		if err != nil || prompt != test.prompt {
+			t.Errorf("ParsePrompt(%q) = %+v, %v, want %+v", test.text, prompt, err, test.prompt)
+		}
+		if prompt.String() != test.text {
+			t.Errorf("%+v formatted as %q, want %q", prompt, prompt.String(), test.text)
+		}
+	}
+	for _, text := range []string{"password", ",echo=Password: ", "otp,hidden=Code: "} {
+		if _, err := ParsePrompt(text); err == nil {
+			t.Errorf("ParsePrompt(%q) succeeded, want an error", text)
+		}
+	}
+}
+
+func TestUsers(t *testing.T) {
+	users := Users{}
+	if err := users.Set("root,admin*,,ubuntu"); err != nil {
+		t.Fatal(err)
+	}
+	if users.String() != "root,admin*,ubuntu" {
+		t.Errorf("users = %q", users.String())
+	}
+	for user, valid := range map[string]bool{"root": true, "administrator": true, "ubuntu": true, "rooot": false, "bob": false, "": false} {
+		if users.Valid(user) != valid {
+			t.Errorf("Valid(%q) = %v, want %v", user, !valid, valid)
+		}
+	}
+	if !(Users{}).Valid("bob") {
+		t.Error("no users are valid without a list")
+	}
+	if err := users.Set("root,[a"); err == nil {
+		t.Error("Set with an invalid pattern succeeded")
+	}
+	if _, accepted := (Auth{Users: Users{"root"}}).AcceptPassword("bob", "123456"); accepted {
+		t.Error("password of an invalid user accepted")
+	}
+}
@@ -0,0 +1,331 @@
+// Package config holds the settings shared by the server and the channel and
+// request handlers.
+package config
+
+import (
+	"fmt"
+	"text/template"
+	"time"
+)
+
+// Config is the complete configuration of a running server.
+type Config struct {
+	Auth        Auth
+	Capture     Capture
+	Disconnects Disconnects
+	Forwarding  Forwarding
+	// Interactions are the interaction levels of client categories.
+	Interactions Interactions
+	Jump         Jump
+	Limits       Limits
+	Requests     Requests
+	Shadow       Shadow
+	Shell        Shell
+	SlowBanner   SlowBanner
+	Sticky       Sticky
+	Subsystems   Subsystems
+	Tarpit       Tarpit
+}
+
+// Capture configures saving the raw traffic of connections, as the SSH
+// transport sends and receives it, for offline analysis.
+type Capture struct {
+	// Dir, if set, is the directory the traffic of every connection is
+	// saved to, in Format.
+	Dir    string
+	Format CaptureFormat
+	// MaxBytes, if positive, is how many bytes of each direction of a
+	// connection are saved at most.
+	MaxBytes int64
+}
+
+// CaptureFormat is the file format of traffic captures: pcap, with made up
+// TCP/IP headers around the bytes exchanged, or raw, the bytes received and
+// those sent in two files. It implements flag.Value, rejecting other
+// formats.
+type CaptureFormat string
+
+func (format *CaptureFormat) String() string {
+	if format == nil {
+		return ""
+	}
+	return string(*format)
+}
+
+// Set implements flag.Value.
+func (format *CaptureFormat) Set(text string) error {
+	if text != "pcap" && text != "raw" {
+		return fmt.Errorf("unknown capture format %q, must be pcap or raw", text)
+	}
+	*format = CaptureFormat(text)
+	return nil
+}
+
+// SlowBanner configures sending the server's identification line slowly, to
+// study how clients handle slow servers and waste their time.
+type SlowBanner struct {
+	// ChunkSize is how many bytes of the identification line are sent at
+	// once. 0 disables the slow banner.
+	ChunkSize int
+	// Delay is how long to wait between chunks.
+	Delay time.Duration
+}
+
+// Tarpit configures wasting the time of clients like endlessh, while still
+// serving them: random lines are sent before the identification line, and
+// authentication attempts, channels and requests are answered slowly.
+type Tarpit struct {
+	Enabled bool
+	// Lines random lines are sent before the identification line, LineDelay
+	// apart, or lines until the client leaves if 0.
+	Lines     int
+	LineDelay time.Duration
+	// AuthDelay is how long every authentication attempt waits before being
+	// answered, and ReplyDelay every channel and request.
+	AuthDelay  time.Duration
+	ReplyDelay time.Duration
+}
+
+// Forwarding configures the sandbox mode of remote forwarding, which really
+// listens for tcpip-forward requests and relays connections to the client,
+// and how direct-tcpip channels are answered.
+type Forwarding struct {
+	// Sandbox enables the sandbox mode, remote forwards are only pretended
+	// otherwise.
+	Sandbox bool
+	// MaxForwards caps the number of remote forwards of a connection.
+	MaxForwards int
+	// MaxBytes and MaxDuration cap how much a forwarded connection may relay
+	// in both directions and how long it stays open.
+	MaxBytes    int64
+	MaxDuration time.Duration
+	// Sniff answers direct-tcpip channels like SMTP and HTTP servers would,
+	// logging the mails and requests clients send through them, rather than
+	// only logging their data.
+	Sniff bool
+}
+
+// Jump configures how clients using the host as a jump host, opening
+// direct-tcpip channels to SSH ports of other hosts, are answered.
+type Jump struct {
+	// Ports are the destination ports of direct-tcpip channels taken for
+	// SSH.
+	Ports []int
+	// Emulate serves another fake host over such channels, so that clients
+	// jump into it, rather than only logging them.
+	Emulate bool
+	// MaxDepth caps how many jumps deep clients may go, 0 being the host
+	// they connected to.
+	MaxDepth int
+	// MaxDuration caps how long a jump hop stays open.
+	MaxDuration time.Duration
+}
+
+// SSHPort reports whether port is one of Ports.
+func (jump Jump) SSHPort(port uint32) bool {
+	for _, sshPort := range jump.Ports {
+		if uint32(sshPort) == port {
+			return true
+		}
+	}
+	return false
+}
+
+// Limits bounds what clients can make the server process.
+type Limits struct {
+	// MaxPayloadSize is the largest request payload or channel extra data
+	// accepted, in bytes. Larger ones are rejected as malformed. 0 disables
+	// the limit.
+	MaxPayloadSize int
+	// IdentificationTimeout is how long clients have to send their SSH
+	// identification line. 0 disables the timeout.
+	IdentificationTimeout time.Duration
+	// MaxConnectionBytes and MaxConnectionLifetime are the most bytes a
+	// connection may transfer across all its channels and the longest it may
+	// stay open, after which it's closed. 0 disables either limit.
+	MaxConnectionBytes    int64
+	MaxConnectionLifetime time.Duration
+	// MaxConnectionsPerIP and MaxGoroutinesPerIP cap the connections open at
+	// once from a source IP and the goroutines serving them, one for every
+	// connection and channel. 0 disables either limit.
+	MaxConnectionsPerIP int
+	MaxGoroutinesPerIP  int
+	// MaxConnections caps the connections open at once across all listeners
+	// and personalities. 0 disables the limit.
+	MaxConnections int
+	// Source IPs opening more than MaxConnectionRate connections within
+	// ConnectionRateWindow have their connections closed for
+	// ConnectionRateBan. 0 disables rate limiting.
+	MaxConnectionRate    int
+	ConnectionRateWindow time.Duration
+	ConnectionRateBan    time.Duration
+	// ChannelIdleTimeout is how long channels may go without receiving a
+	// request or data, before a session channel starts a program, after
+	// which they are closed. 0 disables the timeout.
+	ChannelIdleTimeout time.Duration
+	// MaxSessionDuration is the longest an authenticated connection may
+	// last, SessionIdleTimeout how long its channels may together go
+	// without receiving data or requests, MaxChannelBytes the most bytes
+	// any of its channels may read and MaxChannelsPerConnection the most
+	// channels it may open, after which it's disconnected. 0 disables any of
+	// them.
+	MaxSessionDuration       time.Duration
+	SessionIdleTimeout       time.Duration
+	MaxChannelBytes          int64
+	MaxChannelsPerConnection int
+}
+
+// Requests configures how channel requests are answered.
+type Requests struct {
+	// AcceptXonXoff accepts xon-xoff requests, which OpenSSH rejects as only
+	// servers are meant to send them.
+	AcceptXonXoff bool
+	// ProbeAgent lists the keys of the agent clients forward with
+	// auth-agent-req@openssh.com requests. Nothing is ever signed.
+	ProbeAgent bool
+	// FakeAgent serves a fake agent holding AgentKeys to clients that
+	// requested agent forwarding, over the auth-agent@openssh.com channels
+	// they open and to ssh-add in their shells, logging what it's asked to
+	// sign.
+	FakeAgent bool
+	AgentKeys []AgentKey
+}
+
+// Subsystems configures how subsystem requests are answered.
+type Subsystems struct {
+	// AcceptUnknown accepts requests for subsystems that aren't emulated and
+	// logs their input, rather than rejecting them.
+	AcceptUnknown bool
+	// MaxCaptureBytes and MaxCaptureDuration cap how much input of
+	// subsystems that aren't emulated is captured and for how long, after
+	// which the channel is closed. 0 disables either cap.
+	MaxCaptureBytes    int
+	MaxCaptureDuration time.Duration
+	// CaptureDir, if set, is where the input of subsystems that aren't
+	// emulated is saved to.
+	CaptureDir string
+	// MaxUploadSize is the largest file clients can upload over SFTP or
+	// SCP, larger uploads failing. 0 disables the limit.
+	MaxUploadSize int
+}
+
+// Shadow configures relaying session channels to a real backend host instead
+// of emulating them.
+type Shadow struct {
+	// Backend is the address of the backend host, shadow mode is disabled if
+	// empty.
+	Backend string
+	// AllowedBackends must include Backend, as a safeguard against relaying
+	// to the wrong host.
+	AllowedBackends []string
+	// BackendFingerprint is the SHA256 fingerprint of the backend's host key.
+	BackendFingerprint string
+	// User and Password authenticate to the backend.
+	User     string
+	Password string
+	// MaxSessions caps the number of sessions relayed at once, further ones
+	// are emulated.
+	MaxSessions int
+	// MaxDuration caps how long a session is relayed for.
+	MaxDuration time.Duration
+}
+
+// Shell configures the emulated shell.
+type Shell struct {
+	// CommandDelay is the minimum time to wait before a command's output is written.
+	CommandDelay time.Duration
+	// CommandJitter is the maximum random time added to CommandDelay.
+	CommandJitter time.Duration
+	// CommandDelayPerKB is added to the delay for every KiB of output, to mimic transfer time.
+	CommandDelayPerKB time.Duration
+	// LogKeystrokes logs every chunk of terminal input with its timing. This is
+	// high-volume and captures everything typed, including corrections.
+	LogKeystrokes bool
+	// DenyPTY rejects pty-req requests like a restricted server would,
+	// shells then run without a terminal.
+	DenyPTY bool
+	// DenyShell rejects shell requests, commands and subsystems still run.
+	DenyShell bool
+	// Restricted emulates a restricted shell like rbash, rejecting commands
+	// that change directory, redirect output or name a path.
+	Restricted bool
+	// Profile is the name of the distribution, kernel and hardware profile
+	// fake hosts follow, the default one if empty.
+	Profile string
+	// Prompt is the prompt of interactive shells, with the escapes of bash's
+	// PS1, the system profile's if empty.
+	Prompt string
+	// MOTD, if set, is rendered with MOTDData when interactive shells start,
+	// in place of the last login.
+	MOTD *template.Template
+	// IdleTimeout, if set, ends interactive shells that don't receive a line
+	// for this long, like bash's TMOUT.
+	IdleTimeout time.Duration
+	// Responses are canned responses answering commands before the emulated
+	// ones.
+	Responses Responses
+	// Files are the contents of fake files read before the emulated ones.
+	Files FileContents
+	// Filesystem, if set, holds files and directories the fake host has
+	// besides the emulated ones, under Files.
+	Filesystem *Filesystem
+	// RecordingDir, if set, is where interactive shell sessions are recorded
+	// to, in RecordingFormat.
+	RecordingDir    string
+	RecordingFormat RecordingFormat
+}
+
+// RecordingFormat is the file format of session recordings, asciicast or
+// ttyrec. It implements flag.Value, rejecting other formats.
+type RecordingFormat string
+
+func (format *RecordingFormat) String() string {
+	if format == nil {
+		return ""
+	}
+	return string(*format)
+}
+
+// Set implements flag.Value.
+func (format *RecordingFormat) Set(text string) error {
+	if text != "asciicast" && text != "ttyrec" {
+		return fmt.Errorf("unknown recording format %q, must be asciicast or ttyrec", text)
+	}
+	*format = RecordingFormat(text)
+	return nil
+}
+
+// Sticky configures presenting returning clients the fake host they saw
+// before.
+type Sticky struct {
+	// By is what identifies returning clients, ip or credential, or empty
+	// for a new host every connection.
+	By StickyKey
+	// Salt is mixed into the seeds of hosts, so that other servers present
+	// other hosts to the same clients.
+	Salt string
+	// Window is how long the changes clients made to their host are kept
+	// after they leave.
+	Window time.Duration
+}
+
+// StickyKey is what identifies returning clients: ip for their address, or
+// credential for the user and credential they authenticated with. It
+// implements flag.Value, rejecting other keys.
+type StickyKey string
+
+func (key *StickyKey) String() string {
+	if key == nil {
+		return ""
+	}
+	return string(*key)
+}
+
+// Set implements flag.Value.
+func (key *StickyKey) Set(text string) error {
+	if text != "ip" && text != "credential" {
+		return fmt.Errorf("unknown sticky key %q, must be ip or credential", text)
+	}
+	*key = StickyKey(text)
+	return nil
+}
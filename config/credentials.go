@@ -0,0 +1,147 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Credential is a user and password pair that is accepted. Either may be a
+// pattern as understood by path.Match, e.g. * matches anything.
+type Credential struct {
+	User, Password string
+	// Source is the file and line the credential was read from.
+	Source string
+}
+
+// Credentials are the only user and password pairs accepted, if set.
+type Credentials []Credential
+
+// Match returns the first of the credentials user and password match, if
+// any.
+func (credentials Credentials) Match(user, password string) (Credential, bool) {
+	for _, credential := range credentials {
+		userMatches, _ := path.Match(credential.User, user)
+		passwordMatches, _ := path.Match(credential.Password, password)
+		if userMatches && passwordMatches {
+			return credential, true
+		}
+	}
+	return Credential{}, false
+}
+
+// CredentialsFiles are credentials files, in increasing order of precedence.
+// It implements flag.Value, appending a file every time it's set.
+type CredentialsFiles []string
+
+func (files *CredentialsFiles) String() string {
+	if files == nil {
+		return ""
+	}
+	return strings.Join(*files, ",")
+}
+
+// Set implements flag.Value.
+func (files *CredentialsFiles) Set(text string) error {
+	*files = append(*files, text)
+	return nil
+}
+
+// CredentialSources are the credentials merged from several files, which can
+// be reloaded. The credentials of a file override those of the files before
+// it for the same user pattern, and are matched first. CredentialSources are
+// shared by every copy of the configuration, so reloading applies to all of
+// them.
+type CredentialSources struct {
+	files CredentialsFiles
+
+	mu          sync.RWMutex
+	credentials Credentials
+}
+
+// LoadCredentialSources reads and merges the credentials of files.
+func LoadCredentialSources(files CredentialsFiles) (*CredentialSources, error) {
+	sources := &CredentialSources{files: files}
+	if err := sources.Reload(); err != nil {
+		return nil, err
+	}
+	return sources, nil
+}
+
+// Reload reads the files again, keeping the credentials loaded before if any
+// of them can't be read.
+func (sources *CredentialSources) Reload() error {
+	merged := Credentials{}
+	for _, file := range sources.files {
+		credentials, err := LoadCredentials(file)
+		if err != nil {
+			return err
+		}
+		overridden := map[string]bool{}
+		for _, credential := range credentials {
+			overridden[credential.User] = true
+		}
+		kept := Credentials{}
+		for _, credential := range merged {
+			if !overridden[credential.User] {
+				kept = append(kept, credential)
+			}
+		}
+		merged = append(credentials, kept...)
+	}
+	sources.mu.Lock()
+	defer sources.mu.Unlock()
+	sources.credentials = merged
+	return nil
+}
+
+// Len returns the number of credentials.
+func (sources *CredentialSources) Len() int {
+	sources.mu.RLock()
+	defer sources.mu.RUnlock()
+	return len(sources.credentials)
+}
+
+// Match returns the credential of the highest precedence user and password
+// match, if any.
+func (sources *CredentialSources) Match(user, password string) (Credential, bool) {
+	sources.mu.RLock()
+	defer sources.mu.RUnlock()
+	return sources.credentials.Match(user, password)
+}
+
+// LoadCredentials reads credentials from a file with a user:password pair per
+// line. The password is everything after the first colon. Empty lines and
+// lines starting with # are ignored.
+func LoadCredentials(filePath string) (Credentials, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	credentials := Credentials{}
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%v:%v: missing colon between user and password", filePath, number)
+		}
+		for _, pattern := range parts {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("%v:%v: invalid pattern %q", filePath, number, pattern)
+			}
+		}
+		credentials = append(credentials, Credential{parts[0], parts[1], fmt.Sprintf("%v:%v", filePath, number)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestCredentialSources(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"weak.txt":  "# Default credentials\nroot:123456\nadmin:*\n\n*:changeme\r\n",
+		"local.txt": "root:toor\n",
+	})
+	sources, err := LoadCredentialSources(CredentialsFiles{filepath.Join(dir, "weak.txt"), filepath.Join(dir, "local.txt")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		user, password string
+		accepted       bool
+		source         string
+	}{
+		{"root", "toor", true, "local.txt:1"},
+		// local.txt overrides the credentials of root.
+		{"root", "123456", false, ""},
+		{"admin", "anything", true, "weak.txt:3"},
+		{"oracle", "changeme", true, "weak.txt:5"},
+		{"oracle", "oracle", false, ""},
+		{"# Default credentials", "", false, ""},
+	} {
+		credential, accepted := sources.Match(test.user, test.password)
+		if accepted != test.accepted || accepted && credential.Source != filepath.Join(dir, test.source) {
+			t.Errorf("Match(%q, %q) = %v, %v, want %v from %v", test.user, test.password, credential, accepted, test.accepted, test.source)
+		}
+	}
+}
+
+func TestCredentialSourcesReload(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"weak.txt": "root:123456\n"})
+	file := filepath.Join(dir, "weak.txt")
+	sources, err := LoadCredentialSources(CredentialsFiles{file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, []byte("root:password\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := sources.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, accepted := sources.Match("root", "password"); !accepted {
+		t.Error("credential added to the file rejected after reloading")
+	}
+	if _, accepted := sources.Match("root", "123456"); accepted {
+		t.Error("credential removed from the file accepted after reloading")
+	}
+
+	// Credentials are kept if the file becomes invalid.
+	if err := ioutil.WriteFile(file, []byte("root\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := sources.Reload(); err == nil {
+		t.Error("reloading an invalid file succeeded")
+	}
+	if _, accepted := sources.Match("root", "password"); !accepted {
+		t.Error("credentials lost reloading an invalid file")
+	}
+}
+
+func TestLoadCredentialsInvalid(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"colon.txt": "root\n", "pattern.txt": "root:[\n"})
+	for _, name := range []string{"colon.txt", "pattern.txt", "missing.txt"} {
+		if _, err := LoadCredentials(filepath.Join(dir, name)); err == nil {
+			t.Errorf("LoadCredentials(%v) succeeded", name)
+		}
+	}
+}
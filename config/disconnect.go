@@ -0,0 +1,71 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Disconnect is what clients are told when their connection is closed for a
+// policy reason, as an SSH_MSG_DISCONNECT.
+type Disconnect struct {
+	// Code is the reason code of RFC 4253 section 11.1.
+	Code    uint32
+	Message string
+}
+
+// Disconnects are the disconnects sent for every policy reason. They
+// implement flag.Value, setting <reason>=<message> pairs.
+type Disconnects map[string]Disconnect
+
+// DefaultDisconnects returns the disconnects sent by default, worded like
+// OpenSSH.
+func DefaultDisconnects() Disconnects {
+	return Disconnects{
+		// SSH_DISCONNECT_HOST_NOT_ALLOWED_TO_CONNECT
+		"denylisted": {1, "Not allowed at this time"},
+		// SSH_DISCONNECT_PROTOCOL_ERROR, which OpenSSH uses for too many
+		// authentication failures.
+		"blocked": {2, "Too many authentication failures"},
+		// SSH_DISCONNECT_TOO_MANY_CONNECTIONS
+		"per_ip_limit": {12, "Too many connections"},
+		// SSH_DISCONNECT_BY_APPLICATION for the limits of sessions.
+		"session_duration": {11, "Session time limit exceeded"},
+		"session_idle":     {11, "Timeout, session idle"},
+		"channel_bytes":    {11, "Channel data limit exceeded"},
+		"max_channels":     {11, "Too many channels"},
+	}
+}
+
+func (disconnects *Disconnects) String() string {
+	if disconnects == nil {
+		return ""
+	}
+	texts := []string{}
+	for reason, disconnect := range *disconnects {
+		texts = append(texts, reason+"="+disconnect.Message)
+	}
+	sort.Strings(texts)
+	return strings.Join(texts, " ")
+}
+
+// Set implements flag.Value.
+func (disconnects *Disconnects) Set(text string) error {
+	separator := strings.Index(text, "=")
+	if separator == -1 {
+		return fmt.Errorf("invalid disconnect message %q, must be <reason>=<message>", text)
+	}
+	reason, message := text[:separator], text[separator+1:]
+	disconnect, ok := (*disconnects)[reason]
+	if !ok {
+		reasons := []string{}
+		for reason := range *disconnects {
+			reasons = append(reasons, reason)
+		}
+		sort.Strings(reasons)
+		return fmt.Errorf("unknown disconnect reason %q, must be one of %v", reason, strings.Join(reasons, ", "))
+	}
+	disconnect.Message = message
+	(*disconnects)[reason] = disconnect
+	return nil
+}
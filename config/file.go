@@ -0,0 +1,289 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// Setting is a flag set in a configuration file.
+type Setting struct {
+	Name string
+	// Values are the values the flag is set to in turn, several for the
+	// arrays of flags that can be repeated.
+	Values []string
+	// Source is the file and line the setting was read from.
+	Source string
+}
+
+// Settings are the settings of a configuration file, in the order of the
+// file.
+type Settings []Setting
+
+// LoadSettings reads a configuration file of flag settings, in TOML: every
+// key is a flag name and its value a string, number, boolean or array of
+// those, the flag being set to every element of arrays in turn. Tables
+// aren't supported, settings being named like flags.
+func LoadSettings(filePath string) (Settings, error) {
+	text, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	parser := &tomlParser{text: string(text), filePath: filePath, line: 1}
+	settings := Settings{}
+	seen := map[string]bool{}
+	for {
+		parser.skipBlank(true)
+		if parser.done() {
+			return settings, nil
+		}
+		line := parser.line
+		if parser.peek() == '[' {
+			return nil, parser.errorf("tables aren't supported, settings are named like flags")
+		}
+		name, err := parser.key()
+		if err != nil {
+			return nil, err
+		}
+		if seen[name] {
+			return nil, parser.errorf("%v is set twice", name)
+		}
+		seen[name] = true
+		parser.skipBlank(false)
+		if parser.done() || parser.peek() != '=' {
+			return nil, parser.errorf("missing = after %v", name)
+		}
+		parser.pos++
+		parser.skipBlank(false)
+		values, err := parser.value()
+		if err != nil {
+			return nil, err
+		}
+		parser.skipBlank(false)
+		if !parser.done() && parser.peek() != '\n' {
+			return nil, parser.errorf("unexpected %q after the value of %v", parser.peek(), name)
+		}
+		settings = append(settings, Setting{name, values, fmt.Sprintf("%v:%v", filePath, line)})
+	}
+}
+
+// Lookup returns the setting called name, if any.
+func (settings Settings) Lookup(name string) (Setting, bool) {
+	for _, setting := range settings {
+		if setting.Name == name {
+			return setting, true
+		}
+	}
+	return Setting{}, false
+}
+
+// Apply sets the flags of flags to the settings, but for those skip returns
+// true for, such as the ones already set on the command line.
+func (settings Settings) Apply(flags *flag.FlagSet, skip func(name string) bool) error {
+	for _, setting := range settings {
+		if flags.Lookup(setting.Name) == nil {
+			return fmt.Errorf("%v: unknown setting %v", setting.Source, setting.Name)
+		}
+		if skip(setting.Name) {
+			continue
+		}
+		for _, value := range setting.Values {
+			if err := flags.Set(setting.Name, value); err != nil {
+				return fmt.Errorf("%v: invalid value %q for %v: %w", setting.Source, value, setting.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// tomlParser parses the subset of TOML configuration files use.
+type tomlParser struct {
+	text     string
+	filePath string
+	pos      int
+	line     int
+}
+
+func (parser *tomlParser) done() bool {
+	return parser.pos >= len(parser.text)
+}
+
+func (parser *tomlParser) peek() byte {
+	return parser.text[parser.pos]
+}
+
+func (parser *tomlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%v:%v: %v", parser.filePath, parser.line, fmt.Sprintf(format, args...))
+}
+
+// skipBlank skips spaces and comments, and line breaks too if newlines is
+// set.
+func (parser *tomlParser) skipBlank(newlines bool) {
+	for !parser.done() {
+		switch parser.peek() {
+		case ' ', '\t', '\r':
+		case '\n':
+			if !newlines {
+				return
+			}
+			parser.line++
+		case '#':
+			for !parser.done() && parser.peek() != '\n' {
+				parser.pos++
+			}
+			continue
+		default:
+			return
+		}
+		parser.pos++
+	}
+}
+
+// key parses a bare or quoted key.
+func (parser *tomlParser) key() (string, error) {
+	if c := parser.peek(); c == '"' || c == '\'' {
+		return parser.string()
+	}
+	start := parser.pos
+	for !parser.done() && isBareKeyByte(parser.peek()) {
+		parser.pos++
+	}
+	if parser.pos == start {
+		return "", parser.errorf("invalid key starting with %q", parser.peek())
+	}
+	key := parser.text[start:parser.pos]
+	if !parser.done() && parser.peek() == '.' {
+		return "", parser.errorf("dotted keys aren't supported, settings are named like flags")
+	}
+	return key, nil
+}
+
+func isBareKeyByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// value parses a value, returning the elements of arrays.
+func (parser *tomlParser) value() ([]string, error) {
+	if parser.done() || parser.peek() == '\n' {
+		return nil, parser.errorf("missing value")
+	}
+	if parser.peek() != '[' {
+		value, err := parser.scalar()
+		if err != nil {
+			return nil, err
+		}
+		return []string{value}, nil
+	}
+	parser.pos++
+	values := []string{}
+	for {
+		parser.skipBlank(true)
+		if parser.done() {
+			return nil, parser.errorf("unterminated array")
+		}
+		if parser.peek() == ']' {
+			parser.pos++
+			return values, nil
+		}
+		if parser.peek() == '[' {
+			return nil, parser.errorf("nested arrays aren't supported")
+		}
+		value, err := parser.scalar()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		parser.skipBlank(true)
+		if !parser.done() && parser.peek() == ',' {
+			parser.pos++
+		} else if parser.done() || parser.peek() != ']' {
+			return nil, parser.errorf("missing , between array elements")
+		}
+	}
+}
+
+// scalar parses a string, number or boolean, returning it as the text a
+// flag is set to.
+func (parser *tomlParser) scalar() (string, error) {
+	if c := parser.peek(); c == '"' || c == '\'' {
+		return parser.string()
+	}
+	start := parser.pos
+	for !parser.done() && strings.IndexByte(" \t\r\n#,]", parser.peek()) < 0 {
+		parser.pos++
+	}
+	text := parser.text[start:parser.pos]
+	switch {
+	case text == "true" || text == "false":
+		return text, nil
+	case text == "":
+		return "", parser.errorf("missing value")
+	}
+	number := strings.ReplaceAll(text, "_", "")
+	if _, err := strconv.ParseInt(number, 0, 64); err == nil {
+		return strings.TrimPrefix(number, "+"), nil
+	}
+	if _, err := strconv.ParseFloat(number, 64); err == nil && !strings.ContainsAny(number, "xXpP") {
+		return strings.TrimPrefix(number, "+"), nil
+	}
+	return "", parser.errorf("invalid value %q, strings must be quoted", text)
+}
+
+// string parses a basic string, with escapes, or a literal string. Multiline
+// strings aren't supported.
+func (parser *tomlParser) string() (string, error) {
+	quote := parser.peek()
+	parser.pos++
+	var value strings.Builder
+	for {
+		if parser.done() || parser.peek() == '\n' {
+			return "", parser.errorf("unterminated string")
+		}
+		c := parser.peek()
+		parser.pos++
+		switch {
+		case c == quote:
+			return value.String(), nil
+		case c == '\\' && quote == '"':
+			if parser.done() {
+				return "", parser.errorf("unterminated string")
+			}
+			escape := parser.peek()
+			parser.pos++
+			switch escape {
+			case 'b':
+				value.WriteByte('\b')
+			case 't':
+				value.WriteByte('\t')
+			case 'n':
+				value.WriteByte('\n')
+			case 'f':
+				value.WriteByte('\f')
+			case 'r':
+				value.WriteByte('\r')
+			case '"', '\\':
+				value.WriteByte(escape)
+			case 'u', 'U':
+				size := 4
+				if escape == 'U' {
+					size = 8
+				}
+				if parser.pos+size > len(parser.text) {
+					return "", parser.errorf("invalid escape \\%c", escape)
+				}
+				code, err := strconv.ParseUint(parser.text[parser.pos:parser.pos+size], 16, 32)
+				if err != nil {
+					return "", parser.errorf("invalid escape \\%c%v", escape, parser.text[parser.pos:parser.pos+size])
+				}
+				parser.pos += size
+				value.WriteRune(rune(code))
+			default:
+				return "", parser.errorf("invalid escape \\%c", escape)
+			}
+		default:
+			value.WriteByte(c)
+		}
+	}
+}
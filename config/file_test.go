@@ -0,0 +1,115 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeSettings writes text to a configuration file, returning its path.
+func writeSettings(t *testing.T, text string) string {
+	t.Helper()
+	filePath := filepath.Join(t.TempDir(), "sshesame.toml")
+	if err := ioutil.WriteFile(filePath, []byte(text), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return filePath
+}
+
+func TestLoadSettings(t *testing.T) {
+	filePath := writeSettings(t, `# sshesame
+server_version = "SSH-2.0-OpenSSH_8.2p1" # the version
+"auth_methods" = 'password,publickey'
+deny_pty = true
+max_connections = 1_000
+command_delay_jitter = +0.5
+shell_prompt = "\\u@\\h:\\w\\$ \t\u00e9"
+
+credentials_file = [
+	"/etc/sshesame/baseline",
+	'/etc/sshesame/local', # overrides
+]
+valid_users = []
+`)
+	settings, err := LoadSettings(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Settings{
+		{"server_version", []string{"SSH-2.0-OpenSSH_8.2p1"}, filePath + ":2"},
+		{"auth_methods", []string{"password,publickey"}, filePath + ":3"},
+		{"deny_pty", []string{"true"}, filePath + ":4"},
+		{"max_connections", []string{"1000"}, filePath + ":5"},
+		{"command_delay_jitter", []string{"0.5"}, filePath + ":6"},
+		{"shell_prompt", []string{"\\u@\\h:\\w\\$ \té"}, filePath + ":7"},
+		{"credentials_file", []string{"/etc/sshesame/baseline", "/etc/sshesame/local"}, filePath + ":9"},
+		{"valid_users", []string{}, filePath + ":13"},
+	}
+	if !reflect.DeepEqual(settings, want) {
+		t.Errorf("LoadSettings = %q, want %q", settings, want)
+	}
+	if setting, ok := settings.Lookup("deny_pty"); !ok || setting.Values[0] != "true" {
+		t.Errorf("Lookup(deny_pty) = %v, %v", setting, ok)
+	}
+	if _, ok := settings.Lookup("decoy"); ok {
+		t.Error("Lookup found a setting that isn't set")
+	}
+}
+
+func TestLoadSettingsInvalid(t *testing.T) {
+	for _, test := range []struct {
+		text, err string
+	}{
+		{"[server]\n", ":1: tables aren't supported, settings are named like flags"},
+		{"server.version = \"x\"\n", ":1: dotted keys aren't supported, settings are named like flags"},
+		{"a = 1\n\na = 2\n", ":3: a is set twice"},
+		{"a 1\n", ":1: missing = after a"},
+		{"a =\n", ":1: missing value"},
+		{"a = SSH-2.0\n", ":1: invalid value \"SSH-2.0\", strings must be quoted"},
+		{"a = \"x\" \"y\"\n", ":1: unexpected '\"' after the value of a"},
+		{"a = \"x\n\"\n", ":1: unterminated string"},
+		{"a = \"\\q\"\n", ":1: invalid escape \\q"},
+		{"a = [1 2]\n", ":1: missing , between array elements"},
+		{"a = [[1]]\n", ":1: nested arrays aren't supported"},
+		{"a = [1,\n", ":2: unterminated array"},
+		{"=1\n", ":1: invalid key starting with '='"},
+	} {
+		filePath := writeSettings(t, test.text)
+		if _, err := LoadSettings(filePath); err == nil || err.Error() != filePath+test.err {
+			t.Errorf("LoadSettings(%q) = %v, want %v", test.text, err, filePath+test.err)
+		}
+	}
+}
+
+func TestSettingsApply(t *testing.T) {
+	flags := flag.NewFlagSet("sshesame", flag.ContinueOnError)
+	version := flags.String("server_version", "", "")
+	denyPTY := flags.Bool("deny_pty", false, "")
+	files := CredentialsFiles{}
+	flags.Var(&files, "credentials_file", "")
+	settings := Settings{
+		{"server_version", []string{"SSH-2.0-OpenSSH_8.2p1"}, "sshesame.toml:1"},
+		{"deny_pty", []string{"true"}, "sshesame.toml:2"},
+		{"credentials_file", []string{"/etc/a", "/etc/b"}, "sshesame.toml:3"},
+	}
+	// Flags set on the command line are skipped.
+	if err := settings.Apply(flags, func(name string) bool { return name == "deny_pty" }); err != nil {
+		t.Fatal(err)
+	}
+	if *version != "SSH-2.0-OpenSSH_8.2p1" || *denyPTY || !reflect.DeepEqual(files, CredentialsFiles{"/etc/a", "/etc/b"}) {
+		t.Errorf("applied %q, %v and %q", *version, *denyPTY, files)
+	}
+	for _, test := range []struct {
+		setting Setting
+		err     string
+	}{
+		{Setting{"unknown", []string{"1"}, "sshesame.toml:4"}, "sshesame.toml:4: unknown setting unknown"},
+		{Setting{"deny_pty", []string{"maybe"}, "sshesame.toml:5"}, `sshesame.toml:5: invalid value "maybe" for deny_pty: parse error`},
+	} {
+		if err := (Settings{test.setting}).Apply(flags, func(string) bool { return false }); err == nil || err.Error() != test.err {
+			t.Errorf("applying %+v = %v, want %v", test.setting, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// FileContent is the content of the fake files matching a pattern.
+type FileContent struct {
+	// Path is the file the content was loaded from.
+	Path string
+	// Pattern is matched against absolute paths with path.Match.
+	Pattern  string
+	Template *template.Template
+	// Honeytoken marks files whose content is bait, reads of which are
+	// logged.
+	Honeytoken bool
+}
+
+// FileData is what file content templates are rendered with.
+type FileData struct {
+	User     string
+	Hostname string
+	ClientIP string
+	// Token is unique to the connection and file, to tell where leaked
+	// content was read.
+	Token string
+}
+
+// MOTDData is what the message of the day is rendered with.
+type MOTDData struct {
+	User     string
+	Hostname string
+	// ClientIP is the address of the client, which the user last logged in
+	// from at LastLogin, as formatted by sshd.
+	ClientIP  string
+	LastLogin string
+	// Kernel is the kernel release, Machine the hardware name and
+	// PrettyName the distribution of the system profile.
+	Kernel     string
+	Machine    string
+	PrettyName string
+}
+
+// FileContents are the contents of fake files, consulted before the
+// emulated ones.
+type FileContents []FileContent
+
+// Find returns the content of the file at the absolute path filePath, or nil.
+// The first matching pattern applies.
+func (contents FileContents) Find(filePath string) *FileContent {
+	for i := range contents {
+		if matched, _ := path.Match(contents[i].Pattern, filePath); matched {
+			return &contents[i]
+		}
+	}
+	return nil
+}
+
+// LoadFileContents loads the content of fake files from every file in dir, in
+// the order of their names. A file's content is a text/template rendered with
+// FileData, and starts with a header between two "---" lines setting:
+//
+//	path: <pattern of the paths the content is for, e.g. /home/*/.ssh/authorized_keys>
+//	honeytoken: <true or false>
+func LoadFileContents(dir string) (FileContents, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	contents := FileContents{}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		filePath := filepath.Join(dir, entry.Name())
+		data, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+		content, err := parseFileContent(filePath, string(data))
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", filePath, err)
+		}
+		contents = append(contents, content)
+	}
+	return contents, nil
+}
+
+func parseFileContent(filePath, data string) (FileContent, error) {
+	content := FileContent{Path: filePath}
+	if !strings.HasPrefix(data, "---\n") {
+		return content, fmt.Errorf("missing header")
+	}
+	end := strings.Index(data[len("---\n"):], "\n---\n")
+	if end == -1 {
+		return content, fmt.Errorf("unterminated header")
+	}
+	header := data[len("---\n") : len("---\n")+end]
+	body := data[len("---\n")+end+len("\n---\n"):]
+	scanner := bufio.NewScanner(strings.NewReader(header))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return content, fmt.Errorf("invalid header line %q", line)
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		switch key {
+		case "path":
+			if !path.IsAbs(value) {
+				return content, fmt.Errorf("path %q isn't absolute", value)
+			}
+			if _, err := path.Match(value, ""); err != nil {
+				return content, fmt.Errorf("invalid path %q: %v", value, err)
+			}
+			content.Pattern = value
+		case "honeytoken":
+			honeytoken, err := strconv.ParseBool(value)
+			if err != nil {
+				return content, fmt.Errorf("invalid honeytoken %q", value)
+			}
+			content.Honeytoken = honeytoken
+		default:
+			return content, fmt.Errorf("unknown header %q", key)
+		}
+	}
+	if content.Pattern == "" {
+		return content, fmt.Errorf("missing path")
+	}
+	parsed, err := template.New(filepath.Base(filePath)).Parse(body)
+	if err != nil {
+		return content, err
+	}
+	content.Template = parsed
+	return content, nil
+}
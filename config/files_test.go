@@ -0,0 +1,61 @@
+package config
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLoadFileContents(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"1-keys":  "---\npath: /home/*/.ssh/authorized_keys\nhoneytoken: true\n---\nssh-ed25519 AAAA {{.User}}@{{.Hostname}} {{.Token}}\n",
+		"2-logs":  "---\npath: /var/log/*\n---\nlogin from {{.ClientIP}}\n",
+		".hidden": "ignored\n",
+	})
+	contents, err := LoadFileContents(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contents) != 2 {
+		t.Fatalf("loaded %v file contents, want 2", len(contents))
+	}
+	content := contents.Find("/home/bob/.ssh/authorized_keys")
+	if content == nil || !content.Honeytoken || !strings.HasSuffix(content.Path, "1-keys") {
+		t.Fatalf("authorized_keys found %+v", content)
+	}
+	var rendered bytes.Buffer
+	if err := content.Template.Execute(&rendered, FileData{User: "bob", Hostname: "web-1", Token: "9f2c"}); err != nil {
+		t.Fatal(err)
+	}
+	if rendered.String() != "ssh-ed25519 AAAA bob@web-1 9f2c\n" {
+		t.Errorf("authorized_keys rendered %q", rendered.String())
+	}
+	if content := contents.Find("/var/log/auth.log"); content == nil || content.Honeytoken {
+		t.Errorf("auth.log found %+v, want the logs without a honeytoken", content)
+	}
+	for _, filePath := range []string{"/var/log/apt/history.log", "/home/bob/.ssh/known_hosts", "/root/.bash_history"} {
+		if content := contents.Find(filePath); content != nil {
+			t.Errorf("%v found %+v", filePath, content)
+		}
+	}
+}
+
+func TestLoadFileContentsInvalid(t *testing.T) {
+	for _, test := range []struct {
+		files map[string]string
+		err   string
+	}{
+		{map[string]string{"a": "content\n"}, "missing header"},
+		{map[string]string{"a": "---\nhoneytoken: true\n---\n"}, "missing path"},
+		{map[string]string{"a": "---\npath: etc/passwd\n---\n"}, "isn't absolute"},
+		{map[string]string{"a": "---\npath: /etc/[\n---\n"}, "invalid path"},
+		{map[string]string{"a": "---\npath: /etc/motd\nhoneytoken: maybe\n---\n"}, "invalid honeytoken"},
+		{map[string]string{"a": "---\npath: /etc/motd\nmode: 0600\n---\n"}, "unknown header"},
+		{map[string]string{"a": "---\npath: /etc/motd\n"}, "unterminated header"},
+		{map[string]string{"a": "---\npath: /etc/motd\n---\n{{.User\n"}, "unclosed action"},
+	} {
+		if _, err := LoadFileContents(writeFiles(t, test.files)); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("LoadFileContents(%q) = %v, want an error with %q", test.files, err, test.err)
+		}
+	}
+}
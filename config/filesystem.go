@@ -0,0 +1,198 @@
+package config
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FilesystemEntry is a file or directory of a seeded filesystem.
+type FilesystemEntry struct {
+	Path    string
+	Dir     bool
+	Content []byte
+	// Mode are the permission bits, e.g. 0644.
+	Mode    uint32
+	ModTime time.Time
+}
+
+// Filesystem is what fake hosts have on disk besides what's emulated, the
+// same for every session, which clients then change in their own session
+// only. Directories are implied by the paths of their contents.
+type Filesystem struct {
+	entries map[string]*FilesystemEntry
+	// children are the names of the entries of every directory.
+	children map[string]map[string]bool
+}
+
+// jsonFilesystemEntry is an entry of a JSON filesystem description, whose
+// mode is in octal and modification time in RFC 3339.
+type jsonFilesystemEntry struct {
+	Path    string `json:"path"`
+	Dir     bool   `json:"dir"`
+	Content string `json:"content"`
+	Mode    string `json:"mode"`
+	ModTime string `json:"mtime"`
+}
+
+// LoadFilesystem loads a filesystem from file, a tarball, optionally
+// gzipped, or a JSON array of entries like:
+//
+//	{"path": "/var/www/html/index.html", "content": "<h1>It works!</h1>\n", "mode": "0644", "mtime": "2024-03-01T10:00:00Z"}
+//	{"path": "/opt/backup", "dir": true}
+//
+// Only regular files and directories of tarballs are kept.
+func LoadFilesystem(file string) (*Filesystem, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	filesystem := &Filesystem{entries: map[string]*FilesystemEntry{}, children: map[string]map[string]bool{}}
+	if strings.HasSuffix(file, ".json") {
+		err = filesystem.loadJSON(data)
+	} else {
+		err = filesystem.loadTar(file, data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", file, err)
+	}
+	return filesystem, nil
+}
+
+func (filesystem *Filesystem) loadJSON(data []byte) error {
+	entries := []jsonFilesystemEntry{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		parsed := FilesystemEntry{Path: entry.Path, Dir: entry.Dir, Content: []byte(entry.Content), Mode: 0644}
+		if entry.Dir {
+			parsed.Mode = 0755
+		}
+		if entry.Mode != "" {
+			mode, err := strconv.ParseUint(entry.Mode, 8, 32)
+			if err != nil || mode > 07777 {
+				return fmt.Errorf("invalid mode %q of %v", entry.Mode, entry.Path)
+			}
+			parsed.Mode = uint32(mode)
+		}
+		if entry.ModTime != "" {
+			modTime, err := time.Parse(time.RFC3339, entry.ModTime)
+			if err != nil {
+				return fmt.Errorf("invalid mtime %q of %v", entry.ModTime, entry.Path)
+			}
+			parsed.ModTime = modTime
+		}
+		if err := filesystem.add(parsed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (filesystem *Filesystem) loadTar(file string, data []byte) error {
+	var reader io.Reader = bytes.NewReader(data)
+	if strings.HasSuffix(file, ".gz") || strings.HasSuffix(file, ".tgz") {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return err
+		}
+		reader = gzipReader
+	}
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		entry := FilesystemEntry{Path: header.Name, Mode: uint32(header.Mode) & 07777, ModTime: header.ModTime}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			entry.Dir = true
+		case tar.TypeReg:
+			content, err := ioutil.ReadAll(tarReader)
+			if err != nil {
+				return err
+			}
+			entry.Content = content
+		default:
+			continue
+		}
+		if err := filesystem.add(entry); err != nil {
+			return err
+		}
+	}
+}
+
+// add adds entry, relative paths being relative to the root.
+func (filesystem *Filesystem) add(entry FilesystemEntry) error {
+	entry.Path = path.Clean("/" + entry.Path)
+	if entry.Path == "/" {
+		return nil
+	}
+	if _, ok := filesystem.children[entry.Path]; ok && !entry.Dir {
+		return fmt.Errorf("%v is both a file and a directory", entry.Path)
+	}
+	if existing, ok := filesystem.entries[entry.Path]; ok && existing.Dir != entry.Dir {
+		return fmt.Errorf("%v is both a file and a directory", entry.Path)
+	}
+	for dir := path.Dir(entry.Path); ; dir = path.Dir(dir) {
+		if parent, ok := filesystem.entries[dir]; ok && !parent.Dir {
+			return fmt.Errorf("%v is both a file and a directory", dir)
+		}
+		if dir == "/" {
+			break
+		}
+	}
+	filesystem.entries[entry.Path] = &entry
+	for child := entry.Path; child != "/"; child = path.Dir(child) {
+		dir := path.Dir(child)
+		if filesystem.children[dir] == nil {
+			filesystem.children[dir] = map[string]bool{}
+		}
+		filesystem.children[dir][path.Base(child)] = true
+	}
+	return nil
+}
+
+// Lookup returns the entry at the absolute filePath, directories implied by
+// the paths of their contents having no modification time. A nil filesystem
+// is empty.
+func (filesystem *Filesystem) Lookup(filePath string) (FilesystemEntry, bool) {
+	if filesystem == nil {
+		return FilesystemEntry{}, false
+	}
+	if entry, ok := filesystem.entries[filePath]; ok {
+		return *entry, true
+	}
+	if _, ok := filesystem.children[filePath]; ok {
+		return FilesystemEntry{Path: filePath, Dir: true, Mode: 0755}, true
+	}
+	return FilesystemEntry{}, false
+}
+
+// List returns the names of the entries of the directory at the absolute dir,
+// sorted.
+func (filesystem *Filesystem) List(dir string) []string {
+	if filesystem == nil {
+		return nil
+	}
+	names := make([]string, 0, len(filesystem.children[dir]))
+	for name := range filesystem.children[dir] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,119 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Honeytoken is a credential planted for attackers to find, e.g. in a
+// configuration file of another host, whose use shows where they found it.
+// It matches a password, or a public key by fingerprint, of User, or of any
+// user if User is empty.
+type Honeytoken struct {
+	// Name describes the token in alerts, e.g. where it was planted.
+	Name        string
+	User        string
+	Password    string
+	Fingerprint string
+}
+
+func (token Honeytoken) String() string {
+	parts := []string{}
+	if token.Name != "" {
+		parts = append(parts, "name="+token.Name)
+	}
+	if token.User != "" {
+		parts = append(parts, "user="+token.User)
+	}
+	if token.Fingerprint != "" {
+		parts = append(parts, "fingerprint="+token.Fingerprint)
+	} else {
+		parts = append(parts, "password="+token.Password)
+	}
+	return strings.Join(parts, ",")
+}
+
+// ParseHoneytoken parses a token of the form
+// [name=<name>,][user=<user>,]password=<password>|fingerprint=<fingerprint>.
+// The password comes last, so that it can contain commas.
+func ParseHoneytoken(text string) (Honeytoken, error) {
+	token := Honeytoken{}
+	hasPassword := false
+	for rest := text; rest != ""; {
+		part := rest
+		rest = ""
+		if !strings.HasPrefix(part, "password=") {
+			if comma := strings.IndexByte(part, ','); comma != -1 {
+				part, rest = part[:comma], part[comma+1:]
+			}
+		}
+		keyValue := strings.SplitN(part, "=", 2)
+		if len(keyValue) != 2 {
+			return token, fmt.Errorf("invalid honeytoken field %q, must be key=value", part)
+		}
+		switch keyValue[0] {
+		case "name":
+			token.Name = keyValue[1]
+		case "user":
+			token.User = keyValue[1]
+		case "password":
+			token.Password, hasPassword = keyValue[1], true
+		case "fingerprint":
+			if !strings.HasPrefix(keyValue[1], "SHA256:") {
+				return token, fmt.Errorf("invalid fingerprint %q, must be a SHA256 fingerprint", keyValue[1])
+			}
+			token.Fingerprint = keyValue[1]
+		default:
+			return token, fmt.Errorf("unknown honeytoken field %q", keyValue[0])
+		}
+	}
+	if hasPassword == (token.Fingerprint != "") {
+		return token, fmt.Errorf("invalid honeytoken %q, must have either a password or a fingerprint", text)
+	}
+	return token, nil
+}
+
+// Honeytokens is a list of honeytokens. It implements flag.Value, appending
+// a token every time it's set.
+type Honeytokens []Honeytoken
+
+func (tokens *Honeytokens) String() string {
+	if tokens == nil {
+		return ""
+	}
+	texts := make([]string, len(*tokens))
+	for i, token := range *tokens {
+		texts[i] = token.String()
+	}
+	return strings.Join(texts, " ")
+}
+
+// Set implements flag.Value.
+func (tokens *Honeytokens) Set(text string) error {
+	token, err := ParseHoneytoken(text)
+	if err != nil {
+		return err
+	}
+	*tokens = append(*tokens, token)
+	return nil
+}
+
+// MatchPassword returns the token user and password match, if any.
+func (tokens Honeytokens) MatchPassword(user, password string) (Honeytoken, bool) {
+	for _, token := range tokens {
+		if token.Fingerprint == "" && token.Password == password && (token.User == "" || token.User == user) {
+			return token, true
+		}
+	}
+	return Honeytoken{}, false
+}
+
+// MatchKey returns the token user and the key with fingerprint match, if any.
+func (tokens Honeytokens) MatchKey(user, fingerprint string) (Honeytoken, bool) {
+	for _, token := range tokens {
+		if token.Fingerprint != "" && token.Fingerprint == fingerprint && (token.User == "" || token.User == user) {
+			return token, true
+		}
+	}
+	return Honeytoken{}, false
+}
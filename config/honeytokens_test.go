@@ -0,0 +1,72 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestParseHoneytoken(t *testing.T) {
+	for _, test := range []struct {
+		text  string
+		token Honeytoken
+	}{
+		{"password=hunter2", Honeytoken{Password: "hunter2"}},
+		// Passwords come last, whatever they contain.
+		{"name=wiki,user=deploy,password=a,b=c", Honeytoken{Name: "wiki", User: "deploy", Password: "a,b=c"}},
+		{"password=", Honeytoken{}},
+		{"user=git,fingerprint=SHA256:uNiVztksCsDhcc0u9e8BujQXVUpKZIDTMczCvj3tD2s", Honeytoken{User: "git", Fingerprint: "SHA256:uNiVztksCsDhcc0u9e8BujQXVUpKZIDTMczCvj3tD2s"}},
+	} {
+		token, err := ParseHoneytoken(test.text)
+		if err != nil || token != test.token {
+			t.Errorf("ParseHoneytoken(%q) = %+v, %v, want %+v", test.text, token, err, test.token)
+		}
+		if text := token.String(); text != test.text {
+			t.Errorf("%+v formatted as %q, want %q", token, text, test.text)
+		}
+	}
+}
+
+func TestParseHoneytokenInvalid(t *testing.T) {
+	for _, text := range []string{
+		"",
+		"user=root",
+		"fingerprint=SHA256:x,password=a",
+		"fingerprint=MD5:16:27:ac:a5:76:28:2d:36:63:1b:56:4d:eb:df:a6:48",
+		"note=wiki,password=a",
+		"user",
+	} {
+		if token, err := ParseHoneytoken(text); err == nil {
+			t.Errorf("ParseHoneytoken(%q) = %+v", text, token)
+		}
+	}
+}
+
+func TestHoneytokensMatch(t *testing.T) {
+	tokens := Honeytokens{}
+	for _, text := range []string{"name=wiki,user=deploy,password=hunter2", "name=any,password=s3cret", "name=key,fingerprint=SHA256:abc"} {
+		if err := tokens.Set(text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, test := range []struct {
+		user, password, fingerprint string
+		name                        string
+	}{
+		{"deploy", "hunter2", "", "wiki"},
+		{"root", "hunter2", "", ""},
+		{"root", "s3cret", "", "any"},
+		{"root", "", "SHA256:abc", "key"},
+		// Keys don't match passwords, even equal to their fingerprint.
+		{"root", "SHA256:abc", "", ""},
+	} {
+		var token Honeytoken
+		var ok bool
+		if test.fingerprint != "" {
+			token, ok = tokens.MatchKey(test.user, test.fingerprint)
+		} else {
+			token, ok = tokens.MatchPassword(test.user, test.password)
+		}
+		if ok != (test.name != "") || token.Name != test.name {
+			t.Errorf("%+v matched %+v, %v", test, token, ok)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Interaction levels, from the most to the least emulated.
+const (
+	// InteractionFull emulates everything for the client.
+	InteractionFull = "full"
+	// InteractionExec denies pseudo-terminals and shells, commands and
+	// subsystems still run.
+	InteractionExec = "exec"
+	// InteractionAuth rejects every channel once the client authenticated,
+	// so that only its credentials are gathered.
+	InteractionAuth = "auth"
+)
+
+var interactionLevels = []string{InteractionFull, InteractionExec, InteractionAuth}
+
+// clientSoftware are the prefixes of the software versions clients identify
+// with, lower-cased, and the category of clients using them.
+var clientSoftware = []struct{ prefix, category string }{
+	{"openssh", "openssh"},
+	{"putty", "putty"},
+	{"winscp", "putty"},
+	{"dropbear", "dropbear"},
+	{"libssh", "libssh"},
+	{"paramiko", "paramiko"},
+	{"asyncssh", "asyncssh"},
+	{"jsch", "jsch"},
+	{"go", "go"},
+	{"zgrab", "scanner"},
+	{"nmap", "scanner"},
+	{"masscan", "scanner"},
+}
+
+// ClientCategories are the categories ClientCategory puts clients in.
+var ClientCategories = []string{"asyncssh", "dropbear", "go", "jsch", "libssh", "openssh", "other", "paramiko", "putty", "scanner"}
+
+// ClientCategory returns the category of the client that sent version, its
+// identification line.
+func ClientCategory(version string) string {
+	software := strings.TrimPrefix(strings.TrimPrefix(version, "SSH-2.0-"), "SSH-1.99-")
+	software = strings.ToLower(software)
+	for _, client := range clientSoftware {
+		if strings.HasPrefix(software, client.prefix) {
+			return client.category
+		}
+	}
+	return "other"
+}
+
+// Interactions maps client categories to the interaction level they get,
+// categories missing from it get full interaction. It implements flag.Value,
+// setting <category>=<level> pairs.
+type Interactions map[string]string
+
+// Level returns the interaction level of clients in category.
+func (interactions Interactions) Level(category string) string {
+	if level, ok := interactions[category]; ok {
+		return level
+	}
+	return InteractionFull
+}
+
+func (interactions *Interactions) String() string {
+	if interactions == nil {
+		return ""
+	}
+	pairs := []string{}
+	for category, level := range *interactions {
+		pairs = append(pairs, category+"="+level)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Set implements flag.Value.
+func (interactions *Interactions) Set(text string) error {
+	separator := strings.Index(text, "=")
+	if separator == -1 {
+		return fmt.Errorf("invalid client interaction %q, must be <category>=<level>", text)
+	}
+	category, level := text[:separator], text[separator+1:]
+	if !contains(ClientCategories, category) {
+		return fmt.Errorf("unknown client category %q, must be one of %v", category, strings.Join(ClientCategories, ", "))
+	}
+	if !contains(interactionLevels, level) {
+		return fmt.Errorf("unknown interaction level %q, must be one of %v", level, strings.Join(interactionLevels, ", "))
+	}
+	if *interactions == nil {
+		*interactions = Interactions{}
+	}
+	(*interactions)[category] = level
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
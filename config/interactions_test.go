@@ -0,0 +1,43 @@
+package config
+
+import "testing"
+
+func TestClientCategory(t *testing.T) {
+	for version, category := range map[string]string{
+		"SSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu13": "openssh",
+		"SSH-2.0-PuTTY_Release_0.80":             "putty",
+		"SSH-2.0-WinSCP_release_6.1.2":           "putty",
+		"SSH-2.0-libssh_0.9.6":                   "libssh",
+		"SSH-2.0-paramiko_3.4.0":                 "paramiko",
+		"SSH-2.0-Go":                             "go",
+		"SSH-2.0-ZGrab ZGrab SSH Survey":         "scanner",
+		"SSH-1.99-OpenSSH_3.9p1":                 "openssh",
+		"SSH-2.0-MyClient_1.0":                   "other",
+	} {
+		if got := ClientCategory(version); got != category {
+			t.Errorf("ClientCategory(%q) = %v, want %v", version, got, category)
+		}
+	}
+}
+
+func TestInteractions(t *testing.T) {
+	var interactions Interactions
+	for _, text := range []string{"scanner=auth", "go=exec", "scanner=auth"} {
+		if err := interactions.Set(text); err != nil {
+			t.Fatalf("Set(%q) = %v", text, err)
+		}
+	}
+	if interactions.String() != "go=exec,scanner=auth" {
+		t.Errorf("String() = %q", interactions.String())
+	}
+	for category, level := range map[string]string{"scanner": InteractionAuth, "go": InteractionExec, "openssh": InteractionFull} {
+		if got := interactions.Level(category); got != level {
+			t.Errorf("Level(%v) = %v, want %v", category, got, level)
+		}
+	}
+	for _, text := range []string{"scanner", "curl=auth", "scanner=none", "=full"} {
+		if err := interactions.Set(text); err == nil {
+			t.Errorf("Set(%q) succeeded, want an error", text)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Personality is a different host presented on a listener of its own, so that
+// a single process can run several distinct decoys.
+type Personality struct {
+	Name string
+	// ListenAddress is the host:port the personality is served on, within
+	// AddressFamily (any, ipv4 or ipv6), any if empty.
+	ListenAddress string
+	AddressFamily string
+	// HostKey, ServerVersion, CommandsDir and FilesDir replace the host key,
+	// identification, canned responses and fake file contents used on other
+	// listeners, if set.
+	HostKey       string
+	ServerVersion string
+	CommandsDir   string
+	FilesDir      string
+	// Restricted emulates a restricted shell on the personality's listener,
+	// Tarpit enables tarpit mode on it and DenyPTY rejects pseudo-terminal
+	// requests on it.
+	Restricted bool
+	Tarpit     bool
+	DenyPTY    bool
+	// Profile, if set, replaces the system profile of fake hosts.
+	Profile string
+	// AuthMethods, ValidUsers and CredentialsFiles, if set, replace the
+	// authentication methods offered, the valid users and the credentials
+	// files, and Decoy rejects every authentication attempt on the
+	// personality's listener.
+	AuthMethods      Methods
+	ValidUsers       Users
+	CredentialsFiles CredentialsFiles
+	Decoy            bool
+}
+
+func (personality Personality) String() string {
+	text := personality.Name + ",listen=" + personality.ListenAddress
+	for _, option := range []struct{ key, value string }{
+		{"host_key", personality.HostKey},
+		{"server_version", personality.ServerVersion},
+		{"commands_dir", personality.CommandsDir},
+		{"files_dir", personality.FilesDir},
+		{"address_family", personality.AddressFamily},
+		{"profile", personality.Profile},
+		{"auth_methods", strings.Join(personality.AuthMethods, ";")},
+		{"valid_users", strings.Join(personality.ValidUsers, ";")},
+		{"credentials_file", strings.Join(personality.CredentialsFiles, ";")},
+	} {
+		if option.value != "" {
+			text += "," + option.key + "=" + option.value
+		}
+	}
+	if personality.Restricted {
+		text += ",restricted=true"
+	}
+	if personality.Tarpit {
+		text += ",tarpit=true"
+	}
+	if personality.DenyPTY {
+		text += ",deny_pty=true"
+	}
+	if personality.Decoy {
+		text += ",decoy=true"
+	}
+	return text
+}
+
+// ParsePersonality parses a personality of the form
+// <name>,listen=<address>[,address_family=<family>][,host_key=<file>][,server_version=<version>][,commands_dir=<dir>][,files_dir=<dir>][,profile=<name>][,restricted=<bool>][,tarpit=<bool>][,deny_pty=<bool>][,auth_methods=<methods>][,valid_users=<users>][,credentials_file=<files>][,decoy=<bool>].
+// The lists of auth_methods, valid_users and credentials_file are separated
+// by semicolons.
+func ParsePersonality(text string) (Personality, error) {
+	parts := strings.Split(text, ",")
+	personality := Personality{Name: parts[0]}
+	if personality.Name == "" || strings.Contains(personality.Name, "=") {
+		return personality, fmt.Errorf("invalid personality %q, must start with a name", text)
+	}
+	for _, part := range parts[1:] {
+		keyValue := strings.SplitN(part, "=", 2)
+		if len(keyValue) != 2 {
+			return personality, fmt.Errorf("invalid option %q, must be key=value", part)
+		}
+		switch keyValue[0] {
+		case "listen":
+			personality.ListenAddress = keyValue[1]
+		case "address_family":
+			switch keyValue[1] {
+			case "any", "ipv4", "ipv6":
+				personality.AddressFamily = keyValue[1]
+			default:
+				return personality, fmt.Errorf("invalid address family %q, must be any, ipv4 or ipv6", keyValue[1])
+			}
+		case "host_key":
+			personality.HostKey = keyValue[1]
+		case "server_version":
+			if !strings.HasPrefix(keyValue[1], "SSH-2.0-") {
+				return personality, fmt.Errorf("invalid server version %q, must start with SSH-2.0-", keyValue[1])
+			}
+			personality.ServerVersion = keyValue[1]
+		case "commands_dir":
+			personality.CommandsDir = keyValue[1]
+		case "files_dir":
+			personality.FilesDir = keyValue[1]
+		case "profile":
+			personality.Profile = keyValue[1]
+		case "restricted":
+			restricted, err := strconv.ParseBool(keyValue[1])
+			if err != nil {
+				return personality, fmt.Errorf("invalid restricted option %q, must be a boolean", keyValue[1])
+			}
+			personality.Restricted = restricted
+		case "tarpit":
+			tarpit, err := strconv.ParseBool(keyValue[1])
+			if err != nil {
+				return personality, fmt.Errorf("invalid tarpit option %q, must be a boolean", keyValue[1])
+			}
+			personality.Tarpit = tarpit
+		case "deny_pty":
+			denyPTY, err := strconv.ParseBool(keyValue[1])
+			if err != nil {
+				return personality, fmt.Errorf("invalid deny_pty option %q, must be a boolean", keyValue[1])
+			}
+			personality.DenyPTY = denyPTY
+		case "auth_methods":
+			if err := personality.AuthMethods.Set(strings.ReplaceAll(keyValue[1], ";", ",")); err != nil {
+				return personality, err
+			}
+			if len(personality.AuthMethods) == 0 {
+				return personality, fmt.Errorf("invalid auth_methods option %q, no method is set", keyValue[1])
+			}
+		case "valid_users":
+			if err := personality.ValidUsers.Set(strings.ReplaceAll(keyValue[1], ";", ",")); err != nil {
+				return personality, err
+			}
+		case "credentials_file":
+			for _, file := range strings.Split(keyValue[1], ";") {
+				if file != "" {
+					personality.CredentialsFiles.Set(file)
+				}
+			}
+		case "decoy":
+			decoy, err := strconv.ParseBool(keyValue[1])
+			if err != nil {
+				return personality, fmt.Errorf("invalid decoy option %q, must be a boolean", keyValue[1])
+			}
+			personality.Decoy = decoy
+		default:
+			return personality, fmt.Errorf("unknown option %q", keyValue[0])
+		}
+	}
+	if personality.ListenAddress == "" {
+		return personality, fmt.Errorf("invalid personality %q, the listen address is missing", text)
+	}
+	return personality, nil
+}
+
+// Personalities implements flag.Value, appending a personality every time
+// it's set.
+type Personalities []Personality
+
+func (personalities *Personalities) String() string {
+	if personalities == nil {
+		return ""
+	}
+	texts := make([]string, len(*personalities))
+	for i, personality := range *personalities {
+		texts[i] = personality.String()
+	}
+	return strings.Join(texts, " ")
+}
+
+// Set implements flag.Value.
+func (personalities *Personalities) Set(text string) error {
+	personality, err := ParsePersonality(text)
+	if err != nil {
+		return err
+	}
+	for _, existing := range *personalities {
+		if existing.Name == personality.Name {
+			return fmt.Errorf("duplicate personality name %q", personality.Name)
+		}
+	}
+	*personalities = append(*personalities, personality)
+	return nil
+}
+
+// Named reports whether one of personalities is called name.
+func (personalities Personalities) Named(name string) bool {
+	for _, personality := range personalities {
+		if personality.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePersonality(t *testing.T) {
+	for _, text := range []string{
+		"alt,listen=:2222",
+		"alt,listen=:2222,profile=ubuntu-22.04,restricted=true,tarpit=true,deny_pty=true,decoy=true",
+		"alt,listen=:2222,address_family=ipv6,auth_methods=password;publickey,valid_users=root;admin*,credentials_file=/etc/a;/etc/b",
+	} {
+		personality, err := ParsePersonality(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if personality.String() != text {
+			t.Errorf("ParsePersonality(%q) = %v", text, personality)
+		}
+	}
+	personality, err := ParsePersonality("alt,listen=:2222,deny_pty=true")
+	if err != nil || !personality.DenyPTY {
+		t.Errorf("deny_pty=true parsed as %v, %v", personality.DenyPTY, err)
+	}
+	personality, err = ParsePersonality("alt,listen=:2222,address_family=ipv4,auth_methods=password;password,valid_users=root,credentials_file=/etc/a,decoy=true")
+	want := Personality{Name: "alt", ListenAddress: ":2222", AddressFamily: "ipv4", AuthMethods: Methods{"password"}, ValidUsers: Users{"root"}, CredentialsFiles: CredentialsFiles{"/etc/a"}, Decoy: true}
+	if err != nil || !reflect.DeepEqual(personality, want) {
+		t.Errorf("auth policy parsed as %+v, %v, want %+v", personality, err, want)
+	}
+	for _, text := range []string{
+		"alt",
+		"alt,listen=:2222,deny_pty=maybe",
+		"alt,listen=:2222,unknown=true",
+		"alt,listen=:2222,address_family=ipx",
+		"alt,listen=:2222,auth_methods=",
+		"alt,listen=:2222,auth_methods=password;telnet",
+		"alt,listen=:2222,decoy=maybe",
+	} {
+		if _, err := ParsePersonality(text); err == nil {
+			t.Errorf("ParsePersonality(%q) succeeded", text)
+		}
+	}
+}
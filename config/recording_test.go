@@ -0,0 +1,14 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestRecordingFormatValidated(t *testing.T) {
+	for text, valid := range map[string]bool{"asciicast": true, "ttyrec": true, "script": false, "": false} {
+		var format RecordingFormat
+		if err := format.Set(text); (err == nil) != valid {
+			t.Errorf("Set(%q) = %v, want valid %v", text, err, valid)
+		}
+	}
+}
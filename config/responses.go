@@ -0,0 +1,160 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Response is a canned response to a command.
+type Response struct {
+	// Path is the file the response was loaded from.
+	Path string
+	// Name is the command the response is for, matched against the first
+	// argument when Match is nil.
+	Name string
+	// Match, if set, is matched against the whole command line instead.
+	Match      *regexp.Regexp
+	Stdout     string
+	Stderr     string
+	ExitStatus int
+	// ExitSignal, if set, is the name of a signal the command dies of
+	// instead of exiting with ExitStatus, e.g. SEGV, reported along with
+	// CoreDumped and ErrorMessage.
+	ExitSignal   string
+	CoreDumped   bool
+	ErrorMessage string
+}
+
+// Responses are canned responses, consulted before the emulated commands.
+type Responses []Response
+
+// Find returns the response to the command line with arguments args, or nil.
+// Responses with a pattern take precedence over ones matching by name.
+func (responses Responses) Find(line string, args []string) *Response {
+	for i := range responses {
+		if responses[i].Match != nil && responses[i].Match.MatchString(line) {
+			return &responses[i]
+		}
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	for i := range responses {
+		if responses[i].Match == nil && responses[i].Name == args[0] {
+			return &responses[i]
+		}
+	}
+	return nil
+}
+
+// LoadResponses loads a canned response from every file in dir. A file's
+// content is the standard output of the command it is named after. It may
+// start with a header between two "---" lines setting any of:
+//
+//	match: <regular expression matched against the whole command line>
+//	stderr: <a line written to standard error>
+//	exit_status: <exit status>
+//	exit_signal: <signal the command dies of instead, e.g. SEGV>
+//	core_dumped: <true or false>
+//	error_message: <message sent along with the signal>
+//
+// It is an error for two files to respond to the same command name or pattern.
+func LoadResponses(dir string) (Responses, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	responses := Responses{}
+	names := map[string]string{}
+	patterns := map[string]string{}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		response, err := parseResponse(path, string(data))
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", path, err)
+		}
+		if response.Match != nil {
+			if other, ok := patterns[response.Match.String()]; ok {
+				return nil, fmt.Errorf("%v: pattern %q already used by %v", path, response.Match, other)
+			}
+			patterns[response.Match.String()] = path
+		} else {
+			if other, ok := names[response.Name]; ok {
+				return nil, fmt.Errorf("%v: command %q already answered by %v", path, response.Name, other)
+			}
+			names[response.Name] = path
+		}
+		responses = append(responses, response)
+	}
+	return responses, nil
+}
+
+func parseResponse(path, data string) (Response, error) {
+	response := Response{Path: path, Name: filepath.Base(path), Stdout: data}
+	if !strings.HasPrefix(data, "---\n") {
+		return response, nil
+	}
+	end := strings.Index(data[len("---\n"):], "\n---\n")
+	if end == -1 {
+		return response, fmt.Errorf("unterminated header")
+	}
+	header := data[len("---\n") : len("---\n")+end]
+	response.Stdout = data[len("---\n")+end+len("\n---\n"):]
+	scanner := bufio.NewScanner(strings.NewReader(header))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return response, fmt.Errorf("invalid header line %q", line)
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		switch key {
+		case "match":
+			match, err := regexp.Compile("^(?:" + value + ")$")
+			if err != nil {
+				return response, err
+			}
+			response.Match = match
+		case "stderr":
+			response.Stderr += value + "\n"
+		case "exit_status":
+			status, err := strconv.Atoi(value)
+			if err != nil {
+				return response, fmt.Errorf("invalid exit status %q", value)
+			}
+			response.ExitStatus = status
+		case "exit_signal":
+			name := strings.TrimPrefix(value, "SIG")
+			if _, ok := Signals[name]; !ok {
+				return response, fmt.Errorf("unknown signal %q", value)
+			}
+			response.ExitSignal = name
+		case "core_dumped":
+			coreDumped, err := strconv.ParseBool(value)
+			if err != nil {
+				return response, fmt.Errorf("invalid core_dumped %q", value)
+			}
+			response.CoreDumped = coreDumped
+		case "error_message":
+			response.ErrorMessage = value
+		default:
+			return response, fmt.Errorf("unknown header %q", key)
+		}
+	}
+	return response, nil
+}
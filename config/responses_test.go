@@ -0,0 +1,71 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles writes files, by name, to a new directory it returns.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadResponses(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"nvidia-smi": "No devices were found\n",
+		"miner":      "---\nmatch: curl .*pool\\.example.*\nstderr: curl: (6) Could not resolve host\nexit_status: 6\n---\n",
+		"crash":      "---\nexit_signal: SIGSEGV\ncore_dumped: true\nerror_message: invalid memory reference\n---\n",
+		".hidden":    "ignored\n",
+	})
+	responses, err := LoadResponses(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 3 {
+		t.Fatalf("loaded %v responses, want 3", len(responses))
+	}
+	if response := responses.Find("nvidia-smi -L", []string{"nvidia-smi", "-L"}); response == nil || response.Stdout != "No devices were found\n" || response.ExitStatus != 0 {
+		t.Errorf("nvidia-smi answered with %+v", response)
+	}
+	response := responses.Find("curl -s http://pool.example.com/x", []string{"curl", "-s", "http://pool.example.com/x"})
+	if response == nil || response.Name != "miner" || response.Stdout != "" || response.Stderr != "curl: (6) Could not resolve host\n" || response.ExitStatus != 6 {
+		t.Errorf("curl answered with %+v", response)
+	}
+	// Patterns match the whole line.
+	if response := responses.Find("echo curl http://pool.example.com/x", []string{"echo"}); response != nil {
+		t.Errorf("echo answered with %+v", response)
+	}
+	if response := responses.Find("crash", []string{"crash"}); response == nil || response.ExitSignal != "SEGV" || !response.CoreDumped || response.ErrorMessage != "invalid memory reference" {
+		t.Errorf("crash answered with %+v", response)
+	}
+	if response := responses.Find(".hidden", []string{".hidden"}); response != nil {
+		t.Errorf("hidden file loaded as %+v", response)
+	}
+}
+
+func TestLoadResponsesInvalid(t *testing.T) {
+	for _, test := range []struct {
+		files map[string]string
+		err   string
+	}{
+		{map[string]string{"a": "---\nmatch: uname.*\n---\n", "b": "---\nmatch: uname.*\n---\n"}, "already used by"},
+		{map[string]string{"a": "---\nexit_status: one\n---\n"}, "invalid exit status"},
+		{map[string]string{"a": "---\nexit_signal: SIGFOO\n---\n"}, "unknown signal"},
+		{map[string]string{"a": "---\nstdout: hello\n---\n"}, "unknown header"},
+		{map[string]string{"a": "---\nmatch: (\n---\n"}, "missing closing )"},
+		{map[string]string{"a": "---\nexit_status: 1\n"}, "unterminated header"},
+	} {
+		if _, err := LoadResponses(writeFiles(t, test.files)); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("LoadResponses(%q) = %v, want an error with %q", test.files, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package config
+
+// Signal is a signal a command can be made to die of.
+type Signal struct {
+	Number int
+	// Description is what bash prints when a command dies of the signal.
+	Description string
+}
+
+// Signals are the signals RFC 4254 section 6.10 names, without their SIG
+// prefix.
+var Signals = map[string]Signal{
+	"ABRT": {6, "Aborted"},
+	"ALRM": {14, "Alarm clock"},
+	"FPE":  {8, "Floating point exception"},
+	"HUP":  {1, "Hangup"},
+	"ILL":  {4, "Illegal instruction"},
+	"INT":  {2, ""},
+	"KILL": {9, "Killed"},
+	"PIPE": {13, ""},
+	"QUIT": {3, "Quit"},
+	"SEGV": {11, "Segmentation fault"},
+	"TERM": {15, "Terminated"},
+	"USR1": {10, "User defined signal 1"},
+	"USR2": {12, "User defined signal 2"},
+}
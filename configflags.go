@@ -0,0 +1,226 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/longkeyy/sshesame/config"
+	"github.com/longkeyy/sshesame/shadow"
+	"github.com/longkeyy/sshesame/shell"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// configFlags are the flags making up the configuration of the servers,
+// which reloading the config file can change.
+type configFlags struct {
+	cfg *config.Config
+	// The settings of the configuration that aren't fields of it, loaded or
+	// parsed into it.
+	commandsDir           *string
+	filesDir              *string
+	filesystem            *string
+	credentialsFiles      config.CredentialsFiles
+	jumpPorts             *string
+	agentKeys             *string
+	shadowAllowedBackends *string
+	banner                *string
+	motd                  *string
+}
+
+// generatedAgentKey is the key of the fake agent when fake_agent_keys is
+// empty, generated once so that it survives reloads.
+var generatedAgentKey config.AgentKey
+
+// defineConfigFlags defines the flags of the configuration of the servers on
+// flags.
+func defineConfigFlags(flags *flag.FlagSet) *configFlags {
+	cfg := &config.Config{}
+	configFlags := &configFlags{cfg: cfg}
+	cfg.Disconnects = config.DefaultDisconnects()
+	flags.Var(&cfg.Interactions, "client_interaction", "a <category>=<full|exec|auth> pair setting how much is emulated for clients of a category, by the software they identify with: "+strings.Join(config.ClientCategories, ", ")+", can be repeated (full if unset)")
+	flags.Var(&cfg.Disconnects, "disconnect_message", "a <reason>=<message> pair overriding the message clients are disconnected with for denylisted, blocked, per_ip_limit, session_duration, session_idle, channel_bytes or max_channels, can be repeated; the last four are written to the stderr of open sessions, as no disconnect message can be sent once keys are exchanged")
+	flags.DurationVar(&cfg.Shell.CommandDelay, "command_delay", 10*time.Millisecond, "the minimum time to wait before writing the output of a command")
+	flags.DurationVar(&cfg.Shell.CommandJitter, "command_jitter", 40*time.Millisecond, "the maximum random time added to command_delay")
+	flags.DurationVar(&cfg.Shell.CommandDelayPerKB, "command_delay_per_kb", 0, "additional delay for every KiB of command output")
+	flags.BoolVar(&cfg.Shell.DenyPTY, "deny_pty", false, "reject pseudo-terminal requests like a restricted server, shells then run without a terminal")
+	flags.Var(&cfg.Sticky.By, "sticky_hosts", "present returning clients the fake host they saw before, identifying them by: ip or credential (a new host every connection if empty)")
+	flags.StringVar(&cfg.Sticky.Salt, "sticky_salt", "", "a secret mixed into the fake hosts of sticky_hosts, so that they survive restarts and differ from other servers' (random if empty)")
+	flags.DurationVar(&cfg.Sticky.Window, "sticky_window", 24*time.Hour, "how long the files, accounts and passwords clients changed on their sticky host are kept after they leave")
+	flags.BoolVar(&cfg.Shell.Restricted, "restricted_shell", false, "emulate a restricted shell like rbash, rejecting commands that change directory, redirect output or name a path")
+	flags.StringVar(&cfg.Shell.Profile, "system_profile", shell.DefaultProfile, fmt.Sprintf("the distribution, kernel and hardware of fake hosts, one of %v", strings.Join(shell.ProfileNames(), ", ")))
+	configFlags.motd = flags.String("motd", "", "a text/template shown when interactive shells start, in place of the last login, \\n standing for a line break, with {{.User}}, {{.Hostname}}, {{.ClientIP}}, the client's address, {{.LastLogin}}, a time the user supposedly last logged in from it, {{.Kernel}}, {{.Machine}} and {{.PrettyName}}, the kernel release, hardware name and distribution of the system profile (disabled if empty)")
+	flags.StringVar(&cfg.Shell.Prompt, "shell_prompt", "", "the prompt of interactive shells, with the \\u, \\h, \\H, \\w, \\W, \\$, \\s, \\n and \\\\ escapes of bash's PS1, the system profile's if empty")
+	flags.DurationVar(&cfg.Shell.IdleTimeout, "shell_idle_timeout", 0, "how long interactive shells may wait for input before logging out, disabled if 0")
+	flags.StringVar(&cfg.Shell.RecordingDir, "recording_dir", "", "a directory to record interactive shell sessions to for replay, in recording_format (disabled if empty)")
+	cfg.Shell.RecordingFormat = "asciicast"
+	flags.Var(&cfg.Shell.RecordingFormat, "recording_format", "the format of session recordings: asciicast (asciinema) or ttyrec")
+	flags.StringVar(&cfg.Capture.Dir, "traffic_capture_dir", "", "a directory to save the raw traffic of every connection to, as the SSH transport sends and receives it, in traffic_capture_format (disabled if empty)")
+	cfg.Capture.Format = "pcap"
+	flags.Var(&cfg.Capture.Format, "traffic_capture_format", "the format of traffic captures: pcap, with made up TCP/IP headers, or raw, the bytes received and sent in a .in and a .out file")
+	flags.Int64Var(&cfg.Capture.MaxBytes, "traffic_capture_max_bytes", 16*1024*1024, "the most bytes of each direction of a connection saved by traffic_capture_dir (unlimited if 0)")
+	flags.BoolVar(&cfg.Shell.LogKeystrokes, "log_keystrokes", false, "log every keystroke typed in interactive shells with its timing (high volume, captures everything typed)")
+	cfg.Auth.Methods = config.Methods{"password", "publickey", "keyboard-interactive"}
+	configFlags.commandsDir = flags.String("commands_dir", "", "a directory of canned command responses, each file answering the command it is named after or the pattern in its header")
+	configFlags.filesDir = flags.String("files_dir", "", "a directory of templates of fake file contents, each for the paths matching the pattern in its header, optionally holding honeytokens")
+	configFlags.filesystem = flags.String("filesystem", "", "a tarball, optionally gzipped, or a JSON file of the files and directories fake hosts have besides the emulated ones, which clients see the same in shells, SFTP and SCP (disabled if empty)")
+	flags.Var(&cfg.Auth.Methods, "auth_methods", "a comma-separated list of the authentication methods to offer, any of password, publickey and keyboard-interactive (if empty, only none is offered)")
+	flags.Var(&cfg.Auth.Steps, "auth_steps", "a comma-separated list of authentication methods clients must pass in turn, e.g. publickey,password, all but the last ending in partial success (if empty, any single method is enough)")
+	flags.Var(&cfg.Auth.Users, "valid_users", "a comma-separated list of the only users, possibly * wildcard patterns, that can authenticate, others are always rejected as invalid users (if empty, any can)")
+	flags.BoolVar(&cfg.Auth.AcceptNone, "accept_none_auth", false, "accept clients authenticating with the none method, i.e. without credentials")
+	flags.BoolVar(&cfg.Auth.Decoy, "decoy", false, "reject every authentication attempt, logging the credentials tried, after showing decoy_banner, so that no client ever gets a session")
+	configFlags.banner = flags.String("banner", "", "the banner shown to clients before they authenticate, outside of decoy mode, \\n standing for a line break, the system profile's if empty")
+	flags.StringVar(&cfg.Auth.DecoyBanner, "decoy_banner", "This system is down for scheduled maintenance.\nPlease try again later.\n", "the banner shown to clients before they authenticate in decoy mode, \\n standing for a line break (disabled if empty)")
+	flags.StringVar(&cfg.Auth.DecoyMessage, "decoy_message", "", "a message sent to clients with every rejected authentication attempt in decoy mode, \\n standing for a line break (disabled if empty)")
+	flags.Var(&configFlags.credentialsFiles, "credentials_file", "a file of user:password lines, either possibly a * wildcard pattern, that are the only credentials accepted, can be repeated to layer files, whose lines override those of the files before them for the same user, and reloaded on SIGHUP (if not set, every password is)")
+	flags.Var(&cfg.Auth.Prompts, "keyboard_interactive_prompt", "a prompt of the form <name>[,echo][,round]=<text> asked during keyboard-interactive authentication, can be repeated to ask several in order, the round ones in a new challenge, and the answer to the one named password is decided like a password (default password=Password: )")
+	flags.Var(&cfg.Auth.PublicKeyRules, "publickey_rule", "a rule of the form accept|reject[,type=<key type>][,fingerprint=<SHA256 fingerprint>][,after=<distinct keys offered>] deciding public key authentication, can be repeated and the first matching rule applies (default reject)")
+	flags.Var(&cfg.Auth.PasswordRules, "password_rule", "a rule of the form accept|reject[,user=<regexp>][,password=<regexp>][,after=<failed attempts>][,probability=<0-1>] deciding password and keyboard-interactive authentication before credentials_file, accepting only once the client's address failed that many attempts remembered for auth_history_window and at random with that probability, can be repeated and the first matching rule applies")
+	flags.Var(&cfg.Auth.Honeytokens, "honeytoken", "a credential of the form [name=<name>,][user=<user>,]password=<password>|fingerprint=<SHA256 fingerprint> planted for attackers to find, whose use is logged as Honeytoken credential used, the password coming last, can be repeated")
+	flags.DurationVar(&cfg.Auth.HistoryWindow, "auth_history_window", time.Hour, "how long the failed attempts of a client's address, counted by the after of password_rule, and the decisions of sticky_auth are remembered after its last attempt")
+	flags.BoolVar(&cfg.Auth.StickyDecisions, "sticky_auth", false, "decide the same user and password the same way every time a client's address tries them within auth_history_window, so that random password_rule decisions stay consistent")
+	flags.IntVar(&cfg.Auth.BlockFailures, "block_failures", 0, "the number of failed authentication attempts within block_window after which connections from a client are closed for block_cooldown, 0 disables blocking")
+	flags.DurationVar(&cfg.Auth.BlockWindow, "block_window", 10*time.Minute, "the window failed authentication attempts are counted in for block_failures")
+	flags.DurationVar(&cfg.Auth.BlockCooldown, "block_cooldown", time.Hour, "how long clients stay blocked once block_failures is reached")
+	flags.IntVar(&cfg.Auth.MaxAuthTries, "max_auth_tries", 6, "the number of failed authentication attempts, across methods and counted like sshd's MaxAuthTries, after which clients are disconnected (unlimited if 0)")
+	flags.DurationVar(&cfg.Auth.PreAuthDelay, "pre_auth_delay", 0, "how long the first authentication attempt of a connection waits before being answered, like sshd waiting on PAM or DNS (disabled if 0)")
+	flags.DurationVar(&cfg.Auth.PreAuthJitter, "pre_auth_jitter", 0, "the maximum random time added to pre_auth_delay")
+	flags.DurationVar(&cfg.Limits.ChannelIdleTimeout, "channel_idle_timeout", time.Minute, "how long channels may go without receiving a request or data, session channels only until they start a shell, command or subsystem, before being closed (disabled if 0)")
+	flags.DurationVar(&cfg.Limits.IdentificationTimeout, "identification_timeout", 30*time.Second, "how long clients have to send their SSH identification line before being logged as a non-SSH probe")
+	flags.BoolVar(&cfg.Tarpit.Enabled, "tarpit", false, "waste the time of clients like endlessh while still serving them: send tarpit_lines random lines before the identification line and answer authentication attempts, channels and requests slowly, on every listener (personalities can enable it on their own)")
+	flags.IntVar(&cfg.Tarpit.Lines, "tarpit_lines", 0, "the number of random lines sent before the identification line in tarpit mode, tarpit_line_delay apart (until the client leaves if 0)")
+	flags.DurationVar(&cfg.Tarpit.LineDelay, "tarpit_line_delay", 10*time.Second, "the delay before every random line sent in tarpit mode")
+	flags.DurationVar(&cfg.Tarpit.AuthDelay, "tarpit_auth_delay", 5*time.Second, "how long every authentication attempt waits before being answered in tarpit mode")
+	flags.DurationVar(&cfg.Tarpit.ReplyDelay, "tarpit_reply_delay", 2*time.Second, "how long every channel and request waits before being answered in tarpit mode")
+	flags.IntVar(&cfg.SlowBanner.ChunkSize, "slow_banner_chunk_size", 0, "send the identification line in chunks of this many bytes, slow_banner_delay apart, like a tarpit (disabled if 0)")
+	flags.DurationVar(&cfg.SlowBanner.Delay, "slow_banner_delay", time.Second, "the delay between chunks of the identification line sent with slow_banner_chunk_size")
+	flags.Int64Var(&cfg.Limits.MaxConnectionBytes, "max_connection_bytes", 1<<30, "the most bytes a connection may transfer across all its channels before it's closed (0 disables the limit)")
+	flags.DurationVar(&cfg.Limits.MaxConnectionLifetime, "max_connection_lifetime", 24*time.Hour, "the longest a connection may stay open before it's closed (0 disables the limit)")
+	flags.DurationVar(&cfg.Limits.MaxSessionDuration, "max_session_duration", 0, "the longest an authenticated connection may last before it's disconnected as session_duration (0 disables the limit)")
+	flags.DurationVar(&cfg.Limits.SessionIdleTimeout, "session_idle_timeout", 0, "how long the channels of a connection may together go without receiving data or requests, and it without opening any, before it's disconnected as session_idle (disabled if 0)")
+	flags.Int64Var(&cfg.Limits.MaxChannelBytes, "max_channel_bytes", 0, "the most bytes a channel may read from the client before its connection is disconnected as channel_bytes (0 disables the limit)")
+	flags.IntVar(&cfg.Limits.MaxChannelsPerConnection, "max_channels_per_connection", 0, "the most channels a connection may open, beyond which it's disconnected as max_channels (0 disables the limit)")
+	flags.IntVar(&cfg.Limits.MaxConnectionsPerIP, "max_connections_per_ip", 0, "the most connections open at once from a source IP, further ones are closed (0 disables the limit)")
+	flags.IntVar(&cfg.Limits.MaxGoroutinesPerIP, "max_goroutines_per_ip", 0, "the most goroutines serving the connections of a source IP, one for every connection and channel, beyond which connections are closed and channels rejected (0 disables the limit)")
+	flags.IntVar(&cfg.Limits.MaxConnections, "max_connections", 0, "the most connections open at once across all listeners and personalities, further ones are closed right away (0 disables the limit)")
+	flags.IntVar(&cfg.Limits.MaxConnectionRate, "max_connection_rate", 0, "the most connections a source IP may open within connection_rate_window, beyond which its connections are closed right away for connection_rate_ban (0 disables rate limiting)")
+	flags.DurationVar(&cfg.Limits.ConnectionRateWindow, "connection_rate_window", time.Minute, "the window connections are counted in for max_connection_rate")
+	flags.DurationVar(&cfg.Limits.ConnectionRateBan, "connection_rate_ban", 10*time.Minute, "how long source IPs exceeding max_connection_rate have their connections closed")
+	flags.IntVar(&cfg.Limits.MaxPayloadSize, "max_payload_size", 128*1024, "the largest request payload or channel data accepted in bytes, larger ones are rejected as malformed (0 disables the limit)")
+	configFlags.jumpPorts = flags.String("jump_ports", "22", "a comma-separated list of the ports direct-tcpip channels are logged as jump host connections to")
+	flags.BoolVar(&cfg.Jump.Emulate, "emulate_jump_host", false, "serve another fake host over direct-tcpip channels to jump_ports, so that clients using the host as a jump host jump into it")
+	flags.IntVar(&cfg.Jump.MaxDepth, "jump_max_depth", 2, "the maximum number of jumps from host to fake host in emulate_jump_host mode")
+	flags.DurationVar(&cfg.Jump.MaxDuration, "jump_max_duration", 10*time.Minute, "the longest a jump into a fake host may stay open in emulate_jump_host mode")
+	flags.BoolVar(&cfg.Forwarding.Sandbox, "sandbox_remote_forwarding", false, "really listen for remote forwards on ephemeral loopback ports and relay connections to them to the client while logging everything, rather than only pretending to")
+	flags.IntVar(&cfg.Forwarding.MaxForwards, "sandbox_max_forwards", 2, "the maximum number of remote forwards of a connection in sandbox_remote_forwarding mode")
+	flags.Int64Var(&cfg.Forwarding.MaxBytes, "sandbox_max_forward_bytes", 1<<20, "the most bytes a forwarded connection may relay in sandbox_remote_forwarding mode")
+	flags.DurationVar(&cfg.Forwarding.MaxDuration, "sandbox_max_forward_duration", 5*time.Minute, "the longest a forwarded connection may stay open in sandbox_remote_forwarding mode")
+	flags.BoolVar(&cfg.Forwarding.Sniff, "sniff_direct_tcpip", false, "answer direct-tcpip channels to SMTP ports like a mail server and HTTP requests through any like a web server, logging the mails, credentials and requests clients send, rather than only logging their data")
+	flags.StringVar(&cfg.Shadow.Backend, "shadow_backend", "", "the address of a real, sacrificial host to relay sessions to while logging everything, instead of emulating them (disabled if empty)")
+	configFlags.shadowAllowedBackends = flags.String("shadow_allowed_backends", "", "a comma-separated list of the addresses shadow_backend may be, as a safeguard")
+	flags.StringVar(&cfg.Shadow.BackendFingerprint, "shadow_backend_fingerprint", "", "the SHA256 fingerprint of the host key of shadow_backend")
+	flags.StringVar(&cfg.Shadow.User, "shadow_user", "root", "the user to authenticate to shadow_backend as")
+	flags.StringVar(&cfg.Shadow.Password, "shadow_password", "", "the password to authenticate to shadow_backend with")
+	flags.IntVar(&cfg.Shadow.MaxSessions, "shadow_max_sessions", 1, "the maximum number of sessions relayed to shadow_backend at once, further ones are emulated")
+	flags.DurationVar(&cfg.Shadow.MaxDuration, "shadow_max_duration", 10*time.Minute, "the maximum time a session is relayed to shadow_backend for")
+	flags.BoolVar(&cfg.Requests.AcceptXonXoff, "accept_xon_xoff", false, "accept xon-xoff requests, which OpenSSH rejects as only servers are meant to send them")
+	flags.BoolVar(&cfg.Requests.ProbeAgent, "probe_forwarded_agent", false, "list and log the public keys of the agent clients forward, the agent is never asked to sign anything")
+	flags.BoolVar(&cfg.Requests.FakeAgent, "fake_agent", false, "answer clients that forward their agent with a fake one, listing fake_agent_keys to ssh-add and over the agent channels they open, and logging what it's asked to sign")
+	configFlags.agentKeys = flags.String("fake_agent_keys", "", "a comma-separated list of unencrypted private key files the fake agent holds, commented by their .pub files (a generated Ed25519 key if empty)")
+	flags.BoolVar(&cfg.Subsystems.AcceptUnknown, "accept_unknown_subsystems", false, "accept requests for subsystems that aren't emulated and log their input")
+	flags.IntVar(&cfg.Subsystems.MaxCaptureBytes, "subsystem_capture_max_bytes", 64*1024, "the most bytes of input of subsystems that aren't emulated captured before their channel is closed, in accept_unknown_subsystems mode (unlimited if 0)")
+	flags.DurationVar(&cfg.Subsystems.MaxCaptureDuration, "subsystem_capture_max_duration", time.Minute, "the longest the input of subsystems that aren't emulated is captured for before their channel is closed, in accept_unknown_subsystems mode (unlimited if 0)")
+	flags.IntVar(&cfg.Subsystems.MaxUploadSize, "max_upload_size", 16*1024*1024, "the largest file clients can upload over SFTP or SCP, larger uploads failing like on a full disk (unlimited if 0)")
+	flags.StringVar(&cfg.Subsystems.CaptureDir, "subsystem_capture_dir", "", "a directory to save the input of subsystems that aren't emulated to, in accept_unknown_subsystems mode (disabled if empty)")
+	return configFlags
+}
+
+// load validates the configuration set by the flags and loads the files it
+// refers to, returning it.
+func (configFlags *configFlags) load() (*config.Config, error) {
+	cfg := configFlags.cfg
+	if shell.LookupProfile(cfg.Shell.Profile) == nil {
+		return nil, fmt.Errorf("invalid system_profile: %q isn't one of %v", cfg.Shell.Profile, strings.Join(shell.ProfileNames(), ", "))
+	}
+	cfg.Auth.Banner = flagText(*configFlags.banner)
+	if cfg.Auth.Banner == "" {
+		cfg.Auth.Banner = shell.LookupProfile(cfg.Shell.Profile).Banner
+	}
+	if *configFlags.motd != "" {
+		motd, err := template.New("motd").Parse(flagText(*configFlags.motd))
+		if err != nil {
+			return nil, fmt.Errorf("invalid motd: %w", err)
+		}
+		cfg.Shell.MOTD = motd
+	}
+	cfg.Auth.DecoyBanner = flagText(cfg.Auth.DecoyBanner)
+	cfg.Auth.DecoyMessage = flagText(cfg.Auth.DecoyMessage)
+
+	for _, method := range cfg.Auth.Steps {
+		if !cfg.Auth.Methods.Enabled(method) {
+			return nil, fmt.Errorf("invalid authentication steps: %v isn't one of auth_methods", method)
+		}
+	}
+	for _, text := range strings.Split(*configFlags.jumpPorts, ",") {
+		port, err := strconv.Atoi(text)
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid jump ports: %q isn't a port", text)
+		}
+		cfg.Jump.Ports = append(cfg.Jump.Ports, port)
+	}
+	if *configFlags.shadowAllowedBackends != "" {
+		cfg.Shadow.AllowedBackends = strings.Split(*configFlags.shadowAllowedBackends, ",")
+	}
+	if err := shadow.Validate(cfg.Shadow); err != nil {
+		return nil, fmt.Errorf("invalid shadow mode configuration: %w", err)
+	}
+
+	if *configFlags.agentKeys != "" {
+		keys, err := config.LoadAgentKeys(strings.Split(*configFlags.agentKeys, ","))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load fake agent keys: %w", err)
+		}
+		cfg.Requests.AgentKeys = keys
+	} else if cfg.Requests.FakeAgent {
+		if generatedAgentKey.Signer == nil {
+			key, err := config.GenerateAgentKey("deploy@build-01")
+			if err != nil {
+				return nil, fmt.Errorf("failed to generate fake agent key: %w", err)
+			}
+			generatedAgentKey = key
+		}
+		cfg.Requests.AgentKeys = []config.AgentKey{generatedAgentKey}
+	}
+
+	if len(configFlags.credentialsFiles) != 0 {
+		credentials, err := config.LoadCredentialSources(configFlags.credentialsFiles)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load credentials: %w", err)
+		}
+		cfg.Auth.Credentials = credentials
+	}
+
+	if *configFlags.commandsDir != "" {
+		responses, err := config.LoadResponses(*configFlags.commandsDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load command responses: %w", err)
+		}
+		cfg.Shell.Responses = responses
+	}
+	if *configFlags.filesDir != "" {
+		files, err := config.LoadFileContents(*configFlags.filesDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load file contents: %w", err)
+		}
+		cfg.Shell.Files = files
+	}
+	if *configFlags.filesystem != "" {
+		filesystem, err := config.LoadFilesystem(*configFlags.filesystem)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load filesystem: %w", err)
+		}
+		cfg.Shell.Filesystem = filesystem
+	}
+	return cfg, nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"github.com/longkeyy/sshesame/config"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// reloadCredentials reloads credentials every time the process receives
+// SIGHUP, keeping the credentials loaded before if it fails.
+func reloadCredentials(credentials *config.CredentialSources) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		if err := credentials.Reload(); err != nil {
+			log.Warning("Failed to reload credentials:", err.Error())
+			continue
+		}
+		log.WithFields(log.Fields{
+			"credentials": credentials.Len(),
+		}).Info("Credentials reloaded")
+	}
+}
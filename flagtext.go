@@ -0,0 +1,16 @@
+package main
+
+import (
+	"strings"
+)
+
+// flagText returns the text of a flag setting a banner, message of the day or
+// message of decoy mode, in which \n stands for a line break, ending it with
+// one as clients print it as is.
+func flagText(text string) string {
+	text = strings.ReplaceAll(text, `\n`, "\n")
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	return text
+}
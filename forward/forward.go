@@ -0,0 +1,210 @@
+// Package forward really serves the remote forwards clients request, in a
+// sandbox: whatever a forward's tcpip-forward request asked for, it listens on
+// an ephemeral loopback port, and turns connections to it into
+// forwarded-tcpip channels to the client while logging everything relayed.
+//
+// This lets operators study the reverse tunnels of clients by connecting to
+// the port themselves, so it is only enabled explicitly, and the number of
+// forwards and what they relay are capped.
+package forward
+
+import (
+	"fmt"
+	"github.com/longkeyy/sshesame/config"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RFC 4254
+type forwardedTCPIP struct {
+	ConnectedAddress  string
+	ConnectedPort     uint32
+	OriginatorAddress string
+	OriginatorPort    uint32
+}
+
+// Forwards are the remote forwards of a connection.
+type Forwards struct {
+	conn       ssh.Conn
+	remoteAddr net.Addr
+	cfg        config.Forwarding
+
+	mu        sync.Mutex
+	listeners map[string]net.Listener
+	closed    bool
+}
+
+// New returns the remote forwards of conn, from remoteAddr.
+func New(conn ssh.Conn, remoteAddr net.Addr, cfg config.Forwarding) *Forwards {
+	return &Forwards{conn: conn, remoteAddr: remoteAddr, cfg: cfg, listeners: map[string]net.Listener{}}
+}
+
+func forwardKey(bindAddress string, bindPort uint32) string {
+	return net.JoinHostPort(bindAddress, strconv.Itoa(int(bindPort)))
+}
+
+// Forward starts listening for the remote forward of bindAddress and
+// bindPort. It returns the port clients are told was bound, the listening one
+// if bindPort is 0 and bindPort otherwise.
+func (forwards *Forwards) Forward(bindAddress string, bindPort uint32) (uint32, error) {
+	forwards.mu.Lock()
+	defer forwards.mu.Unlock()
+	if forwards.closed {
+		return 0, fmt.Errorf("connection closed")
+	}
+	if len(forwards.listeners) >= forwards.cfg.MaxForwards {
+		return 0, fmt.Errorf("%v remote forwards are already open", len(forwards.listeners))
+	}
+	if _, ok := forwards.listeners[forwardKey(bindAddress, bindPort)]; ok && bindPort != 0 {
+		return 0, fmt.Errorf("%v is already forwarded", forwardKey(bindAddress, bindPort))
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	if bindPort == 0 {
+		bindPort = uint32(listener.Addr().(*net.TCPAddr).Port)
+	}
+	// Forwards are cancelled by the port clients were told.
+	key := forwardKey(bindAddress, bindPort)
+	forwards.listeners[key] = listener
+	log.WithFields(log.Fields{
+		"client":         forwards.remoteAddr,
+		"forward":        key,
+		"listen_address": listener.Addr(),
+	}).Warning("Remote forward listening in sandbox")
+	go forwards.accept(listener, bindAddress, bindPort)
+	return bindPort, nil
+}
+
+// Cancel stops listening for the remote forward of bindAddress and bindPort.
+func (forwards *Forwards) Cancel(bindAddress string, bindPort uint32) error {
+	key := forwardKey(bindAddress, bindPort)
+	forwards.mu.Lock()
+	defer forwards.mu.Unlock()
+	listener, ok := forwards.listeners[key]
+	if !ok {
+		return fmt.Errorf("%v isn't forwarded", key)
+	}
+	delete(forwards.listeners, key)
+	return listener.Close()
+}
+
+// Close stops listening for all remote forwards, once the connection is
+// closed.
+func (forwards *Forwards) Close() {
+	forwards.mu.Lock()
+	defer forwards.mu.Unlock()
+	forwards.closed = true
+	for key, listener := range forwards.listeners {
+		listener.Close()
+		delete(forwards.listeners, key)
+	}
+}
+
+func (forwards *Forwards) accept(listener net.Listener, bindAddress string, bindPort uint32) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			if err := forwards.relay(conn, bindAddress, bindPort); err != nil {
+				log.Warning("Failed to relay forwarded connection:", err.Error())
+			}
+		}()
+	}
+}
+
+// relay relays conn to a new forwarded-tcpip channel until either end closes
+// it or a cap is reached.
+func (forwards *Forwards) relay(conn net.Conn, bindAddress string, bindPort uint32) error {
+	originator := conn.RemoteAddr().(*net.TCPAddr)
+	channel, requests, err := forwards.conn.OpenChannel("forwarded-tcpip", ssh.Marshal(forwardedTCPIP{
+		ConnectedAddress:  bindAddress,
+		ConnectedPort:     bindPort,
+		OriginatorAddress: originator.IP.String(),
+		OriginatorPort:    uint32(originator.Port),
+	}))
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+	go ssh.DiscardRequests(requests)
+	forward := forwardKey(bindAddress, bindPort)
+	log.WithFields(log.Fields{
+		"client":     forwards.remoteAddr,
+		"forward":    forward,
+		"originator": originator,
+	}).Info("Forwarded connection opened")
+	start := time.Now()
+	timer := time.AfterFunc(forwards.cfg.MaxDuration, func() {
+		channel.Close()
+		conn.Close()
+	})
+	defer timer.Stop()
+	relayed := &dataLogger{forwards: forwards, forward: forward}
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(channel, io.TeeReader(conn, relayed.stream("to_client", channel, conn)))
+		channel.CloseWrite()
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, io.TeeReader(channel, relayed.stream("from_client", channel, conn)))
+		done <- struct{}{}
+	}()
+	<-done
+	channel.Close()
+	conn.Close()
+	<-done
+	log.WithFields(log.Fields{
+		"client":   forwards.remoteAddr,
+		"forward":  forward,
+		"bytes":    atomic.LoadInt64(&relayed.bytes),
+		"duration": time.Since(start).String(),
+	}).Info("Forwarded connection closed")
+	return nil
+}
+
+// dataLogger logs the data relayed through a forwarded connection, closing
+// it once more than the maximum bytes were relayed.
+type dataLogger struct {
+	forwards *Forwards
+	forward  string
+	bytes    int64
+}
+
+func (logger *dataLogger) stream(direction string, channel ssh.Channel, conn net.Conn) io.Writer {
+	return writerFunc(func(data []byte) (int, error) {
+		log.WithFields(log.Fields{
+			"client":    logger.forwards.remoteAddr,
+			"forward":   logger.forward,
+			"direction": direction,
+			"data":      string(data),
+		}).Info("Forwarded data relayed")
+		if atomic.AddInt64(&logger.bytes, int64(len(data))) > logger.forwards.cfg.MaxBytes {
+			log.WithFields(log.Fields{
+				"client":    logger.forwards.remoteAddr,
+				"forward":   logger.forward,
+				"max_bytes": logger.forwards.cfg.MaxBytes,
+			}).Info("Forwarded connection exceeded its byte limit")
+			channel.Close()
+			conn.Close()
+		}
+		return len(data), nil
+	})
+}
+
+type writerFunc func(data []byte) (int, error)
+
+func (write writerFunc) Write(data []byte) (int, error) {
+	return write(data)
+}
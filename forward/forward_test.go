@@ -0,0 +1,243 @@
+package forward_test
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"github.com/longkeyy/sshesame/config"
+	"github.com/longkeyy/sshesame/honeypot"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// captureLog records the entries logged for the rest of the test instead
+// of printing them.
+func captureLog(t *testing.T) *logtest.Hook {
+	hook := logtest.NewGlobal()
+	out := log.StandardLogger().Out
+	log.SetOutput(ioutil.Discard)
+	t.Cleanup(func() {
+		log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+		log.SetOutput(out)
+	})
+	return hook
+}
+
+// waitFor returns the first entry logged with message, waiting for the
+// server to log it.
+func waitFor(t *testing.T, hook *logtest.Hook, message string) *log.Entry {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		for _, entry := range hook.AllEntries() {
+			if entry.Message == message {
+				return entry
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%q wasn't logged", message)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// newConfig returns a configuration accepting any password and serving up to
+// one remote forward in the sandbox.
+func newConfig() *config.Config {
+	return &config.Config{
+		Auth:       config.Auth{Methods: config.Methods{"password"}},
+		Forwarding: config.Forwarding{Sandbox: true, MaxForwards: 1, MaxBytes: 1024, MaxDuration: time.Minute},
+	}
+}
+
+// dial logs in as root to a new honeypot configured by cfg, returning a
+// channel closed once the honeypot is done with the connection. The
+// connection is closed at the end of the test at the latest.
+func dial(t *testing.T, cfg *config.Config) (*ssh.Client, <-chan struct{}) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromSigner(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshConfig := &ssh.ServerConfig{ServerVersion: "SSH-2.0-OpenSSH_8.9p1"}
+	sshConfig.AddHostKey(signer)
+	server := honeypot.NewServer(cfg, sshConfig)
+	clientEnd, serverEnd := honeypot.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.HandleConn(serverEnd)
+	}()
+	t.Cleanup(func() {
+		clientEnd.Close()
+		<-done
+	})
+	conn, channels, requests, err := ssh.NewClientConn(clientEnd, serverEnd.LocalAddr().String(), &ssh.ClientConfig{
+		User:            "root",
+		Auth:            []ssh.AuthMethod{ssh.Password("password")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ssh.NewClient(conn, channels, requests), done
+}
+
+// sandboxAddress returns the loopback address the remote forward logged in
+// hook listens on.
+func sandboxAddress(t *testing.T, hook *logtest.Hook) string {
+	t.Helper()
+	return fmt.Sprint(waitFor(t, hook, "Remote forward listening in sandbox").Data["listen_address"])
+}
+
+func TestForwardRelayed(t *testing.T) {
+	hook := captureLog(t)
+	client, _ := dial(t, newConfig())
+	listener, err := client.Listen("tcp", "0.0.0.0:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		conn.Write([]byte("pong " + line))
+	}()
+
+	address := sandboxAddress(t, hook)
+	if host, _, _ := net.SplitHostPort(address); host != "127.0.0.1" {
+		t.Errorf("forward listening on %v, want loopback", address)
+	}
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("ping\n"))
+	if reply, err := bufio.NewReader(conn).ReadString('\n'); err != nil || reply != "pong ping\n" {
+		t.Errorf("read %q, %v, want the client's reply", reply, err)
+	}
+	conn.Close()
+
+	if entry := waitFor(t, hook, "Forwarded connection closed"); entry.Data["forward"] != "0.0.0.0:8080" || entry.Data["bytes"] != int64(15) {
+		t.Errorf("closing logged with %v, want 15 bytes of 0.0.0.0:8080", entry.Data)
+	}
+	relayed := map[string]string{}
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Forwarded data relayed" {
+			relayed[entry.Data["direction"].(string)] += entry.Data["data"].(string)
+		}
+	}
+	if relayed["to_client"] != "ping\n" || relayed["from_client"] != "pong ping\n" {
+		t.Errorf("logged %q relayed, want both directions", relayed)
+	}
+}
+
+func TestForwardByteLimit(t *testing.T) {
+	hook := captureLog(t)
+	cfg := newConfig()
+	cfg.Forwarding.MaxBytes = 4
+	client, _ := dial(t, cfg)
+	listener, err := client.Listen("tcp", "0.0.0.0:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			ioutil.ReadAll(conn)
+			conn.Close()
+		}
+	}()
+	conn, err := net.Dial("tcp", sandboxAddress(t, hook))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("more than four bytes"))
+	if entry := waitFor(t, hook, "Forwarded connection exceeded its byte limit"); entry.Data["max_bytes"] != int64(4) {
+		t.Errorf("limit logged with %v", entry.Data)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("forwarded connection still open after its byte limit")
+	}
+}
+
+func TestForwardsCapped(t *testing.T) {
+	hook := captureLog(t)
+	client, _ := dial(t, newConfig())
+	first, err := client.Listen("tcp", "0.0.0.0:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	if second, err := client.Listen("tcp", "0.0.0.0:8081"); err == nil {
+		second.Close()
+		t.Error("second forward accepted, want at most 1")
+	}
+	if entry := waitFor(t, hook, "Remote forward refused"); entry.Data["forward"] != "0.0.0.0:8081" {
+		t.Errorf("refusal logged with %v", entry.Data)
+	}
+}
+
+func TestForwardsClosed(t *testing.T) {
+	hook := captureLog(t)
+	client, done := dial(t, newConfig())
+	listener, err := client.Listen("tcp", "0.0.0.0:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancelled := sandboxAddress(t, hook)
+	if err := listener.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if conn, err := net.Dial("tcp", cancelled); err == nil {
+		conn.Close()
+		t.Error("cancelled forward still listening")
+	}
+
+	hook.Reset()
+	if _, err := client.Listen("tcp", "0.0.0.0:8080"); err != nil {
+		t.Fatal(err)
+	}
+	disconnected := sandboxAddress(t, hook)
+	client.Close()
+	<-done
+	if conn, err := net.Dial("tcp", disconnected); err == nil {
+		conn.Close()
+		t.Error("forward still listening after the client disconnected")
+	}
+}
+
+func TestForwardPretendedOutsideSandbox(t *testing.T) {
+	hook := captureLog(t)
+	cfg := newConfig()
+	cfg.Forwarding.Sandbox = false
+	client, _ := dial(t, cfg)
+	listener, err := client.Listen("tcp", "0.0.0.0:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Remote forward listening in sandbox" {
+			t.Error("forward listening outside the sandbox mode")
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package forward
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/mail"
+	"strings"
+)
+
+const (
+	// maxSniffedMessage is the most bytes of a mail or HTTP body kept, the
+	// rest being only counted.
+	maxSniffedMessage = 1 << 20
+	// maxSniffedSnippet is the most bytes of a mail or HTTP body logged.
+	maxSniffedSnippet = 1024
+	// maxSMTPRecipients is the most recipients a mail may have, like
+	// Postfix's default.
+	maxSMTPRecipients = 1000
+	maxSMTPLine       = 4096
+)
+
+// smtpPorts are the ports direct-tcpip channels are answered like an SMTP
+// server on.
+var smtpPorts = map[uint32]bool{25: true, 587: true, 2525: true}
+
+// httpMethods are the beginnings of HTTP requests.
+var httpMethods = []string{"GET ", "POST", "HEAD", "PUT ", "DELE", "OPTI", "PATC", "TRAC", "CONN"}
+
+// Sniff answers a direct-tcpip channel to destination like the server it was
+// opened to, for the protocols clients abuse tunnels for, and logs what they
+// send: mail to SMTP ports and HTTP requests to any port. It reports whether
+// it recognized the protocol, input being left to read as is otherwise, the
+// client's stream being read from input and answered to output.
+func Sniff(remoteAddr net.Addr, destination string, port uint32, input *bufio.Reader, output io.Writer) (bool, error) {
+	sniffer := sniffer{remoteAddr: remoteAddr, destination: destination, input: input, output: output}
+	if smtpPorts[port] {
+		return true, sniffer.smtp()
+	}
+	start, err := input.Peek(4)
+	if err != nil {
+		if err == io.EOF {
+			err = nil
+		}
+		return false, err
+	}
+	for _, method := range httpMethods {
+		if string(start) == method {
+			return sniffer.http()
+		}
+	}
+	return false, nil
+}
+
+// sniffer answers a direct-tcpip channel.
+type sniffer struct {
+	remoteAddr  net.Addr
+	destination string
+	input       *bufio.Reader
+	output      io.Writer
+}
+
+func (sniffer sniffer) fields() log.Fields {
+	return log.Fields{
+		"client":      sniffer.remoteAddr,
+		"channel":     "direct-tcpip",
+		"destination": sniffer.destination,
+	}
+}
+
+// body returns the fields logged for the body of a mail or request.
+func body(content []byte, size int) log.Fields {
+	sum := sha256.Sum256(content)
+	snippet := content
+	if len(snippet) > maxSniffedSnippet {
+		snippet = snippet[:maxSniffedSnippet]
+	}
+	return log.Fields{
+		"size":      size,
+		"truncated": size > len(content),
+		"sha256":    hex.EncodeToString(sum[:]),
+		"snippet":   string(snippet),
+	}
+}
+
+// http answers HTTP requests until the client closes the channel, and tells
+// it CONNECT requests succeed, the tunnel then being read as is.
+func (sniffer sniffer) http() (bool, error) {
+	for {
+		request, err := http.ReadRequest(sniffer.input)
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			_, err := io.WriteString(sniffer.output, "HTTP/1.1 400 Bad Request\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+			return true, err
+		}
+		content, err := ioutil.ReadAll(io.LimitReader(request.Body, maxSniffedMessage))
+		if err != nil {
+			return true, err
+		}
+		rest, err := io.Copy(ioutil.Discard, request.Body)
+		if err != nil {
+			return true, err
+		}
+		log.WithFields(sniffer.fields()).WithFields(body(content, len(content)+int(rest))).WithFields(log.Fields{
+			"method":     request.Method,
+			"host":       request.Host,
+			"url":        request.RequestURI,
+			"user_agent": request.UserAgent(),
+			"category":   "proxy_attempt",
+		}).Info("Tunneled HTTP request received")
+		if request.Method == http.MethodConnect {
+			_, err := io.WriteString(sniffer.output, "HTTP/1.1 200 Connection established\r\n\r\n")
+			return false, err
+		}
+		response := "HTTP/1.1 200 OK\r\nContent-Type: text/html\r\nContent-Length: 0\r\n"
+		if request.Close {
+			response += "Connection: close\r\n"
+		}
+		if _, err := io.WriteString(sniffer.output, response+"\r\n"); err != nil {
+			return true, err
+		}
+		if request.Close {
+			return true, nil
+		}
+	}
+}
+
+// readLine reads an SMTP line, without its line break.
+func (sniffer sniffer) readLine() (string, error) {
+	line, err := sniffer.input.ReadSlice('\n')
+	if err == bufio.ErrBufferFull || len(line) > maxSMTPLine {
+		return "", fmt.Errorf("SMTP line too long")
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+func (sniffer sniffer) reply(lines ...string) error {
+	_, err := io.WriteString(sniffer.output, strings.Join(lines, "\r\n")+"\r\n")
+	return err
+}
+
+// smtp answers like a Postfix server accepting every mail and
+// authentication until the client quits, logging the mails and credentials.
+func (sniffer sniffer) smtp() error {
+	host, _, err := net.SplitHostPort(sniffer.destination)
+	if err != nil {
+		host = sniffer.destination
+	}
+	if err := sniffer.reply("220 " + host + " ESMTP Postfix (Ubuntu)"); err != nil {
+		return err
+	}
+	helo, from := "", ""
+	recipients := []string{}
+	for {
+		line, err := sniffer.readLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		argument := strings.TrimSpace(strings.TrimPrefix(line, line[:len(verb)]))
+		switch verb {
+		case "HELO":
+			helo = argument
+			err = sniffer.reply("250 " + host)
+		case "EHLO":
+			helo = argument
+			err = sniffer.reply("250-"+host, "250-PIPELINING", "250-SIZE 10240000", "250-AUTH PLAIN LOGIN", "250-8BITMIME", "250 SMTPUTF8")
+		case "AUTH":
+			err = sniffer.auth(argument)
+		case "MAIL":
+			from = smtpAddress(argument, "FROM:")
+			recipients = []string{}
+			err = sniffer.reply("250 2.1.0 Ok")
+		case "RCPT":
+			if len(recipients) >= maxSMTPRecipients {
+				err = sniffer.reply("452 4.5.3 Error: too many recipients")
+				break
+			}
+			recipients = append(recipients, smtpAddress(argument, "TO:"))
+			err = sniffer.reply("250 2.1.5 Ok")
+		case "DATA":
+			if len(recipients) == 0 {
+				err = sniffer.reply("554 5.5.1 Error: no valid recipients")
+				break
+			}
+			if err := sniffer.reply("354 End data with <CR><LF>.<CR><LF>"); err != nil {
+				return err
+			}
+			if err := sniffer.data(helo, from, recipients); err != nil {
+				return err
+			}
+			from, recipients = "", []string{}
+			err = sniffer.reply("250 2.0.0 Ok: queued")
+		case "RSET":
+			from, recipients = "", []string{}
+			err = sniffer.reply("250 2.0.0 Ok")
+		case "NOOP":
+			err = sniffer.reply("250 2.0.0 Ok")
+		case "QUIT":
+			return sniffer.reply("221 2.0.0 Bye")
+		default:
+			err = sniffer.reply("502 5.5.2 Error: command not recognized")
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// smtpAddress returns the address of a MAIL FROM or RCPT TO argument,
+// without its brackets and parameters.
+func smtpAddress(argument, prefix string) string {
+	if len(argument) >= len(prefix) && strings.EqualFold(argument[:len(prefix)], prefix) {
+		argument = strings.TrimSpace(argument[len(prefix):])
+	}
+	argument = strings.SplitN(argument, " ", 2)[0]
+	return strings.TrimSuffix(strings.TrimPrefix(argument, "<"), ">")
+}
+
+// auth answers an AUTH command, accepting any credentials and logging them.
+func (sniffer sniffer) auth(argument string) error {
+	parts := strings.Fields(argument)
+	if len(parts) == 0 {
+		return sniffer.reply("501 5.5.4 Syntax: AUTH mechanism")
+	}
+	mechanism := strings.ToUpper(parts[0])
+	var user, password string
+	switch mechanism {
+	case "PLAIN":
+		response := ""
+		if len(parts) > 1 {
+			response = parts[1]
+		} else {
+			if err := sniffer.reply("334 "); err != nil {
+				return err
+			}
+			line, err := sniffer.readLine()
+			if err != nil {
+				return err
+			}
+			response = line
+		}
+		decoded, err := base64.StdEncoding.DecodeString(response)
+		if err != nil {
+			return sniffer.reply("501 5.5.2 Syntax: AUTH PLAIN")
+		}
+		fields := strings.SplitN(string(decoded), "\x00", 3)
+		if len(fields) != 3 {
+			return sniffer.reply("535 5.7.8 Error: authentication failed")
+		}
+		user, password = fields[1], fields[2]
+	case "LOGIN":
+		answers := []string{}
+		for _, prompt := range []string{"Username:", "Password:"} {
+			if len(parts) > 1 && prompt == "Username:" {
+				answers = append(answers, parts[1])
+				continue
+			}
+			if err := sniffer.reply("334 " + base64.StdEncoding.EncodeToString([]byte(prompt))); err != nil {
+				return err
+			}
+			line, err := sniffer.readLine()
+			if err != nil {
+				return err
+			}
+			answers = append(answers, line)
+		}
+		decoded := make([]string, len(answers))
+		for i, answer := range answers {
+			text, err := base64.StdEncoding.DecodeString(answer)
+			if err != nil {
+				return sniffer.reply("501 5.5.2 Syntax: AUTH LOGIN")
+			}
+			decoded[i] = string(text)
+		}
+		user, password = decoded[0], decoded[1]
+	default:
+		return sniffer.reply("535 5.7.8 Error: authentication failed: Invalid authentication mechanism")
+	}
+	log.WithFields(sniffer.fields()).WithFields(log.Fields{
+		"mechanism": mechanism,
+		"user":      user,
+		"password":  password,
+		"category":  "spam_attempt",
+	}).Info("Tunneled SMTP authentication received")
+	return sniffer.reply("235 2.7.0 Authentication successful")
+}
+
+// data reads a mail until the line with a single dot, and logs it.
+func (sniffer sniffer) data(helo, from string, recipients []string) error {
+	content := []byte{}
+	size := 0
+	for {
+		line, err := sniffer.readLine()
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		if line == "." {
+			break
+		}
+		// RFC 5321 section 4.5.2
+		line = strings.TrimPrefix(line, ".") + "\r\n"
+		size += len(line)
+		if len(content)+len(line) <= maxSniffedMessage {
+			content = append(content, line...)
+		}
+	}
+	fields := log.Fields{
+		"helo":       helo,
+		"from":       from,
+		"recipients": recipients,
+		"category":   "spam_attempt",
+	}
+	if message, err := mail.ReadMessage(strings.NewReader(string(content))); err == nil {
+		fields["subject"] = message.Header.Get("Subject")
+	}
+	log.WithFields(sniffer.fields()).WithFields(body(content, size)).WithFields(fields).Info("Tunneled mail received")
+	return nil
+}
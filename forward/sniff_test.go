@@ -0,0 +1,153 @@
+package forward_test
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/longkeyy/sshesame/forward"
+	"net"
+	"strings"
+	"testing"
+)
+
+var sniffedClient = &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 50000}
+
+func TestSniffSMTP(t *testing.T) {
+	hook := captureLog(t)
+	input := strings.Join([]string{
+		"EHLO spammer.example",
+		"AUTH PLAIN AGFsaWNlAGh1bnRlcjI=",
+		"AUTH LOGIN",
+		"Ym9i",
+		"czNjcmV0",
+		"DATA",
+		"MAIL FROM:<alice@example.com> SIZE=100",
+		"RCPT TO:<bob@example.org>",
+		"DATA",
+		"Subject: Win a prize",
+		"",
+		"..Click",
+		".",
+		"QUIT",
+	}, "\r\n") + "\r\n"
+	var output bytes.Buffer
+	handled, err := forward.Sniff(sniffedClient, "mail.example.org:25", 25, bufio.NewReader(strings.NewReader(input)), &output)
+	if !handled || err != nil {
+		t.Fatalf("Sniff = %v, %v", handled, err)
+	}
+	if want := strings.Join([]string{
+		"220 mail.example.org ESMTP Postfix (Ubuntu)",
+		"250-mail.example.org", "250-PIPELINING", "250-SIZE 10240000", "250-AUTH PLAIN LOGIN", "250-8BITMIME", "250 SMTPUTF8",
+		"235 2.7.0 Authentication successful",
+		"334 VXNlcm5hbWU6",
+		"334 UGFzc3dvcmQ6",
+		"235 2.7.0 Authentication successful",
+		"554 5.5.1 Error: no valid recipients",
+		"250 2.1.0 Ok",
+		"250 2.1.5 Ok",
+		"354 End data with <CR><LF>.<CR><LF>",
+		"250 2.0.0 Ok: queued",
+		"221 2.0.0 Bye",
+	}, "\r\n") + "\r\n"; output.String() != want {
+		t.Errorf("answered %q, want %q", output.String(), want)
+	}
+	entries := hook.AllEntries()
+	if len(entries) != 3 {
+		t.Fatalf("logged %v entries, want 3", len(entries))
+	}
+	for i, credentials := range [][2]string{{"PLAIN", "alice:hunter2"}, {"LOGIN", "bob:s3cret"}} {
+		if entry := entries[i]; entry.Message != "Tunneled SMTP authentication received" || entry.Data["mechanism"] != credentials[0] || entry.Data["user"].(string)+":"+entry.Data["password"].(string) != credentials[1] {
+			t.Errorf("logged %v %v, want the %v credentials", entry.Message, entry.Data, credentials[0])
+		}
+	}
+	// Leading dots are unstuffed, see RFC 5321 section 4.5.2.
+	mail := entries[2]
+	for key, value := range map[string]interface{}{
+		"destination": "mail.example.org:25",
+		"channel":     "direct-tcpip",
+		"helo":        "spammer.example",
+		"from":        "alice@example.com",
+		"subject":     "Win a prize",
+		"snippet":     "Subject: Win a prize\r\n\r\n.Click\r\n",
+		"size":        32,
+		"truncated":   false,
+		"sha256":      "41c5fd5b79cf7d23d9ce3982f0aad6a76674107d917804fa154237fbc09831f1",
+		"category":    "spam_attempt",
+	} {
+		if mail.Data[key] != value {
+			t.Errorf("%v = %v, want %v", key, mail.Data[key], value)
+		}
+	}
+	if recipients := mail.Data["recipients"].([]string); len(recipients) != 1 || recipients[0] != "bob@example.org" {
+		t.Errorf("recipients = %q", recipients)
+	}
+}
+
+func TestSniffHTTP(t *testing.T) {
+	hook := captureLog(t)
+	input := "GET http://example.com/ HTTP/1.1\r\nHost: example.com\r\nUser-Agent: curl/8.5.0\r\n\r\n" +
+		"POST /login HTTP/1.1\r\nHost: example.com\r\nContent-Length: 5\r\nConnection: close\r\n\r\nhello"
+	var output bytes.Buffer
+	handled, err := forward.Sniff(sniffedClient, "example.com:80", 80, bufio.NewReader(strings.NewReader(input)), &output)
+	if !handled || err != nil {
+		t.Fatalf("Sniff = %v, %v", handled, err)
+	}
+	if want := "HTTP/1.1 200 OK\r\nContent-Type: text/html\r\nContent-Length: 0\r\n\r\n" +
+		"HTTP/1.1 200 OK\r\nContent-Type: text/html\r\nContent-Length: 0\r\nConnection: close\r\n\r\n"; output.String() != want {
+		t.Errorf("answered %q, want %q", output.String(), want)
+	}
+	entries := hook.AllEntries()
+	if len(entries) != 2 {
+		t.Fatalf("logged %v entries, want 2", len(entries))
+	}
+	for i, want := range []map[string]interface{}{
+		{"method": "GET", "host": "example.com", "url": "http://example.com/", "user_agent": "curl/8.5.0", "size": 0, "snippet": "", "category": "proxy_attempt"},
+		{"method": "POST", "host": "example.com", "url": "/login", "user_agent": "", "size": 5, "snippet": "hello", "sha256": "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"},
+	} {
+		for key, value := range want {
+			if entries[i].Data[key] != value {
+				t.Errorf("request %v: %v = %v, want %v", i+1, key, entries[i].Data[key], value)
+			}
+		}
+	}
+}
+
+func TestSniffHTTPConnect(t *testing.T) {
+	captureLog(t)
+	input := bufio.NewReader(strings.NewReader("CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n\x16\x03\x01"))
+	var output bytes.Buffer
+	// The tunnel is left to read as is.
+	handled, err := forward.Sniff(sniffedClient, "example.com:443", 443, input, &output)
+	if handled || err != nil || output.String() != "HTTP/1.1 200 Connection established\r\n\r\n" {
+		t.Fatalf("Sniff = %v, %v, answering %q", handled, err, output.String())
+	}
+	if rest, _ := input.Peek(3); string(rest) != "\x16\x03\x01" {
+		t.Errorf("left %q to read", rest)
+	}
+}
+
+func TestSniffUnrecognized(t *testing.T) {
+	hook := captureLog(t)
+	for _, test := range []struct {
+		input  string
+		output string
+	}{
+		{"SSH-2.0-OpenSSH_9.6\r\n", ""},
+		{"GET", ""},
+		{"GET / HTTP/1.1\r\nHost\r\n\r\n", "HTTP/1.1 400 Bad Request\r\nContent-Length: 0\r\nConnection: close\r\n\r\n"},
+	} {
+		var output bytes.Buffer
+		input := bufio.NewReader(strings.NewReader(test.input))
+		handled, err := forward.Sniff(sniffedClient, "example.com:22", 22, input, &output)
+		if handled != (test.output != "") || err != nil || output.String() != test.output {
+			t.Errorf("Sniff(%q) = %v, %v, answering %q, want %q", test.input, handled, err, output.String(), test.output)
+		}
+		if !handled {
+			if rest, _ := input.Peek(len(test.input)); string(rest) != test.input {
+				t.Errorf("Sniff(%q) left %q to read", test.input, rest)
+			}
+		}
+	}
+	if entries := hook.AllEntries(); len(entries) != 0 {
+		t.Errorf("logged %v", entries)
+	}
+}
@@ -0,0 +1,186 @@
+// Package geoip looks up the country, city and autonomous system of client
+// addresses in MaxMind DB files such as the GeoLite2 City and ASN databases.
+package geoip
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// cacheSize bounds how many addresses are remembered, the cache being
+// emptied once it's full.
+const cacheSize = 4096
+
+// Location is where an address is, any part of it being empty if no database
+// knows it.
+type Location struct {
+	// Country is an ISO 3166-1 country code.
+	Country string
+	City    string
+	// ASN is the number of the autonomous system announcing the address and
+	// Org the organization it's registered to.
+	ASN uint64
+	Org string
+}
+
+// Fields returns the fields events of a client at location are tagged with.
+func (location Location) Fields() log.Fields {
+	fields := log.Fields{}
+	if location.Country != "" {
+		fields["country"] = location.Country
+	}
+	if location.City != "" {
+		fields["city"] = location.City
+	}
+	if location.ASN != 0 {
+		fields["asn"] = location.ASN
+	}
+	if location.Org != "" {
+		fields["org"] = location.Org
+	}
+	return fields
+}
+
+type database struct {
+	path     string
+	modified time.Time
+	db       *mmdb
+}
+
+// Reader looks addresses up in any number of databases, each one filling in
+// what it knows, and reopens the files that change.
+type Reader struct {
+	mu        sync.RWMutex
+	databases []*database
+	cache     map[string]Location
+}
+
+// Open opens the databases at paths.
+func Open(paths []string) (*Reader, error) {
+	reader := &Reader{cache: map[string]Location{}}
+	for _, path := range paths {
+		database, err := openDatabase(path)
+		if err != nil {
+			return nil, err
+		}
+		reader.databases = append(reader.databases, database)
+	}
+	return reader, nil
+}
+
+func openDatabase(path string) (*database, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	buffer, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	db, err := parseMMDB(buffer)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	return &database{path: path, modified: info.ModTime(), db: db}, nil
+}
+
+// Lookup returns the location of ip. Databases failing to decode its record
+// are skipped without logging anything, as events are tagged with it while
+// they're logged.
+func (reader *Reader) Lookup(ip net.IP) Location {
+	key := ip.String()
+	reader.mu.RLock()
+	location, ok := reader.cache[key]
+	databases := reader.databases
+	reader.mu.RUnlock()
+	if ok {
+		return location
+	}
+	for _, database := range databases {
+		record, err := database.db.lookup(ip)
+		if err != nil {
+			continue
+		}
+		fields, _ := record.(map[string]interface{})
+		if country := lookupString(fields, "country", "iso_code"); country != "" {
+			location.Country = country
+		}
+		if city := lookupString(fields, "city", "names", "en"); city != "" {
+			location.City = city
+		}
+		if asn, ok := fields["autonomous_system_number"].(uint64); ok {
+			location.ASN = asn
+		}
+		if org := lookupString(fields, "autonomous_system_organization"); org != "" {
+			location.Org = org
+		}
+	}
+	reader.mu.Lock()
+	if len(reader.cache) >= cacheSize {
+		reader.cache = map[string]Location{}
+	}
+	reader.cache[key] = location
+	reader.mu.Unlock()
+	return location
+}
+
+// lookupString returns the string value at the path of keys in fields, empty
+// if there's none.
+func lookupString(fields map[string]interface{}, keys ...string) string {
+	for _, key := range keys[:len(keys)-1] {
+		fields, _ = fields[key].(map[string]interface{})
+	}
+	value, _ := fields[keys[len(keys)-1]].(string)
+	return value
+}
+
+// Reload reopens the databases whose files were modified since they were
+// opened. Those failing to open are kept as they were.
+func (reader *Reader) Reload() {
+	reader.mu.RLock()
+	databases := append([]*database{}, reader.databases...)
+	reader.mu.RUnlock()
+	reloaded := false
+	for i, current := range databases {
+		info, err := os.Stat(current.path)
+		if err != nil || info.ModTime().Equal(current.modified) {
+			continue
+		}
+		database, err := openDatabase(current.path)
+		if err != nil {
+			log.Warning("Failed to reload GeoIP database:", err.Error())
+			continue
+		}
+		databases[i] = database
+		reloaded = true
+		log.WithFields(log.Fields{
+			"path": current.path,
+		}).Info("GeoIP database reloaded")
+	}
+	if !reloaded {
+		return
+	}
+	reader.mu.Lock()
+	reader.databases = databases
+	reader.cache = map[string]Location{}
+	reader.mu.Unlock()
+}
+
+// ReloadEvery reloads the databases every interval until stop is closed.
+func (reader *Reader) ReloadEvery(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			reader.Reload()
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package geoip
+
+import (
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// cityRecord and asnRecord are records laid out like those of the GeoLite2
+// City and ASN databases.
+const (
+	cityRecord = "\xe2" +
+		"\x47country" + "\xe1" + "\x48iso_code" + "\x42FR" +
+		"\x44city" + "\xe1" + "\x45names" + "\xe2" + "\x42de" + "\x45Paris" + "\x42en" + "\x45Paris"
+	asnRecord = "\xe2" +
+		"\x58autonomous_system_number" + "\xc2\xfb\xf0" +
+		"\x5d\x01autonomous_system_organization" + "\x47Example"
+)
+
+// writeDatabase writes a database with networks to a file in dir, returning
+// its path.
+func writeDatabase(t *testing.T, dir, name string, networks ...testNetwork) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, buildMMDB(t, 24, 6, networks...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReaderLookup(t *testing.T) {
+	dir := t.TempDir()
+	city := writeDatabase(t, dir, "city.mmdb", testNetwork{"192.0.2.0/24", cityRecord})
+	asn := writeDatabase(t, dir, "asn.mmdb", testNetwork{"192.0.2.0/23", asnRecord})
+	reader, err := Open([]string{city, asn})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for address, want := range map[string]Location{
+		"192.0.2.1":    {Country: "FR", City: "Paris", ASN: 64496, Org: "Example"},
+		"192.0.3.1":    {ASN: 64496, Org: "Example"},
+		"198.51.100.1": {},
+	} {
+		if location := reader.Lookup(net.ParseIP(address)); location != want {
+			t.Errorf("%v is at %+v, want %+v", address, location, want)
+		}
+	}
+	if want := (log.Fields{"country": "FR", "city": "Paris", "asn": uint64(64496), "org": "Example"}); !reflect.DeepEqual(reader.Lookup(net.ParseIP("192.0.2.1")).Fields(), want) {
+		t.Errorf("tagged events with %v, want %v", reader.Lookup(net.ParseIP("192.0.2.1")).Fields(), want)
+	}
+	if fields := (Location{}).Fields(); len(fields) != 0 {
+		t.Errorf("tagged events of unknown locations with %v", fields)
+	}
+	if _, err := Open([]string{city, filepath.Join(dir, "missing.mmdb")}); err == nil {
+		t.Error("opened a missing database")
+	}
+}
+
+func TestReaderReload(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	dir := t.TempDir()
+	city := writeDatabase(t, dir, "city.mmdb", testNetwork{"192.0.2.0/24", cityRecord})
+	reader, err := Open([]string{city})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location := reader.Lookup(net.ParseIP("198.51.100.1")); location.Country != "" {
+		t.Fatalf("198.51.100.1 is in %v", location.Country)
+	}
+
+	// Databases are reloaded once their modification time changes.
+	info, err := os.Stat(city)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeDatabase(t, dir, "city.mmdb", testNetwork{"198.51.100.0/24", cityRecord})
+	os.Chtimes(city, info.ModTime(), info.ModTime())
+	reader.Reload()
+	if location := reader.Lookup(net.ParseIP("198.51.100.1")); location.Country != "" {
+		t.Errorf("reloaded an unmodified database, 198.51.100.1 is in %v", location.Country)
+	}
+	modified := time.Now().Add(time.Hour)
+	os.Chtimes(city, modified, modified)
+	reader.Reload()
+	if location := reader.Lookup(net.ParseIP("198.51.100.1")); location.Country != "FR" {
+		t.Errorf("198.51.100.1 is in %q after reloading, want FR", location.Country)
+	}
+
+	// Databases failing to open are kept as they were.
+	ioutil.WriteFile(city, []byte("corrupt"), 0644)
+	modified = modified.Add(time.Hour)
+	os.Chtimes(city, modified, modified)
+	reader.Reload()
+	if location := reader.Lookup(net.ParseIP("198.51.100.1")); location.Country != "FR" {
+		t.Errorf("198.51.100.1 is in %q after failing to reload, want FR", location.Country)
+	}
+}
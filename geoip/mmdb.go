@@ -0,0 +1,260 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+)
+
+// metadataMarker starts the metadata at the end of MaxMind DB files, see
+// https://maxmind.github.io/MaxMind-DB/.
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// dataSeparator is the size of the zeros between the search tree and the
+// data section.
+const dataSeparator = 16
+
+// maxDepth bounds the nesting of decoded values so that a corrupt file can't
+// exhaust the stack.
+const maxDepth = 32
+
+// mmdb is a parsed MaxMind DB file.
+type mmdb struct {
+	buffer     []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	// ipv4Start is the node IPv4 addresses are looked up from, that of
+	// ::/96 in IPv6 databases.
+	ipv4Start uint
+}
+
+func parseMMDB(buffer []byte) (*mmdb, error) {
+	start := bytes.LastIndex(buffer, metadataMarker)
+	if start == -1 {
+		return nil, errors.New("no MaxMind DB metadata")
+	}
+	metadata, _, err := (&decoder{data: buffer[start+len(metadataMarker):]}).decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MaxMind DB metadata: %w", err)
+	}
+	fields, ok := metadata.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("invalid MaxMind DB metadata")
+	}
+	db := &mmdb{buffer: buffer}
+	for key, value := range map[string]*uint{"node_count": &db.nodeCount, "record_size": &db.recordSize, "ip_version": &db.ipVersion} {
+		number, ok := fields[key].(uint64)
+		if !ok {
+			return nil, fmt.Errorf("invalid MaxMind DB metadata: no %v", key)
+		}
+		*value = uint(number)
+	}
+	if db.recordSize != 24 && db.recordSize != 28 && db.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported MaxMind DB record size %v", db.recordSize)
+	}
+	treeSize := db.nodeCount * db.recordSize / 4
+	if treeSize+dataSeparator > uint(start) {
+		return nil, errors.New("truncated MaxMind DB search tree")
+	}
+	db.data = buffer[treeSize+dataSeparator : start]
+	if db.ipVersion == 6 {
+		for i := 0; i < 96 && db.ipv4Start < db.nodeCount; i++ {
+			db.ipv4Start = db.record(db.ipv4Start, 0)
+		}
+	}
+	return db, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of node.
+func (db *mmdb) record(node uint, bit uint) uint {
+	offset := node * db.recordSize / 4
+	b := db.buffer[offset : offset+db.recordSize/4]
+	switch db.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// lookup returns the record of the network ip belongs to, nil if none does.
+func (db *mmdb) lookup(ip net.IP) (interface{}, error) {
+	node := uint(0)
+	bits := 128
+	if ipv4 := ip.To4(); ipv4 != nil {
+		ip = ipv4
+		bits = 32
+		node = db.ipv4Start
+	} else if db.ipVersion == 4 {
+		return nil, nil
+	}
+	for i := 0; i < bits && node < db.nodeCount; i++ {
+		node = db.record(node, uint(ip[i/8]>>(7-i%8))&1)
+	}
+	if node <= db.nodeCount {
+		return nil, nil
+	}
+	offset := node - db.nodeCount - dataSeparator
+	value, _, err := (&decoder{data: db.data}).decode(offset, 0)
+	return value, err
+}
+
+// decoder decodes values of the data section data, which pointers are
+// relative to.
+type decoder struct {
+	data []byte
+}
+
+var errTruncated = errors.New("truncated MaxMind DB data")
+
+// decode returns the value at offset and the offset following it.
+func (d *decoder) decode(offset uint, depth int) (interface{}, uint, error) {
+	if depth > maxDepth {
+		return nil, 0, errors.New("too deeply nested MaxMind DB data")
+	}
+	control, offset, err := d.bytes(offset, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	kind := uint(control[0] >> 5)
+	if kind == 1 {
+		pointer, next, err := d.pointer(control[0], offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decode(pointer, depth+1)
+		return value, next, err
+	}
+	if kind == 0 {
+		extended, next, err := d.bytes(offset, 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		kind = 7 + uint(extended[0])
+		offset = next
+	}
+	size := uint(control[0] & 0x1f)
+	if size >= 29 {
+		extra, next, err := d.bytes(offset, size-28)
+		if err != nil {
+			return nil, 0, err
+		}
+		offset = next
+		switch size {
+		case 29:
+			size = 29 + uint(extra[0])
+		case 30:
+			size = 285 + uint(binary.BigEndian.Uint16(extra))
+		default:
+			size = 65821 + (uint(extra[0])<<16 | uint(extra[1])<<8 | uint(extra[2]))
+		}
+	}
+	switch kind {
+	case 7:
+		fields := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("invalid MaxMind DB map key")
+			}
+			fields[name], offset, err = d.decode(next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		return fields, offset, nil
+	case 11:
+		values := make([]interface{}, size)
+		for i := range values {
+			values[i], offset, err = d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		return values, offset, nil
+	case 14:
+		return size != 0, offset, nil
+	}
+	value, next, err := d.bytes(offset, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch kind {
+	case 2:
+		return string(value), next, nil
+	case 3:
+		if size != 8 {
+			return nil, 0, errors.New("invalid MaxMind DB double")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(value)), next, nil
+	case 15:
+		if size != 4 {
+			return nil, 0, errors.New("invalid MaxMind DB float")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(value))), next, nil
+	case 4:
+		return value, next, nil
+	case 5, 6, 9:
+		number := uint64(0)
+		for _, b := range value {
+			number = number<<8 | uint64(b)
+		}
+		return number, next, nil
+	case 8:
+		number := uint32(0)
+		for _, b := range value {
+			number = number<<8 | uint32(b)
+		}
+		return int32(number), next, nil
+	case 10:
+		// uint128 only holds IPv6 addresses in practice.
+		return value, next, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported MaxMind DB data type %v", kind)
+}
+
+// pointer returns the offset a pointer with control byte control starting
+// before offset points to and the offset following it.
+func (d *decoder) pointer(control byte, offset uint) (uint, uint, error) {
+	size := uint(control>>3)&3 + 1
+	value, next, err := d.bytes(offset, size)
+	if err != nil {
+		return 0, 0, err
+	}
+	pointer := uint(0)
+	if size < 4 {
+		pointer = uint(control & 7)
+	}
+	for _, b := range value {
+		pointer = pointer<<8 | uint(b)
+	}
+	switch size {
+	case 2:
+		pointer += 2048
+	case 3:
+		pointer += 526336
+	}
+	return pointer, next, nil
+}
+
+func (d *decoder) bytes(offset, size uint) ([]byte, uint, error) {
+	if offset+size > uint(len(d.data)) {
+		return nil, 0, errTruncated
+	}
+	return d.data[offset : offset+size], offset + size, nil
+}
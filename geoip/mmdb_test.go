@@ -0,0 +1,238 @@
+package geoip
+
+import (
+	"encoding/binary"
+	"math"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testNetwork is a network of a database built by buildMMDB, with the
+// encoded data of its record.
+type testNetwork struct {
+	cidr string
+	data string
+}
+
+// buildMMDB returns a MaxMind DB file with recordSize bit records holding
+// networks, IPv4 ones being under ::/96 in IPv6 databases.
+func buildMMDB(t *testing.T, recordSize, ipVersion uint, networks ...testNetwork) []byte {
+	t.Helper()
+	// Records are nodes when positive, -1 when empty and -2-i for the data
+	// of networks[i].
+	nodes := [][2]int{{-1, -1}}
+	for i, network := range networks {
+		ip, ipNet, err := net.ParseCIDR(network.cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ones, _ := ipNet.Mask.Size()
+		if ipVersion == 6 {
+			if ip.To4() != nil {
+				ones += 96
+				ip = append(make(net.IP, 12), ip.To4()...)
+			}
+		} else {
+			ip = ip.To4()
+		}
+		node := 0
+		for bit := 0; bit < ones; bit++ {
+			side := int(ip[bit/8]>>(7-bit%8)) & 1
+			if bit == ones-1 {
+				nodes[node][side] = -2 - i
+				break
+			}
+			if nodes[node][side] < 0 {
+				nodes = append(nodes, [2]int{-1, -1})
+				nodes[node][side] = len(nodes) - 1
+			}
+			node = nodes[node][side]
+		}
+	}
+	offsets := []uint{}
+	data := ""
+	for _, network := range networks {
+		offsets = append(offsets, uint(len(data)))
+		data += network.data
+	}
+	tree := []byte{}
+	for _, node := range nodes {
+		var values [2]uint
+		for side, record := range node {
+			switch {
+			case record == -1:
+				values[side] = uint(len(nodes))
+			case record < 0:
+				values[side] = uint(len(nodes)) + dataSeparator + offsets[-2-record]
+			default:
+				values[side] = uint(record)
+			}
+		}
+		left, right := values[0], values[1]
+		switch recordSize {
+		case 24:
+			tree = append(tree, byte(left>>16), byte(left>>8), byte(left), byte(right>>16), byte(right>>8), byte(right))
+		case 28:
+			tree = append(tree, byte(left>>16), byte(left>>8), byte(left), byte(left>>20&0xf0|right>>24&0x0f), byte(right>>16), byte(right>>8), byte(right))
+		case 32:
+			tree = binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(tree, uint32(left)), uint32(right))
+		}
+	}
+	metadata := "\xe3" +
+		"\x4anode_count" + "\xc4" + string(binary.BigEndian.AppendUint32(nil, uint32(len(nodes)))) +
+		"\x4brecord_size" + "\xa2" + string(binary.BigEndian.AppendUint16(nil, uint16(recordSize))) +
+		"\x4aip_version" + "\xa1" + string([]byte{byte(ipVersion)})
+	return []byte(string(tree) + strings.Repeat("\x00", dataSeparator) + data + string(metadataMarker) + metadata)
+}
+
+func TestDecode(t *testing.T) {
+	// Examples of the MaxMind DB specification and its reference decoders.
+	for _, test := range []struct {
+		data  string
+		value interface{}
+	}{
+		{"\x43Foo", "Foo"},
+		{"\x40", ""},
+		{"\x5d\x00" + strings.Repeat("x", 29), strings.Repeat("x", 29)},
+		{"\x5e\x00\xd7" + strings.Repeat("x", 500), strings.Repeat("x", 500)},
+		{"\x5f\x00\x10\x53" + strings.Repeat("x", 70000), strings.Repeat("x", 70000)},
+		{"\x68\x40\x09\x21\xfb\x54\x44\x2d\x18", math.Pi},
+		{"\x04\x08\x3f\x80\x00\x00", 1.0},
+		{"\x83abc", []byte("abc")},
+		{"\xa0", uint64(0)},
+		{"\xa1\xff", uint64(255)},
+		{"\xa2\x01\xf4", uint64(500)},
+		{"\xc4\xff\xff\xff\xff", uint64(math.MaxUint32)},
+		{"\x01\x01\x01", int32(1)},
+		{"\x04\x01\xff\xff\xff\xff", int32(-1)},
+		{"\x08\x02\x01\x00\x00\x00\x00\x00\x00\x00", uint64(1) << 56},
+		{"\x00\x07", false},
+		{"\x01\x07", true},
+		{"\xe1\x42en\x43Foo", map[string]interface{}{"en": "Foo"}},
+		{"\x02\x04\x43Foo\x43Bar", []interface{}{"Foo", "Bar"}},
+	} {
+		value, next, err := (&decoder{data: []byte(test.data)}).decode(0, 0)
+		if err != nil || !reflect.DeepEqual(value, test.value) || next != uint(len(test.data)) {
+			t.Errorf("decoded % .20x as %#v up to %v, %v, want %#v", test.data, value, next, err, test.value)
+		}
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	// A map whose value points back to itself.
+	loop := "\xe1\x42en\x20\x00"
+	for _, data := range []string{
+		"",
+		"\x43Fo",
+		"\x5e\x00",
+		"\x64\x00\x00\x00\x00",
+		"\x04\x08\x3f\x80",
+		"\x03\x08\x3f\x80\x00",
+		"\xe1\xa1\x01\x43Foo",
+		"\x02\x04\x43Foo",
+		"\x00\x05",
+		loop,
+	} {
+		if value, _, err := (&decoder{data: []byte(data)}).decode(0, 0); err == nil {
+			t.Errorf("decoded % x as %#v", data, value)
+		}
+	}
+}
+
+func TestPointer(t *testing.T) {
+	// The pointer examples of the reference decoders.
+	for data, want := range map[string]uint{
+		"\x20\x00":             0,
+		"\x20\x05":             5,
+		"\x20\x0a":             10,
+		"\x23\xff":             1023,
+		"\x28\x03\xc9":         3017,
+		"\x2f\xf7\xfb":         524283,
+		"\x2f\xff\xff":         526335,
+		"\x37\xf7\xf7\xfe":     134217726,
+		"\x37\xff\xff\xff":     134744063,
+		"\x38\x7f\xff\xff\xff": 2147483647,
+	} {
+		pointer, next, err := (&decoder{data: []byte(data)}).pointer(data[0], 1)
+		if err != nil || pointer != want || next != uint(len(data)) {
+			t.Errorf("% x points to %v up to %v, %v, want %v", data, pointer, next, err, want)
+		}
+	}
+}
+
+func TestRecord(t *testing.T) {
+	for _, test := range []struct {
+		recordSize  uint
+		node        string
+		left, right uint
+	}{
+		{24, "\x12\x34\x56\xab\xcd\xef", 0x123456, 0xabcdef},
+		// The middle byte holds the high nibbles of both records.
+		{28, "\x12\x34\x56\xab\xcd\xef\x01", 0xa123456, 0xbcdef01},
+		{32, "\x12\x34\x56\x78\x9a\xbc\xde\xf0", 0x12345678, 0x9abcdef0},
+	} {
+		db := &mmdb{buffer: []byte(test.node), recordSize: test.recordSize}
+		if left, right := db.record(0, 0), db.record(0, 1); left != test.left || right != test.right {
+			t.Errorf("%v bit node % x has the records %#x and %#x, want %#x and %#x", test.recordSize, test.node, left, right, test.left, test.right)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	networks := []testNetwork{
+		{"192.0.2.0/24", "\xe1\x44name\x41a"},
+		// Pointers are relative to the data section.
+		{"198.51.100.0/25", "\xe1\x44name\x20\x06"},
+	}
+	for _, test := range []struct {
+		ipVersion uint
+		networks  []testNetwork
+	}{
+		{4, networks},
+		{6, append(networks, testNetwork{"2001:db8::/32", "\xe1\x44name\x41b"})},
+	} {
+		for _, recordSize := range []uint{24, 28, 32} {
+			db, err := parseMMDB(buildMMDB(t, recordSize, test.ipVersion, test.networks...))
+			if err != nil {
+				t.Fatalf("IPv%v, %v bit records: %v", test.ipVersion, recordSize, err)
+			}
+			records := map[string]interface{}{
+				"192.0.2.1":      map[string]interface{}{"name": "a"},
+				"192.0.2.255":    map[string]interface{}{"name": "a"},
+				"198.51.100.127": map[string]interface{}{"name": "a"},
+				"198.51.100.128": nil,
+				"203.0.113.1":    nil,
+				// IPv6 addresses aren't in IPv4 databases.
+				"2001:db8::1": nil,
+				"2001:db9::1": nil,
+			}
+			if test.ipVersion == 6 {
+				records["2001:db8::1"] = map[string]interface{}{"name": "b"}
+			}
+			for address, want := range records {
+				record, err := db.lookup(net.ParseIP(address))
+				if err != nil || !reflect.DeepEqual(record, want) {
+					t.Errorf("IPv%v, %v bit records: %v has the record %v, %v, want %v", test.ipVersion, recordSize, address, record, err, want)
+				}
+			}
+		}
+	}
+}
+
+func TestParseMMDBInvalid(t *testing.T) {
+	valid := buildMMDB(t, 24, 4, testNetwork{"192.0.2.0/24", "\x41a"})
+	marker := strings.LastIndex(string(valid), string(metadataMarker))
+	for name, buffer := range map[string]string{
+		"no metadata":      string(valid[:marker]),
+		"truncated tree":   string(valid[marker-dataSeparator:]),
+		"record size":      strings.Replace(string(valid), "record_size\xa2\x00\x18", "record_size\xa2\x00\x10", 1),
+		"no node count":    strings.Replace(string(valid), "node_count", "node_cnunt", 1),
+		"metadata not map": string(valid[:marker+len(metadataMarker)]) + "\x41a",
+	} {
+		if _, err := parseMMDB([]byte(buffer)); err == nil {
+			t.Errorf("%v: parsed", name)
+		}
+	}
+}
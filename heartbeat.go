@@ -0,0 +1,43 @@
+package main
+
+import (
+	"github.com/longkeyy/sshesame/honeypot"
+	"github.com/longkeyy/sshesame/output"
+	"github.com/longkeyy/sshesame/stats"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// authMessages are the messages of the events logged for every authentication
+// attempt.
+var authMessages = []string{
+	"Authentication with disabled method rejected",
+	"Keyboard-interactive authentication accepted",
+	"Keyboard-interactive authentication rejected",
+	"None authentication accepted",
+	"None authentication rejected",
+	"Password authentication accepted",
+	"Password authentication rejected",
+	"Public key authentication accepted",
+	"Public key authentication rejected",
+}
+
+// heartbeat logs a summary of the activity since startup every interval until
+// stop is closed, so that quiet periods still show signs of life.
+func heartbeat(counter *output.CounterSink, aggregates *stats.Stats, connects *honeypot.ConnectSampler, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+		log.WithFields(log.Fields{
+			"active_connections": aggregates.Active(),
+			"connections":        counter.Count("Client connected") + connects.Suppressed(),
+			"auth_attempts":      counter.Count(authMessages...),
+			"commands":           counter.Count("Command executed"),
+		}).Info("Heartbeat")
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"github.com/longkeyy/sshesame/output"
+	"github.com/longkeyy/sshesame/stats"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHeartbeat(t *testing.T) {
+	hook := captureLog(t)
+	counter := output.NewCounterSink()
+	for _, message := range []string{
+		"Client connected",
+		"Client connected",
+		"Password authentication rejected",
+		"Password authentication rejected",
+		"Public key authentication accepted",
+		"Command executed",
+		"Channel input received",
+	} {
+		counter.Emit(output.Event{Message: message})
+	}
+	aggregates := stats.New()
+	aggregates.RecordConnection(&net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 50000})
+	aggregates.RecordConnection(&net.TCPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 50000})
+	aggregates.RecordDisconnection()
+
+	const interval = 100 * time.Millisecond
+	started := time.Now()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		heartbeat(counter, aggregates, nil, interval, stop)
+		close(done)
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(hook.AllEntries()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no heartbeat logged")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	entry := hook.AllEntries()[0]
+	if entry.Message != "Heartbeat" {
+		t.Fatalf("logged %q, want a heartbeat", entry.Message)
+	}
+	if elapsed := entry.Time.Sub(started); elapsed < interval {
+		t.Errorf("heartbeat logged after %v, want at least %v", elapsed, interval)
+	}
+	for field, want := range map[string]interface{}{
+		"active_connections": 1,
+		"connections":        uint64(2),
+		"auth_attempts":      uint64(3),
+		"commands":           uint64(1),
+	} {
+		if entry.Data[field] != want {
+			t.Errorf("%v = %v, want %v", field, entry.Data[field], want)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package honeypot
+
+import (
+	"errors"
+	"github.com/longkeyy/sshesame/config"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+var errNoAnswer = errors.New("no answer")
+
+// advertisedMethods returns the authentication methods a server configured
+// with methods offers, those a client trying all of them got to use.
+func advertisedMethods(t *testing.T, methods config.Methods) []string {
+	t.Helper()
+	tried := []string{}
+	signer := newSigner(t)
+	// The answers are all wrong for the client to try every method.
+	_, err := dialTest(t, newTestServer(t, &config.Config{Auth: config.Auth{Methods: methods}}), &ssh.ClientConfig{
+		User: "root",
+		Auth: []ssh.AuthMethod{
+			ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+				tried = append(tried, "publickey")
+				return []ssh.Signer{signer}, nil
+			}),
+			ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+				tried = append(tried, "keyboard-interactive")
+				return nil, errNoAnswer
+			}),
+			ssh.PasswordCallback(func() (string, error) {
+				tried = append(tried, "password")
+				return "", errNoAnswer
+			}),
+		},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err == nil {
+		t.Fatalf("authenticated with methods %v, want every attempt rejected", methods)
+	}
+	sort.Strings(tried)
+	return tried
+}
+
+func TestAdvertisedMethods(t *testing.T) {
+	captureLog(t)
+	for _, test := range []struct {
+		methods    config.Methods
+		advertised []string
+	}{
+		{config.Methods{"publickey"}, []string{"publickey"}},
+		{config.Methods{"password", "keyboard-interactive"}, []string{"keyboard-interactive", "password"}},
+		{config.Methods{"password", "publickey", "keyboard-interactive"}, []string{"keyboard-interactive", "password", "publickey"}},
+		{config.Methods{}, []string{}},
+	} {
+		if advertised := advertisedMethods(t, test.methods); !reflect.DeepEqual(advertised, test.advertised) {
+			t.Errorf("methods %v advertised %v, want %v", test.methods, advertised, test.advertised)
+		}
+	}
+}
+
+func TestNoneAuthentication(t *testing.T) {
+	hook := captureLog(t)
+	for _, test := range []struct {
+		acceptNone bool
+		message    string
+	}{
+		{false, "None authentication rejected"},
+		{true, "None authentication accepted"},
+	} {
+		hook.Reset()
+		cfg := &config.Config{Auth: config.Auth{Methods: config.Methods{"password"}, AcceptNone: test.acceptNone}}
+		client, err := dialTest(t, newTestServer(t, cfg), &ssh.ClientConfig{
+			User:            "root",
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+		if (err == nil) != test.acceptNone {
+			t.Errorf("authenticating with none with AcceptNone %v: %v", test.acceptNone, err)
+		}
+		if err == nil {
+			client.Close()
+		}
+		entry := waitFor(t, hook, test.message)
+		if entry.Data["method"] != "none" || entry.Data["user"] != "root" || entry.Data["version"] != "SSH-2.0-Go" {
+			t.Errorf("logged %v, want the none attempt of root", entry.Data)
+		}
+	}
+}
+
+func TestBlockedClientsRejected(t *testing.T) {
+	hook := captureLog(t)
+	const cooldown = 200 * time.Millisecond
+	// Every key is rejected.
+	cfg := &config.Config{Auth: config.Auth{Methods: config.Methods{"publickey"}, BlockFailures: 2, BlockWindow: time.Minute, BlockCooldown: cooldown}}
+	address := serve(t, newTestServer(t, cfg))
+	clientConfig := &ssh.ClientConfig{
+		User:            "root",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(newSigner(t), newSigner(t))},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+	if _, err := ssh.Dial("tcp", address, clientConfig); err == nil {
+		t.Fatal("authenticated with a rejected key")
+	}
+	waitFor(t, hook, "Client temporarily blocked")
+	hook.Reset()
+	if _, err := ssh.Dial("tcp", address, clientConfig); err == nil {
+		t.Fatal("blocked client connected")
+	}
+	if entry := waitFor(t, hook, "Temporarily blocked client rejected"); entry.Data["until"] == nil {
+		t.Errorf("logged %v, want until when the client is blocked", entry.Data)
+	}
+
+	time.Sleep(cooldown)
+	hook.Reset()
+	ssh.Dial("tcp", address, clientConfig)
+	waitFor(t, hook, "Public key authentication rejected")
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Temporarily blocked client rejected" {
+			t.Error("client rejected after the cooldown")
+		}
+	}
+}
+
+func TestCredentialsFileAuthentication(t *testing.T) {
+	hook := captureLog(t)
+	file := filepath.Join(t.TempDir(), "weak.txt")
+	if err := ioutil.WriteFile(file, []byte("# Accepted\nroot:123456\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	credentials, err := config.LoadCredentialSources(config.CredentialsFiles{file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTestServer(t, &config.Config{Auth: config.Auth{Methods: config.Methods{"password"}, Credentials: credentials}})
+	login := func(password string) error {
+		client, err := dialTest(t, server, &ssh.ClientConfig{
+			User:            "root",
+			Auth:            []ssh.AuthMethod{ssh.Password(password)},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+		if err == nil {
+			client.Close()
+		}
+		return err
+	}
+	if err := login("123456"); err != nil {
+		t.Errorf("listed credential rejected: %v", err)
+	}
+	waitFor(t, hook, "Password authentication accepted")
+	hook.Reset()
+	if err := login("password"); err == nil {
+		t.Error("unlisted credential accepted")
+	}
+	waitFor(t, hook, "Password authentication rejected")
+
+	if err := ioutil.WriteFile(file, []byte("root:password\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := credentials.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err := login("password"); err != nil {
+		t.Errorf("credential added by reloading rejected: %v", err)
+	}
+}
+
+func TestCredentialsFilesLayered(t *testing.T) {
+	hook := captureLog(t)
+	dir := t.TempDir()
+	weak, local := filepath.Join(dir, "weak.txt"), filepath.Join(dir, "local.txt")
+	for file, content := range map[string]string{weak: "root:123456\nadmin:*\n", local: "root:toor\n"} {
+		if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	credentials, err := config.LoadCredentialSources(config.CredentialsFiles{weak, local})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTestServer(t, &config.Config{Auth: config.Auth{Methods: config.Methods{"password"}, Credentials: credentials}})
+	for _, test := range []struct {
+		user, password string
+		reason         interface{}
+	}{
+		{"root", "toor", "credential " + local + ":1"},
+		{"admin", "admin", "credential " + weak + ":2"},
+		// local.txt overrides the credentials of root.
+		{"root", "123456", nil},
+	} {
+		hook.Reset()
+		client, err := dialTest(t, server, &ssh.ClientConfig{
+			User:            test.user,
+			Auth:            []ssh.AuthMethod{ssh.Password(test.password)},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+		if err == nil {
+			client.Close()
+		}
+		if (err == nil) != (test.reason != nil) {
+			t.Errorf("%v:%v: dial = %v", test.user, test.password, err)
+		}
+		message := "Password authentication accepted"
+		if test.reason == nil {
+			message = "Password authentication rejected"
+		}
+		if entry := waitFor(t, hook, message); entry.Data["reason"] != test.reason {
+			t.Errorf("%v:%v: logged reason %v, want %v", test.user, test.password, entry.Data["reason"], test.reason)
+		}
+	}
+}
+
+func TestValidUsers(t *testing.T) {
+	hook := captureLog(t)
+	cfg := &config.Config{Auth: config.Auth{
+		Methods:    config.Methods{"password", "keyboard-interactive"},
+		AcceptNone: true,
+		Users:      config.Users{"root", "admin*"},
+	}}
+	server := newTestServer(t, cfg)
+	login := func(user string, auth ...ssh.AuthMethod) error {
+		client, err := dialTest(t, server, &ssh.ClientConfig{
+			User:            user,
+			Auth:            auth,
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+		if err == nil {
+			client.Close()
+		}
+		return err
+	}
+	answer := ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		return make([]string, len(questions)), nil
+	})
+	for _, user := range []string{"root", "administrator"} {
+		hook.Reset()
+		if err := login(user, ssh.Password("123456")); err != nil {
+			t.Errorf("%v rejected: %v", user, err)
+		}
+		for _, entry := range hook.AllEntries() {
+			if entry.Message == "Invalid user" {
+				t.Errorf("%v logged as an invalid user", user)
+			}
+		}
+	}
+	for _, auth := range [][]ssh.AuthMethod{nil, {ssh.Password("123456")}, {answer, ssh.Password("root")}} {
+		hook.Reset()
+		if err := login("bob", auth...); err == nil {
+			t.Errorf("bob accepted with %v methods", len(auth))
+		}
+		if entry := waitFor(t, hook, "Invalid user"); entry.Data["user"] != "bob" {
+			t.Errorf("invalid user logged with %v", entry.Data)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package honeypot
+
+import (
+	"github.com/longkeyy/sshesame/config"
+	"net"
+	"sync"
+	"time"
+)
+
+// sendBanner sends the server's identification line right away, like sshd
+// does, as scanners wait for it before sending their own. With a slow banner,
+// it's sent in chunks with delays between them, like a tarpit.
+func sendBanner(conn net.Conn, serverVersion string, cfg config.SlowBanner) error {
+	banner := []byte(serverVersion + "\r\n")
+	if cfg.ChunkSize <= 0 {
+		_, err := conn.Write(banner)
+		return err
+	}
+	for len(banner) > 0 {
+		size := cfg.ChunkSize
+		if size > len(banner) {
+			size = len(banner)
+		}
+		if _, err := conn.Write(banner[:size]); err != nil {
+			return err
+		}
+		banner = banner[size:]
+		if len(banner) > 0 {
+			time.Sleep(cfg.Delay)
+		}
+	}
+	return nil
+}
+
+// bannerSentConn discards the identification line the SSH library writes, as
+// sendBanner already sent it.
+type bannerSentConn struct {
+	net.Conn
+	mu   sync.Mutex
+	skip int
+}
+
+func newBannerSentConn(conn net.Conn, serverVersion string) *bannerSentConn {
+	return &bannerSentConn{Conn: conn, skip: len(serverVersion) + len("\r\n")}
+}
+
+func (conn *bannerSentConn) Write(data []byte) (int, error) {
+	conn.mu.Lock()
+	skipped := conn.skip
+	if skipped > len(data) {
+		skipped = len(data)
+	}
+	conn.skip -= skipped
+	conn.mu.Unlock()
+	if skipped == len(data) {
+		return len(data), nil
+	}
+	n, err := conn.Conn.Write(data[skipped:])
+	return skipped + n, err
+}
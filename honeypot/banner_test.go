@@ -0,0 +1,69 @@
+package honeypot
+
+import (
+	"github.com/longkeyy/sshesame/config"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writesConn records what is written to it, write by write, and when.
+type writesConn struct {
+	net.Conn
+	writes []string
+	times  []time.Time
+}
+
+func (conn *writesConn) Write(data []byte) (int, error) {
+	conn.writes = append(conn.writes, string(data))
+	conn.times = append(conn.times, time.Now())
+	return len(data), nil
+}
+
+func TestSlowBanner(t *testing.T) {
+	const delay = 30 * time.Millisecond
+	for _, test := range []struct {
+		chunkSize int
+		writes    []string
+	}{
+		{0, []string{"SSH-2.0-OpenSSH_8.9p1\r\n"}},
+		{8, []string{"SSH-2.0-", "OpenSSH_", "8.9p1\r\n"}},
+		{1000, []string{"SSH-2.0-OpenSSH_8.9p1\r\n"}},
+	} {
+		conn := &writesConn{}
+		start := time.Now()
+		if err := sendBanner(conn, "SSH-2.0-OpenSSH_8.9p1", config.SlowBanner{ChunkSize: test.chunkSize, Delay: delay}); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(conn.writes, test.writes) {
+			t.Errorf("chunks of %v: wrote %q, want %q", test.chunkSize, conn.writes, test.writes)
+			continue
+		}
+		if conn.times[0].Sub(start) >= delay {
+			t.Errorf("chunks of %v: first chunk sent after %v, want it right away", test.chunkSize, conn.times[0].Sub(start))
+		}
+		for i := 1; i < len(conn.times); i++ {
+			if gap := conn.times[i].Sub(conn.times[i-1]); gap < delay {
+				t.Errorf("chunks of %v: chunk %v sent %v after the previous one, want at least %v", test.chunkSize, i, gap, delay)
+			}
+		}
+		// No delay follows the last chunk.
+		if total, want := time.Since(start), time.Duration(len(test.writes))*delay; total >= want {
+			t.Errorf("chunks of %v: took %v, want less than %v", test.chunkSize, total, want)
+		}
+	}
+}
+
+func TestSlowBannerHandshake(t *testing.T) {
+	captureLog(t)
+	cfg := newConfig()
+	cfg.SlowBanner = config.SlowBanner{ChunkSize: 4, Delay: 10 * time.Millisecond}
+	// The identification timeout only counts once the banner was sent.
+	cfg.Limits.IdentificationTimeout = 20 * time.Millisecond
+	client := dial(t, newTestServer(t, cfg), ssh.Config{})
+	if version := string(client.ServerVersion()); version != "SSH-2.0-OpenSSH_8.9p1" {
+		t.Errorf("server version = %q, want the banner sent once", version)
+	}
+}
@@ -0,0 +1,80 @@
+package honeypot
+
+import (
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errBudgetExceeded = errors.New("connection budget exceeded")
+
+// budgetConn closes a connection once it transferred more than maxBytes in
+// both directions or was open for longer than maxLifetime. Either limit is
+// disabled if 0.
+type budgetConn struct {
+	net.Conn
+	maxBytes int64
+	bytes    int64
+	timer    *time.Timer
+	once     sync.Once
+}
+
+func newBudgetConn(conn net.Conn, maxBytes int64, maxLifetime time.Duration) *budgetConn {
+	budgetConn := &budgetConn{Conn: conn, maxBytes: maxBytes}
+	if maxLifetime > 0 {
+		budgetConn.timer = time.AfterFunc(maxLifetime, func() {
+			budgetConn.exceed("lifetime", maxLifetime.String())
+		})
+	}
+	return budgetConn
+}
+
+func (conn *budgetConn) Read(data []byte) (int, error) {
+	n, err := conn.Conn.Read(data)
+	if conn.count(n) {
+		return n, errBudgetExceeded
+	}
+	return n, err
+}
+
+func (conn *budgetConn) Write(data []byte) (int, error) {
+	n, err := conn.Conn.Write(data)
+	if conn.count(n) {
+		return n, errBudgetExceeded
+	}
+	return n, err
+}
+
+// count adds n bytes to the total, reporting whether the budget is exceeded.
+func (conn *budgetConn) count(n int) bool {
+	total := atomic.AddInt64(&conn.bytes, int64(n))
+	if conn.maxBytes <= 0 || total <= conn.maxBytes {
+		return false
+	}
+	conn.exceed("bytes", conn.maxBytes)
+	return true
+}
+
+// exceed logs that the budget is exceeded and closes the connection, once.
+func (conn *budgetConn) exceed(budget string, limit interface{}) {
+	conn.once.Do(func() {
+		log.WithFields(log.Fields{
+			"client":   conn.RemoteAddr(),
+			"budget":   budget,
+			"limit":    limit,
+			"bytes":    atomic.LoadInt64(&conn.bytes),
+			"category": "budget_exceeded",
+		}).Warning("Connection budget exceeded")
+		conn.Conn.Close()
+	})
+}
+
+func (conn *budgetConn) Close() error {
+	if conn.timer != nil {
+		conn.timer.Stop()
+	}
+	return conn.Conn.Close()
+}
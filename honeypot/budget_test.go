@@ -0,0 +1,78 @@
+package honeypot
+
+import (
+	"bytes"
+	"golang.org/x/crypto/ssh"
+	"testing"
+	"time"
+)
+
+func TestByteBudget(t *testing.T) {
+	hook := captureLog(t)
+	cfg := newConfig()
+	// The handshake alone takes a few kilobytes.
+	cfg.Limits.MaxConnectionBytes = 64 * 1024
+	client := dial(t, newTestServer(t, cfg), ssh.Config{})
+	// Neither channel exceeds the budget alone.
+	data := bytes.Repeat([]byte("A"), 32*1024)
+	for i := 0; i < 2; i++ {
+		channel, requests, err := client.OpenChannel("session", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go ssh.DiscardRequests(requests)
+		channel.Write(data)
+	}
+	closed := make(chan error)
+	go func() { closed <- client.Wait() }()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection still open after exceeding the byte budget")
+	}
+	entry := waitFor(t, hook, "Connection budget exceeded")
+	if entry.Data["category"] != "budget_exceeded" || entry.Data["budget"] != "bytes" || entry.Data["limit"] != cfg.Limits.MaxConnectionBytes || entry.Data["bytes"].(int64) <= cfg.Limits.MaxConnectionBytes {
+		t.Errorf("budget exceeded logged with %v", entry.Data)
+	}
+}
+
+func TestLifetimeBudget(t *testing.T) {
+	hook := captureLog(t)
+	cfg := newConfig()
+	cfg.Limits.MaxConnectionLifetime = 100 * time.Millisecond
+	start := time.Now()
+	client := dial(t, newTestServer(t, cfg), ssh.Config{})
+	closed := make(chan error)
+	go func() { closed <- client.Wait() }()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection still open after its lifetime")
+	}
+	if elapsed := time.Since(start); elapsed < cfg.Limits.MaxConnectionLifetime {
+		t.Errorf("connection closed after %v, want at least %v", elapsed, cfg.Limits.MaxConnectionLifetime)
+	}
+	if entry := waitFor(t, hook, "Connection budget exceeded"); entry.Data["budget"] != "lifetime" || entry.Data["limit"] != "100ms" {
+		t.Errorf("budget exceeded logged with %v", entry.Data)
+	}
+}
+
+func TestBudgetNotExceeded(t *testing.T) {
+	hook := captureLog(t)
+	cfg := newConfig()
+	cfg.Limits.MaxConnectionBytes = 1024 * 1024
+	cfg.Limits.MaxConnectionLifetime = time.Minute
+	client := dial(t, newTestServer(t, cfg), ssh.Config{})
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output, err := session.Output("echo hi"); err != nil || string(output) != "hi\n" {
+		t.Errorf("echo hi = %q, %v", output, err)
+	}
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Connection budget exceeded" {
+			t.Errorf("budget exceeded logged with %v", entry.Data)
+		}
+	}
+}
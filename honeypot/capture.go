@@ -0,0 +1,355 @@
+package honeypot
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"github.com/longkeyy/sshesame/config"
+	log "github.com/sirupsen/logrus"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// pcapLinkTypeRaw is the link type of packets starting with their IPv4
+	// or IPv6 header.
+	pcapLinkTypeRaw = 101
+	// captureSegmentSize is the most data put in a single made up segment.
+	captureSegmentSize = 32 * 1024
+)
+
+const (
+	tcpFIN = 0x01
+	tcpSYN = 0x02
+	tcpPSH = 0x08
+	tcpACK = 0x10
+)
+
+// captureWriter saves the traffic of a connection in a file format.
+type captureWriter interface {
+	// write saves data received from the client if inbound, or sent to it.
+	write(inbound bool, data []byte) error
+	Close() error
+}
+
+// captureConn saves the traffic of a connection as it reads and writes it.
+type captureConn struct {
+	net.Conn
+	remoteAddr net.Addr
+	paths      []string
+	maxBytes   int64
+	start      time.Time
+
+	mu              sync.Mutex
+	writer          captureWriter
+	received, sent  int64
+	truncated       bool
+	err             error
+	closeWriterOnce sync.Once
+}
+
+// newCaptureConn returns conn, saving its traffic to a new file of the
+// session id in cfg.Dir.
+func newCaptureConn(conn net.Conn, id string, cfg config.Capture) (*captureConn, error) {
+	start := time.Now()
+	host := conn.RemoteAddr().String()
+	if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		host = tcpAddr.IP.String()
+	}
+	base := filepath.Join(cfg.Dir, fmt.Sprintf("%v-%v-%v", start.UTC().Format("20060102T150405.000000000Z"), strings.NewReplacer(":", "_").Replace(host), id))
+	capture := &captureConn{Conn: conn, remoteAddr: conn.RemoteAddr(), maxBytes: cfg.MaxBytes, start: start}
+	if cfg.Format == "raw" {
+		writer, err := newRawCapture(base+".in", base+".out")
+		if err != nil {
+			return nil, err
+		}
+		capture.writer, capture.paths = writer, []string{base + ".in", base + ".out"}
+		return capture, nil
+	}
+	writer, err := newPcapCapture(base+".pcap", conn.RemoteAddr(), conn.LocalAddr())
+	if err != nil {
+		return nil, err
+	}
+	capture.writer, capture.paths = writer, []string{base + ".pcap"}
+	return capture, nil
+}
+
+func (conn *captureConn) Read(data []byte) (int, error) {
+	n, err := conn.Conn.Read(data)
+	conn.save(true, data[:n])
+	return n, err
+}
+
+func (conn *captureConn) Write(data []byte) (int, error) {
+	n, err := conn.Conn.Write(data)
+	conn.save(false, data[:n])
+	return n, err
+}
+
+// save saves data received or sent within MaxBytes. Captures stop at the
+// first error, the connection going on.
+func (conn *captureConn) save(inbound bool, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.err != nil {
+		return
+	}
+	total := &conn.sent
+	if inbound {
+		total = &conn.received
+	}
+	if conn.maxBytes > 0 && *total+int64(len(data)) > conn.maxBytes {
+		data = data[:conn.maxBytes-*total]
+		conn.truncated = true
+	}
+	*total += int64(len(data))
+	if len(data) != 0 {
+		conn.err = conn.writer.write(inbound, data)
+	}
+}
+
+// Close closes the connection and ends the capture, logging it.
+func (conn *captureConn) Close() error {
+	err := conn.Conn.Close()
+	conn.closeWriterOnce.Do(func() {
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		if closeErr := conn.writer.Close(); conn.err == nil {
+			conn.err = closeErr
+		}
+		if conn.err != nil {
+			log.Warning("Failed to capture traffic:", conn.err.Error())
+		}
+		log.WithFields(log.Fields{
+			"client":         conn.remoteAddr,
+			"path":           strings.Join(conn.paths, ","),
+			"bytes_received": conn.received,
+			"bytes_sent":     conn.sent,
+			"truncated":      conn.truncated,
+			"duration":       time.Since(conn.start).String(),
+		}).Info("Traffic captured")
+	})
+	return err
+}
+
+// rawCapture saves the bytes received and sent as they are, in two files.
+type rawCapture struct {
+	in, out *os.File
+}
+
+func newRawCapture(inPath, outPath string) (*rawCapture, error) {
+	in, err := os.OpenFile(inPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	out, err := os.OpenFile(outPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		in.Close()
+		return nil, err
+	}
+	return &rawCapture{in, out}, nil
+}
+
+func (capture *rawCapture) write(inbound bool, data []byte) error {
+	file := capture.out
+	if inbound {
+		file = capture.in
+	}
+	_, err := file.Write(data)
+	return err
+}
+
+func (capture *rawCapture) Close() error {
+	err := capture.in.Close()
+	if outErr := capture.out.Close(); err == nil {
+		err = outErr
+	}
+	return err
+}
+
+// pcapEndpoint is one end of a made up TCP connection.
+type pcapEndpoint struct {
+	ip   net.IP
+	port uint16
+	// seq is the sequence number of the next byte it sends.
+	seq uint32
+}
+
+// pcapCapture saves the traffic as the segments of a TCP connection between
+// the client and the server, opened and closed with the usual handshakes, so
+// that dissectors like Wireshark's follow the SSH stream as captured on the
+// wire, without the retransmissions and segmentation of the real one.
+type pcapCapture struct {
+	file           *os.File
+	writer         *bufio.Writer
+	client, server pcapEndpoint
+	ipv6           bool
+	ipID           uint16
+}
+
+// newPcapEndpoint returns the end of a made up connection at addr, the
+// unspecified address for those that aren't TCP ones, like the ends of pipes.
+func newPcapEndpoint(addr net.Addr) pcapEndpoint {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return pcapEndpoint{ip: net.IPv4zero, seq: rand.Uint32()}
+	}
+	return pcapEndpoint{ip: tcpAddr.IP, port: uint16(tcpAddr.Port), seq: rand.Uint32()}
+}
+
+func newPcapCapture(path string, clientAddr, serverAddr net.Addr) (*pcapCapture, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	capture := &pcapCapture{
+		file:   file,
+		writer: bufio.NewWriter(file),
+		client: newPcapEndpoint(clientAddr),
+		server: newPcapEndpoint(serverAddr),
+	}
+	capture.ipv6 = capture.client.ip.To4() == nil || capture.server.ip.To4() == nil
+	// The global header: magic number, version 2.4, no time zone offset or
+	// accuracy, the largest snapshot length and the link type.
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(header[4:], 2)
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], 65535)
+	binary.LittleEndian.PutUint32(header[20:], pcapLinkTypeRaw)
+	capture.writer.Write(header)
+	err = capture.segment(true, tcpSYN, nil)
+	if err == nil {
+		err = capture.segment(false, tcpSYN|tcpACK, nil)
+	}
+	if err == nil {
+		err = capture.segment(true, tcpACK, nil)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return capture, nil
+}
+
+func (capture *pcapCapture) write(inbound bool, data []byte) error {
+	for len(data) != 0 {
+		size := len(data)
+		if size > captureSegmentSize {
+			size = captureSegmentSize
+		}
+		if err := capture.segment(inbound, tcpPSH|tcpACK, data[:size]); err != nil {
+			return err
+		}
+		data = data[size:]
+	}
+	return nil
+}
+
+// Close closes the made up connection, the server first, and the file.
+func (capture *pcapCapture) Close() error {
+	err := capture.segment(false, tcpFIN|tcpACK, nil)
+	if err == nil {
+		err = capture.segment(true, tcpFIN|tcpACK, nil)
+	}
+	if err == nil {
+		err = capture.segment(false, tcpACK, nil)
+	}
+	if err == nil {
+		err = capture.writer.Flush()
+	}
+	if closeErr := capture.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// segment saves a segment with flags and payload sent by the client if
+// inbound, or the server.
+func (capture *pcapCapture) segment(inbound bool, flags byte, payload []byte) error {
+	source, destination := &capture.server, &capture.client
+	if inbound {
+		source, destination = &capture.client, &capture.server
+	}
+	tcp := make([]byte, 20+len(payload))
+	binary.BigEndian.PutUint16(tcp[0:], source.port)
+	binary.BigEndian.PutUint16(tcp[2:], destination.port)
+	binary.BigEndian.PutUint32(tcp[4:], source.seq)
+	if flags&tcpACK != 0 {
+		binary.BigEndian.PutUint32(tcp[8:], destination.seq)
+	}
+	tcp[12] = 5 << 4
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:], 65535)
+	copy(tcp[20:], payload)
+	source.seq += uint32(len(payload))
+	if flags&(tcpSYN|tcpFIN) != 0 {
+		source.seq++
+	}
+
+	var ip, pseudoHeader []byte
+	if capture.ipv6 {
+		ip = make([]byte, 40)
+		ip[0] = 6 << 4
+		binary.BigEndian.PutUint16(ip[4:], uint16(len(tcp)))
+		ip[6] = 6
+		ip[7] = 64
+		copy(ip[8:], source.ip.To16())
+		copy(ip[24:], destination.ip.To16())
+		pseudoHeader = make([]byte, 40)
+		copy(pseudoHeader, ip[8:40])
+		binary.BigEndian.PutUint32(pseudoHeader[32:], uint32(len(tcp)))
+		pseudoHeader[39] = 6
+	} else {
+		ip = make([]byte, 20)
+		ip[0] = 4<<4 | 5
+		binary.BigEndian.PutUint16(ip[2:], uint16(len(ip)+len(tcp)))
+		binary.BigEndian.PutUint16(ip[4:], capture.ipID)
+		capture.ipID++
+		binary.BigEndian.PutUint16(ip[6:], 0x4000)
+		ip[8] = 64
+		ip[9] = 6
+		copy(ip[12:], source.ip.To4())
+		copy(ip[16:], destination.ip.To4())
+		binary.BigEndian.PutUint16(ip[10:], checksum(ip))
+		pseudoHeader = make([]byte, 12)
+		copy(pseudoHeader, ip[12:20])
+		pseudoHeader[9] = 6
+		binary.BigEndian.PutUint16(pseudoHeader[10:], uint16(len(tcp)))
+	}
+	binary.BigEndian.PutUint16(tcp[16:], checksum(append(pseudoHeader, tcp...)))
+
+	now := time.Now()
+	record := make([]byte, 16)
+	binary.LittleEndian.PutUint32(record[0:], uint32(now.Unix()))
+	binary.LittleEndian.PutUint32(record[4:], uint32(now.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(record[8:], uint32(len(ip)+len(tcp)))
+	binary.LittleEndian.PutUint32(record[12:], uint32(len(ip)+len(tcp)))
+	capture.writer.Write(record)
+	capture.writer.Write(ip)
+	_, err := capture.writer.Write(tcp)
+	return err
+}
+
+// checksum returns the Internet checksum of data.
+func checksum(data []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
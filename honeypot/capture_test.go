@@ -0,0 +1,144 @@
+package honeypot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/longkeyy/sshesame/config"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestChecksum(t *testing.T) {
+	for _, test := range []struct {
+		data     string
+		checksum uint16
+	}{
+		// RFC 1071's example, and an IPv4 header with a zeroed checksum.
+		{"0001f203f4f5f6f7", 0x220d},
+		{"450000730000400040110000c0a80001c0a800c7", 0xb861},
+		{"01", 0xfeff},
+	} {
+		data, _ := hex.DecodeString(test.data)
+		if checksum := checksum(data); checksum != test.checksum {
+			t.Errorf("checksum of %v = %04x, want %04x", test.data, checksum, test.checksum)
+		}
+	}
+}
+
+func TestPcapSegment(t *testing.T) {
+	for _, test := range []struct {
+		client, server pcapEndpoint
+		ipv6           bool
+		inbound        bool
+		flags          byte
+		payload        string
+		packet         string
+		// next is the sequence number of the next byte the sender sends, the
+		// FIN counting as one.
+		next uint32
+	}{
+		{
+			pcapEndpoint{net.ParseIP("192.0.2.1"), 51000, 1000}, pcapEndpoint{net.ParseIP("198.51.100.2"), 22, 2000},
+			false, true, tcpPSH | tcpACK, "SSH-2.0-x\r\n",
+			"450000330007400040064e87c0000201c6336402" + "c7380016000003e8000007d05018ffff709a0000" + "5353482d322e302d780d0a",
+			1011,
+		},
+		{
+			pcapEndpoint{net.ParseIP("2001:db8::1"), 51000, 1011}, pcapEndpoint{net.ParseIP("2001:db8::2"), 22, 2000},
+			true, false, tcpFIN | tcpACK, "",
+			"6000000000140640" + "20010db8000000000000000000000002" + "20010db8000000000000000000000001" + "0016c738000007d0000003f35011ffff814d0000",
+			2001,
+		},
+	} {
+		buffer := &bytes.Buffer{}
+		capture := &pcapCapture{writer: bufio.NewWriter(buffer), client: test.client, server: test.server, ipv6: test.ipv6, ipID: 7}
+		if err := capture.segment(test.inbound, test.flags, []byte(test.payload)); err != nil {
+			t.Fatal(err)
+		}
+		capture.writer.Flush()
+		record := buffer.Bytes()
+		if packet := hex.EncodeToString(record[16:]); packet != test.packet {
+			t.Errorf("saved %v, want %v", packet, test.packet)
+		}
+		if length := binary.LittleEndian.Uint32(record[8:]); int(length) != len(test.packet)/2 || binary.LittleEndian.Uint32(record[12:]) != length {
+			t.Errorf("record header %x", record[:16])
+		}
+		sender := capture.server
+		if test.inbound {
+			sender = capture.client
+		}
+		if sender.seq != test.next {
+			t.Errorf("next sequence number %v, want %v", sender.seq, test.next)
+		}
+	}
+}
+
+func TestCaptureConn(t *testing.T) {
+	captureLog(t)
+	for _, format := range []config.CaptureFormat{"raw", "pcap"} {
+		dir := t.TempDir()
+		client, server := net.Pipe()
+		conn, err := newCaptureConn(server, "id", config.Capture{Dir: dir, Format: format, MaxBytes: 5})
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			client.Write([]byte("SSH-2.0-client\r\n"))
+			ioutil.ReadAll(client)
+		}()
+		buffer := make([]byte, 16)
+		if _, err := conn.Read(buffer); err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte("SSH-2.0-server\r\n"))
+		conn.Close()
+		client.Close()
+		if !conn.truncated || conn.received != 5 || conn.sent != 5 {
+			t.Errorf("%v: captured %v and %v bytes, truncated %v", format, conn.received, conn.sent, conn.truncated)
+		}
+
+		if format == "raw" {
+			for _, test := range []struct{ suffix, content string }{{".in", "SSH-2"}, {".out", "SSH-2"}} {
+				matches, _ := filepath.Glob(filepath.Join(dir, "*-id"+test.suffix))
+				if len(matches) != 1 {
+					t.Fatalf("saved %v", matches)
+				}
+				if content, err := ioutil.ReadFile(matches[0]); err != nil || string(content) != test.content {
+					t.Errorf("%v has %q, %v, want %q", matches[0], content, err, test.content)
+				}
+			}
+			continue
+		}
+		matches, _ := filepath.Glob(filepath.Join(dir, "*-pipe-id.pcap"))
+		if len(matches) != 1 {
+			t.Fatalf("saved %v", matches)
+		}
+		content, err := ioutil.ReadFile(matches[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header := hex.EncodeToString(content[:24]); header != "d4c3b2a1020004000000000000000000ffff000065000000" {
+			t.Errorf("global header %v", header)
+		}
+		// The handshake, a segment each way and the closing handshake, from
+		// and to the unspecified address of pipes.
+		flags := []byte{}
+		for record := content[24:]; len(record) >= 16; {
+			length := binary.LittleEndian.Uint32(record[8:])
+			packet := record[16 : 16+length]
+			if packet[0] != 0x45 || !net.IP(packet[12:16]).Equal(net.IPv4zero) || checksum(packet[:20]) != 0 {
+				t.Errorf("IPv4 header %x", packet[:20])
+			}
+			flags = append(flags, packet[33])
+			record = record[16+length:]
+		}
+		want := []byte{tcpSYN, tcpSYN | tcpACK, tcpACK, tcpPSH | tcpACK, tcpPSH | tcpACK, tcpFIN | tcpACK, tcpFIN | tcpACK, tcpACK}
+		if !bytes.Equal(flags, want) {
+			t.Errorf("segments with flags %x, want %x", flags, want)
+		}
+	}
+}
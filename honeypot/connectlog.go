@@ -0,0 +1,126 @@
+package honeypot
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxSampledClients bounds the clients whose suppressed connections are
+	// counted apart, the connections of further ones are only counted in
+	// total.
+	maxSampledClients = 1024
+	// topSampledClients is how many of the clients with the most suppressed
+	// connections a summary names.
+	topSampledClients = 5
+)
+
+// ConnectSampler only logs every nth Client connected event, along with the
+// first connection of every client, so that mass scans don't flood the logs.
+// The connections it suppresses are summarised periodically. Events of
+// connections that go on to authenticate are logged as usual.
+type ConnectSampler struct {
+	every int
+
+	mu sync.Mutex
+	// connects counts the connections of clients seen before.
+	connects int
+	// suppressed counts the connections suppressed since the last summary,
+	// by client, and total those of every client.
+	suppressed map[string]int
+	total      int
+	// allSuppressed counts every connection ever suppressed.
+	allSuppressed uint64
+}
+
+// NewConnectSampler returns a sampler logging every nth connection, or nil,
+// which logs every connection, if every is 1.
+func NewConnectSampler(every int) *ConnectSampler {
+	if every <= 1 {
+		return nil
+	}
+	return &ConnectSampler{every: every, suppressed: map[string]int{}}
+}
+
+// sample reports whether the connection from addr is logged, given whether
+// its client was never seen before, and counts it if it isn't.
+func (sampler *ConnectSampler) sample(addr net.Addr, firstSeen bool) bool {
+	if sampler == nil || firstSeen {
+		return true
+	}
+	sampler.mu.Lock()
+	defer sampler.mu.Unlock()
+	sampler.connects++
+	if sampler.connects%sampler.every == 0 {
+		return true
+	}
+	key := addr.String()
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		key = tcpAddr.IP.String()
+	}
+	if _, ok := sampler.suppressed[key]; ok || len(sampler.suppressed) < maxSampledClients {
+		sampler.suppressed[key]++
+	}
+	sampler.total++
+	sampler.allSuppressed++
+	return false
+}
+
+// Suppressed returns how many connections were ever suppressed.
+func (sampler *ConnectSampler) Suppressed() uint64 {
+	if sampler == nil {
+		return 0
+	}
+	sampler.mu.Lock()
+	defer sampler.mu.Unlock()
+	return sampler.allSuppressed
+}
+
+// Summarize logs how many connections were suppressed since the last summary
+// every interval, if any were, until stop is closed.
+func (sampler *ConnectSampler) Summarize(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+		sampler.mu.Lock()
+		suppressed, total := sampler.suppressed, sampler.total
+		sampler.suppressed, sampler.total = map[string]int{}, 0
+		sampler.mu.Unlock()
+		if total == 0 {
+			continue
+		}
+		clients := make([]string, 0, len(suppressed))
+		for client := range suppressed {
+			clients = append(clients, client)
+		}
+		sort.Slice(clients, func(i, j int) bool {
+			if suppressed[clients[i]] != suppressed[clients[j]] {
+				return suppressed[clients[i]] > suppressed[clients[j]]
+			}
+			return clients[i] < clients[j]
+		})
+		if len(clients) > topSampledClients {
+			clients = clients[:topSampledClients]
+		}
+		top := make([]string, len(clients))
+		for i, client := range clients {
+			top[i] = fmt.Sprintf("%v=%v", client, suppressed[client])
+		}
+		log.WithFields(log.Fields{
+			"suppressed":  total,
+			"clients":     len(suppressed),
+			"top_clients": strings.Join(top, " "),
+			"interval":    interval.String(),
+		}).Info("Client connections sampled")
+	}
+}
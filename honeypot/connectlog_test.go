@@ -0,0 +1,92 @@
+package honeypot
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnectSampler(t *testing.T) {
+	hook := captureLog(t)
+	if NewConnectSampler(1) != nil {
+		t.Error("NewConnectSampler(1) samples, want every connection logged")
+	}
+	sampler := NewConnectSampler(10)
+	scanner := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 50000}
+	other := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 50000}
+	logged := 0
+	for i := 0; i < 100; i++ {
+		addr := scanner
+		if i%4 == 0 {
+			addr = other
+		}
+		if sampler.sample(addr, false) {
+			logged++
+		}
+	}
+	// The first connection of a client is always logged.
+	if !sampler.sample(&net.TCPAddr{IP: net.IPv4(192, 0, 2, 3)}, true) {
+		t.Error("first connection of a client suppressed")
+	}
+	if logged != 10 || sampler.Suppressed() != 90 {
+		t.Errorf("logged %v of 100 connections, suppressed %v, want every 10th logged", logged, sampler.Suppressed())
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sampler.Summarize(10*time.Millisecond, stop)
+	}()
+	entry := waitFor(t, hook, "Client connections sampled")
+	close(stop)
+	<-done
+	if entry.Data["suppressed"] != 90 || entry.Data["clients"] != 2 || entry.Data["top_clients"] != "192.0.2.1=65 192.0.2.2=25" {
+		t.Errorf("summary logged with %v, want the 90 suppressed connections by client", entry.Data)
+	}
+	// Nothing is summarised again until more connections are suppressed.
+	count := 0
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Client connections sampled" {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("logged %v summaries, want 1", count)
+	}
+}
+
+func TestConnectSamplerServed(t *testing.T) {
+	hook := captureLog(t)
+	server := newTestServer(t, newConfig())
+	server.Connects = NewConnectSampler(5)
+	address := serve(t, server)
+	for i := 0; i < 20; i++ {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for server.Connects.Suppressed() != 16 {
+		if time.Now().After(deadline) {
+			t.Fatalf("suppressed %v connections, want 16", server.Connects.Suppressed())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// The first connection and every 5th of the 19 others.
+	connected := 0
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Client connected" {
+			connected++
+		}
+	}
+	if connected != 4 {
+		t.Errorf("logged %v of 20 connections, want 4", connected)
+	}
+	// Connections going on to authenticate are logged in full all the same.
+	hook.Reset()
+	dialEventually(t, address)
+	waitFor(t, hook, "SSH connection established")
+}
@@ -0,0 +1,102 @@
+package honeypot
+
+import (
+	"fmt"
+	"github.com/longkeyy/sshesame/config"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"golang.org/x/crypto/ssh"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mixedAuth returns the authentication methods of a client offering two keys,
+// then trying passwords, wrong ones first.
+func mixedAuth(t *testing.T, wrong int) []ssh.AuthMethod {
+	tries := 0
+	return []ssh.AuthMethod{
+		ssh.PublicKeys(newSigner(t), newSigner(t)),
+		ssh.RetryableAuthMethod(ssh.PasswordCallback(func() (string, error) {
+			tries++
+			if tries <= wrong {
+				return fmt.Sprint("wrong", tries), nil
+			}
+			return "123456", nil
+		}), wrong+1),
+	}
+}
+
+// waitForPrefix returns the first entry logged with a message starting with
+// prefix, waiting for the server to log it.
+func waitForPrefix(t *testing.T, hook *logtest.Hook, prefix string) *log.Entry {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		for _, entry := range hook.AllEntries() {
+			if strings.HasPrefix(entry.Message, prefix) {
+				return entry
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("nothing starting with %q was logged", prefix)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAuthCountsByMethod(t *testing.T) {
+	hook := captureLog(t)
+	cfg := newConfig()
+	cfg.Auth.Methods = config.Methods{"password", "publickey"}
+	cfg.Auth.PasswordRules = config.PasswordRules{{Password: regexp.MustCompile("^wrong")}}
+	client, err := dialTest(t, newTestServer(t, cfg), &ssh.ClientConfig{
+		User:            "root",
+		Auth:            mixedAuth(t, 2),
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attempts := map[string]int{"none": 1, "publickey": 2, "password": 3}
+	failures := map[string]int{"none": 1, "publickey": 2, "password": 2}
+	established := waitFor(t, hook, "SSH connection established")
+	client.Close()
+	for _, entry := range []*log.Entry{established, waitFor(t, hook, "Client disconnected")} {
+		if !reflect.DeepEqual(entry.Data["auth_attempts"], attempts) || !reflect.DeepEqual(entry.Data["auth_failures"], failures) || entry.Data["keys_offered"] != 2 {
+			t.Errorf("%v logged attempts %v, failures %v and %v keys, want %v, %v and 2", entry.Message, entry.Data["auth_attempts"], entry.Data["auth_failures"], entry.Data["keys_offered"], attempts, failures)
+		}
+	}
+	// Failures count across methods, the first none attempt aside.
+	rejections := []interface{}{}
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Password authentication rejected" {
+			rejections = append(rejections, entry.Data["failed_attempts"])
+		}
+	}
+	if want := []interface{}{2, 3}; !reflect.DeepEqual(rejections, want) {
+		t.Errorf("passwords rejected after %v failed attempts, want %v", rejections, want)
+	}
+}
+
+func TestMaxAuthTriesAcrossMethods(t *testing.T) {
+	hook := captureLog(t)
+	cfg := newConfig()
+	cfg.Auth.Methods = config.Methods{"password", "publickey"}
+	cfg.Auth.PasswordRules = config.PasswordRules{{Password: regexp.MustCompile("^wrong")}}
+	cfg.Auth.MaxAuthTries = 3
+	if _, err := dialTest(t, newTestServer(t, cfg), &ssh.ClientConfig{
+		User:            "root",
+		Auth:            mixedAuth(t, 5),
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}); err == nil {
+		t.Fatal("authenticated after more than 3 failed attempts")
+	}
+	// Both keys count, leaving a single password attempt.
+	entry := waitForPrefix(t, hook, "Failed to establish SSH connection:")
+	if attempts := entry.Data["auth_attempts"]; !reflect.DeepEqual(attempts, map[string]int{"none": 1, "publickey": 2, "password": 1}) {
+		t.Errorf("disconnected after attempts %v, want 2 keys and 1 password", attempts)
+	}
+}
@@ -0,0 +1,112 @@
+package honeypot
+
+import (
+	"github.com/longkeyy/sshesame/auth"
+	"github.com/longkeyy/sshesame/config"
+	"golang.org/x/crypto/ssh"
+	"testing"
+)
+
+// windowDecider accepts root only while open, like a decider only letting
+// clients in during some hours. With partial, passwords only partially
+// succeed.
+type windowDecider struct {
+	open, partial bool
+}
+
+func (decider windowDecider) decide(attempt auth.Attempt) auth.Decision {
+	if !decider.open || attempt.Conn.User() != "root" {
+		return auth.Decision{Outcome: auth.Reject, Reason: "outside window"}
+	}
+	return auth.Decision{Outcome: auth.Accept, Reason: "inside window"}
+}
+
+func (decider windowDecider) Password(attempt auth.Attempt, password string) auth.Decision {
+	decision := decider.decide(attempt)
+	if decider.partial && decision.Outcome == auth.Accept {
+		decision.Outcome = auth.Partial
+	}
+	return decision
+}
+
+func (decider windowDecider) PublicKey(attempt auth.Attempt, key ssh.PublicKey) auth.Decision {
+	return decider.decide(attempt)
+}
+
+func (decider windowDecider) KeyboardInteractive(attempt auth.Attempt, responses map[string]string) auth.Decision {
+	if responses["password"] != "123456" {
+		return auth.Decision{Outcome: auth.Reject, Reason: "wrong answer"}
+	}
+	return decider.decide(attempt)
+}
+
+func TestCustomDecider(t *testing.T) {
+	hook := captureLog(t)
+	cfg := newConfig()
+	cfg.Auth.Methods = config.Methods{"password", "publickey", "keyboard-interactive"}
+	answer := ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		for i := range answers {
+			answers[i] = "123456"
+		}
+		return answers, nil
+	})
+	for _, test := range []struct {
+		method  string
+		auth    ssh.AuthMethod
+		message string
+	}{
+		{"password", ssh.Password("123456"), "Password authentication"},
+		{"publickey", ssh.PublicKeys(newSigner(t)), "Public key authentication"},
+		{"keyboard-interactive", answer, "Keyboard-interactive authentication"},
+	} {
+		for _, open := range []bool{true, false} {
+			hook.Reset()
+			server := newTestServer(t, cfg)
+			server.Decider = windowDecider{open: open}
+			client, err := dialTest(t, server, &ssh.ClientConfig{
+				User:            "root",
+				Auth:            []ssh.AuthMethod{test.auth},
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			})
+			if (err == nil) != open {
+				t.Errorf("%v while open %v: dial = %v", test.method, open, err)
+			}
+			if client != nil {
+				client.Close()
+			}
+			message, reason := test.message+" accepted", "inside window"
+			if !open {
+				message, reason = test.message+" rejected", "outside window"
+			}
+			if entry := waitFor(t, hook, message); entry.Data["reason"] != reason {
+				t.Errorf("%v while open %v: logged %v, want the decider's reason %q", test.method, open, entry.Data, reason)
+			}
+		}
+	}
+}
+
+func TestCustomDeciderPartial(t *testing.T) {
+	hook := captureLog(t)
+	cfg := newConfig()
+	cfg.Auth.Methods = config.Methods{"password", "publickey"}
+	server := newTestServer(t, cfg)
+	server.Decider = windowDecider{open: true, partial: true}
+	clientConfig := &ssh.ClientConfig{
+		User:            "root",
+		Auth:            []ssh.AuthMethod{ssh.Password("123456")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+	// The password alone isn't enough.
+	if _, err := dialTest(t, server, clientConfig); err == nil {
+		t.Error("authenticated with a partially successful password alone")
+	}
+	waitFor(t, hook, "Password authentication partially succeeded")
+	// The client may go on with a key after it.
+	clientConfig.Auth = append(clientConfig.Auth, ssh.PublicKeys(newSigner(t)))
+	client, err := dialTest(t, server, clientConfig)
+	if err != nil {
+		t.Fatalf("dial with a password then a key = %v", err)
+	}
+	client.Close()
+}
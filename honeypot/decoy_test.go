@@ -0,0 +1,94 @@
+package honeypot
+
+import (
+	"github.com/longkeyy/sshesame/config"
+	"golang.org/x/crypto/ssh"
+	"testing"
+)
+
+func TestDecoyRejectsEverything(t *testing.T) {
+	hook := captureLog(t)
+	cfg := newConfig()
+	cfg.Auth.Methods = config.Methods{"password", "publickey", "keyboard-interactive"}
+	cfg.Auth.AcceptNone = true
+	cfg.Auth.Decoy = true
+	cfg.Auth.DecoyBanner = "This system is down for scheduled maintenance.\n"
+	cfg.Auth.DecoyMessage = "Access denied.\n"
+	server := newTestServer(t, cfg)
+	// Not even a decider accepting everyone lets clients in.
+	server.Decider = windowDecider{open: true}
+	var banners []string
+	_, err := dialTest(t, server, &ssh.ClientConfig{
+		User: "root",
+		Auth: []ssh.AuthMethod{
+			ssh.Password("123456"),
+			ssh.PublicKeys(newSigner(t)),
+			ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+				answers := make([]string, len(questions))
+				for i := range answers {
+					answers[i] = "123456"
+				}
+				return answers, nil
+			}),
+		},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		BannerCallback: func(message string) error {
+			banners = append(banners, message)
+			return nil
+		},
+	})
+	if err == nil {
+		t.Fatal("authenticated in decoy mode")
+	}
+
+	waitFor(t, hook, "None authentication rejected")
+	if entry := waitFor(t, hook, "Password authentication rejected"); entry.Data["password"] != "123456" || entry.Data["reason"] != "decoy" {
+		t.Errorf("password attempt logged with %v, want the password rejected as decoy", entry.Data)
+	}
+	if entry := waitFor(t, hook, "Public key authentication rejected"); entry.Data["fingerprint"] == nil || entry.Data["reason"] != "decoy" {
+		t.Errorf("key attempt logged with %v, want the key rejected as decoy", entry.Data)
+	}
+	if entry := waitFor(t, hook, "Keyboard-interactive authentication rejected"); entry.Data["reason"] != "decoy" {
+		t.Errorf("keyboard-interactive attempt logged with %v, want it rejected as decoy", entry.Data)
+	}
+	waitFor(t, hook, "Decoy banner sent")
+	if len(banners) == 0 || banners[0] != cfg.Auth.DecoyBanner {
+		t.Fatalf("client was shown %q, want the decoy banner first", banners)
+	}
+	for _, message := range banners[1:] {
+		if message != cfg.Auth.DecoyMessage {
+			t.Errorf("client was shown %q after the banner, want only the rejection message", message)
+		}
+	}
+	if len(banners) < 2 {
+		t.Errorf("client was shown %q, want the rejection message too", banners)
+	}
+}
+
+func TestDecoyWithoutBanner(t *testing.T) {
+	hook := captureLog(t)
+	cfg := newConfig()
+	cfg.Auth.Decoy = true
+	var banners []string
+	_, err := dialTest(t, newTestServer(t, cfg), &ssh.ClientConfig{
+		User:            "root",
+		Auth:            []ssh.AuthMethod{ssh.Password("123456")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		BannerCallback: func(message string) error {
+			banners = append(banners, message)
+			return nil
+		},
+	})
+	if err == nil {
+		t.Fatal("authenticated in decoy mode")
+	}
+	waitFor(t, hook, "Password authentication rejected")
+	if len(banners) != 0 {
+		t.Errorf("client was shown %q, want no banner", banners)
+	}
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Decoy banner sent" {
+			t.Error("logged a decoy banner that wasn't set")
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package honeypot
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"github.com/longkeyy/sshesame/config"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"io/ioutil"
+	"net"
+	"time"
+)
+
+// disconnectLinger is how long connections are drained of what clients sent
+// after a disconnect, so they aren't reset before reading it.
+const disconnectLinger = 2 * time.Second
+
+// RFC 4253 section 11.1
+type disconnectMsg struct {
+	Reason   uint32 `sshtype:"1"`
+	Message  string
+	Language string
+}
+
+// sendDisconnect sends the server's identification line and disconnect to a
+// client whose connection is refused, then closes it. As no keys were
+// exchanged yet, the disconnect is sent in a plaintext packet.
+func sendDisconnect(conn net.Conn, serverVersion string, disconnect config.Disconnect) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(disconnectLinger))
+	if _, err := io.WriteString(conn, serverVersion+"\r\n"); err != nil {
+		return
+	}
+	if _, err := conn.Write(plaintextPacket(ssh.Marshal(disconnectMsg{Reason: disconnect.Code, Message: disconnect.Message}))); err != nil {
+		return
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.CloseWrite()
+	}
+	io.Copy(ioutil.Discard, conn)
+}
+
+// plaintextPacket frames payload as a binary packet without encryption or MAC
+// (RFC 4253 section 6).
+func plaintextPacket(payload []byte) []byte {
+	const blockSize = 8
+	padding := blockSize - (5+len(payload))%blockSize
+	if padding < 4 {
+		padding += blockSize
+	}
+	packet := make([]byte, 5+len(payload)+padding)
+	binary.BigEndian.PutUint32(packet, uint32(1+len(payload)+padding))
+	packet[4] = byte(padding)
+	copy(packet[5:], payload)
+	rand.Read(packet[5+len(payload):])
+	return packet
+}
@@ -0,0 +1,75 @@
+package honeypot
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/longkeyy/sshesame/auth"
+	"github.com/longkeyy/sshesame/config"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPolicyDisconnects(t *testing.T) {
+	hook := captureLog(t)
+	loopback := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	for _, test := range []struct {
+		reason, message string
+		code            uint32
+		logged          string
+		setUp           func(t *testing.T, server *Server, address string)
+	}{
+		{"denylisted", "Not allowed at this time", 1, "Denylisted client rejected", func(t *testing.T, server *Server, address string) {
+			server.Denylist, _ = auth.LoadDenylist("")
+			server.Denylist.Add("127.0.0.1")
+		}},
+		{"blocked", "Too many authentication failures", 2, "Temporarily blocked client rejected", func(t *testing.T, server *Server, address string) {
+			server.blocker.RecordFailure(loopback)
+		}},
+		{"per_ip_limit", "Too many connections", 12, "Client over per-IP limit rejected", func(t *testing.T, server *Server, address string) {
+			// Held open by a client yet to identify itself.
+			conn, err := net.Dial("tcp", address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { conn.Close() })
+			if _, err := bufio.NewReader(conn).ReadString('\n'); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		// Messages are configurable, codes aren't.
+		{"denylisted", "Go away", 1, "Denylisted client rejected", func(t *testing.T, server *Server, address string) {
+			server.Denylist, _ = auth.LoadDenylist("")
+			server.Denylist.Add("127.0.0.1")
+		}},
+	} {
+		hook.Reset()
+		cfg := newConfig()
+		cfg.Disconnects = config.DefaultDisconnects()
+		if err := cfg.Disconnects.Set(test.reason + "=" + test.message); err != nil {
+			t.Fatal(err)
+		}
+		cfg.Auth.BlockFailures = 1
+		cfg.Auth.BlockWindow = time.Minute
+		cfg.Auth.BlockCooldown = time.Minute
+		cfg.Limits.MaxConnectionsPerIP = 1
+		server := newTestServer(t, cfg)
+		address := serve(t, server)
+		test.setUp(t, server, address)
+
+		_, err := ssh.Dial("tcp", address, &ssh.ClientConfig{
+			User:            "root",
+			Auth:            []ssh.AuthMethod{ssh.Password("password")},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+		if want := fmt.Sprintf("ssh: disconnect, reason %v: %q", test.code, test.message); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%v: dial = %v, want %q", test.reason, err, want)
+		}
+		entry := waitFor(t, hook, test.logged)
+		if entry.Data["disconnect_code"] != test.code || entry.Data["disconnect_message"] != test.message {
+			t.Errorf("%v: logged %v, want what was sent", test.reason, entry.Data)
+		}
+	}
+}
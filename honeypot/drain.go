@@ -0,0 +1,101 @@
+package honeypot
+
+import (
+	log "github.com/sirupsen/logrus"
+	"net"
+	"sync"
+	"time"
+)
+
+// connections tracks the open connections of every server, so that they can
+// be drained on shutdown.
+type connections struct {
+	mu   sync.Mutex
+	open map[net.Conn]*openConnection
+	wg   sync.WaitGroup
+	// shutdown is closed once draining starts, notifying the shells of the
+	// open connections.
+	shutdown chan struct{}
+}
+
+// openConnection is when a tracked connection was accepted, and the ID of its
+// session once it's known.
+type openConnection struct {
+	start     time.Time
+	sessionID string
+}
+
+func newConnections() *connections {
+	return &connections{open: map[net.Conn]*openConnection{}, shutdown: make(chan struct{})}
+}
+
+// add tracks conn until done is called for it.
+func (connections *connections) add(conn net.Conn) {
+	connections.mu.Lock()
+	defer connections.mu.Unlock()
+	connections.open[conn] = &openConnection{start: time.Now()}
+	connections.wg.Add(1)
+}
+
+// identify records the ID of the session of conn, if it's tracked.
+func (connections *connections) identify(conn net.Conn, sessionID string) {
+	connections.mu.Lock()
+	defer connections.mu.Unlock()
+	if open, ok := connections.open[conn]; ok {
+		open.sessionID = sessionID
+	}
+}
+
+// done stops tracking conn once it's handled.
+func (connections *connections) done(conn net.Conn) {
+	connections.mu.Lock()
+	defer connections.mu.Unlock()
+	delete(connections.open, conn)
+	connections.wg.Done()
+}
+
+// drain notifies the open connections of the shutdown, waits up to timeout
+// for them to finish, including their channels and recordings, then closes
+// those that didn't, and logs how many of each there were. No connections may
+// be added once draining has started.
+func (connections *connections) drain(timeout time.Duration) {
+	close(connections.shutdown)
+	connections.mu.Lock()
+	open := len(connections.open)
+	connections.mu.Unlock()
+	finished := make(chan struct{})
+	go func() {
+		connections.wg.Wait()
+		close(finished)
+	}()
+	forceClosed := 0
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-finished:
+	case <-timer.C:
+		connections.mu.Lock()
+		for conn, open := range connections.open {
+			log.WithFields(log.Fields{
+				"client":     conn.RemoteAddr(),
+				"session_id": open.sessionID,
+				"duration":   time.Since(open.start).String(),
+			}).Warning("Connection force-closed after drain timeout")
+			conn.Close()
+			forceClosed++
+		}
+		connections.mu.Unlock()
+		<-finished
+	}
+	log.WithFields(log.Fields{
+		"drained":      open - forceClosed,
+		"force_closed": forceClosed,
+	}).Info("Connections drained")
+}
+
+// Drain waits up to timeout for the connections the server and the ones
+// derived from it accepted to finish, then closes those that didn't. The
+// servers may not accept connections anymore.
+func (server *Server) Drain(timeout time.Duration) {
+	server.connections.drain(timeout)
+}
@@ -0,0 +1,103 @@
+package honeypot
+
+import (
+	"golang.org/x/crypto/ssh"
+	"net"
+	"testing"
+	"time"
+)
+
+// openSession logs in to the server listening on address and starts a shell,
+// which stays open until the connection is closed.
+func openSession(t *testing.T, address string) *ssh.Client {
+	t.Helper()
+	client, err := ssh.Dial("tcp", address, &ssh.ClientConfig{
+		User:            "root",
+		Auth:            []ssh.AuthMethod{ssh.Password("password")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// listen serves connections to server on a new loopback listener, returning
+// its address and a function to stop accepting connections.
+func listen(t *testing.T, server *Server) (string, func()) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shutdown := make(chan struct{})
+	go server.Serve(listener, shutdown)
+	return listener.Addr().String(), func() {
+		close(shutdown)
+		listener.Close()
+	}
+}
+
+func TestDrainForceCloses(t *testing.T) {
+	hook := captureLog(t)
+	server := newTestServer(t, newConfig())
+	address, stop := listen(t, server)
+	client := openSession(t, address)
+	defer client.Close()
+
+	stop()
+	start := time.Now()
+	server.Drain(100 * time.Millisecond)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Drain took %v, want it bounded by its deadline", elapsed)
+	}
+	entry := waitFor(t, hook, "Connection force-closed after drain timeout")
+	if id, ok := entry.Data["session_id"].(string); !ok || len(id) != 36 {
+		t.Errorf("session_id = %v, want the ID of the hung session", entry.Data["session_id"])
+	}
+	if duration, err := time.ParseDuration(entry.Data["duration"].(string)); err != nil || duration < 100*time.Millisecond {
+		t.Errorf("duration = %v, want at least the deadline", entry.Data["duration"])
+	}
+	if entry := waitFor(t, hook, "Connections drained"); entry.Data["drained"] != 0 || entry.Data["force_closed"] != 1 {
+		t.Errorf("summary logged with %v, want 1 force-closed", entry.Data)
+	}
+	if err := client.Wait(); err == nil {
+		t.Error("connection ended cleanly, want it force-closed")
+	}
+}
+
+func TestDrainWaitsForSessions(t *testing.T) {
+	hook := captureLog(t)
+	server := newTestServer(t, newConfig())
+	address, stop := listen(t, server)
+	client := openSession(t, address)
+
+	stop()
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		server.Drain(5 * time.Second)
+	}()
+	select {
+	case <-drained:
+		t.Fatal("Drain returned with a session still open")
+	case <-time.After(100 * time.Millisecond):
+	}
+	client.Close()
+	<-drained
+	if entry := waitFor(t, hook, "Connections drained"); entry.Data["drained"] != 1 || entry.Data["force_closed"] != 0 {
+		t.Errorf("summary logged with %v, want 1 drained", entry.Data)
+	}
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Connection force-closed after drain timeout" {
+			t.Error("finished session force-closed")
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package honeypot
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"strings"
+	"sync"
+)
+
+// maxPacketSize is the largest packet RFC 4253 section 6.1 requires to be
+// supported.
+const maxPacketSize = 35000
+
+// maxHandshakeSize bounds how much is recorded while waiting for the client to
+// complete the key exchange.
+const maxHandshakeSize = maxIdentificationSize + 4*maxPacketSize
+
+// Message numbers of RFC 4253 section 12, those from msgKexFirst to
+// msgKexLast are specific to the key exchange method.
+const (
+	msgKexInit  = 20
+	msgNewKeys  = 21
+	msgKexFirst = 30
+	msgKexLast  = 49
+)
+
+// Handshake stages reached by clients, see recordingConn.stage.
+const (
+	stageBanner  = "banner"
+	stageKexInit = "kexinit"
+	stageKex     = "kex"
+)
+
+// kexInit is the SSH_MSG_KEXINIT message of RFC 4253 section 7.1.
+type kexInit struct {
+	Cookie                  [16]byte `sshtype:"20"`
+	KexAlgorithms           []string
+	HostKeyAlgorithms       []string
+	CiphersClientServer     []string
+	CiphersServerClient     []string
+	MACsClientServer        []string
+	MACsServerClient        []string
+	CompressionClientServer []string
+	CompressionServerClient []string
+	LanguagesClientServer   []string
+	LanguagesServerClient   []string
+	FirstKexFollows         bool
+	Reserved                uint32
+}
+
+// recordingConn records what a client sends until its identification line
+// and the unencrypted packets of its first key exchange, up to its NEWKEYS,
+// were received.
+type recordingConn struct {
+	net.Conn
+	mu       sync.Mutex
+	recorded []byte
+	done     bool
+}
+
+func (conn *recordingConn) Read(data []byte) (int, error) {
+	n, err := conn.Conn.Read(data)
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if !conn.done {
+		conn.recorded = append(conn.recorded, data[:n]...)
+		_, packets := splitHandshake(conn.recorded)
+		conn.done = newKeysSent(packets) || len(conn.recorded) >= maxHandshakeSize
+	}
+	return n, err
+}
+
+// kexInit returns the identification and KEXINIT the client sent, if they
+// were received and can be parsed.
+func (conn *recordingConn) kexInit() (string, *kexInit, bool) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	identification, packets := splitHandshake(conn.recorded)
+	if len(packets) == 0 {
+		return "", nil, false
+	}
+	msg := &kexInit{}
+	if err := ssh.Unmarshal(packets[0], msg); err != nil {
+		return "", nil, false
+	}
+	return identification, msg, true
+}
+
+// stage returns how far the client got: stageBanner if it only sent its
+// identification, stageKexInit if it also sent its KEXINIT and stageKex if it
+// went on with the key exchange, which got it the host key.
+func (conn *recordingConn) stage() string {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	_, packets := splitHandshake(conn.recorded)
+	stage := stageBanner
+	for _, packet := range packets {
+		switch {
+		case len(packet) == 0:
+		case packet[0] >= msgKexFirst && packet[0] <= msgKexLast:
+			return stageKex
+		case packet[0] == msgKexInit:
+			stage = stageKexInit
+		}
+	}
+	return stage
+}
+
+// splitHandshake splits what a client sent into its identification line and
+// the payloads of the complete unencrypted packets that followed.
+func splitHandshake(data []byte) (string, [][]byte) {
+	end := bytes.IndexByte(data, '\n')
+	if end == -1 {
+		return "", nil
+	}
+	identification := strings.TrimRight(string(data[:end]), "\r")
+	packets := [][]byte{}
+	rest := data[end+1:]
+	for len(rest) >= 5 {
+		length := int(binary.BigEndian.Uint32(rest))
+		padding := int(rest[4])
+		if length > maxPacketSize || padding+1 > length || len(rest) < 4+length {
+			break
+		}
+		payload := rest[5 : 4+length-padding]
+		packets = append(packets, payload)
+		rest = rest[4+length:]
+		if len(payload) > 0 && payload[0] == msgNewKeys {
+			// Everything after is encrypted.
+			break
+		}
+	}
+	return identification, packets
+}
+
+// newKeysSent reports whether packets include a NEWKEYS, which ends the key
+// exchange.
+func newKeysSent(packets [][]byte) bool {
+	for _, packet := range packets {
+		if len(packet) > 0 && packet[0] == msgNewKeys {
+			return true
+		}
+	}
+	return false
+}
+
+// hassh returns the HASSH of a client, the MD5 of its client to server key
+// exchange, cipher, MAC and compression algorithms, each list comma-separated
+// and the lists separated by semicolons, along with the algorithms it's the
+// MD5 of.
+func hassh(msg *kexInit) (string, string) {
+	algorithms := strings.Join([]string{
+		strings.Join(msg.KexAlgorithms, ","),
+		strings.Join(msg.CiphersClientServer, ","),
+		strings.Join(msg.MACsClientServer, ","),
+		strings.Join(msg.CompressionClientServer, ","),
+	}, ";")
+	sum := md5.Sum([]byte(algorithms))
+	return hex.EncodeToString(sum[:]), algorithms
+}
+
+// clientFingerprint returns a JA4-like fingerprint of a client from its
+// identification and KEXINIT, along with its raw form listing everything it
+// is derived from. Both have the form a_b_c_d_e:
+//
+//	a: s, the two digits of the protocol version (20 for 2.0, 19 for 1.99),
+//	   the number of key exchange, host key, client to server cipher and MAC
+//	   algorithms offered as two digits each (capped at 99), then z if any
+//	   compression other than none is offered and n otherwise
+//	b: the key exchange algorithms in the order offered, comma-separated
+//	c: the host key algorithms, likewise
+//	d: the client to server ciphers, then a semicolon and the client to
+//	   server MACs, likewise
+//	e: the software version and comments of the identification line
+//
+// In the fingerprint, b to e are each replaced by the first 12 hex digits of
+// their SHA-256, so it stays short while similar clients remain comparable
+// part by part.
+func clientFingerprint(identification string, msg *kexInit) (string, string) {
+	protocol, software := "00", identification
+	parts := strings.SplitN(identification, "-", 3)
+	if len(parts) == 3 {
+		protocol = strings.Replace(parts[1], ".", "", -1)
+		if len(protocol) > 2 {
+			protocol = protocol[:2]
+		}
+		software = parts[2]
+	}
+	compression := "n"
+	for _, method := range msg.CompressionClientServer {
+		if method != "none" {
+			compression = "z"
+		}
+	}
+	a := fmt.Sprintf("s%v%02d%02d%02d%02d%v", protocol,
+		capCount(msg.KexAlgorithms), capCount(msg.HostKeyAlgorithms),
+		capCount(msg.CiphersClientServer), capCount(msg.MACsClientServer), compression)
+	raw := []string{
+		strings.Join(msg.KexAlgorithms, ","),
+		strings.Join(msg.HostKeyAlgorithms, ","),
+		strings.Join(msg.CiphersClientServer, ",") + ";" + strings.Join(msg.MACsClientServer, ","),
+		software,
+	}
+	hashed := make([]string, len(raw))
+	for i, part := range raw {
+		sum := sha256.Sum256([]byte(part))
+		hashed[i] = hex.EncodeToString(sum[:])[:12]
+	}
+	return a + "_" + strings.Join(hashed, "_"), a + "_" + strings.Join(raw, "_")
+}
+
+func capCount(list []string) int {
+	if len(list) > 99 {
+		return 99
+	}
+	return len(list)
+}
@@ -0,0 +1,110 @@
+package honeypot
+
+import (
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// recordedHandshake is what an OpenSSH 8.9 client sends up to its NEWKEYS,
+// with fewer algorithms, followed by an encrypted packet.
+func recordedHandshake() []byte {
+	msg := &kexInit{
+		KexAlgorithms:           []string{"curve25519-sha256", "ecdh-sha2-nistp256", "ext-info-c"},
+		HostKeyAlgorithms:       []string{"ssh-ed25519", "rsa-sha2-512"},
+		CiphersClientServer:     []string{"chacha20-poly1305@openssh.com", "aes128-ctr"},
+		CiphersServerClient:     []string{"chacha20-poly1305@openssh.com", "aes128-ctr"},
+		MACsClientServer:        []string{"hmac-sha2-256"},
+		MACsServerClient:        []string{"hmac-sha2-256"},
+		CompressionClientServer: []string{"none", "zlib@openssh.com"},
+		CompressionServerClient: []string{"none", "zlib@openssh.com"},
+	}
+	data := []byte("SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.1\r\n")
+	data = append(data, plaintextPacket(ssh.Marshal(msg))...)
+	data = append(data, plaintextPacket([]byte{msgKexFirst, 0, 0, 0, 0})...)
+	data = append(data, plaintextPacket([]byte{msgNewKeys})...)
+	return append(data, 0xde, 0xad, 0xbe, 0xef)
+}
+
+func TestClientFingerprint(t *testing.T) {
+	conn := &recordingConn{recorded: recordedHandshake()}
+	identification, msg, ok := conn.kexInit()
+	if !ok || identification != "SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.1" {
+		t.Fatalf("kexInit = %q, %v, want the recorded client's", identification, ok)
+	}
+	if stage := conn.stage(); stage != stageKex {
+		t.Errorf("stage = %v, want %v", stage, stageKex)
+	}
+	fingerprint, raw := clientFingerprint(identification, msg)
+	if want := "s2003020201z_" + "22c4ccbeda91_4ac0eeea4360_e56dd964902d_9df0c5253127"; fingerprint != want {
+		t.Errorf("fingerprint = %v, want %v", fingerprint, want)
+	}
+	if want := "s2003020201z_curve25519-sha256,ecdh-sha2-nistp256,ext-info-c_ssh-ed25519,rsa-sha2-512_chacha20-poly1305@openssh.com,aes128-ctr;hmac-sha2-256_OpenSSH_8.9p1 Ubuntu-3ubuntu0.1"; raw != want {
+		t.Errorf("raw fingerprint = %v, want %v", raw, want)
+	}
+	if hash, algorithms := hassh(msg); hash != "5d947d530d8131f38f35f913fbd67b81" || algorithms != "curve25519-sha256,ecdh-sha2-nistp256,ext-info-c;chacha20-poly1305@openssh.com,aes128-ctr;hmac-sha2-256;none,zlib@openssh.com" {
+		t.Errorf("hassh = %v of %v", hash, algorithms)
+	}
+}
+
+func TestFingerprintFields(t *testing.T) {
+	conn := &recordingConn{recorded: recordedHandshake()}
+	fields := log.Fields{}
+	addFingerprintFields(fields, conn)
+	want := log.Fields{
+		"version":          "SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.1",
+		"hassh":            "5d947d530d8131f38f35f913fbd67b81",
+		"hassh_algorithms": "curve25519-sha256,ecdh-sha2-nistp256,ext-info-c;chacha20-poly1305@openssh.com,aes128-ctr;hmac-sha2-256;none,zlib@openssh.com",
+		"fingerprint":      "s2003020201z_22c4ccbeda91_4ac0eeea4360_e56dd964902d_9df0c5253127",
+		"fingerprint_raw":  "s2003020201z_curve25519-sha256,ecdh-sha2-nistp256,ext-info-c_ssh-ed25519,rsa-sha2-512_chacha20-poly1305@openssh.com,aes128-ctr;hmac-sha2-256_OpenSSH_8.9p1 Ubuntu-3ubuntu0.1",
+	}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("fields = %v, want %v", fields, want)
+	}
+	// The version already logged is kept, and nothing is added before the
+	// client's KEXINIT.
+	fields = log.Fields{"version": "SSH-2.0-Go"}
+	addFingerprintFields(fields, conn)
+	if fields["version"] != "SSH-2.0-Go" {
+		t.Errorf("version = %v, want the one logged", fields["version"])
+	}
+	fields = log.Fields{}
+	addFingerprintFields(fields, &recordingConn{recorded: []byte("SSH-2.0-OpenSSH_8.9p1\r\n")})
+	if len(fields) != 0 {
+		t.Errorf("fields = %v before the KEXINIT", fields)
+	}
+}
+
+func TestFingerprintLogged(t *testing.T) {
+	hook := captureLog(t)
+	fingerprints := []interface{}{}
+	for _, user := range []string{"root", "admin"} {
+		hook.Reset()
+		server := newTestServer(t, newConfig())
+		client, err := dialTest(t, server, &ssh.ClientConfig{
+			Config:          ssh.Config{KeyExchanges: []string{"curve25519-sha256"}},
+			User:            user,
+			Auth:            []ssh.AuthMethod{ssh.Password("password")},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.Close()
+		entry := waitFor(t, hook, "SSH connection established")
+		fingerprint, _ := entry.Data["fingerprint"].(string)
+		if len(fingerprint) < 13 {
+			t.Fatalf("fingerprint = %q", fingerprint)
+		}
+		raw, _ := entry.Data["fingerprint_raw"].(string)
+		if !strings.HasPrefix(fingerprint, "s20") || !strings.HasPrefix(raw, fingerprint[:13]+"curve25519-sha256,") || !strings.HasSuffix(raw, "_Go") {
+			t.Errorf("fingerprint = %q from %q, want one of a Go client", fingerprint, raw)
+		}
+		fingerprints = append(fingerprints, fingerprint)
+	}
+	if fingerprints[0] != fingerprints[1] {
+		t.Errorf("fingerprints = %v, want the same client fingerprinted the same", fingerprints)
+	}
+}
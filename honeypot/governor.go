@@ -0,0 +1,140 @@
+package honeypot
+
+import (
+	log "github.com/sirupsen/logrus"
+	"net"
+	"sync"
+	"time"
+)
+
+// maxRateTrackedHosts bounds the number of IPs a governor tracks the rate of;
+// once reached, idle ones are swept and new IPs aren't rate limited until
+// some are.
+const maxRateTrackedHosts = 100000
+
+// governor caps the connections open at once across all listeners and
+// personalities, and bans source IPs opening connections faster than a rate
+// for a while. Refused connections are closed right away, without a
+// goroutine sending them a disconnect, so that a flood of them can't exhaust
+// file descriptors and goroutines. A limit of 0 disables it.
+type governor struct {
+	maxConnections int
+	rate           int
+	window         time.Duration
+	ban            time.Duration
+
+	mu          sync.Mutex
+	connections int
+	// limited is set once the connection limit was logged as reached, until
+	// connections fall below it again.
+	limited bool
+	hosts   map[string]*hostRate
+}
+
+type hostRate struct {
+	// connections are the times of the connections of the IP within the
+	// window.
+	connections []time.Time
+	bannedUntil time.Time
+}
+
+func newGovernor(maxConnections, rate int, window, ban time.Duration) *governor {
+	return &governor{
+		maxConnections: maxConnections,
+		rate:           rate,
+		window:         window,
+		ban:            ban,
+		hosts:          map[string]*hostRate{},
+	}
+}
+
+// admit counts a new connection from addr, reporting whether it's within the
+// rate of its IP and the connection limit. If it isn't, only the attempt is
+// counted toward the rate, and the limit is logged when it's first reached,
+// but not every refused connection.
+func (governor *governor) admit(addr net.Addr) bool {
+	governor.mu.Lock()
+	defer governor.mu.Unlock()
+	if !governor.admitRate(addr, time.Now()) {
+		return false
+	}
+	if governor.maxConnections > 0 && governor.connections >= governor.maxConnections {
+		if !governor.limited {
+			governor.limited = true
+			log.WithFields(log.Fields{
+				"client":   addr,
+				"max":      governor.maxConnections,
+				"category": "connection_limit",
+			}).Warning("Connection limit reached")
+		}
+		return false
+	}
+	governor.connections++
+	return true
+}
+
+// admitRate counts a connection from addr toward the rate of its IP,
+// reporting whether it isn't banned, and bans it once it exceeds the rate.
+// governor.mu must be held.
+func (governor *governor) admitRate(addr net.Addr, now time.Time) bool {
+	if governor.rate <= 0 {
+		return true
+	}
+	key := quotaKey(addr)
+	host, ok := governor.hosts[key]
+	if !ok {
+		if len(governor.hosts) >= maxRateTrackedHosts {
+			governor.sweep(now)
+			if len(governor.hosts) >= maxRateTrackedHosts {
+				return true
+			}
+		}
+		host = &hostRate{}
+		governor.hosts[key] = host
+	}
+	if now.Before(host.bannedUntil) {
+		return false
+	}
+	since := now.Add(-governor.window)
+	for len(host.connections) > 0 && host.connections[0].Before(since) {
+		host.connections = host.connections[1:]
+	}
+	host.connections = append(host.connections, now)
+	if len(host.connections) <= governor.rate {
+		return true
+	}
+	host.connections = nil
+	host.bannedUntil = now.Add(governor.ban)
+	log.WithFields(log.Fields{
+		"client":   addr,
+		"rate":     governor.rate,
+		"window":   governor.window.String(),
+		"until":    host.bannedUntil.Format(time.RFC3339),
+		"category": "rate_limit",
+	}).Warning("Connection rate limit exceeded")
+	return false
+}
+
+// sweep forgets IPs that are neither banned nor connected within the window.
+// governor.mu must be held.
+func (governor *governor) sweep(now time.Time) {
+	since := now.Add(-governor.window)
+	for key, host := range governor.hosts {
+		if now.Before(host.bannedUntil) {
+			continue
+		}
+		if len(host.connections) == 0 || host.connections[len(host.connections)-1].Before(since) {
+			delete(governor.hosts, key)
+		}
+	}
+}
+
+// release stops counting a connection admitted with admit.
+func (governor *governor) release() {
+	governor.mu.Lock()
+	defer governor.mu.Unlock()
+	governor.connections--
+	if governor.connections < governor.maxConnections {
+		governor.limited = false
+	}
+}
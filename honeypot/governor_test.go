@@ -0,0 +1,90 @@
+package honeypot
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestGovernorConnectionLimit(t *testing.T) {
+	hook := captureLog(t)
+	governor := newGovernor(2, 0, 0, 0)
+	addr := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 50000}
+	for i, want := range []bool{true, true, false, false} {
+		if admitted := governor.admit(addr); admitted != want {
+			t.Errorf("connection %v admitted = %v, want %v", i+1, admitted, want)
+		}
+	}
+	// The limit is logged once until connections fall below it.
+	governor.release()
+	if !governor.admit(addr) || governor.admit(addr) {
+		t.Error("released connection not admitted again")
+	}
+	entries := hook.AllEntries()
+	if len(entries) != 2 {
+		t.Fatalf("logged %v, want the limit twice", entries)
+	}
+	for _, entry := range entries {
+		if entry.Message != "Connection limit reached" || entry.Data["max"] != 2 || entry.Data["category"] != "connection_limit" || entry.Data["client"] != addr {
+			t.Errorf("logged %v", entry)
+		}
+	}
+}
+
+func TestGovernorRate(t *testing.T) {
+	hook := captureLog(t)
+	governor := newGovernor(0, 2, time.Minute, time.Hour)
+	start := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	addr := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 50000}
+	for _, test := range []struct {
+		offset time.Duration
+		addr   *net.TCPAddr
+		want   bool
+	}{
+		{0, addr, true},
+		// Connections older than the window don't count.
+		{time.Minute + time.Second, addr, true},
+		{time.Minute + 2*time.Second, &net.TCPAddr{IP: addr.IP, Port: 50001}, true},
+		{time.Minute + 3*time.Second, addr, false},
+		// Other IPs aren't banned.
+		{time.Minute + 4*time.Second, &net.TCPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 50000}, true},
+		{time.Hour + time.Minute + 2*time.Second, addr, false},
+		{time.Hour + time.Minute + 3*time.Second, addr, true},
+	} {
+		if admitted := governor.admitRate(test.addr, start.Add(test.offset)); admitted != test.want {
+			t.Errorf("connection from %v after %v admitted = %v, want %v", test.addr, test.offset, admitted, test.want)
+		}
+	}
+	entries := hook.AllEntries()
+	if len(entries) != 1 || entries[0].Message != "Connection rate limit exceeded" || entries[0].Data["until"] != "2026-10-14T13:01:03Z" || entries[0].Data["window"] != "1m0s" || entries[0].Data["rate"] != 2 || entries[0].Data["category"] != "rate_limit" {
+		t.Errorf("logged %v", entries)
+	}
+}
+
+func TestGovernorClosesRefusedConnections(t *testing.T) {
+	captureLog(t)
+	cfg := newConfig()
+	cfg.Limits.MaxConnections = 1
+	addr := serve(t, newTestServer(t, cfg))
+	admitted, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admitted.Close()
+	admitted.SetDeadline(time.Now().Add(5 * time.Second))
+	if version, err := bufio.NewReader(admitted).ReadString('\n'); err != nil || version != "SSH-2.0-OpenSSH_8.9p1\r\n" {
+		t.Fatalf("sent %q, %v, want the identification line", version, err)
+	}
+	// Refused connections are closed without a disconnect message.
+	refused, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer refused.Close()
+	refused.SetDeadline(time.Now().Add(5 * time.Second))
+	if sent, err := ioutil.ReadAll(refused); len(sent) != 0 || err != nil {
+		t.Errorf("sent %q, %v to a refused connection, want nothing", sent, err)
+	}
+}
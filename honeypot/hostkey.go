@@ -0,0 +1,15 @@
+package honeypot
+
+import (
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+)
+
+// ReadHostKey reads the private key in path.
+func ReadHostKey(path string) (ssh.Signer, error) {
+	keyBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKey(keyBytes)
+}
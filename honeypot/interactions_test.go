@@ -0,0 +1,75 @@
+package honeypot
+
+import (
+	"golang.org/x/crypto/ssh"
+	"testing"
+)
+
+// dialAs logs in to server as root with any password, identifying as version.
+func dialAs(t *testing.T, server *Server, version string) *ssh.Client {
+	t.Helper()
+	client, err := dialTest(t, server, &ssh.ClientConfig{
+		User:            "root",
+		Auth:            []ssh.AuthMethod{ssh.Password("password")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		ClientVersion:   version,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestClientInteractionLevels(t *testing.T) {
+	hook := captureLog(t)
+	cfg := newConfig()
+	for _, interaction := range []string{"scanner=auth", "paramiko=exec"} {
+		if err := cfg.Interactions.Set(interaction); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := newTestServer(t, cfg)
+
+	// Scanners only get to authenticate.
+	client := dialAs(t, server, "SSH-2.0-ZGrab ZGrab SSH Survey")
+	if entry := waitFor(t, hook, "SSH connection established"); entry.Data["client_category"] != "scanner" || entry.Data["interaction_level"] != "auth" {
+		t.Errorf("scanner connection logged with %v", entry.Data)
+	}
+	if _, err := client.NewSession(); err == nil {
+		t.Error("scanner opened a session")
+	} else if openErr, ok := err.(*ssh.OpenChannelError); !ok || openErr.Reason != ssh.Prohibited {
+		t.Errorf("scanner session = %v, want it prohibited", err)
+	}
+	if entry := waitFor(t, hook, "Channel rejected for client category"); entry.Data["channel"] != "session" || entry.Data["client_category"] != "scanner" {
+		t.Errorf("rejection logged with %v", entry.Data)
+	}
+
+	// Paramiko runs commands, but gets neither a terminal nor a shell.
+	hook.Reset()
+	client = dialAs(t, server, "SSH-2.0-paramiko_3.4.0")
+	session := newSession(t, client)
+	if err := session.RequestPty("xterm", 24, 80, ssh.TerminalModes{}); err == nil {
+		t.Error("paramiko got a terminal")
+	}
+	if err := session.Shell(); err == nil {
+		t.Error("paramiko got a shell")
+	}
+	waitFor(t, hook, "Shell denied")
+	if output, err := newSession(t, client).Output("echo hello"); err != nil || string(output) != "hello\n" {
+		t.Errorf("paramiko ran echo hello = %q, %v", output, err)
+	}
+
+	// OpenSSH clients get everything.
+	hook.Reset()
+	client = dialAs(t, server, "SSH-2.0-OpenSSH_9.6")
+	if entry := waitFor(t, hook, "SSH connection established"); entry.Data["client_category"] != "openssh" || entry.Data["interaction_level"] != "full" {
+		t.Errorf("OpenSSH connection logged with %v", entry.Data)
+	}
+	session = newSession(t, client)
+	if err := session.RequestPty("xterm", 24, 80, ssh.TerminalModes{}); err != nil {
+		t.Errorf("OpenSSH terminal = %v", err)
+	}
+	if err := session.Shell(); err != nil {
+		t.Errorf("OpenSSH shell = %v", err)
+	}
+}
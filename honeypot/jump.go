@@ -0,0 +1,93 @@
+package honeypot
+
+import (
+	"crypto/ed25519"
+	"github.com/longkeyy/sshesame/session"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"time"
+)
+
+// jumpConn is a direct-tcpip channel a client jumps into another fake host
+// over, seen as a connection from the same client.
+type jumpConn struct {
+	ssh.Channel
+	localAddr, remoteAddr net.Addr
+	// depth is how many jumps away from the host the client connected to
+	// the fake host is, and target the address the client asked for.
+	depth  int
+	target string
+}
+
+func (conn *jumpConn) LocalAddr() net.Addr  { return conn.localAddr }
+func (conn *jumpConn) RemoteAddr() net.Addr { return conn.remoteAddr }
+
+// Channels have no deadlines, jump hops are bounded by their maximum
+// duration instead.
+func (conn *jumpConn) SetDeadline(t time.Time) error      { return nil }
+func (conn *jumpConn) SetReadDeadline(t time.Time) error  { return nil }
+func (conn *jumpConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// EmulateJumpHosts makes server serve fake hosts to the clients jumping from
+// it, as set up with config.Jump, once it's configured. The fake hosts
+// present their own host key, as different hosts would, and don't tag events
+// or restore sticky hosts, which are told apart by client address.
+func (server *Server) EmulateJumpHosts() error {
+	_, keyBytes, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return err
+	}
+	key, err := ssh.NewSignerFromSigner(keyBytes)
+	if err != nil {
+		return err
+	}
+	sshConfig := &ssh.ServerConfig{
+		Config:                  server.sshConfig.Config,
+		ServerVersion:           server.sshConfig.ServerVersion,
+		PublicKeyAuthAlgorithms: server.sshConfig.PublicKeyAuthAlgorithms,
+	}
+	sshConfig.AddHostKey(key)
+	jumpServer := *server
+	jumpServer.sshConfig = sshConfig
+	jumpServer.Tags = nil
+	jumpServer.Hosts = nil
+	jumpServer.jump = &jumpServer
+	server.jump = &jumpServer
+	return nil
+}
+
+// Jump implements session.Jumper, serving a fake host over channel as if it
+// were a connection from the client of sess.
+func (server *Server) Jump(sess *session.Session, channel ssh.Channel, target string) {
+	conn := &jumpConn{
+		Channel:    channel,
+		localAddr:  sess.Conn.LocalAddr(),
+		remoteAddr: sess.RemoteAddr,
+		depth:      sess.Depth + 1,
+		target:     target,
+	}
+	if !server.quota.acquireConnection(conn.RemoteAddr()) {
+		return
+	}
+	defer server.quota.releaseConnection(conn.RemoteAddr())
+	if maxDuration := server.config().Jump.MaxDuration; maxDuration > 0 {
+		timer := time.AfterFunc(maxDuration, func() {
+			log.WithFields(log.Fields{
+				"client":     conn.RemoteAddr(),
+				"target":     target,
+				"jump_depth": conn.depth,
+				"duration":   maxDuration.String(),
+			}).Info("Jump hop closed after maximum duration")
+			channel.Close()
+		})
+		defer timer.Stop()
+	}
+	log.WithFields(log.Fields{
+		"client":     conn.RemoteAddr(),
+		"target":     target,
+		"jump_depth": conn.depth,
+	}).Info("Jump hop started")
+	server.Stats.RecordConnection(conn.RemoteAddr())
+	server.HandleConn(conn)
+}
@@ -0,0 +1,144 @@
+package honeypot
+
+import (
+	"bytes"
+	"github.com/longkeyy/sshesame/config"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"testing"
+	"time"
+)
+
+// keyRecorder returns a host key callback accepting any host key, recording
+// it into key.
+func keyRecorder(key *ssh.PublicKey) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, hostKey ssh.PublicKey) error {
+		*key = hostKey
+		return nil
+	}
+}
+
+// jump logs in to the host at target through client, as ssh -J does, with
+// password.
+func jump(t *testing.T, client *ssh.Client, target, password string, hostKey *ssh.PublicKey) *ssh.Client {
+	t.Helper()
+	conn, err := client.Dial("tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	sshConn, channels, requests, err := ssh.NewClientConn(conn, target, &ssh.ClientConfig{
+		User:            "root",
+		Auth:            []ssh.AuthMethod{ssh.Password(password)},
+		HostKeyCallback: keyRecorder(hostKey),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ssh.NewClient(sshConn, channels, requests)
+}
+
+// entries returns the entries logged with message.
+func entries(hook *logtest.Hook, message string) []*log.Entry {
+	matching := []*log.Entry{}
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == message {
+			matching = append(matching, entry)
+		}
+	}
+	return matching
+}
+
+func TestProxyJump(t *testing.T) {
+	hook := captureLog(t)
+	cfg := newConfig()
+	cfg.Jump = config.Jump{Ports: []int{22}, Emulate: true, MaxDepth: 1, MaxDuration: time.Minute}
+	server := newTestServer(t, cfg)
+	if err := server.EmulateJumpHosts(); err != nil {
+		t.Fatal(err)
+	}
+	var firstKey, secondKey ssh.PublicKey
+	client, err := dialTest(t, server, &ssh.ClientConfig{
+		User:            "root",
+		Auth:            []ssh.AuthMethod{ssh.Password("password")},
+		HostKeyCallback: keyRecorder(&firstKey),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hop := jump(t, client, "10.0.0.5:22", "hunter2", &secondKey)
+	if bytes.Equal(firstKey.Marshal(), secondKey.Marshal()) {
+		t.Error("the host jumped into has the host key of the jump host")
+	}
+
+	if entry := waitFor(t, hook, "Jump host connection requested"); entry.Data["target"] != "10.0.0.5:22" || entry.Data["jump_depth"] != 0 || entry.Data["category"] != "jump_attempt" {
+		t.Errorf("jump logged with %v", entry.Data)
+	}
+	if entry := waitFor(t, hook, "Jump hop started"); entry.Data["target"] != "10.0.0.5:22" || entry.Data["jump_depth"] != 1 {
+		t.Errorf("hop logged with %v", entry.Data)
+	}
+	passwords := []interface{}{}
+	for _, entry := range entries(hook, "Password authentication accepted") {
+		passwords = append(passwords, entry.Data["password"])
+	}
+	if len(passwords) != 2 || passwords[0] != "password" || passwords[1] != "hunter2" {
+		t.Errorf("logged the passwords %v, want both hops'", passwords)
+	}
+	established := entries(hook, "SSH connection established")
+	if len(established) != 2 || established[0].Data["jump_depth"] != nil || established[1].Data["jump_depth"] != 1 || established[1].Data["target"] != "10.0.0.5:22" {
+		t.Errorf("logged %v connections established, want the jump host's and the hop's", len(established))
+	}
+
+	// The hop serves sessions like any host.
+	session, err := hop.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := session.Output("true"); err != nil {
+		t.Errorf("running a command on the hop = %v", err)
+	}
+
+	// Jumping further than the maximum depth is refused.
+	if conn, err := hop.Dial("tcp", "10.0.0.6:22"); err == nil {
+		conn.Close()
+		t.Error("jumped deeper than the maximum depth")
+	} else if openErr, ok := err.(*ssh.OpenChannelError); !ok || openErr.Reason != ssh.ConnectionFailed {
+		t.Errorf("jumping too deep failed with %v, want the connection refused", err)
+	}
+	if entry := waitFor(t, hook, "Jump host depth limit reached"); entry.Data["target"] != "10.0.0.6:22" || entry.Data["jump_depth"] != 1 || entry.Data["max_depth"] != 1 {
+		t.Errorf("depth limit logged with %v", entry.Data)
+	}
+
+	hop.Close()
+	if entry := waitFor(t, hook, "Client disconnected"); entry.Data["jump_depth"] != 1 || entry.Data["target"] != "10.0.0.5:22" {
+		t.Errorf("hop disconnection logged with %v", entry.Data)
+	}
+}
+
+func TestJumpLoggedOnly(t *testing.T) {
+	hook := captureLog(t)
+	cfg := newConfig()
+	cfg.Jump = config.Jump{Ports: []int{22, 2222}, MaxDepth: 2}
+	client := dial(t, newTestServer(t, cfg), ssh.Config{})
+	for _, target := range []string{"10.0.0.5:2222", "10.0.0.5:80"} {
+		hook.Reset()
+		conn, err := client.Dial("tcp", target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+		waitFor(t, hook, "Channel requested")
+		jumps := entries(hook, "Jump host connection requested")
+		if target == "10.0.0.5:2222" && (len(jumps) != 1 || jumps[0].Data["target"] != target) {
+			t.Errorf("direct-tcpip to %v logged %v jumps", target, len(jumps))
+		}
+		if target == "10.0.0.5:80" && len(jumps) != 0 {
+			t.Errorf("direct-tcpip to %v logged as a jump", target)
+		}
+		if len(entries(hook, "Jump hop started")) != 0 {
+			t.Errorf("jumped into a host for %v without emulate_jump_host", target)
+		}
+	}
+}
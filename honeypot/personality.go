@@ -0,0 +1,137 @@
+package honeypot
+
+import (
+	"fmt"
+	"github.com/longkeyy/sshesame/config"
+	"github.com/longkeyy/sshesame/shell"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"strings"
+	"sync/atomic"
+)
+
+// defaultPersonality is the personality of the listeners configured by the
+// listen_address and tls_listen_address flags.
+const defaultPersonality = "default"
+
+// WithPersonality returns a server presenting personality, sharing everything
+// but its settings with server. Settings the personality leaves unset are
+// those of its system profile if it sets one, or of server, including keys,
+// of the types its profile offers, if no host key is set.
+func (server *Server) WithPersonality(personality config.Personality, keys []ssh.Signer) (*Server, error) {
+	cfg, err := personalityConfig(server.config(), personality)
+	if err != nil {
+		return nil, err
+	}
+	if personality.HostKey != "" {
+		key, err := ReadHostKey(personality.HostKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read host key: %w", err)
+		}
+		keys = []ssh.Signer{key}
+	} else {
+		keys = ProfileHostKeys(shell.LookupProfile(cfg.Shell.Profile), keys)
+	}
+	sshConfig := &ssh.ServerConfig{
+		Config:                  server.sshConfig.Config,
+		ServerVersion:           server.sshConfig.ServerVersion,
+		PublicKeyAuthAlgorithms: server.sshConfig.PublicKeyAuthAlgorithms,
+	}
+	if personality.ServerVersion != "" {
+		sshConfig.ServerVersion = personality.ServerVersion
+	} else if personality.Profile != "" && shell.LookupProfile(personality.Profile).ServerVersion != "" {
+		sshConfig.ServerVersion = shell.LookupProfile(personality.Profile).ServerVersion
+	}
+	for _, key := range keys {
+		sshConfig.AddHostKey(key)
+	}
+	personalityServer := *server
+	personalityServer.sshConfig = sshConfig
+	personalityServer.cfg = &atomic.Value{}
+	personalityServer.cfg.Store(cfg)
+	personalityServer.personality = personality.Name
+	personalityServer.overrides = &personality
+	return &personalityServer, nil
+}
+
+// ProfileHostKeys returns those of keys of the types the host of profile
+// offers, in its order, or all of them if it offers every type or none of
+// them is of those types.
+func ProfileHostKeys(profile *shell.Profile, keys []ssh.Signer) []ssh.Signer {
+	if len(profile.HostKeyTypes) == 0 {
+		return keys
+	}
+	offered := []ssh.Signer{}
+	for _, keyType := range profile.HostKeyTypes {
+		for _, key := range keys {
+			if strings.HasPrefix(strings.TrimPrefix(key.PublicKey().Type(), "ssh-"), keyType) {
+				offered = append(offered, key)
+			}
+		}
+	}
+	if len(offered) == 0 {
+		log.WithFields(log.Fields{
+			"types": strings.Join(profile.HostKeyTypes, ","),
+		}).Warning("No host key of the types of the system profile, offering every host key")
+		return keys
+	}
+	return offered
+}
+
+// personalityConfig returns a copy of base with the settings of personality
+// applied.
+func personalityConfig(base *config.Config, personality config.Personality) (*config.Config, error) {
+	cfg := *base
+	if personality.CommandsDir != "" {
+		responses, err := config.LoadResponses(personality.CommandsDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load command responses: %w", err)
+		}
+		cfg.Shell.Responses = responses
+	}
+	if personality.FilesDir != "" {
+		files, err := config.LoadFileContents(personality.FilesDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load file contents: %w", err)
+		}
+		cfg.Shell.Files = files
+	}
+	if personality.Restricted {
+		cfg.Shell.Restricted = true
+	}
+	if personality.Tarpit {
+		cfg.Tarpit.Enabled = true
+	}
+	if personality.DenyPTY {
+		cfg.Shell.DenyPTY = true
+	}
+	if personality.Profile != "" {
+		if shell.LookupProfile(personality.Profile) == nil {
+			return nil, fmt.Errorf("unknown system profile %q, must be one of %v", personality.Profile, strings.Join(shell.ProfileNames(), ", "))
+		}
+		cfg.Shell.Profile = personality.Profile
+		cfg.Auth.Banner = shell.LookupProfile(personality.Profile).Banner
+	}
+	if personality.AuthMethods != nil {
+		cfg.Auth.Methods = personality.AuthMethods
+		for _, method := range cfg.Auth.Steps {
+			if !cfg.Auth.Methods.Enabled(method) {
+				return nil, fmt.Errorf("invalid authentication steps: %v isn't one of auth_methods", method)
+			}
+		}
+	}
+	if personality.ValidUsers != nil {
+		cfg.Auth.Users = personality.ValidUsers
+	}
+	if len(personality.CredentialsFiles) != 0 {
+		credentials, err := config.LoadCredentialSources(personality.CredentialsFiles)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load credentials: %w", err)
+		}
+		cfg.Auth.Credentials = credentials
+	}
+	if personality.Decoy {
+		cfg.Auth.Decoy = true
+	}
+	return &cfg, nil
+}
@@ -0,0 +1,230 @@
+package honeypot
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"github.com/longkeyy/sshesame/config"
+	"github.com/longkeyy/sshesame/output"
+	"github.com/longkeyy/sshesame/shell"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPersonalityConfig(t *testing.T) {
+	base := newConfig()
+	cfg, err := personalityConfig(base, config.Personality{Name: "alt", ListenAddress: ":2222", Restricted: true, DenyPTY: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Shell.Restricted || !cfg.Shell.DenyPTY {
+		t.Errorf("personality shell configuration = %+v, want restricted without PTYs", cfg.Shell)
+	}
+	if base.Shell.Restricted || base.Shell.DenyPTY {
+		t.Error("personality changed the base configuration")
+	}
+}
+
+func TestPersonalityAuthPolicy(t *testing.T) {
+	base := newConfig()
+	base.Auth.Users = config.Users{"admin"}
+	credentials := filepath.Join(t.TempDir(), "credentials")
+	if err := ioutil.WriteFile(credentials, []byte("root:toor\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := personalityConfig(base, config.Personality{
+		Name:             "alt",
+		AuthMethods:      config.Methods{"password", "publickey"},
+		ValidUsers:       config.Users{"root"},
+		CredentialsFiles: config.CredentialsFiles{credentials},
+		Decoy:            true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg.Auth.Methods, config.Methods{"password", "publickey"}) || !cfg.Auth.Users.Valid("root") || cfg.Auth.Users.Valid("admin") || !cfg.Auth.Decoy {
+		t.Errorf("personality authentication configuration = %+v", cfg.Auth)
+	}
+	if _, ok := cfg.Auth.AcceptPassword("root", "toor"); !ok {
+		t.Error("personality credentials not accepted")
+	}
+	if _, ok := cfg.Auth.AcceptPassword("root", "password"); ok {
+		t.Error("password accepted outside the personality credentials")
+	}
+	if base.Auth.Decoy || base.Auth.Credentials != nil || !reflect.DeepEqual(base.Auth.Users, config.Users{"admin"}) {
+		t.Error("personality changed the base configuration")
+	}
+
+	// Steps must stay possible with the personality's methods.
+	base.Auth.Steps = config.Methods{"password", "keyboard-interactive"}
+	if _, err := personalityConfig(base, config.Personality{Name: "alt", AuthMethods: config.Methods{"password"}}); err == nil {
+		t.Error("personality disabled a method of the authentication steps")
+	}
+	if _, err := personalityConfig(newConfig(), config.Personality{Name: "alt", CredentialsFiles: config.CredentialsFiles{filepath.Join(t.TempDir(), "missing")}}); err == nil {
+		t.Error("personality with a missing credentials file created")
+	}
+}
+
+func TestPersonalitiesPerListener(t *testing.T) {
+	hook := captureLog(t)
+	events := output.NewRecentSink(100)
+	dispatcher := &output.Dispatcher{Tags: output.NewTags()}
+	dispatcher.Add("recent", events, 100)
+	log.AddHook(dispatcher)
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "uname"), []byte("Cisco IOS Software\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	server := newTestServer(t, newConfig())
+	server.Tags = dispatcher.Tags
+	server.TagPersonality = true
+	router, err := server.WithPersonality(config.Personality{Name: "router", ServerVersion: "SSH-2.0-Cisco-1.25", CommandsDir: dir}, []ssh.Signer{newSigner(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Draining server drains the connections of router too.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shutdown := make(chan struct{})
+	go router.Serve(listener, shutdown)
+	t.Cleanup(func() {
+		close(shutdown)
+		listener.Close()
+	})
+	addresses := map[string]string{defaultPersonality: serve(t, server), "router": listener.Addr().String()}
+	for _, test := range []struct {
+		personality, serverVersion, output string
+	}{
+		{defaultPersonality, "SSH-2.0-OpenSSH_8.9p1", "Linux\n"},
+		{"router", "SSH-2.0-Cisco-1.25", "Cisco IOS Software\n"},
+	} {
+		hook.Reset()
+		client, err := ssh.Dial("tcp", addresses[test.personality], &ssh.ClientConfig{
+			User:            "root",
+			Auth:            []ssh.AuthMethod{ssh.Password("password")},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version := string(client.ServerVersion()); version != test.serverVersion {
+			t.Errorf("%v presented %q, want %q", test.personality, version, test.serverVersion)
+		}
+		session, err := client.NewSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var stdout bytes.Buffer
+		session.Stdout = &stdout
+		if err := session.Run("uname"); err != nil || stdout.String() != test.output {
+			t.Errorf("uname on %v = %q, %v, want %q", test.personality, stdout.String(), err, test.output)
+		}
+		client.Close()
+		waitFor(t, hook, "Client disconnected")
+	}
+
+	dispatcher.Close()
+	tagged := map[string]string{}
+	for _, event := range events.Events() {
+		if event.Message == "Command executed" {
+			tagged[event.Fields["personality"].(string)] = event.Fields["listen_addr"].(string)
+		}
+	}
+	for personality, address := range addresses {
+		if tagged[personality] != address {
+			t.Errorf("command on %v tagged with listen_addr %q, want %v", personality, tagged[personality], address)
+		}
+	}
+}
+
+// newRSASigner returns a new 2048-bit RSA host key.
+func newRSASigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromSigner(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func TestProfileHostKeys(t *testing.T) {
+	captureLog(t)
+	rsaSigner := newRSASigner(t)
+	ed25519Signer := newSigner(t)
+	for _, test := range []struct {
+		profile string
+		keys    []ssh.Signer
+		want    []ssh.Signer
+	}{
+		{"cisco-ios-15.2", []ssh.Signer{ed25519Signer, rsaSigner}, []ssh.Signer{rsaSigner}},
+		// Profiles offering every type offer every key.
+		{"ubuntu-22.04-amd64", []ssh.Signer{ed25519Signer, rsaSigner}, []ssh.Signer{ed25519Signer, rsaSigner}},
+		// Hosts not offering any of the keys offer them all.
+		{"cisco-ios-15.2", []ssh.Signer{ed25519Signer}, []ssh.Signer{ed25519Signer}},
+	} {
+		keys := ProfileHostKeys(shell.LookupProfile(test.profile), test.keys)
+		if len(keys) != len(test.want) {
+			t.Errorf("%v offered %v keys, want %v", test.profile, len(keys), len(test.want))
+			continue
+		}
+		for i := range keys {
+			if keys[i] != test.want[i] {
+				t.Errorf("%v offered a %v key, want %v", test.profile, keys[i].PublicKey().Type(), test.want[i].PublicKey().Type())
+			}
+		}
+	}
+}
+
+func TestPersonalityProfile(t *testing.T) {
+	captureLog(t)
+	rsaSigner := newRSASigner(t)
+	server := newTestServer(t, newConfig())
+	router, err := server.WithPersonality(config.Personality{Name: "router", Profile: "cisco-ios-15.2"}, []ssh.Signer{newSigner(t), rsaSigner})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shutdown := make(chan struct{})
+	go router.Serve(listener, shutdown)
+	t.Cleanup(func() {
+		close(shutdown)
+		listener.Close()
+	})
+	// The profile's server, host key and banner are those of the router.
+	var hostKeyType, banner string
+	client, err := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+		User: "admin",
+		Auth: []ssh.AuthMethod{ssh.Password("password")},
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKeyType = key.Type()
+			return nil
+		},
+		BannerCallback: func(message string) error {
+			banner = message
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	profile := shell.LookupProfile("cisco-ios-15.2")
+	if version := string(client.ServerVersion()); version != "SSH-2.0-Cisco-1.25" || hostKeyType != ssh.KeyAlgoRSA || banner != profile.Banner {
+		t.Errorf("router presented %q with a %v key and the banner %q", version, hostKeyType, banner)
+	}
+}
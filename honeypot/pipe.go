@@ -0,0 +1,172 @@
+package honeypot
+
+import (
+	"golang.org/x/crypto/ssh"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// pipes counts the pipes created, numbering the ports of their client ends.
+var pipes uint32
+
+// Pipe returns the two ends of an in-memory connection, the client one to
+// drive a session with an SSH client and the server one to hand to
+// HandleConn. Unlike a net.Pipe, writes are buffered and never block, as both
+// ends of an SSH connection write at once during key exchanges. The ends have
+// loopback addresses, the client one a different port for every pipe.
+func Pipe() (client net.Conn, server net.Conn) {
+	port := 49152 + int(atomic.AddUint32(&pipes, 1)%16384)
+	clientAddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+	serverAddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 22}
+	toServer, toClient := newPipeHalf(), newPipeHalf()
+	return &pipeConn{toClient, toServer, clientAddr, serverAddr}, &pipeConn{toServer, toClient, serverAddr, clientAddr}
+}
+
+// Dial connects an SSH client configured by clientConfig to server over a
+// Pipe, server handling the connection in a goroutine, and returns it once
+// authenticated. Closing the client ends the connection.
+func Dial(server *Server, clientConfig *ssh.ClientConfig) (*ssh.Client, error) {
+	client, serverEnd := Pipe()
+	go server.HandleConn(serverEnd)
+	conn, channels, requests, err := ssh.NewClientConn(client, serverEnd.LocalAddr().String(), clientConfig)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return ssh.NewClient(conn, channels, requests), nil
+}
+
+// pipeHalf is one direction of a pipe, holding what was written until it's
+// read.
+type pipeHalf struct {
+	mu       sync.Mutex
+	data     []byte
+	closed   bool
+	deadline time.Time
+	// changed is closed and replaced whenever data is written, the half is
+	// closed or the deadline is set, waking up readers.
+	changed chan struct{}
+}
+
+func newPipeHalf() *pipeHalf {
+	return &pipeHalf{changed: make(chan struct{})}
+}
+
+// change wakes up readers, the mutex being held.
+func (half *pipeHalf) change() {
+	close(half.changed)
+	half.changed = make(chan struct{})
+}
+
+func (half *pipeHalf) read(data []byte) (int, error) {
+	for {
+		half.mu.Lock()
+		if len(half.data) != 0 {
+			n := copy(data, half.data)
+			half.data = half.data[n:]
+			half.mu.Unlock()
+			return n, nil
+		}
+		if half.closed {
+			half.mu.Unlock()
+			return 0, io.EOF
+		}
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !half.deadline.IsZero() {
+			wait := time.Until(half.deadline)
+			if wait <= 0 {
+				half.mu.Unlock()
+				return 0, os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+		changed := half.changed
+		half.mu.Unlock()
+		select {
+		case <-changed:
+		case <-timeout:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+func (half *pipeHalf) write(data []byte) (int, error) {
+	half.mu.Lock()
+	defer half.mu.Unlock()
+	if half.closed {
+		return 0, io.ErrClosedPipe
+	}
+	half.data = append(half.data, data...)
+	half.change()
+	return len(data), nil
+}
+
+func (half *pipeHalf) close() {
+	half.mu.Lock()
+	defer half.mu.Unlock()
+	if !half.closed {
+		half.closed = true
+		half.change()
+	}
+}
+
+func (half *pipeHalf) setDeadline(deadline time.Time) {
+	half.mu.Lock()
+	defer half.mu.Unlock()
+	half.deadline = deadline
+	half.change()
+}
+
+// pipeConn is an end of a pipe, reading one half and writing the other.
+type pipeConn struct {
+	reading    *pipeHalf
+	writing    *pipeHalf
+	localAddr  net.Addr
+	remoteAddr net.Addr
+}
+
+func (conn *pipeConn) Read(data []byte) (int, error) {
+	return conn.reading.read(data)
+}
+
+func (conn *pipeConn) Write(data []byte) (int, error) {
+	return conn.writing.write(data)
+}
+
+// Close closes both halves, the other end reading what was written before
+// and then EOF.
+func (conn *pipeConn) Close() error {
+	conn.reading.close()
+	conn.writing.close()
+	return nil
+}
+
+func (conn *pipeConn) LocalAddr() net.Addr {
+	return conn.localAddr
+}
+
+func (conn *pipeConn) RemoteAddr() net.Addr {
+	return conn.remoteAddr
+}
+
+func (conn *pipeConn) SetDeadline(deadline time.Time) error {
+	return conn.SetReadDeadline(deadline)
+}
+
+func (conn *pipeConn) SetReadDeadline(deadline time.Time) error {
+	conn.reading.setDeadline(deadline)
+	return nil
+}
+
+// SetWriteDeadline implements net.Conn, writes never blocking.
+func (conn *pipeConn) SetWriteDeadline(deadline time.Time) error {
+	return nil
+}
@@ -0,0 +1,159 @@
+package honeypot
+
+import (
+	"bytes"
+	"errors"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPipe(t *testing.T) {
+	client, server := Pipe()
+	// Writes don't wait for the other end to read.
+	for _, data := range []string{"SSH-2.0-", "OpenSSH_9.6\r\n"} {
+		if _, err := client.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buffer := make([]byte, 8)
+	if n, err := server.Read(buffer); err != nil || string(buffer[:n]) != "SSH-2.0-" {
+		t.Errorf("read %q, %v, want the first write", buffer[:n], err)
+	}
+
+	if _, err := io.ReadFull(server, make([]byte, 13)); err != nil {
+		t.Fatal(err)
+	}
+	server.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, err := server.Read(buffer); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("read past the deadline = %v, want the deadline exceeded", err)
+	}
+	server.SetReadDeadline(time.Time{})
+
+	client.Write([]byte("bye"))
+	client.Close()
+	if rest, err := ioutil.ReadAll(server); err != nil || string(rest) != "bye" {
+		t.Errorf("read %q, %v after the client closed, want what it wrote before", rest, err)
+	}
+	if _, err := client.Write([]byte("late")); err != io.ErrClosedPipe {
+		t.Errorf("write after closing = %v, want the pipe closed", err)
+	}
+
+	if client.LocalAddr().String() != server.RemoteAddr().String() || server.LocalAddr().String() != "127.0.0.1:22" {
+		t.Errorf("pipe ends have the addresses %v and %v", client.LocalAddr(), server.LocalAddr())
+	}
+	other, _ := Pipe()
+	if other.LocalAddr().String() == client.LocalAddr().String() {
+		t.Errorf("pipes share the client address %v", client.LocalAddr())
+	}
+}
+
+func TestFullSessionOverPipe(t *testing.T) {
+	hook := captureLog(t)
+	server := newTestServer(t, newConfig())
+	client, err := Dial(server, &ssh.ClientConfig{
+		User:            "root",
+		Auth:            []ssh.AuthMethod{ssh.Password("hunter2")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(client.ServerVersion()) != "SSH-2.0-OpenSSH_8.9p1" {
+		t.Errorf("server identifies as %q", client.ServerVersion())
+	}
+	if _, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := newSession(t, client).Output("id")
+	if err != nil || !strings.HasPrefix(string(output), "uid=0(root) gid=0(root)") {
+		t.Errorf("id = %q, %v", output, err)
+	}
+
+	session := newSession(t, client)
+	if err := session.Setenv("LANG", "C.UTF-8"); err != nil {
+		t.Fatal(err)
+	}
+	if err := session.RequestPty("xterm-256color", 24, 80, ssh.TerminalModes{}); err != nil {
+		t.Fatal(err)
+	}
+	var transcript bytes.Buffer
+	session.Stdout = &transcript
+	session.Stdin = strings.NewReader("uname -n\rexit\r")
+	if err := session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if match := regexp.MustCompile(`root@([\w-]+):~# uname -n\r\n([\w-]+)\r\n`).FindStringSubmatch(transcript.String()); match == nil || match[1] != match[2] {
+		t.Errorf("shell wrote %q, want the hostname of the prompt", transcript.String())
+	}
+
+	conn, err := client.Dial("tcp", "198.51.100.7:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	client.Close()
+	waitFor(t, hook, "Client disconnected")
+
+	// Every handler logged along, in order.
+	want := []string{"Password authentication accepted", "SSH connection established", "Request received", "Channel requested", "Command executed", "Channel requested", "Request received", "Command executed", "Channel requested", "Client disconnected"}
+	logged := []string{}
+	for _, entry := range hook.AllEntries() {
+		logged = append(logged, entry.Message)
+	}
+	remaining := want
+	for _, message := range logged {
+		if len(remaining) != 0 && message == remaining[0] {
+			remaining = remaining[1:]
+		}
+	}
+	if len(remaining) != 0 {
+		t.Errorf("logged %q, want %q in order", logged, want)
+	}
+	commands := []interface{}{}
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Command executed" {
+			commands = append(commands, entry.Data["command"])
+		}
+	}
+	if len(commands) != 3 || commands[0] != "id" || commands[1] != "uname -n" || commands[2] != "exit" {
+		t.Errorf("ran %q, want id, uname -n and exit", commands)
+	}
+}
+
+func TestDialFails(t *testing.T) {
+	captureLog(t)
+	cfg := newConfig()
+	cfg.Auth.Methods = nil
+	server := newTestServer(t, cfg)
+	if client, err := Dial(server, &ssh.ClientConfig{
+		User:            "root",
+		Auth:            []ssh.AuthMethod{ssh.Password("hunter2")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}); err == nil {
+		client.Close()
+		t.Error("logged in with no authentication method enabled")
+	}
+	// The host key is checked like over a network.
+	if client, err := Dial(server, &ssh.ClientConfig{
+		User: "root",
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			return errors.New("unknown host key")
+		},
+	}); err == nil || !strings.Contains(err.Error(), "unknown host key") {
+		if client != nil {
+			client.Close()
+		}
+		t.Errorf("Dial = %v, want the host key rejected", err)
+	}
+}
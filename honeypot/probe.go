@@ -0,0 +1,78 @@
+package honeypot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"net"
+	"time"
+)
+
+var sshPrefix = []byte("SSH-")
+
+// maxIdentificationSize is the longest identification line RFC 4253 section
+// 4.2 allows, including its CR LF.
+const maxIdentificationSize = 255
+
+// probeSnippetSize is how many of the bytes that were received are logged for
+// non-SSH probes.
+const probeSnippetSize = 64
+
+// bufferedConn is a connection whose first bytes were already read into
+// reader.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (conn *bufferedConn) Read(data []byte) (int, error) {
+	return conn.reader.Read(data)
+}
+
+// awaitIdentification waits up to timeout, if set, for the client to send an SSH
+// identification line, without consuming it. It returns the connection to use
+// from then on, or, if the client sends something else, sends too much
+// without a line ending or doesn't send anything in time, why it isn't SSH
+// and what was received.
+func awaitIdentification(conn net.Conn, timeout time.Duration) (net.Conn, string, []byte) {
+	reader := bufio.NewReaderSize(conn, maxIdentificationSize)
+	if timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		defer conn.SetReadDeadline(time.Time{})
+	}
+	for size := 1; size <= maxIdentificationSize; size++ {
+		data, err := reader.Peek(size)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				return nil, "timeout", data
+			}
+			return nil, "closed", data
+		}
+		if size <= len(sshPrefix) && !bytes.HasPrefix(sshPrefix, data) {
+			// Include whatever else already arrived.
+			data, _ = reader.Peek(reader.Buffered())
+			return nil, "not ssh", data
+		}
+		if data[size-1] == '\n' {
+			return &bufferedConn{conn, reader}, "", nil
+		}
+	}
+	data, _ := reader.Peek(maxIdentificationSize)
+	return nil, "identification too long", data
+}
+
+// snippet renders the first bytes of data as hex and as ASCII, with bytes
+// that aren't printable replaced by dots.
+func snippet(data []byte) (string, string) {
+	if len(data) > probeSnippetSize {
+		data = data[:probeSnippetSize]
+	}
+	ascii := make([]byte, len(data))
+	for i, b := range data {
+		if b < ' ' || b > '~' {
+			b = '.'
+		}
+		ascii[i] = b
+	}
+	return hex.EncodeToString(data), string(ascii)
+}
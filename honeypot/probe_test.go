@@ -0,0 +1,61 @@
+package honeypot
+
+import (
+	"bufio"
+	"golang.org/x/crypto/ssh"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNonSSHProbes(t *testing.T) {
+	hook := captureLog(t)
+	cfg := newConfig()
+	cfg.Limits.IdentificationTimeout = 200 * time.Millisecond
+	for _, test := range []struct {
+		name, sent, reason string
+		size               int
+	}{
+		{"HTTP", "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n", "not ssh", 37},
+		{"oversized banner", "SSH-2.0-" + strings.Repeat("A", 1000), "identification too long", maxIdentificationSize},
+		{"incomplete banner", "SSH-2.0-", "timeout", 8},
+	} {
+		hook.Reset()
+		server := newTestServer(t, cfg)
+		clientEnd, serverEnd := Pipe()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			server.HandleConn(serverEnd)
+		}()
+		go clientEnd.Write([]byte(test.sent))
+		// The server sends its identification right away.
+		if line, err := bufio.NewReader(clientEnd).ReadString('\n'); err != nil || !strings.HasPrefix(line, "SSH-2.0-") {
+			t.Errorf("%v: server sent %q, %v", test.name, line, err)
+		}
+		<-done
+		clientEnd.Close()
+		entry := waitFor(t, hook, "Non-SSH probe received")
+		if entry.Data["category"] != "non_ssh_probe" || entry.Data["reason"] != test.reason || entry.Data["size"] != test.size {
+			t.Errorf("%v: probe logged with %v, want %v after %v bytes", test.name, entry.Data, test.reason, test.size)
+		}
+		want := strings.ReplaceAll(test.sent, "\r\n", "..")
+		if len(want) > probeSnippetSize {
+			want = want[:probeSnippetSize]
+		}
+		if entry.Data["snippet_ascii"] != want {
+			t.Errorf("%v: snippet %q, want %q", test.name, entry.Data["snippet_ascii"], want)
+		}
+	}
+}
+
+func TestSSHClientsNotProbes(t *testing.T) {
+	hook := captureLog(t)
+	dial(t, newTestServer(t, newConfig()), ssh.Config{})
+	waitFor(t, hook, "SSH connection established")
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Non-SSH probe received" {
+			t.Errorf("SSH client logged as a probe with %v", entry.Data)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package honeypot
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"runtime/debug"
+	"sync"
+)
+
+// ipQuota caps the connections open at once from every source IP, and the
+// goroutines serving them, so that a single aggressive host can't monopolise
+// the server. Only IPs with open connections are tracked. A limit of 0
+// disables it.
+type ipQuota struct {
+	maxConnections int
+	maxGoroutines  int
+
+	mu     sync.Mutex
+	counts map[string]*ipCount
+}
+
+type ipCount struct {
+	connections int
+	// goroutines are those serving the connections, one for every
+	// connection and every channel.
+	goroutines int
+}
+
+func newIPQuota(maxConnections, maxGoroutines int) *ipQuota {
+	return &ipQuota{
+		maxConnections: maxConnections,
+		maxGoroutines:  maxGoroutines,
+		counts:         map[string]*ipCount{},
+	}
+}
+
+// quotaKey returns the IP of addr.
+func quotaKey(addr net.Addr) string {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP.String()
+	}
+	return addr.String()
+}
+
+// acquireConnection counts a new connection from addr and its goroutine,
+// reporting whether it's within the limits. If it isn't, nothing is counted
+// and the exceeded limit is logged.
+func (quota *ipQuota) acquireConnection(addr net.Addr) bool {
+	quota.mu.Lock()
+	defer quota.mu.Unlock()
+	count := quota.count(addr)
+	switch {
+	case quota.maxConnections > 0 && count.connections >= quota.maxConnections:
+		quota.exceeded(addr, "connections", quota.maxConnections, count)
+		return false
+	case quota.maxGoroutines > 0 && count.goroutines >= quota.maxGoroutines:
+		quota.exceeded(addr, "goroutines", quota.maxGoroutines, count)
+		return false
+	}
+	count.connections++
+	count.goroutines++
+	return true
+}
+
+// releaseConnection stops counting a connection from addr acquired with
+// acquireConnection.
+func (quota *ipQuota) releaseConnection(addr net.Addr) {
+	quota.mu.Lock()
+	defer quota.mu.Unlock()
+	count := quota.count(addr)
+	count.connections--
+	count.goroutines--
+	quota.forget(addr, count)
+}
+
+// acquireGoroutine counts a new goroutine serving a connection from addr,
+// reporting whether it's within the limit. If it isn't, nothing is counted
+// and the exceeded limit is logged.
+func (quota *ipQuota) acquireGoroutine(addr net.Addr) bool {
+	quota.mu.Lock()
+	defer quota.mu.Unlock()
+	count := quota.count(addr)
+	if quota.maxGoroutines > 0 && count.goroutines >= quota.maxGoroutines {
+		quota.exceeded(addr, "goroutines", quota.maxGoroutines, count)
+		return false
+	}
+	count.goroutines++
+	return true
+}
+
+// releaseGoroutine stops counting a goroutine acquired with acquireGoroutine.
+func (quota *ipQuota) releaseGoroutine(addr net.Addr) {
+	quota.mu.Lock()
+	defer quota.mu.Unlock()
+	count := quota.count(addr)
+	count.goroutines--
+	quota.forget(addr, count)
+}
+
+// count returns the counts of the IP of addr. quota.mu must be held.
+func (quota *ipQuota) count(addr net.Addr) *ipCount {
+	key := quotaKey(addr)
+	count, ok := quota.counts[key]
+	if !ok {
+		count = &ipCount{}
+		quota.counts[key] = count
+	}
+	return count
+}
+
+// forget stops tracking the IP of addr if nothing of it is left. quota.mu
+// must be held.
+func (quota *ipQuota) forget(addr net.Addr, count *ipCount) {
+	if count.connections <= 0 && count.goroutines <= 0 {
+		delete(quota.counts, quotaKey(addr))
+	}
+}
+
+// exceeded logs that addr reached limit. quota.mu must be held.
+func (quota *ipQuota) exceeded(addr net.Addr, limit string, max int, count *ipCount) {
+	log.WithFields(log.Fields{
+		"client":      addr,
+		"limit":       limit,
+		"max":         max,
+		"connections": count.connections,
+		"goroutines":  count.goroutines,
+		"category":    "per_ip_limit",
+	}).Warning("Per-IP limit exceeded")
+}
+
+// recoverHandler, deferred by the goroutines serving connections from addr,
+// logs a panic instead of crashing the server, so that one client hitting a
+// bug doesn't end every session and the goroutine's quota is still released.
+func recoverHandler(addr net.Addr) {
+	if value := recover(); value != nil {
+		log.WithFields(log.Fields{
+			"client": addr,
+			"panic":  fmt.Sprint(value),
+			"stack":  string(debug.Stack()),
+		}).Error("Handler panicked")
+	}
+}
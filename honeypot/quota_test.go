@@ -0,0 +1,166 @@
+package honeypot
+
+import (
+	"bufio"
+	"github.com/longkeyy/sshesame/auth"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"testing"
+	"time"
+)
+
+// holdOpen opens a connection to address, held open until the end of the
+// test by a client yet to identify itself.
+func holdOpen(t *testing.T, address string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if _, err := bufio.NewReader(conn).ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// dialRoot logs in to address as root.
+func dialRoot(address string) (*ssh.Client, error) {
+	return ssh.Dial("tcp", address, &ssh.ClientConfig{
+		User:            "root",
+		Auth:            []ssh.AuthMethod{ssh.Password("password")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+}
+
+// dialEventually logs in to address as root, retrying until the server
+// released what an earlier connection held.
+func dialEventually(t *testing.T, address string) *ssh.Client {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		client, err := dialRoot(address)
+		if err == nil {
+			t.Cleanup(func() { client.Close() })
+			return client
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("dial = %v, want the quota released", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPerIPConnectionsCapped(t *testing.T) {
+	hook := captureLog(t)
+	cfg := newConfig()
+	cfg.Limits.MaxConnectionsPerIP = 2
+	server := newTestServer(t, cfg)
+	address := serve(t, server)
+	held := holdOpen(t, address)
+	holdOpen(t, address)
+	for i := 0; i < 2; i++ {
+		if client, err := dialRoot(address); err == nil {
+			client.Close()
+			t.Fatalf("connection %v from the same IP accepted, want at most 2", 3+i)
+		}
+	}
+	entry := waitFor(t, hook, "Per-IP limit exceeded")
+	if entry.Data["category"] != "per_ip_limit" || entry.Data["limit"] != "connections" || entry.Data["max"] != 2 || entry.Data["connections"] != 2 {
+		t.Errorf("limit logged with %v", entry.Data)
+	}
+	// Closed connections no longer count.
+	held.Close()
+	dialEventually(t, address)
+}
+
+func TestPerIPGoroutinesCapped(t *testing.T) {
+	hook := captureLog(t)
+	cfg := newConfig()
+	// The connection's own and a channel's.
+	cfg.Limits.MaxGoroutinesPerIP = 2
+	client := dialEventually(t, serve(t, newTestServer(t, cfg)))
+	first, requests, err := client.OpenChannel("session", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ssh.DiscardRequests(requests)
+	_, _, err = client.OpenChannel("session", nil)
+	if openErr, ok := err.(*ssh.OpenChannelError); !ok || openErr.Reason != ssh.ResourceShortage {
+		t.Errorf("second channel = %v, want a resource shortage", err)
+	}
+	if entry := waitFor(t, hook, "Per-IP limit exceeded"); entry.Data["limit"] != "goroutines" || entry.Data["goroutines"] != 2 {
+		t.Errorf("limit logged with %v", entry.Data)
+	}
+	first.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		channel, _, err := client.OpenChannel("session", nil)
+		if err == nil {
+			channel.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("channel after closing the first = %v, want its goroutine released", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// panickingDecider panics deciding the first password, like a buggy custom
+// decider, then decides like the configuration.
+type panickingDecider struct {
+	auth.ConfigDecider
+	panicked chan struct{}
+}
+
+func (decider panickingDecider) Password(attempt auth.Attempt, password string) auth.Decision {
+	select {
+	case <-decider.panicked:
+		return decider.ConfigDecider.Password(attempt, password)
+	default:
+		close(decider.panicked)
+		panic("decider bug")
+	}
+}
+
+func TestPerIPQuotaReleasedOnPanic(t *testing.T) {
+	hook := captureLog(t)
+	cfg := newConfig()
+	cfg.Limits.MaxConnectionsPerIP = 1
+	server := newTestServer(t, cfg)
+	server.Decider = panickingDecider{auth.ConfigDecider{Auth: cfg.Auth}, make(chan struct{})}
+	address := serve(t, server)
+	if client, err := dialRoot(address); err == nil {
+		client.Close()
+		t.Fatal("authenticated with a panicking decider")
+	}
+	if entry := waitFor(t, hook, "Handler panicked"); entry.Data["panic"] != "decider bug" || entry.Data["stack"] == "" {
+		t.Errorf("panic logged with %v", entry.Data)
+	}
+	dialEventually(t, address)
+}
+
+func TestPerIPQuotaForgetsIPs(t *testing.T) {
+	quota := newIPQuota(2, 4)
+	addrs := []net.Addr{
+		&net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 50000},
+		&net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 50001},
+		&net.TCPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 50000},
+	}
+	for _, addr := range addrs {
+		if !quota.acquireConnection(addr) || !quota.acquireGoroutine(addr) {
+			t.Fatalf("%v over the quota", addr)
+		}
+	}
+	if count := quota.counts["192.0.2.1"]; count == nil || count.connections != 2 || count.goroutines != 4 {
+		t.Errorf("192.0.2.1 counted %+v, want both ports together", count)
+	}
+	for _, addr := range addrs {
+		quota.releaseGoroutine(addr)
+		quota.releaseConnection(addr)
+	}
+	if len(quota.counts) != 0 {
+		t.Errorf("still tracking %v after every connection closed", quota.counts)
+	}
+}
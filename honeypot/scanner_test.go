@@ -0,0 +1,107 @@
+package honeypot
+
+import (
+	"errors"
+	"github.com/longkeyy/sshesame/config"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestBannerGrabs(t *testing.T) {
+	hook := captureLog(t)
+	kexInitPacket := plaintextPacket(ssh.Marshal(&kexInit{
+		KexAlgorithms:           []string{"curve25519-sha256"},
+		HostKeyAlgorithms:       []string{"ssh-ed25519"},
+		CiphersClientServer:     []string{"aes128-ctr"},
+		CiphersServerClient:     []string{"aes128-ctr"},
+		MACsClientServer:        []string{"hmac-sha2-256"},
+		MACsServerClient:        []string{"hmac-sha2-256"},
+		CompressionClientServer: []string{"none"},
+		CompressionServerClient: []string{"none"},
+	}))
+	for _, test := range []struct {
+		name, stage string
+		// grab connects to the server and leaves.
+		grab func(t *testing.T, server *Server)
+	}{
+		{"banner", stageBanner, func(t *testing.T, server *Server) {
+			grabRaw(server, []byte("SSH-2.0-Go\r\n"))
+		}},
+		{"kexinit", stageKexInit, func(t *testing.T, server *Server) {
+			grabRaw(server, append([]byte("SSH-2.0-Go\r\n"), kexInitPacket...))
+		}},
+		{"kex", stageKex, func(t *testing.T, server *Server) {
+			// Like ssh-keyscan, which leaves once it got the host key.
+			_, err := dialTest(t, server, &ssh.ClientConfig{
+				User: "root",
+				HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+					return errors.New("got the key")
+				},
+			})
+			if err == nil {
+				t.Error("client logged in")
+			}
+		}},
+	} {
+		hook.Reset()
+		test.grab(t, newTestServer(t, newConfig()))
+		entry := waitFor(t, hook, "Banner grab detected")
+		if entry.Data["category"] != "banner_grab" || entry.Data["stage"] != test.stage {
+			t.Errorf("%v: banner grab logged with %v, want stage %v", test.name, entry.Data, test.stage)
+		}
+		if _, ok := entry.Data["hassh"]; ok != (test.stage != stageBanner) {
+			t.Errorf("%v: banner grab logged with %v, want the HASSH once the KEXINIT was sent", test.name, entry.Data)
+		}
+	}
+}
+
+// grabRaw sends data to server and leaves once the server answered it.
+func grabRaw(server *Server, data []byte) {
+	clientEnd, serverEnd := Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.HandleConn(serverEnd)
+	}()
+	clientEnd.Write(data)
+	// The server sends its identification and KEXINIT right away.
+	io.CopyN(ioutil.Discard, clientEnd, 64)
+	clientEnd.Close()
+	<-done
+}
+
+func TestAuthenticationAttemptsNotBannerGrabs(t *testing.T) {
+	hook := captureLog(t)
+	cfg := newConfig()
+	cfg.Auth.Methods = config.Methods{"publickey"}
+	server := newTestServer(t, cfg)
+	clientEnd, serverEnd := Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.HandleConn(serverEnd)
+	}()
+	if _, _, _, err := ssh.NewClientConn(clientEnd, serverEnd.LocalAddr().String(), &ssh.ClientConfig{
+		User:            "root",
+		Auth:            []ssh.AuthMethod{ssh.Password("password")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}); err == nil {
+		t.Fatal("client logged in without a key")
+	}
+	clientEnd.Close()
+	<-done
+	failed := false
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Banner grab detected" {
+			t.Errorf("authentication attempt logged as a banner grab with %v", entry.Data)
+		}
+		failed = failed || strings.HasPrefix(entry.Message, "Failed to establish SSH connection:")
+	}
+	if !failed {
+		t.Error("failed authentication not logged")
+	}
+}
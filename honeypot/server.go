@@ -0,0 +1,499 @@
+// Package honeypot serves the connections of clients to the fake SSH server,
+// from the identification exchange and authentication to their channels and
+// requests. Connections needn't come from a socket, which lets tests and
+// programs embedding sshesame drive whole sessions in process.
+package honeypot
+
+import (
+	"crypto/tls"
+	"github.com/longkeyy/sshesame/auth"
+	"github.com/longkeyy/sshesame/channel"
+	"github.com/longkeyy/sshesame/config"
+	"github.com/longkeyy/sshesame/forward"
+	"github.com/longkeyy/sshesame/output"
+	"github.com/longkeyy/sshesame/request"
+	"github.com/longkeyy/sshesame/session"
+	"github.com/longkeyy/sshesame/stats"
+	"github.com/longkeyy/sshesame/tracing"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Server holds everything shared by the connections to a running server.
+// The optional parts are set before it serves anything.
+type Server struct {
+	// Stats aggregates what clients do across connections.
+	Stats *stats.Stats
+	// Decider, if set, decides authentication attempts instead of the
+	// configured credentials and public key rules.
+	Decider auth.AuthDecider
+	// Denylist, if set, refuses connections from the clients it lists.
+	Denylist *auth.Denylist
+	// Tracer, if set, traces connections.
+	Tracer *tracing.Tracer
+	// Tags, if set, tag the events of clients with the ID of their session,
+	// and with the personality they connected to if TagPersonality is set.
+	Tags           *output.Tags
+	TagPersonality bool
+	// Connects, if set, only logs some Client connected events.
+	Connects *ConnectSampler
+	// Hosts, if set, present returning clients the fake host they saw
+	// before.
+	Hosts *session.Hosts
+	// Collector, if set, receives the files clients put on the host.
+	Collector session.FileCollector
+	// Downloader, if set, fetches the files clients download onto the host.
+	Downloader session.Downloader
+
+	sshConfig *ssh.ServerConfig
+	// cfg holds the *config.Config connections are served with, replaced
+	// by Reconfigure.
+	cfg     *atomic.Value
+	blocker *auth.Blocker
+	history *auth.History
+	// personality is the name of the personality presented, and overrides,
+	// unless it's the default one, its settings overriding the
+	// configuration's.
+	personality string
+	overrides   *config.Personality
+	connections *connections
+	quota       *ipQuota
+	governor    *governor
+	// jump, if set, serves the fake hosts clients jump into.
+	jump *Server
+}
+
+// NewServer returns a server answering connections as cfg configures,
+// presenting the identification, algorithms and host keys of sshConfig.
+func NewServer(cfg *config.Config, sshConfig *ssh.ServerConfig) *Server {
+	server := &Server{
+		Stats:       stats.New(),
+		sshConfig:   sshConfig,
+		cfg:         &atomic.Value{},
+		blocker:     auth.NewBlocker(cfg.Auth),
+		history:     auth.NewHistory(cfg.Auth.HistoryWindow),
+		personality: defaultPersonality,
+		connections: newConnections(),
+		quota:       newIPQuota(cfg.Limits.MaxConnectionsPerIP, cfg.Limits.MaxGoroutinesPerIP),
+		governor:    newGovernor(cfg.Limits.MaxConnections, cfg.Limits.MaxConnectionRate, cfg.Limits.ConnectionRateWindow, cfg.Limits.ConnectionRateBan),
+	}
+	server.cfg.Store(cfg)
+	return server
+}
+
+// config returns the configuration new connections are served with.
+func (server *Server) config() *config.Config {
+	return server.cfg.Load().(*config.Config)
+}
+
+// Configuration returns the configuration the server serves connections
+// with for cfg: a server presenting a personality applies its settings to
+// cfg, which can fail.
+func (server *Server) Configuration(cfg *config.Config) (*config.Config, error) {
+	if server.overrides == nil {
+		return cfg, nil
+	}
+	return personalityConfig(cfg, *server.overrides)
+}
+
+// Reconfigure makes the connections established from then on be served with
+// cfg, as returned by Configuration, those already established keep the
+// configuration they started with. The authentication blocking and per-IP
+// limits, set up once, keep their settings.
+func (server *Server) Reconfigure(cfg *config.Config) {
+	server.cfg.Store(cfg)
+}
+
+// Serve accepts connections on listener until shutdown is closed.
+func (server *Server) Serve(listener net.Listener, shutdown <-chan struct{}) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-shutdown:
+				return
+			default:
+			}
+			log.Warning("Failed to accept connection:", err.Error())
+			continue
+		}
+		cfg := server.config()
+		if server.Denylist.Denied(conn.RemoteAddr()) {
+			disconnect := cfg.Disconnects["denylisted"]
+			log.WithFields(log.Fields{
+				"client":             conn.RemoteAddr(),
+				"disconnect_code":    disconnect.Code,
+				"disconnect_message": disconnect.Message,
+			}).Info("Denylisted client rejected")
+			go sendDisconnect(conn, server.sshConfig.ServerVersion, disconnect)
+			continue
+		}
+		if blocked, until := server.blocker.Blocked(conn.RemoteAddr()); blocked {
+			disconnect := cfg.Disconnects["blocked"]
+			log.WithFields(log.Fields{
+				"client":             conn.RemoteAddr(),
+				"until":              until.Format(time.RFC3339),
+				"disconnect_code":    disconnect.Code,
+				"disconnect_message": disconnect.Message,
+			}).Info("Temporarily blocked client rejected")
+			go sendDisconnect(conn, server.sshConfig.ServerVersion, disconnect)
+			continue
+		}
+		if !server.governor.admit(conn.RemoteAddr()) {
+			conn.Close()
+			continue
+		}
+		if !server.quota.acquireConnection(conn.RemoteAddr()) {
+			server.governor.release()
+			disconnect := cfg.Disconnects["per_ip_limit"]
+			log.WithFields(log.Fields{
+				"client":             conn.RemoteAddr(),
+				"disconnect_code":    disconnect.Code,
+				"disconnect_message": disconnect.Message,
+			}).Info("Client over per-IP limit rejected")
+			go sendDisconnect(conn, server.sshConfig.ServerVersion, disconnect)
+			continue
+		}
+		firstSeen := server.Stats.RecordConnection(conn.RemoteAddr())
+		if server.Connects.sample(conn.RemoteAddr(), firstSeen) {
+			log.WithFields(log.Fields{
+				"client":     conn.RemoteAddr(),
+				"first_seen": firstSeen,
+			}).Info("Client connected")
+		}
+		server.connections.add(conn)
+		go func() {
+			defer server.connections.done(conn)
+			defer server.governor.release()
+			defer server.quota.releaseConnection(conn.RemoteAddr())
+			defer recoverHandler(conn.RemoteAddr())
+			server.HandleConn(conn)
+		}()
+	}
+}
+
+func (server *Server) passwordCallback(authConnection *auth.Connection, conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	fields := log.Fields{
+		"client":   conn.RemoteAddr(),
+		"user":     conn.User(),
+		"password": string(password),
+		"version":  string(conn.ClientVersion()),
+	}
+	decision := authConnection.DecidePassword(conn, string(password))
+	authConnection.LogDecision(fields, "Password", decision)
+	if server.Stats.RecordPassword(conn.User(), string(password)) {
+		log.WithFields(log.Fields{
+			"client":   conn.RemoteAddr(),
+			"password": string(password),
+			"users":    stats.SprayingThreshold,
+		}).Warning("Password spraying detected")
+	}
+	if addresses, replayed := server.Stats.RecordReplay(conn.User(), string(password), conn.RemoteAddr()); replayed {
+		log.WithFields(log.Fields{
+			"client":    conn.RemoteAddr(),
+			"user":      conn.User(),
+			"password":  string(password),
+			"addresses": addresses,
+			"category":  "credential_replay",
+		}).Warning("Credential replay detected")
+	}
+	return authConnection.PasswordPermissions(decision, string(password))
+}
+
+// stickyKey returns the key of the fake host presented to the client of
+// sshConn, which is only the same for the same personality.
+func (server *Server) stickyKey(cfg *config.Config, remoteAddr net.Addr, sshConn *ssh.ServerConn) string {
+	key := remoteAddr.String()
+	if tcpAddr, ok := remoteAddr.(*net.TCPAddr); ok {
+		key = tcpAddr.IP.String()
+	}
+	if cfg.Sticky.By == "credential" {
+		credential := ""
+		if sshConn.Permissions != nil {
+			credential = sshConn.Permissions.Extensions[auth.CredentialExtension]
+		}
+		key = sshConn.User() + "\x00" + credential
+	}
+	return server.personality + "\x00" + key
+}
+
+// tlsHandshakeTimeout bounds how long TLS-wrapped connections may take to
+// complete their TLS handshake.
+const tlsHandshakeTimeout = 10 * time.Second
+
+// HandleConn serves the connection of a client until it disconnects. conn
+// needn't be a network connection, the server end of a Pipe serves clients in
+// process.
+func (server *Server) HandleConn(conn net.Conn) {
+	defer conn.Close()
+	cfg := server.config()
+	jump, _ := conn.(*jumpConn)
+	id := session.NewID()
+	server.connections.identify(conn, id)
+	if server.Tags != nil {
+		tags := log.Fields{"session_id": id}
+		if server.TagPersonality {
+			tags["listen_addr"] = conn.LocalAddr().String()
+			tags["personality"] = server.personality
+		}
+		server.Tags.Set(conn.RemoteAddr(), tags)
+		defer server.Tags.Remove(conn.RemoteAddr())
+	}
+	defer server.Stats.RecordDisconnection()
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		tlsConn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			log.Warning("Failed to complete TLS handshake:", err.Error())
+			return
+		}
+		tlsConn.SetDeadline(time.Time{})
+		state := tlsConn.ConnectionState()
+		log.WithFields(log.Fields{
+			"client":       conn.RemoteAddr(),
+			"server_name":  state.ServerName,
+			"cipher_suite": tls.CipherSuiteName(state.CipherSuite),
+			"tls_version":  tls.VersionName(state.Version),
+		}).Info("TLS handshake completed")
+	}
+	if cfg.Capture.Dir != "" {
+		// Captured as the SSH transport sees it, inside TLS.
+		capture, err := newCaptureConn(conn, id, cfg.Capture)
+		if err != nil {
+			log.Warning("Failed to start traffic capture:", err.Error())
+		} else {
+			defer capture.Close()
+			conn = capture
+		}
+	}
+	budget := newBudgetConn(conn, cfg.Limits.MaxConnectionBytes, cfg.Limits.MaxConnectionLifetime)
+	defer budget.Close()
+	conn = budget
+	// The identification timeout only starts once a slow banner was sent.
+	if cfg.Tarpit.Enabled {
+		if err := sendTarpitLines(conn, cfg.Tarpit); err != nil {
+			return
+		}
+	}
+	if err := sendBanner(conn, server.sshConfig.ServerVersion, cfg.SlowBanner); err != nil {
+		return
+	}
+	identified, reason, received := awaitIdentification(conn, cfg.Limits.IdentificationTimeout)
+	if identified == nil {
+		if reason == "closed" && len(received) == 0 {
+			// Port scanners connect and disconnect right away.
+			return
+		}
+		hexSnippet, asciiSnippet := snippet(received)
+		log.WithFields(log.Fields{
+			"client":        conn.RemoteAddr(),
+			"category":      "non_ssh_probe",
+			"reason":        reason,
+			"size":          len(received),
+			"snippet_hex":   hexSnippet,
+			"snippet_ascii": asciiSnippet,
+		}).Warning("Non-SSH probe received")
+		return
+	}
+	recorder := &recordingConn{Conn: identified}
+	conn = recorder
+	conn = newBannerSentConn(conn, server.sshConfig.ServerVersion)
+	sess := session.New(conn.RemoteAddr())
+	sess.Shutdown = server.connections.shutdown
+	sess.ID = id
+	sess.Collector = server.Collector
+	sess.Downloader = server.Downloader
+	if server.jump != nil {
+		sess.Jumper = server.jump
+	}
+	if jump != nil {
+		sess.Depth = jump.depth
+	}
+	defer sess.EndCollection()
+	sess.Span = server.Tracer.Start("connection", map[string]string{
+		"client.address": conn.RemoteAddr().String(),
+	})
+	defer sess.Span.End()
+	authSpan := sess.Span.Child("auth", nil)
+	connConfig := *server.sshConfig
+	// x/crypto/ssh allows 6 failed attempts if MaxAuthTries is 0, and any
+	// number if it's negative.
+	connConfig.MaxAuthTries = cfg.Auth.MaxAuthTries
+	if connConfig.MaxAuthTries == 0 {
+		connConfig.MaxAuthTries = -1
+	}
+	authConnection := auth.NewConnection(cfg.Auth, server.blocker, server.history, server.Decider)
+	authConnection.Install(&connConfig)
+	if cfg.Auth.Methods.Enabled("password") {
+		connConfig.PasswordCallback = func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			return server.passwordCallback(authConnection, conn, password)
+		}
+	}
+	if len(cfg.Auth.Steps) != 0 {
+		authConnection.Chain(&connConfig, cfg.Auth.Steps)
+	}
+	authConnection.Delay(&connConfig)
+	if cfg.Tarpit.Enabled {
+		tarpitAuth(&connConfig, cfg.Tarpit.AuthDelay)
+	}
+	sshConn, channels, requests, err := ssh.NewServerConn(conn, &connConfig)
+	if err == nil {
+		authSpan.SetAttribute("user", sshConn.User())
+	}
+	authSpan.SetError(err)
+	authSpan.End()
+	if err != nil {
+		sess.Span.SetError(err)
+		fields := log.Fields{"client": conn.RemoteAddr()}
+		addFingerprintFields(fields, recorder)
+		if !authConnection.Attempted() {
+			// Scanners grab the banner, maybe the algorithms, and leave.
+			fields["stage"] = recorder.stage()
+			fields["category"] = "banner_grab"
+			log.WithFields(fields).Info("Banner grab detected")
+			return
+		}
+		authConnection.LogSteps(conn.RemoteAddr(), err)
+		authConnection.AddCounts(fields)
+		log.WithFields(fields).Warning("Failed to establish SSH connection:", err.Error())
+		return
+	}
+	authConnection.LogSteps(conn.RemoteAddr(), nil)
+	sess.User = sshConn.User()
+	if server.Hosts != nil {
+		key := server.stickyKey(cfg, conn.RemoteAddr(), sshConn)
+		server.Hosts.Restore(sess, key)
+		defer server.Hosts.Save(sess, key)
+	}
+	sess.Span.SetAttribute("user", sshConn.User())
+	sess.Span.SetAttribute("client.version", string(sshConn.ClientVersion()))
+	fields := log.Fields{
+		"client":  conn.RemoteAddr(),
+		"user":    sshConn.User(),
+		"version": string(sshConn.ClientVersion()),
+	}
+	if jump != nil {
+		fields["jump_depth"] = jump.depth
+		fields["target"] = jump.target
+	}
+	category := config.ClientCategory(string(sshConn.ClientVersion()))
+	level := cfg.Interactions.Level(category)
+	fields["client_category"] = category
+	fields["interaction_level"] = level
+	addAlgorithmFields(fields, sshConn)
+	addFingerprintFields(fields, recorder)
+	authConnection.AddCounts(fields)
+	log.WithFields(fields).Info("SSH connection established")
+	if level == config.InteractionExec {
+		levelCfg := *cfg
+		levelCfg.Shell.DenyPTY = true
+		levelCfg.Shell.DenyShell = true
+		cfg = &levelCfg
+	}
+	sess.Conn = sshConn
+	closed := make(chan struct{})
+	sess.Closed = closed
+	if cfg.Forwarding.Sandbox {
+		sess.Forwards = forward.New(sshConn, conn.RemoteAddr(), cfg.Forwarding)
+		defer sess.Forwards.Close()
+	}
+	go request.Handle(sess, cfg, "global", requests, nil)
+	limits := channel.NewLimits(sshConn, conn.RemoteAddr(), cfg.Limits, cfg.Disconnects)
+	defer limits.Stop()
+	// The connection is only done once its channels are, so that draining
+	// waits for their recordings and captures to be written.
+	var channelsDone sync.WaitGroup
+	for newChannel := range channels {
+		if level == config.InteractionAuth {
+			log.WithFields(log.Fields{
+				"client":          conn.RemoteAddr(),
+				"channel":         newChannel.ChannelType(),
+				"client_category": category,
+			}).Info("Channel rejected for client category")
+			if err := newChannel.Reject(ssh.Prohibited, "administratively prohibited"); err != nil {
+				log.Warning("Failed to reject channel:", err.Error())
+			}
+			continue
+		}
+		if !limits.OpenChannel() {
+			if err := newChannel.Reject(ssh.ResourceShortage, "too many channels"); err != nil {
+				log.Warning("Failed to reject channel:", err.Error())
+			}
+			continue
+		}
+		if !server.quota.acquireGoroutine(conn.RemoteAddr()) {
+			if err := newChannel.Reject(ssh.ResourceShortage, "too many channels"); err != nil {
+				log.Warning("Failed to reject channel:", err.Error())
+			}
+			continue
+		}
+		server.Stats.RecordChannelOpened()
+		channelsDone.Add(1)
+		go func(newChannel ssh.NewChannel) {
+			defer channelsDone.Done()
+			defer server.quota.releaseGoroutine(conn.RemoteAddr())
+			defer server.Stats.RecordChannelClosed()
+			defer recoverHandler(conn.RemoteAddr())
+			if cfg.Tarpit.Enabled {
+				time.Sleep(cfg.Tarpit.ReplyDelay)
+			}
+			channel.Handle(sess, cfg, limits, newChannel)
+		}(newChannel)
+	}
+	close(closed)
+	channelsDone.Wait()
+	fields = log.Fields{
+		"client":      conn.RemoteAddr(),
+		"duration":    time.Since(sess.Start).String(),
+		"interaction": sess.Interaction(),
+	}
+	if jump != nil {
+		fields["jump_depth"] = jump.depth
+		fields["target"] = jump.target
+	}
+	authConnection.AddCounts(fields)
+	log.WithFields(fields).Info("Client disconnected")
+}
+
+// addAlgorithmFields adds the algorithms negotiated for a connection to
+// fields. Reads are client to server and writes server to client. The MAC is
+// left out for AEAD ciphers, which don't use one, and compression is always
+// none as no other method is implemented.
+func addAlgorithmFields(fields log.Fields, conn *ssh.ServerConn) {
+	algorithmsConn, ok := conn.Conn.(ssh.AlgorithmsConnMetadata)
+	if !ok {
+		return
+	}
+	algorithms := algorithmsConn.Algorithms()
+	fields["kex"] = algorithms.KeyExchange
+	fields["host_key_algorithm"] = algorithms.HostKey
+	fields["cipher_client_to_server"] = algorithms.Read.Cipher
+	fields["cipher_server_to_client"] = algorithms.Write.Cipher
+	if algorithms.Read.MAC != "" {
+		fields["mac_client_to_server"] = algorithms.Read.MAC
+	}
+	if algorithms.Write.MAC != "" {
+		fields["mac_server_to_client"] = algorithms.Write.MAC
+	}
+	fields["compression"] = "none"
+}
+
+// addFingerprintFields adds the HASSH, the algorithms it's derived from and
+// the fingerprint of the client to fields, if its KEXINIT was received, along
+// with its version unless fields have it already.
+func addFingerprintFields(fields log.Fields, conn *recordingConn) {
+	identification, msg, ok := conn.kexInit()
+	if !ok {
+		return
+	}
+	if _, ok := fields["version"]; !ok {
+		fields["version"] = identification
+	}
+	fingerprint, raw := clientFingerprint(identification, msg)
+	fields["hassh"], fields["hassh_algorithms"] = hassh(msg)
+	fields["fingerprint"] = fingerprint
+	fields["fingerprint_raw"] = raw
+}
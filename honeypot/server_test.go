@@ -0,0 +1,197 @@
+package honeypot
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"github.com/longkeyy/sshesame/config"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// newSigner returns a new ed25519 key.
+func newSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromSigner(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// newTestServer returns a server configured by cfg with a new host key.
+func newTestServer(t *testing.T, cfg *config.Config) *Server {
+	t.Helper()
+	sshConfig := &ssh.ServerConfig{ServerVersion: "SSH-2.0-OpenSSH_8.9p1"}
+	sshConfig.AddHostKey(newSigner(t))
+	return NewServer(cfg, sshConfig)
+}
+
+// captureLog records the entries logged for the rest of the test instead
+// of printing them.
+func captureLog(t *testing.T) *logtest.Hook {
+	hook := logtest.NewGlobal()
+	out := log.StandardLogger().Out
+	log.SetOutput(ioutil.Discard)
+	t.Cleanup(func() {
+		log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+		log.SetOutput(out)
+	})
+	return hook
+}
+
+// waitFor returns the first entry logged with message, waiting for the
+// server to log it.
+func waitFor(t *testing.T, hook *logtest.Hook, message string) *log.Entry {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		for _, entry := range hook.AllEntries() {
+			if entry.Message == message {
+				return entry
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%q wasn't logged", message)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// dialTest connects to server like Dial, closing the connection at the end
+// of the test and waiting for the server to be done with it, so that nothing
+// it logs leaks into other tests.
+func dialTest(t *testing.T, server *Server, clientConfig *ssh.ClientConfig) (*ssh.Client, error) {
+	clientEnd, serverEnd := Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.HandleConn(serverEnd)
+	}()
+	t.Cleanup(func() {
+		clientEnd.Close()
+		<-done
+	})
+	conn, channels, requests, err := ssh.NewClientConn(clientEnd, serverEnd.LocalAddr().String(), clientConfig)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.NewClient(conn, channels, requests), nil
+}
+
+// dial logs in to server as root with any password.
+func dial(t *testing.T, server *Server, algorithms ssh.Config) *ssh.Client {
+	t.Helper()
+	client, err := dialTest(t, server, &ssh.ClientConfig{
+		Config:          algorithms,
+		User:            "root",
+		Auth:            []ssh.AuthMethod{ssh.Password("password")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// newConfig returns a configuration accepting any password.
+func newConfig() *config.Config {
+	return &config.Config{Auth: config.Auth{Methods: config.Methods{"password"}}}
+}
+
+func TestNegotiatedAlgorithmsLogged(t *testing.T) {
+	hook := captureLog(t)
+	for _, test := range []struct {
+		algorithms ssh.Config
+		fields     log.Fields
+	}{
+		{
+			ssh.Config{KeyExchanges: []string{"curve25519-sha256"}, Ciphers: []string{"aes256-ctr"}, MACs: []string{"hmac-sha2-256"}},
+			log.Fields{"kex": "curve25519-sha256", "cipher_client_to_server": "aes256-ctr", "cipher_server_to_client": "aes256-ctr", "mac_client_to_server": "hmac-sha2-256", "mac_server_to_client": "hmac-sha2-256"},
+		},
+		{
+			// AEAD ciphers have no separate MAC.
+			ssh.Config{KeyExchanges: []string{"ecdh-sha2-nistp256"}, Ciphers: []string{"chacha20-poly1305@openssh.com"}},
+			log.Fields{"kex": "ecdh-sha2-nistp256", "cipher_client_to_server": "chacha20-poly1305@openssh.com", "cipher_server_to_client": "chacha20-poly1305@openssh.com", "mac_client_to_server": nil, "mac_server_to_client": nil},
+		},
+	} {
+		hook.Reset()
+		dial(t, newTestServer(t, newConfig()), test.algorithms)
+		entry := waitFor(t, hook, "SSH connection established")
+		test.fields["host_key_algorithm"] = "ssh-ed25519"
+		test.fields["compression"] = "none"
+		for field, value := range test.fields {
+			if entry.Data[field] != value {
+				t.Errorf("%v = %v, want %v", field, entry.Data[field], value)
+			}
+		}
+	}
+}
+
+// serve serves connections to server on a new loopback listener until the
+// end of the test, returning its address.
+func serve(t *testing.T, server *Server) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shutdown := make(chan struct{})
+	go server.Serve(listener, shutdown)
+	t.Cleanup(func() {
+		close(shutdown)
+		listener.Close()
+		server.Drain(5 * time.Second)
+	})
+	return listener.Addr().String()
+}
+
+func TestPreAuthDelayAcceptsMeanwhile(t *testing.T) {
+	captureLog(t)
+	cfg := newConfig()
+	cfg.Auth.PreAuthDelay = time.Second
+	addr := serve(t, newTestServer(t, cfg))
+	var attempted time.Time
+	start := time.Now()
+	delayed := make(chan error, 1)
+	go func() {
+		client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+			User: "root",
+			Auth: []ssh.AuthMethod{ssh.PasswordCallback(func() (string, error) {
+				attempted = time.Now()
+				return "password", nil
+			})},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+		if err == nil {
+			client.Close()
+		}
+		delayed <- err
+	}()
+	// Another client is greeted while the first one waits.
+	time.Sleep(100 * time.Millisecond)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	if _, err := conn.Read(make([]byte, 64)); err != nil {
+		t.Errorf("second client not greeted while the first waited: %v", err)
+	}
+	if err := <-delayed; err != nil {
+		t.Fatal(err)
+	}
+	// The client's none attempt is its first, waiting before the password.
+	if waited := attempted.Sub(start); waited < time.Second {
+		t.Errorf("first attempt answered after %v, want at least 1s", waited)
+	}
+}
@@ -0,0 +1,90 @@
+package honeypot
+
+import (
+	"github.com/longkeyy/sshesame/config"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"testing"
+)
+
+func TestAuthenticationSteps(t *testing.T) {
+	hook := captureLog(t)
+	key := newSigner(t)
+	fingerprint := ssh.FingerprintSHA256(key.PublicKey())
+	cfg := newConfig()
+	cfg.Auth.Methods = config.Methods{"publickey", "password"}
+	cfg.Auth.Steps = config.Methods{"publickey", "password"}
+	cfg.Auth.PublicKeyRules = config.PublicKeyRules{{Fingerprint: fingerprint, Accept: true}}
+	for _, test := range []struct {
+		name    string
+		methods []ssh.AuthMethod
+		steps   []log.Fields
+		result  string
+		passed  int
+	}{
+		{
+			"both steps",
+			[]ssh.AuthMethod{ssh.PublicKeys(key), ssh.Password("123456")},
+			[]log.Fields{
+				{"method": "publickey", "step": 1, "partial_success": true},
+				{"method": "password", "step": 2, "partial_success": false},
+			},
+			"Multi-step authentication succeeded", 2,
+		},
+		{
+			"first step only",
+			[]ssh.AuthMethod{ssh.PublicKeys(key)},
+			[]log.Fields{{"method": "publickey", "step": 1, "partial_success": true}},
+			"Multi-step authentication failed", 1,
+		},
+		{
+			// The password alone isn't enough, however good it is.
+			"second step only",
+			[]ssh.AuthMethod{ssh.Password("123456")},
+			nil,
+			"Multi-step authentication failed", 0,
+		},
+	} {
+		hook.Reset()
+		client, err := dialTest(t, newTestServer(t, cfg), &ssh.ClientConfig{
+			User:            "root",
+			Auth:            test.methods,
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+		if (err == nil) != (test.passed == 2) {
+			t.Errorf("%v: dial = %v", test.name, err)
+		}
+		if client != nil {
+			client.Close()
+		}
+		if entry := waitFor(t, hook, test.result); entry.Data["steps"] != 2 || entry.Data["steps_passed"] != test.passed || entry.Data["user"] != "root" {
+			t.Errorf("%v: %v logged with %v, want %v of 2 steps passed by root", test.name, test.result, entry.Data, test.passed)
+		}
+		steps := []log.Fields{}
+		for _, entry := range hook.AllEntries() {
+			if entry.Message == "Authentication step passed" {
+				steps = append(steps, log.Fields{"method": entry.Data["method"], "step": entry.Data["step"], "partial_success": entry.Data["partial_success"]})
+			}
+		}
+		if len(steps) != len(test.steps) {
+			t.Errorf("%v: logged steps %v, want %v", test.name, steps, test.steps)
+			continue
+		}
+		for i := range steps {
+			for field, value := range test.steps[i] {
+				if steps[i][field] != value {
+					t.Errorf("%v: step %v %v = %v, want %v", test.name, i+1, field, steps[i][field], value)
+				}
+			}
+		}
+		// The credentials of every step are captured.
+		if test.passed == 2 {
+			if entry := waitFor(t, hook, "Public key authentication accepted"); entry.Data["fingerprint"] != fingerprint {
+				t.Errorf("%v: public key logged with %v", test.name, entry.Data)
+			}
+			if entry := waitFor(t, hook, "Password authentication accepted"); entry.Data["password"] != "123456" {
+				t.Errorf("%v: password logged with %v", test.name, entry.Data)
+			}
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package honeypot
+
+import (
+	"github.com/longkeyy/sshesame/config"
+	"github.com/longkeyy/sshesame/session"
+	"golang.org/x/crypto/ssh"
+	"testing"
+	"time"
+)
+
+// visit logs in to server as root with password, runs command and returns
+// what identifies the fake host it left: its hostname, accounts, uptime
+// baseline and the contents of /tmp. It returns once server is done with the
+// connection, so that the host is saved.
+func visit(t *testing.T, server *Server, password, command string) string {
+	t.Helper()
+	clientEnd, serverEnd := Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.HandleConn(serverEnd)
+	}()
+	defer func() {
+		clientEnd.Close()
+		<-done
+	}()
+	conn, channels, requests, err := ssh.NewClientConn(clientEnd, serverEnd.LocalAddr().String(), &ssh.ClientConfig{
+		User:            "root",
+		Auth:            []ssh.AuthMethod{ssh.Password(password)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := ssh.NewClient(conn, channels, requests)
+	output, err := newSession(t, client).Output(command + "; hostname; cat /etc/passwd; uptime -s; ls /tmp")
+	if err != nil {
+		t.Fatalf("%q = %v", command, err)
+	}
+	return string(output)
+}
+
+// newSession opens a new session of client.
+func newSession(t *testing.T, client *ssh.Client) *ssh.Session {
+	t.Helper()
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session
+}
+
+// newStickyServer returns a server presenting returning clients, identified
+// by by, the host they saw before.
+func newStickyServer(t *testing.T, by config.StickyKey) *Server {
+	cfg := newConfig()
+	cfg.Sticky = config.Sticky{By: by, Window: time.Hour}
+	server := newTestServer(t, cfg)
+	server.Hosts = session.NewHosts("salt", time.Hour)
+	return server
+}
+
+func TestStickyHosts(t *testing.T) {
+	captureLog(t)
+	server := newStickyServer(t, "ip")
+	seen := visit(t, server, "123456", "true")
+	changed := visit(t, server, "123456", "useradd -s /bin/bash backup2; echo hi > /tmp/note")
+	if changed == seen {
+		t.Fatalf("useradd and writing /tmp/note changed nothing on %q", seen)
+	}
+	// Pipes always come from 127.0.0.1, so this is the same client again,
+	// even with another password.
+	if again := visit(t, server, "hunter2", "true"); again != changed {
+		t.Errorf("returning client saw\n%v\nwant the host it left\n%v", again, changed)
+	}
+}
+
+func TestStickyHostsByCredential(t *testing.T) {
+	captureLog(t)
+	server := newStickyServer(t, "credential")
+	changed := visit(t, server, "123456", "echo hi > /tmp/note")
+	if again := visit(t, server, "123456", "true"); again != changed {
+		t.Errorf("same credential saw\n%v\nwant the host it left\n%v", again, changed)
+	}
+	if other := visit(t, server, "hunter2", "true"); other == changed {
+		t.Errorf("another credential saw the host changed with 123456:\n%v", other)
+	}
+}
@@ -0,0 +1,71 @@
+package honeypot
+
+import (
+	"github.com/longkeyy/sshesame/config"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// tarpitLineChars are the characters of the random lines sent before the
+// identification line in tarpit mode.
+const tarpitLineChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789 "
+
+// sendTarpitLines sends random lines before the identification line, which
+// RFC 4253 section 4.2 allows servers to do, one every cfg.LineDelay like
+// endlessh, and cfg.Lines of them or until the client leaves if 0. Clients
+// leaving before the identification line are logged.
+func sendTarpitLines(conn net.Conn, cfg config.Tarpit) error {
+	start := time.Now()
+	for sent := 0; cfg.Lines == 0 || sent < cfg.Lines; sent++ {
+		time.Sleep(cfg.LineDelay)
+		line := make([]byte, 3+rand.Intn(30), 35)
+		for i := range line {
+			line[i] = tarpitLineChars[rand.Intn(len(tarpitLineChars))]
+		}
+		if _, err := conn.Write(append(line, '\r', '\n')); err != nil {
+			log.WithFields(log.Fields{
+				"client":   conn.RemoteAddr(),
+				"duration": time.Since(start).String(),
+				"lines":    sent,
+				"category": "tarpit",
+			}).Info("Client left tarpit")
+			return err
+		}
+	}
+	return nil
+}
+
+// tarpitAuth makes every authentication attempt wait for delay before being
+// answered.
+func tarpitAuth(sshConfig *ssh.ServerConfig, delay time.Duration) {
+	if delay <= 0 {
+		return
+	}
+	if callback := sshConfig.NoClientAuthCallback; callback != nil {
+		sshConfig.NoClientAuthCallback = func(conn ssh.ConnMetadata) (*ssh.Permissions, error) {
+			time.Sleep(delay)
+			return callback(conn)
+		}
+	}
+	if callback := sshConfig.PasswordCallback; callback != nil {
+		sshConfig.PasswordCallback = func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			time.Sleep(delay)
+			return callback(conn, password)
+		}
+	}
+	if callback := sshConfig.PublicKeyCallback; callback != nil {
+		sshConfig.PublicKeyCallback = func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			time.Sleep(delay)
+			return callback(conn, key)
+		}
+	}
+	if callback := sshConfig.KeyboardInteractiveCallback; callback != nil {
+		sshConfig.KeyboardInteractiveCallback = func(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			time.Sleep(delay)
+			return callback(conn, client)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package honeypot
+
+import (
+	"bufio"
+	"github.com/longkeyy/sshesame/config"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestTarpitLines(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	sent := make(chan error, 1)
+	go func() {
+		sent <- sendTarpitLines(server, config.Tarpit{Lines: 3, LineDelay: time.Millisecond})
+	}()
+	reader := bufio.NewReader(client)
+	// Lines mustn't start with SSH- to be taken for the identification line,
+	// see RFC 4253 section 4.2.
+	line := regexp.MustCompile(`^[A-Za-z0-9 ]{3,32}\r\n$`)
+	for i := 0; i < 3; i++ {
+		text, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !line.MatchString(text) {
+			t.Errorf("sent %q", text)
+		}
+	}
+	if err := <-sent; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTarpitLinesClientLeft(t *testing.T) {
+	hook := captureLog(t)
+	client, server := net.Pipe()
+	sent := make(chan error, 1)
+	go func() {
+		sent <- sendTarpitLines(server, config.Tarpit{LineDelay: time.Millisecond})
+	}()
+	reader := bufio.NewReader(client)
+	for i := 0; i < 2; i++ {
+		if _, err := reader.ReadString('\n'); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client.Close()
+	if err := <-sent; err == nil {
+		t.Error("lines sent to a client that left")
+	}
+	if entries := hook.AllEntries(); len(entries) != 1 || entries[0].Message != "Client left tarpit" || entries[0].Data["lines"] != 2 || entries[0].Data["category"] != "tarpit" {
+		t.Errorf("logged %v", entries)
+	}
+}
+
+func TestTarpitAuth(t *testing.T) {
+	sshConfig := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			return &ssh.Permissions{}, nil
+		},
+	}
+	tarpitAuth(sshConfig, 50*time.Millisecond)
+	// Methods without a callback stay disabled.
+	if sshConfig.PublicKeyCallback != nil || sshConfig.KeyboardInteractiveCallback != nil || sshConfig.NoClientAuthCallback != nil {
+		t.Error("disabled methods enabled")
+	}
+	start := time.Now()
+	if permissions, err := sshConfig.PasswordCallback(nil, []byte("password")); permissions == nil || err != nil {
+		t.Errorf("password callback = %v, %v", permissions, err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("answered after %v, want 50ms", elapsed)
+	}
+}
@@ -0,0 +1,112 @@
+package honeypot
+
+import (
+	"encoding/json"
+	"github.com/longkeyy/sshesame/tracing"
+	"golang.org/x/crypto/ssh"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// exportedSpan is a span as exported over OTLP/HTTP, with its attributes.
+type exportedSpan struct {
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Attributes   []struct {
+		Key   string
+		Value struct{ StringValue string }
+	}
+}
+
+func (span exportedSpan) attribute(key string) string {
+	for _, attribute := range span.Attributes {
+		if attribute.Key == key {
+			return attribute.Value.StringValue
+		}
+	}
+	return ""
+}
+
+// collectSpans returns an OTLP/HTTP endpoint and the spans exported to it,
+// served until the end of the test.
+func collectSpans(t *testing.T) (string, func() []exportedSpan) {
+	var mu sync.Mutex
+	spans := []exportedSpan{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct{ Spans []exportedSpan }
+			}
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, resourceSpans := range request.ResourceSpans {
+			for _, scopeSpans := range resourceSpans.ScopeSpans {
+				spans = append(spans, scopeSpans.Spans...)
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, func() []exportedSpan {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]exportedSpan(nil), spans...)
+	}
+}
+
+func TestSessionTraced(t *testing.T) {
+	captureLog(t)
+	endpoint, spans := collectSpans(t)
+	server := newTestServer(t, newConfig())
+	server.Tracer = tracing.NewTracer(endpoint, "sshesame")
+	clientEnd, serverEnd := Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.HandleConn(serverEnd)
+	}()
+	conn, channels, requests, err := ssh.NewClientConn(clientEnd, serverEnd.LocalAddr().String(), &ssh.ClientConfig{
+		User:            "root",
+		Auth:            []ssh.AuthMethod{ssh.Password("password")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := ssh.NewClient(conn, channels, requests)
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output, err := session.Output("whoami"); err != nil || string(output) != "root\n" {
+		t.Errorf("whoami = %q, %v", output, err)
+	}
+	client.Close()
+	<-done
+	server.Tracer.Close()
+
+	byName := map[string]exportedSpan{}
+	for _, span := range spans() {
+		byName[span.Name] = span
+	}
+	connection, auth, channel, command := byName["connection"], byName["auth"], byName["channel"], byName["command"]
+	if connection.SpanID == "" || connection.ParentSpanID != "" || connection.attribute("client.address") != serverEnd.RemoteAddr().String() {
+		t.Fatalf("connection span = %+v, want a root span of the client", connection)
+	}
+	if auth.ParentSpanID != connection.SpanID || auth.attribute("user") != "root" {
+		t.Errorf("auth span = %+v, want a child of the connection", auth)
+	}
+	if channel.ParentSpanID != connection.SpanID || channel.attribute("channel") != "session" || channel.attribute("program") != "exec" {
+		t.Errorf("channel span = %+v, want a child of the connection", channel)
+	}
+	if command.ParentSpanID != channel.SpanID || command.attribute("command") != "whoami" || command.attribute("user") != "root" || command.attribute("exit_status") != "0" {
+		t.Errorf("command span = %+v, want a child of the channel", command)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"fmt"
+	"github.com/longkeyy/sshesame/honeypot"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+	"os"
+	"path/filepath"
+)
+
+// defaultRSABits is the size of generated RSA keys if none is given, as for
+// ssh-keygen.
+const defaultRSABits = 3072
+
+// storedHostKeyTypes are the types of the host keys kept in a host key
+// directory, like sshd's.
+var storedHostKeyTypes = []string{"ed25519", "ecdsa", "rsa"}
+
+// loadHostKeys reads the host keys of every stored type in dir, named like
+// sshd's, e.g. ssh_host_ed25519_key. Missing keys are generated first, along
+// with dir if needed, so the host keeps its identity across restarts.
+func loadHostKeys(dir string) ([]ssh.Signer, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	keys := []ssh.Signer{}
+	for _, keyType := range storedHostKeyTypes {
+		path := filepath.Join(dir, "ssh_host_"+keyType+"_key")
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			publicKey, err := generateHostKey(path, keyType, 0, false)
+			// Another process may have generated it meanwhile.
+			if err != nil && !os.IsExist(err) {
+				return nil, fmt.Errorf("failed to generate host key: %w", err)
+			}
+			if err == nil {
+				log.WithFields(log.Fields{
+					"host_key":           path,
+					"type":               publicKey.Type(),
+					"sha256_fingerprint": ssh.FingerprintSHA256(publicKey),
+				}).Info("Host key generated")
+			}
+		}
+		key, err := honeypot.ReadHostKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read host key %v: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// generateHostKey writes a new private key of keyType to path in the OpenSSH
+// format, readable only by its owner, and returns its public key. bits is the
+// key size, ignored for ed25519 and defaulting to a common size if 0. An
+// existing file is only overwritten if force is set.
+func generateHostKey(path, keyType string, bits int, force bool) (ssh.PublicKey, error) {
+	var key crypto.Signer
+	var err error
+	switch keyType {
+	case "ed25519":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	case "rsa":
+		if bits == 0 {
+			bits = defaultRSABits
+		}
+		if bits < 2048 {
+			return nil, fmt.Errorf("RSA keys must be at least 2048 bits, not %v", bits)
+		}
+		key, err = rsa.GenerateKey(rand.Reader, bits)
+	case "ecdsa":
+		var curve elliptic.Curve
+		switch bits {
+		case 0, 256:
+			curve = elliptic.P256()
+		case 384:
+			curve = elliptic.P384()
+		case 521:
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("ECDSA keys must be 256, 384 or 521 bits, not %v", bits)
+		}
+		key, err = ecdsa.GenerateKey(curve, rand.Reader)
+	default:
+		return nil, fmt.Errorf("unknown key type %q, must be ed25519, rsa or ecdsa", keyType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := ssh.NewPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	file, err := os.OpenFile(path, flags, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	// An overwritten file keeps its permissions otherwise.
+	if err := file.Chmod(0600); err != nil {
+		return nil, err
+	}
+	if err := pem.Encode(file, block); err != nil {
+		return nil, err
+	}
+	return publicKey, file.Close()
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/pem"
+	"github.com/longkeyy/sshesame/honeypot"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGenerateHostKey(t *testing.T) {
+	dir := t.TempDir()
+	for _, test := range []struct {
+		keyType string
+		bits    int
+		ssh     string
+	}{
+		{"ed25519", 0, "ssh-ed25519"},
+		{"rsa", 2048, "ssh-rsa"},
+		{"ecdsa", 0, "ecdsa-sha2-nistp256"},
+		{"ecdsa", 384, "ecdsa-sha2-nistp384"},
+	} {
+		path := filepath.Join(dir, test.ssh)
+		publicKey, err := generateHostKey(path, test.keyType, test.bits, false)
+		if err != nil {
+			t.Fatalf("generating a %v key: %v", test.ssh, err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("%v key written with mode %v, want 0600", test.ssh, info.Mode().Perm())
+		}
+		signer, err := honeypot.ReadHostKey(path)
+		if err != nil {
+			t.Fatalf("reading the %v key back: %v", test.ssh, err)
+		}
+		if signer.PublicKey().Type() != test.ssh || !bytes.Equal(signer.PublicKey().Marshal(), publicKey.Marshal()) {
+			t.Errorf("read a %v key back, want the %v key generated", signer.PublicKey().Type(), test.ssh)
+		}
+	}
+}
+
+func TestGenerateHostKeyInvalid(t *testing.T) {
+	dir := t.TempDir()
+	for _, test := range []struct {
+		keyType string
+		bits    int
+	}{
+		{"dsa", 0},
+		{"rsa", 1024},
+		{"ecdsa", 512},
+	} {
+		if _, err := generateHostKey(filepath.Join(dir, test.keyType), test.keyType, test.bits, false); err == nil {
+			t.Errorf("generated a %v bits %v key", test.bits, test.keyType)
+		}
+	}
+}
+
+func TestGenerateHostKeyOverwrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ssh_host_ed25519_key")
+	if err := ioutil.WriteFile(path, []byte("existing"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := generateHostKey(path, "ed25519", 0, false); !os.IsExist(err) {
+		t.Errorf("overwriting without force = %v, want the file to exist", err)
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != "existing" {
+		t.Errorf("file overwritten without force")
+	}
+	if _, err := generateHostKey(path, "ed25519", 0, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := honeypot.ReadHostKey(path); err != nil {
+		t.Errorf("reading the key written with force: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("key written with force has mode %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestLoadHostKeys(t *testing.T) {
+	captureLog(t)
+	dir := filepath.Join(t.TempDir(), "host_keys")
+	keys, err := loadHostKeys(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(dir); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("host key directory created with %v, %v", info, err)
+	}
+	for i, test := range []struct{ name, ssh string }{
+		{"ssh_host_ed25519_key", "ssh-ed25519"},
+		{"ssh_host_ecdsa_key", "ecdsa-sha2-nistp256"},
+		{"ssh_host_rsa_key", "ssh-rsa"},
+	} {
+		if keys[i].PublicKey().Type() != test.ssh {
+			t.Errorf("key %v is a %v key, want %v", i, keys[i].PublicKey().Type(), test.ssh)
+		}
+		// Keys are unencrypted, in the format of PROTOCOL.key: the magic
+		// string, the none cipher and KDF, no KDF options and one key.
+		data, err := ioutil.ReadFile(filepath.Join(dir, test.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, _ := pem.Decode(data)
+		if block == nil || block.Type != "OPENSSH PRIVATE KEY" || !bytes.HasPrefix(block.Bytes, []byte("openssh-key-v1\x00\x00\x00\x00\x04none\x00\x00\x00\x04none\x00\x00\x00\x00\x00\x00\x00\x01")) {
+			t.Errorf("%v holds %q", test.name, data)
+		}
+	}
+	// The host keeps its keys across restarts.
+	reloaded, err := loadHostKeys(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range keys {
+		if !bytes.Equal(reloaded[i].PublicKey().Marshal(), keys[i].PublicKey().Marshal()) {
+			t.Errorf("key %v regenerated", i)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"github.com/longkeyy/sshesame/stats"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"time"
+)
+
+const (
+	// leakWindow is the number of consecutive checks goroutines must grow
+	// for while connections don't to be considered leaking.
+	leakWindow = 5
+	// leakThreshold is the least growth over the window considered a leak,
+	// below which it's noise.
+	leakThreshold = 20
+)
+
+// sample is the goroutine and connection counts at a check.
+type sample struct {
+	goroutines, connections int
+}
+
+// leakDetector tells goroutine leaks apart from goroutines serving more
+// connections.
+type leakDetector struct {
+	samples []sample
+}
+
+// observe records a check and reports whether goroutines grew at every one of
+// the last leakWindow checks, by leakThreshold overall, while connections
+// didn't. Samples are forgotten once a leak is reported, so it's reported
+// again only if goroutines keep growing.
+func (detector *leakDetector) observe(current sample) bool {
+	detector.samples = append(detector.samples, current)
+	if len(detector.samples) > leakWindow+1 {
+		detector.samples = detector.samples[1:]
+	}
+	if len(detector.samples) <= leakWindow {
+		return false
+	}
+	for i := 1; i < len(detector.samples); i++ {
+		if detector.samples[i].goroutines <= detector.samples[i-1].goroutines {
+			return false
+		}
+	}
+	first := detector.samples[0]
+	if current.goroutines-first.goroutines < leakThreshold || current.connections > first.connections {
+		return false
+	}
+	detector.samples = nil
+	return true
+}
+
+// monitorLeaks checks for goroutine leaks every interval until stop is
+// closed. If profileDir is set, a goroutine profile is written there for every
+// leak found.
+func monitorLeaks(aggregates *stats.Stats, interval time.Duration, profileDir string, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	detector := &leakDetector{}
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+		current := sample{runtime.NumGoroutine(), aggregates.Active()}
+		if !detector.observe(current) {
+			continue
+		}
+		fields := log.Fields{
+			"goroutines":         current.goroutines,
+			"active_connections": current.connections,
+			"active_channels":    aggregates.ActiveChannels(),
+		}
+		if profileDir != "" {
+			profile, err := writeGoroutineProfile(profileDir)
+			if err != nil {
+				log.Warning("Failed to write goroutine profile:", err.Error())
+			} else {
+				fields["profile"] = profile
+			}
+		}
+		log.WithFields(fields).Warning("Goroutine leak suspected")
+	}
+}
+
+// writeGoroutineProfile writes the stacks of all goroutines to a new file in
+// dir, in the text format of the goroutine profile, and returns its path.
+func writeGoroutineProfile(dir string) (string, error) {
+	path := filepath.Join(dir, fmt.Sprintf("goroutines-%v.txt", time.Now().UTC().Format("20060102T150405Z")))
+	file, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if err := pprof.Lookup("goroutine").WriteTo(file, 1); err != nil {
+		file.Close()
+		return "", err
+	}
+	return path, file.Close()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"github.com/longkeyy/sshesame/stats"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLeakDetector(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		samples []sample
+		leak    bool
+	}{
+		{"growing", []sample{{10, 1}, {20, 1}, {30, 1}, {40, 1}, {50, 1}, {60, 1}}, true},
+		{"serving more connections", []sample{{10, 1}, {20, 2}, {30, 3}, {40, 4}, {50, 5}, {60, 6}}, false},
+		{"below the threshold", []sample{{10, 1}, {11, 1}, {12, 1}, {13, 1}, {14, 1}, {15, 1}}, false},
+		{"leveled off", []sample{{10, 1}, {20, 1}, {30, 1}, {30, 1}, {50, 1}, {60, 1}}, false},
+		{"too few checks", []sample{{10, 1}, {30, 1}, {50, 1}, {70, 1}, {90, 1}}, false},
+	} {
+		detector := &leakDetector{}
+		leak := false
+		for _, current := range test.samples {
+			leak = detector.observe(current)
+		}
+		if leak != test.leak {
+			t.Errorf("%v: leak = %v, want %v", test.name, leak, test.leak)
+		}
+	}
+}
+
+func TestLeakMonitorWarns(t *testing.T) {
+	hook := captureLog(t)
+	// Goroutines leak steadily faster than they're checked, while no
+	// connection is served.
+	release := make(chan struct{})
+	defer close(release)
+	stopLeaking := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				for i := 0; i < 10; i++ {
+					go func() { <-release }()
+				}
+			case <-stopLeaking:
+				return
+			}
+		}
+	}()
+	defer close(stopLeaking)
+
+	dir := t.TempDir()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		monitorLeaks(stats.New(), 20*time.Millisecond, dir, stop)
+		close(done)
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(hook.AllEntries()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no leak warning logged")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	entry := hook.AllEntries()[0]
+	if entry.Message != "Goroutine leak suspected" || entry.Data["active_connections"] != 0 || entry.Data["active_channels"] != 0 {
+		t.Fatalf("logged %q with %v, want a leak warning", entry.Message, entry.Data)
+	}
+	profile, err := ioutil.ReadFile(entry.Data["profile"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(profile), "TestLeakMonitorWarns") {
+		t.Error("goroutine profile doesn't show the leaking goroutines")
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"strconv"
+	"time"
+)
+
+// socketOptions are the options of listening sockets.
+type socketOptions struct {
+	// ReusePort sets SO_REUSEPORT, so that several processes can listen on
+	// the same port.
+	ReusePort bool
+	// Backlog is the length of the queue of connections waiting to be
+	// accepted, the system's default if 0.
+	Backlog int
+	// ProxyProtocol reads a PROXY protocol header from the connections of
+	// ProxyNetworks, which must be set, within ProxyTimeout.
+	ProxyProtocol bool
+	ProxyNetworks proxyNetworks
+	ProxyTimeout  time.Duration
+}
+
+// listen listens on address like net.Listen, with options.
+func (options socketOptions) listen(network, address string) (net.Listener, error) {
+	config := net.ListenConfig{}
+	if options.ReusePort {
+		config.Control = reusePort
+	}
+	listener, err := config.Listen(context.Background(), network, address)
+	if err != nil {
+		return nil, err
+	}
+	if options.Backlog > 0 {
+		if err := setBacklog(listener, options.Backlog); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+	return options.wrap(listener), nil
+}
+
+// wrap wraps a listening socket bound without options, like one passed by
+// systemd, with those that apply after binding.
+func (options socketOptions) wrap(listener net.Listener) net.Listener {
+	if options.ProxyProtocol {
+		return newProxyListener(listener, options.ProxyNetworks, options.ProxyTimeout)
+	}
+	return listener
+}
+
+var addressFamilies = map[string]string{
+	"any":  "tcp",
+	"ipv4": "tcp4",
+	"ipv6": "tcp6",
+}
+
+// listen binds every address address resolves to within family, with
+// options. An empty address binds the unspecified address, which is
+// dual-stack for the any family.
+func listen(address string, port uint, family string, options socketOptions) ([]net.Listener, error) {
+	network, ok := addressFamilies[family]
+	if !ok {
+		return nil, fmt.Errorf("unknown address family %q, must be any, ipv4 or ipv6", family)
+	}
+	portString := strconv.Itoa(int(port))
+	hosts := []string{address}
+	if address != "" && net.ParseIP(address) == nil {
+		addrs, err := net.DefaultResolver.LookupIPAddr(context.Background(), address)
+		if err != nil {
+			return nil, err
+		}
+		hosts = nil
+		for _, addr := range addrs {
+			if (network == "tcp4" && addr.IP.To4() == nil) || (network == "tcp6" && addr.IP.To4() != nil) {
+				continue
+			}
+			hosts = append(hosts, addr.String())
+		}
+		if len(hosts) == 0 {
+			return nil, fmt.Errorf("%v has no %v addresses", address, family)
+		}
+	}
+	listeners := []net.Listener{}
+	for _, host := range hosts {
+		listener, err := options.listen(network, net.JoinHostPort(host, portString))
+		if err != nil {
+			for _, listener := range listeners {
+				listener.Close()
+			}
+			return nil, err
+		}
+		log.WithFields(log.Fields{
+			"listen_address": listener.Addr(),
+		}).Info("Listening")
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// listenTLS listens on address for SSH wrapped in TLS, using the certificate
+// and key in certFile and keyFile, with options.
+func listenTLS(address, certFile, keyFile string, options socketOptions) (net.Listener, error) {
+	listener, err := options.listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	tlsListener, err := newTLSListener(listener, certFile, keyFile)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return tlsListener, nil
+}
+
+// newTLSListener wraps listener in TLS, using the certificate and key in
+// certFile and keyFile.
+func newTLSListener(listener net.Listener, certFile, keyFile string) (net.Listener, error) {
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{certificate}}
+	config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		log.WithFields(log.Fields{
+			"client":             hello.Conn.RemoteAddr(),
+			"server_name":        hello.ServerName,
+			"cipher_suites":      hello.CipherSuites,
+			"supported_versions": hello.SupportedVersions,
+			"alpn_protocols":     hello.SupportedProtos,
+		}).Info("TLS client hello received")
+		return nil, nil
+	}
+	log.WithFields(log.Fields{
+		"listen_address": listener.Addr(),
+	}).Info("Listening for TLS")
+	return tls.NewListener(listener, config), nil
+}
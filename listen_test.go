@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"github.com/longkeyy/sshesame/config"
+	"github.com/longkeyy/sshesame/honeypot"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listenLoopback listens on the IPv6 loopback address, skipping the test if
+// the host has no IPv6.
+func listenLoopback(t *testing.T) net.Listener {
+	t.Helper()
+	listeners, err := listen("::1", 0, "ipv6", socketOptions{})
+	if err != nil {
+		t.Skip("IPv6 unavailable:", err)
+	}
+	if len(listeners) != 1 {
+		t.Fatalf("listen bound %v listeners, want 1", len(listeners))
+	}
+	t.Cleanup(func() { listeners[0].Close() })
+	return listeners[0]
+}
+
+// captureLog records what's logged during the test instead of printing it.
+func captureLog(t *testing.T) *logtest.Hook {
+	hook := logtest.NewGlobal()
+	out := log.StandardLogger().Out
+	log.SetOutput(ioutil.Discard)
+	t.Cleanup(func() {
+		log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+		log.SetOutput(out)
+	})
+	return hook
+}
+
+// serve serves connections on listener with a honeypot configured by cfg
+// until the end of the test.
+func serve(t *testing.T, listener net.Listener, cfg *config.Config) {
+	t.Helper()
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshConfig := &ssh.ServerConfig{ServerVersion: "SSH-2.0-OpenSSH_8.9p1"}
+	sshConfig.AddHostKey(signer)
+	server := honeypot.NewServer(cfg, sshConfig)
+	shutdown := make(chan struct{})
+	go server.Serve(listener, shutdown)
+	t.Cleanup(func() {
+		close(shutdown)
+		listener.Close()
+		server.Drain(5 * time.Second)
+	})
+}
+
+func TestListenIPv6(t *testing.T) {
+	captureLog(t)
+	listener := listenLoopback(t)
+	addr, ok := listener.Addr().(*net.TCPAddr)
+	if !ok || !addr.IP.Equal(net.IPv6loopback) || addr.Port == 0 {
+		t.Fatalf("listening on %v, want [::1] on a port", listener.Addr())
+	}
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := net.Dial("tcp6", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if local := conn.LocalAddr().(*net.TCPAddr); local.IP.To4() != nil {
+		t.Errorf("connected from %v, want an IPv6 address", local)
+	}
+}
+
+func TestListenFamily(t *testing.T) {
+	captureLog(t)
+	for _, test := range []struct {
+		family string
+		ipv4   bool
+	}{
+		{"ipv4", true},
+		{"ipv6", false},
+	} {
+		listeners, err := listen("localhost", 0, test.family, socketOptions{})
+		if err != nil {
+			t.Logf("listen(localhost, %v) = %v", test.family, err)
+			continue
+		}
+		for _, listener := range listeners {
+			ip := listener.Addr().(*net.TCPAddr).IP
+			if (ip.To4() != nil) != test.ipv4 {
+				t.Errorf("listen(localhost, %v) bound %v", test.family, ip)
+			}
+			listener.Close()
+		}
+	}
+	if _, err := listen("localhost", 0, "ipx", socketOptions{}); err == nil {
+		t.Error("listen with an unknown address family succeeded")
+	}
+}
+
+func TestProxyNetworksMatchMappedAddresses(t *testing.T) {
+	var networks proxyNetworks
+	if err := networks.Set("10.0.0.0/8,2001:db8::1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		ip       string
+		contains bool
+	}{
+		{"10.1.2.3", true},
+		{"::ffff:10.1.2.3", true},
+		{"192.0.2.1", false},
+		{"::ffff:192.0.2.1", false},
+		{"2001:db8::1", true},
+		{"2001:db8::2", false},
+	} {
+		if contains := networks.contains(&net.TCPAddr{IP: net.ParseIP(test.ip), Port: 50000}); contains != test.contains {
+			t.Errorf("contains(%v) = %v, want %v", test.ip, contains, test.contains)
+		}
+	}
+}
+
+func TestServeIPv6Client(t *testing.T) {
+	hook := captureLog(t)
+	listener := listenLoopback(t)
+	serve(t, listener, &config.Config{Auth: config.Auth{Methods: config.Methods{"password"}}})
+
+	client, err := ssh.Dial("tcp6", listener.Addr().String(), &ssh.ClientConfig{
+		User:            "root",
+		Auth:            []ssh.AuthMethod{ssh.Password("password")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	output, err := session.Output("ss -tn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(output), "[::1]") {
+		t.Errorf("ss -tn = %q, want the connection from [::1]", output)
+	}
+
+	for _, entry := range hook.AllEntries() {
+		if entry.Message != "Client connected" {
+			continue
+		}
+		if addr, ok := entry.Data["client"].(*net.TCPAddr); !ok || !addr.IP.Equal(net.IPv6loopback) {
+			t.Errorf("Client connected with client %v, want [::1]", entry.Data["client"])
+		}
+		return
+	}
+	t.Error("no Client connected event")
+}
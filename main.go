@@ -1,106 +1,797 @@
 package main
 
 import (
+	"bytes"
+	"crypto/rand"
 	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"flag"
+	"fmt"
+	"github.com/longkeyy/sshesame/api"
+	"github.com/longkeyy/sshesame/auth"
+	"github.com/longkeyy/sshesame/cluster"
+	"github.com/longkeyy/sshesame/config"
+	"github.com/longkeyy/sshesame/geoip"
+	"github.com/longkeyy/sshesame/honeypot"
+	"github.com/longkeyy/sshesame/outbound"
+	"github.com/longkeyy/sshesame/output"
+	"github.com/longkeyy/sshesame/samples"
+	"github.com/longkeyy/sshesame/session"
+	"github.com/longkeyy/sshesame/shell"
+	"github.com/longkeyy/sshesame/stats"
+	"github.com/longkeyy/sshesame/tracing"
 	log "github.com/sirupsen/logrus"
-	"github.com/longkeyy/sshesame/channel"
-	"github.com/longkeyy/sshesame/request"
 	"golang.org/x/crypto/ed25519"
 	"golang.org/x/crypto/ssh"
 	"io/ioutil"
 	"net"
-	"strconv"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
+// builtinSinks are the names of the sinks added by flags, which
+// -sink_buffering can refer to and -sink names can't take.
+var builtinSinks = map[string]bool{
+	"console": true, "file": true, "raw_file": true, "fail2ban": true, "abuse": true, "alert": true,
+	"loki": true, "stix": true, "collector": true, "counter": true, "metrics": true, "recent": true,
+	"stream": true, "sessions": true,
+}
+
 func main() {
+	configuration := defineConfigFlags(flag.CommandLine)
+	configFile := flag.String("config", "", "a TOML file of settings named like flags, applied unless set on the command line, and reloaded on SIGHUP along with credentials_file, new connections being served with the settings reloaded (disabled if empty)")
 	hostKey := flag.String("host_key", "", "a file containing a private key to use")
-	listenAddress := flag.String("listen_address", "localhost", "the local address to listen on")
+	hostKeyDir := flag.String("host_key_dir", "", "a directory to keep ed25519, ecdsa and rsa host keys in, named like sshd's, which are generated the first time and all offered to clients, if host_key isn't set")
+	listenAddress := flag.String("listen_address", "localhost", "the local address to listen on, every address a hostname resolves to is bound and an empty address listens on all interfaces")
+	addressFamily := flag.String("address_family", "any", "the address family to listen on: any, ipv4 or ipv6 (an empty listen_address is dual-stack with any)")
 	port := flag.Uint("port", 2022, "the port number to listen on")
+	runUser := flag.String("user", "", "a user to switch to once every socket is bound and the host keys are loaded, when started as root (disabled if empty)")
+	runGroup := flag.String("group", "", "the group to switch to along with user (the primary group of user if empty)")
+	sockets := socketOptions{}
+	flag.BoolVar(&sockets.ReusePort, "reuse_port", false, "listen with SO_REUSEPORT, so that several processes can listen on the same ports and share their connections, where supported")
+	flag.IntVar(&sockets.Backlog, "listen_backlog", 0, "the length of the queue of connections waiting to be accepted by the listeners, where supported (the system's default if 0)")
+	flag.BoolVar(&sockets.ProxyProtocol, "proxy_protocol", false, "read a PROXY protocol version 1 or 2 header from the connections of proxy_protocol_networks on every listener, like sent by HAProxy, AWS NLB or Traefik, and use the client address it conveys")
+	flag.Var(&sockets.ProxyNetworks, "proxy_protocol_networks", "a comma-separated list of networks in CIDR notation or addresses of the proxies sending PROXY protocol headers, required with proxy_protocol, connections from others are served as they are")
+	flag.DurationVar(&sockets.ProxyTimeout, "proxy_protocol_timeout", 5*time.Second, "how long proxies have to send the PROXY protocol header of a connection before it's closed")
+	tlsListenAddress := flag.String("tls_listen_address", "", "an additional local address to listen on for SSH wrapped in TLS, e.g. :443 (disabled if empty)")
+	tlsCert := flag.String("tls_cert", "", "a file containing the PEM certificate chain of the TLS listener")
+	personalities := config.Personalities{}
+	flag.Var(&personalities, "personality", "a personality of the form <name>,listen=<address>[,address_family=<family>][,host_key=<file>][,server_version=<version>][,commands_dir=<dir>][,files_dir=<dir>][,profile=<name>][,restricted=<bool>][,tarpit=<bool>][,deny_pty=<bool>][,auth_methods=<methods>][,valid_users=<users>][,credentials_file=<files>][,decoy=<bool>], lists being separated by semicolons, presented on an additional listener, overriding the corresponding flags, can be repeated to run several decoys")
+	tlsKey := flag.String("tls_key", "", "a file containing the PEM private key of the TLS listener")
 	jsonLogging := flag.Bool("json_logging", false, "enable logging in JSON")
-	serverVersion := flag.String("server_version", "SSH-2.0-sshesame", "The version identification of the server (RFC 4253 section 4.2 requires that this string start with \"SSH-2.0-\")")
+	logFormat := flag.String("log_format", "", "the format of events logged to the console: text, json, cef for ArcSight CEF or leef for IBM QRadar LEEF (json if json_logging is set, text otherwise, if empty)")
+	serverVersion := flag.String("server_version", "", "The version identification of the server (RFC 4253 section 4.2 requires that this string start with \"SSH-2.0-\"), that of the system profile's SSH server if empty")
+	timestampFormat := flag.String("timestamp_format", "rfc3339nano", "the format of event timestamps: rfc3339, rfc3339nano, epoch_millis or a Go time layout")
+	timezone := flag.String("timezone", "UTC", "the time zone of event timestamps, e.g. UTC, Local or Europe/Paris")
+	logFile := flag.String("log_file", "", "a file to append events to as JSON lines, in addition to the console")
+	batching := output.Batching{}
+	flag.IntVar(&batching.Size, "log_file_batch_size", 1, "the number of events written to log_file at once")
+	flag.DurationVar(&batching.FlushInterval, "log_file_flush_interval", time.Second, "how long events wait for their batch to fill up before being written to log_file anyway, 0 waits for full batches")
+	flag.BoolVar(&batching.Gzip, "log_file_gzip", false, "compress every batch written to log_file as a gzip member, so the file can be read with zcat")
+	lokiURL := flag.String("loki_url", "", "the URL of the push API of a Grafana Loki server to push events to, e.g. http://localhost:3100/loki/api/v1/push (disabled if empty)")
+	lokiLabels := output.DefaultLokiLabels()
+	flag.Var(&lokiLabels, "loki_labels", "a comma-separated list of the low-cardinality event fields labelling Loki streams, event standing for the event type (at most 64 values each, further ones are labelled other)")
+	lokiBatching := output.Batching{}
+	flag.IntVar(&lokiBatching.Size, "loki_batch_size", 100, "the number of events pushed to Loki at once")
+	flag.DurationVar(&lokiBatching.FlushInterval, "loki_flush_interval", time.Second, "how long events wait for their batch to fill up before being pushed to Loki anyway")
+	stix := output.STIXExport{}
+	flag.StringVar(&stix.Path, "stix_file", "", "a file to write the client addresses, credentials, file hashes and commands observed to as a STIX 2.1 bundle, replaced every stix_export_interval (disabled if empty)")
+	flag.StringVar(&stix.CollectionURL, "taxii_collection_url", "", "the URL of a TAXII 2.1 collection to push the STIX indicators updated since the last push to every stix_export_interval, e.g. https://taxii.example.com/api1/collections/<id>/ (disabled if empty)")
+	flag.StringVar(&stix.User, "taxii_user", "", "the user to authenticate to taxii_collection_url as with basic authentication (none if empty)")
+	flag.StringVar(&stix.Password, "taxii_password", "", "the password to authenticate to taxii_collection_url with")
+	flag.DurationVar(&stix.Interval, "stix_export_interval", time.Hour, "how often STIX indicators are written to stix_file and pushed to taxii_collection_url, as well as when sshesame exits")
+	chainKeyFile := flag.String("log_file_chain_key", "", "a file holding a secret key to chain the lines of log_file and raw_log_file with an HMAC of the previous line's, and sign checkpoints of them with, so that tampering can be detected (disabled if empty)")
+	chaining := output.Chaining{}
+	flag.DurationVar(&chaining.CheckpointInterval, "log_file_checkpoint_interval", time.Hour, "how often a signed checkpoint of the chain of log_file is appended to it with .checkpoints added, if lines were added, in log_file_chain_key mode (only when closing it if 0)")
+	verifyLogFile := flag.String("verify_log_file", "", "verify the chain of a log file and its checkpoints with log_file_chain_key, print the result and exit")
+	fail2banLogFile := flag.String("fail2ban_log_file", "", "a file to append a line for every failed authentication attempt to, worded like sshd's for fail2ban")
+	alerting := output.Alerting{}
+	alertEvents := flag.String("alert_events", "Honeytoken credential used", "a comma-separated list of the messages, or category:<category> for the events of a category, of the events alerted on through alert_webhook_url and alert_smtp_address")
+	flag.StringVar(&alerting.WebhookURL, "alert_webhook_url", "", "a URL to post every event of alert_events to as a JSON object as soon as it happens (disabled if empty)")
+	flag.StringVar(&alerting.SMTPAddress, "alert_smtp_address", "", "the host:port of an SMTP server to mail every event of alert_events through as soon as it happens, with STARTTLS if it supports it (disabled if empty)")
+	flag.StringVar(&alerting.SMTPUser, "alert_smtp_user", "", "the user to authenticate to alert_smtp_address as (none if empty)")
+	flag.StringVar(&alerting.SMTPPassword, "alert_smtp_password", "", "the password to authenticate to alert_smtp_address with")
+	flag.StringVar(&alerting.From, "alert_email_from", "", "the sender of alert emails")
+	alertEmailTo := flag.String("alert_email_to", "", "a comma-separated list of the recipients of alert emails")
+	abuse := output.AbuseReporting{}
+	flag.IntVar(&abuse.Threshold, "abuse_report_threshold", 5, "the number of failed authentication attempts of a client within abuse_report_window after which it's reported to AbuseIPDB and abuse_ban_file")
+	flag.DurationVar(&abuse.Window, "abuse_report_window", time.Hour, "the window abuse_report_threshold failed authentication attempts are counted over")
+	flag.DurationVar(&abuse.Interval, "abuse_report_interval", 24*time.Hour, "how long a reported client isn't reported again for, at least 15m with abuseipdb_key")
+	flag.StringVar(&abuse.AbuseIPDBKey, "abuseipdb_key", "", "the API key to report clients reaching abuse_report_threshold to AbuseIPDB with (disabled if empty)")
+	flag.StringVar(&abuse.Categories, "abuseipdb_categories", "18,22", "a comma-separated list of the AbuseIPDB categories of reports, 18 being brute-force and 22 SSH")
+	flag.IntVar(&abuse.DailyLimit, "abuseipdb_daily_limit", 1000, "the most reports submitted to AbuseIPDB within 24 hours, 1000 being the limit of its free plan (unlimited if 0)")
+	flag.StringVar(&abuse.BanFile, "abuse_ban_file", "", "a file to append a line for every client reaching abuse_report_threshold to, for a fail2ban jail to ban them (disabled if empty)")
+	collectorAddress := flag.String("collector_address", "", "the host:port of a collector to forward every event to as a sensor, over TLS authenticated with cluster_cert (disabled if empty)")
+	sensorSpoolSize := flag.Int("sensor_spool_size", 100000, "the most events a sensor holds while the collector is unreachable or hasn't acknowledged them, the oldest being dropped beyond it")
+	collectorListenAddress := flag.String("collector_listen_address", "", "the local address to accept the events of sensors on as a collector, over TLS authenticated with cluster_cert (disabled if empty)")
+	clusterCert := flag.String("cluster_cert", "", "a file containing the PEM certificate chain sensors and the collector authenticate with, sensors being named by its common name")
+	clusterKey := flag.String("cluster_key", "", "a file containing the PEM private key of cluster_cert")
+	clusterCA := flag.String("cluster_ca", "", "a file containing the PEM certificates of the CAs the certificates of sensors and the collector must be issued by")
+	httpAddress := flag.String("http_address", "", "the local address to serve the health check and data endpoints on, disabled if empty")
+	apiToken := flag.String("api_token", "", "the bearer token required to manage the denylist and stream events through the HTTP API, both disabled if empty")
+	denylistFile := flag.String("denylist_file", "", "a file persisting the addresses and networks whose connections are refused, managed through the HTTP API")
+	metricsAddress := flag.String("metrics_address", "", "the local address to serve Prometheus metrics on at /metrics, disabled if empty")
+	dashboardAddress := flag.String("dashboard_address", "", "the local address to serve the web dashboard on, disabled if empty")
+	dashboardUser := flag.String("dashboard_user", "admin", "the user the web dashboard authenticates with dashboard_password")
+	dashboardPassword := flag.String("dashboard_password", "", "the password the web dashboard requires over HTTP basic authentication, which is disabled if empty")
+	algorithms := newAlgorithmFlags()
+	flag.Var(algorithms.keyExchanges, "kex_algorithms", "a comma-separated list of the key exchange algorithms offered, in order of preference, e.g. to match those of an OpenSSH version (x/crypto/ssh's defaults if unset)")
+	flag.Var(algorithms.ciphers, "ciphers", "a comma-separated list of the ciphers offered, in order of preference (x/crypto/ssh's defaults if unset)")
+	flag.Var(algorithms.macs, "macs", "a comma-separated list of the MACs offered, in order of preference (x/crypto/ssh's defaults if unset)")
+	flag.Var(algorithms.publicKeyAuths, "server_sig_algs", "a comma-separated list of the public key algorithms accepted for authentication, sent in the server-sig-algs extension (x/crypto/ssh's defaults if unset)")
+	var s3 samples.S3
+	flag.StringVar(&s3.Bucket, "s3_bucket", "", "an S3-compatible bucket to upload the files clients put on the host to, named by their SHA-256 hash (disabled if empty)")
+	quarantineDir := flag.String("quarantine_dir", "", "a directory to store the files clients put on the host in, named by their SHA-256 hash, along with a JSON Lines manifest of the files of every session named by its ID (disabled if empty)")
+	flag.StringVar(&s3.Endpoint, "s3_endpoint", "https://s3.amazonaws.com", "the URL of the S3 API of s3_bucket, e.g. http://localhost:9000 for MinIO")
+	flag.StringVar(&s3.Region, "s3_region", "us-east-1", "the region of s3_bucket")
+	flag.StringVar(&s3.AccessKey, "s3_access_key", "", "the access key to upload to s3_bucket with (AWS_ACCESS_KEY_ID if empty)")
+	flag.StringVar(&s3.SecretKey, "s3_secret_key", "", "the secret key to upload to s3_bucket with (AWS_SECRET_ACCESS_KEY if empty)")
+	captureDownloads := flag.Bool("capture_downloads", false, "fetch the HTTP and HTTPS URLs clients download files from with wget and curl, and collect the files like the ones they upload, logging their URL and SHA-256 hash")
+	var downloads samples.Downloads
+	flag.StringVar(&downloads.Proxy, "download_proxy", "", "the URL of an HTTP proxy to fetch capture_downloads through, e.g. http://proxy:3128 (if empty, only public addresses are connected to directly)")
+	flag.Int64Var(&downloads.MaxSize, "download_max_size", 10<<20, "the most bytes of a file capture_downloads fetches, larger ones aren't collected (unlimited if 0)")
+	downloadContentTypes := flag.String("download_content_types", "", "a comma-separated list of the media types capture_downloads collects, <type>/ matching every subtype, e.g. application/,text/x-shellscript (any if empty)")
+	flag.DurationVar(&downloads.Timeout, "download_timeout", 30*time.Second, "how long capture_downloads waits for a file to be fetched")
+	statsFile := flag.String("stats_file", "", "a file to persist aggregated credential and client statistics to across restarts")
+	statsSnapshotInterval := flag.Duration("stats_snapshot_interval", 5*time.Minute, "how often to snapshot statistics to stats_file")
+	geoIPDatabases := flag.String("geoip_db", "", "a comma-separated list of MaxMind DB files, e.g. GeoLite2-City.mmdb,GeoLite2-ASN.mmdb, to add the country, city, ASN and organization of clients to their events from (disabled if empty)")
+	geoIPReloadInterval := flag.Duration("geoip_reload_interval", time.Hour, "how often to check whether the files of geoip_db were updated and reload them, 0 disables reloading")
+	severities := output.DefaultSeverities()
+	flag.Var(&severities, "severity", "a <message or category:<category>>=<debug|info|notice|warning|critical> pair overriding the severity field of matching events, can be repeated")
+	breakerFailures := flag.Int("sink_breaker_failures", 5, "the number of consecutive failures after which events aren't written to a failing log file or Loki for sink_breaker_cooldown, 0 disables the circuit breaker")
+	breakerCooldown := flag.Duration("sink_breaker_cooldown", 30*time.Second, "how long events aren't written to a failing log file or Loki for once sink_breaker_failures is reached")
+	sinks := output.SinkSpecs{}
+	flag.Var(&sinks, "sink", "a <type>[,<key>=<value>...] sink of a registered type to add, which can be repeated, a name option naming it for sink_buffering when there are several of a type; built-in types are stdout (format option), file (path, format, max_size, max_files and chain options), loki (url and ;-separated labels options), syslog (address, network, facility, tag and format options), webhook (url and authorization options), elasticsearch (url, index, user, password and api_key options), hpfeeds (address, ident, secret, auth_channel, commands_channel and files_channel options), kafka (;-separated brokers and topic options) and database (dsn and driver options)")
+	bufferings := output.Bufferings{}
+	flag.Var(&bufferings, "sink_buffering", "a <sink>=<block|drop_newest|drop_oldest>[:<size>] pair choosing what happens to events for a sink whose buffer of 1024 events is full, block delays sessions until it catches up, can be repeated for console, file, raw_file, fail2ban, abuse, alert, loki, stix, collector, counter, metrics, recent, stream, sessions and the ones added with sink (default drop_newest)")
+	filter := output.Filter{}
+	flag.Var(&filter.Include, "log_event_types", "a comma-separated list of the only request and channel types to log events of, e.g. exec,session (if empty, all are logged)")
+	flag.Var(&filter.Exclude, "suppress_event_types", "a comma-separated list of request and channel types not to log events of, e.g. window-change,keepalive@openssh.com,env, they still count toward the heartbeat")
+	scrubber := &output.Scrubber{}
+	scrubPII := flag.Bool("scrub_pii", false, "redact email addresses, payment card numbers and common API keys and tokens from events")
+	flag.Var(scrubber, "scrub_pattern", "a regular expression matching additional sensitive data to redact from events, can be repeated")
+	flag.BoolVar(&scrubber.Hash, "scrub_hash", false, "replace redacted data with a hash of it, so that events can still be correlated")
+	rawLogFile := flag.String("raw_log_file", "", "a file to append events to as JSON lines before sensitive data is redacted from them or they're truncated, which only its owner can read")
+	maxFieldLength := flag.Int("max_field_length", 0, "the most bytes of a string field, such as a command, to log, longer ones are truncated and logged with their length and SHA-256 hash (unlimited if 0)")
+	outboundAllowlist := outbound.Allowlist{}
+	flag.Var(&outboundAllowlist, "outbound_allowlist", "a comma-separated list of CIDRs, IPs and hostnames, *.<domain> matching subdomains, that Loki, OTLP, S3, TAXII, AbuseIPDB, alert webhooks and SMTP servers and the shadow backend may connect to, refusing connections to any other host (unrestricted if empty)")
+	otlpEndpoint := flag.String("otlp_endpoint", "", "the OTLP/HTTP traces endpoint of an OpenTelemetry collector to export connections to as traces, e.g. http://localhost:4318/v1/traces (disabled if empty)")
+	otlpServiceName := flag.String("otlp_service_name", "sshesame", "the service name traces are exported as")
+	drainTimeout := flag.Duration("drain_timeout", 10*time.Second, "how long to wait on shutdown for connections to finish before closing them")
+	connectLogEvery := flag.Int("connect_log_every", 1, "only log every nth Client connected event, and the first one of every client, summarising the others every connect_log_summary_interval (every one is logged if 1)")
+	connectLogSummaryInterval := flag.Duration("connect_log_summary_interval", time.Minute, "how often to summarise the Client connected events connect_log_every suppressed")
+	heartbeatInterval := flag.Duration("heartbeat_interval", 0, "how often to log a summary of the activity since startup, disabled if 0")
+	leakCheckInterval := flag.Duration("leak_check_interval", 0, "how often to check whether goroutines grow while connections don't, logging a warning if they keep doing so, disabled if 0")
+	leakProfileDir := flag.String("leak_profile_dir", "", "a directory to write a goroutine profile to whenever a leak is suspected")
+	runSelfCheck := flag.Bool("self_check", false, "attempt to authenticate with every enabled method once listening, check that the attempts are answered and logged, then exit with a non-zero status if any check failed")
+	replayFiles := flag.String("replay", "", "a comma-separated list of JSON logs like log_file writes or asciicast recordings whose events, or typed command lines, are re-emitted through the configured sinks instead of serving clients, exiting once done (disabled if empty)")
+	replaySpeed := flag.Float64("replay_speed", 1, "how many times faster than originally the events of replay are re-emitted, as fast as possible if 0")
+	printVersion := flag.Bool("version", false, "print the version and exit")
+	generateKey := flag.String("generate_host_key", "", "generate a private key to use with host_key, write it to this file, print its fingerprint and exit")
+	generateKeyType := flag.String("generate_host_key_type", "ed25519", "the type of the key written by generate_host_key: ed25519, rsa or ecdsa")
+	generateKeyBits := flag.Int("generate_host_key_bits", 0, "the size of the key written by generate_host_key, 3072 for rsa and 256 for ecdsa if 0")
+	force := flag.Bool("force", false, "let generate_host_key overwrite an existing file")
 	flag.Parse()
+	reloader := loadConfigFile(*configFile)
+
+	build := api.BuildInfo{Version: version, Commit: commit, GoVersion: runtime.Version()}
+	if *printVersion {
+		fmt.Printf("sshesame %v (commit %v, %v)\n", build.Version, build.Commit, build.GoVersion)
+		return
+	}
+	if *chainKeyFile != "" {
+		key, err := ioutil.ReadFile(*chainKeyFile)
+		if err != nil {
+			log.Fatal("Failed to read log file chain key:", err.Error())
+		}
+		chaining.Key = bytes.TrimSpace(key)
+		if len(chaining.Key) == 0 {
+			log.Fatal("Invalid log_file_chain_key:", fmt.Sprintf("%v is empty", *chainKeyFile))
+		}
+	}
+	if *verifyLogFile != "" {
+		if len(chaining.Key) == 0 {
+			log.Fatal("Invalid verify_log_file:", "log_file_chain_key isn't set")
+		}
+		lines, checkpoints, err := output.VerifyChain(*verifyLogFile, chaining.Key)
+		if err != nil {
+			fmt.Printf("%v: verification failed: %v\n", *verifyLogFile, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%v: OK, %v lines and %v checkpoints verified\n", *verifyLogFile, lines, checkpoints)
+		return
+	}
+	if *generateKey != "" {
+		publicKey, err := generateHostKey(*generateKey, *generateKeyType, *generateKeyBits, *force)
+		if err != nil {
+			log.Fatal("Failed to generate host key:", err.Error())
+		}
+		fmt.Println(ssh.FingerprintSHA256(publicKey), publicKey.Type())
+		return
+	}
+
+	if sockets.Backlog < 0 {
+		log.Fatal("Invalid listen_backlog:", fmt.Sprintf("%v is negative", sockets.Backlog))
+	}
+	if sockets.ProxyProtocol && len(sockets.ProxyNetworks) == 0 {
+		log.Fatal("Invalid proxy_protocol:", "proxy_protocol_networks isn't set")
+	}
+	if sockets.ProxyTimeout <= 0 {
+		log.Fatal("Invalid proxy_protocol_timeout:", fmt.Sprintf("%v isn't positive", sockets.ProxyTimeout))
+	}
+	if downloads.Timeout <= 0 {
+		log.Fatal("Invalid download_timeout:", fmt.Sprintf("%v isn't positive", downloads.Timeout))
+	}
+	if *connectLogEvery < 1 {
+		log.Fatal("Invalid connect_log_every:", fmt.Sprintf("%v isn't positive", *connectLogEvery))
+	}
+	if *connectLogSummaryInterval <= 0 {
+		log.Fatal("Invalid connect_log_summary_interval:", fmt.Sprintf("%v isn't positive", *connectLogSummaryInterval))
+	}
+	if *replaySpeed < 0 {
+		log.Fatal("Invalid replay_speed:", fmt.Sprintf("%v is negative", *replaySpeed))
+	}
+	if !outboundAllowlist.Empty() {
+		outbound.Restrict(outboundAllowlist)
+	}
+	cfg, err := configuration.load()
+	if err != nil {
+		log.Fatal("Invalid configuration:", err.Error())
+	}
+
+	timestamps, err := output.ParseTimestamps(*timestampFormat)
+	if err != nil {
+		log.Fatal("Invalid timestamp format:", err.Error())
+	}
+	if *logFormat == "" {
+		*logFormat = "text"
+		if *jsonLogging {
+			*logFormat = "json"
+		}
+	}
+	output.ProductVersion = build.Version
+	consoleFormatter, err := output.NewFormatter(*logFormat, timestamps)
+	if err != nil {
+		log.Fatal("Invalid log format:", err.Error())
+	}
+	location, err := time.LoadLocation(*timezone)
+	if err != nil {
+		log.Fatal("Invalid time zone:", err.Error())
+	}
+	for name := range bufferings {
+		if !builtinSinks[name] && !sinks.Contains(name) {
+			log.Fatal("Invalid sink buffering:", fmt.Sprintf("unknown sink %q", name))
+		}
+	}
+	dispatcher := &output.Dispatcher{Location: location, Severities: severities, Filter: filter, MaxFieldLength: *maxFieldLength, Buffering: bufferings}
+	dispatcher.Tags = output.NewTags()
+	if *geoIPDatabases != "" {
+		reader, err := geoip.Open(strings.Split(*geoIPDatabases, ","))
+		if err != nil {
+			log.Fatal("Failed to open GeoIP database:", err.Error())
+		}
+		dispatcher.GeoIP = reader
+	}
+	if *scrubPII {
+		scrubber.Patterns = append(output.DefaultScrubPatterns(), scrubber.Patterns...)
+	}
+	if len(scrubber.Patterns) != 0 {
+		dispatcher.Scrubber = scrubber
+	}
+	dispatcher.Add("console", output.NewWriterSink(os.Stderr, consoleFormatter), 1024)
+	health := &api.Health{Build: build, Started: time.Now()}
+	// Sinks that can fail for a while are wrapped in a circuit breaker, and
+	// the server isn't ready while their last event failed, including while
+	// their breaker is open.
+	addFallible := func(name string, sink output.Sink) {
+		status := output.NewStatusSink(sink)
+		health.AddCheck("sink "+name, status.Err)
+		sink = status
+		if *breakerFailures > 0 {
+			sink = output.NewBreakerSink(name, sink, *breakerFailures, *breakerCooldown)
+		}
+		dispatcher.Add(name, sink, 1024)
+	}
+	sinkConfig := func(options map[string]string) output.SinkConfig {
+		return output.SinkConfig{Options: options, Timestamps: timestamps, Batching: batching, Chaining: chaining}
+	}
+	if *logFile != "" {
+		options := map[string]string{"path": *logFile}
+		if len(chaining.Key) != 0 {
+			options["chain"] = "true"
+		}
+		sink, err := output.NewSink("file", sinkConfig(options))
+		if err != nil {
+			log.Fatal("Failed to open log file:", err.Error())
+		}
+		addFallible("file", sink)
+	}
+	if *rawLogFile != "" {
+		sink, err := output.NewFileSink(*rawLogFile, timestamps.Formatter(true), batching, chaining, output.Rotation{})
+		if err != nil {
+			log.Fatal("Failed to open raw log file:", err.Error())
+		}
+		dispatcher.AddRaw("raw_file", sink, 1024)
+	}
+	if *lokiURL != "" {
+		config := sinkConfig(map[string]string{"url": *lokiURL, "labels": strings.Join(lokiLabels, ";")})
+		config.Batching = lokiBatching
+		sink, err := output.NewSink("loki", config)
+		if err != nil {
+			log.Fatal("Invalid Loki sink:", err.Error())
+		}
+		addFallible("loki", sink)
+	}
+	for _, spec := range sinks {
+		if builtinSinks[spec.Name] {
+			log.Fatal("Invalid sink:", fmt.Sprintf("the name %q is taken by a built-in sink, set a name option", spec.Name))
+		}
+		sink, err := output.NewSink(spec.Type, sinkConfig(spec.Options))
+		if err != nil {
+			log.Fatal("Failed to create sink:", fmt.Sprintf("%v: %v", spec.Name, err))
+		}
+		addFallible(spec.Name, sink)
+	}
+	if *fail2banLogFile != "" {
+		validUser := shell.IsSystemUser
+		if len(cfg.Auth.Users) != 0 {
+			validUser = cfg.Auth.Users.Valid
+		}
+		sink, err := output.NewFail2banSink(*fail2banLogFile, validUser)
+		if err != nil {
+			log.Fatal("Failed to open fail2ban log file:", err.Error())
+		}
+		addFallible("fail2ban", sink)
+	}
+	if alerting.WebhookURL != "" || alerting.SMTPAddress != "" {
+		alerting.Events = strings.Split(*alertEvents, ",")
+		if *alertEmailTo != "" {
+			alerting.To = strings.Split(*alertEmailTo, ",")
+		}
+		sink, err := output.NewAlertSink(alerting, timestamps)
+		if err != nil {
+			log.Fatal("Invalid alerting:", err.Error())
+		}
+		dispatcher.Add("alert", sink, 1024)
+	}
+	if abuse.AbuseIPDBKey != "" || abuse.BanFile != "" {
+		sink, err := output.NewAbuseSink(abuse)
+		if err != nil {
+			log.Fatal("Invalid abuse reporting:", err.Error())
+		}
+		dispatcher.Add("abuse", sink, 1024)
+	}
+	if stix.Path != "" || stix.CollectionURL != "" {
+		if stix.Interval <= 0 {
+			log.Fatal("Invalid STIX export interval:", fmt.Sprintf("%v isn't positive", stix.Interval))
+		}
+		dispatcher.Add("stix", output.NewSTIXSink(stix), 1024)
+	}
+	var clusterTLS *tls.Config
+	if *collectorAddress != "" || *collectorListenAddress != "" {
+		clusterTLS, err = cluster.TLSConfig(*clusterCert, *clusterKey, *clusterCA)
+		if err != nil {
+			log.Fatal("Invalid cluster certificate:", err.Error())
+		}
+	}
+	if *collectorAddress != "" {
+		sink, err := cluster.NewSensorSink(*collectorAddress, clusterTLS, *sensorSpoolSize)
+		if err != nil {
+			log.Fatal("Invalid collector:", err.Error())
+		}
+		dispatcher.Add("collector", sink, 1024)
+	}
+	counter := output.NewCounterSink()
+	if *heartbeatInterval > 0 || *runSelfCheck {
+		dispatcher.AddMetrics("counter", counter, 1024)
+	}
+	metrics := output.NewMetricsSink()
+	if *metricsAddress != "" {
+		dispatcher.AddMetrics("metrics", metrics, 1024)
+	}
+	recent := output.NewRecentSink(500)
+	if *httpAddress != "" || *dashboardAddress != "" {
+		dispatcher.Add("recent", recent, 1024)
+	}
+	stream := output.NewStreamSink(timestamps)
+	if *httpAddress != "" && *apiToken != "" {
+		dispatcher.Add("stream", stream, 1024)
+	}
+	sessions := output.NewSessionsSink()
+	if *dashboardAddress != "" {
+		dispatcher.Add("sessions", sessions, 1024)
+	}
+	log.SetOutput(ioutil.Discard)
+	log.AddHook(dispatcher)
+	log.RegisterExitHandler(dispatcher.Close)
+	defer dispatcher.Close()
 
-	if *jsonLogging {
-		log.SetFormatter(&log.JSONFormatter{})
+	if *replayFiles != "" {
+		events, err := replay(strings.Split(*replayFiles, ","), *replaySpeed, timestamps.Layout)
+		if err != nil {
+			log.Fatal("Failed to replay events:", err.Error())
+		}
+		fmt.Printf("%v events replayed\n", events)
+		return
+	}
+
+	aggregates := stats.New()
+	if *statsFile != "" {
+		aggregates = stats.Load(*statsFile)
+	}
+
+	log.WithFields(log.Fields{
+		"version":    build.Version,
+		"commit":     build.Commit,
+		"go_version": build.GoVersion,
+	}).Info("Starting")
+
+	denylist, err := auth.LoadDenylist(*denylistFile)
+	if err != nil {
+		log.Fatal("Failed to load denylist:", err.Error())
+	}
+	if *httpAddress != "" {
+		// Bound before privileges are dropped.
+		httpListener, err := net.Listen("tcp", *httpAddress)
+		if err != nil {
+			log.Fatal("Failed to listen for HTTP API:", err.Error())
+		}
+		go func() {
+			log.WithFields(log.Fields{
+				"http_address": *httpAddress,
+			}).Info("Serving HTTP API")
+			err := http.Serve(httpListener, api.Handler(health, dispatcher, recent, stream, aggregates, denylist, *apiToken))
+			log.Fatal("Failed to serve HTTP API:", err.Error())
+		}()
+	}
+	if *dashboardAddress != "" {
+		if *dashboardPassword == "" {
+			log.Warning("Serving dashboard without authentication, set dashboard_password unless it's only reachable by trusted users")
+		}
+		// Bound before privileges are dropped.
+		dashboardListener, err := net.Listen("tcp", *dashboardAddress)
+		if err != nil {
+			log.Fatal("Failed to listen for dashboard:", err.Error())
+		}
+		go func() {
+			log.WithFields(log.Fields{
+				"dashboard_address": *dashboardAddress,
+			}).Info("Serving dashboard")
+			err := http.Serve(dashboardListener, api.DashboardHandler(recent, aggregates, sessions, cfg.Shell.RecordingDir, *dashboardUser, *dashboardPassword))
+			log.Fatal("Failed to serve dashboard:", err.Error())
+		}()
 	}
 
-	var key ssh.Signer
-	var err error
-	if *hostKey != "" {
-		keyBytes, err := ioutil.ReadFile(*hostKey)
+	var collectorListener net.Listener
+	if *collectorListenAddress != "" {
+		// Bound before privileges are dropped.
+		collectorListener, err = net.Listen("tcp", *collectorListenAddress)
+		if err != nil {
+			log.Fatal("Failed to listen for sensors:", err.Error())
+		}
+		log.WithFields(log.Fields{
+			"collector_listen_address": collectorListener.Addr(),
+		}).Info("Collecting events of sensors")
+	}
+
+	var keys []ssh.Signer
+	switch {
+	case *hostKey != "":
+		key, err := honeypot.ReadHostKey(*hostKey)
 		if err != nil {
 			log.Fatal("Failed to read host key:", err.Error())
 		}
-		key, err = ssh.ParsePrivateKey(keyBytes)
+		keys = []ssh.Signer{key}
+	case *hostKeyDir != "":
+		keys, err = loadHostKeys(*hostKeyDir)
 		if err != nil {
-			log.Fatal("Failed to parse host key:", err.Error())
+			log.Fatal("Failed to load host keys:", err.Error())
 		}
-	} else {
+	default:
 		_, keyBytes, err := ed25519.GenerateKey(nil)
 		if err != nil {
 			log.Fatal("Failed to generate temporary private key:", err.Error())
 		}
-		key, err = ssh.NewSignerFromSigner(keyBytes)
+		key, err := ssh.NewSignerFromSigner(keyBytes)
 		if err != nil {
 			log.Fatal("Failed to parse generated private key:", err.Error())
 		}
+		keys = []ssh.Signer{key}
 		log.WithFields(log.Fields{
 			"sha256_fingerprint": sha256.Sum256(key.PublicKey().Marshal()),
-		}).Warning("Using a temporary host key, consider creating a permanent one and passing it to -host_key")
+		}).Warning("Using a temporary host key, consider keeping permanent ones in -host_key_dir or creating one with -generate_host_key and passing it to -host_key")
 	}
 
-	serverConfig := &ssh.ServerConfig{
+	profile := shell.LookupProfile(cfg.Shell.Profile)
+	sshConfig := &ssh.ServerConfig{
 		ServerVersion: *serverVersion,
-		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	}
+	if sshConfig.ServerVersion == "" {
+		sshConfig.ServerVersion = profile.ServerVersion
+	}
+	if sshConfig.ServerVersion == "" {
+		sshConfig.ServerVersion = "SSH-2.0-sshesame"
+	}
+	algorithms.apply(sshConfig)
+	// The keys of every type are kept for personalities with other profiles.
+	for _, key := range honeypot.ProfileHostKeys(profile, keys) {
+		sshConfig.AddHostKey(key)
+	}
+	// servers maps every listener to the server of its personality, and
+	// reloaded are the servers, reconfigured once the config file is
+	// reloaded.
+	servers := map[net.Listener]*honeypot.Server{}
+	server := honeypot.NewServer(cfg, sshConfig)
+	reloaded := []*honeypot.Server{server}
+	server.Stats = aggregates
+	server.Denylist = denylist
+	server.Tracer = tracing.NewTracer(*otlpEndpoint, *otlpServiceName)
+	// Events are only tagged with the personality if there are several.
+	server.Tags = dispatcher.Tags
+	server.TagPersonality = len(personalities) != 0
+	server.Connects = honeypot.NewConnectSampler(*connectLogEvery)
+	if *metricsAddress != "" {
+		// Connections are counted from Client connected events, along with
+		// the ones connect_log_every didn't log.
+		metrics.Metrics = []output.Metric{
+			{Name: "sshesame_connections_total", Help: "Connections accepted.", Type: "counter", Value: func() float64 {
+				return float64(metrics.Count("Client connected") + server.Connects.Suppressed())
+			}},
+			{Name: "sshesame_active_sessions", Help: "SSH connections currently open.", Type: "gauge", Value: func() float64 {
+				return float64(aggregates.Active())
+			}},
+			{Name: "sshesame_active_channels", Help: "Channels currently open.", Type: "gauge", Value: func() float64 {
+				return float64(aggregates.ActiveChannels())
+			}},
+		}
+		metrics.Dropped = dispatcher.Dropped
+		// Bound before privileges are dropped.
+		metricsListener, err := net.Listen("tcp", *metricsAddress)
+		if err != nil {
+			log.Fatal("Failed to listen for metrics:", err.Error())
+		}
+		go func() {
 			log.WithFields(log.Fields{
-				"client":   conn.RemoteAddr(),
-				"user":     conn.User(),
-				"password": string(password),
-				"version":  string(conn.ClientVersion()),
-			}).Info("Password authentication accepted")
-			return nil, nil
-		},
+				"metrics_address": *metricsAddress,
+			}).Info("Serving metrics")
+			err := http.Serve(metricsListener, api.MetricsHandler(metrics))
+			log.Fatal("Failed to serve metrics:", err.Error())
+		}()
+	}
+	if cfg.Sticky.By != "" {
+		salt := cfg.Sticky.Salt
+		if salt == "" {
+			random := make([]byte, 16)
+			rand.Read(random)
+			salt = hex.EncodeToString(random)
+		}
+		server.Hosts = session.NewHosts(salt, cfg.Sticky.Window)
+	}
+	collectors := samples.Collectors{}
+	if s3.Bucket != "" {
+		if s3.AccessKey == "" {
+			s3.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+		}
+		if s3.SecretKey == "" {
+			s3.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		}
+		uploader := samples.NewUploader(s3)
+		defer uploader.Close()
+		collectors = append(collectors, uploader)
+	}
+	if *quarantineDir != "" {
+		quarantine, err := samples.NewQuarantine(*quarantineDir)
+		if err != nil {
+			log.Fatal("Failed to create quarantine directory:", err.Error())
+		}
+		collectors = append(collectors, quarantine)
+	}
+	if len(collectors) != 0 {
+		server.Collector = collectors
+	}
+	if *captureDownloads {
+		for _, contentType := range strings.Split(*downloadContentTypes, ",") {
+			if contentType = strings.TrimSpace(contentType); contentType != "" {
+				downloads.ContentTypes = append(downloads.ContentTypes, contentType)
+			}
+		}
+		downloader, err := samples.NewDownloader(downloads)
+		if err != nil {
+			log.Fatal("Invalid download_proxy:", err.Error())
+		}
+		server.Downloader = downloader
+	}
+	defer server.Tracer.Close()
+	if cfg.Jump.Emulate {
+		if err := server.EmulateJumpHosts(); err != nil {
+			log.Fatal("Failed to generate jump host key:", err.Error())
+		}
 	}
-	serverConfig.AddHostKey(key)
 
-	listener, err := net.Listen("tcp", net.JoinHostPort(*listenAddress, strconv.Itoa(int(*port))))
+	if (sockets.ReusePort || sockets.Backlog > 0) && !socketOptionsSupported {
+		log.Warning("SO_REUSEPORT and listen backlogs aren't supported on this platform, listening without them")
+		sockets.ReusePort, sockets.Backlog = false, 0
+	}
+	inherited, err := systemdListeners()
 	if err != nil {
-		log.Fatal("Failed to listen:", err.Error())
+		log.Fatal("Failed to inherit sockets from systemd:", err.Error())
 	}
-	log.WithFields(log.Fields{
-		"listen_address": listener.Addr(),
-	}).Info("Listening")
-	defer listener.Close()
-
-	for {
-		conn, err := listener.Accept()
+	// Sockets systemd passed named tls or after a personality replace its
+	// listener, the others the main one.
+	var listeners, tlsListeners []net.Listener
+	inheritedPersonalities := map[string][]net.Listener{}
+	for _, socket := range inherited {
+		log.WithFields(log.Fields{
+			"listen_address": socket.listener.Addr(),
+			"name":           socket.name,
+		}).Info("Inherited socket from systemd")
+		switch {
+		case socket.name == "tls":
+			tlsListeners = append(tlsListeners, socket.listener)
+		case personalities.Named(socket.name):
+			inheritedPersonalities[socket.name] = append(inheritedPersonalities[socket.name], socket.listener)
+		default:
+			listeners = append(listeners, sockets.wrap(socket.listener))
+		}
+	}
+	if len(listeners) == 0 {
+		listeners, err = listen(*listenAddress, *port, *addressFamily, sockets)
+		if err != nil {
+			log.Fatal("Failed to listen:", err.Error())
+		}
+	}
+	selfCheckAddress := listeners[0].Addr().String()
+	for _, listener := range tlsListeners {
+		tlsListener, err := newTLSListener(sockets.wrap(listener), *tlsCert, *tlsKey)
+		if err != nil {
+			log.Fatal("Failed to listen for TLS:", err.Error())
+		}
+		listeners = append(listeners, tlsListener)
+	}
+	if *tlsListenAddress != "" && len(tlsListeners) == 0 {
+		listener, err := listenTLS(*tlsListenAddress, *tlsCert, *tlsKey, sockets)
 		if err != nil {
-			log.Warning("Failed to accept connection:", err.Error())
-			continue
+			log.Fatal("Failed to listen for TLS:", err.Error())
+		}
+		listeners = append(listeners, listener)
+	}
+	for _, listener := range listeners {
+		servers[listener] = server
+	}
+	for _, personality := range personalities {
+		personalityServer, err := server.WithPersonality(personality, keys)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"personality": personality.Name,
+			}).Fatal("Invalid personality:", err.Error())
+		}
+		personalityListeners := []net.Listener{}
+		for _, listener := range inheritedPersonalities[personality.Name] {
+			personalityListeners = append(personalityListeners, sockets.wrap(listener))
+		}
+		if len(personalityListeners) == 0 {
+			network := "tcp"
+			if personality.AddressFamily != "" {
+				network = addressFamilies[personality.AddressFamily]
+			}
+			listener, err := sockets.listen(network, personality.ListenAddress)
+			if err != nil {
+				log.Fatal("Failed to listen:", err.Error())
+			}
+			personalityListeners = append(personalityListeners, listener)
+		}
+		for _, listener := range personalityListeners {
+			log.WithFields(log.Fields{
+				"listen_address": listener.Addr(),
+				"personality":    personality.Name,
+			}).Info("Listening")
+			listeners = append(listeners, listener)
+			servers[listener] = personalityServer
+		}
+		reloaded = append(reloaded, personalityServer)
+	}
+	// Privileges are only dropped once every socket is bound and every host
+	// key and log file opened, which may need root.
+	if *runUser != "" {
+		uid, gid, err := dropPrivileges(*runUser, *runGroup)
+		if err != nil {
+			log.Fatal("Failed to drop privileges:", err.Error())
 		}
 		log.WithFields(log.Fields{
-			"client": conn.RemoteAddr(),
-		}).Info("Client connected")
-		go handleConn(serverConfig, conn)
+			"user": *runUser,
+			"uid":  uid,
+			"gid":  gid,
+		}).Info("Privileges dropped")
 	}
-}
+	health.SetListening(true)
 
-func handleConn(serverConfig *ssh.ServerConfig, conn net.Conn) {
-	defer conn.Close()
-	_, channels, requests, err := ssh.NewServerConn(conn, serverConfig)
-	if err != nil {
-		log.Warning("Failed to establish SSH connection:", err.Error())
-		return
+	if reloader != nil {
+		reloader.servers = reloaded
+		go reloader.reloadOnSIGHUP()
+	} else if cfg.Auth.Credentials != nil {
+		go reloadCredentials(cfg.Auth.Credentials)
 	}
-	log.WithFields(log.Fields{
-		"client": conn.RemoteAddr(),
-	}).Info("SSH connection established")
-	go request.Handle(conn.RemoteAddr(), "global", requests)
-	for newChannel := range channels {
-		go channel.Handle(conn.RemoteAddr(), newChannel)
+
+	shutdown := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.WithFields(log.Fields{
+			"signal": sig,
+		}).Info("Shutting down")
+		health.SetShuttingDown()
+		close(shutdown)
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	if *statsFile != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			aggregates.SnapshotEvery(*statsFile, *statsSnapshotInterval, shutdown)
+		}()
 	}
-	log.WithFields(log.Fields{
-		"client": conn.RemoteAddr(),
-	}).Info("Client disconnected")
+	if dispatcher.GeoIP != nil && *geoIPReloadInterval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dispatcher.GeoIP.ReloadEvery(*geoIPReloadInterval, shutdown)
+		}()
+	}
+	if *heartbeatInterval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			heartbeat(counter, aggregates, server.Connects, *heartbeatInterval, shutdown)
+		}()
+	}
+	if server.Connects != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			server.Connects.Summarize(*connectLogSummaryInterval, shutdown)
+		}()
+	}
+	if *leakCheckInterval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			monitorLeaks(aggregates, *leakCheckInterval, *leakProfileDir, shutdown)
+		}()
+	}
+	if collectorListener != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cluster.NewCollector(clusterTLS).Serve(collectorListener, shutdown)
+		}()
+	}
+	for listener := range servers {
+		wg.Add(1)
+		go func(listener net.Listener) {
+			defer wg.Done()
+			servers[listener].Serve(listener, shutdown)
+		}(listener)
+	}
+	if *runSelfCheck {
+		go func() {
+			if !runSelfChecks(selfCheckAddress, sockets.ProxyProtocol, cfg.Auth.Methods, counter) {
+				log.Exit(1)
+			}
+			log.Exit(0)
+		}()
+	}
+	wg.Wait()
+	server.Drain(*drainTimeout)
 }
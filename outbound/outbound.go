@@ -0,0 +1,168 @@
+// Package outbound makes the connections the server opens to other hosts,
+// such as log sinks, trace collectors and sample storage, refusing those to
+// hosts outside an allowlist.
+package outbound
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Allowlist are the networks and hostnames connections may be opened to. It
+// implements flag.Value, setting a comma-separated list of CIDRs, IPs and
+// hostnames. Hostnames starting with *. match their subdomains.
+type Allowlist struct {
+	networks  []*net.IPNet
+	hostnames []string
+}
+
+func (allowlist *Allowlist) String() string {
+	if allowlist == nil {
+		return ""
+	}
+	entries := []string{}
+	for _, network := range allowlist.networks {
+		entries = append(entries, network.String())
+	}
+	return strings.Join(append(entries, allowlist.hostnames...), ",")
+}
+
+// Set implements flag.Value.
+func (allowlist *Allowlist) Set(text string) error {
+	parsed := Allowlist{}
+	for _, entry := range strings.Split(text, ",") {
+		entry = strings.TrimSpace(entry)
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			parsed.networks = append(parsed.networks, network)
+			continue
+		}
+		if ip := net.ParseIP(entry); ip != nil {
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			parsed.networks = append(parsed.networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		hostname := strings.ToLower(strings.TrimPrefix(entry, "*."))
+		if hostname == "" || strings.ContainsAny(hostname, "/:*") {
+			return fmt.Errorf("invalid outbound allowlist entry %q, must be a CIDR, IP or hostname", entry)
+		}
+		parsed.hostnames = append(parsed.hostnames, strings.ToLower(entry))
+	}
+	*allowlist = parsed
+	return nil
+}
+
+// Empty reports whether nothing is allowed.
+func (allowlist *Allowlist) Empty() bool {
+	return len(allowlist.networks) == 0 && len(allowlist.hostnames) == 0
+}
+
+// allowsHostname reports whether hostname is allowed by name.
+func (allowlist *Allowlist) allowsHostname(hostname string) bool {
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+	for _, allowed := range allowlist.hostnames {
+		if hostname == allowed || strings.HasPrefix(allowed, "*.") && strings.HasSuffix(hostname, allowed[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsIP reports whether ip is in one of the allowed networks.
+func (allowlist *Allowlist) allowsIP(ip net.IP) bool {
+	for _, network := range allowlist.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	mu sync.RWMutex
+	// allowlist, if set, restricts every connection.
+	allowlist *Allowlist
+)
+
+// Restrict only allows connections to hosts of allowed from now on.
+func Restrict(allowed Allowlist) {
+	mu.Lock()
+	defer mu.Unlock()
+	allowlist = &allowed
+}
+
+func restriction() *Allowlist {
+	mu.RLock()
+	defer mu.RUnlock()
+	return allowlist
+}
+
+// DialContext connects to address like net.Dialer does, unless connections
+// are restricted and its host isn't allowed. Hosts allowed by IP are only
+// connected to on the addresses they resolve to that are allowed, so that
+// their resolution can't be made to point elsewhere.
+func DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := &net.Dialer{}
+	allowlist := restriction()
+	if allowlist == nil {
+		return dialer.DialContext(ctx, network, address)
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) == nil && allowlist.allowsHostname(host) {
+		return dialer.DialContext(ctx, network, address)
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var lastErr error
+	for _, ip := range ips {
+		if !allowlist.allowsIP(ip.IP) {
+			continue
+		}
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.IP.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	// Refusals aren't logged here: sinks dial from their own goroutines, and
+	// an event would go back to the sink being refused.
+	return nil, fmt.Errorf("outbound connection to %v is not allowed", address)
+}
+
+// Dial connects to address like DialContext, giving up after timeout.
+func Dial(network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return DialContext(ctx, network, address)
+}
+
+// HTTPClient returns a client whose requests time out after timeout and
+// connect with DialContext. Proxies set in the environment are only used if
+// connections aren't restricted, as the hosts behind them couldn't be checked.
+func HTTPClient(timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = DialContext
+	transport.Proxy = func(request *http.Request) (*url.URL, error) {
+		if restriction() != nil {
+			return nil, nil
+		}
+		return http.ProxyFromEnvironment(request)
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
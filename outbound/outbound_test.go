@@ -0,0 +1,114 @@
+package outbound
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// restrict only allows connections to the hosts of text for the rest of the
+// test.
+func restrict(t *testing.T, text string) {
+	t.Helper()
+	var allowed Allowlist
+	if err := allowed.Set(text); err != nil {
+		t.Fatal(err)
+	}
+	Restrict(allowed)
+	t.Cleanup(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		allowlist = nil
+	})
+}
+
+func TestAllowlistSet(t *testing.T) {
+	var allowed Allowlist
+	if err := allowed.Set("192.0.2.0/24, 2001:db8::1,Loki.example.com,*.s3.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if text := allowed.String(); text != "192.0.2.0/24,2001:db8::1/128,loki.example.com,*.s3.example.com" {
+		t.Errorf("String = %q", text)
+	}
+	for _, test := range []struct {
+		host    string
+		allowed bool
+	}{
+		{"192.0.2.7", true},
+		{"198.51.100.1", false},
+		{"2001:db8::1", true},
+		{"2001:db8::2", false},
+		{"loki.example.com", true},
+		{"LOKI.example.com.", true},
+		{"evil-loki.example.com", false},
+		{"bucket.s3.example.com", true},
+		{"s3.example.com.evil.test", false},
+	} {
+		allows := allowed.allowsHostname(test.host)
+		if ip := net.ParseIP(test.host); ip != nil {
+			allows = allowed.allowsIP(ip)
+		}
+		if allows != test.allowed {
+			t.Errorf("%v allowed %v, want %v", test.host, allows, test.allowed)
+		}
+	}
+	for _, text := range []string{"", "192.0.2.0/33", "http://example.com", "example.com:443", "*"} {
+		if err := allowed.Set(text); err == nil {
+			t.Errorf("Set(%q) succeeded", text)
+		}
+	}
+}
+
+func TestDialRestricted(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	for _, test := range []struct {
+		allowlist string
+		address   string
+		allowed   bool
+	}{
+		{"127.0.0.1", "127.0.0.1:" + port, true},
+		{"127.0.0.0/8", "localhost:" + port, true},
+		{"localhost", "localhost:" + port, true},
+		{"192.0.2.0/24", "127.0.0.1:" + port, false},
+		// A name resolving to an address that isn't allowed is refused.
+		{"192.0.2.0/24", "localhost:" + port, false},
+	} {
+		restrict(t, test.allowlist)
+		conn, err := Dial("tcp", test.address, time.Second)
+		if err == nil {
+			conn.Close()
+		}
+		if (err == nil) != test.allowed {
+			t.Errorf("allowing %v, dial %v = %v, want allowed %v", test.allowlist, test.address, err, test.allowed)
+		}
+		if !test.allowed && (err == nil || !strings.Contains(err.Error(), "is not allowed")) {
+			t.Errorf("allowing %v, dial %v = %v, want it refused", test.allowlist, test.address, err)
+		}
+	}
+}
+
+func TestHTTPClientRestricted(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {}))
+	defer server.Close()
+	// Unrestricted until Restrict is called.
+	if response, err := HTTPClient(time.Second).Get(server.URL); err != nil {
+		t.Errorf("unrestricted GET = %v", err)
+	} else {
+		response.Body.Close()
+	}
+	restrict(t, "127.0.0.1")
+	if response, err := HTTPClient(time.Second).Get(server.URL); err != nil {
+		t.Errorf("allowed GET = %v", err)
+	} else {
+		response.Body.Close()
+	}
+	restrict(t, "192.0.2.1")
+	if _, err := HTTPClient(time.Second).Get(server.URL); err == nil || !strings.Contains(err.Error(), "is not allowed") {
+		t.Errorf("GET outside the allowlist = %v, want it refused", err)
+	}
+}
@@ -0,0 +1,216 @@
+package output
+
+import (
+	"fmt"
+	"github.com/longkeyy/sshesame/outbound"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	abuseIPDBTimeout = 10 * time.Second
+	// abuseIPDBMinInterval is how long AbuseIPDB refuses reports of an
+	// address after the last one.
+	abuseIPDBMinInterval = 15 * time.Minute
+	// maxReportedUsers bounds the users a report lists.
+	maxReportedUsers = 5
+)
+
+// abuseIPDBURL is the endpoint of the AbuseIPDB API reports are submitted to.
+var abuseIPDBURL = "https://api.abuseipdb.com/api/v2/report"
+
+// AbuseReporting configures reporting clients that fail to authenticate too
+// often.
+type AbuseReporting struct {
+	// Threshold is the number of failed authentication attempts within
+	// Window after which a client is reported.
+	Threshold int
+	Window    time.Duration
+	// Interval is how long a reported client isn't reported again for.
+	Interval time.Duration
+	// AbuseIPDBKey, if set, is the API key of the AbuseIPDB account reports
+	// are submitted with, in Categories, at most DailyLimit in 24 hours.
+	AbuseIPDBKey string
+	Categories   string
+	DailyLimit   int
+	// BanFile, if set, is the file a line for every reported client is
+	// appended to, for a fail2ban jail to ban them.
+	BanFile string
+}
+
+// abuseClient is the recent failed authentication attempts of a client.
+type abuseClient struct {
+	failures []time.Time
+	users    map[string]bool
+}
+
+// AbuseSink reports the clients that fail to authenticate Threshold times
+// within Window to AbuseIPDB and appends them to a ban file, once every
+// Interval. Clients with private addresses aren't reported to AbuseIPDB,
+// which refuses them. Reports failing or exceeding the daily limit are only
+// logged.
+type AbuseSink struct {
+	reporting AbuseReporting
+	client    *http.Client
+	banFile   *os.File
+	hostname  string
+
+	clients   map[string]*abuseClient
+	reported  map[string]time.Time
+	submitted []time.Time
+	pruned    time.Time
+}
+
+// NewAbuseSink returns a sink reporting clients as configured.
+func NewAbuseSink(reporting AbuseReporting) (*AbuseSink, error) {
+	if reporting.Threshold <= 0 {
+		return nil, fmt.Errorf("the threshold %v isn't positive", reporting.Threshold)
+	}
+	if reporting.AbuseIPDBKey != "" && reporting.Interval < abuseIPDBMinInterval {
+		return nil, fmt.Errorf("AbuseIPDB refuses reports of an address for %v, longer than the interval %v", abuseIPDBMinInterval, reporting.Interval)
+	}
+	sink := &AbuseSink{
+		reporting: reporting,
+		client:    outbound.HTTPClient(abuseIPDBTimeout),
+		clients:   map[string]*abuseClient{},
+		reported:  map[string]time.Time{},
+	}
+	if reporting.BanFile != "" {
+		file, err := os.OpenFile(reporting.BanFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+		sink.banFile = file
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	sink.hostname = hostname
+	return sink, nil
+}
+
+// Emit implements Sink.
+func (sink *AbuseSink) Emit(event Event) error {
+	if !strings.HasSuffix(event.Message, " authentication rejected") && event.Message != "Authentication with disabled method rejected" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(fmt.Sprint(event.Fields["client"]))
+	if err != nil {
+		return err
+	}
+	sink.prune(event.Time)
+	client, ok := sink.clients[host]
+	if !ok {
+		client = &abuseClient{users: map[string]bool{}}
+		sink.clients[host] = client
+	}
+	client.failures = append(client.failures, event.Time)
+	for len(client.failures) != 0 && event.Time.Sub(client.failures[0]) > sink.reporting.Window {
+		client.failures = client.failures[1:]
+	}
+	if user, ok := event.Fields["user"]; ok && len(client.users) < maxReportedUsers {
+		client.users[fmt.Sprint(user)] = true
+	}
+	if len(client.failures) < sink.reporting.Threshold {
+		return nil
+	}
+	if last, ok := sink.reported[host]; ok && event.Time.Sub(last) < sink.reporting.Interval {
+		return nil
+	}
+	sink.reported[host] = event.Time
+	delete(sink.clients, host)
+	users := make([]string, 0, len(client.users))
+	for user := range client.users {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	failures := len(client.failures)
+	if sink.banFile != nil {
+		if _, err := fmt.Fprintf(sink.banFile, "%v %v sshesame[%v]: Ban %v after %v failed authentication attempts\n",
+			event.Time.Format("2006-01-02 15:04:05"), sink.hostname, os.Getpid(), host, failures); err != nil {
+			return err
+		}
+	}
+	if sink.reporting.AbuseIPDBKey == "" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return nil
+	}
+	for len(sink.submitted) != 0 && event.Time.Sub(sink.submitted[0]) > 24*time.Hour {
+		sink.submitted = sink.submitted[1:]
+	}
+	if sink.reporting.DailyLimit > 0 && len(sink.submitted) >= sink.reporting.DailyLimit {
+		return fmt.Errorf("not reporting %v to AbuseIPDB, the daily limit of %v reports is reached", host, sink.reporting.DailyLimit)
+	}
+	sink.submitted = append(sink.submitted, event.Time)
+	comment := fmt.Sprintf("SSH brute force: %v failed authentication attempts within %v", failures, sink.reporting.Window)
+	if len(users) != 0 {
+		comment += " as " + strings.Join(users, ", ")
+	}
+	form := url.Values{
+		"ip":         {host},
+		"categories": {sink.reporting.Categories},
+		"comment":    {comment},
+		"timestamp":  {event.Time.Format(time.RFC3339)},
+	}
+	return retryPush(func() (bool, error) {
+		return sink.submit(form)
+	})
+}
+
+// submit submits a report once, reporting whether a failure is worth
+// retrying.
+func (sink *AbuseSink) submit(form url.Values) (bool, error) {
+	request, err := http.NewRequest(http.MethodPost, abuseIPDBURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Key", sink.reporting.AbuseIPDBKey)
+	request.Header.Set("Accept", "application/json")
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, err := sink.client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	response.Body.Close()
+	if response.StatusCode/100 == 2 {
+		return false, nil
+	}
+	// AbuseIPDB also answers 429 for addresses reported too recently, which
+	// retrying doesn't help.
+	return response.StatusCode/100 == 5, fmt.Errorf("AbuseIPDB responded %v to the report of %v", response.Status, form.Get("ip"))
+}
+
+// prune forgets clients without recent failures and reports older than the
+// interval, at most once a window.
+func (sink *AbuseSink) prune(now time.Time) {
+	if now.Sub(sink.pruned) < sink.reporting.Window {
+		return
+	}
+	sink.pruned = now
+	for host, client := range sink.clients {
+		if len(client.failures) == 0 || now.Sub(client.failures[len(client.failures)-1]) > sink.reporting.Window {
+			delete(sink.clients, host)
+		}
+	}
+	for host, last := range sink.reported {
+		if now.Sub(last) >= sink.reporting.Interval {
+			delete(sink.reported, host)
+		}
+	}
+}
+
+// Close implements Sink.
+func (sink *AbuseSink) Close() error {
+	if sink.banFile != nil {
+		return sink.banFile.Close()
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package output
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAbuseSinkReports(t *testing.T) {
+	reports := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Method != http.MethodPost || r.Header.Get("Key") != "secret" || r.Header.Get("Content-Type") != "application/x-www-form-urlencoded" {
+			t.Errorf("%v with %v", r.Method, r.Header)
+		}
+		reports = append(reports, string(body))
+	}))
+	defer server.Close()
+	defer func(url string) { abuseIPDBURL = url }(abuseIPDBURL)
+	abuseIPDBURL = server.URL
+
+	banFile := filepath.Join(t.TempDir(), "banned.log")
+	sink, err := NewAbuseSink(AbuseReporting{Threshold: 3, Window: time.Minute, Interval: 15 * time.Minute, AbuseIPDBKey: "secret", Categories: "18,22", DailyLimit: 1, BanFile: banFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		offset  time.Duration
+		message string
+		client  string
+		user    string
+		invalid bool
+	}{
+		{0, "Password authentication rejected", "203.0.113.7:50000", "root", false},
+		{10 * time.Second, "Public key authentication rejected", "203.0.113.7:50001", "admin", false},
+		{15 * time.Second, "Password authentication accepted", "203.0.113.7:50002", "root", false},
+		{20 * time.Second, "Authentication with disabled method rejected", "203.0.113.7:50003", "root", false},
+		// Reported clients aren't reported again within the interval.
+		{30 * time.Second, "Password authentication rejected", "203.0.113.7:50004", "root", false},
+		{40 * time.Second, "Password authentication rejected", "203.0.113.7:50005", "root", false},
+		{50 * time.Second, "Password authentication rejected", "203.0.113.7:50006", "root", false},
+		// Private clients are only banned.
+		{time.Minute, "Password authentication rejected", "10.0.0.1:50000", "", false},
+		{time.Minute, "Password authentication rejected", "10.0.0.1:50001", "", false},
+		{time.Minute, "Password authentication rejected", "10.0.0.1:50002", "", false},
+		// Failures older than the window don't count.
+		{time.Minute + time.Second, "Password authentication rejected", "198.51.100.9:50000", "", false},
+		{2*time.Minute + 10*time.Second, "Password authentication rejected", "198.51.100.9:50001", "", false},
+		{2*time.Minute + 20*time.Second, "Password authentication rejected", "198.51.100.9:50002", "", false},
+		{2*time.Minute + 30*time.Second, "Password authentication rejected", "198.51.100.9:50003", "", true},
+	} {
+		fields := log.Fields{"client": test.client}
+		if test.user != "" {
+			fields["user"] = test.user
+		}
+		err := sink.Emit(Event{Time: start.Add(test.offset), Level: log.InfoLevel, Message: test.message, Fields: fields})
+		if test.invalid {
+			if err == nil || !strings.Contains(err.Error(), "the daily limit of 1 reports is reached") {
+				t.Errorf("report beyond the daily limit = %v", err)
+			}
+		} else if err != nil {
+			t.Errorf("%+v: %v", test, err)
+		}
+	}
+	sink.Close()
+
+	if want := "categories=18%2C22&comment=SSH+brute+force%3A+3+failed+authentication+attempts+within+1m0s+as+admin%2C+root&ip=203.0.113.7&timestamp=2026-10-14T12%3A00%3A20Z"; len(reports) != 1 || reports[0] != want {
+		t.Errorf("reported %q, want %q", reports, want)
+	}
+	hostname, _ := os.Hostname()
+	want := ""
+	for _, ban := range []struct{ time, host string }{
+		{"2026-10-14 12:00:20", "203.0.113.7"},
+		{"2026-10-14 12:01:00", "10.0.0.1"},
+		{"2026-10-14 12:02:30", "198.51.100.9"},
+	} {
+		want += fmt.Sprintf("%v %v sshesame[%v]: Ban %v after 3 failed authentication attempts\n", ban.time, hostname, os.Getpid(), ban.host)
+	}
+	if content, err := ioutil.ReadFile(banFile); err != nil || string(content) != want {
+		t.Errorf("banned %q, %v, want %q", content, err, want)
+	}
+}
+
+func TestNewAbuseSinkInvalid(t *testing.T) {
+	for _, reporting := range []AbuseReporting{
+		{Threshold: 0, Window: time.Minute},
+		// AbuseIPDB refuses reports of an address for 15 minutes.
+		{Threshold: 5, Window: time.Minute, Interval: time.Minute, AbuseIPDBKey: "secret"},
+		{Threshold: 5, Window: time.Minute, BanFile: filepath.Join(t.TempDir(), "missing", "banned.log")},
+	} {
+		if _, err := NewAbuseSink(reporting); err == nil {
+			t.Errorf("sink created with %+v", reporting)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package output
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"github.com/longkeyy/sshesame/outbound"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+const alertTimeout = 10 * time.Second
+
+// Alerting configures alerting on events as soon as they happen.
+type Alerting struct {
+	// Events are the messages, or category:<category> for those of a
+	// category, of the events alerted on.
+	Events []string
+	// WebhookURL, if set, is the URL every event alerted on is posted to as
+	// a JSON object.
+	WebhookURL string
+	// SMTPAddress, if set, is the host:port of the SMTP server every event
+	// alerted on is mailed through, from From to To, with STARTTLS if the
+	// server supports it and authenticating as SMTPUser if set.
+	SMTPAddress  string
+	SMTPUser     string
+	SMTPPassword string
+	From         string
+	To           []string
+}
+
+// AlertSink posts the events alerted on to a webhook and mails them as they
+// come, unbatched.
+type AlertSink struct {
+	alerting  Alerting
+	events    map[string]bool
+	formatter log.Formatter
+	client    *http.Client
+}
+
+// NewAlertSink returns a sink alerting on events as configured.
+func NewAlertSink(alerting Alerting, timestamps Timestamps) (*AlertSink, error) {
+	if alerting.SMTPAddress != "" {
+		if _, _, err := net.SplitHostPort(alerting.SMTPAddress); err != nil {
+			return nil, fmt.Errorf("invalid SMTP address %q: %v", alerting.SMTPAddress, err)
+		}
+		if alerting.From == "" || len(alerting.To) == 0 {
+			return nil, fmt.Errorf("alerting through %v needs a sender and recipients", alerting.SMTPAddress)
+		}
+	}
+	events := map[string]bool{}
+	for _, event := range alerting.Events {
+		events[event] = true
+	}
+	return &AlertSink{
+		alerting:  alerting,
+		events:    events,
+		formatter: timestamps.Formatter(true),
+		client:    outbound.HTTPClient(alertTimeout),
+	}, nil
+}
+
+// Emit implements Sink.
+func (sink *AlertSink) Emit(event Event) error {
+	if !sink.events[event.Message] && !sink.events["category:"+fmt.Sprint(event.Fields["category"])] {
+		return nil
+	}
+	line, err := sink.formatter.Format(&log.Entry{
+		Logger:  log.StandardLogger(),
+		Data:    event.Fields,
+		Time:    event.Time,
+		Level:   event.Level,
+		Message: event.Message,
+	})
+	if err != nil {
+		return err
+	}
+	var errs []string
+	if sink.alerting.WebhookURL != "" {
+		if err := retryPush(func() (bool, error) {
+			return sink.post(line)
+		}); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if sink.alerting.SMTPAddress != "" {
+		if err := sink.mail(event, line); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("failed to alert on %v: %v", event.Message, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// post posts an event once, reporting whether a failure is worth retrying.
+func (sink *AlertSink) post(line []byte) (bool, error) {
+	response, err := sink.client.Post(sink.alerting.WebhookURL, "application/json", bytes.NewReader(line))
+	if err != nil {
+		return true, err
+	}
+	response.Body.Close()
+	if response.StatusCode/100 == 2 {
+		return false, nil
+	}
+	retry := response.StatusCode == http.StatusTooManyRequests || response.StatusCode/100 == 5
+	return retry, fmt.Errorf("alert webhook responded %v", response.Status)
+}
+
+// mail mails an event, subjected with its message and client.
+func (sink *AlertSink) mail(event Event, line []byte) error {
+	host, _, _ := net.SplitHostPort(sink.alerting.SMTPAddress)
+	conn, err := outbound.Dial("tcp", sink.alerting.SMTPAddress, alertTimeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(alertTimeout))
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if sink.alerting.SMTPUser != "" {
+		if err := client.Auth(smtp.PlainAuth("", sink.alerting.SMTPUser, sink.alerting.SMTPPassword, host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(sink.alerting.From); err != nil {
+		return err
+	}
+	for _, to := range sink.alerting.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	subject := "sshesame alert: " + event.Message
+	if clientAddress, ok := event.Fields["client"]; ok {
+		subject += " from " + fmt.Sprint(clientAddress)
+	}
+	fmt.Fprintf(writer, "From: %v\r\nTo: %v\r\nSubject: %v\r\nDate: %v\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s",
+		sink.alerting.From, strings.Join(sink.alerting.To, ", "), strings.NewReplacer("\r", " ", "\n", " ").Replace(subject), event.Time.Format(time.RFC1123Z), line)
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// Close implements Sink.
+func (sink *AlertSink) Close() error {
+	return nil
+}
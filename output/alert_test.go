@@ -0,0 +1,145 @@
+package output
+
+import (
+	"bufio"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpServer accepts a single mail on listener, without extensions, and
+// returns the commands and message it received.
+func smtpServer(listener net.Listener) <-chan []string {
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			received <- nil
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		reader := bufio.NewReader(conn)
+		lines := []string{}
+		conn.Write([]byte("220 mail.example.com ESMTP\r\n"))
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				received <- lines
+				return
+			}
+			lines = append(lines, line)
+			switch command := strings.ToUpper(strings.Fields(line + " ")[0]); command {
+			case "DATA":
+				conn.Write([]byte("354 Go ahead\r\n"))
+				message := ""
+				for !strings.HasSuffix(message, "\r\n.\r\n") {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						received <- lines
+						return
+					}
+					message += line
+				}
+				lines = append(lines, message)
+				conn.Write([]byte("250 Queued\r\n"))
+			case "QUIT":
+				conn.Write([]byte("221 Bye\r\n"))
+				received <- lines
+				return
+			default:
+				conn.Write([]byte("250 OK\r\n"))
+			}
+		}
+	}()
+	return received
+}
+
+func TestAlertSink(t *testing.T) {
+	posted := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("posted %v", r.Header.Get("Content-Type"))
+		}
+		posted <- string(body)
+	}))
+	defer server.Close()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	mailed := smtpServer(listener)
+
+	sink, err := NewAlertSink(Alerting{
+		Events:      []string{"category:honeytoken_credential"},
+		WebhookURL:  server.URL,
+		SMTPAddress: listener.Addr().String(),
+		From:        "sshesame@example.com",
+		To:          []string{"soc@example.com", "oncall@example.com"},
+	}, Timestamps{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventTime := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	// Only events alerted on are posted and mailed.
+	if err := sink.Emit(Event{Time: eventTime, Level: log.InfoLevel, Message: "Password authentication rejected", Fields: log.Fields{"category": "credential_access"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Emit(Event{Time: eventTime, Level: log.WarnLevel, Message: "Honeytoken credential used", Fields: log.Fields{
+		"category": "honeytoken_credential",
+		"client":   "192.0.2.1:50000\r\nBcc: attacker@example.com",
+		"name":     "wiki",
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	line := `{"category":"honeytoken_credential","client":"192.0.2.1:50000\r\nBcc: attacker@example.com","level":"warning","msg":"Honeytoken credential used","name":"wiki","time":"2026-10-14T12:00:00Z"}` + "\n"
+	if body := <-posted; body != line {
+		t.Errorf("posted %q, want %q", body, line)
+	}
+	// The line breaks of the subject are replaced, so that clients can't add
+	// headers.
+	lines := <-mailed
+	want := []string{
+		"EHLO localhost\r\n",
+		"MAIL FROM:<sshesame@example.com>\r\n",
+		"RCPT TO:<soc@example.com>\r\n",
+		"RCPT TO:<oncall@example.com>\r\n",
+		"DATA\r\n",
+		"From: sshesame@example.com\r\n" +
+			"To: soc@example.com, oncall@example.com\r\n" +
+			"Subject: sshesame alert: Honeytoken credential used from 192.0.2.1:50000  Bcc: attacker@example.com\r\n" +
+			"Date: Wed, 14 Oct 2026 12:00:00 +0000\r\n" +
+			"Content-Type: text/plain; charset=utf-8\r\n" +
+			"\r\n" +
+			line[:len(line)-1] + "\r\n.\r\n",
+		"QUIT\r\n",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("received %q, want %q", lines, want)
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("received %q, want %q", lines[i], want[i])
+		}
+	}
+}
+
+func TestNewAlertSinkInvalid(t *testing.T) {
+	for _, alerting := range []Alerting{
+		{SMTPAddress: "mail.example.com", From: "sshesame@example.com", To: []string{"soc@example.com"}},
+		{SMTPAddress: "mail.example.com:25", To: []string{"soc@example.com"}},
+		{SMTPAddress: "mail.example.com:25", From: "sshesame@example.com"},
+	} {
+		if _, err := NewAlertSink(alerting, Timestamps{}); err == nil {
+			t.Errorf("sink created with %+v", alerting)
+		}
+	}
+}
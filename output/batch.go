@@ -0,0 +1,102 @@
+package output
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"sync"
+	"time"
+)
+
+// Batching configures writing events in batches rather than one at a time.
+type Batching struct {
+	// Size is the number of events written at once, events are written right
+	// away if it's 0 or 1.
+	Size int
+	// FlushInterval bounds how long events wait for their batch to fill up.
+	// 0 waits for the batch to be full.
+	FlushInterval time.Duration
+	// Gzip compresses every batch into a gzip member of its own, which
+	// concatenated form a valid gzip file.
+	Gzip bool
+}
+
+// batchWriter buffers what is written to it and writes it to writer in
+// batches, optionally compressed.
+type batchWriter struct {
+	writer   io.WriteCloser
+	batching Batching
+
+	mu     sync.Mutex
+	buffer bytes.Buffer
+	writes int
+	timer  *time.Timer
+	// err is the error of the last flush triggered by the timer, returned
+	// by the next write.
+	err error
+}
+
+func newBatchWriter(writer io.WriteCloser, batching Batching) *batchWriter {
+	return &batchWriter{writer: writer, batching: batching}
+}
+
+func (writer *batchWriter) Write(data []byte) (int, error) {
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	if err := writer.err; err != nil {
+		writer.err = nil
+		return 0, err
+	}
+	writer.buffer.Write(data)
+	writer.writes++
+	if writer.writes >= writer.batching.Size {
+		return len(data), writer.flush()
+	}
+	if writer.timer == nil && writer.batching.FlushInterval > 0 {
+		writer.timer = time.AfterFunc(writer.batching.FlushInterval, func() {
+			writer.mu.Lock()
+			defer writer.mu.Unlock()
+			writer.timer = nil
+			writer.err = writer.flush()
+		})
+	}
+	return len(data), nil
+}
+
+// flush writes the buffered batch. writer.mu must be held.
+func (writer *batchWriter) flush() error {
+	if writer.timer != nil {
+		writer.timer.Stop()
+		writer.timer = nil
+	}
+	if writer.buffer.Len() == 0 {
+		return nil
+	}
+	batch := writer.buffer.Bytes()
+	if writer.batching.Gzip {
+		var compressed bytes.Buffer
+		gzipWriter := gzip.NewWriter(&compressed)
+		if _, err := gzipWriter.Write(batch); err != nil {
+			return err
+		}
+		if err := gzipWriter.Close(); err != nil {
+			return err
+		}
+		batch = compressed.Bytes()
+	}
+	writer.buffer.Reset()
+	writer.writes = 0
+	_, err := writer.writer.Write(batch)
+	return err
+}
+
+// Close flushes the partial batch and closes the underlying writer.
+func (writer *batchWriter) Close() error {
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	err := writer.flush()
+	if closeErr := writer.writer.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,146 @@
+package output
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writesRecorder records what is written to it, write by write.
+type writesRecorder struct {
+	mu     sync.Mutex
+	writes []string
+	closed bool
+}
+
+func (recorder *writesRecorder) Write(data []byte) (int, error) {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	recorder.writes = append(recorder.writes, string(data))
+	return len(data), nil
+}
+
+func (recorder *writesRecorder) Close() error {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	recorder.closed = true
+	return nil
+}
+
+func (recorder *writesRecorder) written() []string {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	return append([]string(nil), recorder.writes...)
+}
+
+func TestBatchSize(t *testing.T) {
+	recorder := &writesRecorder{}
+	writer := newBatchWriter(recorder, Batching{Size: 3})
+	for i := 1; i <= 7; i++ {
+		fmt.Fprintf(writer, "%v\n", i)
+	}
+	if writes := recorder.written(); len(writes) != 2 || writes[0] != "1\n2\n3\n" || writes[1] != "4\n5\n6\n" {
+		t.Errorf("wrote %q, want full batches of 3 lines", writes)
+	}
+	// The partial batch is flushed on close.
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if writes := recorder.written(); len(writes) != 3 || writes[2] != "7\n" || !recorder.closed {
+		t.Errorf("wrote %q, closed %v, want the last line flushed then the writer closed", writes, recorder.closed)
+	}
+}
+
+func TestBatchFlushInterval(t *testing.T) {
+	recorder := &writesRecorder{}
+	writer := newBatchWriter(recorder, Batching{Size: 100, FlushInterval: 50 * time.Millisecond})
+	defer writer.Close()
+	writer.Write([]byte("1\n"))
+	writer.Write([]byte("2\n"))
+	if writes := recorder.written(); len(writes) != 0 {
+		t.Errorf("wrote %q right away, want the batch to wait", writes)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(recorder.written()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if writes := recorder.written(); len(writes) != 1 || writes[0] != "1\n2\n" {
+		t.Errorf("wrote %q after the flush interval, want the partial batch", writes)
+	}
+}
+
+func TestBatchGzip(t *testing.T) {
+	recorder := &writesRecorder{}
+	writer := newBatchWriter(recorder, Batching{Size: 2, Gzip: true})
+	for i := 1; i <= 3; i++ {
+		fmt.Fprintf(writer, "%v\n", i)
+	}
+	writer.Close()
+	writes := recorder.written()
+	if len(writes) != 2 {
+		t.Fatalf("wrote %v members, want 2", len(writes))
+	}
+	// Every batch is a gzip member of its own, and they decompress together.
+	for i, want := range []string{"1\n2\n", "3\n"} {
+		if got := gunzip(t, []byte(writes[i])); got != want {
+			t.Errorf("member %v = %q, want %q", i, got, want)
+		}
+	}
+	if got := gunzip(t, []byte(strings.Join(writes, ""))); got != "1\n2\n3\n" {
+		t.Errorf("members decompressed to %q", got)
+	}
+}
+
+// gunzip returns the decompressed data.
+func gunzip(t *testing.T, data []byte) string {
+	t.Helper()
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decompressed, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(decompressed)
+}
+
+func TestFileSinkGzip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sshesame.log.gz")
+	sink, err := NewFileSink(path, &log.JSONFormatter{}, Batching{Size: 10, Gzip: true}, Chaining{}, Rotation{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, message := range []string{"Client connected", "Command executed", "Client disconnected"} {
+		if err := sink.Emit(Event{Time: time.Now(), Level: log.InfoLevel, Message: message, Fields: log.Fields{}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(gunzip(t, data), "\n"), "\n")
+	messages := []string{}
+	for _, line := range lines {
+		var event map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("wrote %q: %v", line, err)
+		}
+		messages = append(messages, event["msg"].(string))
+	}
+	if strings.Join(messages, ",") != "Client connected,Command executed,Client disconnected" {
+		t.Errorf("wrote %q, want the events in order", messages)
+	}
+}
@@ -0,0 +1,73 @@
+package output
+
+import (
+	log "github.com/sirupsen/logrus"
+	"sync/atomic"
+	"time"
+)
+
+// BreakerSink wraps a sink that can fail for a while, like one writing to a
+// remote service. After a number of consecutive failures it opens, dropping
+// events without trying the sink for a cooldown, then lets a single event
+// through to test whether the sink recovered.
+type BreakerSink struct {
+	name     string
+	sink     Sink
+	failures int
+	cooldown time.Duration
+
+	consecutive int
+	openUntil   time.Time
+	open        bool
+	dropped     uint64
+}
+
+// NewBreakerSink returns a sink emitting to sink until it fails failures times
+// in a row, then dropping events for cooldown. name identifies the sink in
+// the logs.
+func NewBreakerSink(name string, sink Sink, failures int, cooldown time.Duration) *BreakerSink {
+	return &BreakerSink{name: name, sink: sink, failures: failures, cooldown: cooldown}
+}
+
+// Emit implements Sink.
+func (breaker *BreakerSink) Emit(event Event) error {
+	if breaker.open && time.Now().Before(breaker.openUntil) {
+		atomic.AddUint64(&breaker.dropped, 1)
+		return nil
+	}
+	err := breaker.sink.Emit(event)
+	if err == nil {
+		if breaker.open {
+			breaker.open = false
+			errorLog.WithFields(log.Fields{
+				"sink":    breaker.name,
+				"dropped": atomic.LoadUint64(&breaker.dropped),
+			}).Warning("Sink recovered, circuit breaker closed")
+		}
+		breaker.consecutive = 0
+		return nil
+	}
+	breaker.consecutive++
+	if breaker.open || breaker.consecutive >= breaker.failures {
+		if !breaker.open {
+			errorLog.WithFields(log.Fields{
+				"sink":     breaker.name,
+				"failures": breaker.consecutive,
+				"cooldown": breaker.cooldown.String(),
+			}).Warning("Sink failing, circuit breaker opened")
+		}
+		breaker.open = true
+		breaker.openUntil = time.Now().Add(breaker.cooldown)
+	}
+	return err
+}
+
+// Dropped returns the number of events dropped while the breaker was open.
+func (breaker *BreakerSink) Dropped() uint64 {
+	return atomic.LoadUint64(&breaker.dropped)
+}
+
+// Close implements Sink.
+func (breaker *BreakerSink) Close() error {
+	return breaker.sink.Close()
+}
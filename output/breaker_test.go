@@ -0,0 +1,99 @@
+package output
+
+import (
+	"errors"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"testing"
+	"time"
+)
+
+// flakySink fails with err, if any, counting the events it's given.
+type flakySink struct {
+	err      error
+	attempts int
+}
+
+func (sink *flakySink) Emit(event Event) error {
+	sink.attempts++
+	return sink.err
+}
+
+func (sink *flakySink) Close() error {
+	return nil
+}
+
+// captureErrors records the errors sinks report for the rest of the test
+// instead of printing them.
+func captureErrors(t *testing.T) *logtest.Hook {
+	quietErrors(t)
+	hook := logtest.NewLocal(errorLog)
+	t.Cleanup(func() { errorLog.ReplaceHooks(make(log.LevelHooks)) })
+	return hook
+}
+
+func TestBreakerOpensAndRecovers(t *testing.T) {
+	hook := captureErrors(t)
+	sink := &flakySink{err: errors.New("connection refused")}
+	breaker := NewBreakerSink("webhook", sink, 3, 50*time.Millisecond)
+	event := Event{Time: time.Now(), Level: log.InfoLevel, Message: "Client connected"}
+	for i := 0; i < 3; i++ {
+		if err := breaker.Emit(event); err != sink.err {
+			t.Errorf("failure %v = %v, want the sink's error", i+1, err)
+		}
+	}
+	entry := hook.LastEntry()
+	if entry == nil || entry.Message != "Sink failing, circuit breaker opened" || entry.Data["sink"] != "webhook" || entry.Data["failures"] != 3 {
+		t.Fatalf("logged %v after 3 failures, want the breaker opened", entry)
+	}
+	for i := 0; i < 5; i++ {
+		if err := breaker.Emit(event); err != nil {
+			t.Errorf("emit while open = %v, want the event dropped", err)
+		}
+	}
+	if sink.attempts != 3 || breaker.Dropped() != 5 {
+		t.Errorf("sink tried %v times with %v dropped while open, want 3 and 5", sink.attempts, breaker.Dropped())
+	}
+
+	// Once the cooldown is over, a single failing event opens it again.
+	time.Sleep(60 * time.Millisecond)
+	hook.Reset()
+	if err := breaker.Emit(event); err != sink.err {
+		t.Errorf("emit after the cooldown = %v, want the sink tried", err)
+	}
+	if err := breaker.Emit(event); err != nil || sink.attempts != 4 {
+		t.Errorf("emit after failing again = %v with %v attempts, want the event dropped", err, sink.attempts)
+	}
+	if len(hook.AllEntries()) != 0 {
+		t.Errorf("logged %v reopening the breaker, want it logged once", hook.LastEntry().Message)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	sink.err = nil
+	if err := breaker.Emit(event); err != nil || sink.attempts != 5 {
+		t.Errorf("emit after recovering = %v with %v attempts, want the event emitted", err, sink.attempts)
+	}
+	entry = hook.LastEntry()
+	if entry == nil || entry.Message != "Sink recovered, circuit breaker closed" || entry.Data["dropped"] != uint64(6) {
+		t.Errorf("logged %v after recovering, want the breaker closed with 6 dropped", entry)
+	}
+	breaker.Emit(event)
+	if sink.attempts != 6 {
+		t.Errorf("sink tried %v times, want every event once closed", sink.attempts)
+	}
+}
+
+func TestBreakerResetsOnSuccess(t *testing.T) {
+	hook := captureErrors(t)
+	sink := &flakySink{}
+	breaker := NewBreakerSink("file", sink, 2, time.Minute)
+	for i := 0; i < 5; i++ {
+		sink.err = errors.New("disk full")
+		breaker.Emit(Event{})
+		sink.err = nil
+		breaker.Emit(Event{})
+	}
+	if sink.attempts != 10 || breaker.Dropped() != 0 || len(hook.AllEntries()) != 0 {
+		t.Errorf("intermittent failures opened the breaker, want it open after 2 in a row")
+	}
+}
@@ -0,0 +1,83 @@
+package output
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Policy is what happens to events for a sink whose buffer is full.
+type Policy string
+
+const (
+	// Block waits for the sink to catch up, delaying whoever logged the event,
+	// such as a session handler, but losing nothing.
+	Block Policy = "block"
+	// DropNewest drops the event that doesn't fit.
+	DropNewest Policy = "drop_newest"
+	// DropOldest drops the oldest buffered event to make room for the new one.
+	DropOldest Policy = "drop_oldest"
+)
+
+// Buffering is how events are buffered for a sink.
+type Buffering struct {
+	Policy Policy
+	// Size is the most events buffered, the default size if 0.
+	Size int
+}
+
+func (buffering Buffering) String() string {
+	if buffering.Size == 0 {
+		return string(buffering.Policy)
+	}
+	return fmt.Sprintf("%v:%v", buffering.Policy, buffering.Size)
+}
+
+// Bufferings maps sink names to their buffering, overriding the default of
+// dropping the newest events. It implements flag.Value, setting
+// <sink>=<policy>[:<size>] pairs.
+type Bufferings map[string]Buffering
+
+func (bufferings *Bufferings) String() string {
+	if bufferings == nil {
+		return ""
+	}
+	pairs := []string{}
+	for name, buffering := range *bufferings {
+		pairs = append(pairs, name+"="+buffering.String())
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Set implements flag.Value.
+func (bufferings *Bufferings) Set(text string) error {
+	separator := strings.Index(text, "=")
+	if separator == -1 {
+		return fmt.Errorf("invalid sink buffering %q, must be <sink>=<policy>[:<size>]", text)
+	}
+	name, value := text[:separator], text[separator+1:]
+	parts := strings.SplitN(value, ":", 2)
+	buffering := Buffering{Policy: Policy(parts[0])}
+	switch buffering.Policy {
+	case Block, DropNewest, DropOldest:
+	default:
+		return fmt.Errorf("unknown buffering policy %q, must be block, drop_newest or drop_oldest", parts[0])
+	}
+	if len(parts) == 2 {
+		size, err := strconv.Atoi(parts[1])
+		if err != nil || size <= 0 {
+			return fmt.Errorf("invalid buffer size %q, must be a positive number", parts[1])
+		}
+		buffering.Size = size
+	}
+	if _, ok := (*bufferings)[name]; ok {
+		return fmt.Errorf("duplicate buffering for sink %q", name)
+	}
+	if *bufferings == nil {
+		*bufferings = Bufferings{}
+	}
+	(*bufferings)[name] = buffering
+	return nil
+}
@@ -0,0 +1,89 @@
+package output
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// gatedSink records events, but holds the first one until released, telling
+// when it's received it.
+type gatedSink struct {
+	recordingSink
+	started, release chan struct{}
+}
+
+func (sink *gatedSink) Emit(event Event) error {
+	if sink.started != nil {
+		close(sink.started)
+		sink.started = nil
+		<-sink.release
+	}
+	return sink.recordingSink.Emit(event)
+}
+
+func TestBufferingPolicies(t *testing.T) {
+	for _, test := range []struct {
+		policy   Policy
+		received []string
+		dropped  uint64
+	}{
+		{DropNewest, []string{"1", "2", "3"}, 2},
+		{DropOldest, []string{"1", "4", "5"}, 2},
+		{Block, []string{"1", "2", "3", "4", "5"}, 0},
+	} {
+		sink := &gatedSink{started: make(chan struct{}), release: make(chan struct{})}
+		started := sink.started
+		dispatcher := &Dispatcher{Buffering: Bufferings{"sink": {Policy: test.policy, Size: 2}}}
+		dispatcher.Add("sink", sink, 16)
+		// The sink is busy with the first event, so the others fill its
+		// buffer of 2 and overflow it.
+		dispatcher.Emit(Event{Message: "1"})
+		<-started
+		emitted := make(chan struct{})
+		go func() {
+			for i := 2; i <= 5; i++ {
+				dispatcher.Emit(Event{Message: fmt.Sprint(i)})
+			}
+			close(emitted)
+		}()
+		select {
+		case <-emitted:
+			if test.policy == Block {
+				t.Errorf("%v: emitting didn't wait for the sink", test.policy)
+			}
+		case <-time.After(100 * time.Millisecond):
+			if test.policy != Block {
+				t.Fatalf("%v: emitting blocked on a busy sink", test.policy)
+			}
+		}
+		close(sink.release)
+		<-emitted
+		dispatcher.Close()
+		if received := sink.messages(); !reflect.DeepEqual(received, test.received) {
+			t.Errorf("%v: sink received %q, want %q", test.policy, received, test.received)
+		}
+		if dropped := dispatcher.Dropped()["sink"]; dropped != test.dropped {
+			t.Errorf("%v: dropped %v events, want %v", test.policy, dropped, test.dropped)
+		}
+	}
+}
+
+func TestBufferingsSet(t *testing.T) {
+	var bufferings Bufferings
+	for _, text := range []string{"file=block", "webhook=drop_oldest:100"} {
+		if err := bufferings.Set(text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := Bufferings{"file": {Policy: Block}, "webhook": {Policy: DropOldest, Size: 100}}
+	if !reflect.DeepEqual(bufferings, want) {
+		t.Errorf("bufferings = %v, want %v", bufferings, want)
+	}
+	for _, text := range []string{"file", "file=drop_all", "file=block:0", "file=block:x", "file=drop_newest"} {
+		if err := bufferings.Set(text); err == nil {
+			t.Errorf("Set(%q) succeeded", text)
+		}
+	}
+}
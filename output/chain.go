@@ -0,0 +1,316 @@
+package output
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// chainField is the field holding the chain value of a line, always the last
+// one of the line.
+const chainField = `,"chain":"`
+
+// Chaining configures a hash chain over the lines of a log file, so that
+// altering, removing or reordering lines can be detected. Every line ends
+// with a chain field, the HMAC-SHA256 keyed with Key of the previous line's
+// chain value followed by the line without the field. Checkpoints of the
+// number of lines and the latest chain value are signed and appended to the
+// file's path with .checkpoints added, so that the file being cut short can
+// be detected too.
+type Chaining struct {
+	// Key keys the chain, which is disabled if it's empty.
+	Key []byte
+	// CheckpointInterval is how often a checkpoint is written if lines were
+	// added since the last one, which is also written when the file is
+	// closed. 0 only writes the last one.
+	CheckpointInterval time.Duration
+}
+
+// checkpoint is a line of the checkpoints of a chained log file.
+type checkpoint struct {
+	Time      string `json:"time"`
+	Lines     int    `json:"lines"`
+	Chain     string `json:"chain"`
+	Signature string `json:"signature"`
+}
+
+// chainWriter adds the chain field to every line written to it, which must be
+// a JSON object and written at once, before writing it to writer.
+type chainWriter struct {
+	writer      io.WriteCloser
+	key         []byte
+	checkpoints *os.File
+
+	mu    sync.Mutex
+	chain []byte
+	lines int
+	// checkpointed is the number of lines at the last checkpoint.
+	checkpointed int
+	stop         chan struct{}
+}
+
+// newChainWriter returns a writer continuing the chain of the log file at
+// path, writing to writer.
+func newChainWriter(writer io.WriteCloser, path string, chaining Chaining) (*chainWriter, error) {
+	lines, chain, err := readChain(path)
+	if err != nil {
+		return nil, err
+	}
+	checkpoints, err := os.OpenFile(path+".checkpoints", os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	chainWriter := &chainWriter{
+		writer:       writer,
+		key:          chaining.Key,
+		checkpoints:  checkpoints,
+		chain:        chain,
+		lines:        lines,
+		checkpointed: lines,
+		stop:         make(chan struct{}),
+	}
+	if chaining.CheckpointInterval > 0 {
+		go chainWriter.checkpointEvery(chaining.CheckpointInterval)
+	}
+	return chainWriter, nil
+}
+
+func (writer *chainWriter) Write(data []byte) (int, error) {
+	line := bytes.TrimSuffix(data, []byte("\n"))
+	if !bytes.HasPrefix(line, []byte("{")) || !bytes.HasSuffix(line, []byte("}")) || len(line) < 3 {
+		return 0, errors.New("only JSON objects can be chained")
+	}
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	chain := chainValue(writer.key, writer.chain, line)
+	chained := make([]byte, 0, len(line)+len(chainField)+sha256.Size*2+3)
+	chained = append(chained, line[:len(line)-1]...)
+	chained = append(chained, chainField...)
+	chained = append(chained, hex.EncodeToString(chain)...)
+	chained = append(chained, "\"}\n"...)
+	if _, err := writer.writer.Write(chained); err != nil {
+		return 0, err
+	}
+	writer.chain = chain
+	writer.lines++
+	return len(data), nil
+}
+
+// checkpointEvery writes a checkpoint every interval until the writer is
+// closed.
+func (writer *chainWriter) checkpointEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-writer.stop:
+			return
+		}
+		writer.mu.Lock()
+		if err := writer.checkpoint(); err != nil {
+			errorLog.Warning("Failed to write log checkpoint:", err.Error())
+		}
+		writer.mu.Unlock()
+	}
+}
+
+// checkpoint writes a checkpoint if lines were added since the last one.
+// writer.mu must be held.
+func (writer *chainWriter) checkpoint() error {
+	if writer.lines == writer.checkpointed {
+		return nil
+	}
+	chain := hex.EncodeToString(writer.chain)
+	line, err := json.Marshal(checkpoint{
+		Time:      time.Now().UTC().Format(time.RFC3339),
+		Lines:     writer.lines,
+		Chain:     chain,
+		Signature: hex.EncodeToString(checkpointSignature(writer.key, writer.lines, chain)),
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := writer.checkpoints.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	writer.checkpointed = writer.lines
+	return nil
+}
+
+// Close writes the last checkpoint and closes the underlying writer.
+func (writer *chainWriter) Close() error {
+	close(writer.stop)
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	err := writer.writer.Close()
+	if checkpointErr := writer.checkpoint(); err == nil {
+		err = checkpointErr
+	}
+	if closeErr := writer.checkpoints.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// chainValue returns the chain value of line following the chain value
+// previous.
+func chainValue(key, previous, line []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(previous)
+	mac.Write(line)
+	return mac.Sum(nil)
+}
+
+// checkpointSignature returns the signature of a checkpoint after lines lines
+// with the chain value chain.
+func checkpointSignature(key []byte, lines int, chain string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("checkpoint " + strconv.Itoa(lines) + " " + chain))
+	return mac.Sum(nil)
+}
+
+// splitChain returns a chained line without its chain field, and the chain
+// value the field holds.
+func splitChain(line []byte) ([]byte, []byte, bool) {
+	suffixLength := len(chainField) + sha256.Size*2 + 2
+	if len(line) < suffixLength+2 || !bytes.HasSuffix(line, []byte("\"}")) {
+		return nil, nil, false
+	}
+	start := len(line) - suffixLength
+	if !bytes.Equal(line[start:start+len(chainField)], []byte(chainField)) {
+		return nil, nil, false
+	}
+	chain, err := hex.DecodeString(string(line[start+len(chainField) : len(line)-2]))
+	if err != nil {
+		return nil, nil, false
+	}
+	return append(line[:start:start], '}'), chain, true
+}
+
+// openLog opens the log file at path for reading, decompressing it if it's
+// made of gzip members.
+func openLog(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(file)
+	if magic, err := reader.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{gzipReader, file}, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{reader, file}, nil
+}
+
+// scanLines calls visit with every line of the log file at path, numbered
+// from 1, until it returns an error.
+func scanLines(path string, visit func(number int, line []byte) error) error {
+	reader, err := openLog(path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, 64*1024*1024)
+	number := 0
+	for scanner.Scan() {
+		number++
+		if err := visit(number, scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// readChain returns the number of lines of the log file at path and the chain
+// value of its last line, for the chain to be continued. A missing file has
+// no lines.
+func readChain(path string) (int, []byte, error) {
+	lines := 0
+	var chain []byte
+	err := scanLines(path, func(number int, line []byte) error {
+		_, lineChain, ok := splitChain(line)
+		if !ok {
+			return fmt.Errorf("line %v of %v isn't chained", number, path)
+		}
+		lines, chain = number, lineChain
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return 0, nil, nil
+	}
+	return lines, chain, err
+}
+
+// VerifyChain verifies the chain of the log file at path with key, along with
+// its checkpoints if any were written. It returns the number of lines
+// verified and of checkpoints they matched, or an error describing the first
+// line or checkpoint that doesn't match.
+func VerifyChain(path string, key []byte) (int, int, error) {
+	chains := [][]byte{nil}
+	err := scanLines(path, func(number int, line []byte) error {
+		unchained, chain, ok := splitChain(line)
+		if !ok {
+			return fmt.Errorf("line %v isn't chained", number)
+		}
+		if !hmac.Equal(chain, chainValue(key, chains[number-1], unchained)) {
+			return fmt.Errorf("line %v doesn't match the chain, it or an earlier line was altered, removed or reordered", number)
+		}
+		chains = append(chains, chain)
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	lines := len(chains) - 1
+	checkpoints, err := os.Open(path + ".checkpoints")
+	if os.IsNotExist(err) {
+		return lines, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	defer checkpoints.Close()
+	scanner := bufio.NewScanner(checkpoints)
+	verified := 0
+	for number := 1; scanner.Scan(); number++ {
+		checkpoint := checkpoint{}
+		if err := json.Unmarshal(scanner.Bytes(), &checkpoint); err != nil {
+			return 0, 0, fmt.Errorf("checkpoint %v: %v", number, err)
+		}
+		signature, err := hex.DecodeString(checkpoint.Signature)
+		if err != nil || !hmac.Equal(signature, checkpointSignature(key, checkpoint.Lines, checkpoint.Chain)) {
+			return 0, 0, fmt.Errorf("checkpoint %v isn't signed with the key", number)
+		}
+		if checkpoint.Lines > lines {
+			return 0, 0, fmt.Errorf("checkpoint %v of %v is for %v lines but the file only has %v, it was cut short", number, checkpoint.Time, checkpoint.Lines, lines)
+		}
+		if checkpoint.Chain != hex.EncodeToString(chains[checkpoint.Lines]) {
+			return 0, 0, fmt.Errorf("checkpoint %v of %v doesn't match line %v", number, checkpoint.Time, checkpoint.Lines)
+		}
+		verified++
+	}
+	return lines, verified, scanner.Err()
+}
@@ -0,0 +1,168 @@
+package output
+
+import (
+	"bytes"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeChained appends an event per message to the chained log file at path
+// with key, as a session of the honeypot would.
+func writeChained(t *testing.T, path string, key []byte, batching Batching, messages ...string) {
+	t.Helper()
+	sink, err := NewFileSink(path, &log.JSONFormatter{}, batching, Chaining{Key: key}, Rotation{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, message := range messages {
+		if err := sink.Emit(Event{Time: time.Now(), Level: log.InfoLevel, Message: message, Fields: log.Fields{"client": "192.0.2.1:50000"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChainVerified(t *testing.T) {
+	key := []byte("secret")
+	for name, batching := range map[string]Batching{
+		"plain": {},
+		"gzip":  {Size: 2, Gzip: true},
+	} {
+		path := filepath.Join(t.TempDir(), "sshesame.log")
+		writeChained(t, path, key, batching, "Client connected", "Command executed", "Client disconnected")
+		if lines, checkpoints, err := VerifyChain(path, key); err != nil || lines != 3 || checkpoints != 1 {
+			t.Errorf("%v: VerifyChain = %v, %v, %v, want 3 lines and 1 checkpoint", name, lines, checkpoints, err)
+		}
+		// A restarted honeypot continues the chain.
+		writeChained(t, path, key, batching, "Client connected")
+		if lines, checkpoints, err := VerifyChain(path, key); err != nil || lines != 4 || checkpoints != 2 {
+			t.Errorf("%v: VerifyChain after a restart = %v, %v, %v, want 4 lines and 2 checkpoints", name, lines, checkpoints, err)
+		}
+		if _, _, err := VerifyChain(path, []byte("guessed")); err == nil {
+			t.Errorf("%v: verified with the wrong key", name)
+		}
+	}
+}
+
+func TestChainTampering(t *testing.T) {
+	key := []byte("secret")
+	for name, test := range map[string]struct {
+		tamper func(lines []string) []string
+		want   string
+	}{
+		"altered": {
+			func(lines []string) []string {
+				lines[1] = strings.Replace(lines[1], "192.0.2.1", "192.0.2.2", 1)
+				return lines
+			},
+			"line 2 doesn't match the chain",
+		},
+		"removed": {
+			func(lines []string) []string { return append(lines[:1], lines[2:]...) },
+			"line 2 doesn't match the chain",
+		},
+		"reordered": {
+			func(lines []string) []string {
+				lines[0], lines[1] = lines[1], lines[0]
+				return lines
+			},
+			"line 1 doesn't match the chain",
+		},
+		"cut short": {
+			func(lines []string) []string { return lines[:2] },
+			"is for 3 lines but the file only has 2, it was cut short",
+		},
+		"unchained": {
+			func(lines []string) []string { return append(lines, `{"msg":"Client connected"}`) },
+			"line 4 isn't chained",
+		},
+	} {
+		path := filepath.Join(t.TempDir(), "sshesame.log")
+		writeChained(t, path, key, Batching{}, "Client connected", "Command executed", "Client disconnected")
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := test.tamper(strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"))
+		if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := VerifyChain(path, key); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%v: VerifyChain = %v, want %q", name, err, test.want)
+		}
+	}
+}
+
+func TestChainForgedCheckpoint(t *testing.T) {
+	key := []byte("secret")
+	path := filepath.Join(t.TempDir(), "sshesame.log")
+	writeChained(t, path, key, Batching{}, "Client connected")
+	checkpoints, err := ioutil.ReadFile(path + ".checkpoints")
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := bytes.Replace(checkpoints, []byte(`"lines":1`), []byte(`"lines":0`), 1)
+	if err := ioutil.WriteFile(path+".checkpoints", forged, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := VerifyChain(path, key); err == nil || !strings.Contains(err.Error(), "isn't signed with the key") {
+		t.Errorf("VerifyChain = %v, want the forged checkpoint rejected", err)
+	}
+}
+
+func TestChainPeriodicCheckpoints(t *testing.T) {
+	key := []byte("secret")
+	path := filepath.Join(t.TempDir(), "sshesame.log")
+	sink, err := NewFileSink(path, &log.JSONFormatter{}, Batching{}, Chaining{Key: key, CheckpointInterval: 10 * time.Millisecond}, Rotation{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if err := sink.Emit(Event{Time: time.Now(), Level: log.InfoLevel, Message: "Client connected", Fields: log.Fields{}}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, checkpoints, err := VerifyChain(path, key); err == nil && checkpoints == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no checkpoint written while the sink is open")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	// Nothing was added since, so no more checkpoints are written.
+	time.Sleep(50 * time.Millisecond)
+	if _, checkpoints, err := VerifyChain(path, key); err != nil || checkpoints != 1 {
+		t.Errorf("VerifyChain = %v checkpoints, %v, want 1", checkpoints, err)
+	}
+}
+
+func TestChainRejects(t *testing.T) {
+	if _, err := NewFileSink(filepath.Join(t.TempDir(), "sshesame.log"), &log.TextFormatter{}, Batching{}, Chaining{Key: []byte("secret")}, Rotation{}); err == nil {
+		t.Error("chained a log file in the text format")
+	}
+	if _, err := NewFileSink(filepath.Join(t.TempDir(), "sshesame.log"), &log.JSONFormatter{}, Batching{}, Chaining{Key: []byte("secret")}, Rotation{MaxSize: 1024}); err == nil {
+		t.Error("chained a rotated log file")
+	}
+	path := filepath.Join(t.TempDir(), "sshesame.log")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer, err := newChainWriter(file, path, Chaining{Key: []byte("secret")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	if _, err := writer.Write([]byte("time=now msg=hi\n")); err == nil {
+		t.Error("chained a line that isn't a JSON object")
+	}
+}
@@ -0,0 +1,40 @@
+package output
+
+import (
+	"sync"
+)
+
+// CounterSink counts events by message.
+type CounterSink struct {
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+// NewCounterSink returns a sink with every count at zero.
+func NewCounterSink() *CounterSink {
+	return &CounterSink{counts: map[string]uint64{}}
+}
+
+// Emit implements Sink.
+func (sink *CounterSink) Emit(event Event) error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	sink.counts[event.Message]++
+	return nil
+}
+
+// Count returns the number of events with any of messages emitted so far.
+func (sink *CounterSink) Count(messages ...string) uint64 {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	total := uint64(0)
+	for _, message := range messages {
+		total += sink.counts[message]
+	}
+	return total
+}
+
+// Close implements Sink.
+func (sink *CounterSink) Close() error {
+	return nil
+}
@@ -0,0 +1,287 @@
+package output
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// databaseTimeout bounds writing a batch to the database.
+const databaseTimeout = 30 * time.Second
+
+// databaseSchema creates the tables of DatabaseSink, in SQL both PostgreSQL
+// and SQLite understand.
+var databaseSchema = []string{
+	`CREATE TABLE IF NOT EXISTS sessions (
+		session_id TEXT PRIMARY KEY,
+		client_ip TEXT,
+		client_port INTEGER,
+		username TEXT,
+		client_version TEXT,
+		country TEXT,
+		asn BIGINT,
+		org TEXT,
+		started_at TIMESTAMP,
+		ended_at TIMESTAMP,
+		duration_seconds DOUBLE PRECISION,
+		interaction TEXT
+	)`,
+	`CREATE TABLE IF NOT EXISTS auth_attempts (
+		time TIMESTAMP NOT NULL,
+		session_id TEXT,
+		client_ip TEXT,
+		method TEXT,
+		username TEXT,
+		password TEXT,
+		fingerprint TEXT,
+		outcome TEXT,
+		reason TEXT
+	)`,
+	`CREATE TABLE IF NOT EXISTS commands (
+		time TIMESTAMP NOT NULL,
+		session_id TEXT,
+		client_ip TEXT,
+		command TEXT,
+		exit_status INTEGER,
+		category TEXT
+	)`,
+	`CREATE TABLE IF NOT EXISTS captured_files (
+		time TIMESTAMP NOT NULL,
+		session_id TEXT,
+		client_ip TEXT,
+		path TEXT,
+		sha256 TEXT,
+		size BIGINT,
+		source TEXT,
+		url TEXT
+	)`,
+	`CREATE TABLE IF NOT EXISTS forwards (
+		time TIMESTAMP NOT NULL,
+		session_id TEXT,
+		client_ip TEXT,
+		direction TEXT,
+		source TEXT,
+		destination TEXT
+	)`,
+	`CREATE INDEX IF NOT EXISTS auth_attempts_time ON auth_attempts (time)`,
+	`CREATE INDEX IF NOT EXISTS commands_time ON commands (time)`,
+	`CREATE INDEX IF NOT EXISTS captured_files_sha256 ON captured_files (sha256)`,
+}
+
+// databaseRow is a statement writing an event to its table.
+type databaseRow struct {
+	query string
+	args  []interface{}
+}
+
+// DatabaseSink writes sessions, authentication attempts, commands, captured
+// files and forwarding requests to the tables of a PostgreSQL or SQLite
+// database, created if they don't exist, for querying them with SQL rather
+// than searching logs. Other events aren't written. Rows are written in a
+// transaction per batch, batched like a log file, and failed batches are
+// retried with exponential backoff, then dropped.
+type DatabaseSink struct {
+	db       *sql.DB
+	batching Batching
+
+	mu    sync.Mutex
+	rows  []databaseRow
+	timer *time.Timer
+	// err is the error of the last write triggered by the timer, returned
+	// by the next Emit.
+	err error
+}
+
+// NewDatabaseSink returns a sink writing to the database at dsn through the
+// database/sql driver called driverName, creating its tables.
+func NewDatabaseSink(driverName, dsn string, batching Batching) (*DatabaseSink, error) {
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), databaseTimeout)
+	defer cancel()
+	for _, statement := range databaseSchema {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return &DatabaseSink{db: db, batching: batching}, nil
+}
+
+// Emit implements Sink.
+func (sink *DatabaseSink) Emit(event Event) error {
+	row, ok := databaseRowOf(event)
+	if !ok {
+		return nil
+	}
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if err := sink.err; err != nil {
+		sink.err = nil
+		return err
+	}
+	sink.rows = append(sink.rows, row)
+	if len(sink.rows) >= sink.batching.Size {
+		return sink.flush()
+	}
+	if sink.timer == nil && sink.batching.FlushInterval > 0 {
+		sink.timer = time.AfterFunc(sink.batching.FlushInterval, func() {
+			sink.mu.Lock()
+			defer sink.mu.Unlock()
+			sink.timer = nil
+			sink.err = sink.flush()
+		})
+	}
+	return nil
+}
+
+// flush writes the buffered rows. sink.mu must be held.
+func (sink *DatabaseSink) flush() error {
+	if sink.timer != nil {
+		sink.timer.Stop()
+		sink.timer = nil
+	}
+	if len(sink.rows) == 0 {
+		return nil
+	}
+	rows := sink.rows
+	sink.rows = nil
+	return retryPush(func() (bool, error) {
+		return true, sink.write(rows)
+	})
+}
+
+// write writes rows in a transaction.
+func (sink *DatabaseSink) write(rows []databaseRow) error {
+	ctx, cancel := context.WithTimeout(context.Background(), databaseTimeout)
+	defer cancel()
+	tx, err := sink.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if _, err := tx.ExecContext(ctx, row.query, row.args...); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Close implements Sink, writing the partial batch.
+func (sink *DatabaseSink) Close() error {
+	sink.mu.Lock()
+	err := sink.flush()
+	sink.mu.Unlock()
+	if closeErr := sink.db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// databaseRowOf returns the statement writing event, if it's written.
+func databaseRowOf(event Event) (databaseRow, bool) {
+	fields := event.Fields
+	text := func(name string) interface{} {
+		value, ok := fields[name]
+		if !ok || value == nil {
+			return nil
+		}
+		return fmt.Sprint(value)
+	}
+	number := func(name string) interface{} {
+		switch value := fields[name].(type) {
+		case int:
+			return int64(value)
+		case int64:
+			return value
+		case uint64:
+			return int64(value)
+		case float64:
+			return int64(value)
+		}
+		return nil
+	}
+	sessionID := text("session_id")
+	var clientIP, clientPort interface{}
+	if host, port, err := net.SplitHostPort(fmt.Sprint(fields["client"])); err == nil {
+		clientIP = host
+		var parsed int64
+		if _, err := fmt.Sscan(port, &parsed); err == nil {
+			clientPort = parsed
+		}
+	}
+	switch {
+	case event.Message == "SSH connection established" && sessionID != nil:
+		return databaseRow{
+			`INSERT INTO sessions (session_id, client_ip, client_port, username, client_version, country, asn, org, started_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			ON CONFLICT (session_id) DO UPDATE SET username = excluded.username, client_version = excluded.client_version`,
+			[]interface{}{sessionID, clientIP, clientPort, text("user"), text("version"), text("country"), number("asn"), text("org"), event.Time},
+		}, true
+	case event.Message == "Client disconnected" && sessionID != nil:
+		var started, seconds interface{}
+		if duration, err := time.ParseDuration(fmt.Sprint(fields["duration"])); err == nil {
+			started, seconds = event.Time.Add(-duration), duration.Seconds()
+		}
+		// Clients disconnecting before authenticating have no session yet.
+		return databaseRow{
+			`INSERT INTO sessions (session_id, client_ip, client_port, country, asn, org, started_at, ended_at, duration_seconds, interaction)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			ON CONFLICT (session_id) DO UPDATE SET ended_at = excluded.ended_at, duration_seconds = excluded.duration_seconds, interaction = excluded.interaction`,
+			[]interface{}{sessionID, clientIP, clientPort, text("country"), number("asn"), text("org"), started, event.Time, seconds, text("interaction")},
+		}, true
+	case strings.HasSuffix(event.Message, " authentication accepted") || strings.HasSuffix(event.Message, " authentication rejected") || strings.HasSuffix(event.Message, " authentication partially succeeded"):
+		split := strings.SplitN(event.Message, " authentication ", 2)
+		password := text("password")
+		if responses, ok := fields["responses"].(map[string]string); ok && password == nil {
+			if response, ok := responses["password"]; ok {
+				password = response
+			}
+		}
+		return databaseRow{
+			`INSERT INTO auth_attempts (time, session_id, client_ip, method, username, password, fingerprint, outcome, reason)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			[]interface{}{event.Time, sessionID, clientIP, strings.ToLower(split[0]), text("user"), password, text("fingerprint"), split[1], text("reason")},
+		}, true
+	case event.Message == "Command executed":
+		return databaseRow{
+			`INSERT INTO commands (time, session_id, client_ip, command, exit_status, category)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+			[]interface{}{event.Time, sessionID, clientIP, text("command"), number("exit_status"), text("category")},
+		}, true
+	case event.Message == "File uploaded" || event.Message == "File downloaded":
+		source := text("source")
+		if source == nil {
+			source = text("tool")
+		}
+		return databaseRow{
+			`INSERT INTO captured_files (time, session_id, client_ip, path, sha256, size, source, url)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			[]interface{}{event.Time, sessionID, clientIP, text("path"), text("sha256"), number("size"), source, text("url")},
+		}, true
+	case event.Message == "Channel requested" && fields["channel"] == "direct-tcpip":
+		endpoints := strings.SplitN(fmt.Sprint(fields["payload"]), " -> ", 2)
+		if len(endpoints) != 2 {
+			return databaseRow{}, false
+		}
+		return databaseRow{
+			`INSERT INTO forwards (time, session_id, client_ip, direction, source, destination)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+			[]interface{}{event.Time, sessionID, clientIP, "local", endpoints[0], endpoints[1]},
+		}, true
+	case event.Message == "Request received" && fields["request"] == "tcpip-forward":
+		return databaseRow{
+			`INSERT INTO forwards (time, session_id, client_ip, direction, source, destination)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+			[]interface{}{event.Time, sessionID, clientIP, "remote", text("payload"), nil},
+		}, true
+	}
+	return databaseRow{}, false
+}
@@ -0,0 +1,136 @@
+package output
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"github.com/longkeyy/sshesame/outbound"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const elasticsearchTimeout = 30 * time.Second
+
+// elasticsearchTemplate is the index template of the indices events are
+// indexed into, {{index}} standing for their prefix.
+//
+//go:embed elasticsearch_template.json
+var elasticsearchTemplate string
+
+// ElasticsearchSink indexes events into daily indices of Elasticsearch or
+// OpenSearch with the bulk API, as the objects log_file would contain with an
+// @timestamp field added, batched like a log file. The index template of the
+// indices is put before the first batch. Failed batches are retried with
+// exponential backoff, then dropped, and events the cluster rejects are
+// reported without retrying them.
+type ElasticsearchSink struct {
+	url     string
+	index   string
+	headers http.Header
+	client  *http.Client
+	batcher *pushBatcher
+	// templatePut is set once the index template was put, only used by
+	// flush.
+	templatePut bool
+}
+
+// NewElasticsearchSink returns a sink indexing events into the indices
+// called index followed by the day of the event, e.g. sshesame-2024.01.31,
+// of the cluster at url, sending headers along with every request.
+func NewElasticsearchSink(url, index string, headers http.Header, timestamps Timestamps, batching Batching) *ElasticsearchSink {
+	sink := &ElasticsearchSink{
+		url:     strings.TrimSuffix(url, "/"),
+		index:   index,
+		headers: headers,
+		client:  outbound.HTTPClient(elasticsearchTimeout),
+	}
+	sink.batcher = newPushBatcher(timestamps, batching, sink.flush)
+	return sink
+}
+
+// Emit implements Sink.
+func (sink *ElasticsearchSink) Emit(event Event) error {
+	return sink.batcher.add(event)
+}
+
+func (sink *ElasticsearchSink) flush(lines []pushedLine) error {
+	var body bytes.Buffer
+	for _, line := range lines {
+		fmt.Fprintf(&body, `{"create":{"_index":"%v-%v"}}`+"\n", sink.index, line.time.UTC().Format("2006.01.02"))
+		fmt.Fprintf(&body, `{"@timestamp":"%v",`, line.time.UTC().Format(time.RFC3339Nano))
+		body.Write(line.line[1:])
+		body.WriteByte('\n')
+	}
+	return retryPush(func() (bool, error) {
+		if !sink.templatePut {
+			template := strings.ReplaceAll(elasticsearchTemplate, "{{index}}", sink.index)
+			if retry, err := sink.request(http.MethodPut, "/_index_template/"+sink.index, "application/json", []byte(template), nil); err != nil {
+				return retry, err
+			}
+			sink.templatePut = true
+		}
+		var response struct {
+			Errors bool `json:"errors"`
+			Items  []map[string]struct {
+				Status int `json:"status"`
+				Error  struct {
+					Type   string `json:"type"`
+					Reason string `json:"reason"`
+				} `json:"error"`
+			} `json:"items"`
+		}
+		if retry, err := sink.request(http.MethodPost, "/_bulk", "application/x-ndjson", body.Bytes(), &response); err != nil {
+			return retry, err
+		}
+		if !response.Errors {
+			return false, nil
+		}
+		rejected := 0
+		reason := ""
+		for _, item := range response.Items {
+			for _, result := range item {
+				if result.Status/100 != 2 {
+					rejected++
+					reason = result.Error.Type + ": " + result.Error.Reason
+				}
+			}
+		}
+		return false, fmt.Errorf("Elasticsearch rejected %v of %v events, the last one with %v", rejected, len(lines), reason)
+	})
+}
+
+// request sends a request once, decoding the JSON response into response if
+// set, and reports whether a failure is worth retrying.
+func (sink *ElasticsearchSink) request(method, path, contentType string, body []byte, response interface{}) (bool, error) {
+	request, err := http.NewRequest(method, sink.url+path, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for name, values := range sink.headers {
+		request.Header[name] = values
+	}
+	request.Header.Set("Content-Type", contentType)
+	httpResponse, err := sink.client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	defer httpResponse.Body.Close()
+	if httpResponse.StatusCode/100 != 2 {
+		retry := httpResponse.StatusCode == http.StatusTooManyRequests || httpResponse.StatusCode/100 == 5
+		return retry, fmt.Errorf("Elasticsearch responded %v to %v %v", httpResponse.Status, method, path)
+	}
+	if response == nil {
+		return false, nil
+	}
+	if err := json.NewDecoder(httpResponse.Body).Decode(response); err != nil {
+		return true, err
+	}
+	return false, nil
+}
+
+// Close implements Sink, indexing the partial batch.
+func (sink *ElasticsearchSink) Close() error {
+	return sink.batcher.close()
+}
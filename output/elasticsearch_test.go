@@ -0,0 +1,90 @@
+package output
+
+import (
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestElasticsearchSinkIndexes(t *testing.T) {
+	var mu sync.Mutex
+	requests := []string{}
+	bulks := []string{}
+	rejected := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		if r.Header.Get("Authorization") != "ApiKey secret" {
+			t.Errorf("%v %v with %v", r.Method, r.URL.Path, r.Header)
+		}
+		switch r.URL.Path {
+		case "/_index_template/sshesame":
+			var template struct {
+				IndexPatterns []string `json:"index_patterns"`
+			}
+			if err := json.Unmarshal(body, &template); err != nil || len(template.IndexPatterns) != 1 || template.IndexPatterns[0] != "sshesame-*" {
+				t.Errorf("put the template %s", body)
+			}
+		case "/_bulk":
+			if r.Header.Get("Content-Type") != "application/x-ndjson" {
+				t.Errorf("bulk request of %v", r.Header.Get("Content-Type"))
+			}
+			// The first bulk request is throttled.
+			if len(bulks) == 0 {
+				bulks = append(bulks, string(body))
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			bulks = append(bulks, string(body))
+			if rejected {
+				w.Write([]byte(`{"errors":true,"items":[{"create":{"status":201}},{"create":{"status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse"}}}]}`))
+				return
+			}
+			w.Write([]byte(`{"errors":false,"items":[{"create":{"status":201}},{"create":{"status":201}}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	sink := NewElasticsearchSink(server.URL+"/", "sshesame", http.Header{"Authorization": {"ApiKey secret"}}, Timestamps{}, Batching{Size: 2})
+	// Events go to the index of their day in UTC.
+	for _, eventTime := range []time.Time{
+		time.Date(2026, 10, 14, 23, 59, 59, 500000000, time.UTC),
+		time.Date(2026, 10, 15, 1, 0, 0, 0, time.FixedZone("CEST", 2*60*60)),
+	} {
+		if err := sink.Emit(Event{Time: eventTime, Level: log.InfoLevel, Message: "Command executed"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mu.Lock()
+	rejected = true
+	mu.Unlock()
+	sink.Emit(Event{Time: time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC), Level: log.InfoLevel, Message: "Command executed"})
+	err := sink.Emit(Event{Time: time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC), Level: log.InfoLevel, Message: "Command executed", Fields: log.Fields{"command": "id"}})
+	if err == nil || !strings.Contains(err.Error(), "rejected 1 of 2 events, the last one with mapper_parsing_exception: failed to parse") {
+		t.Errorf("rejected events reported as %v", err)
+	}
+	sink.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	// The template is put once.
+	if want := []string{"PUT /_index_template/sshesame", "POST /_bulk", "POST /_bulk", "POST /_bulk"}; strings.Join(requests, ",") != strings.Join(want, ",") {
+		t.Errorf("sent %v, want %v", requests, want)
+	}
+	first := `{"create":{"_index":"sshesame-2026.10.14"}}` + "\n" +
+		`{"@timestamp":"2026-10-14T23:59:59.5Z","level":"info","msg":"Command executed","time":"2026-10-14T23:59:59Z"}` + "\n" +
+		`{"create":{"_index":"sshesame-2026.10.14"}}` + "\n" +
+		`{"@timestamp":"2026-10-14T23:00:00Z","level":"info","msg":"Command executed","time":"2026-10-15T01:00:00+02:00"}` + "\n"
+	if len(bulks) != 3 || bulks[0] != first || bulks[1] != first {
+		t.Errorf("indexed %q, want %q twice", bulks, first)
+	}
+}
@@ -0,0 +1,100 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Fail2banSink writes a line for every failed authentication attempt, worded
+// like sshd's so that fail2ban's stock sshd filter matches it unchanged:
+//
+//	Oct 14 03:29:30 sshesame sshd[1234]: Failed password for invalid user bob from 192.0.2.1 port 51234 ssh2
+//
+// Other events are ignored.
+type Fail2banSink struct {
+	writer   io.Writer
+	hostname string
+	// validUser reports whether a user exists, users that don't are reported
+	// as invalid like sshd does.
+	validUser func(user string) bool
+}
+
+// NewFail2banSink returns a sink appending failed authentication attempts to
+// the file at path.
+func NewFail2banSink(path string, validUser func(user string) bool) (*Fail2banSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	return &Fail2banSink{file, hostname, validUser}, nil
+}
+
+// sshdKeyType returns how sshd names a key type, e.g. ED25519 for
+// ssh-ed25519.
+func sshdKeyType(keyType string) string {
+	switch {
+	case strings.HasPrefix(keyType, "ecdsa-"):
+		return "ECDSA"
+	case strings.HasPrefix(keyType, "sk-ecdsa-"):
+		return "ECDSA-SK"
+	case strings.HasPrefix(keyType, "sk-ssh-ed25519"):
+		return "ED25519-SK"
+	case strings.HasPrefix(keyType, "rsa-sha2-"):
+		return "RSA"
+	}
+	return strings.ToUpper(strings.TrimPrefix(keyType, "ssh-"))
+}
+
+// Emit implements Sink.
+func (sink *Fail2banSink) Emit(event Event) error {
+	var method, suffix string
+	switch event.Message {
+	case "Public key authentication rejected":
+		method = "publickey"
+		suffix = fmt.Sprintf(": %v %v", sshdKeyType(fmt.Sprint(event.Fields["key_type"])), event.Fields["fingerprint"])
+	case "None authentication rejected":
+		method = "none"
+	case "Password authentication rejected":
+		method = "password"
+	case "Keyboard-interactive authentication rejected":
+		method = "keyboard-interactive/pam"
+	case "Authentication with disabled method rejected":
+		method = fmt.Sprint(event.Fields["method"])
+		if method == "keyboard-interactive" {
+			method = "keyboard-interactive/pam"
+		}
+	default:
+		return nil
+	}
+	host, port, err := net.SplitHostPort(fmt.Sprint(event.Fields["client"]))
+	if err != nil {
+		return err
+	}
+	user := fmt.Sprint(event.Fields["user"])
+	if !sink.validUser(user) {
+		user = "invalid user " + user
+	} else if method == "none" {
+		// Like sshd, which only logs failed none attempts for invalid users
+		// at the default log level.
+		return nil
+	}
+	_, err = fmt.Fprintf(sink.writer, "%v %v sshd[%v]: Failed %v for %v from %v port %v ssh2%v\n",
+		event.Time.Format(time.Stamp), sink.hostname, os.Getpid(), method, user, host, port, suffix)
+	return err
+}
+
+// Close implements Sink.
+func (sink *Fail2banSink) Close() error {
+	if closer, ok := sink.writer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package output
+
+import (
+	"bytes"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	// fail2banDate is the syslog date fail2ban's default date pattern finds
+	// and strips before matching failregex.
+	fail2banDate = regexp.MustCompile(`^[A-Z][a-z]{2} [ \d]\d \d\d:\d\d:\d\d `)
+	// fail2banFailed is the failregex of fail2ban's sshd filter for failed
+	// attempts, in normal mode, with its prefix line, the conditional
+	// groups Go lacks being spelled out as alternatives.
+	fail2banFailed = regexp.MustCompile(`^\s*(?:\S+ )?(?:\[\d+\]:\s+|sshd(?:\[\d+\])?:?\s+)?` +
+		`Failed (?:publickey|password|none|keyboard-interactive/pam|\S+) for ` +
+		`(?:invalid user (?P<invalid>.*?)|(?P<user>\S+)) from (?P<host>\S+)(?: port \d+)?(?: ssh\d*)?(?:: .*)?$`)
+)
+
+// fail2banMatch returns the user and host fail2ban finds in line, or false if
+// it doesn't match.
+func fail2banMatch(line string) (string, string, bool) {
+	if !fail2banDate.MatchString(line) {
+		return "", "", false
+	}
+	match := fail2banFailed.FindStringSubmatch(fail2banDate.ReplaceAllString(line, ""))
+	if match == nil {
+		return "", "", false
+	}
+	return match[1] + match[2], match[3], true
+}
+
+func TestFail2banLines(t *testing.T) {
+	var buffer bytes.Buffer
+	sink := &Fail2banSink{&buffer, "web-1", func(user string) bool { return user == "root" }}
+	client := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 51234}
+	at := time.Date(2026, time.October, 4, 3, 29, 30, 0, time.Local)
+	for _, test := range []struct {
+		message string
+		fields  log.Fields
+		line    string
+	}{
+		{"Password authentication rejected", log.Fields{"user": "root"}, "Failed password for root from 192.0.2.1 port 51234 ssh2"},
+		{"Password authentication rejected", log.Fields{"user": "bob"}, "Failed password for invalid user bob from 192.0.2.1 port 51234 ssh2"},
+		{"Public key authentication rejected", log.Fields{"user": "root", "key_type": "ssh-ed25519", "fingerprint": "SHA256:abc"}, "Failed publickey for root from 192.0.2.1 port 51234 ssh2: ED25519 SHA256:abc"},
+		{"Keyboard-interactive authentication rejected", log.Fields{"user": "bob"}, "Failed keyboard-interactive/pam for invalid user bob from 192.0.2.1 port 51234 ssh2"},
+		{"None authentication rejected", log.Fields{"user": "bob"}, "Failed none for invalid user bob from 192.0.2.1 port 51234 ssh2"},
+		{"Authentication with disabled method rejected", log.Fields{"user": "root", "method": "keyboard-interactive"}, "Failed keyboard-interactive/pam for root from 192.0.2.1 port 51234 ssh2"},
+		// A made up user naming another host isn't taken for the client.
+		{"Password authentication rejected", log.Fields{"user": "x from 198.51.100.1"}, "Failed password for invalid user x from 198.51.100.1 from 192.0.2.1 port 51234 ssh2"},
+	} {
+		buffer.Reset()
+		test.fields["client"] = client
+		if err := sink.Emit(Event{Time: at, Message: test.message, Fields: test.fields}); err != nil {
+			t.Fatal(err)
+		}
+		line := strings.TrimSuffix(buffer.String(), "\n")
+		if prefix := "Oct  4 03:29:30 web-1 sshd["; !strings.HasPrefix(line, prefix) || !strings.HasSuffix(line, "]: "+test.line) {
+			t.Errorf("%v wrote %q, want %v<pid>]: %v", test.message, line, prefix, test.line)
+			continue
+		}
+		user, host, ok := fail2banMatch(line)
+		if !ok || host != "192.0.2.1" || user != test.fields["user"] {
+			t.Errorf("fail2ban matched %q with %q from %q, %v, want %q from 192.0.2.1", line, user, host, ok, test.fields["user"])
+		}
+	}
+}
+
+func TestFail2banIgnores(t *testing.T) {
+	var buffer bytes.Buffer
+	sink := &Fail2banSink{&buffer, "web-1", func(user string) bool { return user == "root" }}
+	client := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 51234}
+	for _, event := range []Event{
+		{Message: "Password authentication accepted", Fields: log.Fields{"client": client, "user": "root"}},
+		{Message: "Command executed", Fields: log.Fields{"client": client, "command": "uname -a"}},
+		// sshd only logs failed none attempts of valid users at higher levels.
+		{Message: "None authentication rejected", Fields: log.Fields{"client": client, "user": "root"}},
+	} {
+		if err := sink.Emit(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if buffer.Len() != 0 {
+		t.Errorf("wrote %q, want nothing", buffer.String())
+	}
+}
@@ -0,0 +1,109 @@
+package output
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// eventTypes are the request and channel types events can be filtered by.
+var eventTypes = []string{
+	// Requests
+	"auth-agent-req@openssh.com",
+	"break",
+	"cancel-tcpip-forward",
+	"env",
+	"exec",
+	"exit-signal",
+	"exit-status",
+	"keepalive@openssh.com",
+	"no-more-sessions@openssh.com",
+	"pty-req",
+	"shell",
+	"signal",
+	"subsystem",
+	"tcpip-forward",
+	"window-change",
+	"x11-req",
+	"xon-xoff",
+	// Channels
+	"direct-tcpip",
+	"forwarded-tcpip",
+	"session",
+	"x11",
+}
+
+// EventType returns the request or channel type of a request or channel
+// event, or an empty string for other events.
+func EventType(event Event) string {
+	var value interface{}
+	switch event.Message {
+	case "Request received":
+		value = event.Fields["request"]
+	case "Channel requested":
+		value = event.Fields["channel"]
+	default:
+		return ""
+	}
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// EventTypes is a set of event types. It implements flag.Value, parsing a
+// comma-separated list of known types.
+type EventTypes map[string]bool
+
+func (types *EventTypes) String() string {
+	if types == nil {
+		return ""
+	}
+	names := []string{}
+	for name := range *types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// Set implements flag.Value.
+func (types *EventTypes) Set(text string) error {
+	parsed := EventTypes{}
+	for _, name := range strings.Split(text, ",") {
+		if name == "" {
+			continue
+		}
+		known := false
+		for _, eventType := range eventTypes {
+			known = known || eventType == name
+		}
+		if !known {
+			return fmt.Errorf("unknown event type %q, must be one of %v", name, strings.Join(eventTypes, ", "))
+		}
+		parsed[name] = true
+	}
+	*types = parsed
+	return nil
+}
+
+// Filter decides which request and channel events reach sinks by their type.
+// Other events always pass.
+type Filter struct {
+	// Include, if set, are the only event types passed.
+	Include EventTypes
+	// Exclude are event types suppressed.
+	Exclude EventTypes
+}
+
+// Pass reports whether event passes the filter.
+func (filter Filter) Pass(event Event) bool {
+	eventType := EventType(event)
+	if eventType == "" {
+		return true
+	}
+	if len(filter.Include) != 0 && !filter.Include[eventType] {
+		return false
+	}
+	return !filter.Exclude[eventType]
+}
@@ -0,0 +1,79 @@
+package output
+
+import (
+	log "github.com/sirupsen/logrus"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// filterEvents are a request and a channel event of each type filtered in
+// tests, and an event filters don't apply to.
+var filterEvents = []Event{
+	{Message: "Request received", Fields: log.Fields{"request": "window-change"}},
+	{Message: "Request received", Fields: log.Fields{"request": "exec"}},
+	{Message: "Channel requested", Fields: log.Fields{"channel": "session"}},
+	{Message: "Client connected", Fields: log.Fields{}},
+}
+
+// types returns the types of events, or their messages for events without
+// one.
+func types(events []Event) []string {
+	types := []string{}
+	for _, event := range events {
+		if eventType := EventType(event); eventType != "" {
+			types = append(types, eventType)
+		} else {
+			types = append(types, event.Message)
+		}
+	}
+	return types
+}
+
+func TestFilterSuppresses(t *testing.T) {
+	filter := Filter{}
+	if err := filter.Exclude.Set("window-change,keepalive@openssh.com"); err != nil {
+		t.Fatal(err)
+	}
+	dispatcher := &Dispatcher{Filter: filter}
+	sink, metrics := &recordingSink{}, &recordingSink{}
+	dispatcher.Add("sink", sink, 16)
+	dispatcher.AddMetrics("metrics", metrics, 16)
+	for _, event := range filterEvents {
+		event.Time = time.Now()
+		dispatcher.Emit(event)
+	}
+	dispatcher.Close()
+	if got, want := types(sink.events), []string{"exec", "session", "Client connected"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sink received %v, want %v", got, want)
+	}
+	// Metrics still count suppressed events.
+	if got, want := types(metrics.events), []string{"window-change", "exec", "session", "Client connected"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("metrics received %v, want %v", got, want)
+	}
+}
+
+func TestFilterIncludes(t *testing.T) {
+	filter := Filter{}
+	if err := filter.Include.Set("exec"); err != nil {
+		t.Fatal(err)
+	}
+	passed := []Event{}
+	for _, event := range filterEvents {
+		if filter.Pass(event) {
+			passed = append(passed, event)
+		}
+	}
+	if got, want := types(passed), []string{"exec", "Client connected"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("passed %v, want %v", got, want)
+	}
+}
+
+func TestEventTypesSetInvalid(t *testing.T) {
+	var parsed EventTypes
+	for _, text := range []string{"window_change", "exec,keepalive"} {
+		if err := parsed.Set(text); err == nil {
+			t.Errorf("Set(%q) succeeded", text)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package output
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/longkeyy/sshesame/outbound"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hpfeeds message opcodes, see https://hpfeeds.org/wire-protocol.
+const (
+	hpfeedsError   = 0
+	hpfeedsInfo    = 1
+	hpfeedsAuth    = 2
+	hpfeedsPublish = 3
+	hpfeedsTimeout = 10 * time.Second
+	// hpfeedsMaxInfo bounds the info message the broker starts with.
+	hpfeedsMaxInfo = 1024
+)
+
+// HPFeedsChannels are the channels events are published to by kind, events
+// of a kind without a channel aren't published.
+type HPFeedsChannels struct {
+	// Auth receives authentication attempts, Commands the commands clients
+	// run and Files the files they upload, with their hashes.
+	Auth, Commands, Files string
+}
+
+// channel returns the channel of event, empty if it isn't published.
+func (channels HPFeedsChannels) channel(event Event) string {
+	switch {
+	case event.Message == "Command executed":
+		return channels.Commands
+	case event.Message == "File uploaded":
+		return channels.Files
+	case strings.Contains(event.Message, "authentication ") && !strings.HasSuffix(event.Message, " received"):
+		return channels.Auth
+	}
+	return ""
+}
+
+// HPFeedsSink publishes authentication attempts, commands and uploaded files
+// to an hpfeeds broker as the JSON lines log_file would contain, as the
+// honeynets aggregating honeypot data consume. The connection is opened and
+// authenticated again when publishing fails.
+type HPFeedsSink struct {
+	address   string
+	ident     string
+	secret    string
+	channels  HPFeedsChannels
+	formatter log.Formatter
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewHPFeedsSink returns a sink publishing to the broker at address,
+// authenticating as ident with secret.
+func NewHPFeedsSink(address, ident, secret string, channels HPFeedsChannels, timestamps Timestamps) *HPFeedsSink {
+	return &HPFeedsSink{
+		address:   address,
+		ident:     ident,
+		secret:    secret,
+		channels:  channels,
+		formatter: timestamps.Formatter(true),
+	}
+}
+
+// connect connects to the broker and authenticates with the nonce of its
+// info message. sink.mu must be held.
+func (sink *HPFeedsSink) connect() error {
+	conn, err := outbound.Dial("tcp", sink.address, hpfeedsTimeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(hpfeedsTimeout))
+	var header [5]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		conn.Close()
+		return err
+	}
+	length := binary.BigEndian.Uint32(header[:4])
+	if length < 5 || length > hpfeedsMaxInfo {
+		conn.Close()
+		return fmt.Errorf("invalid hpfeeds message of %v bytes", length)
+	}
+	payload := make([]byte, length-5)
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		conn.Close()
+		return err
+	}
+	if header[4] == hpfeedsError {
+		conn.Close()
+		return fmt.Errorf("hpfeeds broker error: %s", payload)
+	}
+	// The info message holds the name of the broker and a nonce.
+	if header[4] != hpfeedsInfo || len(payload) < 1 || len(payload) < 1+int(payload[0])+4 {
+		conn.Close()
+		return errors.New("invalid hpfeeds info message")
+	}
+	nonce := payload[1+int(payload[0]):]
+	hash := sha1.Sum(append(append([]byte{}, nonce...), sink.secret...))
+	auth := &bytes.Buffer{}
+	hpfeedsString(auth, sink.ident)
+	auth.Write(hash[:])
+	if _, err := conn.Write(hpfeedsMessage(hpfeedsAuth, auth.Bytes())); err != nil {
+		conn.Close()
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+	sink.conn = conn
+	return nil
+}
+
+// hpfeedsString writes a string prefixed with its length in a byte.
+func hpfeedsString(buffer *bytes.Buffer, text string) {
+	if len(text) > 255 {
+		text = text[:255]
+	}
+	buffer.WriteByte(byte(len(text)))
+	buffer.WriteString(text)
+}
+
+func hpfeedsMessage(opcode byte, payload []byte) []byte {
+	message := make([]byte, 5, 5+len(payload))
+	binary.BigEndian.PutUint32(message, uint32(5+len(payload)))
+	message[4] = opcode
+	return append(message, payload...)
+}
+
+// Emit implements Sink.
+func (sink *HPFeedsSink) Emit(event Event) error {
+	channel := sink.channels.channel(event)
+	if channel == "" {
+		return nil
+	}
+	line, err := sink.formatter.Format(&log.Entry{
+		Logger:  log.StandardLogger(),
+		Data:    event.Fields,
+		Time:    event.Time,
+		Level:   event.Level,
+		Message: event.Message,
+	})
+	if err != nil {
+		return err
+	}
+	publish := &bytes.Buffer{}
+	hpfeedsString(publish, sink.ident)
+	hpfeedsString(publish, channel)
+	publish.Write(bytes.TrimRight(line, "\n"))
+	message := hpfeedsMessage(hpfeedsPublish, publish.Bytes())
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if sink.conn != nil {
+		sink.conn.SetWriteDeadline(time.Now().Add(hpfeedsTimeout))
+		if _, err := sink.conn.Write(message); err == nil {
+			return nil
+		}
+		sink.conn.Close()
+		sink.conn = nil
+	}
+	if err := sink.connect(); err != nil {
+		return err
+	}
+	sink.conn.SetWriteDeadline(time.Now().Add(hpfeedsTimeout))
+	_, err = sink.conn.Write(message)
+	return err
+}
+
+// Close implements Sink.
+func (sink *HPFeedsSink) Close() error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if sink.conn == nil {
+		return nil
+	}
+	return sink.conn.Close()
+}
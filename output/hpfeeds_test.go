@@ -0,0 +1,106 @@
+package output
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// readHPFeedsMessage reads an hpfeeds message from conn.
+func readHPFeedsMessage(t *testing.T, conn net.Conn) (byte, []byte) {
+	t.Helper()
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		t.Fatal(err)
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header)-5)
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		t.Fatal(err)
+	}
+	return header[4], payload
+}
+
+func TestHPFeedsSinkPublishes(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	sink := NewHPFeedsSink(listener.Addr().String(), "sensor", "secret", HPFeedsChannels{Auth: "sshesame.auth", Commands: "sshesame.commands"}, Timestamps{})
+	defer sink.Close()
+	eventTime := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	emitted := make(chan error, 1)
+	go func() {
+		// Events of kinds without a channel aren't published.
+		for _, message := range []string{"SSH connection established", "Password authentication received", "File uploaded"} {
+			if err := sink.Emit(Event{Time: eventTime, Level: log.InfoLevel, Message: message}); err != nil {
+				emitted <- err
+				return
+			}
+		}
+		emitted <- sink.Emit(Event{Time: eventTime, Level: log.InfoLevel, Message: "Password authentication rejected"})
+		emitted <- sink.Emit(Event{Time: eventTime, Level: log.InfoLevel, Message: "Command executed"})
+	}()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	// The info message with the broker's name and the nonce 01020304.
+	conn.Write([]byte("\x00\x00\x00\x10\x01\x06broker\x01\x02\x03\x04"))
+
+	// SHA-1(nonce || secret)
+	opcode, payload := readHPFeedsMessage(t, conn)
+	if want := "\x06sensor" + string(mustDecodeHex(t, "bf13c597ffb288ba1d013c277c6a229a8e976b5a")); opcode != hpfeedsAuth || string(payload) != want {
+		t.Errorf("authenticated with %v %q, want %q", opcode, payload, want)
+	}
+	for _, want := range []string{
+		"\x06sensor\x0dsshesame.auth" + `{"level":"info","msg":"Password authentication rejected","time":"2026-10-14T12:00:00Z"}`,
+		"\x06sensor\x11sshesame.commands" + `{"level":"info","msg":"Command executed","time":"2026-10-14T12:00:00Z"}`,
+	} {
+		if err := <-emitted; err != nil {
+			t.Fatal(err)
+		}
+		if opcode, payload := readHPFeedsMessage(t, conn); opcode != hpfeedsPublish || string(payload) != want {
+			t.Errorf("published %v %q, want %q", opcode, payload, want)
+		}
+	}
+}
+
+func TestHPFeedsSinkBrokerError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for _, message := range []string{"\x00\x00\x00\x0a\x00wrong", "\x00\x00\x00\x08\x01\x06br", "\xff\xff\xff\xff\x01"} {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte(message))
+			conn.Close()
+		}
+	}()
+	sink := NewHPFeedsSink(listener.Addr().String(), "sensor", "secret", HPFeedsChannels{Commands: "sshesame.commands"}, Timestamps{})
+	for _, want := range []string{"hpfeeds broker error: wrong", "invalid hpfeeds info message", "invalid hpfeeds message of 4294967295 bytes"} {
+		if err := sink.Emit(Event{Time: time.Now(), Level: log.InfoLevel, Message: "Command executed"}); err == nil || err.Error() != want {
+			t.Errorf("Emit = %v, want %v", err, want)
+		}
+	}
+}
+
+func mustDecodeHex(t *testing.T, text string) []byte {
+	t.Helper()
+	decoded, err := hex.DecodeString(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
@@ -0,0 +1,424 @@
+package output
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/longkeyy/sshesame/outbound"
+	"hash/crc32"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Kafka protocol, see https://kafka.apache.org/protocol.
+const (
+	kafkaProduce       = 0
+	kafkaMetadata      = 3
+	kafkaProduceV      = 3
+	kafkaMetadataV     = 4
+	kafkaClientID      = "sshesame"
+	kafkaTimeout       = 10 * time.Second
+	kafkaMaxResponse   = 64 << 20
+	kafkaAcksLeader    = 1
+	kafkaLeaderMissing = -1
+)
+
+// kafkaRetriable are the error codes of partitions whose leader moved or
+// isn't elected yet, worth retrying after fetching the metadata again.
+var kafkaRetriable = map[int16]bool{
+	3: true, // UNKNOWN_TOPIC_OR_PARTITION, while the topic is auto-created
+	5: true, // LEADER_NOT_AVAILABLE
+	6: true, // NOT_LEADER_OR_FOLLOWER
+	7: true, // REQUEST_TIMED_OUT
+}
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// KafkaSink produces events to a Kafka topic as the JSON lines log_file
+// would contain, one record per event, batched like a log file. Batches go
+// to the partitions of the topic in turn, through the brokers leading them,
+// and are acknowledged by the leader. Failed batches are retried with
+// exponential backoff, after fetching the metadata of the topic again, then
+// dropped.
+type KafkaSink struct {
+	brokers []string
+	topic   string
+	batcher *pushBatcher
+
+	// The rest is only used by flush, which the batcher never runs
+	// concurrently.
+	correlationID int32
+	// addresses are the addresses of brokers by node ID, leaders the leader
+	// of every partition, nil until the metadata of the topic is fetched.
+	addresses  map[int32]string
+	leaders    map[int32]int32
+	partitions []int32
+	next       int
+	conns      map[int32]net.Conn
+}
+
+// NewKafkaSink returns a sink producing events to topic, with the metadata of
+// the cluster fetched from the first of brokers that answers.
+func NewKafkaSink(brokers []string, topic string, timestamps Timestamps, batching Batching) *KafkaSink {
+	sink := &KafkaSink{brokers: brokers, topic: topic, conns: map[int32]net.Conn{}}
+	sink.batcher = newPushBatcher(timestamps, batching, sink.flush)
+	return sink
+}
+
+// Emit implements Sink.
+func (sink *KafkaSink) Emit(event Event) error {
+	return sink.batcher.add(event)
+}
+
+func (sink *KafkaSink) flush(lines []pushedLine) error {
+	batch := kafkaRecordBatch(lines)
+	return retryPush(func() (bool, error) {
+		retry, err := sink.produce(batch)
+		if err != nil && retry {
+			sink.disconnect()
+		}
+		return retry, err
+	})
+}
+
+// produce sends a record batch to the next partition once, reporting
+// whether a failure is worth retrying.
+func (sink *KafkaSink) produce(batch []byte) (bool, error) {
+	if sink.leaders == nil {
+		if err := sink.fetchMetadata(); err != nil {
+			return true, err
+		}
+	}
+	if len(sink.partitions) == 0 {
+		return true, fmt.Errorf("Kafka topic %v has no partition with a leader", sink.topic)
+	}
+	partition := sink.partitions[sink.next%len(sink.partitions)]
+	sink.next++
+	leader := sink.leaders[partition]
+	conn, err := sink.conn(leader)
+	if err != nil {
+		return true, err
+	}
+	request := &kafkaEncoder{}
+	request.nullableString(nil)
+	request.int16(kafkaAcksLeader)
+	request.int32(int32(kafkaTimeout / time.Millisecond))
+	request.int32(1)
+	request.string(sink.topic)
+	request.int32(1)
+	request.int32(partition)
+	request.bytes(batch)
+	response, err := sink.roundTrip(conn, kafkaProduce, kafkaProduceV, request.Bytes())
+	if err != nil {
+		return true, err
+	}
+	decoder := &kafkaDecoder{data: response}
+	code := int16(-1)
+	for topics := decoder.int32(); topics > 0 && decoder.err == nil; topics-- {
+		decoder.string()
+		for partitions := decoder.int32(); partitions > 0 && decoder.err == nil; partitions-- {
+			decoder.int32()
+			code = decoder.int16()
+			decoder.int64()
+			decoder.int64()
+		}
+	}
+	if decoder.err != nil {
+		return true, decoder.err
+	}
+	if code != 0 {
+		return kafkaRetriable[code], fmt.Errorf("Kafka responded error %v to produce to %v partition %v", code, sink.topic, partition)
+	}
+	return false, nil
+}
+
+// fetchMetadata fetches the brokers and the partition leaders of the topic
+// from the first bootstrap broker that answers.
+func (sink *KafkaSink) fetchMetadata() error {
+	var lastErr error
+	for _, broker := range sink.brokers {
+		conn, err := outbound.Dial("tcp", broker, kafkaTimeout)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		request := &kafkaEncoder{}
+		request.int32(1)
+		request.string(sink.topic)
+		// allow_auto_topic_creation
+		request.int8(1)
+		response, err := sink.roundTrip(conn, kafkaMetadata, kafkaMetadataV, request.Bytes())
+		conn.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return sink.parseMetadata(response)
+	}
+	return lastErr
+}
+
+func (sink *KafkaSink) parseMetadata(response []byte) error {
+	decoder := &kafkaDecoder{data: response}
+	// throttle_time_ms
+	decoder.int32()
+	addresses := map[int32]string{}
+	for brokers := decoder.int32(); brokers > 0 && decoder.err == nil; brokers-- {
+		node := decoder.int32()
+		host := decoder.string()
+		port := decoder.int32()
+		// rack
+		decoder.string()
+		addresses[node] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	// cluster_id and controller_id
+	decoder.string()
+	decoder.int32()
+	leaders := map[int32]int32{}
+	partitions := []int32{}
+	var topicErr error
+	for topics := decoder.int32(); topics > 0 && decoder.err == nil; topics-- {
+		code := decoder.int16()
+		name := decoder.string()
+		// is_internal
+		decoder.int8()
+		if code != 0 && name == sink.topic {
+			topicErr = fmt.Errorf("Kafka responded error %v to the metadata of %v", code, name)
+		}
+		for count := decoder.int32(); count > 0 && decoder.err == nil; count-- {
+			decoder.int16()
+			partition := decoder.int32()
+			leader := decoder.int32()
+			// replica_nodes and isr_nodes
+			for i := 0; i < 2; i++ {
+				for nodes := decoder.int32(); nodes > 0 && decoder.err == nil; nodes-- {
+					decoder.int32()
+				}
+			}
+			if _, ok := addresses[leader]; ok && name == sink.topic && leader != kafkaLeaderMissing {
+				leaders[partition] = leader
+				partitions = append(partitions, partition)
+			}
+		}
+	}
+	if decoder.err != nil {
+		return decoder.err
+	}
+	if topicErr != nil {
+		return topicErr
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+	sink.addresses, sink.leaders, sink.partitions = addresses, leaders, partitions
+	return nil
+}
+
+// conn returns the connection to the broker node, connecting to it first if
+// needed.
+func (sink *KafkaSink) conn(node int32) (net.Conn, error) {
+	if conn, ok := sink.conns[node]; ok {
+		return conn, nil
+	}
+	conn, err := outbound.Dial("tcp", sink.addresses[node], kafkaTimeout)
+	if err != nil {
+		return nil, err
+	}
+	sink.conns[node] = conn
+	return conn, nil
+}
+
+// disconnect closes the connections to brokers and forgets the metadata, for
+// both to be fetched again.
+func (sink *KafkaSink) disconnect() {
+	for node, conn := range sink.conns {
+		conn.Close()
+		delete(sink.conns, node)
+	}
+	sink.addresses, sink.leaders, sink.partitions = nil, nil, nil
+}
+
+// roundTrip sends a request with a version 1 header and returns the body of
+// its response.
+func (sink *KafkaSink) roundTrip(conn net.Conn, apiKey, apiVersion int16, body []byte) ([]byte, error) {
+	sink.correlationID++
+	header := &kafkaEncoder{}
+	header.int32(0)
+	header.int16(apiKey)
+	header.int16(apiVersion)
+	header.int32(sink.correlationID)
+	header.string(kafkaClientID)
+	header.Write(body)
+	request := header.Bytes()
+	binary.BigEndian.PutUint32(request, uint32(len(request)-4))
+	conn.SetDeadline(time.Now().Add(kafkaTimeout))
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+	var size [4]byte
+	if _, err := io.ReadFull(conn, size[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(size[:])
+	if length < 4 || length > kafkaMaxResponse {
+		return nil, fmt.Errorf("invalid Kafka response of %v bytes", length)
+	}
+	response := make([]byte, length)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return nil, err
+	}
+	if correlationID := int32(binary.BigEndian.Uint32(response)); correlationID != sink.correlationID {
+		return nil, fmt.Errorf("Kafka response to request %v instead of %v", correlationID, sink.correlationID)
+	}
+	return response[4:], nil
+}
+
+// Close implements Sink, producing the partial batch.
+func (sink *KafkaSink) Close() error {
+	err := sink.batcher.close()
+	sink.disconnect()
+	return err
+}
+
+// kafkaRecordBatch encodes lines as the records of a version 2 record batch,
+// uncompressed and outside of any transaction.
+func kafkaRecordBatch(lines []pushedLine) []byte {
+	first := lines[0].time.UnixNano() / int64(time.Millisecond)
+	last := first
+	records := &kafkaEncoder{}
+	for i, line := range lines {
+		timestamp := line.time.UnixNano() / int64(time.Millisecond)
+		if timestamp > last {
+			last = timestamp
+		}
+		record := &kafkaEncoder{}
+		// attributes
+		record.int8(0)
+		record.varint(timestamp - first)
+		record.varint(int64(i))
+		// A null key lets the partition be chosen.
+		record.varint(-1)
+		record.varint(int64(len(line.line)))
+		record.Write(line.line)
+		// headers
+		record.varint(0)
+		records.varint(int64(record.Len()))
+		records.Write(record.Bytes())
+	}
+	// Everything from the attributes on is checksummed.
+	checked := &kafkaEncoder{}
+	checked.int16(0)
+	checked.int32(int32(len(lines) - 1))
+	checked.int64(first)
+	checked.int64(last)
+	// producer_id, producer_epoch and base_sequence, unset without
+	// idempotence.
+	checked.int64(-1)
+	checked.int16(-1)
+	checked.int32(-1)
+	checked.int32(int32(len(lines)))
+	checked.Write(records.Bytes())
+	batch := &kafkaEncoder{}
+	// base_offset, set by the broker
+	batch.int64(0)
+	// batch_length covers everything after it: the partition leader epoch,
+	// magic, CRC and the checksummed part.
+	batch.int32(int32(4 + 1 + 4 + checked.Len()))
+	batch.int32(-1)
+	// magic
+	batch.int8(2)
+	batch.int32(int32(crc32.Checksum(checked.Bytes(), crc32c)))
+	batch.Write(checked.Bytes())
+	return batch.Bytes()
+}
+
+// kafkaEncoder encodes the primitive types of the Kafka protocol.
+type kafkaEncoder struct {
+	bytes.Buffer
+}
+
+func (encoder *kafkaEncoder) int8(value int8) {
+	encoder.WriteByte(byte(value))
+}
+
+func (encoder *kafkaEncoder) int16(value int16) {
+	binary.Write(encoder, binary.BigEndian, value)
+}
+
+func (encoder *kafkaEncoder) int32(value int32) {
+	binary.Write(encoder, binary.BigEndian, value)
+}
+
+func (encoder *kafkaEncoder) int64(value int64) {
+	binary.Write(encoder, binary.BigEndian, value)
+}
+
+func (encoder *kafkaEncoder) string(value string) {
+	encoder.int16(int16(len(value)))
+	encoder.WriteString(value)
+}
+
+func (encoder *kafkaEncoder) nullableString(value *string) {
+	if value == nil {
+		encoder.int16(-1)
+		return
+	}
+	encoder.string(*value)
+}
+
+func (encoder *kafkaEncoder) bytes(value []byte) {
+	encoder.int32(int32(len(value)))
+	encoder.Write(value)
+}
+
+// varint writes a zigzag-encoded variable-length integer, as records use.
+func (encoder *kafkaEncoder) varint(value int64) {
+	var buffer [binary.MaxVarintLen64]byte
+	encoder.Write(buffer[:binary.PutVarint(buffer[:], value)])
+}
+
+// kafkaDecoder decodes the primitive types of the Kafka protocol, returning
+// zero values once the data is exhausted, which sets err.
+type kafkaDecoder struct {
+	data []byte
+	err  error
+}
+
+var errKafkaShort = errors.New("truncated Kafka response")
+
+func (decoder *kafkaDecoder) take(size int) []byte {
+	if decoder.err != nil || len(decoder.data) < size {
+		decoder.err = errKafkaShort
+		return make([]byte, size)
+	}
+	taken := decoder.data[:size]
+	decoder.data = decoder.data[size:]
+	return taken
+}
+
+func (decoder *kafkaDecoder) int8() int8 {
+	return int8(decoder.take(1)[0])
+}
+
+func (decoder *kafkaDecoder) int16() int16 {
+	return int16(binary.BigEndian.Uint16(decoder.take(2)))
+}
+
+func (decoder *kafkaDecoder) int32() int32 {
+	return int32(binary.BigEndian.Uint32(decoder.take(4)))
+}
+
+func (decoder *kafkaDecoder) int64() int64 {
+	return int64(binary.BigEndian.Uint64(decoder.take(8)))
+}
+
+// string decodes a string, nullable or not, null ones being empty.
+func (decoder *kafkaDecoder) string() string {
+	length := decoder.int16()
+	if length < 0 {
+		return ""
+	}
+	return string(decoder.take(int(length)))
+}
@@ -0,0 +1,253 @@
+package output
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// kafkaRequest is a request received by a kafkaBroker.
+type kafkaRequest struct {
+	apiKey, apiVersion int16
+	correlationID      int32
+	clientID           string
+	body               []byte
+}
+
+// kafkaBroker is a Kafka broker with node ID 1 leading both partitions of
+// the events topic, answering produce requests with codes in turn and with
+// no error once they run out.
+type kafkaBroker struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	codes    []int16
+	requests []kafkaRequest
+}
+
+func newKafkaBroker(t *testing.T, codes ...int16) *kafkaBroker {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	broker := &kafkaBroker{listener: listener, codes: codes}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go broker.serve(conn)
+		}
+	}()
+	return broker
+}
+
+func (broker *kafkaBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+		data := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return
+		}
+		decoder := &kafkaDecoder{data: data}
+		request := kafkaRequest{decoder.int16(), decoder.int16(), decoder.int32(), decoder.string(), decoder.data}
+		broker.mu.Lock()
+		broker.requests = append(broker.requests, request)
+		code := int16(0)
+		if request.apiKey == kafkaProduce && len(broker.codes) > 0 {
+			code, broker.codes = broker.codes[0], broker.codes[1:]
+		}
+		broker.mu.Unlock()
+		response := &kafkaEncoder{}
+		response.int32(0)
+		response.int32(request.correlationID)
+		switch request.apiKey {
+		case kafkaMetadata:
+			host, port, _ := net.SplitHostPort(broker.listener.Addr().String())
+			portNumber, _ := strconv.Atoi(port)
+			response.int32(0)
+			response.int32(1)
+			response.int32(1)
+			response.string(host)
+			response.int32(int32(portNumber))
+			response.nullableString(nil)
+			response.string("cluster")
+			response.int32(1)
+			response.int32(1)
+			response.int16(0)
+			response.string("events")
+			response.int8(0)
+			response.int32(2)
+			for partition := int32(0); partition < 2; partition++ {
+				response.int16(0)
+				response.int32(partition)
+				response.int32(1)
+				response.int32(1)
+				response.int32(1)
+				response.int32(1)
+				response.int32(1)
+			}
+		case kafkaProduce:
+			partition := binary.BigEndian.Uint32(request.body[len(request.body)-4-78-4:])
+			response.int32(1)
+			response.string("events")
+			response.int32(1)
+			response.int32(int32(partition))
+			response.int16(code)
+			response.int64(0)
+			response.int64(-1)
+			response.int32(0)
+		}
+		message := response.Bytes()
+		binary.BigEndian.PutUint32(message, uint32(len(message)-4))
+		if _, err := conn.Write(message); err != nil {
+			return
+		}
+	}
+}
+
+// kafkaLines are two lines 5ms apart, encoded in kafkaBatch.
+var kafkaLines = []pushedLine{
+	{time.Unix(1700000000, 0), []byte("a")},
+	{time.Unix(1700000000, 5*int64(time.Millisecond)), []byte("bc")},
+}
+
+const kafkaBatch = "0000000000000000" + // base_offset
+	"00000042" + // batch_length
+	"ffffffff" + // partition_leader_epoch
+	"02" + // magic
+	"cdfe91db" + // crc
+	"0000" + // attributes
+	"00000001" + // last_offset_delta
+	"0000018bcfe56800" + // first_timestamp
+	"0000018bcfe56805" + // max_timestamp
+	"ffffffffffffffff" + "ffff" + "ffffffff" + // producer_id, producer_epoch and base_sequence
+	"00000002" + // records
+	"0e" + "00" + "00" + "00" + "01" + "02" + "61" + "00" +
+	"10" + "00" + "0a" + "02" + "01" + "04" + "6263" + "00"
+
+func TestKafkaCRC32C(t *testing.T) {
+	// The check value of CRC-32C.
+	if sum := crc32.Checksum([]byte("123456789"), crc32c); sum != 0xe3069283 {
+		t.Errorf("CRC-32C of 123456789 = %#x, want 0xe3069283", sum)
+	}
+}
+
+func TestKafkaRecordBatch(t *testing.T) {
+	if batch := hex.EncodeToString(kafkaRecordBatch(kafkaLines)); batch != kafkaBatch {
+		t.Errorf("encoded the batch\n%v\nwant\n%v", batch, kafkaBatch)
+	}
+}
+
+// kafkaMetadataResponse is a metadata response with brokers 1 and 2, where
+// the events topic, having code, has partition 1 led by broker 2, partition
+// 0 by broker 1 and partition 2 without a leader.
+func kafkaMetadataResponse(code string) string {
+	return "\x00\x00\x00\x00" + // throttle_time_ms
+		"\x00\x00\x00\x02" +
+		"\x00\x00\x00\x01" + "\x00\x02b1" + "\x00\x00\x23\x84" + "\xff\xff" +
+		"\x00\x00\x00\x02" + "\x00\x02b2" + "\x00\x00\x23\x85" + "\x00\x01r" +
+		"\x00\x01c" + // cluster_id
+		"\x00\x00\x00\x01" + // controller_id
+		"\x00\x00\x00\x02" +
+		code + "\x00\x06events" + "\x00" + "\x00\x00\x00\x03" +
+		"\x00\x00" + "\x00\x00\x00\x01" + "\x00\x00\x00\x02" + "\x00\x00\x00\x01\x00\x00\x00\x02" + "\x00\x00\x00\x01\x00\x00\x00\x02" +
+		"\x00\x00" + "\x00\x00\x00\x00" + "\x00\x00\x00\x01" + "\x00\x00\x00\x01\x00\x00\x00\x01" + "\x00\x00\x00\x01\x00\x00\x00\x01" +
+		"\x00\x05" + "\x00\x00\x00\x02" + "\xff\xff\xff\xff" + "\x00\x00\x00\x00" + "\x00\x00\x00\x00" +
+		// Partitions of other topics are ignored.
+		"\x00\x00" + "\x00\x05other" + "\x00" + "\x00\x00\x00\x01" +
+		"\x00\x00" + "\x00\x00\x00\x03" + "\x00\x00\x00\x01" + "\x00\x00\x00\x01\x00\x00\x00\x01" + "\x00\x00\x00\x01\x00\x00\x00\x01"
+}
+
+func TestKafkaMetadataParsed(t *testing.T) {
+	sink := NewKafkaSink(nil, "events", Timestamps{}, Batching{Size: 1})
+	if err := sink.parseMetadata([]byte(kafkaMetadataResponse("\x00\x00"))); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[int32]string{1: "b1:9092", 2: "b2:9093"}; !reflect.DeepEqual(sink.addresses, want) {
+		t.Errorf("parsed the brokers %v, want %v", sink.addresses, want)
+	}
+	if want := map[int32]int32{0: 1, 1: 2}; !reflect.DeepEqual(sink.leaders, want) {
+		t.Errorf("parsed the leaders %v, want %v", sink.leaders, want)
+	}
+	if want := []int32{0, 1}; !reflect.DeepEqual(sink.partitions, want) {
+		t.Errorf("parsed the partitions %v, want %v", sink.partitions, want)
+	}
+
+	for _, response := range []string{
+		// UNKNOWN_TOPIC_OR_PARTITION
+		kafkaMetadataResponse("\x00\x03"),
+		kafkaMetadataResponse("\x00\x00")[:len(kafkaMetadataResponse("\x00\x00"))-1],
+		"\x00\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00\x01\x7f\xff",
+	} {
+		sink := NewKafkaSink(nil, "events", Timestamps{}, Batching{Size: 1})
+		if err := sink.parseMetadata([]byte(response)); err == nil || sink.leaders != nil {
+			t.Errorf("parsed %q as the leaders %v", response, sink.leaders)
+		}
+	}
+}
+
+func TestKafkaSinkProduces(t *testing.T) {
+	// NOT_LEADER_OR_FOLLOWER is retried after fetching the metadata again,
+	// CORRUPT_MESSAGE isn't.
+	broker := newKafkaBroker(t, 6, 0, 0, 2)
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	sink := NewKafkaSink([]string{closed.Addr().String(), broker.listener.Addr().String()}, "events", Timestamps{}, Batching{Size: 2})
+	defer sink.Close()
+	for i := 0; i < 2; i++ {
+		if err := sink.flush(kafkaLines); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.flush(kafkaLines); err == nil {
+		t.Error("CORRUPT_MESSAGE ignored")
+	}
+
+	batch, _ := hex.DecodeString(kafkaBatch)
+	metadata := "\x00\x00\x00\x01" + "\x00\x06events" + "\x01"
+	produce := func(partition string) string {
+		return "\xff\xff" + // transactional_id
+			"\x00\x01" + // acks
+			"\x00\x00\x27\x10" + // timeout_ms
+			"\x00\x00\x00\x01" + "\x00\x06events" +
+			"\x00\x00\x00\x01" + partition + "\x00\x00\x00\x4e" + string(batch)
+	}
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	for i, want := range []kafkaRequest{
+		{kafkaMetadata, 4, 1, "sshesame", []byte(metadata)},
+		{kafkaProduce, 3, 2, "sshesame", []byte(produce("\x00\x00\x00\x00"))},
+		{kafkaMetadata, 4, 3, "sshesame", []byte(metadata)},
+		{kafkaProduce, 3, 4, "sshesame", []byte(produce("\x00\x00\x00\x01"))},
+		{kafkaProduce, 3, 5, "sshesame", []byte(produce("\x00\x00\x00\x00"))},
+		{kafkaProduce, 3, 6, "sshesame", []byte(produce("\x00\x00\x00\x01"))},
+	} {
+		if i >= len(broker.requests) {
+			t.Fatalf("received %v requests, want 6", len(broker.requests))
+		}
+		if request := broker.requests[i]; !reflect.DeepEqual(request, want) {
+			t.Errorf("request %v = %v %v %v %v %q, want %v %v %v %v %q", i, request.apiKey, request.apiVersion, request.correlationID, request.clientID, request.body, want.apiKey, want.apiVersion, want.correlationID, want.clientID, want.body)
+		}
+	}
+	if len(broker.requests) != 6 {
+		t.Errorf("received %v requests, want 6", len(broker.requests))
+	}
+}
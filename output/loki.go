@@ -0,0 +1,266 @@
+package output
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"github.com/longkeyy/sshesame/outbound"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// lokiMaxLabelValues bounds the distinct values of every label, further
+	// ones are replaced with lokiOtherValue so that a high-cardinality field
+	// can't create a stream per client.
+	lokiMaxLabelValues = 64
+	// lokiMaxLabelLength is the longest label value kept, longer ones are
+	// unlikely to be low-cardinality.
+	lokiMaxLabelLength = 128
+	lokiOtherValue     = "other"
+	lokiTimeout        = 10 * time.Second
+)
+
+// lokiLabelName is the syntax of Prometheus label names Loki requires.
+var lokiLabelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// lokiHighCardinality are fields that differ for nearly every client or
+// event and would make Loki create a stream for each.
+var lokiHighCardinality = map[string]bool{
+	"client": true, "command": true, "fingerprint": true, "password": true,
+	"payload": true, "public_key": true, "seq": true, "session_id": true, "time": true,
+	"token": true, "user": true,
+}
+
+// LokiLabels are the event fields used as Loki stream labels, event standing
+// for the event's message. It implements flag.Value, setting a comma-separated
+// list.
+type LokiLabels []string
+
+// DefaultLokiLabels returns the labels streams are split by if none are set.
+func DefaultLokiLabels() LokiLabels {
+	return LokiLabels{"event", "category", "severity"}
+}
+
+func (labels *LokiLabels) String() string {
+	if labels == nil {
+		return ""
+	}
+	return strings.Join(*labels, ",")
+}
+
+// Set implements flag.Value.
+func (labels *LokiLabels) Set(text string) error {
+	parsed := LokiLabels{}
+	for _, label := range strings.Split(text, ",") {
+		if !lokiLabelName.MatchString(label) || label == "job" {
+			return fmt.Errorf("invalid Loki label %q", label)
+		}
+		if lokiHighCardinality[label] {
+			return fmt.Errorf("field %q has too many distinct values to be a Loki label", label)
+		}
+		parsed = append(parsed, label)
+	}
+	*labels = parsed
+	return nil
+}
+
+// LokiSink pushes events to Grafana Loki's HTTP push API in gzip-compressed
+// batches, as the JSON lines log_file would contain, in streams labelled by
+// a few low-cardinality fields. Failed pushes are retried with exponential
+// backoff, then the batch is dropped.
+type LokiSink struct {
+	url       string
+	labels    LokiLabels
+	formatter log.Formatter
+	batching  Batching
+	client    *http.Client
+
+	mu      sync.Mutex
+	streams map[string]*lokiStream
+	pending int
+	timer   *time.Timer
+	// values are the distinct values seen for every label.
+	values map[string]map[string]bool
+	// err is the error of the last push triggered by the timer, returned by
+	// the next Emit.
+	err error
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// NewLokiSink returns a sink pushing events to the push API at url, e.g.
+// http://localhost:3100/loki/api/v1/push, batched like a log file.
+func NewLokiSink(url string, labels LokiLabels, timestamps Timestamps, batching Batching) *LokiSink {
+	return &LokiSink{
+		url:       url,
+		labels:    labels,
+		formatter: timestamps.Formatter(true),
+		batching:  batching,
+		client:    outbound.HTTPClient(lokiTimeout),
+		streams:   map[string]*lokiStream{},
+		values:    map[string]map[string]bool{},
+	}
+}
+
+// Emit implements Sink.
+func (sink *LokiSink) Emit(event Event) error {
+	line, err := sink.formatter.Format(&log.Entry{
+		Logger:  log.StandardLogger(),
+		Data:    event.Fields,
+		Time:    event.Time,
+		Level:   event.Level,
+		Message: event.Message,
+	})
+	if err != nil {
+		return err
+	}
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if err := sink.err; err != nil {
+		sink.err = nil
+		return err
+	}
+	labels := sink.streamLabels(event)
+	key := streamKey(labels)
+	stream, ok := sink.streams[key]
+	if !ok {
+		stream = &lokiStream{Stream: labels}
+		sink.streams[key] = stream
+	}
+	stream.Values = append(stream.Values, [2]string{
+		strconv.FormatInt(event.Time.UnixNano(), 10),
+		string(bytes.TrimRight(line, "\n")),
+	})
+	sink.pending++
+	if sink.pending >= sink.batching.Size {
+		return sink.flush()
+	}
+	if sink.timer == nil && sink.batching.FlushInterval > 0 {
+		sink.timer = time.AfterFunc(sink.batching.FlushInterval, func() {
+			sink.mu.Lock()
+			defer sink.mu.Unlock()
+			sink.timer = nil
+			sink.err = sink.flush()
+		})
+	}
+	return nil
+}
+
+// streamLabels returns the labels of the stream of event. sink.mu must be
+// held.
+func (sink *LokiSink) streamLabels(event Event) map[string]string {
+	labels := map[string]string{"job": "sshesame"}
+	for _, label := range sink.labels {
+		var value string
+		if label == "event" {
+			value = event.Message
+		} else if field, ok := event.Fields[label]; ok {
+			value = fmt.Sprint(field)
+		}
+		if value == "" {
+			continue
+		}
+		seen := sink.values[label]
+		if seen == nil {
+			seen = map[string]bool{}
+			sink.values[label] = seen
+		}
+		if !seen[value] {
+			if len(seen) >= lokiMaxLabelValues || len(value) > lokiMaxLabelLength {
+				if !seen[lokiOtherValue] {
+					errorLog.WithFields(log.Fields{
+						"sink":  "loki",
+						"label": label,
+					}).Warning("Loki label has too many or too long values, labelling further ones other")
+				}
+				value = lokiOtherValue
+			}
+			seen[value] = true
+		}
+		labels[label] = value
+	}
+	return labels
+}
+
+// streamKey identifies the stream with labels.
+func streamKey(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for name, value := range labels {
+		pairs = append(pairs, name+"="+strconv.Quote(value))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// flush pushes the buffered batch. sink.mu must be held.
+func (sink *LokiSink) flush() error {
+	if sink.timer != nil {
+		sink.timer.Stop()
+		sink.timer = nil
+	}
+	if sink.pending == 0 {
+		return nil
+	}
+	streams := make([]*lokiStream, 0, len(sink.streams))
+	for _, stream := range sink.streams {
+		streams = append(streams, stream)
+	}
+	sink.streams = map[string]*lokiStream{}
+	sink.pending = 0
+	body, err := json.Marshal(struct {
+		Streams []*lokiStream `json:"streams"`
+	}{streams})
+	if err != nil {
+		return err
+	}
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	if _, err := gzipWriter.Write(body); err != nil {
+		return err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return err
+	}
+	return retryPush(func() (bool, error) {
+		return sink.push(compressed.Bytes())
+	})
+}
+
+// push sends a compressed batch once, reporting whether a failure is worth
+// retrying.
+func (sink *LokiSink) push(body []byte) (bool, error) {
+	request, err := http.NewRequest(http.MethodPost, sink.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Content-Encoding", "gzip")
+	response, err := sink.client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	response.Body.Close()
+	if response.StatusCode/100 == 2 {
+		return false, nil
+	}
+	retry := response.StatusCode == http.StatusTooManyRequests || response.StatusCode/100 == 5
+	return retry, fmt.Errorf("Loki responded %v", response.Status)
+}
+
+// Close implements Sink, pushing the partial batch.
+func (sink *LokiSink) Close() error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	return sink.flush()
+}
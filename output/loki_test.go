@@ -0,0 +1,146 @@
+package output
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lokiServer is a mock of Loki's push API, answering pushes with the next of
+// its statuses, or 204 once they're used up.
+type lokiServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	attempts int
+	streams  []lokiStream
+}
+
+func newLokiServer(t *testing.T, statuses ...int) *lokiServer {
+	server := &lokiServer{statuses: statuses}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		server.attempts++
+		if len(server.statuses) != 0 {
+			status := server.statuses[0]
+			server.statuses = server.statuses[1:]
+			writer.WriteHeader(status)
+			return
+		}
+		if request.URL.Path != "/loki/api/v1/push" || request.Header.Get("Content-Type") != "application/json" || request.Header.Get("Content-Encoding") != "gzip" {
+			t.Errorf("pushed to %v with %v", request.URL.Path, request.Header)
+		}
+		reader, err := gzip.NewReader(request.Body)
+		if err != nil {
+			t.Errorf("pushed a body that isn't gzip: %v", err)
+			return
+		}
+		var body struct{ Streams []lokiStream }
+		if err := json.NewDecoder(reader).Decode(&body); err != nil {
+			t.Errorf("pushed a body that isn't JSON: %v", err)
+			return
+		}
+		server.streams = append(server.streams, body.Streams...)
+		writer.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestLokiStreams(t *testing.T) {
+	server := newLokiServer(t)
+	sink := NewLokiSink(server.URL+"/loki/api/v1/push", DefaultLokiLabels(), Timestamps{}, Batching{Size: 3})
+	at := time.Date(2026, time.October, 4, 1, 29, 30, 0, time.UTC)
+	for _, event := range []Event{
+		{Time: at, Level: log.InfoLevel, Message: "Command executed", Fields: log.Fields{"client": "192.0.2.1:50000", "command": "uname -a", "category": "detection_attempt"}},
+		{Time: at.Add(time.Second), Level: log.InfoLevel, Message: "Command executed", Fields: log.Fields{"client": "192.0.2.2:50000", "command": "id", "category": "detection_attempt"}},
+		{Time: at, Level: log.InfoLevel, Message: "Client connected", Fields: log.Fields{"client": "192.0.2.1:50000"}},
+	} {
+		if err := sink.Emit(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	streams := map[string]lokiStream{}
+	for _, stream := range server.streams {
+		streams[streamKey(stream.Stream)] = stream
+	}
+	commands := streams[streamKey(map[string]string{"job": "sshesame", "event": "Command executed", "category": "detection_attempt"})]
+	connections := streams[streamKey(map[string]string{"job": "sshesame", "event": "Client connected"})]
+	if len(streams) != 2 || len(commands.Values) != 2 || len(connections.Values) != 1 {
+		t.Fatalf("pushed streams %+v, want the commands apart from the connection", server.streams)
+	}
+	if commands.Values[0][0] != strconv.FormatInt(at.UnixNano(), 10) {
+		t.Errorf("pushed time %v, want %v in nanoseconds", commands.Values[0][0], at)
+	}
+	var line map[string]interface{}
+	if err := json.Unmarshal([]byte(commands.Values[0][1]), &line); err != nil {
+		t.Fatalf("pushed line %q isn't JSON: %v", commands.Values[0][1], err)
+	}
+	if line["msg"] != "Command executed" || line["command"] != "uname -a" || line["client"] != "192.0.2.1:50000" {
+		t.Errorf("pushed line %q, want the event as log_file writes it", commands.Values[0][1])
+	}
+	// The partial batch is pushed on close.
+	if err := sink.Emit(Event{Time: at, Message: "Client disconnected", Fields: log.Fields{}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil || len(server.streams) != 3 {
+		t.Errorf("Close = %v, pushed %v streams, want the last event pushed", err, len(server.streams))
+	}
+}
+
+func TestLokiLabelCardinality(t *testing.T) {
+	quietErrors(t)
+	server := newLokiServer(t)
+	sink := NewLokiSink(server.URL+"/loki/api/v1/push", LokiLabels{"event"}, Timestamps{}, Batching{})
+	for i := 0; i < 2*lokiMaxLabelValues; i++ {
+		if err := sink.Emit(Event{Message: fmt.Sprintf("Event %v", i), Fields: log.Fields{}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	values := map[string]bool{}
+	for _, stream := range server.streams {
+		values[stream.Stream["event"]] = true
+	}
+	if len(values) > lokiMaxLabelValues+1 || !values[lokiOtherValue] {
+		t.Errorf("labelled %v distinct events, want at most %v and the rest %v", len(values), lokiMaxLabelValues, lokiOtherValue)
+	}
+}
+
+func TestLokiRetries(t *testing.T) {
+	for _, test := range []struct {
+		statuses []int
+		attempts int
+		pushed   bool
+	}{
+		{[]int{http.StatusServiceUnavailable, http.StatusTooManyRequests}, 3, true},
+		// Bad requests won't get better.
+		{[]int{http.StatusBadRequest}, 1, false},
+	} {
+		server := newLokiServer(t, test.statuses...)
+		sink := NewLokiSink(server.URL+"/loki/api/v1/push", DefaultLokiLabels(), Timestamps{}, Batching{})
+		err := sink.Emit(Event{Message: "Client connected", Fields: log.Fields{}})
+		if (err == nil) != test.pushed || server.attempts != test.attempts {
+			t.Errorf("answering %v: Emit = %v after %v attempts, want %v", test.statuses, err, server.attempts, test.attempts)
+		}
+	}
+}
+
+func TestLokiLabelsSet(t *testing.T) {
+	var labels LokiLabels
+	if err := labels.Set("event,client_category,country"); err != nil || labels.String() != "event,client_category,country" {
+		t.Errorf("Set = %v, labels %v", err, labels)
+	}
+	for _, text := range []string{"user", "client", "job", "client-category", ""} {
+		if err := labels.Set(text); err == nil {
+			t.Errorf("Set(%q) succeeded", text)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricsMaxLabelValues bounds the distinct values of labels clients choose,
+// like channel types, further ones are counted as metricsOtherValue.
+const (
+	metricsMaxLabelValues = 64
+	metricsOtherValue     = "other"
+)
+
+// sessionDurationBuckets are the upper bounds in seconds of the buckets of
+// the session duration histogram.
+var sessionDurationBuckets = []float64{1, 5, 15, 60, 300, 900, 3600}
+
+// authResults are the results of authentication attempts by the end of the
+// message of their events.
+var authResults = map[string]string{
+	"accepted":            "accepted",
+	"rejected":            "rejected",
+	"partially succeeded": "partial",
+}
+
+// handshakeFailures are the reasons of handshake failures by the message of
+// their events, matched by prefix as some are logged with their error.
+var handshakeFailures = map[string]string{
+	"Banner grab detected":               "banner_grab",
+	"Non-SSH probe received":             "non_ssh",
+	"Failed to establish SSH connection": "ssh_error",
+	"Failed to complete TLS handshake":   "tls_error",
+}
+
+// capturedBytes are the kinds of data captured by the message of their
+// events, and the fields holding their size.
+var capturedBytes = map[string][2]string{
+	"File uploaded":            {"upload", "size"},
+	"Session recorded":         {"recording", "bytes"},
+	"Subsystem input captured": {"subsystem", "bytes"},
+}
+
+// Metric is a metric read when metrics are written, for those not derived
+// from events.
+type Metric struct {
+	Name, Help string
+	// Type is counter or gauge.
+	Type  string
+	Value func() float64
+}
+
+// MetricsSink derives Prometheus metrics from events: authentication
+// attempts by method and result, channels by type, handshake failures by
+// reason, bytes captured by kind and a histogram of session durations.
+type MetricsSink struct {
+	// Metrics are written along with the ones derived from events.
+	Metrics []Metric
+	// Dropped, if set, returns the number of events dropped by every sink.
+	Dropped func() map[string]uint64
+
+	mu         sync.Mutex
+	messages   map[string]uint64
+	auth       map[[2]string]uint64
+	channels   map[string]uint64
+	handshakes map[string]uint64
+	captured   map[string]float64
+	// durations counts sessions by bucket, the last one being +Inf.
+	durations   []uint64
+	durationSum float64
+}
+
+// NewMetricsSink returns a sink with every metric at zero.
+func NewMetricsSink() *MetricsSink {
+	return &MetricsSink{
+		messages:   map[string]uint64{},
+		auth:       map[[2]string]uint64{},
+		channels:   map[string]uint64{},
+		handshakes: map[string]uint64{},
+		captured:   map[string]float64{},
+		durations:  make([]uint64, len(sessionDurationBuckets)+1),
+	}
+}
+
+// Emit implements Sink.
+func (sink *MetricsSink) Emit(event Event) error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	sink.messages[event.Message]++
+	if parts := strings.SplitN(event.Message, " authentication ", 2); len(parts) == 2 {
+		if result, ok := authResults[parts[1]]; ok {
+			method := strings.NewReplacer(" ", "_", "-", "_").Replace(strings.ToLower(parts[0]))
+			sink.auth[[2]string{method, result}]++
+		}
+	}
+	if event.Message == "Authentication with disabled method rejected" {
+		sink.auth[[2]string{"disabled", "rejected"}]++
+	}
+	if event.Message == "Channel requested" {
+		channelType := fmt.Sprint(event.Fields["channel"])
+		if _, ok := sink.channels[channelType]; !ok && len(sink.channels) >= metricsMaxLabelValues {
+			channelType = metricsOtherValue
+		}
+		sink.channels[channelType]++
+	}
+	for prefix, reason := range handshakeFailures {
+		if strings.HasPrefix(event.Message, prefix) {
+			sink.handshakes[reason]++
+		}
+	}
+	if captured, ok := capturedBytes[event.Message]; ok {
+		if size, err := strconv.ParseFloat(fmt.Sprint(event.Fields[captured[1]]), 64); err == nil {
+			sink.captured[captured[0]] += size
+		}
+	}
+	if event.Message == "Client disconnected" {
+		if duration, err := time.ParseDuration(fmt.Sprint(event.Fields["duration"])); err == nil {
+			seconds := duration.Seconds()
+			bucket := sort.SearchFloat64s(sessionDurationBuckets, seconds)
+			sink.durations[bucket]++
+			sink.durationSum += seconds
+		}
+	}
+	return nil
+}
+
+// Count returns the number of events with message emitted so far.
+func (sink *MetricsSink) Count(message string) uint64 {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	return sink.messages[message]
+}
+
+// WriteMetrics writes the metrics in the Prometheus text exposition format.
+func (sink *MetricsSink) WriteMetrics(writer io.Writer) error {
+	var text strings.Builder
+	for _, metric := range sink.Metrics {
+		writeMetricHeader(&text, metric.Name, metric.Help, metric.Type)
+		fmt.Fprintf(&text, "%v %v\n", metric.Name, formatMetricValue(metric.Value()))
+	}
+	if sink.Dropped != nil {
+		writeLabelledCounter(&text, "sshesame_events_dropped_total", "Events dropped by sinks whose buffer was full, by sink.", "sink", sink.Dropped())
+	}
+	sink.mu.Lock()
+	writeMetricHeader(&text, "sshesame_auth_attempts_total", "Authentication attempts by method and result.", "counter")
+	authKeys := make([][2]string, 0, len(sink.auth))
+	for key := range sink.auth {
+		authKeys = append(authKeys, key)
+	}
+	sort.Slice(authKeys, func(i, j int) bool {
+		return authKeys[i][0] < authKeys[j][0] || authKeys[i][0] == authKeys[j][0] && authKeys[i][1] < authKeys[j][1]
+	})
+	for _, key := range authKeys {
+		fmt.Fprintf(&text, "sshesame_auth_attempts_total{method=%v,result=%v} %v\n", quoteLabelValue(key[0]), quoteLabelValue(key[1]), sink.auth[key])
+	}
+	writeLabelledCounter(&text, "sshesame_channels_total", "Channels requested by type.", "type", sink.channels)
+	writeLabelledCounter(&text, "sshesame_handshake_failures_total", "Connections that didn't complete the SSH handshake, by reason.", "reason", sink.handshakes)
+	writeMetricHeader(&text, "sshesame_captured_bytes_total", "Bytes of files, recordings and subsystem input captured, by kind.", "counter")
+	for _, kind := range []string{"recording", "subsystem", "upload"} {
+		fmt.Fprintf(&text, "sshesame_captured_bytes_total{kind=%v} %v\n", quoteLabelValue(kind), formatMetricValue(sink.captured[kind]))
+	}
+	writeMetricHeader(&text, "sshesame_session_duration_seconds", "Durations of SSH connections.", "histogram")
+	cumulative := uint64(0)
+	for i, count := range sink.durations {
+		cumulative += count
+		bound := "+Inf"
+		if i < len(sessionDurationBuckets) {
+			bound = formatMetricValue(sessionDurationBuckets[i])
+		}
+		fmt.Fprintf(&text, "sshesame_session_duration_seconds_bucket{le=%v} %v\n", quoteLabelValue(bound), cumulative)
+	}
+	fmt.Fprintf(&text, "sshesame_session_duration_seconds_sum %v\n", formatMetricValue(sink.durationSum))
+	fmt.Fprintf(&text, "sshesame_session_duration_seconds_count %v\n", cumulative)
+	sink.mu.Unlock()
+	_, err := io.WriteString(writer, text.String())
+	return err
+}
+
+func writeMetricHeader(text *strings.Builder, name, help, metricType string) {
+	fmt.Fprintf(text, "# HELP %v %v\n# TYPE %v %v\n", name, help, name, metricType)
+}
+
+func writeLabelledCounter(text *strings.Builder, name, help, label string, counts map[string]uint64) {
+	writeMetricHeader(text, name, help, "counter")
+	values := make([]string, 0, len(counts))
+	for value := range counts {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	for _, value := range values {
+		fmt.Fprintf(text, "%v{%v=%v} %v\n", name, label, quoteLabelValue(value), counts[value])
+	}
+}
+
+// quoteLabelValue returns value as a quoted label value, valid UTF-8 with
+// backslashes, double quotes and line breaks escaped, the only escapes of the
+// exposition format.
+func quoteLabelValue(value string) string {
+	value = strings.ToValidUTF8(value, "\uFFFD")
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value) + `"`
+}
+
+func formatMetricValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Close implements Sink.
+func (sink *MetricsSink) Close() error {
+	return nil
+}
@@ -0,0 +1,119 @@
+package output
+
+import (
+	log "github.com/sirupsen/logrus"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// metricsSample matches the lines of samples of the text exposition format,
+// whose label values only escape backslashes, double quotes and line breaks.
+var metricsSample = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*(\{[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\\n]|\\[\\"n])*"(?:,[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\\n]|\\[\\"n])*")*\})? (?:[0-9.e+-]+|\+Inf)$`)
+
+// scrape returns the samples sink writes by metric and labels, checking the
+// exposition is valid.
+func scrape(t *testing.T, sink *MetricsSink) map[string]string {
+	t.Helper()
+	var text strings.Builder
+	if err := sink.WriteMetrics(&text); err != nil {
+		t.Fatal(err)
+	}
+	if !utf8.ValidString(text.String()) {
+		t.Errorf("metrics aren't valid UTF-8: %q", text.String())
+	}
+	samples := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSuffix(text.String(), "\n"), "\n") {
+		if strings.HasPrefix(line, "# HELP ") || strings.HasPrefix(line, "# TYPE ") {
+			continue
+		}
+		if !metricsSample.MatchString(line) {
+			t.Errorf("invalid sample %q", line)
+			continue
+		}
+		split := strings.LastIndex(line, " ")
+		samples[line[:split]] = line[split+1:]
+	}
+	return samples
+}
+
+func TestMetricsDerived(t *testing.T) {
+	sink := NewMetricsSink()
+	sink.Metrics = []Metric{{Name: "sshesame_clients", Help: "Clients connected.", Type: "gauge", Value: func() float64 { return 3 }}}
+	for _, event := range []Event{
+		{Message: "Password authentication rejected"},
+		{Message: "Password authentication accepted"},
+		{Message: "Keyboard-interactive authentication partially succeeded"},
+		{Message: "Channel requested", Fields: log.Fields{"channel": "session"}},
+		{Message: "Non-SSH probe received"},
+		{Message: "File uploaded", Fields: log.Fields{"size": 1024}},
+		{Message: "Client disconnected", Fields: log.Fields{"duration": (90 * time.Second).String()}},
+	} {
+		event.Level = log.InfoLevel
+		sink.Emit(event)
+	}
+	samples := scrape(t, sink)
+	for sample, want := range map[string]string{
+		"sshesame_clients": "3",
+		`sshesame_auth_attempts_total{method="password",result="rejected"}`:            "1",
+		`sshesame_auth_attempts_total{method="password",result="accepted"}`:            "1",
+		`sshesame_auth_attempts_total{method="keyboard_interactive",result="partial"}`: "1",
+		`sshesame_channels_total{type="session"}`:                                      "1",
+		`sshesame_handshake_failures_total{reason="non_ssh"}`:                          "1",
+		`sshesame_captured_bytes_total{kind="upload"}`:                                 "1024",
+		`sshesame_captured_bytes_total{kind="recording"}`:                              "0",
+		`sshesame_session_duration_seconds_bucket{le="60"}`:                            "0",
+		`sshesame_session_duration_seconds_bucket{le="300"}`:                           "1",
+		`sshesame_session_duration_seconds_bucket{le="+Inf"}`:                          "1",
+		"sshesame_session_duration_seconds_sum":                                        "90",
+		"sshesame_session_duration_seconds_count":                                      "1",
+	} {
+		if samples[sample] != want {
+			t.Errorf("%v = %q, want %v", sample, samples[sample], want)
+		}
+	}
+	if sink.Count("Channel requested") != 1 {
+		t.Errorf("counted %v channel requests", sink.Count("Channel requested"))
+	}
+}
+
+func TestMetricsLabelsEscaped(t *testing.T) {
+	sink := NewMetricsSink()
+	// Clients choose channel types.
+	for _, channelType := range []string{`quote"back\slash`, "line\nbreak", "caf\xe9", "\x1b[31mred\x07", "tab\there", "ünï©ödé"} {
+		sink.Emit(Event{Level: log.InfoLevel, Message: "Channel requested", Fields: log.Fields{"channel": channelType}})
+	}
+	samples := scrape(t, sink)
+	for _, sample := range []string{
+		`sshesame_channels_total{type="quote\"back\\slash"}`,
+		`sshesame_channels_total{type="line\nbreak"}`,
+		"sshesame_channels_total{type=\"caf�\"}",
+		// Other control characters needn't be escaped, and can't be.
+		"sshesame_channels_total{type=\"\x1b[31mred\x07\"}",
+		"sshesame_channels_total{type=\"tab\there\"}",
+		`sshesame_channels_total{type="ünï©ödé"}`,
+	} {
+		if samples[sample] != "1" {
+			t.Errorf("%q = %q, want 1", sample, samples[sample])
+		}
+	}
+}
+
+func TestMetricsLabelValuesBounded(t *testing.T) {
+	sink := NewMetricsSink()
+	for i := 0; i < metricsMaxLabelValues+10; i++ {
+		sink.Emit(Event{Level: log.InfoLevel, Message: "Channel requested", Fields: log.Fields{"channel": strings.Repeat("x", i+1)}})
+	}
+	samples := scrape(t, sink)
+	channels := 0
+	for sample := range samples {
+		if strings.HasPrefix(sample, "sshesame_channels_total{") {
+			channels++
+		}
+	}
+	if channels != metricsMaxLabelValues+1 || samples[`sshesame_channels_total{type="other"}`] != "10" {
+		t.Errorf("wrote %v channel types, %v of them other", channels, samples[`sshesame_channels_total{type="other"}`])
+	}
+}
@@ -0,0 +1,298 @@
+// Package output delivers logged events to any number of sinks.
+package output
+
+import (
+	"fmt"
+	"github.com/longkeyy/sshesame/geoip"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Event is a single logged event.
+type Event struct {
+	Time    time.Time
+	Level   log.Level
+	Message string
+	Fields  log.Fields
+}
+
+// Sink receives events. Emit is only ever called from a single goroutine per
+// sink, so sinks needn't be safe for concurrent use, and it should return
+// promptly: the sink's buffer fills up while it blocks. An event Emit returns
+// an error for is dropped, the error being reported. Close is called exactly
+// once, after all pending events have been emitted and never concurrently
+// with Emit, and must flush anything the sink still holds.
+type Sink interface {
+	Emit(event Event) error
+	Close() error
+}
+
+// errorLog reports problems with sinks without feeding them back into the
+// dispatcher.
+var errorLog = &log.Logger{
+	Out:       os.Stderr,
+	Formatter: new(log.TextFormatter),
+	Hooks:     make(log.LevelHooks),
+	Level:     log.WarnLevel,
+}
+
+type queue struct {
+	name string
+	sink Sink
+	// unfiltered queues receive events suppressed by the filter too.
+	unfiltered bool
+	// raw queues receive events before they're scrubbed.
+	raw     bool
+	policy  Policy
+	events  chan Event
+	dropped uint64
+	done    chan struct{}
+}
+
+func (queue *queue) run() {
+	defer close(queue.done)
+	for event := range queue.events {
+		if err := queue.sink.Emit(event); err != nil {
+			errorLog.WithFields(log.Fields{
+				"sink": queue.name,
+			}).Warning("Failed to emit event:", err.Error())
+		}
+	}
+	if err := queue.sink.Close(); err != nil {
+		errorLog.WithFields(log.Fields{
+			"sink": queue.name,
+		}).Warning("Failed to close sink:", err.Error())
+	}
+}
+
+// Dispatcher is a logrus hook that fans every logged entry out to its sinks.
+// Each sink is fed from its own buffer, so a slow or failing sink neither
+// delays the others nor the goroutine that logged the event; events that don't
+// fit in a sink's buffer are dropped and counted, unless its buffering says
+// otherwise.
+type Dispatcher struct {
+	// Location is the time zone event times are converted to, if set.
+	Location *time.Location
+	// Severities, if set, add a severity field to every event.
+	Severities Severities
+	// Filter suppresses events for every sink not added with AddMetrics.
+	Filter Filter
+	// Tags, if set, add the fields of the client of every event.
+	Tags *Tags
+	// GeoIP, if set, adds the country, city, autonomous system and
+	// organization of the client of every event.
+	GeoIP *geoip.Reader
+	// Scrubber, if set, redacts sensitive data from events for every sink
+	// not added with AddRaw.
+	Scrubber *Scrubber
+	// MaxFieldLength, if set, is the most bytes of a string field logged to
+	// every sink not added with AddRaw, longer ones are truncated.
+	MaxFieldLength int
+	// Buffering, if set, overrides the policy and buffer size of sinks by
+	// name.
+	Buffering Bufferings
+
+	mu     sync.RWMutex
+	queues []*queue
+	closed bool
+}
+
+// Add starts delivering events to sink, buffering up to bufferSize of them.
+func (dispatcher *Dispatcher) Add(name string, sink Sink, bufferSize int) {
+	dispatcher.add(name, sink, bufferSize, false, false)
+}
+
+// AddRaw is like Add, but sink receives events before they're scrubbed, so
+// it should only be readable by those allowed to see sensitive data.
+func (dispatcher *Dispatcher) AddRaw(name string, sink Sink, bufferSize int) {
+	dispatcher.add(name, sink, bufferSize, false, true)
+}
+
+// AddMetrics is like Add, but sink also receives the events suppressed by the
+// filter, so they still count toward metrics.
+func (dispatcher *Dispatcher) AddMetrics(name string, sink Sink, bufferSize int) {
+	dispatcher.add(name, sink, bufferSize, true, false)
+}
+
+func (dispatcher *Dispatcher) add(name string, sink Sink, bufferSize int, unfiltered, raw bool) {
+	buffering := dispatcher.Buffering[name]
+	if buffering.Policy == "" {
+		buffering.Policy = DropNewest
+	}
+	if buffering.Size != 0 {
+		bufferSize = buffering.Size
+	}
+	queue := &queue{
+		name:       name,
+		sink:       sink,
+		unfiltered: unfiltered,
+		raw:        raw,
+		policy:     buffering.Policy,
+		events:     make(chan Event, bufferSize),
+		done:       make(chan struct{}),
+	}
+	go queue.run()
+	dispatcher.mu.Lock()
+	defer dispatcher.mu.Unlock()
+	dispatcher.queues = append(dispatcher.queues, queue)
+}
+
+// Levels implements logrus.Hook.
+func (dispatcher *Dispatcher) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire implements logrus.Hook.
+func (dispatcher *Dispatcher) Fire(entry *log.Entry) error {
+	fields := make(log.Fields, len(entry.Data))
+	for key, value := range entry.Data {
+		fields[key] = value
+	}
+	if dispatcher.Tags != nil {
+		dispatcher.Tags.add(fields)
+	}
+	if dispatcher.GeoIP != nil {
+		addLocation(dispatcher.GeoIP, fields)
+	}
+	eventTime := entry.Time
+	if dispatcher.Location != nil {
+		eventTime = eventTime.In(dispatcher.Location)
+	}
+	event := Event{
+		Time:    eventTime,
+		Level:   entry.Level,
+		Message: entry.Message,
+		Fields:  fields,
+	}
+	if dispatcher.Severities != nil {
+		fields["severity"] = dispatcher.Severities.Severity(event)
+	}
+	if dispatcher.Scrubber == nil && dispatcher.MaxFieldLength <= 0 {
+		dispatcher.Emit(event)
+		return nil
+	}
+	redacted := event
+	redacted.Fields = make(log.Fields, len(fields))
+	for key, value := range fields {
+		redacted.Fields[key] = value
+	}
+	if dispatcher.Scrubber != nil && dispatcher.Scrubber.scrub(redacted.Fields) {
+		redacted.Fields["scrubbed"] = true
+	}
+	if dispatcher.MaxFieldLength > 0 && truncate(redacted.Fields, dispatcher.MaxFieldLength) {
+		redacted.Fields["truncated"] = true
+	}
+	dispatcher.emit(redacted, event)
+	return nil
+}
+
+// addLocation adds the location of the client of an event to its fields,
+// without replacing any.
+func addLocation(reader *geoip.Reader, fields log.Fields) {
+	var ip net.IP
+	switch client := fields["client"].(type) {
+	case *net.TCPAddr:
+		ip = client.IP
+	case nil:
+		return
+	default:
+		host, _, err := net.SplitHostPort(fmt.Sprint(client))
+		if err != nil {
+			return
+		}
+		ip = net.ParseIP(host)
+	}
+	if ip == nil {
+		return
+	}
+	for key, value := range reader.Lookup(ip).Fields() {
+		if _, ok := fields[key]; !ok {
+			fields[key] = value
+		}
+	}
+}
+
+// Emit queues event for delivery to every sink.
+func (dispatcher *Dispatcher) Emit(event Event) {
+	dispatcher.emit(event, event)
+}
+
+// emit queues event for delivery to every sink, but raw to raw sinks.
+func (dispatcher *Dispatcher) emit(event, raw Event) {
+	dispatcher.mu.RLock()
+	defer dispatcher.mu.RUnlock()
+	if dispatcher.closed {
+		return
+	}
+	pass := dispatcher.Filter.Pass(raw)
+	for _, queue := range dispatcher.queues {
+		if !pass && !queue.unfiltered {
+			continue
+		}
+		queued := event
+		if queue.raw {
+			queued = raw
+		}
+		queue.push(queued)
+	}
+}
+
+// push buffers event for the sink of queue according to its policy.
+func (queue *queue) push(event Event) {
+	switch queue.policy {
+	case Block:
+		queue.events <- event
+	case DropOldest:
+		for {
+			select {
+			case queue.events <- event:
+				return
+			default:
+			}
+			select {
+			case <-queue.events:
+				atomic.AddUint64(&queue.dropped, 1)
+			default:
+			}
+		}
+	default:
+		select {
+		case queue.events <- event:
+		default:
+			atomic.AddUint64(&queue.dropped, 1)
+		}
+	}
+}
+
+// Dropped returns the number of events that were dropped for each sink.
+func (dispatcher *Dispatcher) Dropped() map[string]uint64 {
+	dispatcher.mu.RLock()
+	defer dispatcher.mu.RUnlock()
+	dropped := map[string]uint64{}
+	for _, queue := range dispatcher.queues {
+		dropped[queue.name] = atomic.LoadUint64(&queue.dropped)
+	}
+	return dropped
+}
+
+// Close delivers all queued events and closes every sink.
+func (dispatcher *Dispatcher) Close() {
+	dispatcher.mu.Lock()
+	if dispatcher.closed {
+		dispatcher.mu.Unlock()
+		return
+	}
+	dispatcher.closed = true
+	queues := dispatcher.queues
+	dispatcher.mu.Unlock()
+	for _, queue := range queues {
+		close(queue.events)
+	}
+	for _, queue := range queues {
+		<-queue.done
+	}
+}
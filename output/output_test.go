@@ -0,0 +1,126 @@
+package output
+
+import (
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingSink records the events it emits.
+type recordingSink struct {
+	mu     sync.Mutex
+	events []Event
+	closed bool
+}
+
+func (sink *recordingSink) Emit(event Event) error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	sink.events = append(sink.events, event)
+	return nil
+}
+
+func (sink *recordingSink) Close() error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	sink.closed = true
+	return nil
+}
+
+// messages returns the messages of the events recorded.
+func (sink *recordingSink) messages() []string {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	messages := make([]string, len(sink.events))
+	for i, event := range sink.events {
+		messages[i] = event.Message
+	}
+	return messages
+}
+
+// quietErrors discards the errors sinks report for the rest of the test.
+func quietErrors(t *testing.T) {
+	out := errorLog.Out
+	errorLog.Out = ioutil.Discard
+	t.Cleanup(func() { errorLog.Out = out })
+}
+
+func TestDispatcherFansOut(t *testing.T) {
+	quietErrors(t)
+	dispatcher := &Dispatcher{}
+	first, second := &recordingSink{}, &recordingSink{}
+	failing := &failingSink{err: errors.New("connection refused")}
+	dispatcher.Add("first", first, 16)
+	dispatcher.Add("failing", failing, 16)
+	dispatcher.Add("second", second, 16)
+	dispatcher.Emit(Event{Time: time.Now(), Level: log.InfoLevel, Message: "Client connected"})
+	dispatcher.Emit(Event{Time: time.Now(), Level: log.InfoLevel, Message: "Client disconnected"})
+	dispatcher.Close()
+	for name, sink := range map[string]*recordingSink{"first": first, "second": second} {
+		if messages := sink.messages(); len(messages) != 2 || messages[0] != "Client connected" || messages[1] != "Client disconnected" {
+			t.Errorf("sink %v received %q, want both events in order", name, messages)
+		}
+		if !sink.closed {
+			t.Errorf("sink %v wasn't closed", name)
+		}
+	}
+}
+
+// blockingSink blocks emitting events until release is closed.
+type blockingSink struct {
+	release chan struct{}
+}
+
+func (sink blockingSink) Emit(event Event) error {
+	<-sink.release
+	return nil
+}
+
+func (sink blockingSink) Close() error {
+	return nil
+}
+
+func TestDispatcherIsolatesSlowSinks(t *testing.T) {
+	dispatcher := &Dispatcher{}
+	slow := blockingSink{make(chan struct{})}
+	fast := &recordingSink{}
+	dispatcher.Add("slow", slow, 1)
+	dispatcher.Add("fast", fast, 16)
+	emitted := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			dispatcher.Emit(Event{Message: "Command executed"})
+		}
+		close(emitted)
+	}()
+	select {
+	case <-emitted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("emitting events blocked on a slow sink")
+	}
+	close(slow.release)
+	dispatcher.Close()
+	if messages := fast.messages(); len(messages) != 10 {
+		t.Errorf("fast sink received %v events, want 10", len(messages))
+	}
+	if dropped := dispatcher.Dropped()["slow"]; dropped == 0 {
+		t.Error("no events dropped for the slow sink")
+	}
+}
+
+func TestDispatcherFiresLogEntries(t *testing.T) {
+	dispatcher := &Dispatcher{}
+	sink := &recordingSink{}
+	dispatcher.Add("sink", sink, 16)
+	logger := log.New()
+	logger.Out = ioutil.Discard
+	logger.AddHook(dispatcher)
+	logger.WithFields(log.Fields{"client": "192.0.2.1:50000"}).Info("Client connected")
+	dispatcher.Close()
+	if len(sink.events) != 1 || sink.events[0].Message != "Client connected" || sink.events[0].Fields["client"] != "192.0.2.1:50000" {
+		t.Errorf("sink received %+v, want the logged event", sink.events)
+	}
+}
@@ -0,0 +1,340 @@
+package output
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// postgresStatement is a statement a postgresServer ran, with its bound
+// parameters, nil for NULL.
+type postgresStatement struct {
+	query  string
+	params []interface{}
+}
+
+// postgresServer speaks enough of the PostgreSQL protocol to authenticate
+// clients with auth, trust, md5 or scram, and record the statements they
+// run. Like PostgreSQL, it rejects parameters that aren't valid UTF-8.
+type postgresServer struct {
+	listener net.Listener
+	auth     string
+
+	mu sync.Mutex
+	// startups are the startup messages received, without their length.
+	startups [][]byte
+	// passwords are the bodies of the password messages received.
+	passwords  [][]byte
+	statements []postgresStatement
+}
+
+func newPostgresServer(t *testing.T, auth string) *postgresServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	server := &postgresServer{listener: listener, auth: auth}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+// dsn returns the URL to connect to the server as user with password.
+func (server *postgresServer) dsn(user, password string) string {
+	return "postgres://" + user + ":" + password + "@" + server.listener.Addr().String() + "/honeypot?sslmode=disable"
+}
+
+func (server *postgresServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	send := func(messageType byte, body string) {
+		conn.Write(postgresMessage(messageType, []byte(body)))
+	}
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return
+	}
+	startup := make([]byte, binary.BigEndian.Uint32(header)-4)
+	if _, err := io.ReadFull(reader, startup); err != nil {
+		return
+	}
+	server.mu.Lock()
+	server.startups = append(server.startups, startup)
+	server.mu.Unlock()
+	receive := func() (byte, []byte) {
+		header := make([]byte, 5)
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return 0, nil
+		}
+		body := make([]byte, binary.BigEndian.Uint32(header[1:])-4)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			return 0, nil
+		}
+		return header[0], body
+	}
+	password := func() []byte {
+		messageType, body := receive()
+		if messageType != 'p' {
+			return nil
+		}
+		server.mu.Lock()
+		server.passwords = append(server.passwords, body)
+		server.mu.Unlock()
+		return body
+	}
+	switch server.auth {
+	case "md5":
+		send('R', "\x00\x00\x00\x05\x01\x02\x03\x04")
+		password()
+	case "scram":
+		send('R', "\x00\x00\x00\x0aSCRAM-SHA-256\x00\x00")
+		first := password()
+		nonce := first[bytes.LastIndex(first, []byte("r="))+2:]
+		send('R', "\x00\x00\x00\x0br="+string(nonce)+"3rfcNHYJY1ZVvWVs7j,s="+base64.StdEncoding.EncodeToString([]byte("salt"))+",i=4096")
+		password()
+		// The server doesn't know the password.
+		send('R', "\x00\x00\x00\x0cv="+base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	}
+	send('R', "\x00\x00\x00\x00")
+	send('Z', "I")
+	var statement postgresStatement
+	failed := false
+	for {
+		messageType, body := receive()
+		switch messageType {
+		case 0, 'X':
+			return
+		case 'S':
+			failed = false
+			send('Z', "I")
+			continue
+		}
+		if failed {
+			continue
+		}
+		switch messageType {
+		case 'P':
+			statement = postgresStatement{query: strings.SplitN(string(body[1:]), "\x00", 2)[0]}
+			if statement.query == "INVALID" {
+				failed = true
+				send('E', "SERROR\x00C42601\x00Msyntax error at or near \"INVALID\"\x00\x00")
+				continue
+			}
+			send('1', "")
+		case 'B':
+			count := binary.BigEndian.Uint16(body[4:])
+			params := body[6:]
+			for i := 0; i < int(count); i++ {
+				length := int32(binary.BigEndian.Uint32(params))
+				params = params[4:]
+				if length < 0 {
+					statement.params = append(statement.params, nil)
+					continue
+				}
+				statement.params = append(statement.params, string(params[:length]))
+				if !utf8.Valid(params[:length]) {
+					failed = true
+				}
+				params = params[length:]
+			}
+			if failed {
+				send('E', "SERROR\x00C22021\x00Minvalid byte sequence for encoding \"UTF8\"\x00\x00")
+				continue
+			}
+			send('2', "")
+		case 'E':
+			server.mu.Lock()
+			server.statements = append(server.statements, statement)
+			server.mu.Unlock()
+			send('C', "INSERT 0 1\x00")
+		}
+	}
+}
+
+// queries returns the queries of the statements run, without whitespace.
+func (server *postgresServer) queries() []string {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	queries := []string{}
+	for _, statement := range server.statements {
+		queries = append(queries, strings.Join(strings.Fields(statement.query), " "))
+	}
+	return queries
+}
+
+func TestPostgresStartup(t *testing.T) {
+	server := newPostgresServer(t, "md5")
+	db, err := sql.Open(PostgresDriver, server.dsn("sshesame", "hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if want := "\x00\x03\x00\x00user\x00sshesame\x00database\x00honeypot\x00application_name\x00sshesame\x00client_encoding\x00UTF8\x00\x00"; len(server.startups) != 1 || string(server.startups[0]) != want {
+		t.Errorf("sent the startup messages %q, want %q", server.startups, want)
+	}
+	// md5(md5(password + user) + salt), with the salt 01020304.
+	if want := "md53d0234094505f1d48ded4d03d01ae011\x00"; len(server.passwords) != 1 || string(server.passwords[0]) != want {
+		t.Errorf("sent the passwords %q, want %q", server.passwords, want)
+	}
+}
+
+func TestPostgresSCRAMServerUnverified(t *testing.T) {
+	server := newPostgresServer(t, "scram")
+	db, err := sql.Open(PostgresDriver, server.dsn("sshesame", "hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Ping(); err == nil || !strings.Contains(err.Error(), "invalid SCRAM server signature") {
+		t.Errorf("connecting to a server not knowing the password = %v", err)
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.passwords) != 2 {
+		t.Fatalf("sent %v SASL messages, want 2", len(server.passwords))
+	}
+	// SASLInitialResponse: the mechanism, then the length of the
+	// client-first-message.
+	initial := server.passwords[0]
+	if !bytes.HasPrefix(initial, []byte("SCRAM-SHA-256\x00")) || int(binary.BigEndian.Uint32(initial[14:])) != len(initial)-18 || !bytes.HasPrefix(initial[18:], []byte("n,,n=,r=")) {
+		t.Errorf("sent the SASL initial response %q", initial)
+	}
+	if final := string(server.passwords[1]); !strings.HasPrefix(final, "c=biws,r="+string(initial[26:])+"3rfcNHYJY1ZVvWVs7j,p=") {
+		t.Errorf("sent the client-final-message %q", final)
+	}
+}
+
+func TestSCRAMVectors(t *testing.T) {
+	// The exchange of RFC 7677 section 3. PostgreSQL leaves the user name
+	// out of the client-first-message, so the vector's is put back for the
+	// proof.
+	client := &scramClient{password: "pencil", nonce: "rOprNGfwEbeRWgbNEkqO"}
+	if first := client.clientFirst(); first != "n,,n=,r=rOprNGfwEbeRWgbNEkqO" {
+		t.Errorf("client-first-message %q", first)
+	}
+	client.firstBare = "n=user,r=rOprNGfwEbeRWgbNEkqO"
+	final, err := client.clientFinal("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="; final != want {
+		t.Errorf("client-final-message %q, want %q", final, want)
+	}
+	if !client.verify("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=") {
+		t.Error("the server signature of RFC 7677 wasn't verified")
+	}
+	if client.verify("v=7rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=") || client.verify("e=invalid-proof") {
+		t.Error("a wrong server signature was verified")
+	}
+
+	for _, serverFirst := range []string{
+		// A nonce not extending the client's.
+		"r=fyko+d2lbbFgONRv9qkxdawL,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096",
+		"r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2Ra,s=not base64,i=4096",
+		"r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2Ra,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=0",
+		"r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2Ra,s=W22ZaJ0SNY7soEsUEjb6gQ==",
+	} {
+		if _, err := client.clientFinal(serverFirst); err == nil {
+			t.Errorf("server-first-message %q accepted", serverFirst)
+		}
+	}
+}
+
+func TestPostgresParameters(t *testing.T) {
+	server := newPostgresServer(t, "trust")
+	db, err := sql.Open(PostgresDriver, server.dsn("sshesame", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// Parameters are bound, not spliced into the query, so quotes and
+	// backslashes don't need escaping.
+	at := time.Date(2024, 3, 1, 12, 0, 0, 500, time.FixedZone("CET", 3600))
+	if _, err := db.Exec("INSERT INTO t VALUES ($1, $2, $3, $4, $5, $6, $7)", `it's \o/`, int64(-7), 0.5, true, at, nil, []byte("ls\x00 \xff")); err != nil {
+		t.Fatal(err)
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.statements) != 1 || server.statements[0].query != "INSERT INTO t VALUES ($1, $2, $3, $4, $5, $6, $7)" {
+		t.Fatalf("ran %v", server.statements)
+	}
+	if want := []interface{}{`it's \o/`, "-7", "0.5", "true", "2024-03-01T11:00:00.0000005Z", nil, "ls �"}; !reflect.DeepEqual(server.statements[0].params, want) {
+		t.Errorf("bound %q, want %q", server.statements[0].params, want)
+	}
+}
+
+func TestPostgresStatementFails(t *testing.T) {
+	server := newPostgresServer(t, "trust")
+	db, err := sql.Open(PostgresDriver, server.dsn("sshesame", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("INVALID"); err == nil || err.Error() != `ERROR: syntax error at or near "INVALID" (SQLSTATE 42601)` {
+		t.Errorf("running an invalid statement = %v", err)
+	}
+	// The connection is usable again once the server synced.
+	if _, err := db.Exec("INSERT INTO t VALUES ($1)", "after"); err != nil {
+		t.Errorf("running a statement after a rejected one = %v", err)
+	}
+	if queries := server.queries(); !reflect.DeepEqual(queries, []string{"INSERT INTO t VALUES ($1)"}) {
+		t.Errorf("ran %q", queries)
+	}
+}
+
+func TestPostgresDatabaseSinkBatches(t *testing.T) {
+	server := newPostgresServer(t, "trust")
+	sink, err := NewDatabaseSink(PostgresDriver, server.dsn("sshesame", ""), Batching{Size: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queries := server.queries(); len(queries) != len(databaseSchema) {
+		t.Errorf("created the schema with %v statements, want %v", len(queries), len(databaseSchema))
+	}
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, command := range []string{"uname -a", "echo \xff\xfe"} {
+		if err := sink.Emit(Event{Time: start.Add(time.Duration(i) * time.Second), Level: log.InfoLevel, Message: "Command executed", Fields: log.Fields{"client": "192.0.2.1:50000", "session_id": "abc", "command": command}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	queries := server.queries()[len(databaseSchema):]
+	insert := "INSERT INTO commands (time, session_id, client_ip, command, exit_status, category) VALUES ($1, $2, $3, $4, $5, $6)"
+	if want := []string{"BEGIN", insert, insert, "COMMIT"}; !reflect.DeepEqual(queries, want) {
+		t.Errorf("ran %q, want %q", queries, want)
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	params := server.statements[len(server.statements)-2].params
+	if want := []interface{}{"2024-03-01T12:00:01Z", "abc", "192.0.2.1", "echo �", nil, nil}; !reflect.DeepEqual(params, want) {
+		t.Errorf("bound %q, want %q", params, want)
+	}
+}
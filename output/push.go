@@ -0,0 +1,107 @@
+package output
+
+import (
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const (
+	pushRetries    = 3
+	pushRetryDelay = 500 * time.Millisecond
+)
+
+// retryPush pushes a batch to a remote sink with push, which reports whether
+// a failure is worth retrying, and retries it pushRetries times with
+// exponential backoff before giving up.
+func retryPush(push func() (bool, error)) error {
+	delay := pushRetryDelay
+	for attempt := 0; ; attempt++ {
+		retry, err := push()
+		if err == nil || !retry || attempt == pushRetries {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// pushedLine is an event formatted as a JSON line, without its line break.
+type pushedLine struct {
+	time time.Time
+	line []byte
+}
+
+// pushBatcher formats events as the JSON lines log_file would contain and
+// hands them to push in batches, like a log file batches them, for sinks
+// pushing events to a remote service.
+type pushBatcher struct {
+	formatter log.Formatter
+	batching  Batching
+	push      func(lines []pushedLine) error
+
+	mu    sync.Mutex
+	lines []pushedLine
+	timer *time.Timer
+	// err is the error of the last push triggered by the timer, returned by
+	// the next add.
+	err error
+}
+
+func newPushBatcher(timestamps Timestamps, batching Batching, push func(lines []pushedLine) error) *pushBatcher {
+	return &pushBatcher{formatter: timestamps.Formatter(true), batching: batching, push: push}
+}
+
+// add adds event to the batch, pushing it if it's full.
+func (batcher *pushBatcher) add(event Event) error {
+	line, err := batcher.formatter.Format(&log.Entry{
+		Logger:  log.StandardLogger(),
+		Data:    event.Fields,
+		Time:    event.Time,
+		Level:   event.Level,
+		Message: event.Message,
+	})
+	if err != nil {
+		return err
+	}
+	batcher.mu.Lock()
+	defer batcher.mu.Unlock()
+	if err := batcher.err; err != nil {
+		batcher.err = nil
+		return err
+	}
+	batcher.lines = append(batcher.lines, pushedLine{event.Time, line[:len(line)-1]})
+	if len(batcher.lines) >= batcher.batching.Size {
+		return batcher.flush()
+	}
+	if batcher.timer == nil && batcher.batching.FlushInterval > 0 {
+		batcher.timer = time.AfterFunc(batcher.batching.FlushInterval, func() {
+			batcher.mu.Lock()
+			defer batcher.mu.Unlock()
+			batcher.timer = nil
+			batcher.err = batcher.flush()
+		})
+	}
+	return nil
+}
+
+// flush pushes the buffered batch. batcher.mu must be held.
+func (batcher *pushBatcher) flush() error {
+	if batcher.timer != nil {
+		batcher.timer.Stop()
+		batcher.timer = nil
+	}
+	if len(batcher.lines) == 0 {
+		return nil
+	}
+	lines := batcher.lines
+	batcher.lines = nil
+	return batcher.push(lines)
+}
+
+// close pushes the partial batch.
+func (batcher *pushBatcher) close() error {
+	batcher.mu.Lock()
+	defer batcher.mu.Unlock()
+	return batcher.flush()
+}
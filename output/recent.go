@@ -0,0 +1,67 @@
+package output
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RecentEvent is an event as kept by RecentSink, with every field rendered so
+// that it can be marshaled to JSON.
+type RecentEvent struct {
+	Time    time.Time              `json:"time"`
+	Level   string                 `json:"level"`
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields"`
+}
+
+// RecentSink keeps the most recent events in memory.
+type RecentSink struct {
+	mu     sync.Mutex
+	events []RecentEvent
+	next   int
+	full   bool
+}
+
+// NewRecentSink returns a sink keeping the last size events.
+func NewRecentSink(size int) *RecentSink {
+	return &RecentSink{events: make([]RecentEvent, size)}
+}
+
+// Emit implements Sink.
+func (sink *RecentSink) Emit(event Event) error {
+	fields := make(map[string]interface{}, len(event.Fields))
+	for key, value := range event.Fields {
+		switch value := value.(type) {
+		case error:
+			fields[key] = value.Error()
+		case fmt.Stringer:
+			fields[key] = value.String()
+		default:
+			fields[key] = value
+		}
+	}
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	sink.events[sink.next] = RecentEvent{event.Time, event.Level.String(), event.Message, fields}
+	sink.next = (sink.next + 1) % len(sink.events)
+	if sink.next == 0 {
+		sink.full = true
+	}
+	return nil
+}
+
+// Events returns the kept events, oldest first.
+func (sink *RecentSink) Events() []RecentEvent {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if !sink.full {
+		return append([]RecentEvent{}, sink.events[:sink.next]...)
+	}
+	return append(append([]RecentEvent{}, sink.events[sink.next:]...), sink.events[:sink.next]...)
+}
+
+// Close implements Sink.
+func (sink *RecentSink) Close() error {
+	return nil
+}
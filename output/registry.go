@@ -0,0 +1,293 @@
+package output
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SinkConfig configures a sink created by type, see RegisterSink.
+type SinkConfig struct {
+	// Options are the <key>=<value> options the sink was selected with.
+	Options map[string]string
+	// Timestamps, Batching and Chaining are the ones of the log file, for
+	// sinks writing events out to use as well.
+	Timestamps Timestamps
+	Batching   Batching
+	Chaining   Chaining
+}
+
+// Option returns the option called name, or an error if it's missing.
+func (config SinkConfig) Option(name string) (string, error) {
+	value, ok := config.Options[name]
+	if !ok || value == "" {
+		return "", fmt.Errorf("missing option %q", name)
+	}
+	return value, nil
+}
+
+// Formatter returns the formatter of the format option, see NewFormatter,
+// formatting events as JSON by default.
+func (config SinkConfig) Formatter() (log.Formatter, error) {
+	format := config.Options["format"]
+	if format == "" {
+		format = "json"
+	}
+	return NewFormatter(format, config.Timestamps)
+}
+
+// SinkFactory creates a sink from its configuration.
+type SinkFactory func(config SinkConfig) (Sink, error)
+
+var (
+	sinkFactoriesMu sync.RWMutex
+	sinkFactories   = map[string]SinkFactory{}
+)
+
+func init() {
+	RegisterSink("stdout", func(config SinkConfig) (Sink, error) {
+		formatter, err := config.Formatter()
+		if err != nil {
+			return nil, err
+		}
+		return NewWriterSink(os.Stdout, formatter), nil
+	})
+	RegisterSink("file", func(config SinkConfig) (Sink, error) {
+		path, err := config.Option("path")
+		if err != nil {
+			return nil, err
+		}
+		rotation, err := ParseRotation(config.Options)
+		if err != nil {
+			return nil, err
+		}
+		formatter, err := config.Formatter()
+		if err != nil {
+			return nil, err
+		}
+		// Files are only chained with the chain option, as chaining a file
+		// whose lines aren't JSON objects fails.
+		chaining := Chaining{}
+		switch config.Options["chain"] {
+		case "", "false":
+		case "true":
+			if len(config.Chaining.Key) == 0 {
+				return nil, errors.New("chain is set but there is no chain key")
+			}
+			chaining = config.Chaining
+		default:
+			return nil, fmt.Errorf("invalid chain %q, must be true or false", config.Options["chain"])
+		}
+		return NewFileSink(path, formatter, config.Batching, chaining, rotation)
+	})
+	RegisterSink("loki", func(config SinkConfig) (Sink, error) {
+		url, err := config.Option("url")
+		if err != nil {
+			return nil, err
+		}
+		labels := DefaultLokiLabels()
+		if text, ok := config.Options["labels"]; ok {
+			if err := labels.Set(strings.ReplaceAll(text, ";", ",")); err != nil {
+				return nil, err
+			}
+		}
+		return NewLokiSink(url, labels, config.Timestamps, config.Batching), nil
+	})
+	RegisterSink("syslog", func(config SinkConfig) (Sink, error) {
+		network := config.Options["network"]
+		if network == "" {
+			network = "udp"
+		}
+		facility := config.Options["facility"]
+		if facility == "" {
+			facility = "auth"
+		}
+		tag := config.Options["tag"]
+		if tag == "" {
+			tag = "sshesame"
+		}
+		formatter, err := config.Formatter()
+		if err != nil {
+			return nil, err
+		}
+		return NewSyslogSink(network, config.Options["address"], facility, tag, formatter)
+	})
+	RegisterSink("webhook", func(config SinkConfig) (Sink, error) {
+		url, err := config.Option("url")
+		if err != nil {
+			return nil, err
+		}
+		headers := http.Header{}
+		if authorization, ok := config.Options["authorization"]; ok {
+			headers.Set("Authorization", authorization)
+		}
+		return NewWebhookSink(url, headers, config.Timestamps, config.Batching), nil
+	})
+	RegisterSink("elasticsearch", func(config SinkConfig) (Sink, error) {
+		url, err := config.Option("url")
+		if err != nil {
+			return nil, err
+		}
+		index := config.Options["index"]
+		if index == "" {
+			index = "sshesame"
+		}
+		if index != strings.ToLower(index) || strings.ContainsAny(index, `*\/?"<>| ,#:`) {
+			return nil, fmt.Errorf("invalid index %q", index)
+		}
+		headers := http.Header{}
+		if apiKey, ok := config.Options["api_key"]; ok {
+			headers.Set("Authorization", "ApiKey "+apiKey)
+		} else if user, ok := config.Options["user"]; ok {
+			headers.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(user+":"+config.Options["password"])))
+		}
+		return NewElasticsearchSink(url, index, headers, config.Timestamps, config.Batching), nil
+	})
+	RegisterSink("hpfeeds", func(config SinkConfig) (Sink, error) {
+		address, err := config.Option("address")
+		if err != nil {
+			return nil, err
+		}
+		ident, err := config.Option("ident")
+		if err != nil {
+			return nil, err
+		}
+		secret, err := config.Option("secret")
+		if err != nil {
+			return nil, err
+		}
+		channels := HPFeedsChannels{Auth: "sshesame.auth", Commands: "sshesame.commands", Files: "sshesame.files"}
+		for name, channel := range map[string]*string{"auth_channel": &channels.Auth, "commands_channel": &channels.Commands, "files_channel": &channels.Files} {
+			// Channels set empty aren't published to.
+			if value, ok := config.Options[name]; ok {
+				*channel = value
+			}
+		}
+		return NewHPFeedsSink(address, ident, secret, channels, config.Timestamps), nil
+	})
+	RegisterSink("kafka", func(config SinkConfig) (Sink, error) {
+		brokers, err := config.Option("brokers")
+		if err != nil {
+			return nil, err
+		}
+		topic, err := config.Option("topic")
+		if err != nil {
+			return nil, err
+		}
+		return NewKafkaSink(strings.Split(brokers, ";"), topic, config.Timestamps, config.Batching), nil
+	})
+	RegisterSink("database", func(config SinkConfig) (Sink, error) {
+		dsn, err := config.Option("dsn")
+		if err != nil {
+			return nil, err
+		}
+		// Other databases, like SQLite, need their database/sql driver
+		// imported into the program.
+		driverName := config.Options["driver"]
+		if driverName == "" {
+			if !strings.HasPrefix(dsn, "postgres://") && !strings.HasPrefix(dsn, "postgresql://") {
+				return nil, errors.New("missing option \"driver\" for a DSN other than a PostgreSQL URL")
+			}
+			driverName = PostgresDriver
+		}
+		return NewDatabaseSink(driverName, dsn, config.Batching)
+	})
+}
+
+// RegisterSink makes the sink type called name available to the sink flag,
+// replacing any it was registered for already. Programs built with
+// sshesame's packages call it from an init function to add their own
+// outputs.
+func RegisterSink(name string, factory SinkFactory) {
+	sinkFactoriesMu.Lock()
+	defer sinkFactoriesMu.Unlock()
+	sinkFactories[name] = factory
+}
+
+// NewSink creates a sink of the type registered as name.
+func NewSink(name string, config SinkConfig) (Sink, error) {
+	sinkFactoriesMu.RLock()
+	factory, ok := sinkFactories[name]
+	sinkFactoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown sink type %q, registered ones are %v", name, strings.Join(SinkTypes(), ", "))
+	}
+	return factory(config)
+}
+
+// SinkTypes returns the names of the registered sink types, sorted.
+func SinkTypes() []string {
+	sinkFactoriesMu.RLock()
+	defer sinkFactoriesMu.RUnlock()
+	names := make([]string, 0, len(sinkFactories))
+	for name := range sinkFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SinkSpec selects a sink by type, named like its type unless a name option
+// is set.
+type SinkSpec struct {
+	Name    string
+	Type    string
+	Options map[string]string
+}
+
+// SinkSpecs implements flag.Value, adding a <type>[,<key>=<value>...] sink
+// every time it's set.
+type SinkSpecs []SinkSpec
+
+func (specs *SinkSpecs) String() string {
+	if specs == nil {
+		return ""
+	}
+	texts := []string{}
+	for _, spec := range *specs {
+		texts = append(texts, spec.Name)
+	}
+	return strings.Join(texts, " ")
+}
+
+// Contains returns whether a sink called name is selected.
+func (specs SinkSpecs) Contains(name string) bool {
+	for _, spec := range specs {
+		if spec.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Set implements flag.Value.
+func (specs *SinkSpecs) Set(text string) error {
+	parts := strings.Split(text, ",")
+	spec := SinkSpec{Name: parts[0], Type: parts[0], Options: map[string]string{}}
+	if spec.Type == "" {
+		return errors.New("missing sink type")
+	}
+	for _, option := range parts[1:] {
+		keyValue := strings.SplitN(option, "=", 2)
+		if len(keyValue) != 2 || keyValue[0] == "" {
+			return fmt.Errorf("invalid sink option %q, expected <key>=<value>", option)
+		}
+		key, value := keyValue[0], keyValue[1]
+		if key == "name" {
+			spec.Name = value
+			continue
+		}
+		spec.Options[key] = value
+	}
+	if specs.Contains(spec.Name) {
+		return fmt.Errorf("sink %q is added twice, set a different name option", spec.Name)
+	}
+	*specs = append(*specs, spec)
+	return nil
+}
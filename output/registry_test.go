@@ -0,0 +1,151 @@
+package output
+
+import (
+	log "github.com/sirupsen/logrus"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// registerSink registers factory as the sink type called name for the rest
+// of the test.
+func registerSink(t *testing.T, name string, factory SinkFactory) {
+	RegisterSink(name, factory)
+	t.Cleanup(func() {
+		sinkFactoriesMu.Lock()
+		defer sinkFactoriesMu.Unlock()
+		delete(sinkFactories, name)
+	})
+}
+
+func TestRegisteredSinkReceivesEvents(t *testing.T) {
+	created := map[string]*recordingSink{}
+	options := map[string]map[string]string{}
+	registerSink(t, "siem", func(config SinkConfig) (Sink, error) {
+		endpoint, err := config.Option("endpoint")
+		if err != nil {
+			return nil, err
+		}
+		sink := &recordingSink{}
+		created[endpoint] = sink
+		options[endpoint] = config.Options
+		return sink, nil
+	})
+	specs := SinkSpecs{}
+	for _, text := range []string{"siem,endpoint=primary", "siem,name=backup,endpoint=secondary,region=eu"} {
+		if err := specs.Set(text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := (SinkSpecs{
+		{Name: "siem", Type: "siem", Options: map[string]string{"endpoint": "primary"}},
+		{Name: "backup", Type: "siem", Options: map[string]string{"endpoint": "secondary", "region": "eu"}},
+	}); !reflect.DeepEqual(specs, want) {
+		t.Fatalf("sinks parsed as %+v, want %+v", specs, want)
+	}
+
+	dispatcher := &Dispatcher{}
+	for _, spec := range specs {
+		sink, err := NewSink(spec.Type, SinkConfig{Options: spec.Options})
+		if err != nil {
+			t.Fatal(err)
+		}
+		dispatcher.Add(spec.Name, sink, 16)
+	}
+	dispatcher.Emit(Event{Time: time.Now(), Level: log.InfoLevel, Message: "Client connected"})
+	dispatcher.Close()
+	for _, endpoint := range []string{"primary", "secondary"} {
+		sink := created[endpoint]
+		if sink == nil {
+			t.Fatalf("no sink created for %v", endpoint)
+		}
+		if messages := sink.messages(); len(messages) != 1 || messages[0] != "Client connected" || !sink.closed {
+			t.Errorf("sink for %v received %q, closed %v", endpoint, messages, sink.closed)
+		}
+	}
+	if options["secondary"]["region"] != "eu" {
+		t.Errorf("sink for secondary created with %v", options["secondary"])
+	}
+
+	if _, err := NewSink("siem", SinkConfig{Options: map[string]string{}}); err == nil || !strings.Contains(err.Error(), `missing option "endpoint"`) {
+		t.Errorf("NewSink without the endpoint = %v", err)
+	}
+	if types := SinkTypes(); !contains(types, "siem") || !contains(types, "file") || !contains(types, "loki") {
+		t.Errorf("SinkTypes = %v, want the registered and built-in types", types)
+	}
+	if _, err := NewSink("splunk", SinkConfig{}); err == nil || !strings.Contains(err.Error(), "registered ones are ") || !strings.Contains(err.Error(), "siem") {
+		t.Errorf("NewSink of an unknown type = %v, want the registered types listed", err)
+	}
+}
+
+func TestSinkSpecsInvalid(t *testing.T) {
+	for _, texts := range [][]string{
+		{""},
+		{",path=x"},
+		{"file,path"},
+		{"file,=x"},
+		{"file,path=a", "file,path=b"},
+		{"loki,name=primary,url=a", "file,name=primary,path=b"},
+	} {
+		specs := SinkSpecs{}
+		var err error
+		for _, text := range texts {
+			if err = specs.Set(text); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			t.Errorf("sinks %q were accepted as %+v", texts, specs)
+		}
+	}
+}
+
+func TestBuiltinSinksRegistered(t *testing.T) {
+	dir := t.TempDir()
+	key := []byte("secret")
+	chained := filepath.Join(dir, "chained.log")
+	sink, err := NewSink("file", SinkConfig{Options: map[string]string{"path": chained, "chain": "true"}, Chaining: Chaining{Key: key}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Emit(Event{Time: time.Now(), Level: log.InfoLevel, Message: "Client connected", Fields: log.Fields{}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if lines, _, err := VerifyChain(chained, key); err != nil || lines != 1 {
+		t.Errorf("VerifyChain of a chained file sink = %v, %v", lines, err)
+	}
+
+	for name, options := range map[string]map[string]string{
+		"file":          {},
+		"loki":          {},
+		"webhook":       {},
+		"kafka":         {"brokers": "localhost:9092"},
+		"elasticsearch": {"url": "http://localhost:9200", "index": "Sshesame"},
+		"database":      {"dsn": "file:sshesame.db"},
+	} {
+		if _, err := NewSink(name, SinkConfig{Options: options}); err == nil {
+			t.Errorf("%v sink created with options %v", name, options)
+		}
+	}
+	for _, chain := range []string{"true", "yes"} {
+		// The key is missing or the option invalid.
+		if _, err := NewSink("file", SinkConfig{Options: map[string]string{"path": filepath.Join(dir, "sshesame.log"), "chain": chain}}); err == nil {
+			t.Errorf("file sink created with chain %v", chain)
+		}
+	}
+}
+
+// contains reports whether names has name.
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
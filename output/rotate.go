@@ -0,0 +1,129 @@
+package output
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// Rotation configures rotating a log file once it grows too large: the file
+// is renamed with .1 appended, older ones with .2, .3 and so on, and a new
+// one started.
+type Rotation struct {
+	// MaxSize is the size in bytes the file is rotated at, it's never
+	// rotated if 0.
+	MaxSize int64
+	// MaxFiles is the number of rotated files kept, older ones being
+	// removed.
+	MaxFiles int
+}
+
+// ParseRotation parses the max_size and max_files options of a file sink,
+// max_files defaulting to 5.
+func ParseRotation(options map[string]string) (Rotation, error) {
+	rotation := Rotation{MaxFiles: 5}
+	if text, ok := options["max_size"]; ok {
+		size, err := strconv.ParseInt(text, 10, 64)
+		if err != nil || size < 0 {
+			return Rotation{}, fmt.Errorf("invalid max_size %q, must be a number of bytes", text)
+		}
+		rotation.MaxSize = size
+	}
+	if text, ok := options["max_files"]; ok {
+		files, err := strconv.Atoi(text)
+		if err != nil || files < 1 {
+			return Rotation{}, fmt.Errorf("invalid max_files %q, must be a positive number", text)
+		}
+		rotation.MaxFiles = files
+	}
+	return rotation, nil
+}
+
+// rotatingFile appends to the file at path, rotating it as rotation says
+// before writes that would make it too large. Writes are never split, so the
+// batches and lines written at once stay in the same file.
+type rotatingFile struct {
+	path     string
+	rotation Rotation
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func openRotatingFile(path string, rotation Rotation) (*rotatingFile, error) {
+	file := &rotatingFile{path: path, rotation: rotation}
+	if err := file.open(); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (file *rotatingFile) open() error {
+	opened, err := os.OpenFile(file.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := opened.Stat()
+	if err != nil {
+		opened.Close()
+		return err
+	}
+	file.file, file.size = opened, info.Size()
+	return nil
+}
+
+func (file *rotatingFile) Write(data []byte) (int, error) {
+	file.mu.Lock()
+	defer file.mu.Unlock()
+	if file.file == nil {
+		// A rotation failed to open the new file, which is retried.
+		if err := file.open(); err != nil {
+			return 0, err
+		}
+	}
+	if file.rotation.MaxSize > 0 && file.size > 0 && file.size+int64(len(data)) > file.rotation.MaxSize {
+		if err := file.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := file.file.Write(data)
+	file.size += int64(n)
+	return n, err
+}
+
+// rotate renames the file and the ones rotated before it and opens a new
+// one. file.mu must be held.
+func (file *rotatingFile) rotate() error {
+	if err := file.file.Close(); err != nil {
+		return err
+	}
+	file.file = nil
+	for i := file.rotation.MaxFiles; i > 0; i-- {
+		older := file.path + "." + strconv.Itoa(i)
+		if i == file.rotation.MaxFiles {
+			if err := os.Remove(older); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			continue
+		}
+		if err := os.Rename(older, file.path+"."+strconv.Itoa(i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Rename(file.path, file.path+".1"); err != nil {
+		return err
+	}
+	return file.open()
+}
+
+func (file *rotatingFile) Close() error {
+	file.mu.Lock()
+	defer file.mu.Unlock()
+	if file.file == nil {
+		return nil
+	}
+	return file.file.Close()
+}
@@ -0,0 +1,73 @@
+package output
+
+import (
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseRotation(t *testing.T) {
+	for _, test := range []struct {
+		options  map[string]string
+		rotation Rotation
+		invalid  bool
+	}{
+		{map[string]string{}, Rotation{MaxFiles: 5}, false},
+		{map[string]string{"max_size": "1048576", "max_files": "2"}, Rotation{MaxSize: 1048576, MaxFiles: 2}, false},
+		{map[string]string{"max_size": "1M"}, Rotation{}, true},
+		{map[string]string{"max_size": "-1"}, Rotation{}, true},
+		{map[string]string{"max_files": "0"}, Rotation{}, true},
+	} {
+		rotation, err := ParseRotation(test.options)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("%v parsed as %+v", test.options, rotation)
+			}
+			continue
+		}
+		if err != nil || rotation != test.rotation {
+			t.Errorf("%v parsed as %+v, %v, want %+v", test.options, rotation, err, test.rotation)
+		}
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sshesame.log")
+	// A file already there counts towards the size.
+	if err := ioutil.WriteFile(path, []byte("first\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	file, err := openRotatingFile(path, Rotation{MaxSize: 12, MaxFiles: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Writes are never split, even those larger than the maximum size.
+	for _, data := range []string{"second\n", "third\n", "fourth, too long\n", "fifth\n"} {
+		if _, err := file.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file.Close()
+	for name, want := range map[string]string{
+		"sshesame.log":   "fifth\n",
+		"sshesame.log.1": "fourth, too long\n",
+		"sshesame.log.2": "third\n",
+	} {
+		if content, err := ioutil.ReadFile(filepath.Join(filepath.Dir(path), name)); err != nil || string(content) != want {
+			t.Errorf("%v has %q, %v, want %q", name, content, err, want)
+		}
+	}
+	// "first" and "second" were rotated beyond max_files.
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("kept 3 rotated files")
+	}
+}
+
+func TestFileSinkRotatedNotChained(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sshesame.log")
+	if _, err := NewFileSink(path, &log.JSONFormatter{}, Batching{}, Chaining{Key: []byte("key")}, Rotation{MaxSize: 1024, MaxFiles: 5}); err == nil {
+		t.Error("chained log file rotated")
+	}
+}
@@ -0,0 +1,149 @@
+package output
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"regexp"
+	"strings"
+)
+
+// ScrubPattern is sensitive data found in events.
+type ScrubPattern struct {
+	Name   string
+	Regexp *regexp.Regexp
+	// Valid, if set, tells matches that are sensitive apart from those that
+	// only look like it.
+	Valid func(match string) bool
+}
+
+// DefaultScrubPatterns returns patterns matching personal data and secrets
+// that clients commonly paste.
+func DefaultScrubPatterns() []ScrubPattern {
+	return []ScrubPattern{
+		{Name: "email", Regexp: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)},
+		{Name: "aws_access_key", Regexp: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+		{Name: "github_token", Regexp: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+		{Name: "slack_token", Regexp: regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}\b`)},
+		{Name: "jwt", Regexp: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`)},
+		{Name: "bearer_token", Regexp: regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/-]{20,}=*`)},
+		{Name: "card_number", Regexp: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), Valid: luhn},
+	}
+}
+
+// luhn reports whether the digits of number pass the Luhn check of payment
+// card numbers.
+func luhn(number string) bool {
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		if number[i] < '0' || number[i] > '9' {
+			continue
+		}
+		digit := int(number[i] - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// Scrubber redacts sensitive data from events before they reach sinks. It
+// implements flag.Value, adding a custom pattern every time it's set.
+type Scrubber struct {
+	Patterns []ScrubPattern
+	// Hash replaces sensitive data with a hash of it, so that events can
+	// still be correlated, rather than only its kind.
+	Hash bool
+}
+
+func (scrubber *Scrubber) String() string {
+	if scrubber == nil {
+		return ""
+	}
+	texts := []string{}
+	for _, pattern := range scrubber.Patterns {
+		if pattern.Name == "custom" {
+			texts = append(texts, pattern.Regexp.String())
+		}
+	}
+	return strings.Join(texts, " ")
+}
+
+// Set implements flag.Value.
+func (scrubber *Scrubber) Set(text string) error {
+	pattern, err := regexp.Compile(text)
+	if err != nil {
+		return fmt.Errorf("invalid scrub pattern %q: %w", text, err)
+	}
+	scrubber.Patterns = append(scrubber.Patterns, ScrubPattern{Name: "custom", Regexp: pattern})
+	return nil
+}
+
+// scrubString redacts the sensitive data in text.
+func (scrubber *Scrubber) scrubString(text string) string {
+	for _, pattern := range scrubber.Patterns {
+		text = pattern.Regexp.ReplaceAllStringFunc(text, func(match string) string {
+			if pattern.Valid != nil && !pattern.Valid(match) {
+				return match
+			}
+			if scrubber.Hash {
+				sum := sha256.Sum256([]byte(match))
+				return fmt.Sprintf("[%v:%v]", pattern.Name, hex.EncodeToString(sum[:6]))
+			}
+			return fmt.Sprintf("[%v]", pattern.Name)
+		})
+	}
+	return text
+}
+
+// scrub redacts the sensitive data in the values of fields in place. Values
+// that are neither strings nor lists or maps of them are formatted if they
+// contain any. It returns whether any was found.
+func (scrubber *Scrubber) scrub(fields log.Fields) bool {
+	scrubbed := false
+	for key, value := range fields {
+		var replacement interface{}
+		switch value := value.(type) {
+		case string:
+			if text := scrubber.scrubString(value); text != value {
+				replacement = text
+			}
+		case []string:
+			texts := make([]string, len(value))
+			changed := false
+			for i, text := range value {
+				texts[i] = scrubber.scrubString(text)
+				changed = changed || texts[i] != text
+			}
+			if changed {
+				replacement = texts
+			}
+		case map[string]string:
+			texts := make(map[string]string, len(value))
+			changed := false
+			for name, text := range value {
+				texts[name] = scrubber.scrubString(text)
+				changed = changed || texts[name] != text
+			}
+			if changed {
+				replacement = texts
+			}
+		case fmt.Stringer:
+			if text := value.String(); scrubber.scrubString(text) != text {
+				replacement = scrubber.scrubString(text)
+			}
+		}
+		if replacement != nil {
+			fields[key] = replacement
+			scrubbed = true
+		}
+	}
+	return scrubbed
+}
@@ -0,0 +1,110 @@
+package output
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LiveSession is a connected client's session as tracked by SessionsSink.
+type LiveSession struct {
+	ID       string    `json:"session_id"`
+	Client   string    `json:"client"`
+	User     string    `json:"user,omitempty"`
+	Version  string    `json:"version,omitempty"`
+	Country  string    `json:"country,omitempty"`
+	Started  time.Time `json:"started"`
+	Commands int       `json:"commands"`
+}
+
+// CountryCount is the number of sessions seen from a country.
+type CountryCount struct {
+	Country  string `json:"country"`
+	Sessions int    `json:"sessions"`
+}
+
+// SessionsSink tracks the sessions of connected clients, told apart by the
+// session_id field of their events, and counts sessions by the country field
+// they are tagged with when geolocated.
+type SessionsSink struct {
+	mu        sync.Mutex
+	sessions  map[string]*LiveSession
+	countries map[string]int
+}
+
+// NewSessionsSink returns a sink tracking no sessions yet.
+func NewSessionsSink() *SessionsSink {
+	return &SessionsSink{sessions: map[string]*LiveSession{}, countries: map[string]int{}}
+}
+
+// Emit implements Sink.
+func (sink *SessionsSink) Emit(event Event) error {
+	id, ok := event.Fields["session_id"]
+	if !ok {
+		return nil
+	}
+	key := fmt.Sprint(id)
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if event.Message == "Client disconnected" {
+		delete(sink.sessions, key)
+		return nil
+	}
+	session, ok := sink.sessions[key]
+	if !ok {
+		session = &LiveSession{ID: key, Client: fmt.Sprint(event.Fields["client"]), Started: event.Time}
+		if country, ok := event.Fields["country"]; ok {
+			session.Country = fmt.Sprint(country)
+			sink.countries[session.Country]++
+		}
+		sink.sessions[key] = session
+	}
+	switch event.Message {
+	case "SSH connection established":
+		session.User = fmt.Sprint(event.Fields["user"])
+		session.Version = fmt.Sprint(event.Fields["version"])
+	case "Command executed":
+		session.Commands++
+	}
+	return nil
+}
+
+// Sessions returns the live sessions, oldest first.
+func (sink *SessionsSink) Sessions() []LiveSession {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	sessions := make([]LiveSession, 0, len(sink.sessions))
+	for _, session := range sink.sessions {
+		sessions = append(sessions, *session)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Started.Before(sessions[j].Started)
+	})
+	return sessions
+}
+
+// TopCountries returns the n countries most sessions came from.
+func (sink *SessionsSink) TopCountries(n int) []CountryCount {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	counts := make([]CountryCount, 0, len(sink.countries))
+	for country, sessions := range sink.countries {
+		counts = append(counts, CountryCount{country, sessions})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Sessions != counts[j].Sessions {
+			return counts[i].Sessions > counts[j].Sessions
+		}
+		return counts[i].Country < counts[j].Country
+	})
+	if len(counts) > n {
+		counts = counts[:n]
+	}
+	return counts
+}
+
+// Close implements Sink.
+func (sink *SessionsSink) Close() error {
+	return nil
+}
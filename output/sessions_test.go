@@ -0,0 +1,45 @@
+package output
+
+import (
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"testing"
+	"time"
+)
+
+func TestSessionsSink(t *testing.T) {
+	sink := NewSessionsSink()
+	start := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	for i, event := range []Event{
+		{Message: "Client connected", Fields: log.Fields{"session_id": "b", "client": "198.51.100.2:50000", "country": "FR"}},
+		{Message: "Client connected", Fields: log.Fields{"session_id": "a", "client": "192.0.2.1:50000", "country": "DE"}},
+		{Message: "SSH connection established", Fields: log.Fields{"session_id": "a", "user": "root", "version": "SSH-2.0-Go"}},
+		{Message: "Command executed", Fields: log.Fields{"session_id": "a"}},
+		{Message: "Command executed", Fields: log.Fields{"session_id": "a"}},
+		// Events of no session are ignored.
+		{Message: "Command executed", Fields: log.Fields{}},
+		{Message: "Client connected", Fields: log.Fields{"session_id": "c", "client": "192.0.2.3:50000", "country": "DE"}},
+		{Message: "Client disconnected", Fields: log.Fields{"session_id": "c"}},
+		{Message: "Client connected", Fields: log.Fields{"session_id": "d", "client": "192.0.2.4:50000"}},
+	} {
+		event.Time = start.Add(time.Duration(i) * time.Second)
+		if err := sink.Emit(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The oldest first, disconnected sessions still counted by country.
+	for _, test := range []struct {
+		value interface{}
+		want  string
+	}{
+		{sink.Sessions(), `[{"session_id":"b","client":"198.51.100.2:50000","country":"FR","started":"2026-10-14T12:00:00Z","commands":0},` +
+			`{"session_id":"a","client":"192.0.2.1:50000","user":"root","version":"SSH-2.0-Go","country":"DE","started":"2026-10-14T12:00:01Z","commands":2},` +
+			`{"session_id":"d","client":"192.0.2.4:50000","started":"2026-10-14T12:00:08Z","commands":0}]`},
+		{sink.TopCountries(10), `[{"country":"DE","sessions":2},{"country":"FR","sessions":1}]`},
+		{sink.TopCountries(1), `[{"country":"DE","sessions":2}]`},
+	} {
+		if encoded, err := json.Marshal(test.value); err != nil || string(encoded) != test.want {
+			t.Errorf("encoded %s, %v, want %s", encoded, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package output
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"strings"
+)
+
+// severityLevels are the severities events can have, least severe first.
+var severityLevels = []string{"debug", "info", "notice", "warning", "critical"}
+
+// Severities maps events to severities. Keys are either the message of an
+// event or category:<category>, which takes precedence for events with a
+// category field. Events matching neither get the severity of their log level.
+// It implements flag.Value, setting <key>=<severity> pairs.
+type Severities map[string]string
+
+// DefaultSeverities returns the default severities of notable events.
+func DefaultSeverities() Severities {
+	return Severities{
+		"Client temporarily blocked":         "notice",
+		"Malformed request rejected":         "notice",
+		"Non-SSH probe received":             "notice",
+		"Session limit exceeded":             "notice",
+		"Password spraying detected":         "warning",
+		"Credential replay detected":         "warning",
+		"Honeytoken credential used":         "critical",
+		"File execution attempted":           "critical",
+		"category:detection_attempt":         "notice",
+		"category:disk_recon":                "notice",
+		"category:sensitive_file_access":     "warning",
+		"category:spam_attempt":              "warning",
+		"category:execution_attempt":         "critical",
+		"category:file_upload":               "warning",
+		"category:history_access":            "notice",
+		"category:jump_attempt":              "notice",
+		"category:honeytoken_access":         "critical",
+		"category:network_recon":             "notice",
+		"category:package_install_attempt":   "notice",
+		"category:persistence_attempt":       "warning",
+		"category:proxy_attempt":             "notice",
+		"category:restricted_escape_attempt": "warning",
+	}
+}
+
+// Severity returns the severity of event.
+func (severities Severities) Severity(event Event) string {
+	if category, ok := event.Fields["category"]; ok {
+		if severity, ok := severities["category:"+fmt.Sprint(category)]; ok {
+			return severity
+		}
+	}
+	if severity, ok := severities[event.Message]; ok {
+		return severity
+	}
+	switch event.Level {
+	case log.PanicLevel, log.FatalLevel:
+		return "critical"
+	case log.ErrorLevel, log.WarnLevel:
+		return "warning"
+	case log.DebugLevel, log.TraceLevel:
+		return "debug"
+	}
+	return "info"
+}
+
+func (severities *Severities) String() string {
+	if severities == nil {
+		return ""
+	}
+	pairs := []string{}
+	for key, severity := range *severities {
+		pairs = append(pairs, key+"="+severity)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Set implements flag.Value.
+func (severities *Severities) Set(text string) error {
+	separator := strings.LastIndex(text, "=")
+	if separator == -1 {
+		return fmt.Errorf("invalid severity %q, must be <message or category:<category>>=<severity>", text)
+	}
+	key, severity := text[:separator], text[separator+1:]
+	valid := false
+	for _, level := range severityLevels {
+		valid = valid || level == severity
+	}
+	if !valid {
+		return fmt.Errorf("unknown severity %q, must be one of %v", severity, strings.Join(severityLevels, ", "))
+	}
+	if *severities == nil {
+		*severities = Severities{}
+	}
+	(*severities)[key] = severity
+	return nil
+}
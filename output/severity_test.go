@@ -0,0 +1,64 @@
+package output
+
+import (
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSeverity(t *testing.T) {
+	severities := DefaultSeverities()
+	for _, override := range []string{"Password authentication accepted=notice", "category:network_recon=debug"} {
+		if err := severities.Set(override); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, test := range []struct {
+		event    Event
+		severity string
+	}{
+		{Event{Level: log.InfoLevel, Message: "Password authentication rejected"}, "info"},
+		{Event{Level: log.WarnLevel, Message: "Password spraying detected"}, "warning"},
+		{Event{Level: log.InfoLevel, Message: "Command executed", Fields: log.Fields{"category": "execution_attempt"}}, "critical"},
+		// Categories take precedence over messages.
+		{Event{Level: log.InfoLevel, Message: "File execution attempted", Fields: log.Fields{"category": "network_recon"}}, "debug"},
+		{Event{Level: log.InfoLevel, Message: "Command executed", Fields: log.Fields{"category": "unknown"}}, "info"},
+		{Event{Level: log.InfoLevel, Message: "Password authentication accepted"}, "notice"},
+		// Unmapped events get the severity of their level.
+		{Event{Level: log.WarnLevel, Message: "Failed to accept channel"}, "warning"},
+		{Event{Level: log.DebugLevel, Message: "Connectivity check received"}, "debug"},
+	} {
+		if severity := severities.Severity(test.event); severity != test.severity {
+			t.Errorf("severity of %q with %v = %v, want %v", test.event.Message, test.event.Fields, severity, test.severity)
+		}
+	}
+}
+
+func TestSeveritySetInvalid(t *testing.T) {
+	severities := Severities{}
+	for _, text := range []string{"Command executed", "Command executed=urgent"} {
+		if err := severities.Set(text); err == nil {
+			t.Errorf("Set(%q) succeeded", text)
+		}
+	}
+}
+
+func TestSeverityEmitted(t *testing.T) {
+	sink := &recordingSink{}
+	dispatcher := &Dispatcher{Severities: DefaultSeverities()}
+	dispatcher.Add("recording", sink, 16)
+	dispatcher.Fire(&log.Entry{Time: time.Now(), Level: log.InfoLevel, Message: "Honeytoken credential used", Data: log.Fields{}})
+	dispatcher.Close()
+	if len(sink.events) != 1 || sink.events[0].Fields["severity"] != "critical" {
+		t.Fatalf("emitted %v, want a critical event", sink.events)
+	}
+
+	line, err := cefFormatter{}.Format(&log.Entry{Time: time.Now(), Level: log.InfoLevel, Message: "Honeytoken credential used", Data: sink.events[0].Fields})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header := strings.Split(string(line), "|"); len(header) < 7 || header[6] != "10" {
+		t.Errorf("CEF line %q, want severity 10", line)
+	}
+}
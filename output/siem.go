@@ -0,0 +1,281 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// ProductVersion is the version CEF and LEEF headers carry, set to the
+// version of the build.
+var ProductVersion = "dev"
+
+// NewFormatter returns the logrus formatter of a log format: text, json,
+// cef for ArcSight CEF or leef for IBM QRadar LEEF. Text and JSON write
+// timestamps this way, CEF and LEEF the way they define.
+func NewFormatter(format string, timestamps Timestamps) (log.Formatter, error) {
+	switch format {
+	case "text":
+		return timestamps.Formatter(false), nil
+	case "json":
+		return timestamps.Formatter(true), nil
+	case "cef":
+		return cefFormatter{}, nil
+	case "leef":
+		return leefFormatter{}, nil
+	}
+	return nil, fmt.Errorf("unknown log format %q, must be text, json, cef or leef", format)
+}
+
+// siemEvent is the signature ID and name of an event in CEF and LEEF, which
+// stay the same across releases for SIEM rules to rely on.
+type siemEvent struct {
+	id, name string
+}
+
+// siemEvents are the signatures of connection, authentication, command and
+// file events, other events being identified by their snake-cased message.
+var siemEvents = map[string]siemEvent{
+	"Client connected":                             {"100", "TCP connection accepted"},
+	"SSH connection established":                   {"101", "SSH connection established"},
+	"Client disconnected":                          {"102", "Client disconnected"},
+	"authentication accepted":                      {"200", "Authentication accepted"},
+	"authentication rejected":                      {"201", "Authentication rejected"},
+	"authentication partially succeeded":           {"202", "Authentication partially succeeded"},
+	"Multi-step authentication succeeded":          {"203", "Multi-step authentication succeeded"},
+	"Multi-step authentication failed":             {"204", "Multi-step authentication failed"},
+	"Authentication with disabled method rejected": {"205", "Authentication with disabled method rejected"},
+	"Command executed":                             {"300", "Command executed"},
+	"File uploaded":                                {"400", "File uploaded"},
+	"File downloaded":                              {"401", "File downloaded"},
+}
+
+// siemOutcomes are the outcomes of authentication events.
+var siemOutcomes = map[string]string{
+	"accepted":            "success",
+	"succeeded":           "success",
+	"rejected":            "failure",
+	"failed":              "failure",
+	"partially succeeded": "partial",
+}
+
+// siemSeverities are the 0 to 10 severities of CEF and LEEF of the severity
+// field of events, see Severities, and of the levels of the others.
+var siemSeverities = map[string]int{
+	"critical": 10, "error": 8, "warning": 6, "notice": 4, "info": 3, "debug": 1,
+}
+
+// siemSignature returns the signature of an event, and the method of an
+// authentication event in its message.
+func siemSignature(message string) (siemEvent, string) {
+	if event, ok := siemEvents[message]; ok {
+		return event, ""
+	}
+	if split := strings.SplitN(message, " authentication ", 2); len(split) == 2 {
+		if event, ok := siemEvents["authentication "+split[1]]; ok {
+			return event, strings.ToLower(split[0])
+		}
+	}
+	id := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return '_'
+	}, message)
+	return siemEvent{id, message}, ""
+}
+
+func siemSeverity(entry *log.Entry) int {
+	severity, ok := siemSeverities[fmt.Sprint(entry.Data["severity"])]
+	if !ok {
+		severity, ok = siemSeverities[entry.Level.String()]
+	}
+	if !ok {
+		severity = siemSeverities["critical"]
+	}
+	return severity
+}
+
+// siemValue formats a field value, as JSON if it isn't text.
+func siemValue(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case fmt.Stringer:
+		return value.String()
+	case error:
+		return value.Error()
+	}
+	if encoded, err := json.Marshal(value); err == nil {
+		return string(encoded)
+	}
+	return fmt.Sprint(value)
+}
+
+// siemKey turns a field name into a camel-cased key, as CEF only allows
+// alphanumeric keys.
+func siemKey(name string) string {
+	key := strings.Builder{}
+	upper := false
+	for _, r := range name {
+		switch {
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			upper = key.Len() != 0
+		case upper:
+			key.WriteRune(unicode.ToUpper(r))
+			upper = false
+		default:
+			key.WriteRune(r)
+		}
+	}
+	return key.String()
+}
+
+// siemAttribute is a key and value of the extension of a CEF or LEEF event.
+type siemAttribute struct {
+	key, value string
+}
+
+// siemAttributes maps the fields of an event to keys, those with a
+// dictionary key to it and the others, sorted, to their camel-cased name.
+// mapped maps field names to keys, and custom to CEF custom keys, labeled
+// with the name.
+func siemAttributes(entry *log.Entry, method string, mapped, custom map[string]string, clientKeys [2]string) []siemAttribute {
+	attributes := []siemAttribute{}
+	names := make([]string, 0, len(entry.Data))
+	for name := range entry.Data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	extra := []siemAttribute{}
+	for _, name := range names {
+		value := siemValue(entry.Data[name])
+		if name == "client" {
+			if host, port, err := net.SplitHostPort(value); err == nil {
+				attributes = append(attributes, siemAttribute{clientKeys[0], host}, siemAttribute{clientKeys[1], port})
+				continue
+			}
+		}
+		if key, ok := mapped[name]; ok {
+			attributes = append(attributes, siemAttribute{key, value})
+			continue
+		}
+		if key, ok := custom[name]; ok {
+			attributes = append(attributes, siemAttribute{key, value}, siemAttribute{key + "Label", name})
+			continue
+		}
+		if key := siemKey(name); key != "" {
+			extra = append(extra, siemAttribute{key, value})
+		}
+	}
+	if _, ok := entry.Data["method"]; !ok && method != "" {
+		if key, ok := custom["method"]; ok {
+			attributes = append(attributes, siemAttribute{key, method}, siemAttribute{key + "Label", "method"})
+		} else {
+			attributes = append(attributes, siemAttribute{"method", method})
+		}
+	}
+	return append(attributes, extra...)
+}
+
+// siemOutcome returns the outcome of an authentication event.
+func siemOutcome(message string) (string, bool) {
+	split := strings.SplitN(message, " authentication ", 2)
+	if len(split) != 2 {
+		return "", false
+	}
+	outcome, ok := siemOutcomes[split[1]]
+	return outcome, ok
+}
+
+// cefFields are the CEF dictionary keys of event fields.
+var cefFields = map[string]string{
+	"user":     "suser",
+	"reason":   "reason",
+	"path":     "fname",
+	"size":     "fsize",
+	"sha256":   "fileHash",
+	"url":      "request",
+	"category": "cat",
+}
+
+// cefCustomFields are the CEF custom keys of event fields, labeled with their
+// name.
+var cefCustomFields = map[string]string{
+	"exit_status":  "cn1",
+	"session_id":   "cs1",
+	"method":       "cs2",
+	"password":     "cs3",
+	"fingerprint":  "cs4",
+	"command_line": "cs5",
+	"duration":     "cs6",
+}
+
+// cefFormatter formats events as ArcSight Common Event Format, see the
+// ArcSight CEF Implementation Standard, with the time in milliseconds since
+// the Unix epoch.
+type cefFormatter struct{}
+
+var (
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+)
+
+func (cefFormatter) Format(entry *log.Entry) ([]byte, error) {
+	event, method := siemSignature(entry.Message)
+	line := strings.Builder{}
+	fmt.Fprintf(&line, "CEF:0|sshesame|sshesame|%v|%v|%v|%v|",
+		cefHeaderEscaper.Replace(ProductVersion), cefHeaderEscaper.Replace(event.id), cefHeaderEscaper.Replace(event.name), siemSeverity(entry))
+	fmt.Fprintf(&line, "rt=%d msg=%v", entry.Time.UnixNano()/1e6, cefExtensionEscaper.Replace(entry.Message))
+	if outcome, ok := siemOutcome(entry.Message); ok {
+		fmt.Fprintf(&line, " outcome=%v", outcome)
+	}
+	for _, attribute := range siemAttributes(entry, method, cefFields, cefCustomFields, [2]string{"src", "spt"}) {
+		fmt.Fprintf(&line, " %v=%v", attribute.key, cefExtensionEscaper.Replace(attribute.value))
+	}
+	line.WriteByte('\n')
+	return []byte(line.String()), nil
+}
+
+// leefFields are the LEEF predefined keys of event fields.
+var leefFields = map[string]string{
+	"user":     "usrName",
+	"category": "cat",
+	"url":      "url",
+}
+
+// leefFormatter formats events as IBM QRadar Log Event Extended Format 1.0,
+// with tab-separated attributes, and tabs and line breaks in values replaced
+// by spaces, as LEEF has no escaping for them.
+type leefFormatter struct{}
+
+var (
+	leefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ", "\t", " ")
+	leefValueEscaper  = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+)
+
+func (leefFormatter) Format(entry *log.Entry) ([]byte, error) {
+	event, method := siemSignature(entry.Message)
+	line := strings.Builder{}
+	fmt.Fprintf(&line, "LEEF:1.0|sshesame|sshesame|%v|%v|", leefHeaderEscaper.Replace(ProductVersion), leefHeaderEscaper.Replace(event.id))
+	fmt.Fprintf(&line, "devTime=%v\tdevTimeFormat=yyyy-MM-dd'T'HH:mm:ss.SSSZ\tsev=%v\tname=%v",
+		entry.Time.Format("2006-01-02T15:04:05.000-0700"), siemSeverity(entry), leefValueEscaper.Replace(event.name))
+	if outcome, ok := siemOutcome(entry.Message); ok {
+		fmt.Fprintf(&line, "\toutcome=%v", outcome)
+	}
+	switch entry.Message {
+	case "SSH connection established":
+		line.WriteString("\tisLoginEvent=true")
+	case "Client disconnected":
+		line.WriteString("\tisLogoutEvent=true")
+	}
+	for _, attribute := range siemAttributes(entry, method, leefFields, nil, [2]string{"src", "srcPort"}) {
+		fmt.Fprintf(&line, "\t%v=%v", attribute.key, leefValueEscaper.Replace(attribute.value))
+	}
+	line.WriteByte('\n')
+	return []byte(line.String()), nil
+}
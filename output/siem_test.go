@@ -0,0 +1,58 @@
+package output
+
+import (
+	log "github.com/sirupsen/logrus"
+	"testing"
+	"time"
+)
+
+func TestSIEMFormatters(t *testing.T) {
+	eventTime := time.Date(2026, 10, 14, 14, 0, 0, 123000000, time.FixedZone("CEST", 2*60*60))
+	for _, test := range []struct {
+		entry     log.Entry
+		cef, leef string
+	}{
+		{
+			log.Entry{Level: log.InfoLevel, Message: "Password authentication rejected", Data: log.Fields{
+				"client":         "192.0.2.1:12345",
+				"client_version": "SSH-2.0-Go",
+				"password":       "a\nb\tc",
+				"reason":         "x|y",
+				"user":           `ro=ot\`,
+			}},
+			// The method of the message is a field, and text fields are escaped.
+			`CEF:0|sshesame|sshesame|dev|201|Authentication rejected|3|rt=1791979200123 msg=Password authentication rejected outcome=failure src=192.0.2.1 spt=12345 cs3=a\nb	c cs3Label=password reason=x|y suser=ro\=ot\\ cs2=password cs2Label=method clientVersion=SSH-2.0-Go` + "\n",
+			"LEEF:1.0|sshesame|sshesame|dev|201|devTime=2026-10-14T14:00:00.123+0200\tdevTimeFormat=yyyy-MM-dd'T'HH:mm:ss.SSSZ\tsev=3\tname=Authentication rejected\toutcome=failure\tsrc=192.0.2.1\tsrcPort=12345\tusrName=ro=ot\\\tmethod=password\tclientVersion=SSH-2.0-Go\tpassword=a b c\treason=x|y\n",
+		},
+		{
+			log.Entry{Level: log.InfoLevel, Message: "SSH connection established", Data: log.Fields{"client": "[2001:db8::1]:22", "exit_status": 1, "severity": "warning"}},
+			"CEF:0|sshesame|sshesame|dev|101|SSH connection established|6|rt=1791979200123 msg=SSH connection established src=2001:db8::1 spt=22 cn1=1 cn1Label=exit_status severity=warning\n",
+			"LEEF:1.0|sshesame|sshesame|dev|101|devTime=2026-10-14T14:00:00.123+0200\tdevTimeFormat=yyyy-MM-dd'T'HH:mm:ss.SSSZ\tsev=6\tname=SSH connection established\tisLoginEvent=true\tsrc=2001:db8::1\tsrcPort=22\texitStatus=1\tseverity=warning\n",
+		},
+		{
+			// Unknown events are identified by their snake-cased message.
+			log.Entry{Level: log.ErrorLevel, Message: "Odd | event\\", Data: log.Fields{"tags": []string{"a", "b"}}},
+			`CEF:0|sshesame|sshesame|dev|odd___event_|Odd \| event\\|8|rt=1791979200123 msg=Odd | event\\ tags=["a","b"]` + "\n",
+			"LEEF:1.0|sshesame|sshesame|dev|odd___event_|devTime=2026-10-14T14:00:00.123+0200\tdevTimeFormat=yyyy-MM-dd'T'HH:mm:ss.SSSZ\tsev=8\tname=Odd | event\\\ttags=[\"a\",\"b\"]\n",
+		},
+	} {
+		entry := test.entry
+		entry.Time = eventTime
+		for _, format := range []struct {
+			name string
+			want string
+		}{{"cef", test.cef}, {"leef", test.leef}} {
+			formatter, err := NewFormatter(format.name, Timestamps{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			line, err := formatter.Format(&entry)
+			if err != nil || string(line) != format.want {
+				t.Errorf("%v formatted %q, %v, want %q", format.name, line, err, format.want)
+			}
+		}
+	}
+	if _, err := NewFormatter("gelf", Timestamps{}); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
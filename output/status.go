@@ -0,0 +1,41 @@
+package output
+
+import (
+	"sync"
+)
+
+// StatusSink wraps a sink, remembering whether the last event it emitted
+// failed, so that readiness checks can tell without trying the sink.
+type StatusSink struct {
+	sink Sink
+
+	mu  sync.Mutex
+	err error
+}
+
+// NewStatusSink returns a sink emitting to sink and tracking its status.
+func NewStatusSink(sink Sink) *StatusSink {
+	return &StatusSink{sink: sink}
+}
+
+// Emit implements Sink.
+func (status *StatusSink) Emit(event Event) error {
+	err := status.sink.Emit(event)
+	status.mu.Lock()
+	status.err = err
+	status.mu.Unlock()
+	return err
+}
+
+// Err returns the error of the last event emitted, nil if it succeeded. It
+// doesn't block on the sink.
+func (status *StatusSink) Err() error {
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	return status.err
+}
+
+// Close implements Sink.
+func (status *StatusSink) Close() error {
+	return status.sink.Close()
+}
@@ -0,0 +1,58 @@
+package output
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// failingSink fails to emit events while err is set, counting those it
+// emitted.
+type failingSink struct {
+	err     error
+	emitted int
+}
+
+func (sink *failingSink) Emit(event Event) error {
+	if sink.err != nil {
+		return sink.err
+	}
+	sink.emitted++
+	return nil
+}
+
+func (sink *failingSink) Close() error {
+	return nil
+}
+
+func TestStatusSink(t *testing.T) {
+	sink := &failingSink{}
+	status := NewStatusSink(sink)
+	if err := status.Err(); err != nil {
+		t.Errorf("Err before any event = %v, want nil", err)
+	}
+	sink.err = errors.New("connection refused")
+	status.Emit(Event{Message: "Client connected"})
+	if err := status.Err(); err != sink.err {
+		t.Errorf("Err after a failed event = %v, want %v", err, sink.err)
+	}
+	sink.err = nil
+	status.Emit(Event{Message: "Client connected"})
+	if err := status.Err(); err != nil {
+		t.Errorf("Err after a successful event = %v, want nil", err)
+	}
+}
+
+func TestStatusSinkInBreaker(t *testing.T) {
+	sink := &failingSink{err: errors.New("connection refused")}
+	status := NewStatusSink(sink)
+	breaker := NewBreakerSink("test", status, 1, time.Hour)
+	breaker.Emit(Event{Message: "Client connected"})
+	sink.err = nil
+	// The breaker is open, so events don't reach the sink and it's still
+	// reported as failing.
+	breaker.Emit(Event{Message: "Client connected"})
+	if status.Err() == nil {
+		t.Error("Err while the breaker is open = nil, want the last error")
+	}
+}
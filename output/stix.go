@@ -0,0 +1,333 @@
+package output
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"github.com/longkeyy/sshesame/outbound"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxSTIXIndicators bounds the indicators aggregated, once reached only
+	// existing ones are updated.
+	maxSTIXIndicators = 100000
+	// stixTimeFormat is the timestamp format of STIX, in UTC.
+	stixTimeFormat = "2006-01-02T15:04:05.000Z"
+	// taxiiMediaType is the media type of TAXII 2.1 requests and responses.
+	taxiiMediaType = "application/taxii+json;version=2.1"
+	taxiiTimeout   = 30 * time.Second
+)
+
+// stixNamespace is the namespace of the UUIDv5 identifiers of STIX objects,
+// the one STIX 2.1 uses for cyber-observable objects, so that the objects
+// of an indicator keep their identifiers across exports and restarts.
+var stixNamespace = [16]byte{0x00, 0xab, 0xed, 0xb4, 0xaa, 0x42, 0x46, 0x6c, 0x9c, 0x01, 0xfe, 0xd2, 0x33, 0x15, 0xa9, 0xb7}
+
+// STIXExport configures exporting the indicators observed in events as STIX
+// 2.1 bundles.
+type STIXExport struct {
+	// Path, if set, is the file the bundle of every indicator is written to,
+	// replaced at every export.
+	Path string
+	// CollectionURL, if set, is the URL of the TAXII 2.1 collection the
+	// indicators updated since the last push are pushed to, e.g.
+	// https://taxii.example.com/api1/collections/<id>/, with basic
+	// authentication as User and Password if User is set.
+	CollectionURL string
+	User          string
+	Password      string
+	// Interval is how often indicators are exported, they are also once the
+	// sink is closed.
+	Interval time.Duration
+}
+
+// stixIndicator is an indicator aggregated from events, and when and how
+// often it was seen.
+type stixIndicator struct {
+	name      string
+	pattern   string
+	firstSeen time.Time
+	lastSeen  time.Time
+	count     uint64
+}
+
+// confidence returns the STIX confidence of the indicator, from 0 to 100,
+// growing with how often it was seen: 30 once, 65 twice, over 90 from ten.
+func (indicator *stixIndicator) confidence() int {
+	return 100 - int(70/indicator.count)
+}
+
+// STIXSink aggregates the indicators events reveal, the addresses of clients
+// attempting to authenticate, the credentials they try, the SHA-256 hashes
+// of the files they put on the host and the commands they run, and exports
+// them as STIX 2.1 indicators along with sightings of when and how often
+// they were seen.
+type STIXSink struct {
+	export   STIXExport
+	client   *http.Client
+	identity map[string]interface{}
+
+	mu         sync.Mutex
+	indicators map[string]*stixIndicator
+	// pushed is when indicators were last pushed to the TAXII collection.
+	pushed time.Time
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewSTIXSink returns a sink exporting indicators as configured by export,
+// created by an identity named after the host.
+func NewSTIXSink(export STIXExport) *STIXSink {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	name := "sshesame on " + hostname
+	created := time.Now().UTC().Format(stixTimeFormat)
+	sink := &STIXSink{
+		export: export,
+		client: outbound.HTTPClient(taxiiTimeout),
+		identity: map[string]interface{}{
+			"type":           "identity",
+			"spec_version":   "2.1",
+			"id":             stixID("identity", name),
+			"created":        created,
+			"modified":       created,
+			"name":           name,
+			"identity_class": "system",
+		},
+		indicators: map[string]*stixIndicator{},
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go sink.exportEvery(export.Interval)
+	return sink
+}
+
+// stixID returns the identifier of the STIX object of a type, derived from
+// name.
+func stixID(objectType, name string) string {
+	hash := sha1.New()
+	hash.Write(stixNamespace[:])
+	hash.Write([]byte(objectType + "\x00" + name))
+	uuid := hash.Sum(nil)[:16]
+	uuid[6] = uuid[6]&0x0f | 0x50
+	uuid[8] = uuid[8]&0x3f | 0x80
+	return fmt.Sprintf("%v--%x-%x-%x-%x-%x", objectType, uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16])
+}
+
+// stixString quotes value as a string of a STIX pattern.
+func stixString(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+// Emit implements Sink.
+func (sink *STIXSink) Emit(event Event) error {
+	if event.Level == log.DebugLevel {
+		return nil
+	}
+	if strings.HasSuffix(event.Message, " authentication accepted") || strings.HasSuffix(event.Message, " authentication rejected") || strings.HasSuffix(event.Message, " authentication partially succeeded") {
+		if host, _, err := net.SplitHostPort(fmt.Sprint(event.Fields["client"])); err == nil {
+			if ip := net.ParseIP(host); ip != nil {
+				objectType := "ipv6-addr"
+				if ip.To4() != nil {
+					objectType = "ipv4-addr"
+				}
+				sink.observe(event.Time, "SSH honeypot client "+ip.String(), fmt.Sprintf("[%v:value = %v]", objectType, stixString(ip.String())))
+			}
+		}
+	}
+	if event.Message == "Password authentication accepted" || event.Message == "Password authentication rejected" {
+		user, password := fmt.Sprint(event.Fields["user"]), fmt.Sprint(event.Fields["password"])
+		sink.observe(event.Time, "SSH credential tried for "+user, fmt.Sprintf("[user-account:account_login = %v AND user-account:credential = %v]", stixString(user), stixString(password)))
+	}
+	if hash, ok := event.Fields["sha256"]; ok {
+		sink.observe(event.Time, "File put on an SSH honeypot", fmt.Sprintf("[file:hashes.'SHA-256' = %v]", stixString(fmt.Sprint(hash))))
+	}
+	if event.Message == "Command executed" {
+		command := fmt.Sprint(event.Fields["command"])
+		sink.observe(event.Time, "Command run on an SSH honeypot", fmt.Sprintf("[process:command_line = %v]", stixString(command)))
+	}
+	return nil
+}
+
+// observe records that the indicator with pattern was seen at seen.
+func (sink *STIXSink) observe(seen time.Time, name, pattern string) {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	indicator, ok := sink.indicators[pattern]
+	if !ok {
+		if len(sink.indicators) >= maxSTIXIndicators {
+			return
+		}
+		indicator = &stixIndicator{name: name, pattern: pattern, firstSeen: seen}
+		sink.indicators[pattern] = indicator
+	}
+	if seen.After(indicator.lastSeen) {
+		indicator.lastSeen = seen
+	}
+	indicator.count++
+}
+
+// objects returns the identity and the indicators and sightings of the
+// indicators last seen after since, sorted by pattern.
+func (sink *STIXSink) objects(since time.Time) []interface{} {
+	sink.mu.Lock()
+	indicators := []stixIndicator{}
+	for _, indicator := range sink.indicators {
+		if indicator.lastSeen.After(since) {
+			indicators = append(indicators, *indicator)
+		}
+	}
+	sink.mu.Unlock()
+	sort.Slice(indicators, func(i, j int) bool {
+		return indicators[i].pattern < indicators[j].pattern
+	})
+	identityID := sink.identity["id"]
+	objects := []interface{}{sink.identity}
+	for _, indicator := range indicators {
+		firstSeen := indicator.firstSeen.UTC().Format(stixTimeFormat)
+		lastSeen := indicator.lastSeen.UTC().Format(stixTimeFormat)
+		indicatorID := stixID("indicator", indicator.pattern)
+		count := indicator.count
+		if count > 999999999 {
+			count = 999999999
+		}
+		objects = append(objects, map[string]interface{}{
+			"type":            "indicator",
+			"spec_version":    "2.1",
+			"id":              indicatorID,
+			"created_by_ref":  identityID,
+			"created":         firstSeen,
+			"modified":        lastSeen,
+			"name":            indicator.name,
+			"indicator_types": []string{"malicious-activity"},
+			"pattern":         indicator.pattern,
+			"pattern_type":    "stix",
+			"valid_from":      firstSeen,
+			"confidence":      indicator.confidence(),
+		}, map[string]interface{}{
+			"type":               "sighting",
+			"spec_version":       "2.1",
+			"id":                 stixID("sighting", indicator.pattern),
+			"created_by_ref":     identityID,
+			"created":            firstSeen,
+			"modified":           lastSeen,
+			"first_seen":         firstSeen,
+			"last_seen":          lastSeen,
+			"count":              count,
+			"sighting_of_ref":    indicatorID,
+			"where_sighted_refs": []interface{}{identityID},
+		})
+	}
+	return objects
+}
+
+// exportEvery exports indicators every interval until the sink is closed.
+func (sink *STIXSink) exportEvery(interval time.Duration) {
+	defer close(sink.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-sink.stop:
+			return
+		}
+		if err := sink.exportNow(); err != nil {
+			errorLog.Warning("Failed to export STIX indicators:", err.Error())
+		}
+	}
+}
+
+// exportNow writes the bundle of every indicator to the file and pushes the
+// ones updated since the last push to the TAXII collection.
+func (sink *STIXSink) exportNow() error {
+	now := time.Now()
+	if sink.export.Path != "" {
+		if err := sink.writeBundle(); err != nil {
+			return err
+		}
+	}
+	if sink.export.CollectionURL != "" {
+		objects := sink.objects(sink.pushed)
+		if len(objects) == 1 {
+			return nil
+		}
+		if err := sink.push(objects); err != nil {
+			return err
+		}
+		sink.pushed = now
+	}
+	return nil
+}
+
+// writeBundle replaces the file with a bundle of every indicator.
+func (sink *STIXSink) writeBundle() error {
+	bundle, err := json.Marshal(map[string]interface{}{
+		"type":    "bundle",
+		"id":      stixID("bundle", time.Now().String()),
+		"objects": sink.objects(time.Time{}),
+	})
+	if err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(filepath.Dir(sink.export.Path), filepath.Base(sink.export.Path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(bundle, '\n')); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), sink.export.Path)
+}
+
+// push adds objects to the TAXII collection.
+func (sink *STIXSink) push(objects []interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{"objects": objects})
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(sink.export.CollectionURL, "/")+"/objects/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", taxiiMediaType)
+	request.Header.Set("Accept", taxiiMediaType)
+	if sink.export.User != "" {
+		request.SetBasicAuth(sink.export.User, sink.export.Password)
+	}
+	response, err := sink.client.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("TAXII server responded %v", response.Status)
+	}
+	return nil
+}
+
+// Close implements Sink, exporting indicators one last time.
+func (sink *STIXSink) Close() error {
+	close(sink.stop)
+	<-sink.done
+	return sink.exportNow()
+}
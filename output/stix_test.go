@@ -0,0 +1,215 @@
+package output
+
+import (
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stixIDPattern matches the identifiers of STIX objects, UUIDv5s prefixed
+// with their type.
+var stixIDPattern = regexp.MustCompile(`^([a-z-]+)--[0-9a-f]{8}-[0-9a-f]{4}-5[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+// stixObservations returns the events of a client trying two passwords,
+// putting a file on the host and running commands.
+func stixObservations(start time.Time) []Event {
+	event := func(offset time.Duration, level log.Level, message string, fields log.Fields) Event {
+		return Event{Time: start.Add(offset), Level: level, Message: message, Fields: fields}
+	}
+	return []Event{
+		event(0, log.InfoLevel, "Password authentication rejected", log.Fields{"client": "192.0.2.1:50000", "user": "root", "password": "123456"}),
+		event(time.Second, log.InfoLevel, "Password authentication accepted", log.Fields{"client": "192.0.2.1:50000", "user": "root", "password": "toor"}),
+		event(2*time.Second, log.InfoLevel, "File uploaded", log.Fields{"client": "192.0.2.1:50000", "sha256": "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"}),
+		event(3*time.Second, log.InfoLevel, "Command executed", log.Fields{"client": "192.0.2.1:50000", "command": "echo 'it''s' \\o/"}),
+		// Debug events, of low-signal commands, aren't indicators.
+		event(4*time.Second, log.DebugLevel, "Command executed", log.Fields{"client": "192.0.2.1:50000", "command": "ls"}),
+	}
+}
+
+// stixObjects returns the objects of a bundle or TAXII envelope by type,
+// checking the references between them.
+func stixObjects(t *testing.T, objects []map[string]interface{}) map[string][]map[string]interface{} {
+	t.Helper()
+	byType := map[string][]map[string]interface{}{}
+	ids := map[interface{}]bool{}
+	for _, object := range objects {
+		match := stixIDPattern.FindStringSubmatch(object["id"].(string))
+		if match == nil || match[1] != object["type"] {
+			t.Errorf("%v has the identifier %v", object["type"], object["id"])
+		}
+		if object["spec_version"] != "2.1" {
+			t.Errorf("%v has spec_version %v", object["id"], object["spec_version"])
+		}
+		ids[object["id"]] = true
+		byType[object["type"].(string)] = append(byType[object["type"].(string)], object)
+	}
+	if len(byType["identity"]) != 1 || objects[0]["type"] != "identity" {
+		t.Fatalf("objects start with %v, want the identity alone", objects[0]["type"])
+	}
+	for _, object := range objects[1:] {
+		refs := []interface{}{object["created_by_ref"]}
+		if object["type"] == "sighting" {
+			refs = append(refs, object["sighting_of_ref"])
+			refs = append(refs, object["where_sighted_refs"].([]interface{})...)
+		}
+		for _, ref := range refs {
+			if !ids[ref] {
+				t.Errorf("%v refers to %v, which isn't in the bundle", object["id"], ref)
+			}
+		}
+	}
+	return byType
+}
+
+func TestSTIXBundle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "indicators.json")
+	sink := NewSTIXSink(STIXExport{Path: path, Interval: time.Hour})
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, event := range stixObservations(start) {
+		if err := sink.Emit(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bundle struct {
+		Type    string
+		ID      string
+		Objects []map[string]interface{}
+	}
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		t.Fatal(err)
+	}
+	if bundle.Type != "bundle" || !stixIDPattern.MatchString(bundle.ID) {
+		t.Errorf("bundle has type %v and identifier %v", bundle.Type, bundle.ID)
+	}
+	objects := stixObjects(t, bundle.Objects)
+	if len(objects["indicator"]) != 5 || len(objects["sighting"]) != 5 {
+		t.Fatalf("bundle has %v indicators and %v sightings, want 5 of each", len(objects["indicator"]), len(objects["sighting"]))
+	}
+
+	indicators := map[interface{}]map[string]interface{}{}
+	for _, indicator := range objects["indicator"] {
+		if indicator["pattern_type"] != "stix" || indicator["valid_from"] != indicator["created"] {
+			t.Errorf("indicator %v has pattern_type %v and valid_from %v", indicator["pattern"], indicator["pattern_type"], indicator["valid_from"])
+		}
+		indicators[indicator["pattern"]] = indicator
+	}
+	sightings := map[interface{}]map[string]interface{}{}
+	for _, sighting := range objects["sighting"] {
+		sightings[sighting["sighting_of_ref"]] = sighting
+	}
+	for pattern, want := range map[string]struct {
+		confidence          float64
+		count               float64
+		firstSeen, lastSeen string
+	}{
+		"[ipv4-addr:value = '192.0.2.1']":                                                              {65, 2, "2024-03-01T12:00:00.000Z", "2024-03-01T12:00:01.000Z"},
+		"[user-account:account_login = 'root' AND user-account:credential = '123456']":                 {30, 1, "2024-03-01T12:00:00.000Z", "2024-03-01T12:00:00.000Z"},
+		"[user-account:account_login = 'root' AND user-account:credential = 'toor']":                   {30, 1, "2024-03-01T12:00:01.000Z", "2024-03-01T12:00:01.000Z"},
+		"[file:hashes.'SHA-256' = '2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae']": {30, 1, "2024-03-01T12:00:02.000Z", "2024-03-01T12:00:02.000Z"},
+		`[process:command_line = 'echo \'it\'\'s\' \\o/']`:                                             {30, 1, "2024-03-01T12:00:03.000Z", "2024-03-01T12:00:03.000Z"},
+	} {
+		indicator := indicators[pattern]
+		if indicator == nil {
+			t.Errorf("no indicator with the pattern %v", pattern)
+			continue
+		}
+		sighting := sightings[indicator["id"]]
+		if indicator["confidence"] != want.confidence || sighting["count"] != want.count {
+			t.Errorf("%v has confidence %v and was sighted %v times, want %v and %v", pattern, indicator["confidence"], sighting["count"], want.confidence, want.count)
+		}
+		if sighting["first_seen"] != want.firstSeen || sighting["last_seen"] != want.lastSeen || indicator["created"] != want.firstSeen || indicator["modified"] != want.lastSeen {
+			t.Errorf("%v seen from %v to %v, want from %v to %v", pattern, sighting["first_seen"], sighting["last_seen"], want.firstSeen, want.lastSeen)
+		}
+	}
+
+	// Identifiers derive from the indicators, so they're kept across
+	// exports and restarts.
+	if id := stixID("indicator", "[ipv4-addr:value = '192.0.2.1']"); indicators["[ipv4-addr:value = '192.0.2.1']"]["id"] != id {
+		t.Errorf("indicator identifier %v, want %v", indicators["[ipv4-addr:value = '192.0.2.1']"]["id"], id)
+	}
+}
+
+func TestSTIXConfidence(t *testing.T) {
+	for count, want := range map[uint64]int{1: 30, 2: 65, 3: 77, 10: 93, 100: 100} {
+		if confidence := (&stixIndicator{count: count}).confidence(); confidence != want {
+			t.Errorf("confidence after %v sightings = %v, want %v", count, confidence, want)
+		}
+	}
+}
+
+func TestTAXIIPush(t *testing.T) {
+	var mu sync.Mutex
+	pushes := [][]map[string]interface{}{}
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		user, password, ok := request.BasicAuth()
+		if request.Method != http.MethodPost || request.URL.Path != "/api1/collections/91a7b528/objects/" || request.Header.Get("Content-Type") != taxiiMediaType || request.Header.Get("Accept") != taxiiMediaType || !ok || user != "sshesame" || password != "secret" {
+			t.Errorf("pushed with %v %v and %v", request.Method, request.URL.Path, request.Header)
+		}
+		var envelope struct{ Objects []map[string]interface{} }
+		if err := json.NewDecoder(request.Body).Decode(&envelope); err != nil {
+			t.Errorf("pushed a body that isn't JSON: %v", err)
+		}
+		mu.Lock()
+		pushes = append(pushes, envelope.Objects)
+		mu.Unlock()
+		writer.Header().Set("Content-Type", taxiiMediaType)
+		writer.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+	sink := NewSTIXSink(STIXExport{CollectionURL: server.URL + "/api1/collections/91a7b528", User: "sshesame", Password: "secret", Interval: time.Hour})
+	observations := stixObservations(time.Now().Add(-time.Minute))
+	for _, event := range observations {
+		sink.Emit(event)
+	}
+	if err := sink.exportNow(); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing was seen since, so nothing is pushed.
+	if err := sink.exportNow(); err != nil {
+		t.Fatal(err)
+	}
+	// Only the indicators seen since the last push are pushed next.
+	sink.Emit(Event{Time: time.Now().Add(time.Second), Level: log.InfoLevel, Message: "Command executed", Fields: log.Fields{"command": "uname -a"}})
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(pushes) != 2 {
+		t.Fatalf("pushed %v times, want 2", len(pushes))
+	}
+	if objects := stixObjects(t, pushes[0]); len(objects["indicator"]) != 5 || len(objects["sighting"]) != 5 {
+		t.Errorf("first push has %v indicators and %v sightings, want 5 of each", len(objects["indicator"]), len(objects["sighting"]))
+	}
+	objects := stixObjects(t, pushes[1])
+	if len(objects["indicator"]) != 1 || objects["indicator"][0]["pattern"] != "[process:command_line = 'uname -a']" {
+		t.Errorf("second push has the indicators %v, want the new command's", objects["indicator"])
+	}
+}
+
+func TestTAXIIPushFails(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+	sink := NewSTIXSink(STIXExport{CollectionURL: server.URL, Interval: time.Hour})
+	sink.Emit(stixObservations(time.Now())[0])
+	if err := sink.Close(); err == nil {
+		t.Error("a rejected push wasn't reported")
+	}
+}
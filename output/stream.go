@@ -0,0 +1,156 @@
+package output
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// subscriptionBuffer is how many events a subscriber can lag behind before
+// its events are dropped.
+const subscriptionBuffer = 256
+
+// StreamFilter selects the events a subscriber receives. Every set part must
+// match, and an empty filter matches every event.
+type StreamFilter struct {
+	// Messages, Types and Categories are the messages, request or channel
+	// types, as EventType returns, and category fields of the events
+	// received.
+	Messages, Types, Categories map[string]bool
+	// Networks are those the clients of the events received are in.
+	Networks []*net.IPNet
+}
+
+func (filter StreamFilter) matches(event Event) bool {
+	if len(filter.Messages) != 0 && !filter.Messages[event.Message] {
+		return false
+	}
+	if len(filter.Types) != 0 && !filter.Types[EventType(event)] {
+		return false
+	}
+	if len(filter.Categories) != 0 && !filter.Categories[fmt.Sprint(event.Fields["category"])] {
+		return false
+	}
+	if len(filter.Networks) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(fmt.Sprint(event.Fields["client"]))
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	for _, network := range filter.Networks {
+		if ip != nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscription receives the events matching its filter as the JSON lines
+// log_file would contain, without their line breaks.
+type Subscription struct {
+	Events <-chan []byte
+	events chan []byte
+	filter StreamFilter
+	// dropped counts the events dropped because the subscriber lagged.
+	dropped uint64
+}
+
+// Dropped returns how many events the subscriber missed by lagging behind.
+func (subscription *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&subscription.dropped)
+}
+
+// StreamSink fans events out to subscribers as they are emitted, for
+// consumers following them in real time. Subscribers that don't keep up miss
+// events rather than holding the others back.
+type StreamSink struct {
+	formatter log.Formatter
+
+	mu            sync.Mutex
+	subscriptions map[*Subscription]bool
+	closed        bool
+}
+
+// NewStreamSink returns a sink with no subscribers yet.
+func NewStreamSink(timestamps Timestamps) *StreamSink {
+	return &StreamSink{formatter: timestamps.Formatter(true), subscriptions: map[*Subscription]bool{}}
+}
+
+// Subscribe returns a subscription to the events matching filter, whose
+// channel is closed once it's unsubscribed or the sink is closed.
+func (sink *StreamSink) Subscribe(filter StreamFilter) *Subscription {
+	events := make(chan []byte, subscriptionBuffer)
+	subscription := &Subscription{Events: events, events: events, filter: filter}
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if sink.closed {
+		close(events)
+		return subscription
+	}
+	sink.subscriptions[subscription] = true
+	return subscription
+}
+
+// Unsubscribe stops sending events to subscription.
+func (sink *StreamSink) Unsubscribe(subscription *Subscription) {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if sink.subscriptions[subscription] {
+		delete(sink.subscriptions, subscription)
+		close(subscription.events)
+	}
+}
+
+// Subscribers returns the number of subscriptions.
+func (sink *StreamSink) Subscribers() int {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	return len(sink.subscriptions)
+}
+
+// Emit implements Sink.
+func (sink *StreamSink) Emit(event Event) error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	var line []byte
+	for subscription := range sink.subscriptions {
+		if !subscription.filter.matches(event) {
+			continue
+		}
+		if line == nil {
+			formatted, err := sink.formatter.Format(&log.Entry{
+				Logger:  log.StandardLogger(),
+				Data:    event.Fields,
+				Time:    event.Time,
+				Level:   event.Level,
+				Message: event.Message,
+			})
+			if err != nil {
+				return err
+			}
+			line = formatted[:len(formatted)-1]
+		}
+		select {
+		case subscription.events <- line:
+		default:
+			atomic.AddUint64(&subscription.dropped, 1)
+		}
+	}
+	return nil
+}
+
+// Close implements Sink, ending every subscription.
+func (sink *StreamSink) Close() error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	for subscription := range sink.subscriptions {
+		close(subscription.events)
+	}
+	sink.subscriptions = map[*Subscription]bool{}
+	sink.closed = true
+	return nil
+}
@@ -0,0 +1,149 @@
+package output
+
+import (
+	"fmt"
+	"github.com/longkeyy/sshesame/outbound"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const syslogTimeout = 10 * time.Second
+
+// syslogFacilities are the facilities of RFC 5424 section 6.2.1 events can be
+// logged with.
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogSeverities are the codes of the severity field of events, see
+// Severities, and of the levels of the others.
+var syslogSeverities = map[string]int{
+	"critical": 2, "error": 3, "warning": 4, "notice": 5, "info": 6, "debug": 7,
+}
+
+// SyslogSink sends events to syslog as the JSON lines log_file would contain,
+// or in another format, with the severity of their severity field, or of their level without one.
+// Messages are formatted like the log/syslog package does: as BSD syslog for
+// the local daemon and with a hostname and RFC 3339 timestamp otherwise. The
+// connection is opened again when sending fails.
+type SyslogSink struct {
+	network, address string
+	facility         int
+	tag              string
+	hostname         string
+	formatter        log.Formatter
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewSyslogSink returns a sink sending events to the syslog daemon at
+// address over network, udp, tcp or unix, or to the local one over its Unix
+// socket if address is empty, tagged with tag and formatted by formatter.
+func NewSyslogSink(network, address, facility, tag string, formatter log.Formatter) (*SyslogSink, error) {
+	code, ok := syslogFacilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q", facility)
+	}
+	if address != "" && network != "udp" && network != "tcp" && network != "unix" {
+		return nil, fmt.Errorf("unknown syslog network %q, must be udp, tcp or unix", network)
+	}
+	hostname, _ := os.Hostname()
+	sink := &SyslogSink{
+		network:   network,
+		address:   address,
+		facility:  code,
+		tag:       tag,
+		hostname:  hostname,
+		formatter: formatter,
+	}
+	if err := sink.connect(); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+// connect opens the connection to the daemon. sink.mu must be held, unless
+// the sink isn't used yet.
+func (sink *SyslogSink) connect() error {
+	var err error
+	if sink.address == "" {
+		// The local daemon listens on a datagram or stream socket at one of
+		// these paths depending on the system.
+		for _, path := range []string{"/dev/log", "/var/run/syslog", "/var/run/log"} {
+			for _, network := range []string{"unixgram", "unix"} {
+				sink.conn, err = net.DialTimeout(network, path, syslogTimeout)
+				if err == nil {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("no local syslog daemon: %w", err)
+	}
+	if sink.network == "unix" {
+		sink.conn, err = net.DialTimeout("unix", sink.address, syslogTimeout)
+		return err
+	}
+	sink.conn, err = outbound.Dial(sink.network, sink.address, syslogTimeout)
+	return err
+}
+
+// Emit implements Sink.
+func (sink *SyslogSink) Emit(event Event) error {
+	line, err := sink.formatter.Format(&log.Entry{
+		Logger:  log.StandardLogger(),
+		Data:    event.Fields,
+		Time:    event.Time,
+		Level:   event.Level,
+		Message: event.Message,
+	})
+	if err != nil {
+		return err
+	}
+	severity, ok := syslogSeverities[fmt.Sprint(event.Fields["severity"])]
+	if !ok {
+		severity, ok = syslogSeverities[event.Level.String()]
+	}
+	if !ok {
+		severity = syslogSeverities["critical"]
+	}
+	priority := sink.facility*8 + severity
+	content := strings.TrimRight(string(line), "\n")
+	var message string
+	if sink.address == "" {
+		message = fmt.Sprintf("<%d>%s %s[%d]: %s\n", priority, event.Time.Format(time.Stamp), sink.tag, os.Getpid(), content)
+	} else {
+		message = fmt.Sprintf("<%d>%s %s %s[%d]: %s\n", priority, event.Time.Format(time.RFC3339), sink.hostname, sink.tag, os.Getpid(), content)
+	}
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if sink.conn != nil {
+		if _, err := sink.conn.Write([]byte(message)); err == nil {
+			return nil
+		}
+		sink.conn.Close()
+		sink.conn = nil
+	}
+	if err := sink.connect(); err != nil {
+		return err
+	}
+	_, err = sink.conn.Write([]byte(message))
+	return err
+}
+
+// Close implements Sink.
+func (sink *SyslogSink) Close() error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if sink.conn == nil {
+		return nil
+	}
+	return sink.conn.Close()
+}
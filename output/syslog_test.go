@@ -0,0 +1,108 @@
+package output
+
+import (
+	"bufio"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSyslogSinkUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	sink, err := NewSyslogSink("udp", conn.LocalAddr().String(), "local0", "sshesame", &log.JSONFormatter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	hostname, _ := os.Hostname()
+	eventTime := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	// The priority is the facility times 8 plus the severity, that of the
+	// severity field or of the level without one.
+	for _, test := range []struct {
+		level    log.Level
+		severity interface{}
+		priority int
+	}{
+		{log.InfoLevel, "warning", 16*8 + 4},
+		{log.InfoLevel, "critical", 16*8 + 2},
+		{log.InfoLevel, nil, 16*8 + 6},
+		{log.ErrorLevel, nil, 16*8 + 3},
+		{log.DebugLevel, nil, 16*8 + 7},
+		{log.PanicLevel, nil, 16*8 + 2},
+	} {
+		fields := log.Fields{}
+		content := fmt.Sprintf(`{"level":"%v","msg":"Command executed","time":"2026-10-14T12:00:00Z"}`, test.level)
+		if test.severity != nil {
+			fields["severity"] = test.severity
+			content = fmt.Sprintf(`{"level":"%v","msg":"Command executed","severity":"%v","time":"2026-10-14T12:00:00Z"}`, test.level, test.severity)
+		}
+		if err := sink.Emit(Event{Time: eventTime, Level: test.level, Message: "Command executed", Fields: fields}); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		message := make([]byte, 65536)
+		n, _, err := conn.ReadFrom(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("<%v>2026-10-14T12:00:00Z %v sshesame[%v]: %v\n", test.priority, hostname, os.Getpid(), content); string(message[:n]) != want {
+			t.Errorf("sent %q, want %q", message[:n], want)
+		}
+	}
+}
+
+func TestSyslogSinkStream(t *testing.T) {
+	for _, network := range []string{"tcp", "unix"} {
+		address := "127.0.0.1:0"
+		if network == "unix" {
+			address = filepath.Join(t.TempDir(), "syslog.sock")
+		}
+		listener, err := net.Listen(network, address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		sink, err := NewSyslogSink(network, listener.Addr().String(), "auth", "honeypot", &log.TextFormatter{DisableTimestamp: true, DisableColors: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		reader := bufio.NewReader(conn)
+		// Messages are separated by line breaks over streams.
+		for _, message := range []string{"Command executed", "Client disconnected"} {
+			sink.Emit(Event{Time: time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC), Level: log.WarnLevel, Message: message})
+		}
+		hostname, _ := os.Hostname()
+		for _, message := range []string{"Command executed", "Client disconnected"} {
+			want := fmt.Sprintf("<%v>2026-10-14T12:00:00Z %v honeypot[%v]: level=warning msg=\"%v\"\n", 4*8+4, hostname, os.Getpid(), message)
+			if line, err := reader.ReadString('\n'); err != nil || line != want {
+				t.Errorf("%v: sent %q, %v, want %q", network, line, err, want)
+			}
+		}
+		sink.Close()
+		conn.Close()
+	}
+}
+
+func TestNewSyslogSinkInvalid(t *testing.T) {
+	for _, test := range []struct{ network, address, facility string }{
+		{"udp", "127.0.0.1:514", "local8"},
+		{"tls", "127.0.0.1:6514", "local0"},
+	} {
+		if _, err := NewSyslogSink(test.network, test.address, test.facility, "sshesame", &log.JSONFormatter{}); err == nil {
+			t.Errorf("syslog sink created with %+v", test)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package output
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// Tags are fields added to every event of a client, told apart by the client
+// field of events, while it's connected, along with a seq field numbering
+// the client's events from 1 so that they can be put back in order. Events
+// without a client field, about the server as a whole like Connections
+// drained, Config file reloaded or circuit breakers opening, and events of
+// clients with no session, like connections refused by the connection limits,
+// aren't tagged.
+type Tags struct {
+	mu      sync.RWMutex
+	clients map[string]*clientTags
+}
+
+type clientTags struct {
+	fields log.Fields
+	events uint64
+}
+
+// NewTags returns tags for no clients.
+func NewTags() *Tags {
+	return &Tags{clients: map[string]*clientTags{}}
+}
+
+// Set adds fields to the events of client until it's removed.
+func (tags *Tags) Set(client net.Addr, fields log.Fields) {
+	tags.mu.Lock()
+	defer tags.mu.Unlock()
+	tags.clients[client.String()] = &clientTags{fields: fields}
+}
+
+// Remove stops tagging the events of client.
+func (tags *Tags) Remove(client net.Addr) {
+	tags.mu.Lock()
+	defer tags.mu.Unlock()
+	delete(tags.clients, client.String())
+}
+
+// add adds the tags of the client of an event to its fields, without
+// replacing any.
+func (tags *Tags) add(fields log.Fields) {
+	client, ok := fields["client"]
+	if !ok {
+		return
+	}
+	tags.mu.RLock()
+	defer tags.mu.RUnlock()
+	clientTags, ok := tags.clients[fmt.Sprint(client)]
+	if !ok {
+		return
+	}
+	for key, value := range clientTags.fields {
+		if _, ok := fields[key]; !ok {
+			fields[key] = value
+		}
+	}
+	if _, ok := fields["seq"]; !ok {
+		fields["seq"] = atomic.AddUint64(&clientTags.events, 1)
+	}
+}
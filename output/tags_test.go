@@ -0,0 +1,69 @@
+package output
+
+import (
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+func TestTags(t *testing.T) {
+	client := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 50000}
+	other := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 50000}
+	dispatcher := &Dispatcher{Tags: NewTags()}
+	sink := &recordingSink{}
+	dispatcher.Add("sink", sink, 16)
+	logger := log.New()
+	logger.Out = ioutil.Discard
+	logger.AddHook(dispatcher)
+
+	dispatcher.Tags.Set(client, log.Fields{"session_id": "abc", "personality": "ubuntu"})
+	logger.WithFields(log.Fields{"client": client}).Info("SSH connection established")
+	logger.WithFields(log.Fields{"client": client.String(), "personality": "debian"}).Info("Command executed")
+	// Events about the server as a whole, or of clients without a session,
+	// like connections refused before being handled, aren't tagged.
+	logger.WithFields(log.Fields{"drained": 1, "force_closed": 0}).Info("Connections drained")
+	logger.WithFields(log.Fields{"changed": []string{"shell"}}).Info("Config file reloaded")
+	logger.WithFields(log.Fields{"sink": "loki"}).Warning("Sink failing, circuit breaker opened")
+	logger.WithFields(log.Fields{"client": other, "max": 1, "category": "connection_limit"}).Warning("Connection limit reached")
+	dispatcher.Tags.Remove(client)
+	logger.WithFields(log.Fields{"client": client}).Info("Client disconnected")
+	dispatcher.Close()
+
+	if len(sink.events) != 7 {
+		t.Fatalf("sink received %v", sink.messages())
+	}
+	for i, want := range []log.Fields{
+		{"session_id": "abc", "personality": "ubuntu", "seq": uint64(1)},
+		// Fields of events aren't replaced.
+		{"session_id": "abc", "personality": "debian", "seq": uint64(2)},
+		{}, {}, {}, {}, {},
+	} {
+		event := sink.events[i]
+		for _, field := range []string{"session_id", "personality", "seq"} {
+			if event.Fields[field] != want[field] {
+				t.Errorf("%v has %v %v, want %v", event.Message, field, event.Fields[field], want[field])
+			}
+		}
+	}
+}
+
+func TestTagsKeptPerClient(t *testing.T) {
+	tags := NewTags()
+	first := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 50000}
+	second := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 50001}
+	tags.Set(first, log.Fields{"session_id": "first"})
+	tags.Set(second, log.Fields{"session_id": "second"})
+	for i, client := range []net.Addr{first, second, first} {
+		fields := log.Fields{"client": client}
+		tags.add(fields)
+		want := map[int]log.Fields{
+			0: {"session_id": "first", "seq": uint64(1)},
+			1: {"session_id": "second", "seq": uint64(1)},
+			2: {"session_id": "first", "seq": uint64(2)},
+		}[i]
+		if fields["session_id"] != want["session_id"] || fields["seq"] != want["seq"] {
+			t.Errorf("event %v of %v tagged with %v, want %v", i, client, fields, want)
+		}
+	}
+}
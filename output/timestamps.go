@@ -0,0 +1,68 @@
+package output
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// Timestamps is how event timestamps are written.
+type Timestamps struct {
+	// Layout is a time.Format layout, unused if EpochMillis is set.
+	Layout string
+	// EpochMillis writes timestamps as milliseconds since the Unix epoch.
+	EpochMillis bool
+}
+
+// ParseTimestamps parses a timestamp format: rfc3339, rfc3339nano,
+// epoch_millis or a custom time.Format layout.
+func ParseTimestamps(format string) (Timestamps, error) {
+	switch format {
+	case "rfc3339":
+		return Timestamps{Layout: time.RFC3339}, nil
+	case "rfc3339nano":
+		return Timestamps{Layout: time.RFC3339Nano}, nil
+	case "epoch_millis":
+		return Timestamps{EpochMillis: true}, nil
+	}
+	// A layout without any element of the reference time formats every time
+	// the same.
+	reference := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+	if reference.Format(format) == reference.Add(367*24*time.Hour+time.Hour+time.Minute+time.Second).Format(format) {
+		return Timestamps{}, fmt.Errorf("invalid timestamp layout %q", format)
+	}
+	return Timestamps{Layout: format}, nil
+}
+
+// Formatter returns a logrus formatter writing timestamps this way, as JSON if
+// json is set and as text otherwise.
+func (timestamps Timestamps) Formatter(json bool) log.Formatter {
+	if timestamps.EpochMillis {
+		// logrus only formats timestamps with layouts, so the timestamp is
+		// passed as a field in place of the real one.
+		fieldMap := log.FieldMap{log.FieldKeyTime: "fields.time"}
+		if json {
+			return epochFormatter{&log.JSONFormatter{DisableTimestamp: true, FieldMap: fieldMap}}
+		}
+		return epochFormatter{&log.TextFormatter{DisableTimestamp: true, FieldMap: fieldMap}}
+	}
+	if json {
+		return &log.JSONFormatter{TimestampFormat: timestamps.Layout}
+	}
+	return &log.TextFormatter{FullTimestamp: true, TimestampFormat: timestamps.Layout}
+}
+
+type epochFormatter struct {
+	formatter log.Formatter
+}
+
+func (formatter epochFormatter) Format(entry *log.Entry) ([]byte, error) {
+	data := make(log.Fields, len(entry.Data)+1)
+	for key, value := range entry.Data {
+		data[key] = value
+	}
+	data[log.FieldKeyTime] = entry.Time.UnixNano() / int64(time.Millisecond)
+	withTime := *entry
+	withTime.Data = data
+	return formatter.formatter.Format(&withTime)
+}
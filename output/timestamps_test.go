@@ -0,0 +1,79 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimestampsEmitted(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+	// Logged in a zone neither UTC nor Tokyo, so that the conversion shows.
+	paris := time.FixedZone("CEST", 2*60*60)
+	at := time.Date(2026, time.October, 4, 3, 29, 30, 123456789, paris)
+	for _, test := range []struct {
+		format   string
+		location *time.Location
+		want     interface{}
+	}{
+		{"rfc3339nano", time.UTC, "2026-10-04T01:29:30.123456789Z"},
+		{"rfc3339", time.UTC, "2026-10-04T01:29:30Z"},
+		{"rfc3339nano", tokyo, "2026-10-04T10:29:30.123456789+09:00"},
+		{"2006-01-02 15:04:05.000 MST", time.UTC, "2026-10-04 01:29:30.123 UTC"},
+		{"epoch_millis", time.UTC, float64(at.UnixNano() / int64(time.Millisecond))},
+	} {
+		timestamps, err := ParseTimestamps(test.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buffer bytes.Buffer
+		dispatcher := &Dispatcher{Location: test.location}
+		dispatcher.Add("writer", NewWriterSink(&buffer, timestamps.Formatter(true)), 16)
+		dispatcher.Fire(&log.Entry{Time: at, Level: log.InfoLevel, Message: "Client connected", Data: log.Fields{}})
+		dispatcher.Close()
+		var event map[string]interface{}
+		if err := json.Unmarshal(buffer.Bytes(), &event); err != nil {
+			t.Fatalf("%v: wrote %q: %v", test.format, buffer.String(), err)
+		}
+		if event["time"] != test.want {
+			t.Errorf("%v in %v: time = %v, want %v", test.format, test.location, event["time"], test.want)
+		}
+		if _, ok := event["fields.time"]; ok {
+			t.Errorf("%v: wrote fields.time as well as time", test.format)
+		}
+	}
+}
+
+func TestTimestampsText(t *testing.T) {
+	at := time.Date(2026, time.October, 4, 1, 29, 30, 123456789, time.UTC)
+	for format, want := range map[string]string{
+		"rfc3339nano":  `time="2026-10-04T01:29:30.123456789Z"`,
+		"epoch_millis": "time=1791077370123\n",
+	} {
+		timestamps, err := ParseTimestamps(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		line, err := timestamps.Formatter(false).Format(&log.Entry{Time: at, Level: log.InfoLevel, Message: "Client connected", Data: log.Fields{}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(line), want) {
+			t.Errorf("%v wrote %q, want %v", format, line, want)
+		}
+	}
+}
+
+func TestParseTimestampsInvalid(t *testing.T) {
+	for _, format := range []string{"", "not a layout", "epoch"} {
+		if _, err := ParseTimestamps(format); err == nil {
+			t.Errorf("ParseTimestamps(%q) succeeded", format)
+		}
+	}
+}
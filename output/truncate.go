@@ -0,0 +1,79 @@
+package output
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"unicode/utf8"
+)
+
+// truncateString shortens text to at most maxLength bytes followed by a
+// marker of how many were cut, keeping whole UTF-8 characters. It returns
+// whether text was too long.
+func truncateString(text string, maxLength int) (string, bool) {
+	if len(text) <= maxLength {
+		return text, false
+	}
+	end := maxLength
+	for end > 0 && !utf8.RuneStart(text[end]) {
+		end--
+	}
+	return fmt.Sprintf("%v...[truncated %v bytes]", text[:end], len(text)-end), true
+}
+
+// truncate shortens the string values of fields, and those of lists or maps of
+// strings, that are longer than maxLength in place. Other values are formatted
+// if they're too long. The original length and
+// SHA-256 hash of truncated strings are added as <key>_length and
+// <key>_sha256, so that events can still be correlated. It returns whether any
+// value was truncated.
+func truncate(fields log.Fields, maxLength int) bool {
+	truncated := false
+	added := log.Fields{}
+	for key, value := range fields {
+		if stringer, ok := value.(fmt.Stringer); ok {
+			value = stringer.String()
+		}
+		switch value := value.(type) {
+		case string:
+			text, ok := truncateString(value, maxLength)
+			if !ok {
+				continue
+			}
+			sum := sha256.Sum256([]byte(value))
+			fields[key] = text
+			added[key+"_length"] = len(value)
+			added[key+"_sha256"] = hex.EncodeToString(sum[:])
+			truncated = true
+		case []string:
+			texts := make([]string, len(value))
+			changed := false
+			for i, text := range value {
+				var ok bool
+				texts[i], ok = truncateString(text, maxLength)
+				changed = changed || ok
+			}
+			if changed {
+				fields[key] = texts
+				truncated = true
+			}
+		case map[string]string:
+			texts := make(map[string]string, len(value))
+			changed := false
+			for name, text := range value {
+				var ok bool
+				texts[name], ok = truncateString(text, maxLength)
+				changed = changed || ok
+			}
+			if changed {
+				fields[key] = texts
+				truncated = true
+			}
+		}
+	}
+	for key, value := range added {
+		fields[key] = value
+	}
+	return truncated
+}
@@ -0,0 +1,64 @@
+package output
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOversizedFieldsTruncated(t *testing.T) {
+	command := "echo " + strings.Repeat("QUJD", 256) + " | base64 -d > /tmp/x"
+	sum := sha256.Sum256([]byte(command))
+	public, restricted := &recordingSink{}, &recordingSink{}
+	dispatcher := &Dispatcher{MaxFieldLength: 16}
+	dispatcher.Add("public", public, 16)
+	dispatcher.AddRaw("restricted", restricted, 16)
+	dispatcher.Fire(&log.Entry{Time: time.Now(), Level: log.InfoLevel, Message: "Command executed", Data: log.Fields{
+		"command": command,
+		"argv":    []string{"echo", strings.Repeat("QUJD", 256)},
+		"user":    "root",
+	}})
+	dispatcher.Close()
+
+	fields := public.events[0].Fields
+	for field, want := range map[string]interface{}{
+		"command":        fmt.Sprintf("echo QUJDQUJDQUJ...[truncated %v bytes]", len(command)-16),
+		"command_length": len(command),
+		"command_sha256": hex.EncodeToString(sum[:]),
+		"user":           "root",
+		"truncated":      true,
+	} {
+		if fields[field] != want {
+			t.Errorf("%v = %v, want %v", field, fields[field], want)
+		}
+	}
+	if argv := fields["argv"]; !reflect.DeepEqual(argv, []string{"echo", "QUJDQUJDQUJDQUJD...[truncated 1008 bytes]"}) {
+		t.Errorf("argv = %q, want the long argument truncated", argv)
+	}
+	if raw := restricted.events[0].Fields; raw["command"] != command || raw["truncated"] != nil {
+		t.Errorf("raw sink received %.32q, truncated %v, want the full command", raw["command"], raw["truncated"])
+	}
+}
+
+func TestTruncateString(t *testing.T) {
+	for _, test := range []struct {
+		text      string
+		maxLength int
+		want      string
+	}{
+		{"short", 16, "short"},
+		{"exactly sixteen!", 16, "exactly sixteen!"},
+		{"0123456789", 4, "0123...[truncated 6 bytes]"},
+		// Characters aren't cut in half.
+		{"héllo", 2, "h...[truncated 5 bytes]"},
+	} {
+		if text, truncated := truncateString(test.text, test.maxLength); text != test.want || truncated != (text != test.text) {
+			t.Errorf("truncateString(%q, %v) = %q, %v, want %q", test.text, test.maxLength, text, truncated, test.want)
+		}
+	}
+}
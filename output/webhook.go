@@ -0,0 +1,79 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/longkeyy/sshesame/outbound"
+	"net/http"
+	"time"
+)
+
+const webhookTimeout = 10 * time.Second
+
+// WebhookSink posts events to an HTTP endpoint as JSON arrays of the objects
+// log_file would contain, batched like a log file. Failed posts are retried
+// with exponential backoff, then the batch is dropped.
+type WebhookSink struct {
+	url     string
+	headers http.Header
+	client  *http.Client
+	batcher *pushBatcher
+}
+
+// NewWebhookSink returns a sink posting events to url with headers.
+func NewWebhookSink(url string, headers http.Header, timestamps Timestamps, batching Batching) *WebhookSink {
+	sink := &WebhookSink{
+		url:     url,
+		headers: headers,
+		client:  outbound.HTTPClient(webhookTimeout),
+	}
+	sink.batcher = newPushBatcher(timestamps, batching, sink.flush)
+	return sink
+}
+
+// Emit implements Sink.
+func (sink *WebhookSink) Emit(event Event) error {
+	return sink.batcher.add(event)
+}
+
+func (sink *WebhookSink) flush(lines []pushedLine) error {
+	var body bytes.Buffer
+	body.WriteByte('[')
+	for i, line := range lines {
+		if i != 0 {
+			body.WriteByte(',')
+		}
+		body.Write(line.line)
+	}
+	body.WriteByte(']')
+	return retryPush(func() (bool, error) {
+		return sink.post(body.Bytes())
+	})
+}
+
+// post sends a batch once, reporting whether a failure is worth retrying.
+func (sink *WebhookSink) post(body []byte) (bool, error) {
+	request, err := http.NewRequest(http.MethodPost, sink.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for name, values := range sink.headers {
+		request.Header[name] = values
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := sink.client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	response.Body.Close()
+	if response.StatusCode/100 == 2 {
+		return false, nil
+	}
+	retry := response.StatusCode == http.StatusTooManyRequests || response.StatusCode/100 == 5
+	return retry, fmt.Errorf("webhook responded %v", response.Status)
+}
+
+// Close implements Sink, posting the partial batch.
+func (sink *WebhookSink) Close() error {
+	return sink.batcher.close()
+}
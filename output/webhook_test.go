@@ -0,0 +1,47 @@
+package output
+
+import (
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhookSinkPosts(t *testing.T) {
+	var mu sync.Mutex
+	bodies := []string{}
+	// The endpoint fails once, then refuses the last batch.
+	statuses := []int{http.StatusServiceUnavailable, http.StatusOK, http.StatusBadRequest}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" || r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("%v with %v", r.Method, r.Header)
+		}
+		bodies = append(bodies, string(body))
+		w.WriteHeader(statuses[0])
+		statuses = statuses[1:]
+	}))
+	defer server.Close()
+	sink := NewWebhookSink(server.URL, http.Header{"Authorization": {"Bearer secret"}}, Timestamps{}, Batching{Size: 2})
+	eventTime := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	for _, message := range []string{"Command executed", "Client disconnected", "Command executed"} {
+		if err := sink.Emit(Event{Time: eventTime, Level: log.InfoLevel, Message: message}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err == nil {
+		t.Error("batch refused with 400 not reported")
+	}
+
+	batch := `[{"level":"info","msg":"Command executed","time":"2026-10-14T12:00:00Z"},{"level":"info","msg":"Client disconnected","time":"2026-10-14T12:00:00Z"}]`
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{batch, batch, `[{"level":"info","msg":"Command executed","time":"2026-10-14T12:00:00Z"}]`}; len(bodies) != len(want) || bodies[0] != want[0] || bodies[1] != want[1] || bodies[2] != want[2] {
+		t.Errorf("posted %q, want %q", bodies, want)
+	}
+}
@@ -0,0 +1,88 @@
+package output
+
+import (
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+)
+
+// WriterSink writes events formatted by a logrus formatter to a writer.
+type WriterSink struct {
+	writer    io.Writer
+	formatter log.Formatter
+}
+
+// NewWriterSink returns a sink writing events to writer. If writer is also an
+// io.Closer, it is closed along with the sink.
+func NewWriterSink(writer io.Writer, formatter log.Formatter) *WriterSink {
+	return &WriterSink{writer, formatter}
+}
+
+// NewFileSink returns a sink appending events to the file at path as lines
+// formatted by formatter, in batches, chained and rotated if configured. Rotated files can't
+// be chained, as the chain spans the whole file, nor can files of lines other than JSON
+// objects, as the chain is a field of them.
+func NewFileSink(path string, formatter log.Formatter, batching Batching, chaining Chaining, rotation Rotation) (*WriterSink, error) {
+	if rotation.MaxSize > 0 && len(chaining.Key) != 0 {
+		return nil, errors.New("chained log files can't be rotated")
+	}
+	if len(chaining.Key) != 0 && !isJSONFormatter(formatter) {
+		return nil, errors.New("only log files in the json format can be chained")
+	}
+	var file io.WriteCloser
+	var err error
+	if rotation.MaxSize > 0 {
+		file, err = openRotatingFile(path, rotation)
+	} else {
+		file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+	writer := file
+	if batching.Size > 1 || batching.Gzip {
+		writer = newBatchWriter(file, batching)
+	}
+	if len(chaining.Key) != 0 {
+		writer, err = newChainWriter(writer, path, chaining)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return NewWriterSink(writer, formatter), nil
+}
+
+// isJSONFormatter returns whether formatter formats entries as JSON objects.
+func isJSONFormatter(formatter log.Formatter) bool {
+	if epoch, ok := formatter.(epochFormatter); ok {
+		formatter = epoch.formatter
+	}
+	_, ok := formatter.(*log.JSONFormatter)
+	return ok
+}
+
+// Emit implements Sink.
+func (sink *WriterSink) Emit(event Event) error {
+	line, err := sink.formatter.Format(&log.Entry{
+		Logger:  log.StandardLogger(),
+		Data:    event.Fields,
+		Time:    event.Time,
+		Level:   event.Level,
+		Message: event.Message,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = sink.writer.Write(line)
+	return err
+}
+
+// Close implements Sink.
+func (sink *WriterSink) Close() error {
+	if closer, ok := sink.writer.(io.Closer); ok && sink.writer != os.Stderr && sink.writer != os.Stdout {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package main
+
+import (
+	"errors"
+)
+
+func dropPrivileges(userName, groupName string) (int, int, error) {
+	return 0, 0, errors.New("switching to another user isn't supported on this platform")
+}
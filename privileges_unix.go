@@ -0,0 +1,57 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// dropPrivileges switches the process, started as root, to userName and
+// groupName, or the primary group of userName if empty, for every thread.
+// It returns the uid and gid switched to.
+func dropPrivileges(userName, groupName string) (int, int, error) {
+	if os.Geteuid() != 0 {
+		return 0, 0, errors.New("only root can switch to another user")
+	}
+	account, err := user.Lookup(userName)
+	if err != nil {
+		return 0, 0, err
+	}
+	uid, err := strconv.Atoi(account.Uid)
+	if err != nil {
+		return 0, 0, fmt.Errorf("user %v has the non-numeric uid %q", userName, account.Uid)
+	}
+	gidText := account.Gid
+	if groupName != "" {
+		group, err := user.LookupGroup(groupName)
+		if err != nil {
+			return 0, 0, err
+		}
+		gidText = group.Gid
+	}
+	gid, err := strconv.Atoi(gidText)
+	if err != nil {
+		return 0, 0, fmt.Errorf("group %v has the non-numeric gid %q", groupName, gidText)
+	}
+	// The supplementary groups of root, and then the group, must go before
+	// the user, which can't change them anymore.
+	if err := syscall.Setgroups([]int{gid}); err != nil {
+		return 0, 0, fmt.Errorf("failed to set supplementary groups: %v", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return 0, 0, fmt.Errorf("failed to set group: %v", err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return 0, 0, fmt.Errorf("failed to set user: %v", err)
+	}
+	if uid != 0 && syscall.Setuid(0) == nil {
+		return 0, 0, errors.New("root privileges could be regained")
+	}
+	return uid, gid, nil
+}
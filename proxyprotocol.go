@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyV2Signature starts PROXY protocol version 2 headers, see
+// https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// maxProxyV1Header is the longest a version 1 header can be.
+const maxProxyV1Header = 107
+
+// proxyNetworks implements flag.Value, parsing a comma-separated list of
+// networks in CIDR notation or addresses.
+type proxyNetworks []*net.IPNet
+
+func (networks *proxyNetworks) String() string {
+	if networks == nil {
+		return ""
+	}
+	texts := make([]string, len(*networks))
+	for i, network := range *networks {
+		texts[i] = network.String()
+	}
+	return strings.Join(texts, ",")
+}
+
+// Set implements flag.Value.
+func (networks *proxyNetworks) Set(text string) error {
+	parsed := proxyNetworks{}
+	for _, entry := range strings.Split(text, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			parsed = append(parsed, network)
+			continue
+		}
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return fmt.Errorf("invalid proxy %q, must be a CIDR or IP", entry)
+		}
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		parsed = append(parsed, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	*networks = parsed
+	return nil
+}
+
+// contains reports whether addr is within networks.
+func (networks proxyNetworks) contains(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyConn is a connection whose client address was conveyed by a PROXY
+// protocol header, reading what followed the header first.
+type proxyConn struct {
+	net.Conn
+	reader     *bufio.Reader
+	remoteAddr net.Addr
+}
+
+func (conn *proxyConn) Read(p []byte) (int, error) {
+	return conn.reader.Read(p)
+}
+
+func (conn *proxyConn) RemoteAddr() net.Addr {
+	return conn.remoteAddr
+}
+
+// proxyListener reads the PROXY protocol header of the connections accepted
+// from the proxies, replacing their client address with the one conveyed,
+// so that logs, blocking, limits and GeoIP see the real client. Headers are
+// read concurrently, so that a connection sending it slowly doesn't hold up
+// the others, and connections failing to send one within timeout are
+// closed. Connections from other addresses are served as they are.
+type proxyListener struct {
+	net.Listener
+	proxies proxyNetworks
+	timeout time.Duration
+
+	conns     chan net.Conn
+	errs      chan error
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newProxyListener(listener net.Listener, proxies proxyNetworks, timeout time.Duration) *proxyListener {
+	proxyListener := &proxyListener{
+		Listener: listener,
+		proxies:  proxies,
+		timeout:  timeout,
+		conns:    make(chan net.Conn),
+		errs:     make(chan error),
+		closed:   make(chan struct{}),
+	}
+	go proxyListener.acceptLoop()
+	return proxyListener
+}
+
+func (listener *proxyListener) acceptLoop() {
+	for {
+		conn, err := listener.Listener.Accept()
+		if err != nil {
+			select {
+			case listener.errs <- err:
+			case <-listener.closed:
+				return
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		if !listener.proxies.contains(conn.RemoteAddr()) {
+			listener.deliver(conn)
+			continue
+		}
+		go func() {
+			proxied, err := readProxyHeader(conn, listener.timeout)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"proxy": conn.RemoteAddr(),
+				}).Warning("Failed to read PROXY protocol header:", err.Error())
+				conn.Close()
+				return
+			}
+			listener.deliver(proxied)
+		}()
+	}
+}
+
+// deliver hands conn to Accept, closing it if the listener is closed.
+func (listener *proxyListener) deliver(conn net.Conn) {
+	select {
+	case listener.conns <- conn:
+	case <-listener.closed:
+		conn.Close()
+	}
+}
+
+// Accept implements net.Listener.
+func (listener *proxyListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-listener.conns:
+		return conn, nil
+	case err := <-listener.errs:
+		return nil, err
+	case <-listener.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close implements net.Listener.
+func (listener *proxyListener) Close() error {
+	listener.closeOnce.Do(func() { close(listener.closed) })
+	return listener.Listener.Close()
+}
+
+// readProxyHeader reads the version 1 or 2 PROXY protocol header of conn
+// within timeout. Headers of local connections, like health checks of the
+// proxy, or of unknown protocols keep the address of conn.
+func readProxyHeader(conn net.Conn, timeout time.Duration) (net.Conn, error) {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+	reader := bufio.NewReader(conn)
+	proxied := &proxyConn{Conn: conn, reader: reader, remoteAddr: conn.RemoteAddr()}
+	// Only the first byte tells the versions apart, as a version 1 header
+	// can be shorter than the version 2 signature, and clients may send
+	// nothing more until the server identifies itself.
+	first, err := reader.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	var addr net.Addr
+	if first[0] == proxyV2Signature[0] {
+		addr, err = readProxyV2Header(reader)
+	} else {
+		addr, err = readProxyV1Header(reader)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if addr != nil {
+		proxied.remoteAddr = addr
+	}
+	return proxied, nil
+}
+
+// readProxyV1Header reads a human-readable header, returning the source
+// address, nil for UNKNOWN.
+func readProxyV1Header(reader *bufio.Reader) (net.Addr, error) {
+	line := []byte{}
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= maxProxyV1Header {
+			return nil, errors.New("PROXY protocol header too long")
+		}
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+	}
+	fields := strings.Split(strings.TrimSuffix(string(line), "\r\n"), " ")
+	if fields[0] != "PROXY" {
+		return nil, errors.New("no PROXY protocol header")
+	}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid PROXY protocol header %q", line)
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil || (ip.To4() != nil) != (fields[1] == "TCP4") {
+		return nil, fmt.Errorf("invalid PROXY protocol header %q", line)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyV2Header reads a binary header, returning the source address, nil
+// for local connections and other families than TCP over IPv4 and IPv6.
+// TLVs are skipped.
+func readProxyV2Header(reader *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, len(proxyV2Signature)+4)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:len(proxyV2Signature)], proxyV2Signature) {
+		return nil, errors.New("no PROXY protocol header")
+	}
+	versionCommand, family := header[12], header[13]
+	body := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return nil, err
+	}
+	if versionCommand>>4 != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version %v", versionCommand>>4)
+	}
+	switch versionCommand & 0xf {
+	case 0:
+		return nil, nil
+	case 1:
+	default:
+		return nil, fmt.Errorf("unsupported PROXY protocol command %v", versionCommand&0xf)
+	}
+	size := 0
+	switch family {
+	case 0x11:
+		size = net.IPv4len
+	case 0x21:
+		size = net.IPv6len
+	default:
+		return nil, nil
+	}
+	if len(body) < 2*size+4 {
+		return nil, errors.New("truncated PROXY protocol addresses")
+	}
+	ip := net.IP(append([]byte{}, body[:size]...))
+	port := binary.BigEndian.Uint16(body[2*size:])
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
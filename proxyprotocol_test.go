@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// proxyV2Header returns a version 2 header with versionCommand, family and
+// body.
+func proxyV2Header(versionCommand, family byte, body []byte) string {
+	header := append(append([]byte{}, proxyV2Signature...), versionCommand, family, 0, 0)
+	binary.BigEndian.PutUint16(header[14:], uint16(len(body)))
+	return string(append(header, body...))
+}
+
+// proxyV2Addresses returns the address block of a header from source to
+// destination.
+func proxyV2Addresses(source, destination string, sourcePort, destinationPort uint16) []byte {
+	block := []byte{}
+	for _, text := range []string{source, destination} {
+		ip := net.ParseIP(text)
+		if ip.To4() != nil {
+			ip = ip.To4()
+		}
+		block = append(block, ip...)
+	}
+	ports := make([]byte, 4)
+	binary.BigEndian.PutUint16(ports, sourcePort)
+	binary.BigEndian.PutUint16(ports[2:], destinationPort)
+	return append(block, ports...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	ipv4 := proxyV2Addresses("192.0.2.1", "198.51.100.1", 56324, 22)
+	ipv6 := proxyV2Addresses("2001:db8::1", "2001:db8::2", 56324, 22)
+	// PP2_TYPE_AUTHORITY and PP2_TYPE_NOOP, which are skipped.
+	tlvs := append(append([]byte{}, ipv4...), 0x02, 0x00, 0x0b, 'e', 'x', 'a', 'm', 'p', 'l', 'e', '.', 'c', 'o', 'm', 0x04, 0x00, 0x02, 0, 0)
+	address := strings.Repeat("ffff:", 7) + "ffff"
+	longest := "PROXY UNKNOWN " + address + " " + address + " 65535 65535\r\n"
+	for _, test := range []struct {
+		name   string
+		header string
+		// rest follows the header, and the client closes the connection
+		// after it unless hold is set.
+		rest string
+		hold bool
+		// addr is the address read, empty for the one of the connection
+		// and "error" for an invalid header.
+		addr string
+	}{
+		{"v1 TCP4", "PROXY TCP4 192.0.2.1 198.51.100.1 56324 22\r\n", "SSH-2.0-OpenSSH_9.6\r\n", false, "192.0.2.1:56324"},
+		{"v1 TCP6", "PROXY TCP6 2001:db8::1 2001:db8::2 56324 22\r\n", "SSH-2.0-OpenSSH_9.6\r\n", false, "[2001:db8::1]:56324"},
+		// Shorter than the version 2 signature, with nothing sent after.
+		{"v1 UNKNOWN", "PROXY UNKNOWN\r\n", "", true, ""},
+		{"v1 longest", longest, "SSH-2.0-OpenSSH_9.6\r\n", false, ""},
+		{"v1 mismatched family", "PROXY TCP4 2001:db8::1 2001:db8::2 56324 22\r\n", "", false, "error"},
+		{"v1 invalid port", "PROXY TCP4 192.0.2.1 198.51.100.1 65536 22\r\n", "", false, "error"},
+		{"v1 missing fields", "PROXY TCP4 192.0.2.1 198.51.100.1\r\n", "", false, "error"},
+		{"v1 truncated", "PROXY TCP4 192.0.2.1 198.51", "", false, "error"},
+		{"v1 too long", "PROXY UNKNOWN " + strings.Repeat("f", 100) + "\r\n", "", true, "error"},
+		{"not a header", "SSH-2.0-OpenSSH_9.6\r\n", "", false, "error"},
+		{"v2 TCP4", proxyV2Header(0x21, 0x11, ipv4), "SSH-2.0-OpenSSH_9.6\r\n", false, "192.0.2.1:56324"},
+		{"v2 TCP6", proxyV2Header(0x21, 0x21, ipv6), "SSH-2.0-OpenSSH_9.6\r\n", false, "[2001:db8::1]:56324"},
+		{"v2 TLVs", proxyV2Header(0x21, 0x11, tlvs), "SSH-2.0-OpenSSH_9.6\r\n", true, "192.0.2.1:56324"},
+		{"v2 LOCAL", proxyV2Header(0x20, 0x00, nil), "", true, ""},
+		{"v2 UDP", proxyV2Header(0x21, 0x12, ipv4), "", true, ""},
+		{"v2 unix sockets", proxyV2Header(0x21, 0x31, make([]byte, 216)), "", true, ""},
+		{"v2 version 3", proxyV2Header(0x31, 0x11, ipv4), "", false, "error"},
+		{"v2 unknown command", proxyV2Header(0x22, 0x11, ipv4), "", false, "error"},
+		{"v2 truncated addresses", proxyV2Header(0x21, 0x21, ipv4), "", false, "error"},
+		{"v2 truncated signature", string(proxyV2Signature[:7]), "", false, "error"},
+		{"v2 truncated length", string(proxyV2Signature) + "\x21\x11\x00", "", false, "error"},
+		{"v2 oversized length", proxyV2Header(0x21, 0x11, ipv4)[:14] + "\xff\xff" + string(ipv4), "", false, "error"},
+		{"v2 invalid signature", "\r\n\r\n\x00\r\nQUIT!" + proxyV2Header(0x21, 0x11, ipv4)[12:], "", false, "error"},
+	} {
+		client, server := net.Pipe()
+		go func() {
+			io.WriteString(client, test.header+test.rest)
+			if !test.hold {
+				client.Close()
+			}
+		}()
+		proxied, err := readProxyHeader(server, time.Second)
+		if test.addr == "error" {
+			if err == nil {
+				t.Errorf("%v: header %q read from %v", test.name, test.header, proxied.RemoteAddr())
+			}
+			server.Close()
+			client.Close()
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: reading %q = %v", test.name, test.header, err)
+			server.Close()
+			client.Close()
+			continue
+		}
+		want := test.addr
+		if want == "" {
+			want = server.RemoteAddr().String()
+		}
+		if proxied.RemoteAddr().String() != want {
+			t.Errorf("%v: header %q read from %v, want %v", test.name, test.header, proxied.RemoteAddr(), want)
+		}
+		// What follows the header is read as usual.
+		rest := make([]byte, len(test.rest))
+		if _, err := io.ReadFull(proxied, rest); err != nil || string(rest) != test.rest {
+			t.Errorf("%v: read %q, %v after the header, want %q", test.name, rest, err, test.rest)
+		}
+		server.Close()
+		client.Close()
+	}
+	if len(longest) != maxProxyV1Header {
+		t.Errorf("the longest header has %v bytes, want %v", len(longest), maxProxyV1Header)
+	}
+}
+
+func TestProxyListener(t *testing.T) {
+	captureLog(t)
+	for _, test := range []struct {
+		proxies string
+		header  string
+		// proxied is set if the address of the header is kept.
+		proxied bool
+	}{
+		{"127.0.0.0/8", "PROXY TCP4 192.0.2.1 198.51.100.1 56324 22\r\n", true},
+		{"127.0.0.1,::1", proxyV2Header(0x21, 0x11, proxyV2Addresses("192.0.2.1", "198.51.100.1", 56324, 22)), true},
+		// Headers from other hosts are taken for what the client sent.
+		{"192.0.2.0/24", "PROXY TCP4 192.0.2.1 198.51.100.1 56324 22\r\n", false},
+		{"", "PROXY TCP4 192.0.2.1 198.51.100.1 56324 22\r\n", false},
+	} {
+		var proxies proxyNetworks
+		if err := proxies.Set(test.proxies); err != nil {
+			t.Fatal(err)
+		}
+		inner, err := net.Listen("tcp4", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listener := newProxyListener(inner, proxies, time.Second)
+		client, err := net.Dial("tcp4", inner.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(client, test.header+"SSH-2.0-OpenSSH_9.6\r\n")
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if test.proxied {
+			rest := make([]byte, len("SSH-2.0-OpenSSH_9.6\r\n"))
+			io.ReadFull(conn, rest)
+			if conn.RemoteAddr().String() != "192.0.2.1:56324" || string(rest) != "SSH-2.0-OpenSSH_9.6\r\n" {
+				t.Errorf("proxies %v: connection from %v sent %q", test.proxies, conn.RemoteAddr(), rest)
+			}
+		} else {
+			data := make([]byte, len(test.header))
+			io.ReadFull(conn, data)
+			if conn.RemoteAddr().String() != client.LocalAddr().String() || string(data) != test.header {
+				t.Errorf("proxies %v: connection from %v sent %q, want the header as it is", test.proxies, conn.RemoteAddr(), data)
+			}
+		}
+		conn.Close()
+		client.Close()
+		listener.Close()
+	}
+}
+
+func TestProxyListenerHeadersConcurrent(t *testing.T) {
+	hook := captureLog(t)
+	var proxies proxyNetworks
+	proxies.Set("127.0.0.0/8")
+	inner, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := newProxyListener(inner, proxies, 200*time.Millisecond)
+	defer listener.Close()
+	// A proxy sending half a header, then one sending an invalid one, don't
+	// hold up the next connection.
+	for _, data := range []string{"PROXY TCP4 192.0.2.1", "GET / HTTP/1.1\r\n"} {
+		conn, err := net.Dial("tcp4", inner.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		io.WriteString(conn, data)
+	}
+	client, err := net.Dial("tcp4", inner.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	io.WriteString(client, "PROXY TCP6 2001:db8::1 2001:db8::2 56324 22\r\n")
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.RemoteAddr().String() != "[2001:db8::1]:56324" {
+		t.Errorf("accepted a connection from %v first", conn.RemoteAddr())
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for failures := 0; failures < 2; {
+		failures = 0
+		for _, entry := range hook.AllEntries() {
+			if strings.HasPrefix(entry.Message, "Failed to read PROXY protocol header:") {
+				failures++
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("logged %v failures, want 2", failures)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
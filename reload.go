@@ -0,0 +1,192 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/longkeyy/sshesame/config"
+	"github.com/longkeyy/sshesame/honeypot"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// restartSettings are the settings of the configuration of the servers that
+// are only used when they start, so reloading doesn't change them.
+var restartSettings = map[string]bool{
+	"block_failures":         true,
+	"block_window":           true,
+	"block_cooldown":         true,
+	"auth_history_window":    true,
+	"max_connections_per_ip": true,
+	"max_goroutines_per_ip":  true,
+	"max_connections":        true,
+	"max_connection_rate":    true,
+	"connection_rate_window": true,
+	"connection_rate_ban":    true,
+	"sticky_hosts":           true,
+	"sticky_salt":            true,
+	"sticky_window":          true,
+	"emulate_jump_host":      true,
+}
+
+// configReloader reloads the config file, rebuilding the configuration of
+// servers from it and the command line.
+type configReloader struct {
+	path string
+	// settings are the settings of the file last loaded.
+	settings config.Settings
+	// commandLine are the flags set on the command line, which override the
+	// file.
+	commandLine map[string]bool
+	servers     []*honeypot.Server
+}
+
+// loadConfigFile applies the settings of the config file at path to the
+// flags not set on the command line, returning a reloader of it, if path is
+// set.
+func loadConfigFile(path string) *configReloader {
+	if path == "" {
+		return nil
+	}
+	reloader := &configReloader{path: path, commandLine: map[string]bool{}}
+	flag.Visit(func(f *flag.Flag) {
+		reloader.commandLine[f.Name] = true
+	})
+	settings, err := config.LoadSettings(path)
+	if err != nil {
+		log.Fatal("Failed to load config file:", err.Error())
+	}
+	if setting, ok := settings.Lookup("config"); ok {
+		log.Fatal("Invalid config file:", setting.Source+": config can't be set in the config file")
+	}
+	if err := settings.Apply(flag.CommandLine, reloader.skip); err != nil {
+		log.Fatal("Invalid config file:", err.Error())
+	}
+	reloader.settings = settings
+	return reloader
+}
+
+func (reloader *configReloader) skip(name string) bool {
+	return reloader.commandLine[name]
+}
+
+// reloadOnSIGHUP reloads the config file every time the process receives
+// SIGHUP, keeping the configuration loaded before if it fails.
+func (reloader *configReloader) reloadOnSIGHUP() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		if err := reloader.reload(); err != nil {
+			log.Warning("Failed to reload config file:", err.Error())
+		}
+	}
+}
+
+// ignoredFlag is a flag parsed again when reloading but ignored, as it isn't
+// part of the configuration of the servers.
+type ignoredFlag struct {
+	flag.Value
+}
+
+func (ignoredFlag) Set(text string) error {
+	return nil
+}
+
+func (value ignoredFlag) IsBoolFlag() bool {
+	boolFlag, ok := value.Value.(interface{ IsBoolFlag() bool })
+	return ok && boolFlag.IsBoolFlag()
+}
+
+// reload builds the configuration of the servers again, from the defaults,
+// the config file and the command line, and serves new connections with it.
+func (reloader *configReloader) reload() error {
+	settings, err := config.LoadSettings(reloader.path)
+	if err != nil {
+		return err
+	}
+	flags := flag.NewFlagSet("config", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	configuration := defineConfigFlags(flags)
+	reloadable := map[string]bool{}
+	flags.VisitAll(func(f *flag.Flag) {
+		reloadable[f.Name] = !restartSettings[f.Name]
+	})
+	flag.VisitAll(func(f *flag.Flag) {
+		if flags.Lookup(f.Name) == nil {
+			flags.Var(ignoredFlag{f.Value}, f.Name, f.Usage)
+		}
+	})
+	if setting, ok := settings.Lookup("config"); ok {
+		return fmt.Errorf("%v: config can't be set in the config file", setting.Source)
+	}
+	if err := settings.Apply(flags, reloader.skip); err != nil {
+		return err
+	}
+	if err := flags.Parse(os.Args[1:]); err != nil {
+		return err
+	}
+	cfg, err := configuration.load()
+	if err != nil {
+		return err
+	}
+	// Every server's configuration is checked before any is applied, so
+	// that they all keep theirs if one fails.
+	configs := make([]*config.Config, len(reloader.servers))
+	for i, server := range reloader.servers {
+		if configs[i], err = server.Configuration(cfg); err != nil {
+			return err
+		}
+	}
+	for i, server := range reloader.servers {
+		server.Reconfigure(configs[i])
+	}
+	restart := []string{}
+	for _, name := range reloader.changed(settings) {
+		if !reloadable[name] {
+			restart = append(restart, name)
+		}
+	}
+	reloader.settings = settings
+	log.WithFields(log.Fields{
+		"config_file": reloader.path,
+		"settings":    len(settings),
+	}).Info("Config file reloaded")
+	if len(restart) != 0 {
+		log.WithFields(log.Fields{
+			"config_file": reloader.path,
+			"settings":    strings.Join(restart, ","),
+		}).Warning("Config file changes only apply after a restart")
+	}
+	return nil
+}
+
+// changed returns the names of the settings settings changes from the ones
+// last loaded, sorted, but for those overridden on the command line.
+func (reloader *configReloader) changed(settings config.Settings) []string {
+	values := map[string]string{}
+	for _, setting := range reloader.settings {
+		values[setting.Name] = fmt.Sprintf("%q", setting.Values)
+	}
+	changed := map[string]bool{}
+	for _, setting := range settings {
+		if values[setting.Name] != fmt.Sprintf("%q", setting.Values) {
+			changed[setting.Name] = true
+		}
+		delete(values, setting.Name)
+	}
+	for name := range values {
+		changed[name] = true
+	}
+	names := []string{}
+	for name := range changed {
+		if !reloader.skip(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
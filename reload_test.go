@@ -0,0 +1,71 @@
+package main
+
+import (
+	"github.com/longkeyy/sshesame/config"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestConfigReloaderChanged(t *testing.T) {
+	reloader := &configReloader{
+		settings: config.Settings{
+			{Name: "server_version", Values: []string{"SSH-2.0-OpenSSH_8.2p1"}},
+			{Name: "credentials_file", Values: []string{"/etc/a", "/etc/b"}},
+			{Name: "deny_pty", Values: []string{"true"}},
+			{Name: "block_failures", Values: []string{"3"}},
+		},
+		commandLine: map[string]bool{"block_failures": true},
+	}
+	// Removed, added and changed settings, but for those overridden on the
+	// command line.
+	changed := reloader.changed(config.Settings{
+		{Name: "server_version", Values: []string{"SSH-2.0-OpenSSH_8.2p1"}},
+		{Name: "credentials_file", Values: []string{"/etc/a\x00/etc/b"}},
+		{Name: "block_failures", Values: []string{"5"}},
+		{Name: "max_connections", Values: []string{"100"}},
+	})
+	if want := []string{"credentials_file", "deny_pty", "max_connections"}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("changed = %q, want %q", changed, want)
+	}
+}
+
+func TestConfigReloaderReload(t *testing.T) {
+	hook := captureLog(t)
+	filePath := filepath.Join(t.TempDir(), "sshesame.toml")
+	write := func(text string) {
+		if err := ioutil.WriteFile(filePath, []byte(text), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("deny_pty = true\nmax_connections = 10\n")
+	settings, err := config.LoadSettings(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloader := &configReloader{path: filePath, settings: settings, commandLine: map[string]bool{}}
+	write("deny_pty = false\nmax_connections = 20\n")
+	if err := reloader.reload(); err != nil {
+		t.Fatal(err)
+	}
+	entries := hook.AllEntries()
+	if len(entries) != 2 || entries[0].Message != "Config file reloaded" || entries[0].Data["settings"] != 2 || entries[0].Data["config_file"] != filePath {
+		t.Fatalf("logged %v", entries)
+	}
+	// Only settings read at startup need a restart.
+	if entries[1].Message != "Config file changes only apply after a restart" || entries[1].Data["settings"] != "max_connections" {
+		t.Errorf("logged %v, want max_connections to need a restart", entries[1])
+	}
+
+	// Files that fail to load keep the settings loaded before.
+	for _, text := range []string{"deny_pty = maybe\n", "config = \"other.toml\"\n", "unknown = 1\n"} {
+		write(text)
+		if err := reloader.reload(); err == nil {
+			t.Errorf("reloaded %q", text)
+		}
+	}
+	if setting, ok := reloader.settings.Lookup("max_connections"); !ok || setting.Values[0] != "20" {
+		t.Errorf("settings %v after failed reloads", reloader.settings)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"strings"
+	"time"
+)
+
+// replayer re-emits captured events through the sinks, spacing them like
+// they originally were divided by speed, or as fast as possible if speed is
+// 0.
+type replayer struct {
+	speed  float64
+	layout string
+	// last is the original time of the event replayed last, and lastReplayed
+	// when it was.
+	last, lastReplayed time.Time
+	events             int
+}
+
+// replayFile replays the JSON events of a log_file or, for a file starting
+// with an asciicast v2 header, the command lines typed in a recording.
+func (replayer *replayer) replayFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	if !scanner.Scan() {
+		return scanner.Err()
+	}
+	first := scanner.Bytes()
+	var header struct {
+		Version   int    `json:"version"`
+		Timestamp int64  `json:"timestamp"`
+		Title     string `json:"title"`
+	}
+	if json.Unmarshal(first, &header) == nil && header.Version == 2 && !bytes.Contains(first, []byte(`"msg"`)) {
+		return replayer.replayRecording(path, scanner, time.Unix(header.Timestamp, 0), header.Title)
+	}
+	line := 1
+	for {
+		if err := replayer.replayEvent(scanner.Bytes()); err != nil {
+			return fmt.Errorf("%v:%v: %v", path, line, err)
+		}
+		if !scanner.Scan() {
+			return scanner.Err()
+		}
+		line++
+	}
+}
+
+// replayEvent replays a JSON event as formatted by the json log format.
+func (replayer *replayer) replayEvent(line []byte) error {
+	if len(bytes.TrimSpace(line)) == 0 {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+	fields := log.Fields{}
+	if err := decoder.Decode(&fields); err != nil {
+		return err
+	}
+	message, _ := fields[log.FieldKeyMsg].(string)
+	if message == "" {
+		return fmt.Errorf("event without %q", log.FieldKeyMsg)
+	}
+	level, err := log.ParseLevel(fmt.Sprint(fields[log.FieldKeyLevel]))
+	if err != nil {
+		level = log.InfoLevel
+	}
+	// Replaying a panic or fatal event mustn't end the process.
+	if level < log.ErrorLevel {
+		level = log.ErrorLevel
+	}
+	originalTime, err := replayer.parseTime(fields[log.FieldKeyTime])
+	if err != nil {
+		return err
+	}
+	for _, key := range []string{log.FieldKeyMsg, log.FieldKeyLevel, log.FieldKeyTime, "severity"} {
+		delete(fields, key)
+	}
+	replayer.emit(originalTime, level, message, fields)
+	return nil
+}
+
+// parseTime parses the time of an event, in milliseconds since the epoch,
+// the layout of timestamp_format or RFC 3339.
+func (replayer *replayer) parseTime(value interface{}) (time.Time, error) {
+	switch value := value.(type) {
+	case json.Number:
+		millis, err := value.Int64()
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid time %v", value)
+		}
+		return time.Unix(0, millis*int64(time.Millisecond)), nil
+	case string:
+		for _, layout := range []string{replayer.layout, time.RFC3339Nano} {
+			if layout == "" {
+				continue
+			}
+			if parsed, err := time.Parse(layout, value); err == nil {
+				return parsed, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("invalid time %q", value)
+	}
+	return time.Time{}, fmt.Errorf("event without %q", log.FieldKeyTime)
+}
+
+// replayRecording replays every command line typed in an asciicast recording
+// as a Command executed event of the client its title names.
+func (replayer *replayer) replayRecording(path string, scanner *bufio.Scanner, start time.Time, title string) error {
+	fields := log.Fields{"recording": path}
+	// Titles are <user>@<client> session <session ID>.
+	if session := strings.LastIndex(title, " session "); session != -1 {
+		fields["session_id"] = title[session+len(" session "):]
+		title = title[:session]
+	}
+	if at := strings.LastIndex(title, "@"); at != -1 {
+		fields["user"], fields["client"] = title[:at], title[at+1:]
+	}
+	line := []rune{}
+	for number := 2; scanner.Scan(); number++ {
+		var event []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || len(event) != 3 {
+			return fmt.Errorf("%v:%v: invalid asciicast event", path, number)
+		}
+		offset, _ := event[0].(float64)
+		code, _ := event[1].(string)
+		data, _ := event[2].(string)
+		if code != "i" {
+			continue
+		}
+		for _, char := range stripEscapes(data) {
+			switch char {
+			case '\r', '\n':
+				if strings.TrimSpace(string(line)) != "" {
+					commandFields := log.Fields{"command_line": string(line)}
+					for key, value := range fields {
+						commandFields[key] = value
+					}
+					replayer.emit(start.Add(time.Duration(offset*float64(time.Second))), log.InfoLevel, "Command executed", commandFields)
+				}
+				line = line[:0]
+			case '\x7f', '\b':
+				if len(line) != 0 {
+					line = line[:len(line)-1]
+				}
+			case '\x03', '\x15':
+				line = line[:0]
+			default:
+				if char >= ' ' {
+					line = append(line, char)
+				}
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// stripEscapes removes the escape sequences of cursor keys and the like from
+// typed input.
+func stripEscapes(input string) string {
+	var stripped strings.Builder
+	for i := 0; i < len(input); i++ {
+		if input[i] != '\x1b' {
+			stripped.WriteByte(input[i])
+			continue
+		}
+		// CSI and SS3 sequences end with a letter or ~.
+		if i+1 < len(input) && (input[i+1] == '[' || input[i+1] == 'O') {
+			i += 2
+			for i < len(input) && !(input[i] >= '@' && input[i] <= '~') {
+				i++
+			}
+		}
+	}
+	return stripped.String()
+}
+
+// emit logs an event originally logged at originalTime, once as long after
+// the previous one as it originally was, divided by the speed.
+func (replayer *replayer) emit(originalTime time.Time, level log.Level, message string, fields log.Fields) {
+	if replayer.speed > 0 && !replayer.last.IsZero() {
+		if delay := time.Duration(float64(originalTime.Sub(replayer.last)) / replayer.speed); delay > 0 {
+			time.Sleep(time.Until(replayer.lastReplayed.Add(delay)))
+		}
+	}
+	replayer.last, replayer.lastReplayed = originalTime, time.Now()
+	fields["original_time"] = originalTime.Format(time.RFC3339Nano)
+	log.WithFields(fields).Log(level, message)
+	replayer.events++
+}
+
+// replay replays every file in paths in turn, returning the number of events
+// replayed.
+func replay(paths []string, speed float64, layout string) (int, error) {
+	replayer := &replayer{speed: speed, layout: layout}
+	for _, path := range paths {
+		// Files are replayed back to back, however far apart they were
+		// captured.
+		replayer.last = time.Time{}
+		if err := replayer.replayFile(path); err != nil {
+			return replayer.events, err
+		}
+	}
+	return replayer.events, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeReplayFile writes content to a new file named name and returns its
+// path.
+func writeReplayFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReplayEvents(t *testing.T) {
+	hook := captureLog(t)
+	path := writeReplayFile(t, "sshesame.log", `{"client":"192.0.2.1:51000","level":"info","msg":"Client connected","time":"2026-10-14T12:00:00.5Z"}
+
+{"level":"warning","msg":"Command executed","port":22,"severity":"critical","time":1791979201000}
+{"level":"fatal","msg":"Failed to accept connection","time":"14/10/2026 12:00:02"}
+`)
+	events, err := replay([]string{path}, 0, "02/01/2006 15:04:05")
+	if err != nil || events != 3 {
+		t.Fatalf("replayed %v events, %v", events, err)
+	}
+	for i, test := range []struct {
+		level        log.Level
+		message      string
+		originalTime string
+		fields       int
+	}{
+		{log.InfoLevel, "Client connected", "2026-10-14T12:00:00.5Z", 2},
+		// The severity is the output's to set again.
+		{log.WarnLevel, "Command executed", time.Unix(1791979201, 0).Format(time.RFC3339Nano), 2},
+		// Fatal events are replayed as errors.
+		{log.ErrorLevel, "Failed to accept connection", "2026-10-14T12:00:02Z", 1},
+	} {
+		entry := hook.AllEntries()[i]
+		if entry.Level != test.level || entry.Message != test.message || entry.Data["original_time"] != test.originalTime || len(entry.Data) != test.fields {
+			t.Errorf("replayed %v %q with %v, want %v %q at %v", entry.Level, entry.Message, entry.Data, test.level, test.message, test.originalTime)
+		}
+	}
+	if port := hook.AllEntries()[1].Data["port"]; port != json.Number("22") {
+		t.Errorf("port replayed as %#v", port)
+	}
+}
+
+func TestReplayRecording(t *testing.T) {
+	hook := captureLog(t)
+	// Backspaces, ^U and cursor keys edit the line as the shell would.
+	path := writeReplayFile(t, "recording.cast", `{"version": 2, "width": 80, "height": 24, "timestamp": 1791979200, "title": "root@192.0.2.1:51000 session 1234"}
+[0.25, "o", "$ "]
+[1.5, "i", "unamx\u007fe -a\r"]
+[2.0, "i", "wrong\u0015id\u001b[A\u001bOB\r"]
+[2.5, "i", "\r"]
+`)
+	events, err := replay([]string{path}, 0, "")
+	if err != nil || events != 2 {
+		t.Fatalf("replayed %v events, %v", events, err)
+	}
+	for i, test := range []struct {
+		commandLine  string
+		originalTime string
+	}{
+		{"uname -a", "2026-10-14T12:00:01.5Z"},
+		{"id", "2026-10-14T12:00:02Z"},
+	} {
+		entry := hook.AllEntries()[i]
+		if originalTime, _ := time.Parse(time.RFC3339Nano, entry.Data["original_time"].(string)); entry.Message != "Command executed" || entry.Data["command_line"] != test.commandLine || !originalTime.Equal(mustParseTime(t, test.originalTime)) {
+			t.Errorf("replayed %q with %v, want %q at %v", entry.Message, entry.Data, test.commandLine, test.originalTime)
+		}
+		if entry.Data["user"] != "root" || entry.Data["client"] != "192.0.2.1:51000" || entry.Data["session_id"] != "1234" || entry.Data["recording"] != path {
+			t.Errorf("replayed with %v", entry.Data)
+		}
+	}
+}
+
+func TestReplaySpeed(t *testing.T) {
+	captureLog(t)
+	path := writeReplayFile(t, "sshesame.log", `{"level":"info","msg":"Client connected","time":"2026-10-14T12:00:00Z"}
+{"level":"info","msg":"Client disconnected","time":"2026-10-14T12:00:01Z"}
+`)
+	// A second apart, replayed 10 times faster.
+	start := time.Now()
+	if _, err := replay([]string{path, path}, 10, ""); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("replayed in %v, want 200ms", elapsed)
+	}
+}
+
+func TestReplayInvalid(t *testing.T) {
+	captureLog(t)
+	for _, content := range []string{
+		`{"level":"info","time":"2026-10-14T12:00:00Z"}`,
+		`{"level":"info","msg":"Client connected"}`,
+		`{"level":"info","msg":"Client connected","time":"yesterday"}`,
+		`{"level":"info","msg":"Client connected","time":"2026-10-14T12:00:00Z"}` + "\nnot json",
+		`{"version": 2, "timestamp": 1791979200}` + "\n[1.5, \"i\"]",
+	} {
+		if _, err := replay([]string{writeReplayFile(t, "invalid.log", content)}, 0, ""); err == nil {
+			t.Errorf("replayed %q", content)
+		}
+	}
+}
+
+func mustParseTime(t *testing.T, text string) time.Time {
+	t.Helper()
+	parsed, err := time.Parse(time.RFC3339Nano, text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
@@ -0,0 +1,78 @@
+package request
+
+import (
+	"fmt"
+	"github.com/longkeyy/sshesame/session"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// socketNameCharacters are those of the random part of the directories of
+// agent sockets, like mkdtemp uses.
+const socketNameCharacters = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+// agentTimeout bounds how long the client's agent may take to list its keys.
+const agentTimeout = 10 * time.Second
+
+// forwardAgent logs an agent forwarding request and, if it's accepted, sets
+// SSH_AUTH_SOCK to the socket sshd would create for the forwarded agent.
+func forwardAgent(sess *session.Session, channel string, accepted bool) {
+	random := rand.New(rand.NewSource(sess.Seed))
+	var directory strings.Builder
+	for i := 0; i < 10; i++ {
+		directory.WriteByte(socketNameCharacters[random.Intn(len(socketNameCharacters))])
+	}
+	socket := fmt.Sprintf("/tmp/ssh-%v/agent.%v", directory.String(), 1000+random.Intn(60000))
+	fields := log.Fields{
+		"client":   sess.RemoteAddr,
+		"channel":  channel,
+		"accepted": accepted,
+	}
+	if accepted {
+		fields["socket"] = socket
+		sess.SetEnv("SSH_AUTH_SOCK", socket)
+	}
+	log.WithFields(fields).Info("Agent forwarding requested")
+}
+
+// probeAgent lists the keys of the agent the client forwarded, over an
+// auth-agent@openssh.com channel to it, and logs them. It only ever sends
+// SSH_AGENTC_REQUEST_IDENTITIES, so the agent is never asked to sign anything.
+func probeAgent(sess *session.Session) {
+	channel, requests, err := sess.Conn.OpenChannel("auth-agent@openssh.com", nil)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"client": sess.RemoteAddr,
+			"reason": err.Error(),
+		}).Info("Forwarded agent unavailable")
+		return
+	}
+	defer channel.Close()
+	go ssh.DiscardRequests(requests)
+	timer := time.AfterFunc(agentTimeout, func() { channel.Close() })
+	defer timer.Stop()
+	keys, err := agent.NewClient(channel).List()
+	if err != nil {
+		log.Warning("Failed to list forwarded agent keys:", err.Error())
+		return
+	}
+	for _, key := range keys {
+		log.WithFields(log.Fields{
+			"client":      sess.RemoteAddr,
+			"channel":     "auth-agent@openssh.com",
+			"key_type":    key.Type(),
+			"fingerprint": ssh.FingerprintSHA256(key),
+			"comment":     key.Comment,
+			"public_key":  strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+		}).Info("Forwarded agent key found")
+	}
+	log.WithFields(log.Fields{
+		"client":    sess.RemoteAddr,
+		"channel":   "auth-agent@openssh.com",
+		"key_count": len(keys),
+	}).Info("Forwarded agent probed")
+}
@@ -0,0 +1,122 @@
+package request_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"regexp"
+	"testing"
+)
+
+// signlessAgent fails the test if the honeypot ever asks it to sign.
+type signlessAgent struct {
+	agent.Agent
+	t *testing.T
+}
+
+func (a signlessAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	a.t.Error("forwarded agent asked to sign")
+	return a.Agent.Sign(key, data)
+}
+
+func TestForwardedAgentProbed(t *testing.T) {
+	hook := captureLog(t)
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: key, Comment: "alice@laptop"}); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromSigner(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := newConfig()
+	cfg.Requests.ProbeAgent = true
+	client := dial(t, cfg)
+	if err := agent.ForwardToAgent(client, signlessAgent{keyring, t}); err != nil {
+		t.Fatal(err)
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	if err := agent.RequestAgentForwarding(session); err != nil {
+		t.Fatal(err)
+	}
+
+	if entry := waitFor(t, hook, "Forwarded agent probed"); entry.Data["key_count"] != 1 {
+		t.Errorf("probe logged with %v, want 1 key", entry.Data)
+	}
+	entry := waitFor(t, hook, "Forwarded agent key found")
+	if entry.Data["fingerprint"] != ssh.FingerprintSHA256(signer.PublicKey()) || entry.Data["comment"] != "alice@laptop" || entry.Data["key_type"] != "ssh-ed25519" {
+		t.Errorf("key logged with %v, want the forwarded ed25519 key", entry.Data)
+	}
+}
+
+func TestForwardedAgentUnavailable(t *testing.T) {
+	hook := captureLog(t)
+	cfg := newConfig()
+	cfg.Requests.ProbeAgent = true
+	client := dial(t, cfg)
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	// The client asks for forwarding but refuses the agent channel.
+	if err := agent.RequestAgentForwarding(session); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, hook, "Forwarded agent unavailable")
+}
+
+func TestAgentChannelFromClientRejected(t *testing.T) {
+	hook := captureLog(t)
+	client := dial(t, newConfig())
+	_, _, err := client.OpenChannel("auth-agent@openssh.com", nil)
+	if openErr, ok := err.(*ssh.OpenChannelError); !ok || openErr.Reason != ssh.Prohibited {
+		t.Errorf("opening an agent channel = %v, want it prohibited", err)
+	}
+	waitFor(t, hook, "Agent channel from client rejected")
+}
+
+func TestAgentForwardingRequested(t *testing.T) {
+	hook := captureLog(t)
+	client := dial(t, newConfig())
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	ok, err := session.SendRequest("auth-agent-req@openssh.com", true, nil)
+	if err != nil || !ok {
+		t.Fatalf("auth-agent-req@openssh.com = %v, %v, want it accepted", ok, err)
+	}
+	entry := waitFor(t, hook, "Agent forwarding requested")
+	socket, _ := entry.Data["socket"].(string)
+	if entry.Data["accepted"] != true || !regexp.MustCompile(`^/tmp/ssh-[A-Za-z0-9]{10}/agent\.\d+$`).MatchString(socket) {
+		t.Errorf("request logged with %v, want it accepted with the socket sshd would create", entry.Data)
+	}
+	if output, err := session.Output("echo $SSH_AUTH_SOCK"); err != nil || string(output) != socket+"\n" {
+		t.Errorf("SSH_AUTH_SOCK = %q, %v, want %v", output, err, socket)
+	}
+
+	// Like sshd, forwarding is only set up once per connection.
+	hook.Reset()
+	other, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if ok, err := other.SendRequest("auth-agent-req@openssh.com", true, nil); err != nil || ok {
+		t.Errorf("second auth-agent-req@openssh.com = %v, %v, want it rejected", ok, err)
+	}
+	if entry := waitFor(t, hook, "Agent forwarding requested"); entry.Data["accepted"] != false || entry.Data["socket"] != nil {
+		t.Errorf("second request logged with %v, want it rejected", entry.Data)
+	}
+}
@@ -0,0 +1,35 @@
+package request_test
+
+import (
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"testing"
+)
+
+func TestBreakRequest(t *testing.T) {
+	hook := captureLog(t)
+	client := dial(t, newConfig())
+	channel, requests, err := client.OpenChannel("session", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer channel.Close()
+	go ssh.DiscardRequests(requests)
+	ok, err := channel.SendRequest("break", true, ssh.Marshal(struct{ Length uint32 }{500}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Error("break request rejected")
+	}
+	entry := waitFor(t, hook, "Request received")
+	if entry.Data["request"] != "break" || fmt.Sprint(entry.Data["payload"]) != "500 ms" {
+		t.Errorf("break request logged %v, want a 500 ms break", entry.Data)
+	}
+
+	hook.Reset()
+	if ok, err := channel.SendRequest("break", true, []byte{0, 0}); ok || err != nil {
+		t.Errorf("truncated break request = %v, %v, want it rejected", ok, err)
+	}
+	waitFor(t, hook, "Malformed request rejected")
+}
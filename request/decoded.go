@@ -0,0 +1,44 @@
+package request
+
+import (
+	"github.com/longkeyy/sshesame/session"
+	log "github.com/sirupsen/logrus"
+)
+
+// logDecoded logs X11 forwarding, environment, signal and window-change
+// requests with the fields of their payloads, which fingerprint clients, and
+// whether they were accepted. Agent forwarding requests are logged by
+// forwardAgent.
+func logDecoded(sess *session.Session, channel string, payload interface{}, wantReply, accepted bool) {
+	fields := log.Fields{
+		"client":     sess.RemoteAddr,
+		"channel":    channel,
+		"want_reply": wantReply,
+		"accepted":   accepted,
+	}
+	var message string
+	switch payload := payload.(type) {
+	case x11:
+		fields["single_connection"] = payload.SingleConnection
+		fields["protocol"] = payload.AuthenticationProtocol
+		fields["cookie"] = payload.AuthenticationCookie
+		fields["screen"] = payload.Screen
+		message = "X11 forwarding requested"
+	case env:
+		fields["name"] = payload.Name
+		fields["value"] = payload.Value
+		message = "Environment variable received"
+	case signal:
+		fields["signal"] = payload.Name
+		message = "Signal received"
+	case windowChange:
+		fields["width"] = payload.Width
+		fields["height"] = payload.Height
+		fields["pixel_width"] = payload.PixelWidth
+		fields["pixel_height"] = payload.PixelHeight
+		message = "Window size changed"
+	default:
+		return
+	}
+	log.WithFields(fields).Info(message)
+}
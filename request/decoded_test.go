@@ -0,0 +1,63 @@
+package request_test
+
+import (
+	"testing"
+)
+
+func TestDecodedRequestsLogged(t *testing.T) {
+	hook := captureLog(t)
+	session, err := dial(t, newConfig()).NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	for _, test := range []struct {
+		request   string
+		wantReply bool
+		payload   string
+		message   string
+		fields    map[string]interface{}
+	}{
+		{
+			"x11-req", true,
+			"\x01\x00\x00\x00\x12MIT-MAGIC-COOKIE-1\x00\x00\x00\x200123456789abcdef0123456789abcdef\x00\x00\x00\x00",
+			"X11 forwarding requested",
+			map[string]interface{}{"single_connection": true, "protocol": "MIT-MAGIC-COOKIE-1", "cookie": "0123456789abcdef0123456789abcdef", "screen": uint32(0)},
+		},
+		{
+			"env", false,
+			"\x00\x00\x00\x04LANG\x00\x00\x00\x07C.UTF-8",
+			"Environment variable received",
+			map[string]interface{}{"name": "LANG", "value": "C.UTF-8"},
+		},
+		{
+			"window-change", false,
+			"\x00\x00\x00\x84\x00\x00\x00\x2b\x00\x00\x04\x20\x00\x00\x03\x6c",
+			"Window size changed",
+			map[string]interface{}{"width": uint32(132), "height": uint32(43), "pixel_width": uint32(1056), "pixel_height": uint32(876)},
+		},
+		{
+			"signal", false,
+			"\x00\x00\x00\x03INT",
+			"Signal received",
+			map[string]interface{}{"signal": "INT"},
+		},
+	} {
+		accepted, err := session.SendRequest(test.request, test.wantReply, []byte(test.payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.wantReply && !accepted {
+			t.Errorf("%v rejected", test.request)
+		}
+		entry := waitFor(t, hook, test.message)
+		if entry.Data["channel"] != "session" || entry.Data["want_reply"] != test.wantReply || entry.Data["accepted"] != true {
+			t.Errorf("%v logged with %v", test.request, entry.Data)
+		}
+		for name, value := range test.fields {
+			if entry.Data[name] != value {
+				t.Errorf("%v logged with %v %v, want %v", test.request, name, entry.Data[name], value)
+			}
+		}
+	}
+}
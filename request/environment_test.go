@@ -0,0 +1,41 @@
+package request_test
+
+import (
+	"bytes"
+	"golang.org/x/crypto/ssh"
+	"strings"
+	"testing"
+)
+
+func TestClientEnvironmentLogged(t *testing.T) {
+	hook := captureLog(t)
+	session, err := dial(t, newConfig()).NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	for name, value := range map[string]string{"LANG": "de_DE.UTF-8", "LC_TIME": "en_GB.UTF-8"} {
+		if err := session.Setenv(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := session.RequestPty("xterm-256color", 24, 80, ssh.TerminalModes{}); err != nil {
+		t.Fatal(err)
+	}
+	var stdout bytes.Buffer
+	session.Stdout = &stdout
+	if err := session.Run("locale; printenv LANG TERM"); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"LANG=de_DE.UTF-8\n", "LC_TIME=en_GB.UTF-8\n", "LC_CTYPE=\"de_DE.UTF-8\"\n", "\nde_DE.UTF-8\nxterm-256color\n"} {
+		if !strings.Contains(stdout.String(), line) {
+			t.Errorf("locale and printenv wrote %q, want %q", stdout.String(), line)
+		}
+	}
+
+	entry := waitFor(t, hook, "Client environment received")
+	locale, _ := entry.Data["locale"].(map[string]string)
+	if entry.Data["term"] != "xterm-256color" || len(locale) != 2 || locale["LANG"] != "de_DE.UTF-8" || locale["LC_TIME"] != "en_GB.UTF-8" {
+		t.Errorf("client environment logged with %v", entry.Data)
+	}
+}
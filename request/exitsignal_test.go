@@ -0,0 +1,37 @@
+package request_test
+
+import (
+	"github.com/longkeyy/sshesame/config"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestExitSignalSent(t *testing.T) {
+	hook := captureLog(t)
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "miner"), []byte("---\nexit_signal: SIGSEGV\ncore_dumped: true\nerror_message: invalid memory reference\n---\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	responses, err := config.LoadResponses(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := newConfig()
+	cfg.Shell.Responses = responses
+	client := dial(t, cfg)
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	err = session.Run("miner --threads 4")
+	exit, ok := err.(*ssh.ExitError)
+	if !ok || exit.Signal() != "SEGV" || exit.Msg() != "invalid memory reference" {
+		t.Fatalf("miner = %v, want it killed by SEGV with its error message", err)
+	}
+	if entry := waitFor(t, hook, "Exit signal sent"); entry.Data["signal"] != "SEGV" || entry.Data["core_dumped"] != true || entry.Data["error_message"] != "invalid memory reference" {
+		t.Errorf("exit signal logged with %v", entry.Data)
+	}
+}
@@ -0,0 +1,238 @@
+package request
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/longkeyy/sshesame/config"
+	"github.com/longkeyy/sshesame/session"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"strings"
+	"sync"
+)
+
+// userAuthRequest is the data an agent signs for public key authentication,
+// see RFC 4252 section 7.
+type userAuthRequest struct {
+	SessionID []byte
+	Type      byte
+	User      string
+	Service   string
+	Method    string
+	HasSig    bool
+	Algorithm string
+	PublicKey []byte
+	Rest      []byte `ssh:"rest"`
+}
+
+// sshSignature is the data an agent signs for ssh-keygen -Y sign, see
+// PROTOCOL.sshsig, after its magic preamble.
+type sshSignature struct {
+	Namespace string
+	Reserved  string
+	Hash      string
+	Digest    []byte
+	Rest      []byte `ssh:"rest"`
+}
+
+// fakeAgent is an agent holding decoy keys, which logs everything clients ask
+// it. It signs with them, so that clients moving on to other hosts with it
+// show which, and pretends to add, remove and lock keys.
+type fakeAgent struct {
+	sess *session.Session
+
+	mu      sync.Mutex
+	keys    []config.AgentKey
+	locked  bool
+	lockKey []byte
+}
+
+func newFakeAgent(sess *session.Session, keys []config.AgentKey) *fakeAgent {
+	return &fakeAgent{sess: sess, keys: append([]config.AgentKey{}, keys...)}
+}
+
+func (fake *fakeAgent) log(fields log.Fields) *log.Entry {
+	fields["client"] = fake.sess.RemoteAddr
+	fields["channel"] = "auth-agent@openssh.com"
+	return log.WithFields(fields)
+}
+
+// List implements agent.Agent.
+func (fake *fakeAgent) List() ([]*agent.Key, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	keys := []*agent.Key{}
+	if !fake.locked {
+		for _, key := range fake.keys {
+			publicKey := key.Signer.PublicKey()
+			keys = append(keys, &agent.Key{Format: publicKey.Type(), Blob: publicKey.Marshal(), Comment: key.Comment})
+		}
+	}
+	fake.log(log.Fields{
+		"key_count": len(keys),
+		"locked":    fake.locked,
+	}).Info("Fake agent keys listed")
+	return keys, nil
+}
+
+// Sign implements agent.Agent.
+func (fake *fakeAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return fake.SignWithFlags(key, data, 0)
+}
+
+// SignWithFlags implements agent.ExtendedAgent, logging what the data to sign
+// is for: the user and service of public key authentication to another host,
+// or the namespace of ssh-keygen -Y sign.
+func (fake *fakeAgent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	digest := sha256.Sum256(data)
+	fields := log.Fields{
+		"fingerprint": ssh.FingerprintSHA256(key),
+		"key_type":    key.Type(),
+		"flags":       uint32(flags),
+		"data_size":   len(data),
+		"data_sha256": hex.EncodeToString(digest[:]),
+		"category":    "agent_sign_attempt",
+	}
+	request := userAuthRequest{}
+	signature := sshSignature{}
+	switch {
+	case ssh.Unmarshal(data, &request) == nil && request.Type == 50 && request.Method == "publickey":
+		fields["purpose"] = "authentication"
+		fields["target_user"] = request.User
+		fields["service"] = request.Service
+		fields["algorithm"] = request.Algorithm
+		fields["session_hash"] = hex.EncodeToString(request.SessionID)
+	case bytes.HasPrefix(data, []byte("SSHSIG")) && ssh.Unmarshal(data[6:], &signature) == nil:
+		fields["purpose"] = "sshsig"
+		fields["namespace"] = signature.Namespace
+		fields["hash"] = signature.Hash
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	var signer ssh.Signer
+	for _, candidate := range fake.keys {
+		if bytes.Equal(candidate.Signer.PublicKey().Marshal(), key.Marshal()) {
+			signer = candidate.Signer
+		}
+	}
+	fields["signed"] = signer != nil && !fake.locked
+	fake.log(fields).Warning("Fake agent sign request received")
+	if signer == nil || fake.locked {
+		return nil, errors.New("no such key")
+	}
+	algorithmSigner, ok := signer.(ssh.AlgorithmSigner)
+	switch {
+	case ok && flags&agent.SignatureFlagRsaSha512 != 0 && key.Type() == ssh.KeyAlgoRSA:
+		return algorithmSigner.SignWithAlgorithm(nil, data, ssh.KeyAlgoRSASHA512)
+	case ok && flags&agent.SignatureFlagRsaSha256 != 0 && key.Type() == ssh.KeyAlgoRSA:
+		return algorithmSigner.SignWithAlgorithm(nil, data, ssh.KeyAlgoRSASHA256)
+	}
+	return signer.Sign(nil, data)
+}
+
+// Add implements agent.Agent, really adding the key, whose public part is
+// logged.
+func (fake *fakeAgent) Add(key agent.AddedKey) error {
+	privateKey := key.PrivateKey
+	if pointer, ok := privateKey.(*ed25519.PrivateKey); ok {
+		privateKey = *pointer
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		fake.log(log.Fields{
+			"reason": err.Error(),
+		}).Info("Fake agent key addition failed")
+		return err
+	}
+	publicKey := signer.PublicKey()
+	fake.log(log.Fields{
+		"key_type":    publicKey.Type(),
+		"fingerprint": ssh.FingerprintSHA256(publicKey),
+		"comment":     key.Comment,
+		"public_key":  strings.TrimSpace(string(ssh.MarshalAuthorizedKey(publicKey))),
+		"category":    "agent_key_added",
+	}).Warning("Fake agent key added")
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.keys = append(fake.keys, config.AgentKey{Signer: signer, Comment: key.Comment})
+	return nil
+}
+
+// Remove implements agent.Agent.
+func (fake *fakeAgent) Remove(key ssh.PublicKey) error {
+	fake.log(log.Fields{
+		"fingerprint": ssh.FingerprintSHA256(key),
+	}).Info("Fake agent key removed")
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	for i, candidate := range fake.keys {
+		if bytes.Equal(candidate.Signer.PublicKey().Marshal(), key.Marshal()) {
+			fake.keys = append(fake.keys[:i], fake.keys[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("no such key")
+}
+
+// RemoveAll implements agent.Agent.
+func (fake *fakeAgent) RemoveAll() error {
+	fake.log(log.Fields{}).Info("Fake agent keys removed")
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.keys = nil
+	return nil
+}
+
+// Lock implements agent.Agent.
+func (fake *fakeAgent) Lock(passphrase []byte) error {
+	fake.log(log.Fields{
+		"passphrase": string(passphrase),
+	}).Info("Fake agent locked")
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if fake.locked {
+		return errors.New("agent already locked")
+	}
+	fake.locked, fake.lockKey = true, passphrase
+	return nil
+}
+
+// Unlock implements agent.Agent.
+func (fake *fakeAgent) Unlock(passphrase []byte) error {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	unlocked := fake.locked && bytes.Equal(passphrase, fake.lockKey)
+	fake.log(log.Fields{
+		"passphrase": string(passphrase),
+		"unlocked":   unlocked,
+	}).Info("Fake agent unlock attempted")
+	if !unlocked {
+		return errors.New("incorrect passphrase")
+	}
+	fake.locked, fake.lockKey = false, nil
+	return nil
+}
+
+// Signers implements agent.Agent.
+func (fake *fakeAgent) Signers() ([]ssh.Signer, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	signers := []ssh.Signer{}
+	for _, key := range fake.keys {
+		signers = append(signers, key.Signer)
+	}
+	return signers, nil
+}
+
+// Extension implements agent.ExtendedAgent, supporting none, like OpenSSH's
+// agent doesn't support most.
+func (fake *fakeAgent) Extension(extensionType string, contents []byte) ([]byte, error) {
+	fake.log(log.Fields{
+		"extension": extensionType,
+	}).Info("Fake agent extension requested")
+	return nil, agent.ErrExtensionUnsupported
+}
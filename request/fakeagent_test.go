@@ -0,0 +1,124 @@
+package request_test
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/longkeyy/sshesame/config"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"io"
+	"testing"
+)
+
+// agentMessage sends an agent protocol message of payload on channel and
+// returns the response.
+func agentMessage(t *testing.T, channel ssh.Channel, payload string) string {
+	t.Helper()
+	message := make([]byte, 4, 4+len(payload))
+	binary.BigEndian.PutUint32(message, uint32(len(payload)))
+	if _, err := channel.Write(append(message, payload...)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(channel, message); err != nil {
+		t.Fatal(err)
+	}
+	response := make([]byte, binary.BigEndian.Uint32(message))
+	if _, err := io.ReadFull(channel, response); err != nil {
+		t.Fatal(err)
+	}
+	return string(response)
+}
+
+func TestFakeAgent(t *testing.T) {
+	hook := captureLog(t)
+	// The key of RFC 8032's first test vector, whose signature of nothing is
+	// known, Ed25519 being deterministic.
+	seed, _ := hex.DecodeString("9d61b19deffd5a60ba844af492ec2cc44449c5697b326919703bac031cae7f60")
+	signer, err := ssh.NewSignerFromKey(ed25519.NewKeyFromSeed(seed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := newConfig()
+	cfg.Requests.FakeAgent = true
+	cfg.Requests.AgentKeys = []config.AgentKey{{Signer: signer, Comment: "alice@laptop"}}
+	client := dial(t, cfg)
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	if err := agent.RequestAgentForwarding(session); err != nil {
+		t.Fatal(err)
+	}
+	channel, requests, err := client.OpenChannel("auth-agent@openssh.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer channel.Close()
+	go ssh.DiscardRequests(requests)
+
+	publicKey, _ := hex.DecodeString("d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a")
+	blob := "\x00\x00\x00\x0bssh-ed25519\x00\x00\x00\x20" + string(publicKey)
+	// SSH_AGENTC_REQUEST_IDENTITIES and SSH_AGENT_IDENTITIES_ANSWER
+	if response, want := agentMessage(t, channel, "\x0b"), "\x0c\x00\x00\x00\x01\x00\x00\x00\x33"+blob+"\x00\x00\x00\x0calice@laptop"; response != want {
+		t.Errorf("identities answered with %x, want %x", response, want)
+	}
+	// SSH_AGENTC_SIGN_REQUEST of no data and SSH_AGENT_SIGN_RESPONSE
+	signature, _ := hex.DecodeString("e5564300c360ac729086e2cc806e828a84877f1eb8e5d974d873e065224901555fb8821590a33bacc61e39701cf9b46bd25bf5f0595bbe24655141438e7a100b")
+	if response, want := agentMessage(t, channel, "\x0d\x00\x00\x00\x33"+blob+"\x00\x00\x00\x00\x00\x00\x00\x00"), "\x0e\x00\x00\x00\x53\x00\x00\x00\x0bssh-ed25519\x00\x00\x00\x40"+string(signature); response != want {
+		t.Errorf("signed with %x, want %x", response, want)
+	}
+	entry := waitFor(t, hook, "Fake agent sign request received")
+	if entry.Data["fingerprint"] != ssh.FingerprintSHA256(signer.PublicKey()) || entry.Data["data_size"] != 0 || entry.Data["data_sha256"] != "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" || entry.Data["signed"] != true {
+		t.Errorf("sign request logged with %v", entry.Data)
+	}
+
+	// Signing for public key authentication to another host tells which.
+	hook.Reset()
+	keyring := agent.NewClient(channel)
+	data := ssh.Marshal(struct {
+		SessionID []byte
+		Type      byte
+		User      string
+		Service   string
+		Method    string
+		HasSig    bool
+		Algorithm string
+		PublicKey []byte
+	}{[]byte{1, 2, 3}, 50, "deploy", "ssh-connection", "publickey", true, "ssh-ed25519", signer.PublicKey().Marshal()})
+	sig, err := keyring.Sign(signer.PublicKey(), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := signer.PublicKey().Verify(data, sig); err != nil {
+		t.Error(err)
+	}
+	entry = waitFor(t, hook, "Fake agent sign request received")
+	if entry.Data["purpose"] != "authentication" || entry.Data["target_user"] != "deploy" || entry.Data["service"] != "ssh-connection" || entry.Data["session_hash"] != "010203" {
+		t.Errorf("authentication sign request logged with %v", entry.Data)
+	}
+
+	// Locked agents list and sign nothing until unlocked.
+	if err := keyring.Lock([]byte("hunter2")); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := keyring.List(); err != nil || len(keys) != 0 {
+		t.Errorf("locked agent listed %v, %v", keys, err)
+	}
+	if _, err := keyring.Sign(signer.PublicKey(), data); err == nil {
+		t.Error("locked agent signed")
+	}
+	if err := keyring.Unlock([]byte("letmein")); err == nil {
+		t.Error("unlocked with the wrong passphrase")
+	}
+	if err := keyring.Unlock([]byte("hunter2")); err != nil {
+		t.Error(err)
+	}
+	if keys, err := keyring.List(); err != nil || len(keys) != 1 || keys[0].Comment != "alice@laptop" {
+		t.Errorf("unlocked agent listed %v, %v", keys, err)
+	}
+	if entry := waitFor(t, hook, "Fake agent locked"); entry.Data["passphrase"] != "hunter2" {
+		t.Errorf("lock logged with %v", entry.Data)
+	}
+}
@@ -0,0 +1,53 @@
+package request_test
+
+import (
+	"golang.org/x/crypto/ssh"
+	"testing"
+)
+
+func TestXonXoffRequest(t *testing.T) {
+	captureLog(t)
+	for _, accept := range []bool{false, true} {
+		cfg := newConfig()
+		cfg.Requests.AcceptXonXoff = accept
+		channel, requests, err := dial(t, cfg).OpenChannel("session", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go ssh.DiscardRequests(requests)
+		ok, err := channel.SendRequest("xon-xoff", true, []byte{1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != accept {
+			t.Errorf("xon-xoff with AcceptXonXoff %v = %v, want %v", accept, ok, accept)
+		}
+		channel.Close()
+	}
+}
+
+func TestUnknownSessionRequestRejected(t *testing.T) {
+	hook := captureLog(t)
+	channel, requests, err := dial(t, newConfig()).OpenChannel("session", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer channel.Close()
+	go ssh.DiscardRequests(requests)
+	ok, err := channel.SendRequest("keepalive@example.com", true, []byte{0xde, 0xad})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("unknown request accepted, want it rejected")
+	}
+	entry := waitFor(t, hook, "Unknown request rejected")
+	if entry.Data["request"] != "keepalive@example.com" || entry.Data["payload_hex"] != "dead" {
+		t.Errorf("rejection logged with %v, want the type and payload", entry.Data)
+	}
+
+	// The channel still serves the requests it knows.
+	if ok, err := channel.SendRequest("env", true, ssh.Marshal(struct{ Name, Value string }{"LANG", "C"})); !ok || err != nil {
+		t.Errorf("env after an unknown request = %v, %v, want it accepted", ok, err)
+	}
+}
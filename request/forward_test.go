@@ -0,0 +1,36 @@
+package request_test
+
+import (
+	"encoding/binary"
+	"golang.org/x/crypto/ssh"
+	"testing"
+)
+
+func TestPretendedForwardPort(t *testing.T) {
+	hook := captureLog(t)
+	client := dial(t, newConfig())
+	// RFC 4254 section 7.1
+	ok, reply, err := client.SendRequest("tcpip-forward", true, ssh.Marshal(struct {
+		BindAddress string
+		BindPort    uint32
+	}{"0.0.0.0", 0}))
+	if err != nil || !ok || len(reply) != 4 {
+		t.Fatalf("tcpip-forward = %v, %x, %v, want a port", ok, reply, err)
+	}
+	port := binary.BigEndian.Uint32(reply)
+	if port < 32768 || port >= 61000 {
+		t.Errorf("allocated port %v, want an ephemeral one", port)
+	}
+	entry := waitFor(t, hook, "Remote forward port pretended")
+	if entry.Data["forward"] != "0.0.0.0:0" || entry.Data["port"] != port {
+		t.Errorf("logged %v", entry.Data)
+	}
+	// Ports asked for are accepted as is.
+	ok, reply, err = client.SendRequest("tcpip-forward", true, ssh.Marshal(struct {
+		BindAddress string
+		BindPort    uint32
+	}{"0.0.0.0", 8080}))
+	if err != nil || !ok || len(reply) != 0 {
+		t.Errorf("tcpip-forward of port 8080 = %v, %x, %v, want no port", ok, reply, err)
+	}
+}
@@ -0,0 +1,48 @@
+package request_test
+
+import (
+	"github.com/longkeyy/sshesame/session"
+	"golang.org/x/crypto/ssh"
+	"testing"
+)
+
+func TestInteraction(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		use         func(*ssh.Session) error
+		interaction string
+	}{
+		{"probe", nil, session.Probe},
+		{"exec", func(session *ssh.Session) error {
+			return session.Run("uname -a")
+		}, session.Automated},
+		{"shell without a pty", func(session *ssh.Session) error {
+			return session.Shell()
+		}, session.Automated},
+		{"pty and shell", func(session *ssh.Session) error {
+			if err := session.RequestPty("xterm", 24, 80, ssh.TerminalModes{}); err != nil {
+				return err
+			}
+			return session.Shell()
+		}, session.Interactive},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			hook := captureLog(t)
+			client := dial(t, newConfig())
+			if test.use != nil {
+				session, err := client.NewSession()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := test.use(session); err != nil {
+					t.Fatal(err)
+				}
+				session.Close()
+			}
+			client.Close()
+			if entry := waitFor(t, hook, "Client disconnected"); entry.Data["interaction"] != test.interaction {
+				t.Errorf("interaction = %v, want %v", entry.Data["interaction"], test.interaction)
+			}
+		})
+	}
+}
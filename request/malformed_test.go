@@ -0,0 +1,84 @@
+package request_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"golang.org/x/crypto/ssh"
+	"testing"
+)
+
+// lengthPrefixed returns a string of the wire format claiming length bytes,
+// followed by data.
+func lengthPrefixed(length uint32, data string) []byte {
+	payload := make([]byte, 4, 4+len(data))
+	binary.BigEndian.PutUint32(payload, length)
+	return append(payload, data...)
+}
+
+func TestMalformedRequestsRejected(t *testing.T) {
+	hook := captureLog(t)
+	cfg := newConfig()
+	cfg.Limits.MaxPayloadSize = 1024
+	client := dial(t, cfg)
+	channel, requests, err := client.OpenChannel("session", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer channel.Close()
+	go ssh.DiscardRequests(requests)
+	for _, test := range []struct {
+		name, request string
+		payload       []byte
+	}{
+		{"oversized", "exec", ssh.Marshal(struct{ Command string }{string(bytes.Repeat([]byte("A"), 2048))})},
+		{"huge length prefix", "exec", lengthPrefixed(0xffffffff, "uname")},
+		{"truncated string", "exec", lengthPrefixed(10, "uname")},
+		{"empty", "env", nil},
+		{"truncated", "pty-req", ssh.Marshal(struct{ Term string }{"xterm"})},
+		{"truncated", "window-change", []byte{0, 0, 0, 80}},
+		{"trailing data", "subsystem", append(ssh.Marshal(struct{ Name string }{"sftp"}), 1, 2, 3)},
+	} {
+		hook.Reset()
+		ok, err := channel.SendRequest(test.request, true, test.payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			t.Errorf("%v %v request accepted", test.name, test.request)
+		}
+		entry := waitFor(t, hook, "Malformed request rejected")
+		if entry.Data["request"] != test.request || entry.Data["payload_size"] != len(test.payload) || entry.Data["error"] == "" {
+			t.Errorf("%v %v request logged %v", test.name, test.request, entry.Data)
+		}
+	}
+	// The channel still works after malformed requests.
+	if ok, err := channel.SendRequest("exec", true, ssh.Marshal(struct{ Command string }{"true"})); !ok || err != nil {
+		t.Errorf("valid exec request after malformed ones = %v, %v", ok, err)
+	}
+}
+
+func TestMalformedChannelsRejected(t *testing.T) {
+	hook := captureLog(t)
+	cfg := newConfig()
+	cfg.Limits.MaxPayloadSize = 1024
+	client := dial(t, cfg)
+	for _, test := range []struct {
+		name, channel string
+		extraData     []byte
+	}{
+		{"oversized", "session", bytes.Repeat([]byte("A"), 2048)},
+		{"huge length prefix", "direct-tcpip", lengthPrefixed(0xffffffff, "localhost")},
+		{"truncated", "direct-tcpip", ssh.Marshal(struct{ Host string }{"localhost"})},
+		{"truncated", "x11", []byte{0, 0}},
+	} {
+		hook.Reset()
+		_, _, err := client.OpenChannel(test.channel, test.extraData)
+		if openErr, ok := err.(*ssh.OpenChannelError); !ok || openErr.Reason != ssh.ConnectionFailed || openErr.Message != "malformed request" {
+			t.Errorf("%v %v channel opened with %v, want it rejected as malformed", test.name, test.channel, err)
+			continue
+		}
+		if entry := waitFor(t, hook, "Malformed channel request rejected"); entry.Data["channel"] != test.channel || entry.Data["payload_size"] != len(test.extraData) {
+			t.Errorf("%v %v channel logged %v", test.name, test.channel, entry.Data)
+		}
+	}
+}
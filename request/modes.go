@@ -0,0 +1,112 @@
+package request
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ttyOpEnd ends an encoded terminal modes blob.
+const ttyOpEnd = 0
+
+// terminalModes names the opcodes of RFC 4254 section 8.
+var terminalModes = map[byte]string{
+	1:   "VINTR",
+	2:   "VQUIT",
+	3:   "VERASE",
+	4:   "VKILL",
+	5:   "VEOF",
+	6:   "VEOL",
+	7:   "VEOL2",
+	8:   "VSTART",
+	9:   "VSTOP",
+	10:  "VSUSP",
+	11:  "VDSUSP",
+	12:  "VREPRINT",
+	13:  "VWERASE",
+	14:  "VLNEXT",
+	15:  "VFLUSH",
+	16:  "VSWTCH",
+	17:  "VSTATUS",
+	18:  "VDISCARD",
+	30:  "IGNPAR",
+	31:  "PARMRK",
+	32:  "INPCK",
+	33:  "ISTRIP",
+	34:  "INLCR",
+	35:  "IGNCR",
+	36:  "ICRNL",
+	37:  "IUCLC",
+	38:  "IXON",
+	39:  "IXANY",
+	40:  "IXOFF",
+	41:  "IMAXBEL",
+	42:  "IUTF8",
+	50:  "ISIG",
+	51:  "ICANON",
+	52:  "XCASE",
+	53:  "ECHO",
+	54:  "ECHOE",
+	55:  "ECHOK",
+	56:  "ECHONL",
+	57:  "NOFLSH",
+	58:  "TOSTOP",
+	59:  "IEXTEN",
+	60:  "ECHOCTL",
+	61:  "ECHOKE",
+	62:  "PENDIN",
+	70:  "OPOST",
+	71:  "OLCUC",
+	72:  "ONLCR",
+	73:  "OCRNL",
+	74:  "ONOCR",
+	75:  "ONLRET",
+	90:  "CS7",
+	91:  "CS8",
+	92:  "PARENB",
+	93:  "PARODD",
+	128: "TTY_OP_ISPEED",
+	129: "TTY_OP_OSPEED",
+}
+
+// notableModes are logged, as clients that don't send realistic values for
+// them are likely automated.
+var notableModes = []string{"ECHO", "ICANON", "ISIG", "IUTF8", "TTY_OP_ISPEED", "TTY_OP_OSPEED"}
+
+var errTruncatedModes = errors.New("truncated terminal modes")
+
+// decodeModes decodes an encoded terminal modes blob into the values of the
+// modes by name. Opcodes without a name are named after their number.
+func decodeModes(blob []byte) (map[string]uint32, error) {
+	modes := map[string]uint32{}
+	for len(blob) > 0 {
+		opcode := blob[0]
+		// Opcodes 160 to 255 aren't defined yet and can't be skipped, as
+		// their arguments are unknown.
+		if opcode == ttyOpEnd || opcode >= 160 {
+			return modes, nil
+		}
+		if len(blob) < 5 {
+			return nil, errTruncatedModes
+		}
+		name, ok := terminalModes[opcode]
+		if !ok {
+			name = fmt.Sprintf("opcode_%v", opcode)
+		}
+		modes[name] = binary.BigEndian.Uint32(blob[1:5])
+		blob = blob[5:]
+	}
+	return modes, nil
+}
+
+// formatModes renders the notable modes among modes.
+func formatModes(modes map[string]uint32) string {
+	pairs := []string{}
+	for _, name := range notableModes {
+		if value, ok := modes[name]; ok {
+			pairs = append(pairs, fmt.Sprintf("%v=%v", name, value))
+		}
+	}
+	return strings.Join(pairs, " ")
+}
@@ -0,0 +1,111 @@
+package request_test
+
+import (
+	"bytes"
+	"golang.org/x/crypto/ssh"
+	"strings"
+	"testing"
+)
+
+// ptyRequest returns the payload of a pty-req of an xterm with modes.
+func ptyRequest(modes []byte) []byte {
+	return ssh.Marshal(struct {
+		Term                         string
+		Columns, Rows, Width, Height uint32
+		Modes                        string
+	}{"xterm-256color", 80, 24, 640, 480, string(modes)})
+}
+
+// openSSHModes are the terminal modes of OpenSSH on a typical Linux
+// terminal, with fewer control characters.
+var openSSHModes = []byte{
+	1, 0, 0, 0, 3, // VINTR ^C
+	3, 0, 0, 0, 127, // VERASE ^?
+	36, 0, 0, 0, 1, // ICRNL
+	42, 0, 0, 0, 1, // IUTF8
+	50, 0, 0, 0, 1, // ISIG
+	51, 0, 0, 0, 1, // ICANON
+	53, 0, 0, 0, 1, // ECHO
+	128, 0, 0, 0x96, 0, // TTY_OP_ISPEED 38400
+	129, 0, 0, 0x96, 0, // TTY_OP_OSPEED 38400
+	0, // TTY_OP_END
+}
+
+func TestTerminalModesDecoded(t *testing.T) {
+	hook := captureLog(t)
+	client := dial(t, newConfig())
+	for _, test := range []struct {
+		name  string
+		modes []byte
+		want  string
+		count int
+	}{
+		{"OpenSSH", openSSHModes, "ECHO=1 ICANON=1 ISIG=1 IUTF8=1 TTY_OP_ISPEED=38400 TTY_OP_OSPEED=38400", 9},
+		{"empty", nil, "", 0},
+		{"echo off", []byte{53, 0, 0, 0, 0, 0}, "ECHO=0", 1},
+		// Undefined opcodes end the modes, as their arguments are unknown.
+		{"undefined", []byte{53, 0, 0, 0, 0, 200, 1, 2}, "ECHO=0", 1},
+		{"unnamed", []byte{99, 0, 0, 0, 7, 0}, "", 1},
+	} {
+		hook.Reset()
+		channel, requests, err := client.OpenChannel("session", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go ssh.DiscardRequests(requests)
+		if ok, err := channel.SendRequest("pty-req", true, ptyRequest(test.modes)); !ok || err != nil {
+			t.Errorf("%v pty-req = %v, %v, want it accepted", test.name, ok, err)
+		}
+		entry := waitFor(t, hook, "Request received")
+		if entry.Data["modes"] != test.want || entry.Data["mode_count"] != test.count {
+			t.Errorf("%v modes logged as %q, %v, want %q, %v", test.name, entry.Data["modes"], entry.Data["mode_count"], test.want, test.count)
+		}
+		channel.Close()
+	}
+}
+
+func TestTerminalModesTruncated(t *testing.T) {
+	hook := captureLog(t)
+	client := dial(t, newConfig())
+	channel, requests, err := client.OpenChannel("session", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer channel.Close()
+	go ssh.DiscardRequests(requests)
+	if ok, _ := channel.SendRequest("pty-req", true, ptyRequest([]byte{53, 0, 0})); ok {
+		t.Error("pty-req with truncated modes accepted")
+	}
+	if entry := waitFor(t, hook, "Malformed request rejected"); entry.Data["error"] != "truncated terminal modes" {
+		t.Errorf("logged %v, want the truncated modes", entry.Data)
+	}
+}
+
+func TestTerminalEchoHonored(t *testing.T) {
+	captureLog(t)
+	for _, echo := range []uint32{1, 0} {
+		client := dial(t, newConfig())
+		session, err := client.NewSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := session.RequestPty("xterm", 24, 80, ssh.TerminalModes{ssh.ECHO: echo}); err != nil {
+			t.Fatal(err)
+		}
+		stdin, err := session.StdinPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var stdout bytes.Buffer
+		session.Stdout = &stdout
+		if err := session.Shell(); err != nil {
+			t.Fatal(err)
+		}
+		stdin.Write([]byte("echo hidden\rexit\r"))
+		session.Wait()
+		session.Close()
+		if echoed := strings.Contains(stdout.String(), "echo hidden"); echoed != (echo == 1) || !strings.Contains(stdout.String(), "hidden\r\n") {
+			t.Errorf("with ECHO=%v, shell wrote %q", echo, stdout.String())
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package request_test
+
+import (
+	"bytes"
+	"golang.org/x/crypto/ssh"
+	"strings"
+	"testing"
+)
+
+func TestPTYDenied(t *testing.T) {
+	hook := captureLog(t)
+	cfg := newConfig()
+	cfg.Shell.DenyPTY = true
+	client := dial(t, cfg)
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	// OpenSSH prints PTY allocation request failed on channel 0 when the
+	// request is rejected.
+	if err := session.RequestPty("xterm", 24, 80, ssh.TerminalModes{}); err == nil {
+		t.Error("pty-req accepted")
+	}
+	waitFor(t, hook, "PTY allocation denied")
+
+	// The shell still runs, without a terminal.
+	var stdout bytes.Buffer
+	session.Stdin = strings.NewReader("echo hi\nexit\n")
+	session.Stdout = &stdout
+	if err := session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "hi\n" {
+		t.Errorf("shell wrote %q, want the output of echo without a prompt", stdout.String())
+	}
+}
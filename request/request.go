@@ -1,11 +1,17 @@
 package request
 
 import (
+	"encoding/hex"
 	"fmt"
+	"github.com/longkeyy/sshesame/config"
+	"github.com/longkeyy/sshesame/session"
+	"github.com/longkeyy/sshesame/shell"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
+	"math/rand"
 	"net"
 	"strconv"
+	"time"
 )
 
 // RFC 4254
@@ -108,16 +114,90 @@ func (payload exitSignal) String() string {
 	return fmt.Sprintf("%v, core dumped: %v, error message: %v, language: %v", payload.Name, payload.CoreDumped, payload.ErrorMessage, payload.Language)
 }
 
-func SendExitStatus(channel ssh.Channel) {
-	_, err := channel.SendRequest("exit-status", false, ssh.Marshal(exitStatus{0}))
+// RFC 4335
+type breakRequest struct {
+	Length uint32
+}
+
+func (payload breakRequest) String() string {
+	return fmt.Sprintf("%v ms", payload.Length)
+}
+
+func SendExitStatus(channel ssh.Channel, status uint32) {
+	_, err := channel.SendRequest("exit-status", false, ssh.Marshal(exitStatus{status}))
 	if err != nil {
 		log.Warning("Failed to send exit status:", err.Error())
 	}
 }
 
-func Handle(remoteAddr net.Addr, channel string, requests <-chan *ssh.Request) {
+// SendExitSignal tells the client the program on channel died of signal,
+// with errorMessage.
+func SendExitSignal(channel ssh.Channel, signal string, coreDumped bool, errorMessage string) {
+	_, err := channel.SendRequest("exit-signal", false, ssh.Marshal(exitSignal{Name: signal, CoreDumped: coreDumped, ErrorMessage: errorMessage}))
+	if err != nil {
+		log.Warning("Failed to send exit signal:", err.Error())
+	}
+}
+
+// sessionRequests are the session channel requests a server can receive.
+// Others are rejected, like OpenSSH rejects those it doesn't support.
+var sessionRequests = map[string]bool{
+	"pty-req":                    true,
+	"x11-req":                    true,
+	"env":                        true,
+	"shell":                      true,
+	"exec":                       true,
+	"subsystem":                  true,
+	"window-change":              true,
+	"xon-xoff":                   true,
+	"signal":                     true,
+	"break":                      true,
+	"exit-status":                true,
+	"exit-signal":                true,
+	"auth-agent-req@openssh.com": true,
+	"eow@openssh.com":            true,
+}
+
+// vendorRequests are requests only sent by some client implementations,
+// which are logged as a fingerprint of the client. Neither wants a reply.
+var vendorRequests = map[string]string{
+	// OpenSSH clients tell the server they won't write to the channel anymore
+	// but still read from it.
+	"eow@openssh.com": "OpenSSH",
+	// PuTTY tells the server it won't open more than one channel at a time.
+	"simple@putty.projects.tartarus.org": "PuTTY",
+}
+
+// Program is what a session channel was requested to run.
+type Program struct {
+	// Type is shell, exec or subsystem.
+	Type string
+	// Name is the command of exec programs and the name of subsystems.
+	Name string
+	// Subsystem is the subsystem serving the program: the name of
+	// subsystems, and for exec programs running a file transfer server, like
+	// /usr/lib/openssh/sftp-server or scp -t, the one emulating it.
+	Subsystem string
+}
+
+// Handle logs and replies to requests until the requests channel is closed.
+// For session channels, programs receives the first program the client
+// successfully requests and is closed once there are no more requests.
+func Handle(sess *session.Session, cfg *config.Config, channel string, requests <-chan *ssh.Request, programs chan<- Program) {
+	if programs != nil {
+		defer close(programs)
+	}
+	started := false
 	for request := range requests {
+		if cfg.Limits.MaxPayloadSize > 0 && len(request.Payload) > cfg.Limits.MaxPayloadSize {
+			rejectMalformed(sess, channel, request, fmt.Errorf("payload of %v bytes exceeds the limit of %v", len(request.Payload), cfg.Limits.MaxPayloadSize))
+			continue
+		}
 		var payload interface{} = request.Payload
+		fields := log.Fields{}
+		var modes map[string]uint32
+		var program *Program
+		var parseErr error
 		switch request.Type {
 		case "tcpip-forward":
 			fallthrough
@@ -125,7 +205,7 @@ func Handle(remoteAddr net.Addr, channel string, requests <-chan *ssh.Request) {
 			parsedPayload := tcpipForward{}
 			err := ssh.Unmarshal(request.Payload, &parsedPayload)
 			if err != nil {
-				log.Warning("Failed to parse payload:", err.Error())
+				parseErr = err
 				break
 			}
 			payload = parsedPayload
@@ -133,15 +213,22 @@ func Handle(remoteAddr net.Addr, channel string, requests <-chan *ssh.Request) {
 			parsedPayload := pty{}
 			err := ssh.Unmarshal(request.Payload, &parsedPayload)
 			if err != nil {
-				log.Warning("Failed to parse payload:", err.Error())
+				parseErr = err
+				break
+			}
+			modes, err = decodeModes(parsedPayload.Modes)
+			if err != nil {
+				parseErr = err
 				break
 			}
+			fields["modes"] = formatModes(modes)
+			fields["mode_count"] = len(modes)
 			payload = parsedPayload
 		case "x11-req":
 			parsedPayload := x11{}
 			err := ssh.Unmarshal(request.Payload, &parsedPayload)
 			if err != nil {
-				log.Warning("Failed to parse payload:", err.Error())
+				parseErr = err
 				break
 			}
 			payload = parsedPayload
@@ -149,7 +236,7 @@ func Handle(remoteAddr net.Addr, channel string, requests <-chan *ssh.Request) {
 			parsedPayload := env{}
 			err := ssh.Unmarshal(request.Payload, &parsedPayload)
 			if err != nil {
-				log.Warning("Failed to parse payload:", err.Error())
+				parseErr = err
 				break
 			}
 			payload = parsedPayload
@@ -157,23 +244,36 @@ func Handle(remoteAddr net.Addr, channel string, requests <-chan *ssh.Request) {
 			parsedPayload := exec{}
 			err := ssh.Unmarshal(request.Payload, &parsedPayload)
 			if err != nil {
-				log.Warning("Failed to parse payload:", err.Error())
+				parseErr = err
 				break
 			}
 			payload = parsedPayload
+			argv, err := shell.Split(parsedPayload.Command)
+			if err != nil {
+				argv = []string{parsedPayload.Command}
+				fields["argv_error"] = err.Error()
+			}
+			fields["argv"] = argv
+			program = &Program{"exec", parsedPayload.Command, transferSubsystem(argv)}
+			if program.Subsystem != "" {
+				fields["subsystem"] = program.Subsystem
+			}
+		case "shell":
+			program = &Program{"shell", "", ""}
 		case "subsystem":
 			parsedPayload := subsystem{}
 			err := ssh.Unmarshal(request.Payload, &parsedPayload)
 			if err != nil {
-				log.Warning("Failed to parse payload:", err.Error())
+				parseErr = err
 				break
 			}
 			payload = parsedPayload
+			program = &Program{"subsystem", parsedPayload.Name, parsedPayload.Name}
 		case "window-change":
 			parsedPayload := windowChange{}
 			err := ssh.Unmarshal(request.Payload, &parsedPayload)
 			if err != nil {
-				log.Warning("Failed to parse payload:", err.Error())
+				parseErr = err
 				break
 			}
 			payload = parsedPayload
@@ -181,7 +281,7 @@ func Handle(remoteAddr net.Addr, channel string, requests <-chan *ssh.Request) {
 			parsedPayload := flowControl{}
 			err := ssh.Unmarshal(request.Payload, &parsedPayload)
 			if err != nil {
-				log.Warning("Failed to parse payload:", err.Error())
+				parseErr = err
 				break
 			}
 			payload = parsedPayload
@@ -189,7 +289,15 @@ func Handle(remoteAddr net.Addr, channel string, requests <-chan *ssh.Request) {
 			parsedPayload := signal{}
 			err := ssh.Unmarshal(request.Payload, &parsedPayload)
 			if err != nil {
-				log.Warning("Failed to parse payload:", err.Error())
+				parseErr = err
+				break
+			}
+			payload = parsedPayload
+		case "break":
+			parsedPayload := breakRequest{}
+			err := ssh.Unmarshal(request.Payload, &parsedPayload)
+			if err != nil {
+				parseErr = err
 				break
 			}
 			payload = parsedPayload
@@ -197,7 +305,7 @@ func Handle(remoteAddr net.Addr, channel string, requests <-chan *ssh.Request) {
 			parsedPayload := exitStatus{}
 			err := ssh.Unmarshal(request.Payload, &parsedPayload)
 			if err != nil {
-				log.Warning("Failed to parse payload:", err.Error())
+				parseErr = err
 				break
 			}
 			payload = parsedPayload
@@ -205,23 +313,184 @@ func Handle(remoteAddr net.Addr, channel string, requests <-chan *ssh.Request) {
 			parsedPayload := exitSignal{}
 			err := ssh.Unmarshal(request.Payload, &parsedPayload)
 			if err != nil {
-				log.Warning("Failed to parse payload:", err.Error())
+				parseErr = err
 				break
 			}
 			payload = parsedPayload
 		}
-		log.WithFields(log.Fields{
-			"client":  remoteAddr,
+		if parseErr != nil {
+			rejectMalformed(sess, channel, request, parseErr)
+			continue
+		}
+		log.WithFields(fields).WithFields(log.Fields{
+			"client":  sess.RemoteAddr,
 			"channel": channel,
 			"request": request.Type,
 			"payload": payload,
 		}).Info("Request received")
+		if implementation, ok := vendorRequests[request.Type]; ok {
+			log.WithFields(log.Fields{
+				"client":         sess.RemoteAddr,
+				"channel":        channel,
+				"request":        request.Type,
+				"implementation": implementation,
+				"want_reply":     request.WantReply,
+			}).Info("Vendor request received")
+		}
+		accept := true
+		switch {
+		case request.Type == "simple@putty.projects.tartarus.org":
+			// OpenSSH doesn't implement it, so it fails if a reply is wanted.
+			accept = false
+		case channel == "session" && !sessionRequests[request.Type]:
+			accept = false
+			log.WithFields(log.Fields{
+				"client":      sess.RemoteAddr,
+				"channel":     channel,
+				"request":     request.Type,
+				"payload_hex": hex.EncodeToString(request.Payload),
+			}).Info("Unknown request rejected")
+		case request.Type == "xon-xoff":
+			accept = cfg.Requests.AcceptXonXoff
+		case channel == "session" && request.Type == "auth-agent-req@openssh.com":
+			// Like sshd, agent forwarding is only set up once per connection.
+			accept = sess.ForwardAgent()
+		}
+		if request.Type == "pty-req" && cfg.Shell.DenyPTY {
+			accept = false
+			log.WithFields(log.Fields{
+				"client":  sess.RemoteAddr,
+				"channel": channel,
+			}).Info("PTY allocation denied")
+		}
+		if programs != nil && (request.Type == "shell" || request.Type == "exec" || request.Type == "subsystem") {
+			// Like OpenSSH, only allow a single program per channel.
+			accept = program != nil && !started
+			if accept && program.Type == "subsystem" {
+				_, emulated := subsystemHandler(program.Name)
+				accept = emulated || cfg.Subsystems.AcceptUnknown
+				log.WithFields(log.Fields{
+					"client":    sess.RemoteAddr,
+					"channel":   channel,
+					"subsystem": program.Name,
+					"emulated":  emulated,
+					"accepted":  accept,
+				}).Info("Subsystem requested")
+			}
+		}
+		if request.Type == "shell" && programs != nil && cfg.Shell.DenyShell {
+			accept = false
+			log.WithFields(log.Fields{
+				"client":  sess.RemoteAddr,
+				"channel": channel,
+			}).Info("Shell denied")
+		}
+		var reply []byte
+		if forwardRequest, ok := payload.(tcpipForward); ok && sess.Forwards != nil {
+			reply, accept = forwardInSandbox(sess, request.Type, forwardRequest)
+		} else if ok && accept && request.Type == "tcpip-forward" && forwardRequest.BindPort == 0 {
+			reply = pretendForward(sess, forwardRequest)
+		}
 		if request.WantReply {
-			err := request.Reply(true, nil)
+			if cfg.Tarpit.Enabled {
+				time.Sleep(cfg.Tarpit.ReplyDelay)
+			}
+			err := request.Reply(accept, reply)
 			if err != nil {
 				log.Warning("Failed to accept request:", err.Error())
 				continue
 			}
 		}
+		logDecoded(sess, channel, payload, request.WantReply, accept)
+		if accept && programs != nil {
+			sess.ObserveRequest(request.Type)
+		}
+		if modes != nil && accept {
+			sess.SetTerminalModes(modes)
+		}
+		if variable, ok := payload.(env); ok && accept {
+			shell.SetEnv(sess, channel, variable.Name, variable.Value)
+		}
+		if terminal, ok := payload.(pty); ok && accept {
+			// Like sshd, the terminal type becomes TERM.
+			shell.SetEnv(sess, channel, "TERM", terminal.Term)
+			sess.SetTerminalSize(terminal.Width, terminal.Height)
+		}
+		if size, ok := payload.(windowChange); ok && accept {
+			sess.SetTerminalSize(size.Width, size.Height)
+		}
+		if channel == "session" && request.Type == "auth-agent-req@openssh.com" {
+			forwardAgent(sess, channel, accept)
+			if accept && cfg.Requests.FakeAgent {
+				sess.SetAgent(newFakeAgent(sess, cfg.Requests.AgentKeys))
+			}
+			if accept && cfg.Requests.ProbeAgent && sess.Conn != nil {
+				go probeAgent(sess)
+			}
+		}
+		if accept && program != nil && programs != nil {
+			shell.LogClientEnvironment(sess, channel)
+			started = true
+			programs <- *program
+		}
+	}
+}
+
+// forwardInSandbox really serves a tcpip-forward or cancel-tcpip-forward
+// request and returns the reply to it and whether it succeeded.
+func forwardInSandbox(sess *session.Session, requestType string, payload tcpipForward) ([]byte, bool) {
+	var err error
+	var reply []byte
+	if requestType == "tcpip-forward" {
+		var port uint32
+		port, err = sess.Forwards.Forward(payload.BindAddress, payload.BindPort)
+		if err == nil && payload.BindPort == 0 {
+			// RFC 4254 section 7.1
+			reply = ssh.Marshal(struct{ Port uint32 }{port})
+		}
+	} else {
+		err = sess.Forwards.Cancel(payload.BindAddress, payload.BindPort)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"client":  sess.RemoteAddr,
+			"request": requestType,
+			"forward": payload.String(),
+			"reason":  err.Error(),
+		}).Info("Remote forward refused")
+		return nil, false
+	}
+	return reply, true
+}
+
+// pretendForward returns the reply to a pretended tcpip-forward request
+// asking for any port, which tells the port allocated, one of the ephemeral
+// ports of Linux.
+func pretendForward(sess *session.Session, payload tcpipForward) []byte {
+	port := uint32(32768 + rand.Intn(61000-32768))
+	log.WithFields(log.Fields{
+		"client":  sess.RemoteAddr,
+		"request": "tcpip-forward",
+		"forward": payload.String(),
+		"port":    port,
+	}).Info("Remote forward port pretended")
+	// RFC 4254 section 7.1
+	return ssh.Marshal(struct{ Port uint32 }{port})
+}
+
+// rejectMalformed logs and rejects a request whose payload is too large or
+// can't be parsed.
+func rejectMalformed(sess *session.Session, channel string, request *ssh.Request, err error) {
+	log.WithFields(log.Fields{
+		"client":       sess.RemoteAddr,
+		"channel":      channel,
+		"request":      request.Type,
+		"payload_size": len(request.Payload),
+		"error":        err.Error(),
+	}).Warning("Malformed request rejected")
+	if request.WantReply {
+		if err := request.Reply(false, nil); err != nil {
+			log.Warning("Failed to reject request:", err.Error())
+		}
 	}
 }
@@ -0,0 +1,93 @@
+package request_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"github.com/longkeyy/sshesame/config"
+	"github.com/longkeyy/sshesame/honeypot"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// newConfig returns a configuration accepting any password.
+func newConfig() *config.Config {
+	return &config.Config{Auth: config.Auth{Methods: config.Methods{"password"}}}
+}
+
+// newServer returns a server configured by cfg with a new host key.
+func newServer(t *testing.T, cfg *config.Config) *honeypot.Server {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromSigner(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshConfig := &ssh.ServerConfig{ServerVersion: "SSH-2.0-OpenSSH_8.9p1"}
+	sshConfig.AddHostKey(signer)
+	return honeypot.NewServer(cfg, sshConfig)
+}
+
+// dial logs in to a new server configured by cfg as root like honeypot.Dial,
+// closing the connection at the end of the test and waiting for the server to
+// be done with it, so that nothing it logs leaks into other tests.
+func dial(t *testing.T, cfg *config.Config) *ssh.Client {
+	t.Helper()
+	server := newServer(t, cfg)
+	clientEnd, serverEnd := honeypot.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.HandleConn(serverEnd)
+	}()
+	t.Cleanup(func() {
+		clientEnd.Close()
+		<-done
+	})
+	conn, channels, requests, err := ssh.NewClientConn(clientEnd, serverEnd.LocalAddr().String(), &ssh.ClientConfig{
+		User:            "root",
+		Auth:            []ssh.AuthMethod{ssh.Password("password")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ssh.NewClient(conn, channels, requests)
+}
+
+// captureLog records the entries logged for the rest of the test instead
+// of printing them.
+func captureLog(t *testing.T) *logtest.Hook {
+	hook := logtest.NewGlobal()
+	out := log.StandardLogger().Out
+	log.SetOutput(ioutil.Discard)
+	t.Cleanup(func() {
+		log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+		log.SetOutput(out)
+	})
+	return hook
+}
+
+// waitFor returns the first entry logged with message, waiting for the
+// server to log it.
+func waitFor(t *testing.T, hook *logtest.Hook, message string) *log.Entry {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		for _, entry := range hook.AllEntries() {
+			if entry.Message == message {
+				return entry
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%q wasn't logged", message)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
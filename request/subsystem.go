@@ -0,0 +1,159 @@
+package request
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"github.com/longkeyy/sshesame/config"
+	"github.com/longkeyy/sshesame/session"
+	"github.com/longkeyy/sshesame/shell"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SubsystemHandler serves a subsystem on a session channel until the client
+// closes it.
+type SubsystemHandler func(sess *session.Session, cfg *config.Config, channel ssh.Channel) error
+
+var (
+	subsystemsMu sync.RWMutex
+	subsystems   = map[string]SubsystemHandler{
+		"sftp": serveSFTP,
+	}
+)
+
+// RegisterSubsystem makes the subsystem called name available to clients.
+func RegisterSubsystem(name string, handler SubsystemHandler) {
+	subsystemsMu.Lock()
+	defer subsystemsMu.Unlock()
+	subsystems[name] = handler
+}
+
+func subsystemHandler(name string) (SubsystemHandler, bool) {
+	subsystemsMu.RLock()
+	defer subsystemsMu.RUnlock()
+	handler, ok := subsystems[name]
+	return handler, ok
+}
+
+// subsystemSnippetSize is how many of the first bytes sent to a subsystem
+// that isn't emulated are logged once it closes.
+const subsystemSnippetSize = 64
+
+// ServeSubsystem serves the subsystem of program on channel, falling back to
+// capturing whatever the client sends, within the limits of cfg, if it isn't
+// registered, and returns its exit status. scp is only served for exec
+// programs, sshd having no such subsystem.
+func ServeSubsystem(sess *session.Session, program Program, channel ssh.Channel, cfg *config.Config) (uint32, error) {
+	if program.Type == "exec" && program.Subsystem == "scp" {
+		return serveSCP(sess, cfg, program.Name, channel)
+	}
+	handler, ok := subsystemHandler(program.Subsystem)
+	if !ok {
+		return 0, captureSubsystemInput(sess, program.Subsystem, channel, cfg.Subsystems)
+	}
+	return 0, handler(sess, cfg, channel)
+}
+
+// serveSFTP serves SFTP on the fake filesystem of shells.
+func serveSFTP(sess *session.Session, cfg *config.Config, channel ssh.Channel) error {
+	ctx, cancel := sess.Context(context.Background())
+	defer cancel()
+	return shell.ServeSFTP(ctx, sess, cfg.Shell, cfg.Subsystems.MaxUploadSize, channel)
+}
+
+// captureSubsystemInput logs what the client sends to the subsystem called
+// name until it closes channel or sends more than cfg.MaxCaptureBytes or for
+// longer than cfg.MaxCaptureDuration, saving it to cfg.CaptureDir if set.
+func captureSubsystemInput(sess *session.Session, name string, channel ssh.Channel, cfg config.Subsystems) error {
+	start := time.Now()
+	var timedOut int32
+	if cfg.MaxCaptureDuration > 0 {
+		timer := time.AfterFunc(cfg.MaxCaptureDuration, func() {
+			atomic.StoreInt32(&timedOut, 1)
+			channel.Close()
+		})
+		defer timer.Stop()
+	}
+	captured := []byte{}
+	truncated := false
+	data := make([]byte, 256)
+	var readErr error
+	for {
+		length, err := channel.Read(data)
+		if length > 0 {
+			chunk := data[:length]
+			if cfg.MaxCaptureBytes > 0 && len(captured)+length > cfg.MaxCaptureBytes {
+				chunk = chunk[:cfg.MaxCaptureBytes-len(captured)]
+				truncated = true
+			}
+			captured = append(captured, chunk...)
+			log.WithFields(log.Fields{
+				"client":    sess.RemoteAddr,
+				"channel":   "session",
+				"subsystem": name,
+				"data":      string(chunk),
+			}).Info("Subsystem input received")
+		}
+		if truncated {
+			break
+		}
+		if err != nil {
+			if err != io.EOF && atomic.LoadInt32(&timedOut) == 0 {
+				readErr = err
+			}
+			break
+		}
+	}
+	snippet := captured
+	if len(snippet) > subsystemSnippetSize {
+		snippet = snippet[:subsystemSnippetSize]
+	}
+	ascii := make([]byte, len(snippet))
+	for i, b := range snippet {
+		if b < ' ' || b > '~' {
+			b = '.'
+		}
+		ascii[i] = b
+	}
+	fields := log.Fields{
+		"client":        sess.RemoteAddr,
+		"channel":       "session",
+		"subsystem":     name,
+		"bytes":         len(captured),
+		"duration":      time.Since(start).String(),
+		"truncated":     truncated,
+		"timed_out":     atomic.LoadInt32(&timedOut) == 1,
+		"snippet_hex":   hex.EncodeToString(snippet),
+		"snippet_ascii": string(ascii),
+		"category":      "subsystem_capture",
+	}
+	if cfg.CaptureDir != "" && len(captured) > 0 {
+		capturePath := filepath.Join(cfg.CaptureDir, fmt.Sprintf("%v-%v-%v.bin", sess.ID, captureName(name), start.UnixNano()))
+		if err := os.WriteFile(capturePath, captured, 0600); err != nil {
+			log.Warning("Failed to save subsystem input:", err.Error())
+		} else {
+			fields["file"] = capturePath
+		}
+	}
+	log.WithFields(fields).Info("Subsystem input captured")
+	return readErr
+}
+
+// captureName returns the name of a subsystem with the characters that can't
+// be in file names replaced.
+func captureName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == 0 {
+			return '_'
+		}
+		return r
+	}, name)
+}
@@ -0,0 +1,165 @@
+package request_test
+
+import (
+	"encoding/hex"
+	"github.com/longkeyy/sshesame/config"
+	"github.com/longkeyy/sshesame/request"
+	"github.com/longkeyy/sshesame/session"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnknownSubsystemRejected(t *testing.T) {
+	hook := captureLog(t)
+	session, err := dial(t, newConfig()).NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	if err := session.RequestSubsystem("netconf"); err == nil {
+		t.Error("netconf subsystem accepted, want it rejected")
+	}
+	entry := waitFor(t, hook, "Subsystem requested")
+	if entry.Data["subsystem"] != "netconf" || entry.Data["emulated"] != false || entry.Data["accepted"] != false {
+		t.Errorf("logged %v, want netconf neither emulated nor accepted", entry.Data)
+	}
+}
+
+func TestUnknownSubsystemCaptured(t *testing.T) {
+	hook := captureLog(t)
+	cfg := newConfig()
+	cfg.Subsystems.AcceptUnknown = true
+	session, err := dial(t, cfg).NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.RequestSubsystem("netconf"); err != nil {
+		t.Fatalf("netconf subsystem rejected: %v", err)
+	}
+	stdin.Write([]byte("<hello/>"))
+	stdin.Close()
+	if entry := waitFor(t, hook, "Subsystem requested"); entry.Data["emulated"] != false || entry.Data["accepted"] != true {
+		t.Errorf("logged %v, want netconf accepted but not emulated", entry.Data)
+	}
+	entry := waitFor(t, hook, "Subsystem input captured")
+	if entry.Data["subsystem"] != "netconf" || entry.Data["bytes"] != 8 || entry.Data["snippet_ascii"] != "<hello/>" {
+		t.Errorf("logged %v, want the input captured", entry.Data)
+	}
+	session.Close()
+}
+
+func TestRegisteredSubsystemServed(t *testing.T) {
+	captureLog(t)
+	request.RegisterSubsystem("echo-test", func(sess *session.Session, cfg *config.Config, channel ssh.Channel) error {
+		data, err := ioutil.ReadAll(channel)
+		if err != nil {
+			return err
+		}
+		_, err = channel.Write(data)
+		return err
+	})
+	sshSession, err := dial(t, newConfig()).NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sshSession.Close()
+	stdin, err := sshSession.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := sshSession.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sshSession.RequestSubsystem("echo-test"); err != nil {
+		t.Fatalf("registered subsystem rejected: %v", err)
+	}
+	stdin.Write([]byte("ping"))
+	stdin.Close()
+	if output, err := ioutil.ReadAll(stdout); err != nil || string(output) != "ping" {
+		t.Errorf("subsystem answered %q, %v, want \"ping\"", output, err)
+	}
+}
+
+// captureSubsystem requests the netconf subsystem from a new server
+// configured by cfg, sends it input, leaving the channel open if keepOpen, and
+// returns the capture logged once it ends.
+func captureSubsystem(t *testing.T, hook *logtest.Hook, cfg *config.Config, input string, keepOpen bool) *log.Entry {
+	t.Helper()
+	sshSession, err := dial(t, cfg).NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sshSession.Close()
+	stdin, err := sshSession.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sshSession.RequestSubsystem("netconf"); err != nil {
+		t.Fatalf("netconf subsystem rejected: %v", err)
+	}
+	io.WriteString(stdin, input)
+	if !keepOpen {
+		stdin.Close()
+	}
+	// The channel is closed by the server once the capture ends.
+	sshSession.Wait()
+	return waitFor(t, hook, "Subsystem input captured")
+}
+
+func TestUnknownSubsystemCaptureCapped(t *testing.T) {
+	hook := captureLog(t)
+	cfg := newConfig()
+	cfg.Subsystems.AcceptUnknown = true
+	cfg.Subsystems.MaxCaptureBytes = 16
+	input := "<rpc><get-config><source><running/></source></get-config></rpc>"
+	entry := captureSubsystem(t, hook, cfg, input, true)
+	if entry.Data["bytes"] != 16 || entry.Data["truncated"] != true || entry.Data["timed_out"] != false {
+		t.Errorf("logged %v, want 16 bytes captured and the rest truncated", entry.Data)
+	}
+	if entry.Data["snippet_ascii"] != input[:16] || entry.Data["snippet_hex"] != hex.EncodeToString([]byte(input[:16])) || entry.Data["category"] != "subsystem_capture" {
+		t.Errorf("logged %v, want a snippet of %q", entry.Data, input[:16])
+	}
+	received := ""
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Subsystem input received" {
+			received += entry.Data["data"].(string)
+		}
+	}
+	if received != input[:16] {
+		t.Errorf("logged input %q, want %q", received, input[:16])
+	}
+
+	hook.Reset()
+	cfg.Subsystems.MaxCaptureBytes = 0
+	cfg.Subsystems.MaxCaptureDuration = 100 * time.Millisecond
+	if entry := captureSubsystem(t, hook, cfg, "\x00\x01hello", true); entry.Data["timed_out"] != true || entry.Data["bytes"] != 7 || entry.Data["snippet_ascii"] != "..hello" {
+		t.Errorf("logged %v, want the capture timed out", entry.Data)
+	}
+}
+
+func TestUnknownSubsystemCaptureSaved(t *testing.T) {
+	hook := captureLog(t)
+	cfg := newConfig()
+	cfg.Subsystems.AcceptUnknown = true
+	cfg.Subsystems.CaptureDir = t.TempDir()
+	entry := captureSubsystem(t, hook, cfg, "<hello/>", false)
+	file, _ := entry.Data["file"].(string)
+	if filepath.Dir(file) != cfg.Subsystems.CaptureDir || !strings.Contains(filepath.Base(file), "-netconf-") {
+		t.Fatalf("saved to %q, want a file of netconf in %v", file, cfg.Subsystems.CaptureDir)
+	}
+	if content, err := ioutil.ReadFile(file); err != nil || string(content) != "<hello/>" {
+		t.Errorf("saved %q, %v, want the input", content, err)
+	}
+}
@@ -0,0 +1,87 @@
+package request
+
+import (
+	"context"
+	"github.com/longkeyy/sshesame/config"
+	"github.com/longkeyy/sshesame/session"
+	"github.com/longkeyy/sshesame/shell"
+	"golang.org/x/crypto/ssh"
+	"path"
+	"strings"
+)
+
+// transferServers are the file transfer servers clients run through exec
+// requests instead of requesting a subsystem, by the subsystem serving them.
+var transferServers = map[string]string{
+	"sftp-server":   "sftp",
+	"internal-sftp": "sftp",
+	"scp":           "scp",
+}
+
+// transferSubsystem returns the subsystem serving the file transfer server
+// run by the command with arguments argv, or an empty string if it runs
+// something else. Servers are recognized by their base name wherever they're
+// installed, also when run through exec, env, sudo or sh -c, but scp only in
+// its server modes, -t (to) and -f (from).
+func transferSubsystem(argv []string) string {
+	argv = unwrapCommand(argv)
+	if len(argv) == 0 {
+		return ""
+	}
+	subsystem := transferServers[path.Base(argv[0])]
+	if subsystem != "scp" {
+		return subsystem
+	}
+	for _, arg := range argv[1:] {
+		if arg == "--" || !strings.HasPrefix(arg, "-") {
+			break
+		}
+		if strings.ContainsAny(arg[1:], "tf") {
+			return subsystem
+		}
+	}
+	return ""
+}
+
+// unwrapCommand strips the commands only running the rest of argv, and the
+// shells running a single command line, off argv.
+func unwrapCommand(argv []string) []string {
+	for len(argv) != 0 {
+		switch path.Base(argv[0]) {
+		case "exec", "sudo", "nohup", "command":
+			argv = argv[1:]
+			for len(argv) != 0 && strings.HasPrefix(argv[0], "-") {
+				argv = argv[1:]
+			}
+		case "env":
+			argv = argv[1:]
+			for len(argv) != 0 && (strings.HasPrefix(argv[0], "-") || strings.Contains(argv[0], "=")) {
+				argv = argv[1:]
+			}
+		case "sh", "bash", "dash", "zsh":
+			if len(argv) != 3 || argv[1] != "-c" {
+				return argv
+			}
+			inner, err := shell.Split(argv[2])
+			if err != nil {
+				return argv
+			}
+			argv = inner
+		default:
+			return argv
+		}
+	}
+	return argv
+}
+
+// serveSCP serves the scp server run by command on the fake filesystem of
+// shells.
+func serveSCP(sess *session.Session, cfg *config.Config, command string, channel ssh.Channel) (uint32, error) {
+	argv, err := shell.Split(command)
+	if err != nil {
+		return 1, nil
+	}
+	ctx, cancel := sess.Context(context.Background())
+	defer cancel()
+	return shell.ServeSCP(ctx, sess, cfg.Shell, cfg.Subsystems.MaxUploadSize, unwrapCommand(argv), channel)
+}
@@ -0,0 +1,64 @@
+package request_test
+
+import (
+	"testing"
+)
+
+func TestTransferCommandsRouted(t *testing.T) {
+	hook := captureLog(t)
+	client := dial(t, newConfig())
+	for _, test := range []struct {
+		command, subsystem string
+	}{
+		{"/usr/lib/openssh/sftp-server", "sftp"},
+		{"/usr/libexec/openssh/sftp-server -e -l INFO", "sftp"},
+		{`"/usr/lib/openssh/sftp-server"`, "sftp"},
+		{"internal-sftp", "sftp"},
+		{"env LC_ALL=C internal-sftp", "sftp"},
+		{"sudo -n /usr/lib/sftp-server", "sftp"},
+		{"sh -c '/usr/lib/openssh/sftp-server'", "sftp"},
+		{"scp -t /tmp", "scp"},
+		{"scp -r -t -- /tmp", "scp"},
+		{"scp -qf '/tmp/my file'", "scp"},
+		{"exec scp -p -f /etc/passwd", "scp"},
+		// scp run as a client, or sftp-server only mentioned, isn't served.
+		{"scp /etc/passwd backup:", ""},
+		{"ls /usr/lib/openssh/sftp-server", ""},
+		{"echo internal-sftp", ""},
+	} {
+		hook.Reset()
+		session, err := client.NewSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := session.Start(test.command); err != nil {
+			t.Fatalf("%v: %v", test.command, err)
+		}
+		if entry := waitFor(t, hook, "Request received"); entry.Data["subsystem"] != nilIfEmpty(test.subsystem) {
+			t.Errorf("%v: request logged with subsystem %v, want %q", test.command, entry.Data["subsystem"], test.subsystem)
+		}
+		if test.subsystem != "" {
+			entry := waitFor(t, hook, "File transfer command routed to subsystem")
+			if entry.Data["subsystem"] != test.subsystem {
+				t.Errorf("%v: routing logged with %v, want %v", test.command, entry.Data, test.subsystem)
+			}
+		} else {
+			session.Wait()
+			for _, entry := range hook.AllEntries() {
+				if entry.Message == "File transfer command routed to subsystem" {
+					t.Errorf("%v: routed to %v, want it run by the shell", test.command, entry.Data["subsystem"])
+				}
+			}
+		}
+		session.Close()
+	}
+}
+
+// nilIfEmpty returns nil for the subsystem of commands not routed to any,
+// which is logged without one.
+func nilIfEmpty(subsystem string) interface{} {
+	if subsystem == "" {
+		return nil
+	}
+	return subsystem
+}
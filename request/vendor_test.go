@@ -0,0 +1,44 @@
+package request_test
+
+import (
+	"golang.org/x/crypto/ssh"
+	"testing"
+)
+
+func TestVendorRequests(t *testing.T) {
+	hook := captureLog(t)
+	for _, test := range []struct {
+		request        string
+		implementation string
+		accepted       bool
+	}{
+		{"eow@openssh.com", "OpenSSH", true},
+		{"simple@putty.projects.tartarus.org", "PuTTY", false},
+	} {
+		for _, wantReply := range []bool{false, true} {
+			hook.Reset()
+			channel, requests, err := dial(t, newConfig()).OpenChannel("session", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			go ssh.DiscardRequests(requests)
+			ok, err := channel.SendRequest(test.request, wantReply, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if wantReply && ok != test.accepted {
+				t.Errorf("%v = %v, want %v", test.request, ok, test.accepted)
+			}
+			// Without a reply wanted, none is sent: the next reply is that of
+			// a rejected request, not a stray success.
+			if ok, err := channel.SendRequest("keepalive@example.com", true, nil); ok || err != nil {
+				t.Errorf("request after %v with want_reply %v = %v, %v, want it rejected", test.request, wantReply, ok, err)
+			}
+			entry := waitFor(t, hook, "Vendor request received")
+			if entry.Data["request"] != test.request || entry.Data["implementation"] != test.implementation || entry.Data["want_reply"] != wantReply {
+				t.Errorf("%v logged with %v, want it from %v", test.request, entry.Data, test.implementation)
+			}
+			channel.Close()
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package samples
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/longkeyy/sshesame/outbound"
+	"github.com/longkeyy/sshesame/session"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxDownloadRedirects bounds the redirects followed by downloads.
+const maxDownloadRedirects = 5
+
+// sharedAddressSpace is the carrier-grade NAT network of RFC 6598, which
+// isn't reachable from the internet either.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0).To4(), Mask: net.CIDRMask(10, 32)}
+
+// Downloads configures fetching the files clients download onto the host.
+type Downloads struct {
+	// Proxy, if set, is the URL of an HTTP proxy every download goes
+	// through. Otherwise, downloads only connect to public addresses, so
+	// that clients can't make the honeypot reach its own network.
+	Proxy   string
+	MaxSize int64
+	// ContentTypes are the media types downloaded, type/ matching every
+	// subtype, or empty for any.
+	ContentTypes []string
+	Timeout      time.Duration
+}
+
+// Downloader fetches the HTTP and HTTPS URLs clients download files from,
+// with wget or curl, so that the files are collected even though the fake
+// host can't reach anything.
+type Downloader struct {
+	cfg    Downloads
+	client *http.Client
+}
+
+// NewDownloader returns a downloader fetching by cfg.
+func NewDownloader(cfg Downloads) (*Downloader, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialPublic
+	if cfg.Proxy != "" {
+		proxy, err := url.Parse(cfg.Proxy)
+		if err != nil || proxy.Host == "" {
+			return nil, fmt.Errorf("invalid proxy %q", cfg.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+		transport.DialContext = outbound.DialContext
+	}
+	client := &http.Client{
+		Timeout:   cfg.Timeout,
+		Transport: transport,
+		CheckRedirect: func(request *http.Request, via []*http.Request) error {
+			if len(via) >= maxDownloadRedirects {
+				return errors.New("too many redirects")
+			}
+			return nil
+		},
+	}
+	return &Downloader{cfg: cfg, client: client}, nil
+}
+
+// Download implements session.Downloader, refusing files larger than the
+// maximum size or of other media types than the allowed ones.
+func (downloader *Downloader) Download(rawURL string) (session.Download, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return session.Download{}, err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return session.Download{}, fmt.Errorf("unsupported scheme %q", parsed.Scheme)
+	}
+	request, err := http.NewRequest(http.MethodGet, parsed.String(), nil)
+	if err != nil {
+		return session.Download{}, err
+	}
+	request.Header.Set("User-Agent", "Wget/1.21.2")
+	response, err := downloader.client.Do(request)
+	if err != nil {
+		return session.Download{}, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return session.Download{}, fmt.Errorf("unexpected status %v", response.Status)
+	}
+	contentType := response.Header.Get("Content-Type")
+	if !downloader.allows(contentType) {
+		return session.Download{}, fmt.Errorf("content type %q not allowed", contentType)
+	}
+	if downloader.cfg.MaxSize > 0 && response.ContentLength > downloader.cfg.MaxSize {
+		return session.Download{}, fmt.Errorf("%v bytes exceed the maximum size", response.ContentLength)
+	}
+	var body io.Reader = response.Body
+	if downloader.cfg.MaxSize > 0 {
+		body = io.LimitReader(body, downloader.cfg.MaxSize+1)
+	}
+	content, err := ioutil.ReadAll(body)
+	if err != nil {
+		return session.Download{}, err
+	}
+	if downloader.cfg.MaxSize > 0 && int64(len(content)) > downloader.cfg.MaxSize {
+		return session.Download{}, errors.New("file exceeds the maximum size")
+	}
+	return session.Download{Content: content, ContentType: contentType}, nil
+}
+
+// allows reports whether files of contentType are downloaded.
+func (downloader *Downloader) allows(contentType string) bool {
+	if len(downloader.cfg.ContentTypes) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "application/octet-stream"
+	}
+	for _, allowed := range downloader.cfg.ContentTypes {
+		if mediaType == allowed || strings.HasSuffix(allowed, "/") && strings.HasPrefix(mediaType, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// dialPublic connects to address like outbound.DialContext, on the public
+// addresses it resolves to only.
+func dialPublic(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var lastErr error = fmt.Errorf("%v has no public address", host)
+	for _, ip := range ips {
+		if !public(ip.IP) {
+			continue
+		}
+		conn, err := outbound.DialContext(ctx, network, net.JoinHostPort(ip.IP.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// public reports whether ip is reachable from the internet.
+func public(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedAddressSpace.Contains(ip)
+}
@@ -0,0 +1,149 @@
+package samples
+
+import (
+	"encoding/json"
+	"github.com/longkeyy/sshesame/session"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// manifestEntry is a line of the manifest of a session, describing a file
+// the client put on the host.
+type manifestEntry struct {
+	SessionID string `json:"session_id"`
+	Client    string `json:"client"`
+	Path      string `json:"path"`
+	Name      string `json:"name"`
+	SHA256    string `json:"sha256"`
+	Size      int    `json:"size"`
+	Source    string `json:"source"`
+	Timestamp string `json:"timestamp"`
+}
+
+// Quarantine stores the files clients put on the host in a local directory,
+// named by their SHA-256 hash, along with a JSON Lines manifest of the files of
+// every session, named by its ID. Manifests are written to as files come in
+// and closed once their session ends.
+type Quarantine struct {
+	dir string
+
+	mu sync.Mutex
+	// manifests are the open manifests, by session ID.
+	manifests map[string]*os.File
+}
+
+// NewQuarantine returns a quarantine in dir, which is created if needed.
+func NewQuarantine(dir string) (*Quarantine, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Quarantine{dir: dir, manifests: map[string]*os.File{}}, nil
+}
+
+// Collect implements session.FileCollector, storing file unless it's stored
+// already and adding it to the manifest of sess.
+func (quarantine *Quarantine) Collect(sess *session.Session, file session.File) {
+	fields := log.Fields{
+		"client": sess.RemoteAddr,
+		"sha256": file.SHA256,
+		"source": file.Source,
+	}
+	if err := quarantine.store(file); err != nil {
+		log.WithFields(fields).Warning("Failed to quarantine sample:", err.Error())
+		return
+	}
+	entry, err := json.Marshal(manifestEntry{
+		SessionID: sess.ID,
+		Client:    sess.RemoteAddr.String(),
+		Path:      file.Path,
+		Name:      path.Base(file.Path),
+		SHA256:    file.SHA256,
+		Size:      len(file.Content),
+		Source:    file.Source,
+		Timestamp: file.Created.UTC().Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		log.WithFields(fields).Warning("Failed to add sample to manifest:", err.Error())
+		return
+	}
+	quarantine.mu.Lock()
+	defer quarantine.mu.Unlock()
+	manifest, ok := quarantine.manifests[sess.ID]
+	if !ok {
+		// Files put after the session ended are appended to its manifest.
+		manifest, err = os.OpenFile(quarantine.manifest(sess.ID), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			log.WithFields(fields).Warning("Failed to open manifest:", err.Error())
+			return
+		}
+		quarantine.manifests[sess.ID] = manifest
+	}
+	if _, err := manifest.Write(append(entry, '\n')); err != nil {
+		log.WithFields(fields).Warning("Failed to add sample to manifest:", err.Error())
+		return
+	}
+	fields["size"] = len(file.Content)
+	fields["manifest"] = manifest.Name()
+	log.WithFields(fields).Info("Sample quarantined")
+}
+
+// EndSession implements session.SessionCollector, closing the manifest of
+// sess.
+func (quarantine *Quarantine) EndSession(sess *session.Session) {
+	quarantine.mu.Lock()
+	defer quarantine.mu.Unlock()
+	manifest, ok := quarantine.manifests[sess.ID]
+	if !ok {
+		return
+	}
+	delete(quarantine.manifests, sess.ID)
+	if err := manifest.Close(); err != nil {
+		log.Warning("Failed to close manifest:", err.Error())
+	}
+}
+
+// manifest returns the path of the manifest of the session with id.
+func (quarantine *Quarantine) manifest(id string) string {
+	return filepath.Join(quarantine.dir, id+".jsonl")
+}
+
+// store writes the content of file, unless a file with the same hash is
+// stored already.
+func (quarantine *Quarantine) store(file session.File) error {
+	stored, err := os.OpenFile(filepath.Join(quarantine.dir, file.SHA256), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := stored.Write(file.Content); err != nil {
+		stored.Close()
+		return err
+	}
+	return stored.Close()
+}
+
+// Collectors hands the files clients put on the host to every one of them.
+type Collectors []session.FileCollector
+
+// Collect implements session.FileCollector.
+func (collectors Collectors) Collect(sess *session.Session, file session.File) {
+	for _, collector := range collectors {
+		collector.Collect(sess, file)
+	}
+}
+
+// EndSession implements session.SessionCollector, telling the collectors
+// keeping state for sessions that sess ended.
+func (collectors Collectors) EndSession(sess *session.Session) {
+	for _, collector := range collectors {
+		if sessionCollector, ok := collector.(session.SessionCollector); ok {
+			sessionCollector.EndSession(sess)
+		}
+	}
+}
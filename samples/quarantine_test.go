@@ -0,0 +1,116 @@
+package samples
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/longkeyy/sshesame/session"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// readManifest returns the entries of the manifest of the session with id in
+// dir.
+func readManifest(t *testing.T, dir, id string) []manifestEntry {
+	t.Helper()
+	manifest, err := os.Open(filepath.Join(dir, id+".jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer manifest.Close()
+	entries := []manifestEntry{}
+	scanner := bufio.NewScanner(manifest)
+	for scanner.Scan() {
+		var entry manifestEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("manifest line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestQuarantineManifest(t *testing.T) {
+	hook := captureLog(t)
+	dir := filepath.Join(t.TempDir(), "quarantine")
+	quarantine, err := NewQuarantine(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess := newSession()
+	sess.Collector = quarantine
+	uploaded := sess.AddFile("/tmp/x 1", []byte("#!/bin/sh\n"), "scp")
+	downloaded := sess.AddFile("/tmp/.x/miner", []byte("\x7fELF"), "wget")
+	// A file put again is listed again, but stored once.
+	again := sess.AddFile("/root/x", []byte("#!/bin/sh\n"), "sftp")
+	sess.EndCollection()
+
+	entries := readManifest(t, dir, sess.ID)
+	want := []manifestEntry{}
+	for _, file := range []session.File{uploaded, downloaded, again} {
+		want = append(want, manifestEntry{
+			SessionID: "0b6e5f9c-1d9a-4c47-9a35-6f0f0b61f8a1",
+			Client:    "192.0.2.1:50000",
+			Path:      file.Path,
+			Name:      filepath.Base(file.Path),
+			SHA256:    file.SHA256,
+			Size:      len(file.Content),
+			Source:    file.Source,
+			Timestamp: file.Created.UTC().Format(time.RFC3339Nano),
+		})
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("manifest lists\n%+v\nwant\n%+v", entries, want)
+	}
+	for _, file := range []session.File{uploaded, downloaded} {
+		if content, err := ioutil.ReadFile(filepath.Join(dir, file.SHA256)); err != nil || string(content) != string(file.Content) {
+			t.Errorf("stored %v as %q, %v", file.Path, content, err)
+		}
+	}
+	if stored, err := ioutil.ReadDir(dir); err != nil || len(stored) != 3 {
+		t.Errorf("quarantine holds %v, %v, want the manifest and two files", len(stored), err)
+	}
+	if entry := hook.LastEntry(); entry == nil || entry.Message != "Sample quarantined" || entry.Data["sha256"] != again.SHA256 || entry.Data["manifest"] != filepath.Join(dir, sess.ID+".jsonl") {
+		t.Errorf("logged %v, want the sample quarantined", entry)
+	}
+	if len(quarantine.manifests) != 0 {
+		t.Errorf("%v manifests left open after the session ended", len(quarantine.manifests))
+	}
+}
+
+func TestQuarantineManifestsPerSession(t *testing.T) {
+	captureLog(t)
+	dir := t.TempDir()
+	quarantine, err := NewQuarantine(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, second := newSession(), newSession()
+	second.ID = "5c1f3a2e-8b7d-4e6f-a0c9-2d4b6e8f0a1c"
+	for _, sess := range []*session.Session{first, second} {
+		sess.Collector = Collectors{quarantine}
+	}
+	first.AddFile("/tmp/a", []byte("a"), "scp")
+	second.AddFile("/tmp/b", []byte("b"), "wget")
+	first.EndCollection()
+	// Files put after the session ended are still appended to its manifest.
+	first.AddFile("/tmp/c", []byte("c"), "scp")
+	first.EndCollection()
+	second.EndCollection()
+
+	for id, paths := range map[string][]string{first.ID: {"/tmp/a", "/tmp/c"}, second.ID: {"/tmp/b"}} {
+		listed := []string{}
+		for _, entry := range readManifest(t, dir, id) {
+			listed = append(listed, entry.Path)
+		}
+		if !reflect.DeepEqual(listed, paths) {
+			t.Errorf("manifest of %v lists %v, want %v", id, listed, paths)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+// Package samples collects the files clients put on the fake host, such as
+// malware they upload or download, into S3-compatible object storage or a
+// local quarantine directory, and fetches the files they download.
+package samples
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/longkeyy/sshesame/outbound"
+	"github.com/longkeyy/sshesame/session"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// queueSize bounds the samples waiting to be uploaded, further ones are
+	// dropped rather than slowing down sessions.
+	queueSize     = 64
+	uploadTimeout = time.Minute
+	// emptySHA256 is the hash of an empty payload, signed for HEAD requests.
+	emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// S3 configures the bucket samples are uploaded to.
+type S3 struct {
+	// Endpoint is the URL of the S3 API, e.g. https://s3.eu-west-1.amazonaws.com
+	// or http://localhost:9000 for MinIO. Buckets are addressed by path.
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+}
+
+// sample is a file to upload.
+type sample struct {
+	file      session.File
+	clientIP  string
+	sessionID string
+}
+
+// Uploader uploads the files clients put on the host to a bucket, named by
+// their SHA-256 hash, in the background. Files already in the bucket, or
+// uploaded before, aren't uploaded again.
+type Uploader struct {
+	cfg     S3
+	client  *http.Client
+	samples chan sample
+	done    chan struct{}
+
+	mu       sync.Mutex
+	uploaded map[string]bool
+	closed   bool
+}
+
+// NewUploader returns an uploader to the bucket of cfg.
+func NewUploader(cfg S3) *Uploader {
+	uploader := &Uploader{
+		cfg:      cfg,
+		client:   outbound.HTTPClient(uploadTimeout),
+		samples:  make(chan sample, queueSize),
+		done:     make(chan struct{}),
+		uploaded: map[string]bool{},
+	}
+	go uploader.upload()
+	return uploader
+}
+
+// Collect implements session.FileCollector, queueing file for upload.
+func (uploader *Uploader) Collect(sess *session.Session, file session.File) {
+	uploader.mu.Lock()
+	defer uploader.mu.Unlock()
+	if uploader.closed || uploader.uploaded[file.SHA256] {
+		return
+	}
+	clientIP := sess.RemoteAddr.String()
+	if host, _, err := net.SplitHostPort(clientIP); err == nil {
+		clientIP = host
+	}
+	select {
+	case uploader.samples <- sample{file, clientIP, sess.ID}:
+		uploader.uploaded[file.SHA256] = true
+	default:
+		log.WithFields(log.Fields{
+			"client": sess.RemoteAddr,
+			"sha256": file.SHA256,
+		}).Warning("Sample upload queue full, sample dropped")
+	}
+}
+
+// Close uploads the queued samples and stops the uploader.
+func (uploader *Uploader) Close() {
+	uploader.mu.Lock()
+	if uploader.closed {
+		uploader.mu.Unlock()
+		return
+	}
+	uploader.closed = true
+	close(uploader.samples)
+	uploader.mu.Unlock()
+	<-uploader.done
+}
+
+// upload uploads queued samples until the uploader is closed.
+func (uploader *Uploader) upload() {
+	defer close(uploader.done)
+	for sample := range uploader.samples {
+		fields := log.Fields{
+			"sha256": sample.file.SHA256,
+			"bucket": uploader.cfg.Bucket,
+			"source": sample.file.Source,
+		}
+		exists, err := uploader.exists(sample.file.SHA256)
+		if err != nil {
+			log.WithFields(fields).Warning("Failed to look up sample:", err.Error())
+		}
+		if exists {
+			log.WithFields(fields).Debug("Sample already stored")
+			continue
+		}
+		if err := uploader.put(sample); err != nil {
+			uploader.mu.Lock()
+			delete(uploader.uploaded, sample.file.SHA256)
+			uploader.mu.Unlock()
+			log.WithFields(fields).Warning("Failed to upload sample:", err.Error())
+			continue
+		}
+		fields["size"] = len(sample.file.Content)
+		log.WithFields(fields).Info("Sample uploaded")
+	}
+}
+
+// exists reports whether the bucket holds an object named key.
+func (uploader *Uploader) exists(key string) (bool, error) {
+	request, err := uploader.request(http.MethodHead, key, nil, emptySHA256, nil)
+	if err != nil {
+		return false, err
+	}
+	response, err := uploader.client.Do(request)
+	if err != nil {
+		return false, err
+	}
+	response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("storage responded %v", response.Status)
+	}
+}
+
+// put uploads sample, with where it came from as metadata.
+func (uploader *Uploader) put(sample sample) error {
+	metadata := map[string]string{
+		"x-amz-meta-source-ip":  sample.clientIP,
+		"x-amz-meta-session-id": sample.sessionID,
+		"x-amz-meta-timestamp":  sample.file.Created.UTC().Format(time.RFC3339),
+		"x-amz-meta-source":     sample.file.Source,
+		"x-amz-meta-path":       url.PathEscape(sample.file.Path),
+	}
+	request, err := uploader.request(http.MethodPut, sample.file.SHA256, sample.file.Content, sample.file.SHA256, metadata)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/octet-stream")
+	response, err := uploader.client.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("storage responded %v", response.Status)
+	}
+	return nil
+}
+
+// request returns a request for the object named key, signed with AWS
+// Signature Version 4. payloadHash is the hex SHA-256 hash of body.
+func (uploader *Uploader) request(method, key string, body []byte, payloadHash string, headers map[string]string) (*http.Request, error) {
+	endpoint := strings.TrimSuffix(uploader.cfg.Endpoint, "/")
+	request, err := http.NewRequest(method, endpoint+"/"+uploader.cfg.Bucket+"/"+key, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	signed := map[string]string{
+		"host":                 request.URL.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           amzDate,
+	}
+	for name, value := range headers {
+		signed[name] = value
+	}
+	names := make([]string, 0, len(signed))
+	for name := range signed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%v:%v\n", name, strings.TrimSpace(signed[name]))
+		if name != "host" {
+			request.Header.Set(name, signed[name])
+		}
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{
+		method,
+		request.URL.EscapedPath(),
+		"",
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := now.Format("20060102") + "/" + uploader.cfg.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+	signingKey := []byte("AWS4" + uploader.cfg.SecretKey)
+	for _, part := range []string{now.Format("20060102"), uploader.cfg.Region, "s3", "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%v/%v, SignedHeaders=%v, Signature=%v",
+		uploader.cfg.AccessKey, scope, signedHeaders, signature))
+	return request, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,179 @@
+package samples
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/longkeyy/sshesame/session"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// captureLog records the entries logged for the rest of the test instead
+// of printing them.
+func captureLog(t *testing.T) *logtest.Hook {
+	hook := logtest.NewGlobal()
+	out := log.StandardLogger().Out
+	log.SetOutput(ioutil.Discard)
+	t.Cleanup(func() {
+		log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+		log.SetOutput(out)
+	})
+	return hook
+}
+
+// bucket is a mock of the S3 API holding the objects of a single bucket.
+type bucket struct {
+	*httptest.Server
+	mu      sync.Mutex
+	objects map[string]*http.Request
+	bodies  map[string]string
+	puts    int
+	// release, if set, holds back answers until it's closed.
+	release chan struct{}
+}
+
+func newBucket(t *testing.T, existing ...string) *bucket {
+	bucket := &bucket{objects: map[string]*http.Request{}, bodies: map[string]string{}}
+	for _, key := range existing {
+		bucket.objects["/samples/"+key] = nil
+	}
+	bucket.Server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if bucket.release != nil {
+			<-bucket.release
+		}
+		if !strings.HasPrefix(request.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+			writer.WriteHeader(http.StatusForbidden)
+			return
+		}
+		bucket.mu.Lock()
+		defer bucket.mu.Unlock()
+		switch request.Method {
+		case http.MethodHead:
+			if _, ok := bucket.objects[request.URL.Path]; !ok {
+				writer.WriteHeader(http.StatusNotFound)
+			}
+		case http.MethodPut:
+			body, _ := ioutil.ReadAll(request.Body)
+			bucket.objects[request.URL.Path] = request
+			bucket.bodies[request.URL.Path] = string(body)
+			bucket.puts++
+		default:
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	t.Cleanup(bucket.Close)
+	return bucket
+}
+
+// newFile returns a file with content uploaded with scp at created.
+func newFile(content string, created time.Time) session.File {
+	sum := sha256.Sum256([]byte(content))
+	return session.File{Path: "/tmp/x 1", Content: []byte(content), SHA256: hex.EncodeToString(sum[:]), Source: "scp", Created: created}
+}
+
+// newSession returns a session of a client of 192.0.2.1.
+func newSession() *session.Session {
+	sess := session.New(&net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 50000})
+	sess.ID = "0b6e5f9c-1d9a-4c47-9a35-6f0f0b61f8a1"
+	return sess
+}
+
+func newTestUploader(bucket *bucket) *Uploader {
+	return NewUploader(S3{Endpoint: bucket.URL + "/", Region: "us-east-1", Bucket: "samples", AccessKey: "AKID", SecretKey: "secret"})
+}
+
+func TestSampleUploaded(t *testing.T) {
+	hook := captureLog(t)
+	bucket := newBucket(t)
+	uploader := newTestUploader(bucket)
+	created := time.Date(2026, time.October, 4, 3, 29, 30, 0, time.FixedZone("CEST", 2*60*60))
+	file := newFile("#!/bin/sh\n", created)
+	uploader.Collect(newSession(), file)
+	uploader.Close()
+
+	request := bucket.objects["/samples/"+file.SHA256]
+	if request == nil {
+		t.Fatalf("uploaded %v, want an object named by the sample's hash", bucket.objects)
+	}
+	if body := bucket.bodies["/samples/"+file.SHA256]; body != "#!/bin/sh\n" {
+		t.Errorf("uploaded %q", body)
+	}
+	for header, value := range map[string]string{
+		"X-Amz-Meta-Source-Ip":  "192.0.2.1",
+		"X-Amz-Meta-Session-Id": "0b6e5f9c-1d9a-4c47-9a35-6f0f0b61f8a1",
+		"X-Amz-Meta-Timestamp":  "2026-10-04T01:29:30Z",
+		"X-Amz-Meta-Source":     "scp",
+		"X-Amz-Meta-Path":       "%2Ftmp%2Fx%201",
+		"X-Amz-Content-Sha256":  file.SHA256,
+	} {
+		if request.Header.Get(header) != value {
+			t.Errorf("%v = %q, want %q", header, request.Header.Get(header), value)
+		}
+	}
+	authorization := request.Header.Get("Authorization")
+	if !regexp.MustCompile(`^AWS4-HMAC-SHA256 Credential=AKID/\d{8}/us-east-1/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date;x-amz-meta-path;x-amz-meta-session-id;x-amz-meta-source;x-amz-meta-source-ip;x-amz-meta-timestamp, Signature=[0-9a-f]{64}$`).MatchString(authorization) {
+		t.Errorf("Authorization = %q, want the metadata signed", authorization)
+	}
+	if entry := hook.LastEntry(); entry == nil || entry.Message != "Sample uploaded" || entry.Data["sha256"] != file.SHA256 || entry.Data["bucket"] != "samples" {
+		t.Errorf("logged %v, want the upload", entry)
+	}
+}
+
+func TestSampleDeduplicated(t *testing.T) {
+	captureLog(t)
+	stored := newFile("stored", time.Now())
+	bucket := newBucket(t, stored.SHA256)
+	uploader := newTestUploader(bucket)
+	file := newFile("new", time.Now())
+	// Files are only uploaded once, and not at all if already stored.
+	for _, collected := range []session.File{file, file, stored} {
+		uploader.Collect(newSession(), collected)
+	}
+	uploader.Close()
+	if bucket.puts != 1 || bucket.objects["/samples/"+stored.SHA256] != nil {
+		t.Errorf("uploaded %v objects, want only the new one", bucket.puts)
+	}
+}
+
+func TestSampleUploadAsynchronous(t *testing.T) {
+	captureLog(t)
+	bucket := newBucket(t)
+	bucket.release = make(chan struct{})
+	uploader := newTestUploader(bucket)
+	start := time.Now()
+	for i := 0; i < queueSize+1; i++ {
+		uploader.Collect(newSession(), newFile(strings.Repeat("x", i), time.Now()))
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("collecting with the bucket unresponsive took %v", elapsed)
+	}
+	close(bucket.release)
+	uploader.Close()
+	// The sample the uploader was busy with and a full queue of others.
+	if bucket.puts < queueSize {
+		t.Errorf("uploaded %v samples, want the %v queued", bucket.puts, queueSize)
+	}
+}
+
+func TestSampleUploadRetriedLater(t *testing.T) {
+	captureLog(t)
+	bucket := newBucket(t)
+	uploader := NewUploader(S3{Endpoint: bucket.URL, Region: "us-east-1", Bucket: "samples", AccessKey: "wrong", SecretKey: "secret"})
+	file := newFile("#!/bin/sh\n", time.Now())
+	uploader.Collect(newSession(), file)
+	uploader.Close()
+	// A failed upload is forgotten, so that the file is uploaded again when
+	// it's next collected.
+	if uploader.uploaded[file.SHA256] {
+		t.Error("failed upload remembered as uploaded")
+	}
+}
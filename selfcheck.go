@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"github.com/longkeyy/sshesame/config"
+	"github.com/longkeyy/sshesame/output"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	selfCheckUser = "root"
+	// selfCheckTimeout bounds every check, including waiting for its event.
+	selfCheckTimeout = 30 * time.Second
+)
+
+// selfCheck is an authentication attempt made against the server itself.
+type selfCheck struct {
+	method string
+	// messages are those of the events authentication attempts with method
+	// are logged with.
+	messages []string
+	auth     func() (ssh.AuthMethod, error)
+}
+
+var selfChecks = []selfCheck{
+	{
+		method:   "password",
+		messages: []string{"Password authentication accepted", "Password authentication rejected"},
+		auth: func() (ssh.AuthMethod, error) {
+			return ssh.Password("self-check"), nil
+		},
+	},
+	{
+		method:   "publickey",
+		messages: []string{"Public key authentication accepted", "Public key authentication rejected"},
+		auth: func() (ssh.AuthMethod, error) {
+			_, key, err := ed25519.GenerateKey(nil)
+			if err != nil {
+				return nil, err
+			}
+			signer, err := ssh.NewSignerFromSigner(key)
+			if err != nil {
+				return nil, err
+			}
+			return ssh.PublicKeys(signer), nil
+		},
+	},
+	{
+		method:   "keyboard-interactive",
+		messages: []string{"Keyboard-interactive authentication accepted", "Keyboard-interactive authentication rejected"},
+		auth: func() (ssh.AuthMethod, error) {
+			return ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+				answers := make([]string, len(questions))
+				for i := range answers {
+					answers[i] = "self-check"
+				}
+				return answers, nil
+			}), nil
+		},
+	},
+}
+
+// runSelfChecks attempts to authenticate to the server listening on address
+// with every enabled method, checking that it answers and logs the attempt,
+// and reports whether all checks passed. With proxyProtocol, connections
+// start with a PROXY protocol header keeping their own address.
+func runSelfChecks(address string, proxyProtocol bool, methods config.Methods, counter *output.CounterSink) bool {
+	passed := true
+	for _, check := range selfChecks {
+		if !methods.Enabled(check.method) {
+			log.WithFields(log.Fields{
+				"method": check.method,
+			}).Info("Self-check skipped, method disabled")
+			continue
+		}
+		result, err := check.run(address, proxyProtocol, counter)
+		if err != nil {
+			passed = false
+			log.WithFields(log.Fields{
+				"method": check.method,
+			}).Error("Self-check failed:", err.Error())
+			continue
+		}
+		log.WithFields(log.Fields{
+			"method": check.method,
+			"result": result,
+		}).Info("Self-check passed")
+	}
+	return passed
+}
+
+// run makes the authentication attempt of check and returns whether it was
+// accepted or rejected.
+func (check selfCheck) run(address string, proxyProtocol bool, counter *output.CounterSink) (string, error) {
+	before := counter.Count(check.messages...)
+	auth, err := check.auth()
+	if err != nil {
+		return "", err
+	}
+	result := "accepted"
+	conn, err := net.DialTimeout("tcp", address, selfCheckTimeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(selfCheckTimeout))
+	if proxyProtocol {
+		if _, err := conn.Write([]byte("PROXY UNKNOWN\r\n")); err != nil {
+			return "", err
+		}
+	}
+	sshConn, _, _, err := ssh.NewClientConn(conn, address, &ssh.ClientConfig{
+		User:            selfCheckUser,
+		Auth:            []ssh.AuthMethod{auth},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	switch {
+	case err == nil:
+		sshConn.Close()
+	case strings.Contains(err.Error(), "unable to authenticate"):
+		result = "rejected"
+	default:
+		return "", err
+	}
+	// Events are counted asynchronously.
+	deadline := time.Now().Add(selfCheckTimeout)
+	for counter.Count(check.messages...) == before {
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("the attempt was %v but not logged", result)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return result, nil
+}
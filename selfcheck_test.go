@@ -0,0 +1,84 @@
+package main
+
+import (
+	"github.com/longkeyy/sshesame/config"
+	"github.com/longkeyy/sshesame/output"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"testing"
+)
+
+// serveSelfCheck serves connections on a new loopback listener with a
+// honeypot enabling methods whose events are counted by the returned
+// counter, returning its address.
+func serveSelfCheck(t *testing.T, methods config.Methods) (string, *output.CounterSink) {
+	t.Helper()
+	counter := output.NewCounterSink()
+	dispatcher := &output.Dispatcher{}
+	dispatcher.Add("counter", counter, 64)
+	log.AddHook(dispatcher)
+	t.Cleanup(dispatcher.Close)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve(t, listener, &config.Config{Auth: config.Auth{Methods: methods}})
+	return listener.Addr().String(), counter
+}
+
+func TestSelfCheckPasses(t *testing.T) {
+	hook := captureLog(t)
+	methods := config.Methods{"password", "publickey", "keyboard-interactive"}
+	address, counter := serveSelfCheck(t, methods)
+	if !runSelfChecks(address, false, methods, counter) {
+		t.Fatal("self-check failed against a working server")
+	}
+	passed := map[interface{}]bool{}
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Self-check passed" {
+			passed[entry.Data["method"]] = true
+		}
+	}
+	for _, method := range methods {
+		if !passed[method] {
+			t.Errorf("%v self-check didn't pass", method)
+		}
+	}
+}
+
+func TestSelfCheckSkipsDisabledMethods(t *testing.T) {
+	hook := captureLog(t)
+	methods := config.Methods{"password"}
+	address, counter := serveSelfCheck(t, methods)
+	if !runSelfChecks(address, false, methods, counter) {
+		t.Fatal("self-check failed against a working server")
+	}
+	skipped := map[interface{}]bool{}
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Self-check skipped, method disabled" {
+			skipped[entry.Data["method"]] = true
+		}
+	}
+	if !skipped["publickey"] || !skipped["keyboard-interactive"] || skipped["password"] {
+		t.Errorf("skipped %v, want only the disabled methods", skipped)
+	}
+}
+
+func TestSelfCheckFails(t *testing.T) {
+	hook := captureLog(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nothing answers on the address once it's closed.
+	address := listener.Addr().String()
+	listener.Close()
+	if runSelfChecks(address, false, config.Methods{"password"}, output.NewCounterSink()) {
+		t.Fatal("self-check passed without a server")
+	}
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Self-check passed" {
+			t.Errorf("%v self-check passed", entry.Data["method"])
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package session
+
+// Account is an account a client added to the fake host.
+type Account struct {
+	Name  string
+	Shell string
+}
+
+// AddAccount records an account added by the client. It reports whether
+// there was none with the same name yet.
+func (session *Session) AddAccount(account Account) bool {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	for _, existing := range session.accounts {
+		if existing.Name == account.Name {
+			return false
+		}
+	}
+	session.accounts = append(session.accounts, account)
+	return true
+}
+
+// Accounts returns the accounts added by the client, in order.
+func (session *Session) Accounts() []Account {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return append([]Account(nil), session.accounts...)
+}
+
+// SetPassword records a password the client set for user.
+func (session *Session) SetPassword(user, password string) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.passwords == nil {
+		session.passwords = map[string]string{}
+	}
+	session.passwords[user] = password
+}
+
+// Password returns the password the client set for user, if any.
+func (session *Session) Password(user string) (string, bool) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	password, ok := session.passwords[user]
+	return password, ok
+}
@@ -0,0 +1,225 @@
+package session
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// File is a file a client put on the fake host, through an upload or a
+// download, so that later commands can be correlated with it.
+type File struct {
+	Path    string
+	Content []byte
+	SHA256  string
+	// Source is how the file got there, e.g. scp or wget.
+	Source     string
+	Executable bool
+	Created    time.Time
+}
+
+// FileCollector receives the files clients put on the host, e.g. to store
+// samples of malware.
+type FileCollector interface {
+	Collect(session *Session, file File)
+}
+
+// SessionCollector is a FileCollector keeping state for every session, which
+// it's told to release once the session ends.
+type SessionCollector interface {
+	FileCollector
+	EndSession(session *Session)
+}
+
+// EndCollection tells the session's collector, if it keeps state for
+// sessions, that the session ended.
+func (session *Session) EndCollection() {
+	if collector, ok := session.Collector.(SessionCollector); ok {
+		collector.EndSession(session)
+	}
+}
+
+// Downloader fetches the files clients download onto the host, e.g. with
+// wget, so that they're collected like the ones they upload.
+type Downloader interface {
+	Download(url string) (Download, error)
+}
+
+// Download is a file fetched by a Downloader.
+type Download struct {
+	Content     []byte
+	ContentType string
+}
+
+// Dir is a directory a client made on the fake host.
+type Dir struct {
+	Path    string
+	Created time.Time
+	// Fresh is set for directories made where one was removed, whose
+	// previous contents stay removed.
+	Fresh bool
+}
+
+// AddFile records a file put at path, replacing any previous one, and hands
+// it to the session's collector, if any.
+func (session *Session) AddFile(path string, content []byte, source string) File {
+	file := newFile(path, content, source)
+	session.mu.Lock()
+	if session.files == nil {
+		session.files = map[string]*File{}
+	}
+	session.files[path] = &file
+	delete(session.removed, path)
+	session.mu.Unlock()
+	if session.Collector != nil {
+		session.Collector.Collect(session, file)
+	}
+	return file
+}
+
+// CollectFile hands a file the client got without putting it on the host,
+// like a download written to standard output, to the session's collector, if
+// any. name is what the file is known by, e.g. the last element of its URL.
+func (session *Session) CollectFile(name string, content []byte, source string) File {
+	file := newFile(name, content, source)
+	if session.Collector != nil {
+		session.Collector.Collect(session, file)
+	}
+	return file
+}
+
+func newFile(path string, content []byte, source string) File {
+	sum := sha256.Sum256(content)
+	return File{
+		Path:    path,
+		Content: content,
+		SHA256:  hex.EncodeToString(sum[:]),
+		Source:  source,
+		Created: time.Now(),
+	}
+}
+
+// File returns the file put at path, if any.
+func (session *Session) File(path string) (File, bool) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	file, ok := session.files[path]
+	if !ok {
+		return File{}, false
+	}
+	return *file, true
+}
+
+// Files returns the files put on the host, sorted by path.
+func (session *Session) Files() []File {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	files := make([]File, 0, len(session.files))
+	for _, file := range session.files {
+		files = append(files, *file)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	return files
+}
+
+// SetExecutable changes whether the file put at path is executable. It reports
+// whether there is such a file.
+func (session *Session) SetExecutable(path string, executable bool) bool {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	file, ok := session.files[path]
+	if ok {
+		file.Executable = executable
+	}
+	return ok
+}
+
+// MakeDir records a directory made at dirPath.
+func (session *Session) MakeDir(dirPath string) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.dirs == nil {
+		session.dirs = map[string]*Dir{}
+	}
+	session.dirs[dirPath] = &Dir{Path: dirPath, Created: time.Now(), Fresh: session.removed[dirPath]}
+	delete(session.removed, dirPath)
+}
+
+// Dir returns the directory made at dirPath, if any.
+func (session *Session) Dir(dirPath string) (Dir, bool) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	dir, ok := session.dirs[dirPath]
+	if !ok {
+		return Dir{}, false
+	}
+	return *dir, true
+}
+
+// Entries returns the names of the files put and directories made in the
+// directory at dirPath.
+func (session *Session) Entries(dirPath string) []string {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	names := []string{}
+	for filePath := range session.files {
+		if path.Dir(filePath) == dirPath {
+			names = append(names, path.Base(filePath))
+		}
+	}
+	for made := range session.dirs {
+		if made != "/" && path.Dir(made) == dirPath {
+			names = append(names, path.Base(made))
+		}
+	}
+	return names
+}
+
+// Remove removes the file or directory at filePath, along with everything
+// beneath it, hiding them from the rest of the fake host too.
+func (session *Session) Remove(filePath string) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	prefix := strings.TrimSuffix(filePath, "/") + "/"
+	for removed := range session.files {
+		if removed == filePath || strings.HasPrefix(removed, prefix) {
+			delete(session.files, removed)
+		}
+	}
+	for removed := range session.dirs {
+		if removed == filePath || strings.HasPrefix(removed, prefix) {
+			delete(session.dirs, removed)
+		}
+	}
+	if session.removed == nil {
+		session.removed = map[string]bool{}
+	}
+	session.removed[filePath] = true
+}
+
+// Removed reports whether the rest of the fake host's file or directory at
+// filePath is hidden, as it or a parent directory was removed, or is beneath
+// a directory made afresh. Files put and directories made there since aren't
+// hidden.
+func (session *Session) Removed(filePath string) bool {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.removed[filePath] {
+		return true
+	}
+	for dir := filePath; dir != "/"; {
+		dir = path.Dir(dir)
+		if session.removed[dir] {
+			return true
+		}
+		if made, ok := session.dirs[dir]; ok && made.Fresh {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package session
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// testCollector records the files it collects and the sessions it's told of
+// the end of.
+type testCollector struct {
+	files []File
+	ended []*Session
+}
+
+func (collector *testCollector) Collect(session *Session, file File) {
+	collector.files = append(collector.files, file)
+}
+
+func (collector *testCollector) EndSession(session *Session) {
+	collector.ended = append(collector.ended, session)
+}
+
+func TestAddFile(t *testing.T) {
+	collector := &testCollector{}
+	sess := New(nil)
+	sess.Collector = collector
+	sess.AddFile("/tmp/b", []byte("old"), "scp")
+	// The SHA-256 example of FIPS 180-2.
+	file := sess.AddFile("/tmp/b", []byte("abc"), "wget")
+	if file.SHA256 != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Errorf("SHA-256 of abc = %v", file.SHA256)
+	}
+	sess.AddFile("/tmp/a", []byte("a"), "sftp")
+	if !sess.SetExecutable("/tmp/b", true) || sess.SetExecutable("/tmp/c", true) {
+		t.Error("SetExecutable reported the wrong files")
+	}
+	paths := []string{}
+	for _, file := range sess.Files() {
+		paths = append(paths, file.Path)
+	}
+	if want := []string{"/tmp/a", "/tmp/b"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("put %v, want %v", paths, want)
+	}
+	if file, ok := sess.File("/tmp/b"); !ok || string(file.Content) != "abc" || file.Source != "wget" || !file.Executable {
+		t.Errorf("/tmp/b is %+v, %v", file, ok)
+	}
+
+	sources := []string{}
+	for _, file := range collector.files {
+		sources = append(sources, file.Source)
+	}
+	if want := []string{"scp", "wget", "sftp"}; !reflect.DeepEqual(sources, want) {
+		t.Errorf("collected files from %v, want %v", sources, want)
+	}
+	sess.EndCollection()
+	if len(collector.ended) != 1 || collector.ended[0] != sess {
+		t.Errorf("told the collector of the end of %v", collector.ended)
+	}
+}
+
+func TestCollectFile(t *testing.T) {
+	collector := &testCollector{}
+	sess := New(nil)
+	// Files got without being put on the host are only collected.
+	if file := sess.CollectFile("install.sh", []byte("abc"), "curl"); file.Path != "install.sh" || file.SHA256 != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Errorf("collected %+v without a collector", file)
+	}
+	sess.Collector = collector
+	sess.CollectFile("install.sh", []byte("abc"), "curl")
+	if _, ok := sess.File("install.sh"); ok || len(sess.Files()) != 0 {
+		t.Error("collected file put on the host")
+	}
+	if len(collector.files) != 1 || collector.files[0].Path != "install.sh" || collector.files[0].Source != "curl" {
+		t.Errorf("collected %+v", collector.files)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	sess := New(nil)
+	sess.MakeDir("/tmp/work")
+	sess.AddFile("/tmp/work/a", nil, "scp")
+	sess.AddFile("/tmp/workers", nil, "scp")
+	if entries := sess.Entries("/tmp"); !reflect.DeepEqual(sortedStrings(entries), []string{"work", "workers"}) {
+		t.Errorf("/tmp has %v", entries)
+	}
+
+	// Removing a directory removes what's beneath it, not its siblings
+	// sharing its prefix.
+	sess.Remove("/tmp/work")
+	if _, ok := sess.Dir("/tmp/work"); ok {
+		t.Error("/tmp/work not removed")
+	}
+	if _, ok := sess.File("/tmp/work/a"); ok {
+		t.Error("/tmp/work/a not removed")
+	}
+	if _, ok := sess.File("/tmp/workers"); !ok {
+		t.Error("/tmp/workers removed")
+	}
+	for filePath, want := range map[string]bool{
+		"/tmp/work":         true,
+		"/tmp/work/a":       true,
+		"/tmp/work/deep/er": true,
+		"/tmp/workers":      false,
+		"/tmp":              false,
+		"/":                 false,
+	} {
+		if sess.Removed(filePath) != want {
+			t.Errorf("Removed(%v) = %v, want %v", filePath, !want, want)
+		}
+	}
+
+	// Directories made again are empty, what the host had there staying
+	// hidden.
+	sess.MakeDir("/tmp/work")
+	if dir, ok := sess.Dir("/tmp/work"); !ok || !dir.Fresh {
+		t.Errorf("/tmp/work made again is %+v, %v", dir, ok)
+	}
+	if sess.Removed("/tmp/work") || !sess.Removed("/tmp/work/a") {
+		t.Errorf("/tmp/work made again removed %v, its contents %v", sess.Removed("/tmp/work"), sess.Removed("/tmp/work/a"))
+	}
+	sess.MakeDir("/var/cache")
+	if dir, _ := sess.Dir("/var/cache"); dir.Fresh || sess.Removed("/var/cache/apt") {
+		t.Error("directory made over the host's is fresh")
+	}
+	sess.AddFile("/etc/passwd", nil, "scp")
+	sess.Remove("/etc/passwd")
+	sess.AddFile("/etc/passwd", nil, "scp")
+	if sess.Removed("/etc/passwd") {
+		t.Error("file put again is removed")
+	}
+}
+
+func sortedStrings(values []string) []string {
+	sorted := append([]string{}, values...)
+	sort.Strings(sorted)
+	return sorted
+}
@@ -0,0 +1,24 @@
+package session
+
+// AddHistory records a command line entered in a shell.
+func (session *Session) AddHistory(line string) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.history = append(session.history, line)
+}
+
+// ClearHistory forgets the command lines entered so far, like history -c.
+func (session *Session) ClearHistory() {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.history = nil
+	session.historyCleared = true
+}
+
+// History returns the command lines entered in shells, in order, and whether
+// the history was cleared before them.
+func (session *Session) History() ([]string, bool) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return append([]string(nil), session.history...), session.historyCleared
+}
@@ -0,0 +1,111 @@
+package session
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// Hosts makes the fake host presented to returning clients the same one they
+// saw before, so that revisiting doesn't give the honeypot away. The host is
+// derived from a key identifying the client, such as its address, and what
+// the client changed on it is kept for a while after it leaves.
+type Hosts struct {
+	salt   string
+	window time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*stickyHost
+}
+
+// stickyHost is what is kept of the fake host of a key.
+type stickyHost struct {
+	origin    time.Time
+	lastSeen  time.Time
+	files     map[string]*File
+	dirs      map[string]*Dir
+	removed   map[string]bool
+	accounts  []Account
+	passwords map[string]string
+}
+
+// NewHosts returns hosts derived from keys and salt, which keeps the same keys
+// from giving the same hosts on other servers. Changes to a host are kept for
+// window after the last client using it leaves.
+func NewHosts(salt string, window time.Duration) *Hosts {
+	return &Hosts{salt: salt, window: window, hosts: map[string]*stickyHost{}}
+}
+
+// seed derives the seed of the host of key.
+func (hosts *Hosts) seed(key string) int64 {
+	sum := sha256.Sum256([]byte(hosts.salt + "\x00" + key))
+	return int64(binary.BigEndian.Uint64(sum[:8]) >> 1)
+}
+
+// Restore makes sess present the host of key, with the changes clients made
+// to it within the window.
+func (hosts *Hosts) Restore(sess *Session, key string) {
+	hosts.mu.Lock()
+	defer hosts.mu.Unlock()
+	now := time.Now()
+	for name, host := range hosts.hosts {
+		if now.Sub(host.lastSeen) > hosts.window {
+			delete(hosts.hosts, name)
+		}
+	}
+	host, ok := hosts.hosts[key]
+	if !ok {
+		host = &stickyHost{origin: sess.Start}
+		hosts.hosts[key] = host
+	}
+	host.lastSeen = now
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.Seed = hosts.seed(key)
+	sess.Origin = host.origin
+	sess.files = map[string]*File{}
+	for path, file := range host.files {
+		copied := *file
+		sess.files[path] = &copied
+	}
+	sess.dirs = map[string]*Dir{}
+	for path, dir := range host.dirs {
+		copied := *dir
+		sess.dirs[path] = &copied
+	}
+	sess.removed = map[string]bool{}
+	for path := range host.removed {
+		sess.removed[path] = true
+	}
+	sess.accounts = append([]Account(nil), host.accounts...)
+	sess.passwords = map[string]string{}
+	for user, password := range host.passwords {
+		sess.passwords[user] = password
+	}
+}
+
+// Save keeps the changes sess made to the host of key.
+func (hosts *Hosts) Save(sess *Session, key string) {
+	hosts.mu.Lock()
+	defer hosts.mu.Unlock()
+	saved := &stickyHost{lastSeen: time.Now(), origin: sess.Origin, files: map[string]*File{}, dirs: map[string]*Dir{}, removed: map[string]bool{}, passwords: map[string]string{}}
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	for path, file := range sess.files {
+		copied := *file
+		saved.files[path] = &copied
+	}
+	for path, dir := range sess.dirs {
+		copied := *dir
+		saved.dirs[path] = &copied
+	}
+	for path := range sess.removed {
+		saved.removed[path] = true
+	}
+	saved.accounts = append([]Account(nil), sess.accounts...)
+	for user, password := range sess.passwords {
+		saved.passwords[user] = password
+	}
+	hosts.hosts[key] = saved
+}
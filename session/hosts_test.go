@@ -0,0 +1,76 @@
+package session
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHostsSeed(t *testing.T) {
+	// The first 8 bytes of SHA-256("salt\x00192.0.2.1"), without the sign
+	// bit.
+	if seed := NewHosts("salt", time.Hour).seed("192.0.2.1"); seed != 4829460641140278897 {
+		t.Errorf("seeded 192.0.2.1 with %v", seed)
+	}
+	if NewHosts("other", time.Hour).seed("192.0.2.1") == NewHosts("salt", time.Hour).seed("192.0.2.1") {
+		t.Error("salts don't change hosts")
+	}
+}
+
+func TestHostsRestored(t *testing.T) {
+	hosts := NewHosts("salt", time.Hour)
+	first := New(nil)
+	hosts.Restore(first, "192.0.2.1")
+	origin := first.Origin
+	if first.Seed != hosts.seed("192.0.2.1") {
+		t.Errorf("seeded the host with %v", first.Seed)
+	}
+	first.AddFile("/tmp/x", []byte("x"), "scp")
+	first.MakeDir("/tmp/work")
+	first.Remove("/etc/motd")
+	first.AddAccount(Account{Name: "backdoor", Shell: "/bin/bash"})
+	first.SetPassword("root", "hunter2")
+	hosts.Save(first, "192.0.2.1")
+	// Changes made after saving aren't kept.
+	first.AddFile("/tmp/y", nil, "scp")
+	first.SetExecutable("/tmp/x", true)
+
+	second := New(nil)
+	hosts.Restore(second, "192.0.2.1")
+	if second.Seed != first.Seed || !second.Origin.Equal(origin) {
+		t.Errorf("restored the seed %v and origin %v, want %v and %v", second.Seed, second.Origin, first.Seed, origin)
+	}
+	if file, ok := second.File("/tmp/x"); !ok || string(file.Content) != "x" || file.Executable {
+		t.Errorf("restored /tmp/x as %+v, %v", file, ok)
+	}
+	if _, ok := second.File("/tmp/y"); ok {
+		t.Error("restored /tmp/y, put after saving")
+	}
+	if _, ok := second.Dir("/tmp/work"); !ok || !second.Removed("/etc/motd") {
+		t.Error("didn't restore the directories made and removed")
+	}
+	if password, _ := second.Password("root"); password != "hunter2" || !reflect.DeepEqual(second.Accounts(), []Account{{"backdoor", "/bin/bash"}}) {
+		t.Errorf("restored the accounts %v and root password %q", second.Accounts(), password)
+	}
+
+	other := New(nil)
+	hosts.Restore(other, "192.0.2.2")
+	if other.Seed == first.Seed || len(other.Files()) != 0 || !other.Origin.Equal(other.Start) {
+		t.Errorf("192.0.2.2 got the host of 192.0.2.1")
+	}
+}
+
+func TestHostsForgotten(t *testing.T) {
+	hosts := NewHosts("salt", 10*time.Millisecond)
+	sess := New(nil)
+	hosts.Restore(sess, "192.0.2.1")
+	sess.AddFile("/tmp/x", nil, "scp")
+	hosts.Save(sess, "192.0.2.1")
+	time.Sleep(20 * time.Millisecond)
+	returning := New(nil)
+	hosts.Restore(returning, "192.0.2.1")
+	// The host is the same, without the changes.
+	if _, ok := returning.File("/tmp/x"); ok || returning.Seed != sess.Seed || !returning.Origin.Equal(returning.Start) {
+		t.Errorf("kept the host of 192.0.2.1 past the window")
+	}
+}
@@ -0,0 +1,250 @@
+// Package session tracks the state of a client connection across its channels
+// and requests.
+package session
+
+import (
+	"context"
+	"fmt"
+	"github.com/longkeyy/sshesame/forward"
+	"github.com/longkeyy/sshesame/tracing"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// Interaction classifies how a client used its connection.
+const (
+	// Interactive sessions requested a pseudo-terminal and a shell, which usually
+	// means someone is typing.
+	Interactive = "interactive"
+	// Automated sessions ran commands, shells or subsystems without a
+	// pseudo-terminal.
+	Automated = "automated"
+	// Probe sessions never ran anything.
+	Probe = "probe"
+)
+
+// Session is the state of a single client connection.
+type Session struct {
+	// ID identifies the connection, e.g. in collected samples and the
+	// session_id field of events.
+	ID         string
+	RemoteAddr net.Addr
+	Start      time.Time
+	// User is the user the client authenticated as.
+	User string
+	// Seed seeds everything randomized about the fake host, so that all
+	// channels of a connection see the same host.
+	Seed int64
+	// Origin is the time the clock of the fake host is derived from, Start
+	// unless the host is one a returning client saw before.
+	Origin time.Time
+	// Span traces the connection, nil if tracing is disabled.
+	Span *tracing.Span
+	// Forwards are the remote forwards really served in sandbox mode, nil
+	// otherwise.
+	Forwards *forward.Forwards
+	// Conn is the SSH connection of the client, for opening channels to it.
+	Conn ssh.Conn
+	// Collector, if set, receives every file the client puts on the host.
+	Collector FileCollector
+	// Downloader, if set, fetches the files the client downloads onto the
+	// host.
+	Downloader Downloader
+	// Jumper, if set, serves the fake hosts clients jump into from this one.
+	Jumper Jumper
+	// Depth is how many jumps away from the host the client connected to
+	// this one is, 0 for that host.
+	Depth int
+	// Shutdown, if set, is closed once the server starts shutting down.
+	Shutdown <-chan struct{}
+	// Closed, if set, is closed once the connection is.
+	Closed <-chan struct{}
+
+	mu    sync.Mutex
+	pty   bool
+	shell bool
+	ran   bool
+	// agentForwarded is set once the client requested agent forwarding.
+	agentForwarded bool
+	// fakeAgent is the fake agent the client's forwarded one is answered
+	// with, if set.
+	fakeAgent agent.ExtendedAgent
+	files     map[string]*File
+	// dirs are the directories the client made and removed the paths it
+	// removed, see MakeDir and Remove.
+	dirs    map[string]*Dir
+	removed map[string]bool
+	env     map[string]string
+	modes   map[string]uint32
+	// width and height are the size of the pseudo-terminal in characters.
+	width, height uint32
+	// accounts and passwords were added and set by the client.
+	accounts  []Account
+	passwords map[string]string
+	// history are the command lines entered in shells, historyCleared is
+	// set once the client cleared the history.
+	history        []string
+	historyCleared bool
+}
+
+// Jumper serves a fake host over a direct-tcpip channel to the SSH port of
+// target, for clients using the host of sess as a jump host. It returns once
+// the client left the fake host.
+type Jumper interface {
+	Jump(sess *Session, channel ssh.Channel, target string)
+}
+
+// New returns the state of a connection from remoteAddr starting now.
+func New(remoteAddr net.Addr) *Session {
+	start := time.Now()
+	return &Session{
+		ID:         NewID(),
+		RemoteAddr: remoteAddr,
+		Start:      start,
+		Seed:       rand.Int63(),
+		Origin:     start,
+	}
+}
+
+// Context returns a context derived from parent, canceled along with the
+// returned function once the server starts shutting down or the connection
+// is closed, so that what's served on it, like delayed output, ends with it.
+func (sess *Session) Context(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	go func() {
+		select {
+		case <-sess.Shutdown:
+		case <-sess.Closed:
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+	return ctx, cancel
+}
+
+// NewID returns a random session ID, a version 4 UUID.
+func NewID() string {
+	high, low := rand.Uint64(), rand.Uint64()
+	// RFC 9562 section 5.4
+	high = high&^0xf000 | 0x4000
+	low = low&^(0xc<<60) | 0x8<<60
+	return fmt.Sprintf("%08x-%04x-%04x-%04x-%012x", high>>32, high>>16&0xffff, high&0xffff, low>>48, low&0xffffffffffff)
+}
+
+// ForwardAgent reports whether the client didn't request agent forwarding
+// on the connection yet, and records that it now did.
+func (session *Session) ForwardAgent() bool {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	forwarded := session.agentForwarded
+	session.agentForwarded = true
+	return !forwarded
+}
+
+// ObserveRequest records an accepted session channel request.
+func (session *Session) ObserveRequest(requestType string) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	switch requestType {
+	case "pty-req":
+		session.pty = true
+	case "shell":
+		session.shell = true
+		session.ran = true
+	case "exec", "subsystem":
+		session.ran = true
+	}
+}
+
+// SetAgent sets the fake agent the client's forwarded agent is answered
+// with.
+func (session *Session) SetAgent(fakeAgent agent.ExtendedAgent) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.fakeAgent = fakeAgent
+}
+
+// Agent returns the fake agent set, nil if the client didn't forward its
+// agent or it isn't answered with one.
+func (session *Session) Agent() agent.ExtendedAgent {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return session.fakeAgent
+}
+
+// SetEnv records an environment variable set by the client.
+func (session *Session) SetEnv(name, value string) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.env == nil {
+		session.env = map[string]string{}
+	}
+	session.env[name] = value
+}
+
+// Env returns a copy of the environment variables set by the client.
+func (session *Session) Env() map[string]string {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	env := make(map[string]string, len(session.env))
+	for name, value := range session.env {
+		env[name] = value
+	}
+	return env
+}
+
+// SetTerminalModes records the terminal modes of the pseudo-terminal, by name.
+func (session *Session) SetTerminalModes(modes map[string]uint32) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.modes = modes
+}
+
+// TerminalMode returns the value of a terminal mode of the pseudo-terminal,
+// if the client set it.
+func (session *Session) TerminalMode(name string) (uint32, bool) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	value, ok := session.modes[name]
+	return value, ok
+}
+
+// SetTerminalSize records the size of the pseudo-terminal in characters.
+func (session *Session) SetTerminalSize(width, height uint32) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.width, session.height = width, height
+}
+
+// TerminalSize returns the size of the pseudo-terminal in characters, zero if
+// the client didn't tell it.
+func (session *Session) TerminalSize() (width, height uint32) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return session.width, session.height
+}
+
+// PTY reports whether a pseudo-terminal was allocated.
+func (session *Session) PTY() bool {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return session.pty
+}
+
+// Interaction classifies the requests observed so far.
+func (session *Session) Interaction() string {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	switch {
+	case session.pty && session.shell:
+		return Interactive
+	case session.ran:
+		return Automated
+	default:
+		return Probe
+	}
+}
@@ -0,0 +1,59 @@
+package session
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestNewID(t *testing.T) {
+	// The version 4 layout of RFC 9562 section 5.4, with the variant of
+	// section 4.1.
+	layout := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	seen := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		id := NewID()
+		if !layout.MatchString(id) {
+			t.Fatalf("%q isn't a version 4 UUID", id)
+		}
+		if seen[id] {
+			t.Fatalf("%q returned twice", id)
+		}
+		seen[id] = true
+	}
+	if id := New(nil).ID; !layout.MatchString(id) {
+		t.Errorf("session ID %q isn't a version 4 UUID", id)
+	}
+}
+
+func TestContextEnds(t *testing.T) {
+	for _, end := range []string{"shutdown", "closed", "canceled", "canceled without notifications"} {
+		shutdown, closed := make(chan struct{}), make(chan struct{})
+		sess := New(nil)
+		// Sessions of servers not notifying them leave the channels nil.
+		if end != "canceled without notifications" {
+			sess.Shutdown, sess.Closed = shutdown, closed
+		}
+		ctx, cancel := sess.Context(context.Background())
+		select {
+		case <-ctx.Done():
+			t.Fatalf("%v: context ended early", end)
+		case <-time.After(10 * time.Millisecond):
+		}
+		switch end {
+		case "shutdown":
+			close(shutdown)
+		case "closed":
+			close(closed)
+		default:
+			cancel()
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+			t.Errorf("%v: context not ended", end)
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,218 @@
+// Package shadow relays session channels to a real backend host while logging
+// everything that goes through them.
+//
+// This effectively records a man-in-the-middle of the client and a host it
+// really controls, so it is only enabled with an explicit backend that is also
+// allowlisted, and the number and duration of relayed sessions are capped.
+package shadow
+
+import (
+	"fmt"
+	"github.com/longkeyy/sshesame/config"
+	"github.com/longkeyy/sshesame/outbound"
+	"github.com/longkeyy/sshesame/session"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// dialTimeout bounds how long connecting to the backend may take.
+const dialTimeout = 10 * time.Second
+
+var (
+	mu     sync.Mutex
+	active int
+)
+
+// Validate checks that cfg enables shadow mode safely.
+func Validate(cfg config.Shadow) error {
+	if cfg.Backend == "" {
+		return nil
+	}
+	allowed := false
+	for _, backend := range cfg.AllowedBackends {
+		allowed = allowed || backend == cfg.Backend
+	}
+	if !allowed {
+		return fmt.Errorf("backend %v isn't allowlisted", cfg.Backend)
+	}
+	if cfg.BackendFingerprint == "" {
+		return fmt.Errorf("the host key fingerprint of backend %v is required", cfg.Backend)
+	}
+	if cfg.MaxSessions <= 0 {
+		return fmt.Errorf("the maximum number of shadowed sessions must be positive")
+	}
+	return nil
+}
+
+// Backend is a connection to the backend host for a single session channel.
+type Backend struct {
+	client *ssh.Client
+	cfg    config.Shadow
+}
+
+// Dial connects to the backend, unless the maximum number of sessions are
+// already shadowed. The backend must be closed once done with.
+func Dial(cfg config.Shadow) (*Backend, error) {
+	mu.Lock()
+	if active >= cfg.MaxSessions {
+		mu.Unlock()
+		return nil, fmt.Errorf("%v sessions are already shadowed", active)
+	}
+	active++
+	mu.Unlock()
+	conn, err := outbound.Dial("tcp", cfg.Backend, dialTimeout)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	sshConn, channels, requests, err := ssh.NewClientConn(conn, cfg.Backend, &ssh.ClientConfig{
+		User: cfg.User,
+		Auth: []ssh.AuthMethod{ssh.Password(cfg.Password)},
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if fingerprint := ssh.FingerprintSHA256(key); fingerprint != cfg.BackendFingerprint {
+				return fmt.Errorf("backend host key %v doesn't match %v", fingerprint, cfg.BackendFingerprint)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		conn.Close()
+		release()
+		return nil, err
+	}
+	return &Backend{ssh.NewClient(sshConn, channels, requests), cfg}, nil
+}
+
+func release() {
+	mu.Lock()
+	defer mu.Unlock()
+	active--
+}
+
+// Close disconnects from the backend.
+func (backend *Backend) Close() error {
+	defer release()
+	return backend.client.Close()
+}
+
+// dataLogger logs the data copied in one direction of a relayed channel.
+type dataLogger struct {
+	session *session.Session
+	message string
+	stream  string
+}
+
+func (logger dataLogger) Write(data []byte) (int, error) {
+	log.WithFields(log.Fields{
+		"client":  logger.session.RemoteAddr,
+		"channel": "session",
+		"stream":  logger.stream,
+		"data":    string(data),
+	}).Info(logger.message)
+	return len(data), nil
+}
+
+// Relay relays the session channel to a new session channel on the backend
+// until either end closes it or the maximum duration elapses.
+func (backend *Backend) Relay(sess *session.Session, channel ssh.Channel, requests <-chan *ssh.Request) error {
+	backendChannel, backendRequests, err := backend.client.OpenChannel("session", nil)
+	if err != nil {
+		return err
+	}
+	defer backendChannel.Close()
+	log.WithFields(log.Fields{
+		"client":  sess.RemoteAddr,
+		"channel": "session",
+		"backend": backend.cfg.Backend,
+	}).Warning("Session shadowed to backend")
+	timer := time.AfterFunc(backend.cfg.MaxDuration, func() {
+		log.WithFields(log.Fields{
+			"client":       sess.RemoteAddr,
+			"channel":      "session",
+			"max_duration": backend.cfg.MaxDuration.String(),
+		}).Info("Shadowed session timed out")
+		backendChannel.Close()
+		channel.Close()
+	})
+	defer timer.Stop()
+
+	go func() {
+		for request := range requests {
+			accepted, err := backendChannel.SendRequest(request.Type, request.WantReply, request.Payload)
+			if err != nil {
+				log.Warning("Failed to relay request to backend:", err.Error())
+			}
+			fields := log.Fields{
+				"client":   sess.RemoteAddr,
+				"channel":  "session",
+				"request":  request.Type,
+				"payload":  string(request.Payload),
+				"accepted": accepted,
+			}
+			exec := struct{ Command string }{}
+			if request.Type == "exec" && ssh.Unmarshal(request.Payload, &exec) == nil {
+				fields["command"] = exec.Command
+			}
+			log.WithFields(fields).Info("Request relayed to backend")
+			if accepted {
+				sess.ObserveRequest(request.Type)
+			}
+			if request.WantReply {
+				if err := request.Reply(accepted, nil); err != nil {
+					log.Warning("Failed to reply to request:", err.Error())
+				}
+			}
+		}
+	}()
+	go func() {
+		_, err := io.Copy(io.MultiWriter(backendChannel, dataLogger{sess, "Shadow input received", "stdin"}), channel)
+		if err != nil {
+			log.Warning("Failed to relay input to backend:", err.Error())
+		}
+		backendChannel.CloseWrite()
+	}()
+
+	var output sync.WaitGroup
+	output.Add(2)
+	go func() {
+		defer output.Done()
+		if _, err := io.Copy(io.MultiWriter(channel, dataLogger{sess, "Shadow output received", "stdout"}), backendChannel); err != nil {
+			log.Warning("Failed to relay output from backend:", err.Error())
+		}
+	}()
+	go func() {
+		defer output.Done()
+		if _, err := io.Copy(io.MultiWriter(channel.Stderr(), dataLogger{sess, "Shadow output received", "stderr"}), backendChannel.Stderr()); err != nil {
+			log.Warning("Failed to relay output from backend:", err.Error())
+		}
+	}()
+	drained := make(chan struct{})
+	go func() {
+		output.Wait()
+		close(drained)
+	}()
+	// The backend closes its requests along with the channel. Exit statuses
+	// and signals are held back until all output is relayed, as clients
+	// stop reading once they get them.
+	for request := range backendRequests {
+		if request.Type == "exit-status" || request.Type == "exit-signal" {
+			<-drained
+		}
+		if _, err := channel.SendRequest(request.Type, false, request.Payload); err != nil {
+			log.Warning("Failed to relay request to client:", err.Error())
+		}
+		if request.WantReply {
+			request.Reply(false, nil)
+		}
+	}
+	<-drained
+	log.WithFields(log.Fields{
+		"client":  sess.RemoteAddr,
+		"channel": "session",
+	}).Info("Shadowed session closed")
+	return nil
+}
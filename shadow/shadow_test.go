@@ -0,0 +1,293 @@
+package shadow_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"github.com/longkeyy/sshesame/config"
+	"github.com/longkeyy/sshesame/honeypot"
+	"github.com/longkeyy/sshesame/shadow"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newSigner returns a new ed25519 key.
+func newSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromSigner(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// stubBackend serves sessions as root:secret on a new loopback listener until
+// the end of the test, returning the shadow configuration relaying to it. Its
+// only commands are cat, which echoes its input, writes done to stderr and
+// exits with status 3, and sleep, which never exits.
+func stubBackend(t *testing.T) config.Shadow {
+	t.Helper()
+	signer := newSigner(t)
+	sshConfig := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() != "root" || string(password) != "secret" {
+				return nil, io.EOF
+			}
+			return nil, nil
+		},
+	}
+	sshConfig.AddHostKey(signer)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveBackend(conn, sshConfig)
+		}
+	}()
+	return config.Shadow{
+		Backend:            listener.Addr().String(),
+		AllowedBackends:    []string{listener.Addr().String()},
+		BackendFingerprint: ssh.FingerprintSHA256(signer.PublicKey()),
+		User:               "root",
+		Password:           "secret",
+		MaxSessions:        1,
+		MaxDuration:        time.Minute,
+	}
+}
+
+func serveBackend(conn net.Conn, sshConfig *ssh.ServerConfig) {
+	defer conn.Close()
+	_, channels, requests, err := ssh.NewServerConn(conn, sshConfig)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for request := range channelRequests {
+				exec := struct{ Command string }{}
+				accepted := request.Type == "exec" && ssh.Unmarshal(request.Payload, &exec) == nil
+				request.Reply(accepted, nil)
+				if accepted && exec.Command == "cat" {
+					go func() {
+						io.Copy(channel, channel)
+						io.WriteString(channel.Stderr(), "done\n")
+						channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{3}))
+						channel.Close()
+					}()
+				}
+			}
+		}()
+	}
+}
+
+// captureLog records the entries logged for the rest of the test instead
+// of printing them.
+func captureLog(t *testing.T) *logtest.Hook {
+	hook := logtest.NewGlobal()
+	out := log.StandardLogger().Out
+	log.SetOutput(ioutil.Discard)
+	t.Cleanup(func() {
+		log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+		log.SetOutput(out)
+	})
+	return hook
+}
+
+// waitFor returns the first entry logged with message, waiting for the
+// server to log it.
+func waitFor(t *testing.T, hook *logtest.Hook, message string) *log.Entry {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		for _, entry := range hook.AllEntries() {
+			if entry.Message == message {
+				return entry
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%q wasn't logged", message)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// dial logs in as root to a new honeypot shadowing sessions as configured by
+// shadowConfig, closing the connection at the end of the test and waiting for
+// the honeypot to be done with it.
+func dial(t *testing.T, shadowConfig config.Shadow) *ssh.Client {
+	t.Helper()
+	cfg := &config.Config{Auth: config.Auth{Methods: config.Methods{"password"}}, Shadow: shadowConfig}
+	sshConfig := &ssh.ServerConfig{ServerVersion: "SSH-2.0-OpenSSH_8.9p1"}
+	sshConfig.AddHostKey(newSigner(t))
+	server := honeypot.NewServer(cfg, sshConfig)
+	clientEnd, serverEnd := honeypot.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.HandleConn(serverEnd)
+	}()
+	t.Cleanup(func() {
+		clientEnd.Close()
+		<-done
+	})
+	conn, channels, requests, err := ssh.NewClientConn(clientEnd, serverEnd.LocalAddr().String(), &ssh.ClientConfig{
+		User:            "root",
+		Auth:            []ssh.AuthMethod{ssh.Password("password")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ssh.NewClient(conn, channels, requests)
+}
+
+// run runs command in a new session of client with input, returning its
+// output.
+func run(t *testing.T, client *ssh.Client, command, input string) (string, string, error) {
+	t.Helper()
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	var stdout, stderr bytes.Buffer
+	session.Stdin = strings.NewReader(input)
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	err = session.Run(command)
+	return stdout.String(), stderr.String(), err
+}
+
+func TestValidate(t *testing.T) {
+	valid := config.Shadow{
+		Backend:            "192.0.2.1:22",
+		AllowedBackends:    []string{"192.0.2.1:22"},
+		BackendFingerprint: "SHA256:abc",
+		MaxSessions:        1,
+	}
+	for _, test := range []struct {
+		name   string
+		change func(cfg *config.Shadow)
+		valid  bool
+	}{
+		{"valid", func(cfg *config.Shadow) {}, true},
+		{"disabled", func(cfg *config.Shadow) { *cfg = config.Shadow{} }, true},
+		{"not allowlisted", func(cfg *config.Shadow) { cfg.AllowedBackends = []string{"192.0.2.2:22"} }, false},
+		{"no fingerprint", func(cfg *config.Shadow) { cfg.BackendFingerprint = "" }, false},
+		{"no sessions", func(cfg *config.Shadow) { cfg.MaxSessions = 0 }, false},
+	} {
+		cfg := valid
+		test.change(&cfg)
+		if err := shadow.Validate(cfg); (err == nil) != test.valid {
+			t.Errorf("%v: Validate = %v, want valid %v", test.name, err, test.valid)
+		}
+	}
+}
+
+func TestSessionRelayed(t *testing.T) {
+	hook := captureLog(t)
+	client := dial(t, stubBackend(t))
+	stdout, stderr, err := run(t, client, "cat", "hello\n")
+	if exit, ok := err.(*ssh.ExitError); !ok || exit.ExitStatus() != 3 {
+		t.Errorf("cat = %v, want the backend's exit status 3", err)
+	}
+	if stdout != "hello\n" || stderr != "done\n" {
+		t.Errorf("cat wrote %q and %q to stderr, want the backend's output", stdout, stderr)
+	}
+
+	waitFor(t, hook, "Shadowed session closed")
+	if entry := waitFor(t, hook, "Request relayed to backend"); entry.Data["command"] != "cat" || entry.Data["accepted"] != true {
+		t.Errorf("relayed request logged with %v, want cat accepted", entry.Data)
+	}
+	logged := map[string]string{}
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Shadow input received" || entry.Message == "Shadow output received" {
+			logged[entry.Data["stream"].(string)] += entry.Data["data"].(string)
+		}
+	}
+	for stream, data := range map[string]string{"stdin": "hello\n", "stdout": "hello\n", "stderr": "done\n"} {
+		if logged[stream] != data {
+			t.Errorf("logged %q on %v, want %q", logged[stream], stream, data)
+		}
+	}
+}
+
+func TestSessionTimedOut(t *testing.T) {
+	hook := captureLog(t)
+	backend := stubBackend(t)
+	backend.MaxDuration = 100 * time.Millisecond
+	client := dial(t, backend)
+	if _, _, err := run(t, client, "sleep", ""); err == nil {
+		t.Error("sleep exited successfully after the maximum duration")
+	}
+	if entry := waitFor(t, hook, "Shadowed session timed out"); entry.Data["max_duration"] != "100ms" {
+		t.Errorf("timeout logged with %v", entry.Data)
+	}
+	waitFor(t, hook, "Shadowed session closed")
+}
+
+func TestSessionEmulatedWithoutBackend(t *testing.T) {
+	hook := captureLog(t)
+	for _, test := range []struct {
+		name   string
+		change func(cfg *config.Shadow)
+	}{
+		{"wrong fingerprint", func(cfg *config.Shadow) { cfg.BackendFingerprint = "SHA256:abc" }},
+		{"wrong password", func(cfg *config.Shadow) { cfg.Password = "wrong" }},
+		{"backend down", func(cfg *config.Shadow) { cfg.Backend = "127.0.0.1:1" }},
+	} {
+		hook.Reset()
+		backend := stubBackend(t)
+		test.change(&backend)
+		client := dial(t, backend)
+		if stdout, _, err := run(t, client, "echo hi", ""); err != nil || stdout != "hi\n" {
+			t.Errorf("%v: echo hi = %q, %v, want it emulated", test.name, stdout, err)
+		}
+		for _, entry := range hook.AllEntries() {
+			if entry.Message == "Session shadowed to backend" {
+				t.Errorf("%v: session shadowed", test.name)
+			}
+		}
+	}
+}
+
+func TestMaxSessions(t *testing.T) {
+	cfg := stubBackend(t)
+	first, err := shadow.Dial(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second, err := shadow.Dial(cfg); err == nil {
+		second.Close()
+		t.Errorf("shadowed %v sessions at once, want at most %v", 2, cfg.MaxSessions)
+	}
+	first.Close()
+	again, err := shadow.Dial(cfg)
+	if err != nil {
+		t.Fatalf("Dial after closing = %v, want the session released", err)
+	}
+	again.Close()
+}
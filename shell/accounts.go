@@ -0,0 +1,223 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"github.com/longkeyy/sshesame/session"
+	log "github.com/sirupsen/logrus"
+	"math/rand"
+	"time"
+)
+
+// errNoInput is returned by prompts of commands run without any input, like
+// those of exec requests.
+var errNoInput = errors.New("no input")
+
+// addAccounts adds the accounts the client created to system, and updates
+// those whose password it changed.
+func (system *System) addAccounts(sess *session.Session) {
+	random := rand.New(rand.NewSource(sess.Seed))
+	today := int(time.Now().Unix() / 86400)
+	for _, account := range sess.Accounts() {
+		if system.LookupUser(account.Name) != nil {
+			continue
+		}
+		uid := 1000
+		for _, user := range system.Users {
+			if user.UID >= uid && user.UID < 65534 {
+				uid = user.UID + 1
+			}
+		}
+		system.Users = append(system.Users, User{account.Name, uid, uid, "/home/" + account.Name, account.Shell, "!", today})
+	}
+	for i := range system.Users {
+		if _, ok := sess.Password(system.Users[i].Name); ok {
+			system.Users[i].Hash = passwordHash(random)
+			system.Users[i].Changed = today
+		}
+	}
+}
+
+// prompt asks the client for a line, after writing the output of the process
+// so far. The line is only echoed if echo is set.
+func (process *process) prompt(text string, echo bool) (string, error) {
+	if process.shell.prompt == nil {
+		return "", errNoInput
+	}
+	output := append(process.stdout.Bytes(), process.stderr.Bytes()...)
+	process.stdout.Reset()
+	process.stderr.Reset()
+	return process.shell.prompt(output, text, echo)
+}
+
+// isRoot reports whether the client is logged in as root.
+func (process *process) isRoot() bool {
+	user := process.shell.system.LookupUser(process.shell.session.User)
+	return user != nil && user.UID == 0
+}
+
+// logPersistence logs an attempt to keep access to the host.
+func (process *process) logPersistence(fields log.Fields, message string) {
+	fields["client"] = process.shell.session.RemoteAddr
+	fields["channel"] = "session"
+	fields["category"] = "persistence_attempt"
+	log.WithFields(fields).Warning(message)
+}
+
+// newPassword asks for a new password twice like passwd, recording it for
+// user if both match. It reports whether the password was changed.
+func (process *process) newPassword(user string) bool {
+	password, err := process.prompt("New password: ", false)
+	if err != nil {
+		process.stderr.WriteString("passwd: Authentication token manipulation error\npasswd: password unchanged\n")
+		return false
+	}
+	retyped, err := process.prompt("Retype new password: ", false)
+	if err != nil {
+		process.stderr.WriteString("passwd: Authentication token manipulation error\npasswd: password unchanged\n")
+		return false
+	}
+	process.logPersistence(log.Fields{
+		"user":     user,
+		"password": password,
+		"retyped":  retyped,
+	}, "Password change attempted")
+	switch {
+	case password != retyped:
+		process.stderr.WriteString("Sorry, passwords do not match.\n")
+	case password == "":
+		process.stderr.WriteString("No password has been supplied.\n")
+	default:
+		process.shell.session.SetPassword(user, password)
+		process.shell.system.addAccounts(process.shell.session)
+		process.stdout.WriteString("passwd: password updated successfully\n")
+		return true
+	}
+	process.stderr.WriteString("passwd: Authentication token manipulation error\npasswd: password unchanged\n")
+	return false
+}
+
+func passwd_(process *process) int {
+	self := process.shell.session.User
+	user := self
+	if len(process.args) > 1 {
+		user = process.args[len(process.args)-1]
+	}
+	if process.shell.system.LookupUser(user) == nil {
+		fmt.Fprintf(&process.stderr, "passwd: user '%v' does not exist\n", user)
+		return 1
+	}
+	if !process.isRoot() {
+		if user != self {
+			fmt.Fprintf(&process.stderr, "passwd: You may not view or modify password information for %v.\n", user)
+			return 1
+		}
+		fmt.Fprintf(&process.stdout, "Changing password for %v.\n", user)
+		current, err := process.prompt("Current password: ", false)
+		if err != nil {
+			process.stderr.WriteString("passwd: Authentication token manipulation error\npasswd: password unchanged\n")
+			return 10
+		}
+		process.logPersistence(log.Fields{
+			"user":     user,
+			"password": current,
+		}, "Current password entered")
+	}
+	if !process.newPassword(user) {
+		return 10
+	}
+	return 0
+}
+
+// useraddValueOptions are the options of useradd taking a value.
+var useraddValueOptions = map[string]bool{
+	"-c": true, "-d": true, "-e": true, "-f": true, "-g": true, "-G": true,
+	"-k": true, "-K": true, "-p": true, "-s": true, "-u": true,
+}
+
+func useradd(process *process) int {
+	name, shell, hash := "", "/bin/sh", ""
+	args := process.args[1:]
+	for i := 0; i < len(args); i++ {
+		if !useraddValueOptions[args[i]] {
+			if len(args[i]) == 0 || args[i][0] != '-' {
+				name = args[i]
+			}
+			continue
+		}
+		if i+1 == len(args) {
+			fmt.Fprintf(&process.stderr, "useradd: option requires an argument -- '%v'\n", args[i][1:])
+			return 2
+		}
+		switch args[i] {
+		case "-s":
+			shell = args[i+1]
+		case "-p":
+			hash = args[i+1]
+		}
+		i++
+	}
+	if name == "" {
+		process.stderr.WriteString("Usage: useradd [options] LOGIN\n")
+		return 2
+	}
+	if !process.isRoot() {
+		process.stderr.WriteString("useradd: Permission denied.\nuseradd: cannot lock /etc/passwd; try again later.\n")
+		return 1
+	}
+	if process.shell.system.LookupUser(name) != nil || !process.shell.session.AddAccount(session.Account{Name: name, Shell: shell}) {
+		fmt.Fprintf(&process.stderr, "useradd: user '%v' already exists\n", name)
+		return 9
+	}
+	fields := log.Fields{"user": name, "shell": shell}
+	if hash != "" {
+		fields["password_hash"] = hash
+	}
+	process.logPersistence(fields, "User created")
+	process.shell.system.addAccounts(process.shell.session)
+	return 0
+}
+
+// adduserFields are the user information adduser asks for after the password.
+var adduserFields = []string{"Full Name", "Room Number", "Work Phone", "Home Phone", "Other"}
+
+func adduser(process *process) int {
+	name := ""
+	for _, arg := range process.args[1:] {
+		if len(arg) > 0 && arg[0] != '-' {
+			name = arg
+		}
+	}
+	if name == "" {
+		process.stderr.WriteString("adduser: Only one or two names allowed.\n")
+		return 1
+	}
+	if !process.isRoot() {
+		process.stderr.WriteString("adduser: Only root may add a user or group to the system.\n")
+		return 1
+	}
+	if process.shell.system.LookupUser(name) != nil || !process.shell.session.AddAccount(session.Account{Name: name, Shell: "/bin/bash"}) {
+		fmt.Fprintf(&process.stderr, "adduser: The user `%v' already exists.\n", name)
+		return 1
+	}
+	process.logPersistence(log.Fields{"user": name, "shell": "/bin/bash"}, "User created")
+	process.shell.system.addAccounts(process.shell.session)
+	uid := process.shell.system.LookupUser(name).UID
+	fmt.Fprintf(&process.stdout, "Adding user `%v' ...\n", name)
+	fmt.Fprintf(&process.stdout, "Adding new group `%v' (%v) ...\n", name, uid)
+	fmt.Fprintf(&process.stdout, "Adding new user `%v' (%v) with group `%v' ...\n", name, uid, name)
+	fmt.Fprintf(&process.stdout, "Creating home directory `/home/%v' ...\n", name)
+	process.stdout.WriteString("Copying files from `/etc/skel' ...\n")
+	process.newPassword(name)
+	fmt.Fprintf(&process.stdout, "Changing the user information for %v\n", name)
+	process.stdout.WriteString("Enter the new value, or press ENTER for the default\n")
+	for _, field := range adduserFields {
+		if _, err := process.prompt("\t"+field+" []: ", true); err != nil {
+			return 1
+		}
+	}
+	if _, err := process.prompt("Is the information correct? [Y/n] ", true); err != nil {
+		return 1
+	}
+	return 0
+}
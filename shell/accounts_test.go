@@ -0,0 +1,182 @@
+package shell
+
+import (
+	"github.com/longkeyy/sshesame/config"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"strings"
+	"testing"
+)
+
+// answer makes shell answer the prompts of commands with answers, in order,
+// returning what the client sees of them: the output written before each
+// prompt, the prompt and the answer if echoed.
+func answer(shell *shell, answers ...string) *strings.Builder {
+	var transcript strings.Builder
+	shell.prompt = func(output []byte, text string, echo bool) (string, error) {
+		transcript.Write(output)
+		transcript.WriteString(text)
+		if len(answers) == 0 {
+			return "", errNoInput
+		}
+		line := answers[0]
+		answers = answers[1:]
+		if echo {
+			transcript.WriteString(line)
+		}
+		transcript.WriteString("\n")
+		return line, nil
+	}
+	return &transcript
+}
+
+// lastEntry returns the last entry logged with message, or nil.
+func lastEntry(hook *logtest.Hook, message string) *log.Entry {
+	entries := hook.AllEntries()
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Message == message {
+			return entries[i]
+		}
+	}
+	return nil
+}
+
+func TestPasswd(t *testing.T) {
+	hook := captureLog(t)
+	sess := newTestSession("root")
+	shell := newTestShell(sess, config.Shell{})
+	before := passwdEntry(t, output(t, shell, "cat /etc/shadow"), "root")
+	transcript := answer(shell, "hunter2", "hunter2")
+	if stdout := output(t, shell, "passwd"); stdout != "passwd: password updated successfully\n" {
+		t.Errorf("passwd = %q", stdout)
+	}
+	if transcript.String() != "New password: \nRetype new password: \n" {
+		t.Errorf("passwd prompted %q", transcript.String())
+	}
+	entry := lastEntry(hook, "Password change attempted")
+	if entry == nil || entry.Data["category"] != "persistence_attempt" || entry.Data["user"] != "root" || entry.Data["password"] != "hunter2" {
+		t.Errorf("logged %v, want the new password", entry)
+	}
+	if password, ok := sess.Password("root"); !ok || password != "hunter2" {
+		t.Errorf("session password = %q, %v", password, ok)
+	}
+	// The hash changes right away, and stays changed for the session.
+	after := passwdEntry(t, output(t, shell, "cat /etc/shadow"), "root")
+	if after[1] == before[1] || after[2] == before[2] {
+		t.Errorf("shadow entry %q after passwd, was %q", after, before)
+	}
+	if again := passwdEntry(t, output(t, newTestShell(sess, config.Shell{}), "cat /etc/shadow"), "root"); strings.Join(again, ":") != strings.Join(after, ":") {
+		t.Errorf("shadow entry %q in a new shell, want %q", again, after)
+	}
+}
+
+func TestPasswdUnchanged(t *testing.T) {
+	hook := captureLog(t)
+	for _, test := range []struct {
+		name    string
+		answers []string
+		stderr  string
+	}{
+		{"mismatch", []string{"hunter2", "hunter3"}, "Sorry, passwords do not match.\n"},
+		{"empty", []string{"", ""}, "No password has been supplied.\n"},
+		{"no input", nil, ""},
+	} {
+		hook.Reset()
+		sess := newTestSession("root")
+		shell := newTestShell(sess, config.Shell{})
+		if test.answers != nil {
+			answer(shell, test.answers...)
+		}
+		_, stderr, status := runScript(t, shell, "passwd")
+		if status != 10 || !strings.HasPrefix(stderr, test.stderr) || !strings.HasSuffix(stderr, "passwd: password unchanged\n") {
+			t.Errorf("%v: passwd = %q, %v", test.name, stderr, status)
+		}
+		if _, ok := sess.Password("root"); ok {
+			t.Errorf("%v: password changed", test.name)
+		}
+	}
+}
+
+func TestPasswdUser(t *testing.T) {
+	hook := captureLog(t)
+	shell := newTestShell(newTestSession("admin"), config.Shell{})
+	if _, stderr, status := runScript(t, shell, "passwd root"); status != 1 || stderr != "passwd: You may not view or modify password information for root.\n" {
+		t.Errorf("passwd root as admin = %q, %v", stderr, status)
+	}
+	transcript := answer(shell, "admin123", "s3cret", "s3cret")
+	if stdout := output(t, shell, "passwd"); stdout != "passwd: password updated successfully\n" {
+		t.Errorf("passwd = %q", stdout)
+	}
+	if transcript.String() != "Changing password for admin.\nCurrent password: \nNew password: \nRetype new password: \n" {
+		t.Errorf("passwd prompted %q", transcript.String())
+	}
+	logged := map[string]interface{}{}
+	for _, entry := range hook.AllEntries() {
+		logged[entry.Message] = entry.Data["password"]
+	}
+	if logged["Current password entered"] != "admin123" || logged["Password change attempted"] != "s3cret" {
+		t.Errorf("logged passwords %v", logged)
+	}
+}
+
+func TestUseradd(t *testing.T) {
+	hook := captureLog(t)
+	sess := newTestSession("root")
+	shell := newTestShell(sess, config.Shell{})
+	if stdout := output(t, shell, "useradd -m -s /bin/bash -p '$1$x$y' backdoor"); stdout != "" {
+		t.Errorf("useradd = %q, want no output", stdout)
+	}
+	entry := lastEntry(hook, "User created")
+	if entry == nil || entry.Data["category"] != "persistence_attempt" || entry.Data["user"] != "backdoor" || entry.Data["shell"] != "/bin/bash" || entry.Data["password_hash"] != "$1$x$y" {
+		t.Errorf("logged %v, want the user created", entry)
+	}
+	// The user stays for the session.
+	for _, passwd := range []string{output(t, shell, "cat /etc/passwd"), output(t, newTestShell(sess, config.Shell{}), "cat /etc/passwd")} {
+		user := passwdEntry(t, passwd, "backdoor")
+		if len(user) != 7 || user[5] != "/home/backdoor" || user[6] != "/bin/bash" {
+			t.Errorf("passwd entry %q, want the new user", user)
+		}
+	}
+	if _, stderr, status := runScript(t, shell, "useradd backdoor"); status != 9 || stderr != "useradd: user 'backdoor' already exists\n" {
+		t.Errorf("useradd again = %q, %v", stderr, status)
+	}
+	if _, stderr, status := runScript(t, newTestShell(newTestSession("admin"), config.Shell{}), "useradd eve"); status != 1 || !strings.HasPrefix(stderr, "useradd: Permission denied.") {
+		t.Errorf("useradd as admin = %q, %v", stderr, status)
+	}
+}
+
+func TestAdduser(t *testing.T) {
+	hook := captureLog(t)
+	sess := newTestSession("root")
+	shell := newTestShell(sess, config.Shell{})
+	transcript := answer(shell, "hunter2", "hunter2", "Eve", "", "", "", "", "Y")
+	output(t, shell, "adduser eve")
+	if want := "Adding user `eve' ...\n" +
+		"Adding new group `eve' (1001) ...\n" +
+		"Adding new user `eve' (1001) with group `eve' ...\n" +
+		"Creating home directory `/home/eve' ...\n" +
+		"Copying files from `/etc/skel' ...\n" +
+		"New password: \nRetype new password: \n" +
+		"passwd: password updated successfully\n" +
+		"Changing the user information for eve\n" +
+		"Enter the new value, or press ENTER for the default\n" +
+		"\tFull Name []: Eve\n\tRoom Number []: \n\tWork Phone []: \n\tHome Phone []: \n\tOther []: \n" +
+		"Is the information correct? [Y/n] Y\n"; transcript.String() != want {
+		t.Errorf("adduser prompted %q, want %q", transcript.String(), want)
+	}
+	logged := map[string]bool{}
+	for _, entry := range hook.AllEntries() {
+		if entry.Data["category"] == "persistence_attempt" && entry.Data["user"] == "eve" {
+			logged[entry.Message] = true
+		}
+	}
+	if !logged["User created"] || !logged["Password change attempted"] {
+		t.Errorf("logged %v, want the user and its password", logged)
+	}
+	if user := passwdEntry(t, output(t, shell, "cat /etc/passwd"), "eve"); len(user) != 7 || user[6] != "/bin/bash" {
+		t.Errorf("passwd entry %q, want the new user", user)
+	}
+	if password, ok := sess.Password("eve"); !ok || password != "hunter2" {
+		t.Errorf("session password of eve = %q, %v", password, ok)
+	}
+}
@@ -0,0 +1,80 @@
+package shell
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"strings"
+)
+
+// keyBits returns the size of key and the name ssh-add -l shows for its type.
+func keyBits(key ssh.PublicKey) (int, string) {
+	cryptoKey, ok := key.(ssh.CryptoPublicKey)
+	if !ok {
+		return 0, strings.ToUpper(key.Type())
+	}
+	switch publicKey := cryptoKey.CryptoPublicKey().(type) {
+	case *rsa.PublicKey:
+		return publicKey.N.BitLen(), "RSA"
+	case *ecdsa.PublicKey:
+		return publicKey.Curve.Params().BitSize, "ECDSA"
+	}
+	return 256, "ED25519"
+}
+
+// sshAdd lists the keys of the fake agent of clients that forwarded theirs,
+// with -l or -L, which is how intruders find agents to move on with.
+func sshAdd(process *process) int {
+	fakeAgent := process.shell.session.Agent()
+	if fakeAgent == nil || process.shell.environment()["SSH_AUTH_SOCK"] == "" {
+		process.stderr.WriteString("Could not open a connection to your authentication agent.\n")
+		return 2
+	}
+	options, operands := parseOptions(process.args[1:], "Et", nil, nil)
+	_, list := options["l"]
+	_, listPublic := options["L"]
+	_, removeAll := options["D"]
+	switch {
+	case list || listPublic:
+		keys, err := fakeAgent.List()
+		if err != nil || len(keys) == 0 {
+			process.stdout.WriteString("The agent has no identities.\n")
+			return 1
+		}
+		for _, key := range keys {
+			if listPublic {
+				fmt.Fprintf(&process.stdout, "%v %v\n", strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))), key.Comment)
+				continue
+			}
+			publicKey, err := ssh.ParsePublicKey(key.Blob)
+			if err != nil {
+				continue
+			}
+			bits, keyType := keyBits(publicKey)
+			fmt.Fprintf(&process.stdout, "%v %v %v (%v)\n", bits, ssh.FingerprintSHA256(key), key.Comment, keyType)
+		}
+		return 0
+	case removeAll:
+		if err := fakeAgent.RemoveAll(); err != nil {
+			process.stderr.WriteString("Failed to remove all identities.\n")
+			return 1
+		}
+		process.stderr.WriteString("All identities removed.\n")
+		return 0
+	}
+	status := 0
+	for _, name := range operands {
+		if _, ok := process.readFile(name); ok {
+			fmt.Fprintf(&process.stderr, "Error loading key \"%v\": invalid format\n", name)
+		} else {
+			fmt.Fprintf(&process.stderr, "%v: No such file or directory\n", name)
+		}
+		status = 1
+	}
+	if len(operands) == 0 {
+		// None of the default identities exist.
+		status = 1
+	}
+	return status
+}
@@ -0,0 +1,314 @@
+package shell
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// builtins are the emulated commands bash implements itself.
+var builtins = map[string]bool{
+	":":       true,
+	"cd":      true,
+	"compgen": true,
+	"echo":    true,
+	"exit":    true,
+	"false":   true,
+	"help":    true,
+	"history": true,
+	"logout":  true,
+	"true":    true,
+	"type":    true,
+}
+
+// keywords are bash's reserved words, which compgen lists as commands.
+var keywords = []string{"!", "[[", "]]", "case", "coproc", "do", "done", "elif", "else", "esac", "fi", "for", "function", "if", "in", "select", "then", "time", "until", "while", "{", "}"}
+
+// sbinCommands are the emulated commands installed in /usr/sbin rather than
+// /usr/bin.
+var sbinCommands = map[string]bool{
+	"adduser":  true,
+	"fdisk":    true,
+	"ifconfig": true,
+	"ip":       true,
+	"useradd":  true,
+}
+
+// detectionBuiltins are builtins commonly used to tell fake shells apart from
+// bash.
+var detectionBuiltins = map[string]bool{
+	"compgen": true,
+	"help":    true,
+	"type":    true,
+}
+
+// detectionVariables are variables commonly expanded to tell fake shells
+// apart from bash.
+var detectionVariables = map[string]bool{
+	"0":            true,
+	"BASH":         true,
+	"BASH_VERSION": true,
+	"SHELL":        true,
+}
+
+// variables returns the variables command lines are expanded with, the
+// environment along with bash's own variables and special parameters.
+func (shell *shell) variables() map[string]string {
+	variables := shell.environment()
+	name := "bash"
+	if shell.cfg.Restricted {
+		name = "rbash"
+	}
+	variables["0"] = name
+	if shell.interactive {
+		variables["0"] = "-" + name
+	}
+	variables["BASH"] = "/usr/bin/" + name
+	variables["BASH_VERSION"] = shell.system.Profile.BashVersion
+	variables["HOSTNAME"] = shell.system.Hostname
+	variables["HOSTTYPE"] = shell.system.Profile.Machine
+	variables["MACHTYPE"] = shell.system.Profile.Machine + "-pc-linux-gnu"
+	if shell.system.Profile.arm() {
+		variables["MACHTYPE"] = shell.system.Profile.Machine + "-unknown-linux-gnu"
+	}
+	variables["OSTYPE"] = "linux-gnu"
+	uid := "0"
+	if user := shell.system.LookupUser(shell.session.User); user != nil {
+		uid = strconv.Itoa(user.UID)
+	}
+	variables["UID"] = uid
+	variables["EUID"] = uid
+	variables["PPID"] = strconv.Itoa(shell.system.ShellPID - 1)
+	variables["$"] = strconv.Itoa(shell.system.ShellPID)
+	variables["?"] = strconv.Itoa(shell.status)
+	variables["#"] = "0"
+	variables["-"] = "hBc"
+	if shell.interactive {
+		variables["-"] = "himBHs"
+	}
+	return variables
+}
+
+// expandsDetectionVariable reports whether line expands one of the
+// detectionVariables.
+func expandsDetectionVariable(line string) bool {
+	runes := []rune(line)
+	for i, r := range runes {
+		if r != '$' {
+			continue
+		}
+		if length := variableLength(runes[i+1:]); length > 0 && detectionVariables[strings.Trim(string(runes[i+1:i+1+length]), "{}")] {
+			return true
+		}
+	}
+	return false
+}
+
+// commandPath returns the path of the command called name, if it isn't a
+// builtin but is emulated or has a canned response.
+func (shell *shell) commandPath(name string) (string, bool) {
+	if builtins[name] {
+		return "", false
+	}
+	_, emulated := commands[name]
+	if !emulated && !shell.hasResponse(name) {
+		return "", false
+	}
+	if sbinCommands[name] {
+		return "/usr/sbin/" + name, true
+	}
+	return "/usr/bin/" + name, true
+}
+
+// hasResponse reports whether there is a canned response to the command
+// called name.
+func (shell *shell) hasResponse(name string) bool {
+	for _, response := range shell.cfg.Responses {
+		if response.Match == nil && response.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// commandNames returns the names of the commands that run, like compgen -c
+// lists them: keywords and builtins first, then the commands on the PATH.
+func (shell *shell) commandNames() []string {
+	names := append([]string{}, keywords...)
+	for name := range builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names[len(keywords):])
+	onPath := []string{}
+	for name := range commands {
+		if _, ok := shell.commandPath(name); ok {
+			onPath = append(onPath, name)
+		}
+	}
+	for _, response := range shell.cfg.Responses {
+		if _, emulated := commands[response.Name]; response.Match == nil && !emulated && !builtins[response.Name] {
+			onPath = append(onPath, response.Name)
+		}
+	}
+	sort.Strings(onPath)
+	return append(names, onPath...)
+}
+
+// cd checks that the directory given exists. Commands keep running in the
+// home directory.
+func cd(process *process) int {
+	args := []string{}
+	for _, arg := range process.args[1:] {
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			args = append(args, arg)
+		}
+	}
+	shell := process.shell
+	target := shell.home()
+	switch {
+	case len(args) > 1:
+		process.errorf("cd: too many arguments\n")
+		return 1
+	case len(args) == 1 && args[0] == "-":
+		if shell.oldDir == "" {
+			process.errorf("cd: OLDPWD not set\n")
+			return 1
+		}
+		target = shell.oldDir
+		fmt.Fprintln(&process.stdout, target)
+	case len(args) == 1:
+		target = args[0]
+	}
+	dir := shell.resolve(target)
+	if process.isDir(dir) {
+		shell.oldDir = shell.workingDir()
+		shell.dir = dir
+		return 0
+	}
+	if _, ok := process.stat(dir); ok {
+		process.errorf("cd: %v: Not a directory\n", target)
+	} else {
+		process.errorf("cd: %v: No such file or directory\n", target)
+	}
+	return 1
+}
+
+// pwd prints the working directory.
+func pwd(process *process) int {
+	fmt.Fprintln(&process.stdout, process.shell.workingDir())
+	return 0
+}
+
+// type_ tells how bash would interpret the names given.
+func type_(process *process) int {
+	status := 0
+	for _, name := range process.args[1:] {
+		if strings.HasPrefix(name, "-") {
+			continue
+		}
+		isKeyword := false
+		for _, keyword := range keywords {
+			isKeyword = isKeyword || keyword == name
+		}
+		path, onPath := process.shell.commandPath(name)
+		switch {
+		case isKeyword:
+			fmt.Fprintf(&process.stdout, "%v is a shell keyword\n", name)
+		case builtins[name]:
+			fmt.Fprintf(&process.stdout, "%v is a shell builtin\n", name)
+		case onPath:
+			fmt.Fprintf(&process.stdout, "%v is %v\n", name, path)
+		default:
+			process.errorf("type: %v: not found\n", name)
+			status = 1
+		}
+	}
+	return status
+}
+
+// compgen lists the commands, builtins or keywords starting with a word.
+func compgen(process *process) int {
+	var names []string
+	word := ""
+	for _, arg := range process.args[1:] {
+		switch arg {
+		case "-c":
+			names = append(names, process.shell.commandNames()...)
+		case "-b":
+			for name := range builtins {
+				names = append(names, name)
+			}
+			sort.Strings(names[len(names)-len(builtins):])
+		case "-k":
+			names = append(names, keywords...)
+		case "-a":
+			// There are no aliases.
+		default:
+			if strings.HasPrefix(arg, "-") {
+				process.errorf("compgen: %v: invalid option\n", arg)
+				fmt.Fprintln(&process.stderr, "compgen: usage: compgen [-abcdefgjksuv] [-o option] [-A action] [-G globpat] [-W wordlist] [-F function] [-C command] [-X filterpat] [-P prefix] [-S suffix] [word]")
+				return 2
+			}
+			word = arg
+		}
+	}
+	status := 1
+	for _, name := range names {
+		if strings.HasPrefix(name, word) {
+			fmt.Fprintln(&process.stdout, name)
+			status = 0
+		}
+	}
+	return status
+}
+
+// help lists the builtins of bash, or describes those given.
+func help(process *process) int {
+	version := process.shell.system.Profile.BashVersion
+	if len(process.args) == 1 {
+		fmt.Fprintf(&process.stdout, "GNU bash, version %v (%v-pc-linux-gnu)\n", version, process.shell.system.Profile.Machine)
+		fmt.Fprintln(&process.stdout, "These shell commands are defined internally.  Type `help' to see this list.")
+		fmt.Fprintln(&process.stdout, "Type `help name' to find out more about the function `name'.")
+		fmt.Fprintln(&process.stdout, "Use `info bash' to find out more about the shell in general.")
+		fmt.Fprintln(&process.stdout, "Use `man -k' or `info' to find out more about commands not in this list.")
+		fmt.Fprintln(&process.stdout)
+		fmt.Fprintln(&process.stdout, "A star (*) next to a name means that the command is disabled.")
+		fmt.Fprintln(&process.stdout)
+		for _, usage := range helpUsages {
+			fmt.Fprintf(&process.stdout, " %v\n", usage)
+		}
+		return 0
+	}
+	status := 0
+	for _, name := range process.args[1:] {
+		found := false
+		for _, usage := range helpUsages {
+			if usage == name || strings.HasPrefix(usage, name+" ") {
+				fmt.Fprintf(&process.stdout, "%v: %v\n", name, usage)
+				found = true
+				break
+			}
+		}
+		if !found {
+			process.errorf("help: no help topics match `%v'.  Try `help help' or `man -k %v' or `info %v'.\n", name, name, name)
+			status = 1
+		}
+	}
+	return status
+}
+
+// helpUsages are the usages of the builtins help describes.
+var helpUsages = []string{
+	": [arguments]",
+	"cd [-L|[-P [-e]] [-@]] [dir]",
+	"compgen [-abcdefgjksuv] [-o option] [-A action] [-G globpat] [-W wordlist] [-F function] [-C command] [-X filterpat] [-P prefix] [-S suffix] [word]",
+	"echo [-neE] [arg ...]",
+	"exit [n]",
+	"false",
+	"help [-dms] [pattern ...]",
+	"history [-c] [-d offset] [n] or history -anrw [filename] or history -ps arg [arg...]",
+	"logout [n]",
+	"true",
+	"type [-afptP] name [name ...]",
+}
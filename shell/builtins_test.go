@@ -0,0 +1,106 @@
+package shell
+
+import (
+	"github.com/longkeyy/sshesame/config"
+	"strings"
+	"testing"
+)
+
+func TestDetectionVariables(t *testing.T) {
+	captureLog(t)
+	for _, name := range []string{"ubuntu-22.04-amd64", "debian-12-arm64"} {
+		shell := newTestShell(newTestSession("root"), config.Shell{Profile: name})
+		for command, want := range map[string]string{
+			"echo $SHELL":          "/bin/bash\n",
+			"echo $0":              "bash\n",
+			"echo $BASH":           "/usr/bin/bash\n",
+			"echo $BASH_VERSION":   Profiles[name].BashVersion + "\n",
+			"echo ${BASH_VERSION}": Profiles[name].BashVersion + "\n",
+			"echo $HOSTTYPE":       Profiles[name].Machine + "\n",
+			"echo $UID $EUID":      "0 0\n",
+			"false; echo $?":       "1\n",
+			"true; echo $?":        "0\n",
+		} {
+			if got := output(t, shell, command); got != want {
+				t.Errorf("%v: %q = %q, want %q", name, command, got, want)
+			}
+		}
+	}
+	if got := output(t, newTestShell(newTestSession("root"), config.Shell{Restricted: true}), "echo $0"); got != "rbash\n" {
+		t.Errorf("echo $0 in a restricted shell = %q, want rbash", got)
+	}
+}
+
+func TestType(t *testing.T) {
+	captureLog(t)
+	shell := newTestShell(newTestSession("root"), config.Shell{})
+	stdout, stderr, status := runScript(t, shell, "type cd if ls ifconfig nmap")
+	if want := "cd is a shell builtin\nif is a shell keyword\nls is /usr/bin/ls\nifconfig is /usr/sbin/ifconfig\n"; stdout != want {
+		t.Errorf("type wrote\n%v\nwant\n%v", stdout, want)
+	}
+	if status != 1 || !strings.Contains(stderr, "type: nmap: not found\n") {
+		t.Errorf("type nmap = %v, %q, want it not found", status, stderr)
+	}
+	if usage := output(t, shell, "help cd"); !strings.HasPrefix(usage, "cd: cd [-L|[-P [-e]] [-@]] [dir]\n") {
+		t.Errorf("help cd = %q", usage)
+	}
+	if listing := output(t, shell, "help"); !strings.HasPrefix(listing, "GNU bash, version "+shell.system.Profile.BashVersion+" ") {
+		t.Errorf("help = %q, want the profile's bash", listing)
+	}
+}
+
+func TestCompgenListsWhatRuns(t *testing.T) {
+	captureLog(t)
+	cfg := config.Shell{Responses: config.Responses{{Name: "docker", Stdout: "Docker version 24.0.7\n"}}}
+	shell := newTestShell(newTestSession("root"), cfg)
+	listed := strings.Split(strings.TrimSuffix(output(t, shell, "compgen -c"), "\n"), "\n")
+	seen := map[string]bool{}
+	for _, name := range listed {
+		seen[name] = true
+	}
+	for _, name := range []string{"if", "cd", "echo", "ls", "uname", "docker"} {
+		if !seen[name] {
+			t.Errorf("compgen -c doesn't list %v", name)
+		}
+	}
+	for name := range commands {
+		if !seen[name] {
+			t.Errorf("compgen -c doesn't list the emulated %v", name)
+		}
+	}
+	// Everything listed runs, and nothing else does.
+	for _, name := range listed {
+		if _, stderr, status := runScript(t, shell, "type "+name); status != 0 {
+			t.Errorf("compgen -c lists %v, but type says %q", name, stderr)
+		}
+	}
+	if seen["nmap"] {
+		t.Error("compgen -c lists nmap, which isn't installed")
+	}
+	if _, _, status := runScript(t, shell, "nmap"); status != 127 {
+		t.Errorf("nmap exited with %v, want it not found", status)
+	}
+	if prefixed := output(t, shell, "compgen -c host"); prefixed != "hostname\nhostnamectl\n" {
+		t.Errorf("compgen -c host = %q", prefixed)
+	}
+}
+
+func TestDetectionBuiltinsLogged(t *testing.T) {
+	hook := captureLog(t)
+	for command, category := range map[string]interface{}{
+		"echo $SHELL":        "detection_attempt",
+		"echo $0":            "detection_attempt",
+		"echo $BASH_VERSION": "detection_attempt",
+		"type cd":            "detection_attempt",
+		"compgen -c":         "detection_attempt",
+		"help":               "detection_attempt",
+		"echo $HOME":         nil,
+		"cd /tmp":            nil,
+	} {
+		hook.Reset()
+		run(t, config.Shell{}, command)
+		if entry := lastEntry(hook, "Command executed"); entry == nil || entry.Data["category"] != category {
+			t.Errorf("%q logged %v, want category %v", command, entry, category)
+		}
+	}
+}
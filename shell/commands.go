@@ -0,0 +1,361 @@
+package shell
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type command func(process *process) int
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		":":           true_,
+		"adduser":     adduser,
+		"apt":         aptGet,
+		"apt-get":     aptGet,
+		"bash":        sh,
+		"cat":         cat,
+		"cd":          cd,
+		"chmod":       chmod,
+		"compgen":     compgen,
+		"curl":        curl,
+		"df":          df,
+		"dmesg":       dmesg,
+		"du":          du,
+		"echo":        echo,
+		"env":         env,
+		"exit":        exit,
+		"false":       false_,
+		"fdisk":       fdisk,
+		"free":        free,
+		"history":     history,
+		"grep":        grep,
+		"head":        head,
+		"help":        help,
+		"hostname":    hostname,
+		"hostnamectl": hostnamectl,
+		"id":          id,
+		"ifconfig":    ifconfig,
+		"ip":          ip,
+		"last":        last,
+		"lastlog":     lastlog,
+		"locale":      locale,
+		"logout":      exit,
+		"ls":          ls,
+		"lsblk":       lsblk,
+		"lscpu":       lscpu,
+		"mkdir":       mkdir,
+		"mount":       mount,
+		"netstat":     netstat,
+		"nproc":       nproc,
+		"npm":         npm,
+		"passwd":      passwd_,
+		"pip":         pip,
+		"pip3":        pip,
+		"printenv":    printenv,
+		"ps":          ps,
+		"pwd":         pwd,
+		"rm":          rm,
+		"sh":          sh,
+		"ss":          ss,
+		"ssh-add":     sshAdd,
+		"tail":        tail,
+		"tftp":        tftp,
+		"true":        true_,
+		"type":        type_,
+		"uname":       uname,
+		"uptime":      uptime,
+		"useradd":     useradd,
+		"w":           w,
+		"wc":          wc,
+		"wget":        wget,
+		"who":         who,
+		"whoami":      whoami,
+	}
+}
+
+// lowSignalCommands are commonly run by automation to check connectivity and
+// reveal little about intentions, so they are logged at a lower level.
+var lowSignalCommands = map[string]bool{
+	":":     true,
+	"echo":  true,
+	"false": true,
+	"true":  true,
+}
+
+// detectionCommands are commonly used to tell honeypots apart from real hosts
+// by cross-checking the hardware and environment they report.
+var detectionCommands = map[string]bool{
+	"dmesg":       true,
+	"free":        true,
+	"hostnamectl": true,
+	"locale":      true,
+	"lscpu":       true,
+	"nproc":       true,
+	"uname":       true,
+	"uptime":      true,
+}
+
+// diskCommands are commonly used to size up the storage of a host.
+var diskCommands = map[string]bool{
+	"df":    true,
+	"du":    true,
+	"fdisk": true,
+	"lsblk": true,
+	"mount": true,
+}
+
+// networkCommands are commonly used to map the network of a host to move
+// laterally from it.
+var networkCommands = map[string]bool{
+	"ifconfig": true,
+	"ip":       true,
+	"netstat":  true,
+	"ss":       true,
+}
+
+// fileCategories are the categories of reading notable files.
+var fileCategories = map[string]string{
+	"/proc/cpuinfo":      "detection_attempt",
+	"/proc/meminfo":      "detection_attempt",
+	"/proc/mounts":       "detection_attempt",
+	"/proc/version":      "detection_attempt",
+	"/proc/uptime":       "detection_attempt",
+	"/proc/loadavg":      "detection_attempt",
+	"/etc/os-release":    "detection_attempt",
+	"/etc/passwd":        "sensitive_file_access",
+	"/etc/shadow":        "sensitive_file_access",
+	"/etc/hosts":         "sensitive_file_access",
+	"/proc/self/environ": "sensitive_file_access",
+}
+
+// classify returns the category of a command line, or an empty string if it
+// isn't notable.
+func classify(args []string) string {
+	if detectionCommands[args[0]] || detectionBuiltins[args[0]] {
+		return "detection_attempt"
+	}
+	if networkCommands[args[0]] {
+		return "network_recon"
+	}
+	if diskCommands[args[0]] {
+		return "disk_recon"
+	}
+	if args[0] == "cat" {
+		for _, arg := range args[1:] {
+			if category, ok := fileCategories[arg]; ok {
+				return category
+			}
+		}
+	}
+	return ""
+}
+
+func exit(process *process) int {
+	process.shell.exit = true
+	if len(process.args) > 1 {
+		if status, err := strconv.Atoi(process.args[1]); err == nil {
+			return status & 0xff
+		}
+	}
+	return 0
+}
+
+func true_(process *process) int {
+	return 0
+}
+
+func false_(process *process) int {
+	return 1
+}
+
+func echo(process *process) int {
+	args := process.args[1:]
+	newline := true
+	if len(args) > 0 && args[0] == "-n" {
+		newline = false
+		args = args[1:]
+	}
+	process.stdout.WriteString(strings.Join(args, " "))
+	if newline {
+		process.stdout.WriteString("\n")
+	}
+	return 0
+}
+
+func cpuinfo(system *System) string {
+	var b strings.Builder
+	for i := 0; i < system.CPUCores && system.Profile.arm(); i++ {
+		fmt.Fprintf(&b, "processor\t: %v\n", i)
+		fmt.Fprintf(&b, "BogoMIPS\t: %.2f\n", system.BogoMIPS)
+		fmt.Fprintf(&b, "Features\t: fp asimd evtstrm aes pmull sha1 sha2 crc32 cpuid\n")
+		fmt.Fprintf(&b, "CPU implementer\t: 0x41\n")
+		fmt.Fprintf(&b, "CPU architecture: 8\n")
+		fmt.Fprintf(&b, "CPU variant\t: 0x%x\n", armPart(system.CPUModel)>>12)
+		fmt.Fprintf(&b, "CPU part\t: 0x%03x\n", armPart(system.CPUModel)&0xfff)
+		fmt.Fprintf(&b, "CPU revision\t: 1\n")
+		fmt.Fprintf(&b, "\n")
+	}
+	for i := 0; i < system.CPUCores && !system.Profile.arm(); i++ {
+		fmt.Fprintf(&b, "processor\t: %v\n", i)
+		fmt.Fprintf(&b, "vendor_id\t: %v\n", cpuVendor(system.CPUModel))
+		fmt.Fprintf(&b, "model name\t: %v\n", system.CPUModel)
+		fmt.Fprintf(&b, "cpu MHz\t\t: %.3f\n", system.CPUMHz)
+		fmt.Fprintf(&b, "physical id\t: 0\n")
+		fmt.Fprintf(&b, "siblings\t: %v\n", system.CPUCores)
+		fmt.Fprintf(&b, "core id\t\t: %v\n", i)
+		fmt.Fprintf(&b, "cpu cores\t: %v\n", system.CPUCores)
+		fmt.Fprintf(&b, "bogomips\t: %.2f\n", system.BogoMIPS)
+		fmt.Fprintf(&b, "\n")
+	}
+	return b.String()
+}
+
+func cpuVendor(model string) string {
+	switch {
+	case strings.HasPrefix(model, "AMD"):
+		return "AuthenticAMD"
+	case armPart(model) != 0:
+		return "ARM"
+	}
+	return "GenuineIntel"
+}
+
+// armPart returns the variant and part number of an ARM processor model, as
+// the variant shifted left by 12 bits of the part, or 0 if it isn't one.
+func armPart(model string) int {
+	switch model {
+	case "Neoverse-N1":
+		return 0x3d0c
+	case "Cortex-A72":
+		return 0x0d08
+	}
+	return 0
+}
+
+func meminfo(system *System) string {
+	return fmt.Sprintf("MemTotal:       %8d kB\nMemFree:        %8d kB\nMemAvailable:   %8d kB\nBuffers:        %8d kB\nCached:         %8d kB\nSwapCached:            0 kB\nSwapTotal:             0 kB\nSwapFree:              0 kB\n",
+		system.MemoryTotal, system.MemoryFree, system.MemoryAvailable(), system.MemoryBuffers, system.MemoryCached)
+}
+
+func free(process *process) int {
+	system := process.shell.system
+	fmt.Fprintf(&process.stdout, "%15v %11v %11v %11v %11v %11v\n", "total", "used", "free", "shared", "buff/cache", "available")
+	fmt.Fprintf(&process.stdout, "Mem:    %11v %11v %11v %11v %11v %11v\n", system.MemoryTotal, system.MemoryUsed(), system.MemoryFree, system.filesystemAt("/run").Used, system.MemoryBuffers+system.MemoryCached, system.MemoryAvailable())
+	fmt.Fprintf(&process.stdout, "Swap:   %11v %11v %11v\n", 0, 0, 0)
+	return 0
+}
+
+func nproc(process *process) int {
+	fmt.Fprintln(&process.stdout, process.shell.system.CPUCores)
+	return 0
+}
+
+func lscpu(process *process) int {
+	system := process.shell.system
+	fmt.Fprintf(&process.stdout, "Architecture:            %v\n", system.Profile.Machine)
+	fmt.Fprintf(&process.stdout, "  CPU op-mode(s):        32-bit, 64-bit\n")
+	fmt.Fprintf(&process.stdout, "CPU(s):                  %v\n", system.CPUCores)
+	fmt.Fprintf(&process.stdout, "  On-line CPU(s) list:   0-%v\n", system.CPUCores-1)
+	fmt.Fprintf(&process.stdout, "Vendor ID:               %v\n", cpuVendor(system.CPUModel))
+	fmt.Fprintf(&process.stdout, "  Model name:            %v\n", system.CPUModel)
+	fmt.Fprintf(&process.stdout, "    Thread(s) per core:  1\n")
+	fmt.Fprintf(&process.stdout, "    Core(s) per socket:  %v\n", system.CPUCores)
+	fmt.Fprintf(&process.stdout, "    Socket(s):           1\n")
+	fmt.Fprintf(&process.stdout, "    BogoMIPS:            %.2f\n", system.BogoMIPS)
+	return 0
+}
+
+func formatUptime(uptime time.Duration) string {
+	days := int(uptime.Hours()) / 24
+	hours := int(uptime.Hours()) % 24
+	minutes := int(uptime.Minutes()) % 60
+	if days == 0 {
+		return fmt.Sprintf("%2d:%02d", hours, minutes)
+	}
+	if days == 1 {
+		return fmt.Sprintf("1 day, %2d:%02d", hours, minutes)
+	}
+	return fmt.Sprintf("%v days, %2d:%02d", days, hours, minutes)
+}
+
+func uptime(process *process) int {
+	system := process.shell.system
+	now := time.Now()
+	fmt.Fprintf(&process.stdout, " %v up %v,  %v,  load average: %.2f, %.2f, %.2f\n",
+		now.Format("15:04:05"), formatUptime(system.Uptime(now)), formatUsers(len(system.loggedIn())), system.LoadAverage[0], system.LoadAverage[1], system.LoadAverage[2])
+	return 0
+}
+
+func dmesg(process *process) int {
+	system := process.shell.system
+	if system.Profile.arm() {
+		fmt.Fprintf(&process.stdout, "[    0.000000] Booting Linux on physical CPU 0x0000000000 [0x41%xf%03x1]\n", armPart(system.CPUModel)>>12, armPart(system.CPUModel)&0xfff)
+	}
+	fmt.Fprintf(&process.stdout, "[    0.000000] %v", procVersion(system))
+	if !system.Profile.arm() {
+		fmt.Fprintf(&process.stdout, "[    0.000000] Command line: BOOT_IMAGE=/boot/vmlinuz-%v root=/dev/sda1 ro console=tty1 console=ttyS0\n", system.Profile.KernelRelease)
+	}
+	fmt.Fprintf(&process.stdout, "[    0.004521] Memory: %vK/%vK available\n", system.MemoryTotal-48*1024, system.MemoryTotal)
+	if !system.Profile.arm() {
+		fmt.Fprintf(&process.stdout, "[    0.061345] smpboot: Allowing %v CPUs, 0 hotplug CPUs\n", system.CPUCores)
+		fmt.Fprintf(&process.stdout, "[    0.103482] smpboot: CPU0: %v\n", system.CPUModel)
+	}
+	fmt.Fprintf(&process.stdout, "[    0.112847] smp: Brought up 1 node, %v CPUs\n", system.CPUCores)
+	fmt.Fprintf(&process.stdout, "[    1.874950] EXT4-fs (sda1): mounted filesystem with ordered data mode. Opts: (null). Quota mode: none.\n")
+	fmt.Fprintf(&process.stdout, "[    3.402113] systemd[1]: Set hostname to <%v>.\n", system.Hostname)
+	return 0
+}
+
+func ps(process *process) int {
+	system := process.shell.system
+	fmt.Fprintf(&process.stdout, "%-8v %7v %5v %5v %-7v %v\n", "USER", "PID", "%CPU", "%MEM", "START", "COMMAND")
+	for _, p := range system.Processes {
+		start := p.Start.Format("Jan02")
+		if time.Since(p.Start) < 24*time.Hour {
+			start = p.Start.Format("15:04")
+		}
+		fmt.Fprintf(&process.stdout, "%-8v %7v %5.1f %5.1f %-7v %v\n", p.User, p.PID, 0.0, 0.1, start, p.Command)
+	}
+	return 0
+}
+
+func whoami(process *process) int {
+	fmt.Fprintln(&process.stdout, process.shell.session.User)
+	return 0
+}
+
+func hostname(process *process) int {
+	if len(process.args) > 1 {
+		switch process.args[1] {
+		case "-I", "--all-ip-addresses":
+			fmt.Fprintf(&process.stdout, "%v \n", process.shell.system.IP)
+			return 0
+		case "-i", "--ip-address":
+			// Like /etc/hosts maps the hostname on Ubuntu.
+			fmt.Fprintln(&process.stdout, "127.0.1.1")
+			return 0
+		}
+	}
+	fmt.Fprintln(&process.stdout, process.shell.system.Hostname)
+	return 0
+}
+
+func id(process *process) int {
+	name := process.shell.session.User
+	if len(process.args) > 1 {
+		name = process.args[1]
+	}
+	user := process.shell.system.LookupUser(name)
+	if user == nil {
+		fmt.Fprintf(&process.stderr, "id: '%v': no such user\n", name)
+		return 1
+	}
+	group := process.shell.system.groupName(user.GID)
+	fmt.Fprintf(&process.stdout, "uid=%v(%v) gid=%v(%v) groups=%v(%v)\n", user.UID, user.Name, user.GID, group, user.GID, group)
+	return 0
+}
@@ -0,0 +1,148 @@
+package shell
+
+import (
+	"context"
+	"github.com/longkeyy/sshesame/config"
+	log "github.com/sirupsen/logrus"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// field returns the whitespace-separated fields of the first line of text
+// starting with prefix, failing the test if there is none.
+func field(t *testing.T, text, prefix string) []string {
+	t.Helper()
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(line, prefix) {
+			return strings.Fields(strings.TrimPrefix(line, prefix))
+		}
+	}
+	t.Fatalf("no line starting with %q in %q", prefix, text)
+	return nil
+}
+
+func TestDetectionCommandsAgreeOnCPUs(t *testing.T) {
+	shell := newTestShell(newTestSession("root"), config.Shell{})
+	nproc := strings.TrimSpace(output(t, shell, "nproc"))
+	lscpu := output(t, shell, "lscpu")
+	cpuinfo := output(t, shell, "cat /proc/cpuinfo")
+	if cpus := field(t, lscpu, "CPU(s):")[0]; cpus != nproc {
+		t.Errorf("lscpu shows %v CPUs, nproc %v", cpus, nproc)
+	}
+	if processors := strings.Count(cpuinfo, "processor\t:"); strconv.Itoa(processors) != nproc {
+		t.Errorf("/proc/cpuinfo lists %v processors, nproc %v", processors, nproc)
+	}
+	model := strings.Join(field(t, lscpu, "  Model name:"), " ")
+	if cpuModel := strings.Join(field(t, cpuinfo, "model name\t:"), " "); cpuModel != model {
+		t.Errorf("/proc/cpuinfo shows model %q, lscpu %q", cpuModel, model)
+	}
+	if !strings.Contains(output(t, shell, "dmesg"), "smpboot: Allowing "+nproc+" CPUs") {
+		t.Errorf("dmesg doesn't boot %v CPUs", nproc)
+	}
+}
+
+func TestDetectionCommandsAgreeOnMemory(t *testing.T) {
+	shell := newTestShell(newTestSession("root"), config.Shell{})
+	free := field(t, output(t, shell, "free"), "Mem:")
+	meminfo := output(t, shell, "cat /proc/meminfo")
+	for i, name := range map[int]string{0: "MemTotal:", 2: "MemFree:", 5: "MemAvailable:"} {
+		if value := field(t, meminfo, name)[0]; value != free[i] {
+			t.Errorf("/proc/meminfo shows %v %v, free %v", name, value, free[i])
+		}
+	}
+	if shared := field(t, output(t, shell, "df"), "tmpfs")[1]; free[3] != shared {
+		t.Errorf("free shows %v shared, df %v used on /run", free[3], shared)
+	}
+}
+
+func TestDetectionCommandsAgreeOnUptime(t *testing.T) {
+	shell := newTestShell(newTestSession("root"), config.Shell{})
+	seconds, err := strconv.ParseFloat(strings.Fields(output(t, shell, "cat /proc/uptime"))[0], 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uptime := time.Duration(seconds * float64(time.Second))
+	if formatted := formatUptime(uptime); !strings.Contains(output(t, shell, "uptime"), "up "+formatted+",") {
+		t.Errorf("uptime doesn't show %q, the uptime of /proc/uptime", formatted)
+	}
+}
+
+func TestDetectionCommandsAreSeeded(t *testing.T) {
+	for _, command := range []string{"cat /proc/cpuinfo", "free", "df", "lscpu", "cat /etc/hostname"} {
+		first := output(t, newTestShell(newTestSession("root"), config.Shell{}), command)
+		second := output(t, newTestShell(newTestSession("root"), config.Shell{}), command)
+		if first != second {
+			t.Errorf("%q differs between shells of the same seed:\n%v\n%v", command, first, second)
+		}
+	}
+}
+
+func TestClassify(t *testing.T) {
+	for _, test := range []struct {
+		args     []string
+		category string
+	}{
+		{[]string{"dmesg"}, "detection_attempt"},
+		{[]string{"lscpu"}, "detection_attempt"},
+		{[]string{"cat", "/proc/cpuinfo"}, "detection_attempt"},
+		{[]string{"cat", "/proc/uptime"}, "detection_attempt"},
+		{[]string{"ls", "-la"}, ""},
+		{[]string{"cat", "notes.txt"}, ""},
+	} {
+		if category := classify(test.args); category != test.category {
+			t.Errorf("classify(%q) = %q, want %q", test.args, category, test.category)
+		}
+	}
+}
+
+// mountPoints returns the mount points in the column of lines matching
+// pattern.
+func mountPoints(text string, pattern *regexp.Regexp) []string {
+	points := []string{}
+	for _, match := range pattern.FindAllStringSubmatch(text, -1) {
+		points = append(points, match[1])
+	}
+	return points
+}
+
+func TestDetectionCommandsAgreeOnFilesystems(t *testing.T) {
+	shell := newTestShell(newTestSession("root"), config.Shell{})
+	df := mountPoints(output(t, shell, "df"), regexp.MustCompile(`(?m)%\s+(\S+)$`))
+	mount := mountPoints(output(t, shell, "mount"), regexp.MustCompile(`(?m)^\S+ on (\S+) type`))
+	mounts := mountPoints(output(t, shell, "cat /proc/mounts"), regexp.MustCompile(`(?m)^\S+ (\S+) `))
+	if strings.Join(df, ",") != strings.Join(mount, ",") || strings.Join(mount, ",") != strings.Join(mounts, ",") {
+		t.Errorf("df shows %v, mount %v and /proc/mounts %v", df, mount, mounts)
+	}
+}
+
+func TestConnectivityChecks(t *testing.T) {
+	hook := captureLog(t)
+	for _, test := range []struct {
+		command string
+		stdout  string
+		status  uint32
+	}{
+		{"echo hello", "hello\n", 0},
+		{"echo -n hello world", "hello world", 0},
+		{"echo", "\n", 0},
+		{"true", "", 0},
+		{":", "", 0},
+		{"false", "", 1},
+	} {
+		hook.Reset()
+		channel := &testChannel{}
+		exit, err := Exec(context.Background(), newTestSession("root"), config.Shell{Profile: DefaultProfile}, test.command, channel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exit.Status != test.status || channel.stdout.String() != test.stdout {
+			t.Errorf("%q exited with %v and output %q, want %v and %q", test.command, exit.Status, channel.stdout.String(), test.status, test.stdout)
+		}
+		if entry := hook.LastEntry(); entry == nil || entry.Message != "Command executed" || entry.Level != log.DebugLevel {
+			t.Errorf("%q logged %v, want Command executed at the debug level", test.command, entry)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package shell
+
+import (
+	"github.com/longkeyy/sshesame/session"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"strings"
+)
+
+// maxControlString is the most bytes of a control string logged, further
+// ones being dropped.
+const maxControlString = 4096
+
+// keyEscape is ESC, which starts escape sequences.
+const keyEscape = 0x1b
+
+// controlStrings are the kinds of control strings, by the byte following ESC
+// that introduces them.
+var controlStrings = map[byte]string{
+	']': "osc",
+	'P': "dcs",
+	'_': "apc",
+	'^': "pm",
+	'X': "sos",
+}
+
+// controlFilter removes the control strings clients send, OSC sequences
+// setting the window title say, from terminal input and logs them, so that
+// the terminal doesn't take them for keys. Strings are terminated by BEL,
+// OSC only, or ST, and abandoned at CAN, SUB, a line break or another escape
+// sequence, which are then read as usual.
+type controlFilter struct {
+	io.ReadWriter
+	session *session.Session
+
+	// pending is filtered input not read yet.
+	pending []byte
+	// escape is set after an ESC, kind is the kind of the control string
+	// being received, if any, and data its content so far.
+	escape    bool
+	kind      string
+	data      []byte
+	truncated bool
+}
+
+func (filter *controlFilter) Read(data []byte) (int, error) {
+	buffer := make([]byte, len(data))
+	for len(filter.pending) == 0 {
+		n, err := filter.ReadWriter.Read(buffer)
+		for _, b := range buffer[:n] {
+			filter.filter(b)
+		}
+		if err != nil {
+			if len(filter.pending) != 0 {
+				break
+			}
+			return 0, err
+		}
+	}
+	n := copy(data, filter.pending)
+	filter.pending = filter.pending[n:]
+	return n, nil
+}
+
+// filter handles the next byte of input.
+func (filter *controlFilter) filter(b byte) {
+	if filter.kind == "" {
+		if filter.escape {
+			filter.escape = false
+			if kind, ok := controlStrings[b]; ok {
+				filter.kind = kind
+				return
+			}
+			filter.pending = append(filter.pending, keyEscape)
+		}
+		if b == keyEscape {
+			filter.escape = true
+			return
+		}
+		filter.pending = append(filter.pending, b)
+		return
+	}
+	if filter.escape {
+		filter.escape = false
+		if b == '\\' {
+			filter.end("st")
+			return
+		}
+		filter.end("")
+		filter.filter(keyEscape)
+		filter.filter(b)
+		return
+	}
+	switch {
+	case b == '\a' && filter.kind == "osc":
+		filter.end("bel")
+	case b == keyEscape:
+		filter.escape = true
+	case b == 0x18, b == 0x1a, b == '\r', b == '\n':
+		filter.end("")
+		filter.pending = append(filter.pending, b)
+	case len(filter.data) < maxControlString:
+		filter.data = append(filter.data, b)
+	default:
+		filter.truncated = true
+	}
+}
+
+// end logs the control string received, terminated by terminator, none if
+// it was abandoned.
+func (filter *controlFilter) end(terminator string) {
+	if terminator == "" {
+		terminator = "none"
+	}
+	fields := log.Fields{
+		"client":     filter.session.RemoteAddr,
+		"channel":    "session",
+		"sequence":   filter.kind,
+		"data":       string(filter.data),
+		"terminator": terminator,
+		"truncated":  filter.truncated,
+		"category":   "terminal_control",
+	}
+	if filter.kind == "osc" {
+		command := strings.SplitN(string(filter.data), ";", 2)
+		fields["osc_command"] = command[0]
+		if len(command) == 2 && (command[0] == "0" || command[0] == "2") {
+			fields["title"] = command[1]
+		}
+	}
+	log.WithFields(fields).Info("Terminal control sequence received")
+	filter.kind = ""
+	filter.data = nil
+	filter.truncated = false
+}
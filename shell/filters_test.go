@@ -0,0 +1,51 @@
+package shell
+
+import (
+	"github.com/longkeyy/sshesame/config"
+	"testing"
+)
+
+func TestFilters(t *testing.T) {
+	shell := newTestShell(newTestSession("root"), config.Shell{})
+	for _, line := range []string{
+		"echo 'root one' > /tmp/a",
+		"echo Root two >> /tmp/a",
+		"echo three >> /tmp/a",
+		"echo root > /tmp/b",
+	} {
+		runScript(t, shell, line)
+	}
+	for _, test := range []struct {
+		line           string
+		stdout, stderr string
+		status         int
+	}{
+		{"grep root /tmp/a", "root one\n", "", 0},
+		{"grep -in root /tmp/a", "1:root one\n2:Root two\n", "", 0},
+		{"grep -v root /tmp/a", "Root two\nthree\n", "", 0},
+		{"grep -c root /tmp/a /tmp/b", "/tmp/a:1\n/tmp/b:1\n", "", 0},
+		{"grep -l three /tmp/a /tmp/b", "/tmp/a\n", "", 0},
+		{"grep -o 'o[a-z]*' /tmp/b", "oot\n", "", 0},
+		{"grep -w ro /tmp/a", "", "", 1},
+		{"grep -E 'one|two' /tmp/a", "root one\nRoot two\n", "", 0},
+		{`grep 'one\|two' /tmp/a`, "root one\nRoot two\n", "", 0},
+		{"grep -m 1 o /tmp/a", "root one\n", "", 0},
+		{"grep -q three /tmp/a", "", "", 0},
+		{"grep root /tmp/a /nosuch", "/tmp/a:root one\n", "grep: /nosuch: No such file or directory\n", 2},
+		{"grep", "", "Usage: grep [OPTION]... PATTERNS [FILE]...\nTry 'grep --help' for more information.\n", 2},
+		{"wc /tmp/a", " 3  5 24 /tmp/a\n", "", 0},
+		{"wc -l /tmp/a /tmp/b", " 3 /tmp/a\n 1 /tmp/b\n 4 total\n", "", 0},
+		{"cat /tmp/a | wc", "      3       5      24\n", "", 0},
+		{"wc -c < /tmp/b", "5\n", "", 0},
+		{"head -1 /tmp/a", "root one\n", "", 0},
+		{"tail -n 2 /tmp/a", "Root two\nthree\n", "", 0},
+		{"head -n 1 /tmp/a /tmp/b", "==> /tmp/a <==\nroot one\n\n==> /tmp/b <==\nroot\n", "", 0},
+		{"cat /tmp/a | tail -1", "three\n", "", 0},
+		{"head -n x /tmp/a", "", "head: invalid number of lines: ‘x’\n", 1},
+	} {
+		stdout, stderr, status := runScript(t, shell, test.line)
+		if stdout != test.stdout || stderr != test.stderr || status != test.status {
+			t.Errorf("%q wrote %q, %q and exited with %v, want %q, %q and %v", test.line, stdout, stderr, status, test.stdout, test.stderr, test.status)
+		}
+	}
+}
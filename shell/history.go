@@ -0,0 +1,125 @@
+package shell
+
+import (
+	"fmt"
+	"github.com/longkeyy/sshesame/session"
+	log "github.com/sirupsen/logrus"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// historyCommands are the commands the past logins of the fake host ran, by
+// what the admin was doing.
+var historyCommands = [][]string{
+	{"sudo apt update", "apt list --upgradable", "sudo apt upgrade -y", "sudo apt autoremove -y"},
+	{"df -h", "du -sh /var/log/*", "sudo journalctl --vacuum-time=7d", "df -h"},
+	{"systemctl status nginx", "sudo nginx -t", "sudo systemctl reload nginx", "tail -n 50 /var/log/nginx/error.log"},
+	{"cd /var/www/html", "git status", "git pull", "cd"},
+	{"free -m", "top", "ps aux --sort=-%mem | head"},
+	{"crontab -l", "crontab -e", "crontab -l"},
+	{"ls -la", "cat /etc/os-release", "uname -a", "uptime"},
+	{"sudo docker ps", "sudo docker logs --tail 100 app", "sudo docker compose restart"},
+	{"ss -tlnp", "sudo ufw status", "sudo tail -f /var/log/auth.log"},
+}
+
+// addHistory adds the bash history the user of sess saved on logging out of
+// their past logins, derived deterministically from its seed, to system. Users
+// who never logged in have none.
+func (system *System) addHistory(sess *session.Session) {
+	random := rand.New(rand.NewSource(sess.Seed))
+	for _, login := range system.Logins {
+		if login.User != sess.User || login.End.IsZero() {
+			continue
+		}
+		for tasks := 1 + random.Intn(2); tasks > 0; tasks-- {
+			commands := historyCommands[random.Intn(len(historyCommands))]
+			system.History = append(system.History, commands[:1+random.Intn(len(commands))]...)
+		}
+		if random.Intn(2) == 0 {
+			system.History = append(system.History, "exit")
+		}
+	}
+	if len(system.History) != 0 {
+		home := "/root"
+		if user := system.LookupUser(sess.User); user != nil {
+			home = user.Home
+		}
+		system.HistoryFile = home + "/.bash_history"
+	}
+}
+
+// bashHistory returns the history file.
+func bashHistory(system *System) string {
+	if len(system.History) == 0 {
+		return ""
+	}
+	return strings.Join(system.History, "\n") + "\n"
+}
+
+// remember adds line to the history of the session like bash with the
+// HISTCONTROL=ignoreboth of Ubuntu does: lines starting with a space and
+// repeating the previous one are left out.
+func (shell *shell) remember(line string) {
+	if strings.TrimSpace(line) == "" || strings.HasPrefix(line, " ") {
+		return
+	}
+	if history := shell.history(); len(history) != 0 && history[len(history)-1] == line {
+		return
+	}
+	shell.session.AddHistory(line)
+}
+
+// history returns the history the shell started with followed by the lines
+// entered in the session.
+func (shell *shell) history() []string {
+	lines, cleared := shell.session.History()
+	if cleared {
+		return lines
+	}
+	return append(append([]string(nil), shell.system.History...), lines...)
+}
+
+// logHistoryRead logs that the client read the history of source, the
+// history command or the history file.
+func (shell *shell) logHistoryRead(source string, entries int) {
+	log.WithFields(log.Fields{
+		"client":   shell.session.RemoteAddr,
+		"channel":  "session",
+		"source":   source,
+		"entries":  entries,
+		"category": "history_access",
+	}).Info("Shell history read")
+}
+
+func history(process *process) int {
+	args := process.args[1:]
+	if !process.shell.interactive {
+		// Like bash, non-interactive shells keep no history.
+		if len(args) == 0 || args[0] != "-c" {
+			process.shell.logHistoryRead("history", 0)
+		}
+		return 0
+	}
+	if len(args) != 0 && args[0] == "-c" {
+		process.shell.session.ClearHistory()
+		return 0
+	}
+	lines := process.shell.history()
+	first := 0
+	if len(args) != 0 {
+		count, err := strconv.Atoi(args[0])
+		if err != nil || count < 0 {
+			fmt.Fprintf(&process.stderr, "bash: history: %v: numeric argument required\n", args[0])
+			return 1
+		}
+		if count < len(lines) {
+			first = len(lines) - count
+		}
+	}
+	for i := first; i < len(lines); i++ {
+		fmt.Fprintf(&process.stdout, "%5d  %v\n", i+1, lines[i])
+	}
+	process.shell.logHistoryRead("history", len(lines)-first)
+	return 0
+}
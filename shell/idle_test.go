@@ -0,0 +1,59 @@
+package shell
+
+import (
+	"context"
+	"github.com/longkeyy/sshesame/config"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdleTimeout(t *testing.T) {
+	hook := captureLog(t)
+	sess := newTestSession("root")
+	sess.ObserveRequest("pty-req")
+	const timeout = 200 * time.Millisecond
+	cfg := config.Shell{Profile: DefaultProfile, IdleTimeout: timeout}
+	reader, writer := io.Pipe()
+	defer writer.Close()
+	channel := &testChannel{input: reader}
+	go func() {
+		// Typing a line before the timeout resets it.
+		time.Sleep(timeout / 2)
+		io.WriteString(writer, "whoami\r")
+	}()
+	start := time.Now()
+	if err := Run(context.Background(), sess, cfg, channel); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < timeout*3/2 {
+		t.Errorf("shell timed out after %v, want at least %v after the last line", elapsed, timeout)
+	}
+	output := channel.stdout.String()
+	if !strings.Contains(output, "root\r\n") || !strings.HasSuffix(output, "\r\ntimed out waiting for input: auto-logout\r\n") {
+		t.Errorf("shell wrote %q, want the output of whoami then the timeout message", output)
+	}
+	if entry := hook.LastEntry(); entry == nil || entry.Message != "Shell timed out" || entry.Data["idle_timeout"] != timeout.String() {
+		t.Errorf("logged %v, want the timeout", entry)
+	}
+}
+
+func TestIdleTimeoutDisabled(t *testing.T) {
+	captureLog(t)
+	sess := newTestSession("root")
+	sess.ObserveRequest("pty-req")
+	reader, writer := io.Pipe()
+	channel := &testChannel{input: reader}
+	done := make(chan error)
+	go func() {
+		done <- Run(context.Background(), sess, config.Shell{Profile: DefaultProfile}, channel)
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("idle shell ended with %v, want it kept open without an idle timeout", err)
+	case <-time.After(300 * time.Millisecond):
+	}
+	writer.Close()
+	<-done
+}
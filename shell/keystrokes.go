@@ -0,0 +1,36 @@
+package shell
+
+import (
+	"github.com/longkeyy/sshesame/session"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"time"
+)
+
+// keystrokeLogger logs every chunk of input read from a terminal along with
+// the time elapsed since the previous one, which tells typing humans apart from
+// pasted or scripted input.
+type keystrokeLogger struct {
+	io.ReadWriter
+	session *session.Session
+	last    time.Time
+}
+
+func (logger *keystrokeLogger) Read(data []byte) (int, error) {
+	n, err := logger.ReadWriter.Read(data)
+	if n > 0 {
+		now := time.Now()
+		fields := log.Fields{
+			"client":    logger.session.RemoteAddr,
+			"channel":   "session",
+			"keys":      string(data[:n]),
+			"timestamp": now.Format(time.RFC3339Nano),
+		}
+		if !logger.last.IsZero() {
+			fields["interval_us"] = now.Sub(logger.last).Microseconds()
+		}
+		logger.last = now
+		log.WithFields(fields).Info("Keystrokes received")
+	}
+	return n, err
+}
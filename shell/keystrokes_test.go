@@ -0,0 +1,111 @@
+package shell
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/longkeyy/sshesame/config"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// pacedReader returns chunks one per Read, waiting delay before each.
+type pacedReader struct {
+	chunks []string
+	delay  time.Duration
+}
+
+func (reader *pacedReader) Read(data []byte) (int, error) {
+	if len(reader.chunks) == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(reader.delay)
+	n := copy(data, reader.chunks[0])
+	reader.chunks = reader.chunks[1:]
+	return n, nil
+}
+
+const keystrokeDelay = 30 * time.Millisecond
+
+func TestKeystrokeTiming(t *testing.T) {
+	hook := captureLog(t)
+	channel := &testChannel{input: &pacedReader{[]string{"l", "s", "\r"}, keystrokeDelay}}
+	logger := &keystrokeLogger{ReadWriter: channel, session: newTestSession("root")}
+	if _, err := io.Copy(ioutil.Discard, logger); err != nil {
+		t.Fatal(err)
+	}
+	entries := hook.AllEntries()
+	if len(entries) != 3 {
+		t.Fatalf("logged %v entries, want one per keystroke", len(entries))
+	}
+	for i, entry := range entries {
+		if entry.Message != "Keystrokes received" || entry.Data["keys"] != []string{"l", "s", "\r"}[i] {
+			t.Errorf("logged %q with %v", entry.Message, entry.Data)
+		}
+		interval, ok := entry.Data["interval_us"].(int64)
+		if i == 0 {
+			if ok {
+				t.Errorf("first keystroke logged with interval_us %v", interval)
+			}
+			continue
+		}
+		if !ok || time.Duration(interval)*time.Microsecond < keystrokeDelay {
+			t.Errorf("keystroke %v logged interval_us %v, want at least %v", i, entry.Data["interval_us"], keystrokeDelay)
+		}
+	}
+}
+
+func TestKeystrokesRecorded(t *testing.T) {
+	hook := captureLog(t)
+	sess := newTestSession("root")
+	sess.ObserveRequest("pty-req")
+	dir := t.TempDir()
+	cfg := config.Shell{Profile: DefaultProfile, LogKeystrokes: true, RecordingDir: dir}
+	channel := &testChannel{input: &pacedReader{[]string{"i", "d", "\r"}, keystrokeDelay}}
+	if err := Run(context.Background(), sess, cfg, channel); err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	logged := 0
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Keystrokes received" {
+			logged++
+		}
+	}
+	if logged != 3 {
+		t.Errorf("logged %v keystrokes, want 3", logged)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil || len(paths) != 1 {
+		t.Fatalf("recorded %v, want a single recording", paths)
+	}
+	file, err := os.Open(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // The header.
+	var times []float64
+	for scanner.Scan() {
+		var event []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatal(err)
+		}
+		if event[1] == "i" {
+			times = append(times, event[0].(float64))
+		}
+	}
+	if len(times) != 3 {
+		t.Fatalf("recorded %v input events, want 3", len(times))
+	}
+	for i := 1; i < len(times); i++ {
+		if delta := time.Duration((times[i] - times[i-1]) * float64(time.Second)); delta < keystrokeDelay {
+			t.Errorf("input event %v recorded %v after the previous one, want at least %v", i, delta, keystrokeDelay)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package shell
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/longkeyy/sshesame/config"
+	"github.com/longkeyy/sshesame/session"
+	log "github.com/sirupsen/logrus"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Login is a login session on a System, as recorded in wtmp.
+type Login struct {
+	User  string
+	TTY   string
+	Host  string
+	PID   int
+	Start time.Time
+	// End is when the user logged out, zero for sessions still logged in.
+	End time.Time
+}
+
+// adminHosts are the first octets of the addresses past logins came from.
+var adminHosts = []int{24, 73, 81, 92, 98, 176, 185, 212}
+
+// addLogins adds past logins, derived deterministically from the seed of
+// sess, to system, along with sess itself if it has a terminal, like sshd only
+// records those.
+func (system *System) addLogins(sess *session.Session) {
+	random := rand.New(rand.NewSource(sess.Seed))
+	users := []string{"root", system.Profile.DefaultUser}
+	if sess.User != "root" && sess.User != system.Profile.DefaultUser && system.LookupUser(sess.User) != nil {
+		users = append(users, sess.User)
+	}
+	hosts := []string{
+		fmt.Sprintf("%v.%v.%v.%v", adminHosts[random.Intn(len(adminHosts))], random.Intn(256), random.Intn(256), 1+random.Intn(254)),
+		fmt.Sprintf("%v.%v.%v.%v", adminHosts[random.Intn(len(adminHosts))], random.Intn(256), random.Intn(256), 1+random.Intn(254)),
+		system.IP[:strings.LastIndex(system.IP, ".")+1] + fmt.Sprint(2+random.Intn(250)),
+	}
+	// Logins are spread over the time since boot, ending an hour ago.
+	span := sess.Start.Add(-time.Hour).Sub(system.Boot)
+	starts := make([]time.Time, 4+random.Intn(8))
+	for i := range starts {
+		starts[i] = system.Boot.Add(time.Duration(random.Int63n(int64(span)))).Truncate(time.Second)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+	pid := 1000
+	for i, start := range starts {
+		user := users[random.Intn(len(users))]
+		if i == len(starts)-1 && system.LookupUser(sess.User) != nil {
+			// The user logged in before, so that their last login is shown.
+			user = sess.User
+		}
+		pid += 1 + random.Intn(4000)
+		system.Logins = append(system.Logins, Login{
+			User:  user,
+			TTY:   fmt.Sprintf("pts/%v", random.Intn(2)),
+			Host:  hosts[random.Intn(len(hosts))],
+			PID:   pid,
+			Start: start,
+			End:   start.Add(time.Duration(2*60+random.Intn(3*3600)) * time.Second),
+		})
+	}
+	if !sess.PTY() {
+		return
+	}
+	host := sess.RemoteAddr.String()
+	if tcpAddr, ok := sess.RemoteAddr.(*net.TCPAddr); ok {
+		host = tcpAddr.IP.String()
+	}
+	system.Logins = append(system.Logins, Login{
+		User:  sess.User,
+		TTY:   "pts/0",
+		Host:  host,
+		PID:   pid + 1 + random.Intn(4000),
+		Start: sess.Start.Truncate(time.Second),
+	})
+}
+
+// loggedIn returns the logins that are still logged in.
+func (system *System) loggedIn() []Login {
+	logins := []Login{}
+	for _, login := range system.Logins {
+		if login.End.IsZero() {
+			logins = append(logins, login)
+		}
+	}
+	return logins
+}
+
+// lastLogin returns the most recent login of user before the current one, or
+// nil if they never logged in.
+func (system *System) lastLogin(user string) *Login {
+	for i := len(system.Logins) - 1; i >= 0; i-- {
+		if system.Logins[i].User == user && !system.Logins[i].End.IsZero() {
+			return &system.Logins[i]
+		}
+	}
+	return nil
+}
+
+// motd renders the message of the day of the shell's session with motd, an
+// empty one if it fails. The user supposedly last logged in from the client's
+// own address, at a time within the day before seeded by the session, rather
+// than at their last login on the system, which was from another host.
+func (shell *shell) motd(motd *template.Template) string {
+	sess, system := shell.session, shell.system
+	clientIP := sess.RemoteAddr.String()
+	if host, _, err := net.SplitHostPort(clientIP); err == nil {
+		clientIP = host
+	}
+	lastLogin := time.Now().Add(-time.Duration(rand.New(rand.NewSource(sess.Seed)).Int63n(int64(24 * time.Hour))))
+	var rendered bytes.Buffer
+	if err := motd.Execute(&rendered, config.MOTDData{
+		User:       sess.User,
+		Hostname:   system.Hostname,
+		ClientIP:   clientIP,
+		LastLogin:  lastLogin.Format("Mon Jan _2 15:04:05 2006"),
+		Kernel:     system.Profile.KernelRelease,
+		Machine:    system.Profile.Machine,
+		PrettyName: system.Profile.PrettyName,
+	}); err != nil {
+		log.Warning("Failed to render message of the day:", err.Error())
+		return ""
+	}
+	return rendered.String()
+}
+
+// formatUsers formats a number of users like uptime and w do.
+func formatUsers(users int) string {
+	if users == 1 {
+		return "1 user"
+	}
+	return fmt.Sprintf("%v users", users)
+}
+
+func who(process *process) int {
+	for _, login := range process.shell.system.loggedIn() {
+		fmt.Fprintf(&process.stdout, "%-8v %-12v %v (%v)\n", login.User, login.TTY, login.Start.Format("2006-01-02 15:04"), login.Host)
+	}
+	return 0
+}
+
+func w(process *process) int {
+	system := process.shell.system
+	now := time.Now()
+	logins := system.loggedIn()
+	fmt.Fprintf(&process.stdout, " %v up %v,  %v,  load average: %.2f, %.2f, %.2f\n",
+		now.Format("15:04:05"), formatUptime(system.Uptime(now)), formatUsers(len(logins)), system.LoadAverage[0], system.LoadAverage[1], system.LoadAverage[2])
+	fmt.Fprintf(&process.stdout, "%-8v %-8v %-16v %-8v %-6v %-6v %-6v %v\n", "USER", "TTY", "FROM", "LOGIN@", "IDLE", "JCPU", "PCPU", "WHAT")
+	for _, login := range logins {
+		fmt.Fprintf(&process.stdout, "%-8.8v %-8v %-16.16v %-8v %-6v %-6v %-6v %v\n", login.User, login.TTY, login.Host, login.Start.Format("15:04"), "0.00s", "0.02s", "0.00s", strings.Join(process.args, " "))
+	}
+	return 0
+}
+
+// formatLoginDuration formats how long a login lasted like last does.
+func formatLoginDuration(duration time.Duration) string {
+	days := int(duration.Hours()) / 24
+	if days == 0 {
+		return fmt.Sprintf("(%02d:%02d)", int(duration.Hours())%24, int(duration.Minutes())%60)
+	}
+	return fmt.Sprintf("(%v+%02d:%02d)", days, int(duration.Hours())%24, int(duration.Minutes())%60)
+}
+
+func last(process *process) int {
+	system := process.shell.system
+	users := map[string]bool{}
+	for _, arg := range process.args[1:] {
+		if !strings.HasPrefix(arg, "-") {
+			users[arg] = true
+		}
+	}
+	for i := len(system.Logins) - 1; i >= 0; i-- {
+		login := system.Logins[i]
+		if len(users) != 0 && !users[login.User] {
+			continue
+		}
+		fmt.Fprintf(&process.stdout, "%-8.8v %-12.12v %-16.16v %v", login.User, login.TTY, login.Host, login.Start.Format("Mon Jan _2 15:04"))
+		if login.End.IsZero() {
+			fmt.Fprintf(&process.stdout, "   still logged in\n")
+		} else {
+			fmt.Fprintf(&process.stdout, " - %v  %v\n", login.End.Format("15:04"), formatLoginDuration(login.End.Sub(login.Start)))
+		}
+	}
+	if len(users) == 0 || users["reboot"] {
+		fmt.Fprintf(&process.stdout, "%-8.8v %-12.12v %-16.16v %v   still running\n", "reboot", "system boot", system.Profile.KernelRelease, system.Boot.Format("Mon Jan _2 15:04"))
+	}
+	fmt.Fprintf(&process.stdout, "\nwtmp begins %v\n", system.Boot.Format("Mon Jan _2 15:04:05 2006"))
+	return 0
+}
+
+func lastlog(process *process) int {
+	system := process.shell.system
+	fmt.Fprintf(&process.stdout, "%-16v %-8v %-16v %v\n", "Username", "Port", "From", "Latest")
+	for _, user := range system.Users {
+		var latest *Login
+		for i := range system.Logins {
+			if system.Logins[i].User == user.Name {
+				latest = &system.Logins[i]
+			}
+		}
+		if latest == nil {
+			fmt.Fprintf(&process.stdout, "%-16v %-8v %-16v %v\n", user.Name, "", "", "**Never logged in**")
+			continue
+		}
+		fmt.Fprintf(&process.stdout, "%-16v %-8v %-16v %v\n", user.Name, latest.TTY, latest.Host, latest.Start.Format("Mon Jan _2 15:04:05 -0700 2006"))
+	}
+	return 0
+}
+
+// The types of utmp records.
+const (
+	utmpBootTime    = 2
+	utmpUserProcess = 7
+	utmpDeadProcess = 8
+)
+
+// utmpRecord is a record of utmp and wtmp files in the layout of glibc on
+// x86-64.
+type utmpRecord struct {
+	Type    int16
+	_       int16
+	PID     int32
+	Line    [32]byte
+	ID      [4]byte
+	User    [32]byte
+	Host    [256]byte
+	Exit    [2]int16
+	Session int32
+	Seconds int32
+	Micros  int32
+	Address [4]int32
+	_       [20]byte
+}
+
+func newUtmpRecord(recordType int16, pid int, line, user, host string, at time.Time) utmpRecord {
+	record := utmpRecord{Type: recordType, PID: int32(pid), Seconds: int32(at.Unix())}
+	copy(record.Line[:], line)
+	copy(record.ID[:], strings.TrimPrefix(line, "p"))
+	copy(record.User[:], user)
+	copy(record.Host[:], host)
+	if ip := net.ParseIP(host).To4(); ip != nil {
+		record.Address[0] = int32(binary.LittleEndian.Uint32(ip))
+	}
+	return record
+}
+
+// encodeUtmp returns records in the binary layout of utmp files.
+func encodeUtmp(records []utmpRecord) string {
+	var b bytes.Buffer
+	for _, record := range records {
+		binary.Write(&b, binary.LittleEndian, record)
+	}
+	return b.String()
+}
+
+// wtmp returns /var/log/wtmp, recording the boot and every login and logout.
+func wtmp(system *System) string {
+	records := []utmpRecord{newUtmpRecord(utmpBootTime, 0, "~", "reboot", system.Profile.KernelRelease, system.Boot)}
+	for _, login := range system.Logins {
+		records = append(records, newUtmpRecord(utmpUserProcess, login.PID, login.TTY, login.User, login.Host, login.Start))
+		if !login.End.IsZero() {
+			records = append(records, newUtmpRecord(utmpDeadProcess, login.PID, login.TTY, "", "", login.End))
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Seconds < records[j].Seconds })
+	return encodeUtmp(records)
+}
+
+// utmp returns /run/utmp, recording the boot and who is logged in.
+func utmp(system *System) string {
+	records := []utmpRecord{newUtmpRecord(utmpBootTime, 0, "~", "reboot", system.Profile.KernelRelease, system.Boot)}
+	for _, login := range system.loggedIn() {
+		records = append(records, newUtmpRecord(utmpUserProcess, login.PID, login.TTY, login.User, login.Host, login.Start))
+	}
+	return encodeUtmp(records)
+}
@@ -0,0 +1,146 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/longkeyy/sshesame/config"
+	"github.com/longkeyy/sshesame/session"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+)
+
+// newLoginSession returns the session of root logging in from 192.0.2.1 with
+// a terminal, which login records show.
+func newLoginSession() *session.Session {
+	sess := newTestSession("root")
+	sess.ObserveRequest("pty-req")
+	return sess
+}
+
+func TestWhoShowsSession(t *testing.T) {
+	shell := newTestShell(newLoginSession(), config.Shell{})
+	who := output(t, shell, "who")
+	if !regexp.MustCompile(`(?m)^root +pts/0 +\d{4}-\d\d-\d\d \d\d:\d\d \(192\.0\.2\.1\)$`).MatchString(who) {
+		t.Errorf("who = %q, want root logged in from 192.0.2.1", who)
+	}
+	users := strings.Count(who, "\n")
+	if w := output(t, shell, "w"); !strings.Contains(w, formatUsers(users)) || !strings.Contains(w, "192.0.2.1") {
+		t.Errorf("w = %q, want the %v of who", w, formatUsers(users))
+	}
+	// Sessions without a terminal aren't recorded.
+	if who := output(t, newTestShell(newTestSession("root"), config.Shell{}), "who"); strings.Contains(who, "192.0.2.1") {
+		t.Errorf("who without a terminal = %q", who)
+	}
+}
+
+func TestLastConsistentWithLastLogin(t *testing.T) {
+	sess := newLoginSession()
+	channel := &testChannel{input: strings.NewReader("last root\rlastlog\rexit\r")}
+	if err := Run(context.Background(), sess, config.Shell{Profile: DefaultProfile}, channel); err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	transcript := channel.stdout.String()
+	match := regexp.MustCompile(`Last login: \w{3} (\w{3} [ \d]\d \d\d:\d\d):\d\d \d{4} from (\S+)\r\n`).FindStringSubmatch(transcript)
+	if match == nil {
+		t.Fatalf("shell wrote %q, want the last login", transcript)
+	}
+	lines := strings.Split(transcript, "\r\n")
+	var logins []string
+	for _, line := range lines {
+		if strings.HasPrefix(line, "root ") && strings.Contains(line, "pts/") {
+			logins = append(logins, line)
+		}
+	}
+	if len(logins) < 2 || !strings.Contains(logins[0], "192.0.2.1") || !strings.HasSuffix(logins[0], "still logged in") {
+		t.Fatalf("last root = %q, want the current session first", logins)
+	}
+	if previous := logins[1]; !strings.Contains(previous, match[2]) || !strings.Contains(previous, match[1]) {
+		t.Errorf("last shows %q before the current session, want the last login of %v from %v", previous, match[1], match[2])
+	}
+	// lastlog pads user names to 16 characters, last to 8.
+	if latest := regexp.MustCompile(`(?m)^root {13}(\S+) +(\S+)`).FindStringSubmatch(transcript); latest == nil || latest[1] != "pts/0" || latest[2] != "192.0.2.1" {
+		t.Errorf("lastlog shows %q as root's latest login, want the current session", latest)
+	}
+}
+
+func TestWtmpConsistentWithLast(t *testing.T) {
+	shell := newTestShell(newLoginSession(), config.Shell{})
+	reader := strings.NewReader(output(t, shell, "cat /var/log/wtmp"))
+	logins := map[string]bool{}
+	boots := 0
+	for reader.Len() != 0 {
+		var record utmpRecord
+		if err := binary.Read(reader, binary.LittleEndian, &record); err != nil {
+			t.Fatal(err)
+		}
+		user, host := string(bytes.TrimRight(record.User[:], "\x00")), string(bytes.TrimRight(record.Host[:], "\x00"))
+		switch record.Type {
+		case utmpBootTime:
+			boots++
+		case utmpUserProcess:
+			logins[fmt.Sprintf("%v %v %v", user, string(bytes.TrimRight(record.Line[:], "\x00")), host)] = true
+		}
+	}
+	if boots != 1 {
+		t.Errorf("wtmp records %v boots, want 1", boots)
+	}
+	for _, login := range shell.system.Logins {
+		if !logins[fmt.Sprintf("%v %v %v", login.User, login.TTY, login.Host)] {
+			t.Errorf("wtmp lacks the login of %v on %v from %v shown by last", login.User, login.TTY, login.Host)
+		}
+	}
+	if !logins["root pts/0 192.0.2.1"] {
+		t.Error("wtmp lacks the current session")
+	}
+}
+
+func TestMOTD(t *testing.T) {
+	shell := newTestShell(newLoginSession(), config.Shell{})
+	motd := template.Must(template.New("motd").Parse("Welcome to {{.PrettyName}} ({{.Kernel}} {{.Machine}})\n{{.User}}@{{.Hostname}} from {{.ClientIP}}\n"))
+	if rendered, want := shell.motd(motd), "Welcome to Ubuntu 22.04.3 LTS (5.15.0-91-generic x86_64)\nroot@db-backup from 192.0.2.1\n"; rendered != want {
+		t.Errorf("motd = %q, want %q", rendered, want)
+	}
+	// The last login is within the day before, the same for the session.
+	lastLogin := template.Must(template.New("motd").Parse("{{.LastLogin}}"))
+	rendered := shell.motd(lastLogin)
+	at, err := time.ParseInLocation("Mon Jan _2 15:04:05 2006", rendered, time.Local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ago := time.Since(at); ago < 0 || ago > 24*time.Hour+time.Second {
+		t.Errorf("last login %q %v ago, want within a day", rendered, ago)
+	}
+	if again := shell.motd(lastLogin); again != rendered {
+		t.Errorf("last login %q, then %q", rendered, again)
+	}
+}
+
+func TestMOTDInvalid(t *testing.T) {
+	hook := captureLog(t)
+	shell := newTestShell(newLoginSession(), config.Shell{})
+	if rendered := shell.motd(template.Must(template.New("motd").Parse("{{.Missing}}"))); rendered != "" {
+		t.Errorf("motd = %q, want none", rendered)
+	}
+	if entries := hook.AllEntries(); len(entries) != 1 || entries[0].Level != log.WarnLevel {
+		t.Errorf("logged %v", entries)
+	}
+}
+
+func TestRunShowsMOTD(t *testing.T) {
+	channel := &testChannel{input: strings.NewReader("exit\r")}
+	cfg := config.Shell{Profile: DefaultProfile, MOTD: template.Must(template.New("motd").Parse("Hello {{.User}}\n"))}
+	if err := Run(context.Background(), newLoginSession(), cfg, channel); err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	// The message of the day replaces the last login.
+	if transcript := channel.stdout.String(); !strings.HasPrefix(transcript, "Hello root\r\n") || strings.Contains(transcript, "Last login") {
+		t.Errorf("shell wrote %q, want the message of the day first", transcript)
+	}
+}
@@ -0,0 +1,288 @@
+package shell
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// networkInterface is the name of the only network interface, as named on
+// KVM guests.
+const networkInterface = "ens3"
+
+// socket is a socket open on a System.
+type socket struct {
+	// Protocol is tcp or udp, with 6 appended for IPv6.
+	Protocol string
+	// State is in the words of ss, LISTEN, UNCONN or ESTAB.
+	State          string
+	Local, Peer    string
+	LocalPort      int
+	PeerPort       int
+	SendQueue      int
+	PID            int
+	Program        string
+	FileDescriptor int
+}
+
+// subnet returns the first three octets of the address of the host.
+func (system *System) subnet() string {
+	return system.IP[:strings.LastIndex(system.IP, ".")]
+}
+
+// linkLocal returns the IPv6 link-local address derived from the MAC.
+func (system *System) linkLocal() string {
+	mac, _ := net.ParseMAC(system.MAC)
+	return fmt.Sprintf("fe80::%x:%x:%x:%x", uint16(mac[0]^2)<<8|uint16(mac[1]), uint16(mac[2])<<8|0xff, 0xfe00|uint16(mac[3]), uint16(mac[4])<<8|uint16(mac[5]))
+}
+
+// sockets returns the sockets open on the host, including the connection of
+// the client itself.
+func (shell *shell) sockets() []socket {
+	system := shell.system
+	sockets := []socket{
+		{"udp", "UNCONN", system.IP, "0.0.0.0", 68, 0, 0, 687, "systemd-network", 18},
+		{"tcp", "LISTEN", "0.0.0.0", "0.0.0.0", 22, 0, 128, 731, "sshd", 3},
+		{"tcp6", "LISTEN", "::", "::", 22, 0, 128, 731, "sshd", 4},
+	}
+	client, ok := shell.session.RemoteAddr.(*net.TCPAddr)
+	if !ok {
+		return sockets
+	}
+	connection := socket{"tcp", "ESTAB", system.IP, client.IP.String(), 22, client.Port, 0, shell.sshdPID(), "sshd", 4}
+	if client.IP.IsLoopback() {
+		connection.Local = connection.Peer
+	}
+	if client.IP.To4() == nil {
+		connection.Protocol = "tcp6"
+		if !client.IP.IsLoopback() {
+			connection.Local = system.linkLocal()
+		}
+	}
+	return append(sockets, connection)
+}
+
+// sshdPID returns the PID of the sshd process serving the client.
+func (shell *shell) sshdPID() int {
+	logins := shell.system.loggedIn()
+	for i := len(logins) - 1; i >= 0; i-- {
+		if logins[i].User == shell.session.User {
+			return logins[i].PID
+		}
+	}
+	return 1000 + int(uint64(shell.session.Seed)%30000)
+}
+
+// formatBytes formats a number of bytes like ifconfig does.
+func formatBytes(bytes uint64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	value := float64(bytes)
+	unit := 0
+	for value >= 1000 && unit < len(units)-1 {
+		value /= 1000
+		unit++
+	}
+	return fmt.Sprintf("%.1f %v", value, units[unit])
+}
+
+// traffic returns the bytes and packets received and sent on the network
+// since boot.
+func (system *System) traffic() (received, receivedPackets, sent, sentPackets uint64) {
+	seconds := uint64(system.Uptime(time.Now()).Seconds())
+	received, sent = seconds*system.ReceiveRate, seconds*system.SendRate
+	return received, received / 800, sent, sent / 600
+}
+
+func ip(process *process) int {
+	args := []string{}
+	for _, arg := range process.args[1:] {
+		if !strings.HasPrefix(arg, "-") {
+			args = append(args, arg)
+		}
+	}
+	if len(args) == 0 {
+		process.stderr.WriteString("Usage: ip [ OPTIONS ] OBJECT { COMMAND | help }\nwhere  OBJECT := { address | link | route | neigh | rule | ... }\n")
+		return 255
+	}
+	object := args[0]
+	system := process.shell.system
+	switch {
+	case strings.HasPrefix("address", object):
+		fmt.Fprintf(&process.stdout, "1: lo: <LOOPBACK,UP,LOWER_UP> mtu 65536 qdisc noqueue state UNKNOWN group default qlen 1000\n    link/loopback 00:00:00:00:00:00 brd 00:00:00:00:00:00\n")
+		fmt.Fprintf(&process.stdout, "    inet 127.0.0.1/8 scope host lo\n       valid_lft forever preferred_lft forever\n    inet6 ::1/128 scope host \n       valid_lft forever preferred_lft forever\n")
+		fmt.Fprintf(&process.stdout, "2: %v: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc fq_codel state UP group default qlen 1000\n    link/ether %v brd ff:ff:ff:ff:ff:ff\n    altname enp0s3\n", networkInterface, system.MAC)
+		lease := 86400 - int(time.Since(system.Boot).Seconds())%43200
+		fmt.Fprintf(&process.stdout, "    inet %v/24 metric 100 brd %v.255 scope global dynamic %v\n       valid_lft %vsec preferred_lft %vsec\n", system.IP, system.subnet(), networkInterface, lease, lease)
+		fmt.Fprintf(&process.stdout, "    inet6 %v/64 scope link \n       valid_lft forever preferred_lft forever\n", system.linkLocal())
+	case strings.HasPrefix("link", object):
+		fmt.Fprintf(&process.stdout, "1: lo: <LOOPBACK,UP,LOWER_UP> mtu 65536 qdisc noqueue state UNKNOWN mode DEFAULT group default qlen 1000\n    link/loopback 00:00:00:00:00:00 brd 00:00:00:00:00:00\n")
+		fmt.Fprintf(&process.stdout, "2: %v: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc fq_codel state UP mode DEFAULT group default qlen 1000\n    link/ether %v brd ff:ff:ff:ff:ff:ff\n    altname enp0s3\n", networkInterface, system.MAC)
+	case strings.HasPrefix("route", object):
+		fmt.Fprintf(&process.stdout, "default via %v.1 dev %v proto dhcp src %v metric 100 \n", system.subnet(), networkInterface, system.IP)
+		fmt.Fprintf(&process.stdout, "%v.0/24 dev %v proto kernel scope link src %v metric 100 \n", system.subnet(), networkInterface, system.IP)
+		fmt.Fprintf(&process.stdout, "%v.1 dev %v proto dhcp scope link src %v metric 100 \n", system.subnet(), networkInterface, system.IP)
+	default:
+		fmt.Fprintf(&process.stderr, "Object \"%v\" is unknown, try \"ip help\".\n", object)
+		return 1
+	}
+	return 0
+}
+
+func ifconfig(process *process) int {
+	system := process.shell.system
+	received, receivedPackets, sent, sentPackets := system.traffic()
+	fmt.Fprintf(&process.stdout, "%v: flags=4163<UP,BROADCAST,RUNNING,MULTICAST>  mtu 1500\n", networkInterface)
+	fmt.Fprintf(&process.stdout, "        inet %v  netmask 255.255.255.0  broadcast %v.255\n", system.IP, system.subnet())
+	fmt.Fprintf(&process.stdout, "        inet6 %v  prefixlen 64  scopeid 0x20<link>\n", system.linkLocal())
+	fmt.Fprintf(&process.stdout, "        ether %v  txqueuelen 1000  (Ethernet)\n", system.MAC)
+	fmt.Fprintf(&process.stdout, "        RX packets %v  bytes %v (%v)\n        RX errors 0  dropped 0  overruns 0  frame 0\n", receivedPackets, received, formatBytes(received))
+	fmt.Fprintf(&process.stdout, "        TX packets %v  bytes %v (%v)\n        TX errors 0  dropped 0 overruns 0  carrier 0  collisions 0\n\n", sentPackets, sent, formatBytes(sent))
+	loopback := sent / 20
+	fmt.Fprintf(&process.stdout, "lo: flags=73<UP,LOOPBACK,RUNNING>  mtu 65536\n        inet 127.0.0.1  netmask 255.0.0.0\n        inet6 ::1  prefixlen 128  scopeid 0x10<host>\n        loop  txqueuelen 1000  (Local Loopback)\n")
+	fmt.Fprintf(&process.stdout, "        RX packets %v  bytes %v (%v)\n        RX errors 0  dropped 0  overruns 0  frame 0\n", loopback/100, loopback, formatBytes(loopback))
+	fmt.Fprintf(&process.stdout, "        TX packets %v  bytes %v (%v)\n        TX errors 0  dropped 0 overruns 0  carrier 0  collisions 0\n\n", loopback/100, loopback, formatBytes(loopback))
+	return 0
+}
+
+// socketOptions are the options of netstat and ss selecting sockets.
+type socketOptions struct {
+	tcp, udp, listening, all, numeric, processes bool
+}
+
+func parseSocketOptions(args []string) socketOptions {
+	options := socketOptions{}
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") || strings.HasPrefix(arg, "--") {
+			continue
+		}
+		for _, option := range arg[1:] {
+			switch option {
+			case 't':
+				options.tcp = true
+			case 'u':
+				options.udp = true
+			case 'l':
+				options.listening = true
+			case 'a':
+				options.all = true
+			case 'n':
+				options.numeric = true
+			case 'p':
+				options.processes = true
+			}
+		}
+	}
+	if !options.tcp && !options.udp {
+		options.tcp, options.udp = true, true
+	}
+	return options
+}
+
+// selects reports whether options select socket.
+func (options socketOptions) selects(socket socket) bool {
+	if strings.HasPrefix(socket.Protocol, "tcp") && !options.tcp || strings.HasPrefix(socket.Protocol, "udp") && !options.udp {
+		return false
+	}
+	if options.all {
+		return true
+	}
+	listening := socket.State != "ESTAB"
+	return listening == options.listening
+}
+
+// serviceNames are the names of the ports of the sockets, as in
+// /etc/services.
+var serviceNames = map[int]string{22: "ssh", 68: "bootpc"}
+
+// socketAddress formats an address and port of socket like netstat, or like
+// ss if brackets is set.
+func (process *process) socketAddress(address string, port int, numeric, brackets bool) string {
+	portText := strconv.Itoa(port)
+	if port == 0 {
+		portText = "*"
+	} else if name, ok := serviceNames[port]; ok && !numeric {
+		portText = name
+	}
+	if address == process.shell.system.IP && !numeric && !brackets {
+		address = process.shell.system.Hostname
+	}
+	if strings.Contains(address, ":") && brackets {
+		address = "[" + address + "]"
+	}
+	return address + ":" + portText
+}
+
+func netstat(process *process) int {
+	options := parseSocketOptions(process.args[1:])
+	switch {
+	case options.all:
+		process.stdout.WriteString("Active Internet connections (servers and established)\n")
+	case options.listening:
+		process.stdout.WriteString("Active Internet connections (only servers)\n")
+	default:
+		process.stdout.WriteString("Active Internet connections (w/o servers)\n")
+	}
+	if options.processes && !process.isRoot() {
+		process.stderr.WriteString("(Not all processes could be identified, non-owned process info\n will not be shown, you would have to be root to see it all.)\n")
+	}
+	fmt.Fprintf(&process.stdout, "%-5v %-6v %-6v %-23v %-23v %-11v", "Proto", "Recv-Q", "Send-Q", "Local Address", "Foreign Address", "State")
+	if options.processes {
+		process.stdout.WriteString(" PID/Program name    ")
+	}
+	process.stdout.WriteString("\n")
+	for _, socket := range process.shell.sockets() {
+		if !options.selects(socket) {
+			continue
+		}
+		state := map[string]string{"LISTEN": "LISTEN", "ESTAB": "ESTABLISHED", "UNCONN": ""}[socket.State]
+		local := process.socketAddress(socket.Local, socket.LocalPort, options.numeric, false)
+		peer := process.socketAddress(socket.Peer, socket.PeerPort, true, false)
+		fmt.Fprintf(&process.stdout, "%-5v %6v %6v %-23v %-23v %-11v", socket.Protocol, 0, 0, local, peer, state)
+		if options.processes {
+			program := "-"
+			if process.isRoot() {
+				program = fmt.Sprintf("%v/%v", socket.PID, socket.Program)
+				if socket.Program == "sshd" && socket.State == "ESTAB" {
+					program += ": " + process.shell.session.User
+				}
+			}
+			fmt.Fprintf(&process.stdout, " %-20.20v", program)
+		}
+		process.stdout.WriteString("\n")
+	}
+	return 0
+}
+
+func ss(process *process) int {
+	options := parseSocketOptions(process.args[1:])
+	netid := options.tcp && options.udp
+	if netid {
+		fmt.Fprintf(&process.stdout, "%-6v", "Netid")
+	}
+	fmt.Fprintf(&process.stdout, "%-7v %-6v %-6v %24v %-24v", "State", "Recv-Q", "Send-Q", "Local Address:Port", " Peer Address:Port")
+	if options.processes {
+		process.stdout.WriteString(" Process")
+	}
+	process.stdout.WriteString("\n")
+	for _, socket := range process.shell.sockets() {
+		if !options.selects(socket) {
+			continue
+		}
+		if netid {
+			fmt.Fprintf(&process.stdout, "%-6v", strings.TrimSuffix(socket.Protocol, "6"))
+		}
+		local := process.socketAddress(socket.Local, socket.LocalPort, options.numeric, true)
+		if socket.Protocol == "udp" && socket.Local == process.shell.system.IP {
+			local = strings.Replace(local, ":", "%"+networkInterface+":", 1)
+		}
+		peer := process.socketAddress(socket.Peer, socket.PeerPort, true, true)
+		fmt.Fprintf(&process.stdout, "%-7v %-6v %-6v %24v  %-23v", socket.State, 0, socket.SendQueue, local, peer)
+		if options.processes && process.isRoot() {
+			fmt.Fprintf(&process.stdout, " users:((\"%v\",pid=%v,fd=%v))", socket.Program, socket.PID, socket.FileDescriptor)
+		}
+		process.stdout.WriteString("\n")
+	}
+	return 0
+}
@@ -0,0 +1,66 @@
+package shell
+
+import (
+	"github.com/longkeyy/sshesame/config"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestNetworkConsistent(t *testing.T) {
+	captureLog(t)
+	shell := newTestShell(newTestSession("root"), config.Shell{})
+	ip := shell.system.IP
+	gateway := ip[:strings.LastIndex(ip, ".")] + ".1"
+	outputs := map[string]string{}
+	for _, command := range []string{"ip addr", "ifconfig", "ip route", "ss -tn", "netstat -tn"} {
+		stdout, stderr, status := runScript(t, shell, command)
+		if status != 0 || stderr != "" {
+			t.Fatalf("%q = %v, %q", command, status, stderr)
+		}
+		outputs[command] = stdout
+	}
+
+	for command, want := range map[string]string{
+		"ip addr":     "inet " + ip + "/24 ",
+		"ifconfig":    "inet " + ip + "  netmask 255.255.255.0",
+		"ip route":    "default via " + gateway + " dev ens3 proto dhcp src " + ip + " ",
+		"ss -tn":      ip + ":22  192.0.2.1:50000",
+		"netstat -tn": ip + ":22",
+	} {
+		if !strings.Contains(outputs[command], want) {
+			t.Errorf("%q wrote\n%v\nwant it to contain %q", command, outputs[command], want)
+		}
+	}
+	if !strings.Contains(outputs["netstat -tn"], "ESTABLISHED") || !strings.Contains(outputs["netstat -tn"], "192.0.2.1:50000") {
+		t.Errorf("netstat -tn wrote\n%v\nwant the client's connection established", outputs["netstat -tn"])
+	}
+	mac := regexp.MustCompile(`link/ether (52:54:00(:[0-9a-f]{2}){3})`).FindStringSubmatch(outputs["ip addr"])
+	if mac == nil || !strings.Contains(outputs["ifconfig"], "ether "+mac[1]+" ") {
+		t.Errorf("ip addr and ifconfig show different MAC addresses:\n%v\n%v", outputs["ip addr"], outputs["ifconfig"])
+	}
+}
+
+func TestNetworkPortsListening(t *testing.T) {
+	captureLog(t)
+	for _, command := range []string{"ss -tlnp", "netstat -tlnp"} {
+		stdout, _, _ := run(t, config.Shell{}, command)
+		if !strings.Contains(stdout, "0.0.0.0:22") || !strings.Contains(stdout, "sshd") {
+			t.Errorf("%q wrote\n%v\nwant sshd listening on port 22", command, stdout)
+		}
+		if strings.Contains(stdout, "192.0.2.1") {
+			t.Errorf("%q wrote\n%v\nwant only listening sockets", command, stdout)
+		}
+	}
+}
+
+func TestNetworkReconLogged(t *testing.T) {
+	hook := captureLog(t)
+	for _, command := range []string{"ip a", "ifconfig -a", "netstat -antp", "ss -s", "ip route show"} {
+		hook.Reset()
+		run(t, config.Shell{}, command)
+		if entry := lastEntry(hook, "Command executed"); entry == nil || entry.Data["category"] != "network_recon" {
+			t.Errorf("%q logged %v, want it categorized as network_recon", command, entry)
+		}
+	}
+}
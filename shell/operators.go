@@ -0,0 +1,237 @@
+package shell
+
+import (
+	"path"
+	"strconv"
+	"strings"
+)
+
+// pipeline is a command line's commands connected by pipes.
+type pipeline struct {
+	commands []string
+	// operator is the control operator following the pipeline, ;, &, &&
+	// or ||, or "" for the last one.
+	operator string
+}
+
+// splitList splits a command line into its pipelines and their commands at
+// the control operators outside quotes, command substitutions and comments,
+// which are dropped. Empty commands are dropped too.
+func splitList(line string) []pipeline {
+	pipelines := []pipeline{}
+	commands := []string{}
+	var command strings.Builder
+	endCommand := func() {
+		if text := strings.TrimSpace(command.String()); text != "" {
+			commands = append(commands, text)
+		}
+		command.Reset()
+	}
+	endPipeline := func(operator string) {
+		endCommand()
+		if len(commands) != 0 {
+			pipelines = append(pipelines, pipeline{commands, operator})
+		}
+		commands = []string{}
+	}
+	var quote rune
+	escaped := false
+	// substitutions is the depth of the $( command substitutions the
+	// current rune is in.
+	substitutions := 0
+	runes := []rune(line)
+	at := func(i int) rune {
+		if i < 0 || i >= len(runes) {
+			return 0
+		}
+		return runes[i]
+	}
+scan:
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '\'':
+			if r == quote {
+				quote = 0
+			}
+		case r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		case r == '$' && at(i+1) == '(':
+			substitutions++
+			command.WriteString("$(")
+			i++
+			continue
+		case substitutions > 0:
+			if r == ')' {
+				substitutions--
+			}
+		case r == '#' && (i == 0 || strings.ContainsRune(" \t;&|", runes[i-1])):
+			break scan
+		case r == ';':
+			endPipeline(";")
+			continue
+		case r == '&' && at(i+1) == '&':
+			endPipeline("&&")
+			i++
+			continue
+		case r == '|' && at(i+1) == '|':
+			endPipeline("||")
+			i++
+			continue
+		case r == '|' && at(i-1) != '>':
+			endCommand()
+			continue
+		case r == '&' && at(i-1) != '>' && at(i+1) != '>':
+			endPipeline("&")
+			continue
+		}
+		command.WriteRune(r)
+	}
+	endPipeline("")
+	return pipelines
+}
+
+// redirect is a redirection of a command's input or output.
+type redirect struct {
+	// fd is the redirected file descriptor, 1 or 2, or 0 for both, as with
+	// &>.
+	fd int
+	// target is the file redirected to or from, or &1 or &2 to duplicate
+	// a file descriptor.
+	target string
+	append bool
+	input  bool
+}
+
+// parseRedirections removes the redirections from args, other than
+// here-documents, returning them in order.
+func parseRedirections(args []string) ([]string, []redirect) {
+	kept := []string{}
+	redirects := []redirect{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if strings.HasPrefix(arg, "<") && !strings.HasPrefix(arg, "<<") {
+			target := arg[1:]
+			if target == "" && i+1 < len(args) {
+				i++
+				target = args[i]
+			}
+			redirects = append(redirects, redirect{target: target, input: true})
+			continue
+		}
+		word, fd := arg, 1
+		if strings.HasPrefix(word, "&>") {
+			word, fd = word[1:], 0
+		} else if digits := len(word) - len(strings.TrimLeft(word, "0123456789")); digits > 0 {
+			fd, _ = strconv.Atoi(word[:digits])
+			word = word[digits:]
+		}
+		if !strings.HasPrefix(word, ">") {
+			kept = append(kept, arg)
+			continue
+		}
+		target := strings.TrimLeft(word, ">|")
+		if target == "" && i+1 < len(args) {
+			i++
+			target = args[i]
+		}
+		redirects = append(redirects, redirect{fd: fd, target: target, append: strings.HasPrefix(word, ">>")})
+	}
+	return kept, redirects
+}
+
+// executeRedirected executes a command with its redirections, which are
+// applied in order like bash does: output redirected to files puts them on
+// the host, that to /dev/null is discarded.
+func (shell *shell) executeRedirected(args []string, redirects []redirect, stdin string) *process {
+	process := &process{shell: shell, args: args}
+	stdout, stderr := "&1", "&2"
+	// files are the files output is redirected to, in order, with what's
+	// written to them.
+	files := []string{}
+	written := map[string]*strings.Builder{}
+	appended := map[string]bool{}
+	for _, redirect := range redirects {
+		if redirect.input {
+			content, ok := process.readFile(redirect.target)
+			if !ok {
+				process.errorf("%v: No such file or directory\n", redirect.target)
+				process.status = 1
+				return process
+			}
+			stdin = content
+			continue
+		}
+		target := redirect.target
+		switch target {
+		case "&1", "/dev/stdout":
+			target = stdout
+		case "&2", "/dev/stderr":
+			target = stderr
+		case "/dev/null":
+		default:
+			filePath := shell.resolve(target)
+			if process.isDir(filePath) {
+				process.errorf("%v: Is a directory\n", target)
+				process.status = 1
+				return process
+			}
+			if !process.isDir(path.Dir(filePath)) {
+				process.errorf("%v: No such file or directory\n", target)
+				process.status = 1
+				return process
+			}
+			if _, ok := written[filePath]; !ok {
+				files = append(files, filePath)
+				written[filePath] = &strings.Builder{}
+				appended[filePath] = redirect.append
+			}
+			target = filePath
+		}
+		switch redirect.fd {
+		case 0:
+			stdout, stderr = target, target
+		case 1:
+			stdout = target
+		case 2:
+			stderr = target
+		}
+	}
+	if len(args) != 0 {
+		process = shell.execute(args, stdin)
+	}
+	output, errors := process.stdout.String(), process.stderr.String()
+	process.stdout.Reset()
+	process.stderr.Reset()
+	for _, routed := range []struct{ target, content string }{{stdout, output}, {stderr, errors}} {
+		switch routed.target {
+		case "&1":
+			process.stdout.WriteString(routed.content)
+		case "&2":
+			process.stderr.WriteString(routed.content)
+		case "/dev/null":
+		default:
+			written[routed.target].WriteString(routed.content)
+		}
+	}
+	source := "shell"
+	if len(args) != 0 {
+		source = path.Base(args[0])
+	}
+	for _, filePath := range files {
+		content := written[filePath].String()
+		if existing, ok := process.fileContent(filePath, false); ok && appended[filePath] {
+			content = existing + content
+		}
+		shell.session.AddFile(filePath, []byte(content), source)
+	}
+	return process
+}
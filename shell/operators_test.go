@@ -0,0 +1,108 @@
+package shell
+
+import (
+	"github.com/longkeyy/sshesame/config"
+	"reflect"
+	"testing"
+)
+
+func TestSplitList(t *testing.T) {
+	for _, test := range []struct {
+		line      string
+		pipelines []pipeline
+	}{
+		{"", []pipeline{}},
+		{"uname -a", []pipeline{{[]string{"uname -a"}, ""}}},
+		{"cd /tmp; wget http://x/a && chmod +x a || rm a &", []pipeline{
+			{[]string{"cd /tmp"}, ";"},
+			{[]string{"wget http://x/a"}, "&&"},
+			{[]string{"chmod +x a"}, "||"},
+			{[]string{"rm a"}, "&"},
+		}},
+		{"cat /etc/passwd | grep root | wc -l", []pipeline{{[]string{"cat /etc/passwd", "grep root", "wc -l"}, ""}}},
+		// Operators in quotes, substitutions and redirections don't split.
+		{`echo "a;b" 'c|d' $(id; w) >| x 2>&1 &> y; ;`, []pipeline{{[]string{`echo "a;b" 'c|d' $(id; w) >| x 2>&1 &> y`}, ";"}}},
+		{`echo a\;b`, []pipeline{{[]string{`echo a\;b`}, ""}}},
+		// Comments are dropped, but not # within words.
+		{"echo a#b # c; d", []pipeline{{[]string{"echo a#b"}, ""}}},
+	} {
+		if pipelines := splitList(test.line); !reflect.DeepEqual(pipelines, test.pipelines) {
+			t.Errorf("splitList(%q) = %q, want %q", test.line, pipelines, test.pipelines)
+		}
+	}
+}
+
+func TestParseRedirections(t *testing.T) {
+	args, redirects := parseRedirections([]string{"cat", "<", "in", "a", ">out", "2>>", "log", "&>all", "2>&1", "<<EOF", "1>|f"})
+	if want := []string{"cat", "a", "<<EOF"}; !reflect.DeepEqual(args, want) {
+		t.Errorf("parseRedirections kept %q, want %q", args, want)
+	}
+	want := []redirect{
+		{target: "in", input: true},
+		{fd: 1, target: "out"},
+		{fd: 2, target: "log", append: true},
+		{fd: 0, target: "all"},
+		{fd: 2, target: "&1"},
+		{fd: 1, target: "f"},
+	}
+	if !reflect.DeepEqual(redirects, want) {
+		t.Errorf("parseRedirections = %+v, want %+v", redirects, want)
+	}
+}
+
+func TestCommandLists(t *testing.T) {
+	for _, test := range []struct {
+		line           string
+		stdout, stderr string
+		status         int
+	}{
+		{"echo a; echo b", "a\nb\n", "", 0},
+		{"false && echo a; echo b", "b\n", "", 0},
+		{"true || echo a", "", "", 0},
+		{"false || echo a && echo b", "a\nb\n", "", 0},
+		{"true && false", "", "", 1},
+		{"nosuch || echo a", "a\n", "bash: line 1: nosuch: command not found\n", 0},
+		// Pipelines end as their last command does, and show the errors of
+		// every command.
+		{"echo a | nosuch | echo b", "b\n", "bash: line 1: nosuch: command not found\n", 0},
+		{"echo root | grep -c root", "1\n", "", 0},
+		{"echo root | grep admin", "", "", 1},
+	} {
+		stdout, stderr, status := runScript(t, newTestShell(newTestSession("root"), config.Shell{}), test.line)
+		if stdout != test.stdout || stderr != test.stderr || status != test.status {
+			t.Errorf("%q wrote %q, %q and exited with %v, want %q, %q and %v", test.line, stdout, stderr, status, test.stdout, test.stderr, test.status)
+		}
+	}
+}
+
+func TestRedirections(t *testing.T) {
+	captureLog(t)
+	shell := newTestShell(newTestSession("root"), config.Shell{})
+	for _, test := range []struct {
+		line           string
+		stdout, stderr string
+		status         int
+	}{
+		{"echo a > /tmp/x", "", "", 0},
+		{"echo b >> /tmp/x", "", "", 0},
+		{"cat /tmp/x", "a\nb\n", "", 0},
+		{"echo c >/tmp/x; cat < /tmp/x", "c\n", "", 0},
+		{"nosuch 2> /tmp/err; cat /tmp/err", "bash: line 1: nosuch: command not found\n", "", 0},
+		{"nosuch 2>&1 | wc -l", "1\n", "", 0},
+		{"nosuch &> /dev/null", "", "", 127},
+		{"echo a > /tmp", "", "bash: line 1: /tmp: Is a directory\n", 1},
+		{"echo a > /nosuch/x", "", "bash: line 1: /nosuch/x: No such file or directory\n", 1},
+		{"cat < /nosuch", "", "bash: line 1: /nosuch: No such file or directory\n", 1},
+	} {
+		stdout, stderr, status := runScript(t, shell, test.line)
+		if stdout != test.stdout || stderr != test.stderr || status != test.status {
+			t.Errorf("%q wrote %q, %q and exited with %v, want %q, %q and %v", test.line, stdout, stderr, status, test.stdout, test.stderr, test.status)
+		}
+	}
+	// Files redirected to are put on the host by the command writing them.
+	for path, want := range map[string]string{"/tmp/x": "c\n", "/tmp/err": "bash: line 1: nosuch: command not found\n"} {
+		if file, ok := shell.session.File(path); !ok || string(file.Content) != want || file.Source != map[string]string{"/tmp/x": "echo", "/tmp/err": "nosuch"}[path] {
+			t.Errorf("%v = %+v, %v, want %q", path, file, ok, want)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package shell
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"path"
+	"strings"
+)
+
+// packageManagers map package managers to the subcommands installing
+// packages.
+var packageManagers = map[string][]string{
+	"apk":     {"add"},
+	"apt":     {"install"},
+	"apt-get": {"install"},
+	"dnf":     {"install"},
+	"gem":     {"install"},
+	"npm":     {"install", "i", "add"},
+	"pip":     {"install"},
+	"pip3":    {"install"},
+	"yum":     {"install"},
+}
+
+// packageOptionsWithValues are options of package managers taking a value,
+// which isn't a package.
+var packageOptionsWithValues = map[string]bool{
+	"-c": true, "-e": true, "-f": true, "-i": true, "-o": true, "-r": true, "-t": true,
+	"--config": true, "--constraint": true, "--editable": true, "--extra-index-url": true,
+	"--find-links": true, "--index-url": true, "--option": true, "--prefix": true,
+	"--registry": true, "--requirement": true, "--target": true, "--target-release": true,
+}
+
+// packageInstall returns the package manager and the packages, URLs and
+// requirement files a command asks to install, or "" if it doesn't.
+func packageInstall(args []string) (string, []string) {
+	manager := path.Base(args[0])
+	rest := args[1:]
+	if (manager == "python" || manager == "python3") && len(rest) >= 2 && rest[0] == "-m" && rest[1] == "pip" {
+		manager, rest = "pip", rest[2:]
+	}
+	subcommands, ok := packageManagers[manager]
+	if !ok {
+		return "", nil
+	}
+	install := -1
+	for i, arg := range rest {
+		if strings.HasPrefix(arg, "-") {
+			continue
+		}
+		for _, subcommand := range subcommands {
+			if arg == subcommand {
+				install = i
+			}
+		}
+		break
+	}
+	if install == -1 {
+		return "", nil
+	}
+	packages := []string{}
+	for i := install + 1; i < len(rest); i++ {
+		arg := rest[i]
+		if packageOptionsWithValues[arg] && i+1 < len(rest) {
+			if arg == "-r" || arg == "--requirement" || arg == "-e" || arg == "--editable" || strings.HasSuffix(arg, "-url") || arg == "--registry" {
+				packages = append(packages, rest[i+1])
+			}
+			i++
+			continue
+		}
+		if arg != "" && !strings.HasPrefix(arg, "-") {
+			packages = append(packages, arg)
+		}
+	}
+	return manager, packages
+}
+
+// logPackageInstall logs an attempt to install packages.
+func (shell *shell) logPackageInstall(manager string, packages []string) {
+	log.WithFields(log.Fields{
+		"client":   shell.session.RemoteAddr,
+		"channel":  "session",
+		"manager":  manager,
+		"packages": packages,
+		"category": "package_install_attempt",
+	}).Info("Package installation attempted")
+}
+
+// mirrorHost returns the host of the mirror at url, which the fake host can't
+// resolve.
+func mirrorHost(url string) string {
+	return strings.SplitN(strings.TrimPrefix(url, "http://"), "/", 2)[0]
+}
+
+// aptGet emulates apt-get and apt failing to reach the package mirror, like
+// hosts without DNS do, so that nothing seems to be installed.
+func aptGet(process *process) int {
+	profile := process.shell.system.Profile
+	subcommand := ""
+	for _, arg := range process.args[1:] {
+		if !strings.HasPrefix(arg, "-") {
+			subcommand = arg
+			break
+		}
+	}
+	switch subcommand {
+	case "update", "install", "upgrade", "dist-upgrade", "full-upgrade":
+	case "":
+		fmt.Fprintf(&process.stdout, "apt %v (%v)\nUsage: %v [options] command\n", profile.AptVersion, profile.DpkgArch, process.args[0])
+		return 1
+	default:
+		fmt.Fprintf(&process.stderr, "E: Invalid operation %v\n", subcommand)
+		return 100
+	}
+	if !process.isRoot() {
+		process.stderr.WriteString("E: Could not open lock file /var/lib/dpkg/lock-frontend - open (13: Permission denied)\n")
+		process.stderr.WriteString("E: Unable to acquire the dpkg frontend lock (/var/lib/dpkg/lock-frontend), are you root?\n")
+		return 100
+	}
+	main := profile.AptSources[0]
+	if subcommand == "update" {
+		for i, source := range profile.AptSources {
+			fmt.Fprintf(&process.stdout, "Err:%v %v %v InRelease\n  Temporary failure resolving '%v'\n", i+1, source.URL, source.Suite, mirrorHost(source.URL))
+		}
+		process.stdout.WriteString("Reading package lists... Done\n")
+		fmt.Fprintf(&process.stderr, "W: Failed to fetch %v/dists/%v/InRelease  Temporary failure resolving '%v'\n", main.URL, main.Suite, mirrorHost(main.URL))
+		process.stderr.WriteString("W: Some index files failed to download. They have been ignored, or old ones used instead.\n")
+		return 0
+	}
+	process.stdout.WriteString("Reading package lists... Done\nBuilding dependency tree... Done\nReading state information... Done\n")
+	_, packages := packageInstall(process.args)
+	if subcommand != "install" || len(packages) == 0 {
+		process.stdout.WriteString("Calculating upgrade... Done\n0 upgraded, 0 newly installed, 0 to remove and 0 not upgraded.\n")
+		return 0
+	}
+	fmt.Fprintf(&process.stdout, "The following NEW packages will be installed:\n  %v\n", strings.Join(packages, " "))
+	fmt.Fprintf(&process.stdout, "0 upgraded, %v newly installed, 0 to remove and 12 not upgraded.\n", len(packages))
+	for i, name := range packages {
+		fmt.Fprintf(&process.stdout, "Err:%v %v %v/%v %v %v %v\n  Temporary failure resolving '%v'\n", i+1, main.URL, main.Suite, profile.AptComponent, profile.DpkgArch, name, profile.DpkgArch, mirrorHost(main.URL))
+	}
+	fmt.Fprintf(&process.stderr, "E: Failed to fetch %v/pool/%v/%v/%v  Temporary failure resolving '%v'\n", main.URL, profile.AptComponent, packages[0][:1], packages[0], mirrorHost(main.URL))
+	process.stderr.WriteString("E: Unable to fetch some archives, maybe run apt-get update or try with --fix-missing?\n")
+	return 100
+}
+
+// pip emulates pip failing to reach the package index.
+func pip(process *process) int {
+	manager, packages := packageInstall(process.args)
+	if manager == "" {
+		fmt.Fprintf(&process.stdout, "\nUsage:   \n  %v <command> [options]\n", process.args[0])
+		return 0
+	}
+	for i, arg := range process.args {
+		if (arg == "-r" || arg == "--requirement") && i+1 < len(process.args) {
+			if _, ok := process.readFile(process.args[i+1]); !ok {
+				fmt.Fprintf(&process.stderr, "ERROR: Could not open requirements file: [Errno 2] No such file or directory: '%v'\n", process.args[i+1])
+				return 1
+			}
+		}
+	}
+	if len(packages) == 0 {
+		process.stderr.WriteString("ERROR: You must give at least one requirement to install (see \"pip help install\")\n")
+		return 1
+	}
+	for retry := 1; retry <= 4; retry++ {
+		fmt.Fprintf(&process.stderr, "WARNING: Retrying (Retry(total=%v, connect=None, read=None, redirect=None, status=None)) after connection broken by 'NewConnectionError('<pip._vendor.urllib3.connection.HTTPSConnection object at 0x7f3b2c1e4d30>: Failed to establish a new connection: [Errno -3] Temporary failure in name resolution')': /simple/%v/\n", 5-retry, packages[0])
+	}
+	fmt.Fprintf(&process.stderr, "ERROR: Could not find a version that satisfies the requirement %v (from versions: none)\n", packages[0])
+	fmt.Fprintf(&process.stderr, "ERROR: No matching distribution found for %v\n", packages[0])
+	return 1
+}
+
+// npm emulates npm failing to reach the registry.
+func npm(process *process) int {
+	manager, packages := packageInstall(process.args)
+	if manager == "" {
+		process.stdout.WriteString("npm <command>\n\nUsage:\n\nnpm install        install all the dependencies in your project\n")
+		return 1
+	}
+	name := "package.json"
+	if len(packages) != 0 {
+		name = packages[0]
+	}
+	process.stderr.WriteString("npm ERR! code EAI_AGAIN\nnpm ERR! syscall getaddrinfo\nnpm ERR! errno EAI_AGAIN\n")
+	fmt.Fprintf(&process.stderr, "npm ERR! request to https://registry.npmjs.org/%v failed, reason: getaddrinfo EAI_AGAIN registry.npmjs.org\n", name)
+	process.stderr.WriteString("\nnpm ERR! A complete log of this run can be found in:\nnpm ERR!     /root/.npm/_logs/2024-01-15T09_12_44_873Z-debug-0.log\n")
+	return 1
+}
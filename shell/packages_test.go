@@ -0,0 +1,83 @@
+package shell
+
+import (
+	"github.com/longkeyy/sshesame/config"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPackageInstallLogged(t *testing.T) {
+	hook := captureLog(t)
+	for _, test := range []struct {
+		command  string
+		manager  string
+		packages []string
+	}{
+		{"apt-get install nmap", "apt-get", []string{"nmap"}},
+		{"apt install -y -t bookworm-backports masscan hydra", "apt", []string{"masscan", "hydra"}},
+		{"yum -y install nc", "yum", []string{"nc"}},
+		{"pip3 install --index-url http://198.51.100.1/simple paramiko", "pip3", []string{"http://198.51.100.1/simple", "paramiko"}},
+		{"python3 -m pip install impacket", "pip", []string{"impacket"}},
+		{"npm i -g miner-cli", "npm", []string{"miner-cli"}},
+	} {
+		hook.Reset()
+		run(t, config.Shell{}, test.command)
+		entry := lastEntry(hook, "Package installation attempted")
+		if entry == nil {
+			t.Errorf("%q logged no installation", test.command)
+			continue
+		}
+		if entry.Data["manager"] != test.manager || !reflect.DeepEqual(entry.Data["packages"], test.packages) || entry.Data["category"] != "package_install_attempt" {
+			t.Errorf("%q logged %v, want %v installing %v", test.command, entry.Data, test.manager, test.packages)
+		}
+	}
+	for _, command := range []string{"apt-get update", "pip list", "npm --version", "echo apt-get install nmap"} {
+		hook.Reset()
+		run(t, config.Shell{}, command)
+		if entry := lastEntry(hook, "Package installation attempted"); entry != nil {
+			t.Errorf("%q logged an installation of %v", command, entry.Data["packages"])
+		}
+	}
+}
+
+func TestPackageInstallOutput(t *testing.T) {
+	captureLog(t)
+	stdout, stderr, status := run(t, config.Shell{}, "apt-get install nmap")
+	if status != 100 || !strings.Contains(stdout, "The following NEW packages will be installed:\n  nmap\n") || !strings.Contains(stderr, "E: Unable to fetch some archives") {
+		t.Errorf("apt-get install nmap = %v, %q, %q, want the mirror unreachable", status, stdout, stderr)
+	}
+	if !strings.Contains(stderr, "/n/nmap") || !strings.Contains(stderr, "Temporary failure resolving") {
+		t.Errorf("apt-get install nmap wrote %q, want a failed fetch of nmap", stderr)
+	}
+	if _, stderr, status := runScript(t, newTestShell(newTestSession("alice"), config.Shell{}), "apt-get install nmap"); status != 100 || !strings.Contains(stderr, "are you root?") {
+		t.Errorf("apt-get install as alice = %v, %q, want the dpkg lock denied", status, stderr)
+	}
+	if _, stderr, status := run(t, config.Shell{}, "pip install scapy"); status != 1 || !strings.Contains(stderr, "No matching distribution found for scapy") {
+		t.Errorf("pip install scapy = %v, %q", status, stderr)
+	}
+	if _, stderr, status := run(t, config.Shell{}, "npm install miner-cli"); status != 1 || !strings.Contains(stderr, "https://registry.npmjs.org/miner-cli failed") {
+		t.Errorf("npm install miner-cli = %v, %q", status, stderr)
+	}
+}
+
+func TestPackageInstallResponse(t *testing.T) {
+	hook := captureLog(t)
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "apt-get"), []byte("nmap is already the newest version (7.93+dfsg1-1).\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	responses, err := config.LoadResponses(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, _, status := run(t, config.Shell{Responses: responses}, "apt-get install nmap")
+	if status != 0 || stdout != "nmap is already the newest version (7.93+dfsg1-1).\n" {
+		t.Errorf("apt-get install nmap = %v, %q, want the configured output", status, stdout)
+	}
+	if entry := lastEntry(hook, "Package installation attempted"); entry == nil || !reflect.DeepEqual(entry.Data["packages"], []string{"nmap"}) {
+		t.Errorf("configured apt-get logged %v, want the installation of nmap", entry)
+	}
+}
@@ -0,0 +1,110 @@
+package shell
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// servicePath is the PATH of the services of a System.
+const servicePath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// procUptime returns /proc/uptime, the seconds since boot and those the CPUs
+// spent idle, agreeing with uptime.
+func procUptime(system *System) string {
+	uptime := system.Uptime(time.Now()).Seconds()
+	return fmt.Sprintf("%.2f %.2f\n", uptime, uptime*float64(system.CPUCores)*0.97)
+}
+
+// procLoadavg returns /proc/loadavg, agreeing with uptime and ps.
+func procLoadavg(system *System) string {
+	return fmt.Sprintf("%.2f %.2f %.2f 1/%v %v\n", system.LoadAverage[0], system.LoadAverage[1], system.LoadAverage[2], len(system.Processes)+2, system.ShellPID)
+}
+
+// nulSeparated joins fields like /proc/<pid>/environ and cmdline do.
+func nulSeparated(fields []string) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.Join(fields, "\x00") + "\x00"
+}
+
+// procFile returns the content of /proc/<pid>/cmdline or environ at filePath,
+// where pid is self for process itself, that of the shell running it or one
+// of the processes of the System.
+func (process *process) procFile(filePath string) (string, bool) {
+	parts := strings.Split(filePath, "/")
+	if len(parts) != 4 || parts[1] != "proc" || (parts[3] != "cmdline" && parts[3] != "environ") {
+		return "", false
+	}
+	system := process.shell.system
+	var cmdline, environ []string
+	switch pid, err := strconv.Atoi(parts[2]); {
+	case parts[2] == "self":
+		cmdline = process.args
+		environ = append(process.shell.environ(), "_=/usr/bin/"+process.args[0])
+	case err == nil && pid == system.ShellPID:
+		cmdline = []string{"-bash"}
+		environ = process.shell.environ()
+	case err == nil:
+		found := false
+		for _, p := range system.Processes {
+			if p.PID != pid {
+				continue
+			}
+			found = true
+			// Kernel threads have neither.
+			if !strings.HasPrefix(p.Command, "[") {
+				cmdline = strings.Fields(p.Command)
+				environ = []string{"LANG=C.UTF-8", "PATH=" + servicePath}
+			}
+		}
+		if !found {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+	if parts[3] == "cmdline" {
+		return nulSeparated(cmdline), true
+	}
+	return nulSeparated(environ), true
+}
+
+// isProcDir reports whether dir is the /proc directory of a process.
+func (system *System) isProcDir(dir string) bool {
+	if dir == "/proc/self" || dir == fmt.Sprintf("/proc/%v", system.ShellPID) {
+		return true
+	}
+	for _, p := range system.Processes {
+		if dir == fmt.Sprintf("/proc/%v", p.PID) {
+			return true
+		}
+	}
+	return false
+}
+
+// environ returns the environment of the shell as name=value pairs, sorted
+// so that they read the same every time.
+func (shell *shell) environ() []string {
+	environment := shell.environment()
+	pairs := make([]string, 0, len(environment))
+	for name, value := range environment {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+// logProcRead logs a read of a file under /proc.
+func (shell *shell) logProcRead(filePath string) {
+	log.WithFields(log.Fields{
+		"client":   shell.session.RemoteAddr,
+		"channel":  "session",
+		"path":     filePath,
+		"category": "proc_access",
+	}).Info("Proc file read")
+}
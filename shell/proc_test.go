@@ -0,0 +1,111 @@
+package shell
+
+import (
+	"fmt"
+	"github.com/longkeyy/sshesame/config"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcVersion(t *testing.T) {
+	captureLog(t)
+	for _, name := range []string{"ubuntu-20.04-amd64", "debian-12-arm64"} {
+		shell := newTestShell(newTestSession("root"), config.Shell{Profile: name})
+		version := output(t, shell, "cat /proc/version")
+		if want := "Linux version " + Profiles[name].KernelRelease + " (" + Profiles[name].KernelBuilder + ") "; !strings.HasPrefix(version, want) {
+			t.Errorf("%v: /proc/version = %q, want it to start with %q", name, version, want)
+		}
+		if release := output(t, shell, "uname -r"); !strings.Contains(version, " "+strings.TrimSpace(release)+" ") {
+			t.Errorf("%v: /proc/version = %q, want uname -r's %q", name, version, release)
+		}
+	}
+}
+
+func TestProcUptime(t *testing.T) {
+	captureLog(t)
+	shell := newTestShell(newTestSession("root"), config.Shell{})
+	read := func() float64 {
+		fields := strings.Fields(output(t, shell, "cat /proc/uptime"))
+		if len(fields) != 2 {
+			t.Fatalf("/proc/uptime has fields %q, want 2", fields)
+		}
+		seconds, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return seconds
+	}
+	first := read()
+	if want := shell.system.Uptime(time.Now()).Seconds(); math.Abs(first-want) > 1 {
+		t.Errorf("/proc/uptime = %v, want the host's uptime of %v", first, want)
+	}
+	if want := fmt.Sprintf(" up %v days, ", int(first)/86400); !strings.Contains(output(t, shell, "uptime"), want) {
+		t.Errorf("uptime disagrees with /proc/uptime's %vs, want %q", first, want)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if second := read(); second <= first {
+		t.Errorf("/proc/uptime went from %v to %v, want it to keep counting", first, second)
+	}
+}
+
+func TestProcLoadavg(t *testing.T) {
+	captureLog(t)
+	shell := newTestShell(newTestSession("root"), config.Shell{})
+	fields := strings.Fields(output(t, shell, "cat /proc/loadavg"))
+	if len(fields) != 5 || fields[4] != strconv.Itoa(shell.system.ShellPID) {
+		t.Fatalf("/proc/loadavg has fields %q, want the shell's PID last", fields)
+	}
+	if want := "load average: " + strings.Join(fields[:3], ", ") + "\n"; !strings.HasSuffix(output(t, shell, "uptime"), want) {
+		t.Errorf("uptime disagrees with /proc/loadavg's %v", fields[:3])
+	}
+}
+
+func TestProcSelf(t *testing.T) {
+	hook := captureLog(t)
+	shell := newTestShell(newTestSession("root"), config.Shell{})
+	if cmdline := output(t, shell, "cat /proc/self/cmdline"); cmdline != "cat\x00/proc/self/cmdline\x00" {
+		t.Errorf("/proc/self/cmdline = %q, want cat's arguments", cmdline)
+	}
+	environ := output(t, shell, "cat /proc/self/environ")
+	for _, want := range []string{"HOME=/root\x00", "USER=root\x00", "PATH=" + servicePath + "\x00", "_=/usr/bin/cat\x00"} {
+		if !strings.Contains(environ, want) {
+			t.Errorf("/proc/self/environ = %q, want %q", environ, want)
+		}
+	}
+	if again := output(t, shell, "cat /proc/self/environ"); again != environ {
+		t.Errorf("/proc/self/environ read %q, then %q", environ, again)
+	}
+	if entry := lastEntry(hook, "Proc file read"); entry == nil || entry.Data["path"] != "/proc/self/environ" || entry.Data["category"] != "proc_access" {
+		t.Errorf("logged %v, want the read of /proc/self/environ", entry)
+	}
+
+	pid := strconv.Itoa(shell.system.ShellPID)
+	if echoed := output(t, shell, "echo $$"); echoed != pid+"\n" {
+		t.Errorf("echo $$ = %q, want %v", echoed, pid)
+	}
+	if cmdline := output(t, shell, "cat /proc/"+pid+"/cmdline"); cmdline != "-bash\x00" {
+		t.Errorf("/proc/%v/cmdline = %q, want the login shell's", pid, cmdline)
+	}
+	if cmdline := output(t, shell, "cat /proc/1/cmdline"); cmdline != "/sbin/init\x00" {
+		t.Errorf("/proc/1/cmdline = %q, want init's", cmdline)
+	}
+	if listed := output(t, shell, "ls /proc/self"); listed != "cmdline  environ\n" {
+		t.Errorf("ls /proc/self = %q, want cmdline and environ", listed)
+	}
+	if listed := strings.Fields(output(t, shell, "ls /proc")); !contains(listed, "self") || !contains(listed, "1") || !contains(listed, pid) {
+		t.Errorf("ls /proc = %q, want self and the processes", listed)
+	}
+}
+
+// contains reports whether names has name.
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
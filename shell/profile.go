@@ -0,0 +1,563 @@
+package shell
+
+import (
+	"fmt"
+	"github.com/longkeyy/sshesame/config"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DefaultProfile is the name of the profile hosts follow unless another one is
+// configured.
+const DefaultProfile = "ubuntu-22.04-amd64"
+
+// Profile is the distribution, kernel and hardware a System is built from, so
+// that every command and file revealing them tells the same story.
+type Profile struct {
+	// KernelRelease and KernelVersion are the release and version shown by
+	// uname, of a kernel built by KernelBuilder with KernelCompiler.
+	KernelRelease  string
+	KernelVersion  string
+	KernelBuilder  string
+	KernelCompiler string
+	// Machine is the hardware name shown by uname -m, Processor that shown
+	// by uname -p and -i, unknown if the distribution doesn't report it.
+	Machine   string
+	Processor string
+	// DpkgArch is the architecture packages are built for.
+	DpkgArch string
+	// PrettyName is the name of the distribution and its version, whose
+	// details are in OSRelease, the contents of /etc/os-release.
+	PrettyName string
+	OSRelease  string
+	// LSBRelease is the contents of /etc/lsb-release, empty if there is
+	// none, and DebianVersion those of /etc/debian_version.
+	LSBRelease    string
+	DebianVersion string
+	// BashVersion is the version of bash, like $BASH_VERSION, and
+	// UtilLinuxVersion that of util-linux, which provides lsblk and fdisk.
+	BashVersion      string
+	UtilLinuxVersion string
+	// AptVersion is the version of apt, which fetches packages of
+	// AptComponent from AptSources.
+	AptVersion   string
+	AptSources   []aptSource
+	AptComponent string
+	// DefaultUser is the account cloud images of the distribution come with.
+	DefaultUser string
+	// CPUs are the processors hosts may have, HardwareVendor and
+	// HardwareModel the virtual machine they run in.
+	CPUs           []cpu
+	HardwareVendor string
+	HardwareModel  string
+	// ServerVersion is the identification of the host's SSH server, and
+	// HostKeyTypes the types of the host keys it offers, in order, every
+	// one if empty.
+	ServerVersion string
+	HostKeyTypes  []string
+	// Banner, if set, is shown to clients before they authenticate.
+	Banner string
+	// Prompt is the prompt of interactive shells unless shell_prompt sets
+	// another one, DefaultPrompt if empty.
+	Prompt string
+	// Commands, if set, are the only emulated commands of a host without a
+	// Unix shell, which doesn't show the last login either, and Responses
+	// canned responses consulted after the configured ones, {hostname}
+	// standing for the host's name up to the first dot in their output.
+	// CommandNotFound is the error of commands not found, a format taking
+	// their name, bash's if empty.
+	Commands        []string
+	Responses       config.Responses
+	CommandNotFound string
+}
+
+// aptSource is a suite of packages served by a mirror.
+type aptSource struct {
+	URL, Suite string
+}
+
+// cpu is a processor model.
+type cpu struct {
+	model string
+	// mhz is the clock speed, 0 for processors that don't report it.
+	mhz      float64
+	bogomips float64
+}
+
+var (
+	x86CPUs = []cpu{
+		{"Intel(R) Xeon(R) CPU E5-2650 v4 @ 2.20GHz", 2199.998, 4399.99},
+		{"Intel(R) Xeon(R) Gold 6140 CPU @ 2.30GHz", 2294.608, 4589.21},
+		{"AMD EPYC 7402P 24-Core Processor", 2800.000, 5600.00},
+		{"Intel(R) Core(TM) i7-7700 CPU @ 3.60GHz", 3600.000, 7200.00},
+	}
+	armCPUs = []cpu{
+		{"Neoverse-N1", 0, 243.75},
+		{"Cortex-A72", 0, 108.00},
+	}
+)
+
+// ubuntuSources returns the suites of the Ubuntu release codename.
+func ubuntuSources(codename string) []aptSource {
+	return []aptSource{
+		{"http://archive.ubuntu.com/ubuntu", codename},
+		{"http://archive.ubuntu.com/ubuntu", codename + "-updates"},
+		{"http://archive.ubuntu.com/ubuntu", codename + "-backports"},
+		{"http://security.ubuntu.com/ubuntu", codename + "-security"},
+	}
+}
+
+// Profiles are the profiles hosts can follow, by name.
+var Profiles = map[string]*Profile{
+	"ubuntu-20.04-amd64": {
+		KernelRelease:  "5.4.0-169-generic",
+		KernelVersion:  "#187-Ubuntu SMP Thu Nov 23 14:52:28 UTC 2023",
+		KernelBuilder:  "buildd@lcy02-amd64-102",
+		KernelCompiler: "gcc version 9.4.0 (Ubuntu 9.4.0-1ubuntu1~20.04.2)",
+		Machine:        "x86_64",
+		Processor:      "x86_64",
+		DpkgArch:       "amd64",
+		PrettyName:     "Ubuntu 20.04.6 LTS",
+		OSRelease: `NAME="Ubuntu"
+VERSION="20.04.6 LTS (Focal Fossa)"
+ID=ubuntu
+ID_LIKE=debian
+PRETTY_NAME="Ubuntu 20.04.6 LTS"
+VERSION_ID="20.04"
+HOME_URL="https://www.ubuntu.com/"
+SUPPORT_URL="https://help.ubuntu.com/"
+BUG_REPORT_URL="https://bugs.launchpad.net/ubuntu/"
+PRIVACY_POLICY_URL="https://www.ubuntu.com/legal/terms-and-policies/privacy-policy"
+VERSION_CODENAME=focal
+UBUNTU_CODENAME=focal
+`,
+		LSBRelease:       "DISTRIB_ID=Ubuntu\nDISTRIB_RELEASE=20.04\nDISTRIB_CODENAME=focal\nDISTRIB_DESCRIPTION=\"Ubuntu 20.04.6 LTS\"\n",
+		DebianVersion:    "bullseye/sid",
+		BashVersion:      "5.0.17(1)-release",
+		UtilLinuxVersion: "2.34",
+		AptVersion:       "2.0.10",
+		AptSources:       ubuntuSources("focal"),
+		AptComponent:     "universe",
+		DefaultUser:      "ubuntu",
+		CPUs:             x86CPUs,
+		HardwareVendor:   "QEMU",
+		HardwareModel:    "Standard PC _i440FX + PIIX, 1996_",
+		ServerVersion:    "SSH-2.0-OpenSSH_8.2p1 Ubuntu-4ubuntu0.11",
+	},
+	"ubuntu-22.04-amd64": {
+		KernelRelease:  "5.15.0-91-generic",
+		KernelVersion:  "#101-Ubuntu SMP Tue Nov 14 13:30:08 UTC 2023",
+		KernelBuilder:  "buildd@lcy02-amd64-045",
+		KernelCompiler: "gcc (Ubuntu 11.4.0-1ubuntu1~22.04) 11.4.0, GNU ld (GNU Binutils for Ubuntu) 2.38",
+		Machine:        "x86_64",
+		Processor:      "x86_64",
+		DpkgArch:       "amd64",
+		PrettyName:     "Ubuntu 22.04.3 LTS",
+		OSRelease: `PRETTY_NAME="Ubuntu 22.04.3 LTS"
+NAME="Ubuntu"
+VERSION_ID="22.04"
+VERSION="22.04.3 LTS (Jammy Jellyfish)"
+VERSION_CODENAME=jammy
+ID=ubuntu
+ID_LIKE=debian
+HOME_URL="https://www.ubuntu.com/"
+SUPPORT_URL="https://help.ubuntu.com/"
+BUG_REPORT_URL="https://bugs.launchpad.net/ubuntu/"
+PRIVACY_POLICY_URL="https://www.ubuntu.com/legal/terms-and-policies/privacy-policy"
+UBUNTU_CODENAME=jammy
+`,
+		LSBRelease:       "DISTRIB_ID=Ubuntu\nDISTRIB_RELEASE=22.04\nDISTRIB_CODENAME=jammy\nDISTRIB_DESCRIPTION=\"Ubuntu 22.04.3 LTS\"\n",
+		DebianVersion:    "bookworm/sid",
+		BashVersion:      "5.1.16(1)-release",
+		UtilLinuxVersion: "2.37.2",
+		AptVersion:       "2.4.11",
+		AptSources:       ubuntuSources("jammy"),
+		AptComponent:     "universe",
+		DefaultUser:      "ubuntu",
+		CPUs:             x86CPUs,
+		HardwareVendor:   "QEMU",
+		HardwareModel:    "Standard PC _i440FX + PIIX, 1996_",
+		ServerVersion:    "SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.6",
+	},
+	"ubuntu-24.04-amd64": {
+		KernelRelease:  "6.8.0-31-generic",
+		KernelVersion:  "#31-Ubuntu SMP PREEMPT_DYNAMIC Sat Apr 20 00:40:06 UTC 2024",
+		KernelBuilder:  "buildd@lcy02-amd64-080",
+		KernelCompiler: "x86_64-linux-gnu-gcc-13 (Ubuntu 13.2.0-23ubuntu4) 13.2.0, GNU ld (GNU Binutils for Ubuntu) 2.42",
+		Machine:        "x86_64",
+		Processor:      "x86_64",
+		DpkgArch:       "amd64",
+		PrettyName:     "Ubuntu 24.04 LTS",
+		OSRelease: `PRETTY_NAME="Ubuntu 24.04 LTS"
+NAME="Ubuntu"
+VERSION_ID="24.04"
+VERSION="24.04 LTS (Noble Numbat)"
+VERSION_CODENAME=noble
+ID=ubuntu
+ID_LIKE=debian
+HOME_URL="https://www.ubuntu.com/"
+SUPPORT_URL="https://help.ubuntu.com/"
+BUG_REPORT_URL="https://bugs.launchpad.net/ubuntu/"
+PRIVACY_POLICY_URL="https://www.ubuntu.com/legal/terms-and-policies/privacy-policy"
+UBUNTU_CODENAME=noble
+LOGO=ubuntu-logo
+`,
+		LSBRelease:       "DISTRIB_ID=Ubuntu\nDISTRIB_RELEASE=24.04\nDISTRIB_CODENAME=noble\nDISTRIB_DESCRIPTION=\"Ubuntu 24.04 LTS\"\n",
+		DebianVersion:    "trixie/sid",
+		BashVersion:      "5.2.21(1)-release",
+		UtilLinuxVersion: "2.39.3",
+		AptVersion:       "2.7.14",
+		AptSources:       ubuntuSources("noble"),
+		AptComponent:     "universe",
+		DefaultUser:      "ubuntu",
+		CPUs:             x86CPUs,
+		HardwareVendor:   "QEMU",
+		HardwareModel:    "Standard PC _i440FX + PIIX, 1996_",
+		ServerVersion:    "SSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu13",
+	},
+	"debian-12-arm64": {
+		KernelRelease:  "6.1.0-17-arm64",
+		KernelVersion:  "#1 SMP Debian 6.1.69-1 (2023-12-30)",
+		KernelBuilder:  "debian-kernel@lists.debian.org",
+		KernelCompiler: "gcc-12 (Debian 12.2.0-14) 12.2.0, GNU ld (GNU Binutils for Debian) 2.40",
+		Machine:        "aarch64",
+		Processor:      "unknown",
+		DpkgArch:       "arm64",
+		PrettyName:     "Debian GNU/Linux 12 (bookworm)",
+		OSRelease: `PRETTY_NAME="Debian GNU/Linux 12 (bookworm)"
+NAME="Debian GNU/Linux"
+VERSION_ID="12"
+VERSION="12 (bookworm)"
+VERSION_CODENAME=bookworm
+ID=debian
+HOME_URL="https://www.debian.org/"
+SUPPORT_URL="https://www.debian.org/support"
+BUG_REPORT_URL="https://bugs.debian.org/"
+`,
+		DebianVersion:    "12.4",
+		BashVersion:      "5.2.15(1)-release",
+		UtilLinuxVersion: "2.38.1",
+		AptVersion:       "2.6.1",
+		AptSources: []aptSource{
+			{"http://deb.debian.org/debian", "bookworm"},
+			{"http://deb.debian.org/debian", "bookworm-updates"},
+			{"http://deb.debian.org/debian-security", "bookworm-security"},
+		},
+		AptComponent:   "main",
+		DefaultUser:    "admin",
+		CPUs:           armCPUs,
+		HardwareVendor: "QEMU",
+		HardwareModel:  "QEMU KVM Virtual Machine",
+		ServerVersion:  "SSH-2.0-OpenSSH_9.2p1 Debian-2+deb12u2",
+	},
+	"cisco-ios-15.2": {
+		KernelRelease:   "15.2(4)M7",
+		KernelVersion:   "RELEASE SOFTWARE (fc2)",
+		KernelBuilder:   "prod_rel_team",
+		KernelCompiler:  "Cisco IOS Software, C2900 Software (C2900-UNIVERSALK9-M)",
+		Machine:         "C2900",
+		Processor:       "unknown",
+		PrettyName:      "Cisco IOS Software, Version 15.2(4)M7",
+		DefaultUser:     "admin",
+		CPUs:            []cpu{{"SB_2", 0, 0}},
+		HardwareVendor:  "Cisco Systems, Inc.",
+		HardwareModel:   "CISCO2901/K9",
+		ServerVersion:   "SSH-2.0-Cisco-1.25",
+		HostKeyTypes:    []string{"rsa"},
+		Banner:          iosBanner,
+		Prompt:          `\h#`,
+		Commands:        []string{"exit", "logout"},
+		CommandNotFound: "Translating \"%v\"...domain server (255.255.255.255)\n%% Unknown command or computer name, or unable to find computer address\n",
+		Responses: config.Responses{
+			iosResponse("en|able", ""),
+			iosResponse("term|inal len|gth 0", ""),
+			iosResponse("sh|ow priv|ilege", "Current privilege level is 15\n"),
+			iosResponse("sh|ow ver|sion", `Cisco IOS Software, C2900 Software (C2900-UNIVERSALK9-M), Version 15.2(4)M7, RELEASE SOFTWARE (fc2)
+Technical Support: http://www.cisco.com/techsupport
+Copyright (c) 1986-2014 by Cisco Systems, Inc.
+Compiled Thu 25-Sep-14 10:36 by prod_rel_team
+
+ROM: System Bootstrap, Version 15.0(1r)M15, RELEASE SOFTWARE (fc1)
+
+{hostname} uptime is 12 weeks, 3 days, 4 hours, 17 minutes
+System returned to ROM by power-on
+System image file is "flash0:c2900-universalk9-mz.SPA.152-4.M7.bin"
+Last reload type: Normal Reload
+
+Cisco CISCO2901/K9 (revision 1.0) with 483328K/40960K bytes of memory.
+Processor board ID FTX1840ALBM
+2 Gigabit Ethernet interfaces
+1 terminal line
+DRAM configuration is 64 bits wide with parity enabled.
+255K bytes of non-volatile configuration memory.
+250880K bytes of ATA System CompactFlash 0 (Read/Write)
+
+Configuration register is 0x2102
+
+`),
+			iosResponse("sh|ow ip int|erface br|ief", `Interface                  IP-Address      OK? Method Status                Protocol
+Embedded-Service-Engine0/0 unassigned      YES NVRAM  administratively down down
+GigabitEthernet0/0         203.0.113.2     YES NVRAM  up                    up
+GigabitEthernet0/1         10.0.0.1        YES NVRAM  up                    up
+`),
+			iosResponse("sh|ow run|ning-config", `Building configuration...
+
+Current configuration : 1764 bytes
+!
+version 15.2
+service timestamps debug datetime msec
+service timestamps log datetime msec
+service password-encryption
+!
+hostname {hostname}
+!
+boot-start-marker
+boot-end-marker
+!
+enable secret 5 $1$mERr$hx5rVt7rPNoS4wqbXKX7m0
+!
+no aaa new-model
+!
+ip cef
+no ipv6 cef
+!
+username admin privilege 15 secret 5 $1$pdQG$o8nrSzsGXeaduXrjlvKc91
+!
+interface GigabitEthernet0/0
+ description WAN
+ ip address 203.0.113.2 255.255.255.252
+ duplex auto
+ speed auto
+!
+interface GigabitEthernet0/1
+ description LAN
+ ip address 10.0.0.1 255.255.255.0
+ duplex auto
+ speed auto
+!
+ip forward-protocol nd
+no ip http server
+no ip http secure-server
+!
+ip route 0.0.0.0 0.0.0.0 203.0.113.1
+ip ssh version 2
+!
+banner login ^C
+`+iosBanner+`^C
+!
+line con 0
+line aux 0
+line vty 0 4
+ login local
+ transport input ssh
+!
+scheduler allocate 20000 1000
+end
+
+`),
+		},
+	},
+}
+
+// iosBanner is the login banner of the Cisco IOS profile.
+const iosBanner = `*************************************************************************
+* Authorized access only. This system is the property of its owner.     *
+* Disconnect IMMEDIATELY if you are not an authorized user!             *
+*************************************************************************
+`
+
+// iosResponse answers an IOS command, whose words can be abbreviated like
+// IOS allows down to the part before their |, e.g. sh|ow ver|sion matches sh
+// ver.
+func iosResponse(command, stdout string) config.Response {
+	words := strings.Fields(command)
+	for i, word := range words {
+		parts := strings.SplitN(word, "|", 2)
+		optional := ""
+		if len(parts) == 2 {
+			for j := len(parts[1]) - 1; j >= 0; j-- {
+				optional = "(?:" + regexp.QuoteMeta(parts[1][j:j+1]) + optional + ")?"
+			}
+		}
+		words[i] = regexp.QuoteMeta(parts[0]) + optional
+	}
+	return config.Response{Match: regexp.MustCompile(`^\s*` + strings.Join(words, `\s+`) + `\s*$`), Stdout: stdout}
+}
+
+// LookupProfile returns the profile called name, the default one if name is
+// empty, or nil if there is none.
+func LookupProfile(name string) *Profile {
+	if name == "" {
+		name = DefaultProfile
+	}
+	return Profiles[name]
+}
+
+// ProfileNames returns the names of the profiles, sorted.
+func ProfileNames() []string {
+	names := make([]string, 0, len(Profiles))
+	for name := range Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// arm reports whether the profile is of an ARM host.
+func (profile *Profile) arm() bool {
+	return profile.Machine == "aarch64"
+}
+
+// hasCommand reports whether the emulated command name is one of the host's.
+func (profile *Profile) hasCommand(name string) bool {
+	if len(profile.Commands) == 0 {
+		return true
+	}
+	for _, command := range profile.Commands {
+		if command == name {
+			return true
+		}
+	}
+	return false
+}
+
+// id returns the ID of the distribution in its os-release.
+func (profile *Profile) id() string {
+	for _, line := range strings.Split(profile.OSRelease, "\n") {
+		if strings.HasPrefix(line, "ID=") {
+			return strings.TrimPrefix(line, "ID=")
+		}
+	}
+	return "linux"
+}
+
+// procVersion returns /proc/version.
+func procVersion(system *System) string {
+	profile := system.Profile
+	return fmt.Sprintf("Linux version %v (%v) (%v) %v\n", profile.KernelRelease, profile.KernelBuilder, profile.KernelCompiler, profile.KernelVersion)
+}
+
+// issue returns /etc/issue.
+func issue(system *System) string {
+	name := system.Profile.PrettyName
+	if strings.HasPrefix(name, "Debian") {
+		// Debian leaves out the codename.
+		name = strings.SplitN(name, " (", 2)[0]
+	}
+	return name + " \\n \\l\n\n"
+}
+
+// unameFields are the fields of uname, in the order they are printed, by
+// their short and long options.
+var unameFields = []struct {
+	short byte
+	long  string
+}{
+	{'s', "kernel-name"},
+	{'n', "nodename"},
+	{'r', "kernel-release"},
+	{'v', "kernel-version"},
+	{'m', "machine"},
+	{'p', "processor"},
+	{'i', "hardware-platform"},
+	{'o', "operating-system"},
+}
+
+func uname(process *process) int {
+	system := process.shell.system
+	selected := map[byte]bool{}
+	all := false
+	for _, arg := range process.args[1:] {
+		switch {
+		case arg == "-a" || arg == "--all":
+			all = true
+		case strings.HasPrefix(arg, "--"):
+			found := false
+			for _, field := range unameFields {
+				if arg[2:] == field.long {
+					selected[field.short], found = true, true
+				}
+			}
+			if !found {
+				fmt.Fprintf(&process.stderr, "uname: unrecognized option '%v'\nTry 'uname --help' for more information.\n", arg)
+				return 1
+			}
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			for i := 1; i < len(arg); i++ {
+				found := arg[i] == 'a'
+				all = all || found
+				for _, field := range unameFields {
+					if arg[i] == field.short {
+						selected[field.short], found = true, true
+					}
+				}
+				if !found {
+					fmt.Fprintf(&process.stderr, "uname: invalid option -- '%c'\nTry 'uname --help' for more information.\n", arg[i])
+					return 1
+				}
+			}
+		default:
+			fmt.Fprintf(&process.stderr, "uname: extra operand '%v'\nTry 'uname --help' for more information.\n", arg)
+			return 1
+		}
+	}
+	if len(selected) == 0 && !all {
+		selected['s'] = true
+	}
+	values := map[byte]string{
+		's': "Linux",
+		'n': system.Hostname,
+		'r': system.Profile.KernelRelease,
+		'v': system.Profile.KernelVersion,
+		'm': system.Profile.Machine,
+		'p': system.Profile.Processor,
+		'i': system.Profile.Processor,
+		'o': "GNU/Linux",
+	}
+	printed := []string{}
+	for _, field := range unameFields {
+		// -a leaves out the processor and platform if they are unknown.
+		if selected[field.short] || all && values[field.short] != "unknown" {
+			printed = append(printed, values[field.short])
+		}
+	}
+	fmt.Fprintln(&process.stdout, strings.Join(printed, " "))
+	return 0
+}
+
+func hostnamectl(process *process) int {
+	system := process.shell.system
+	verb := "status"
+	for _, arg := range process.args[1:] {
+		if !strings.HasPrefix(arg, "-") {
+			verb = arg
+			break
+		}
+	}
+	switch verb {
+	case "status":
+	case "hostname":
+		fmt.Fprintln(&process.stdout, system.Hostname)
+		return 0
+	default:
+		fmt.Fprintf(&process.stderr, "Unknown command verb %v.\n", verb)
+		return 1
+	}
+	architecture := "x86-64"
+	if system.Profile.arm() {
+		architecture = "arm64"
+	}
+	fmt.Fprintf(&process.stdout, " Static hostname: %v\n", system.Hostname)
+	fmt.Fprintf(&process.stdout, "       Icon name: computer-vm\n")
+	fmt.Fprintf(&process.stdout, "         Chassis: vm\n")
+	fmt.Fprintf(&process.stdout, "      Machine ID: %v\n", system.MachineID)
+	fmt.Fprintf(&process.stdout, "         Boot ID: %v\n", system.BootID)
+	fmt.Fprintf(&process.stdout, "  Virtualization: kvm\n")
+	fmt.Fprintf(&process.stdout, "Operating System: %v\n", system.Profile.PrettyName)
+	fmt.Fprintf(&process.stdout, "          Kernel: Linux %v\n", system.Profile.KernelRelease)
+	fmt.Fprintf(&process.stdout, "    Architecture: %v\n", architecture)
+	fmt.Fprintf(&process.stdout, " Hardware Vendor: %v\n", system.Profile.HardwareVendor)
+	fmt.Fprintf(&process.stdout, "  Hardware Model: %v\n", system.Profile.HardwareModel)
+	return 0
+}
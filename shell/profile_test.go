@@ -0,0 +1,111 @@
+package shell
+
+import (
+	"github.com/longkeyy/sshesame/config"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestProfilesConsistent(t *testing.T) {
+	captureLog(t)
+	for _, name := range ProfileNames() {
+		profile := Profiles[name]
+		if len(profile.Commands) != 0 {
+			// Hosts without a Unix shell have none of these commands.
+			continue
+		}
+		shell := newTestShell(newTestSession("root"), config.Shell{Profile: name})
+		output := func(command string) string {
+			stdout, stderr, status := runScript(t, shell, command)
+			if status != 0 || stderr != "" {
+				t.Fatalf("%v: %q = %v, %q", name, command, status, stderr)
+			}
+			return stdout
+		}
+		hostname := shell.system.Hostname
+
+		if release := output("uname -r"); release != profile.KernelRelease+"\n" {
+			t.Errorf("%v: uname -r = %q, want %v", name, release, profile.KernelRelease)
+		}
+		if machine := output("uname -m"); machine != profile.Machine+"\n" {
+			t.Errorf("%v: uname -m = %q, want %v", name, machine, profile.Machine)
+		}
+		if all := output("uname -a"); !strings.HasPrefix(all, "Linux "+hostname+" "+profile.KernelRelease+" ") {
+			t.Errorf("%v: uname -a = %q, want the host's name and kernel", name, all)
+		}
+		osRelease := output("cat /etc/os-release")
+		if osRelease != profile.OSRelease {
+			t.Errorf("%v: /etc/os-release =\n%v\nwant\n%v", name, osRelease, profile.OSRelease)
+		}
+		if !strings.Contains(osRelease, `PRETTY_NAME="`+profile.PrettyName+`"`) {
+			t.Errorf("%v: /etc/os-release doesn't name %v", name, profile.PrettyName)
+		}
+		if version := output("cat /proc/version"); !strings.HasPrefix(version, "Linux version "+profile.KernelRelease+" ") {
+			t.Errorf("%v: /proc/version = %q, want kernel %v", name, version, profile.KernelRelease)
+		}
+
+		hostnamectl := output("hostnamectl")
+		for _, want := range []string{
+			"Static hostname: " + hostname + "\n",
+			"Operating System: " + profile.PrettyName + "\n",
+			"Kernel: Linux " + profile.KernelRelease + "\n",
+		} {
+			if !strings.Contains(hostnamectl, want) {
+				t.Errorf("%v: hostnamectl wrote\n%v\nwant %q", name, hostnamectl, want)
+			}
+		}
+
+		lscpu := output("lscpu")
+		if !strings.Contains(lscpu, "Architecture:            "+profile.Machine+"\n") {
+			t.Errorf("%v: lscpu wrote\n%v\nwant architecture %v", name, lscpu, profile.Machine)
+		}
+		cpus := regexp.MustCompile(`(?m)^CPU\(s\): +(\d+)$`).FindStringSubmatch(lscpu)
+		processors := strings.Count(output("cat /proc/cpuinfo"), "processor\t: ")
+		if nproc := output("nproc"); cpus == nil || nproc != cpus[1]+"\n" || nproc != strconv.Itoa(processors)+"\n" {
+			t.Errorf("%v: nproc = %q, lscpu shows %v CPUs and /proc/cpuinfo %v", name, nproc, cpus, processors)
+		}
+		memTotal := regexp.MustCompile(`MemTotal: +(\d+) kB`).FindStringSubmatch(output("cat /proc/meminfo"))
+		if memTotal == nil || !regexp.MustCompile(`(?m)^Mem: +`+memTotal[1]+` `).MatchString(output("free -k")) {
+			t.Errorf("%v: free -k disagrees with /proc/meminfo's %v", name, memTotal)
+		}
+	}
+}
+
+func TestLookupProfile(t *testing.T) {
+	if profile := LookupProfile(""); profile != Profiles[DefaultProfile] {
+		t.Errorf("LookupProfile(\"\") = %v, want the default profile", profile)
+	}
+	if profile := LookupProfile("debian-12-arm64"); profile == nil || !profile.arm() {
+		t.Errorf("LookupProfile(\"debian-12-arm64\") = %v, want the ARM profile", profile)
+	}
+	if profile := LookupProfile("windows-11"); profile != nil {
+		t.Errorf("LookupProfile(\"windows-11\") = %v, want none", profile)
+	}
+}
+
+func TestIOSProfile(t *testing.T) {
+	captureLog(t)
+	shell := newTestShell(newTestSession("admin"), config.Shell{Profile: "cisco-ios-15.2"})
+	hostname := strings.SplitN(shell.system.Hostname, ".", 2)[0]
+	if prompt := shell.expandPrompt(""); prompt != hostname+"#" {
+		t.Errorf("prompt = %q, want %q", prompt, hostname+"#")
+	}
+	// Words are abbreviated down to what sets them apart.
+	for _, command := range []string{"sh ver", "show version", "  sho   versi "} {
+		if stdout, stderr, status := runScript(t, shell, command); status != 0 || stderr != "" || !strings.Contains(stdout, "\n"+hostname+" uptime is 12 weeks") {
+			t.Errorf("%q = %v, %q, %q", command, status, stdout, stderr)
+		}
+	}
+	for _, command := range []string{"s ver", "show versions", "uname -a", "ls"} {
+		name := strings.Fields(command)[0]
+		want := "Translating \"" + name + "\"...domain server (255.255.255.255)\n% Unknown command or computer name, or unable to find computer address\n"
+		if stdout, stderr, _ := runScript(t, shell, command); stdout != "" || stderr != want {
+			t.Errorf("%q = %q, %q, want %q", command, stdout, stderr, want)
+		}
+	}
+	if stdout := output(t, shell, "show running-config"); !strings.Contains(stdout, "\nhostname "+hostname+"\n") || !strings.Contains(stdout, "banner login ^C\n"+iosBanner+"^C\n") {
+		t.Errorf("show running-config = %q", stdout)
+	}
+}
@@ -0,0 +1,79 @@
+package shell
+
+import (
+	"path"
+	"strings"
+)
+
+// DefaultPrompt is the prompt of interactive shells if neither the
+// configuration nor the system profile sets one, the PS1 of bash on Ubuntu.
+const DefaultPrompt = `\u@\h:\w\$ `
+
+// expandPrompt expands the escapes of prompt like bash does those of PS1:
+// \u is the user, \h the hostname up to the first dot and \H all of it, \w
+// the working directory with the home directory abbreviated to ~ and \W its
+// base name, \$ # for root and $ for others, \s the shell, \n a line break and \\ a backslash. Other
+// escapes are kept as they are.
+func (shell *shell) expandPrompt(prompt string) string {
+	if prompt == "" {
+		prompt = shell.system.Profile.Prompt
+	}
+	if prompt == "" {
+		prompt = DefaultPrompt
+	}
+	hostname := shell.system.Hostname
+	user := shell.system.LookupUser(shell.session.User)
+	dollar := "$"
+	if user != nil && user.UID == 0 {
+		dollar = "#"
+	}
+	var expanded strings.Builder
+	for i := 0; i < len(prompt); i++ {
+		if prompt[i] != '\\' || i == len(prompt)-1 {
+			expanded.WriteByte(prompt[i])
+			continue
+		}
+		i++
+		switch prompt[i] {
+		case 'u':
+			expanded.WriteString(shell.session.User)
+		case 'h':
+			expanded.WriteString(strings.SplitN(hostname, ".", 2)[0])
+		case 'H':
+			expanded.WriteString(hostname)
+		case 'w':
+			expanded.WriteString(shell.abbreviatedDir())
+		case 'W':
+			dir := shell.abbreviatedDir()
+			if dir != "/" && dir != "~" {
+				dir = path.Base(dir)
+			}
+			expanded.WriteString(dir)
+		case '$':
+			expanded.WriteString(dollar)
+		case 's':
+			expanded.WriteString("bash")
+		case 'n':
+			expanded.WriteString("\n")
+		case '\\':
+			expanded.WriteByte('\\')
+		default:
+			expanded.WriteByte('\\')
+			expanded.WriteByte(prompt[i])
+		}
+	}
+	return expanded.String()
+}
+
+// abbreviatedDir returns the working directory with the home directory
+// abbreviated to ~.
+func (shell *shell) abbreviatedDir() string {
+	dir, home := shell.workingDir(), shell.home()
+	switch {
+	case dir == home:
+		return "~"
+	case strings.HasPrefix(dir, home+"/"):
+		return "~" + strings.TrimPrefix(dir, home)
+	}
+	return dir
+}
@@ -0,0 +1,51 @@
+package shell
+
+import (
+	"context"
+	"github.com/longkeyy/sshesame/config"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestExpandPrompt(t *testing.T) {
+	root := newTestShell(newTestSession("root"), config.Shell{})
+	root.system.Hostname = "db-backup.example.com"
+	for _, test := range []struct {
+		script, prompt, want string
+	}{
+		{"", "", "root@db-backup:~# "},
+		{"", `\u@\H \W\$ `, "root@db-backup.example.com ~# "},
+		{"cd /", `\w \W`, "/ /"},
+		{"mkdir /tmp/data; cd /tmp/data", `[\u@\h \W]\$ `, "[root@db-backup data]# "},
+		{"mkdir /root/work; cd /root/work", `\w|\W`, "~/work|work"},
+		{"cd /tmp", `\s-5.1\n\\ \d \`, "bash-5.1\n\\ \\d \\"},
+	} {
+		if test.script != "" {
+			if _, stderr, status := runScript(t, root, test.script); status != 0 {
+				t.Fatalf("%q: %q", test.script, stderr)
+			}
+		}
+		if prompt := root.expandPrompt(test.prompt); prompt != test.want {
+			t.Errorf("expandPrompt(%q) after %q = %q, want %q", test.prompt, test.script, prompt, test.want)
+		}
+	}
+	// Users other than root get $, unknown ones included.
+	if prompt := newTestShell(newTestSession("nobody-here"), config.Shell{}).expandPrompt(`\u\$`); prompt != "nobody-here$" {
+		t.Errorf("expandPrompt = %q, want nobody-here$", prompt)
+	}
+}
+
+func TestRunShowsPrompt(t *testing.T) {
+	channel := &testChannel{input: strings.NewReader("cd /tmp\rexit\r")}
+	cfg := config.Shell{Profile: DefaultProfile, Prompt: `\h:\W \u\$ `}
+	if err := Run(context.Background(), newLoginSession(), cfg, channel); err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	transcript := channel.stdout.String()
+	for _, prompt := range []string{"db-backup:~ root# ", "db-backup:tmp root# "} {
+		if !strings.Contains(transcript, prompt) {
+			t.Errorf("shell wrote %q, want the prompt %q", transcript, prompt)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package shell
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/longkeyy/sshesame/config"
+	"github.com/longkeyy/sshesame/session"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recording records what an interactive shell writes to the terminal so that
+// sessions can be replayed, along with what the client types and the size of
+// its terminal in asciicast.
+type recording struct {
+	session *session.Session
+	path    string
+	format  config.RecordingFormat
+	file    *os.File
+	writer  *bufio.Writer
+	start   time.Time
+
+	mu    sync.Mutex
+	bytes int
+	// inputBytes are the bytes the client typed.
+	inputBytes int
+	// width and height are the terminal size last recorded.
+	width, height uint32
+	err           error
+}
+
+// newRecording starts recording a session to a new file in dir.
+func newRecording(sess *session.Session, dir string, format config.RecordingFormat) (*recording, error) {
+	host := sess.RemoteAddr.String()
+	if tcpAddr, ok := sess.RemoteAddr.(*net.TCPAddr); ok {
+		host = tcpAddr.IP.String()
+	}
+	start := time.Now()
+	extension := ".cast"
+	if format == "ttyrec" {
+		extension = ".ttyrec"
+	}
+	name := fmt.Sprintf("%v-%v%v", start.UTC().Format("20060102T150405.000000000Z"), strings.NewReplacer(":", "_").Replace(host), extension)
+	path := filepath.Join(dir, name)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	recording := &recording{
+		session: sess,
+		path:    path,
+		format:  format,
+		file:    file,
+		writer:  bufio.NewWriter(file),
+		start:   start,
+	}
+	if format == "asciicast" {
+		if err := recording.writeAsciicastHeader(); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return recording, nil
+}
+
+// writeAsciicastHeader writes the header line of an asciicast v2 file, see
+// https://docs.asciinema.org/manual/asciicast/v2/.
+func (recording *recording) writeAsciicastHeader() error {
+	width, height := recording.terminalSize()
+	recording.width, recording.height = width, height
+	header, err := json.Marshal(struct {
+		Version   int               `json:"version"`
+		Width     uint32            `json:"width"`
+		Height    uint32            `json:"height"`
+		Timestamp int64             `json:"timestamp"`
+		Title     string            `json:"title"`
+		Env       map[string]string `json:"env"`
+	}{2, width, height, recording.start.Unix(), fmt.Sprintf("%v@%v session %v", recording.session.User, recording.session.RemoteAddr, recording.session.ID), map[string]string{
+		"SHELL": "/bin/bash",
+		"TERM":  recording.session.Env()["TERM"],
+	}})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(recording.writer, "%s\n", header)
+	return err
+}
+
+// terminalSize returns the size of the terminal of the session, 80x24 until
+// the client tells it.
+func (recording *recording) terminalSize() (uint32, uint32) {
+	width, height := recording.session.TerminalSize()
+	if width == 0 || height == 0 {
+		width, height = 80, 24
+	}
+	return width, height
+}
+
+// writeAsciicastEvent writes an event of an asciicast v2 file, of type code
+// and with data, at time.
+func (recording *recording) writeAsciicastEvent(now time.Time, code string, data string) {
+	var event []byte
+	event, recording.err = json.Marshal([]interface{}{now.Sub(recording.start).Seconds(), code, data})
+	if recording.err == nil {
+		_, recording.err = fmt.Fprintf(recording.writer, "%s\n", event)
+	}
+}
+
+// recordInput records what the client typed at time, which only asciicast
+// can hold.
+func (recording *recording) recordInput(now time.Time, input []byte) {
+	recording.mu.Lock()
+	defer recording.mu.Unlock()
+	if recording.err != nil || len(input) == 0 || recording.format == "ttyrec" {
+		return
+	}
+	recording.inputBytes += len(input)
+	recording.writeAsciicastEvent(now, "i", string(input))
+}
+
+// record records output written to the terminal at time, after the size of
+// the terminal if the client resized it since.
+func (recording *recording) record(now time.Time, output []byte) {
+	recording.mu.Lock()
+	defer recording.mu.Unlock()
+	if recording.err != nil || len(output) == 0 {
+		return
+	}
+	recording.bytes += len(output)
+	switch recording.format {
+	case "ttyrec":
+		// Every chunk has a header of the seconds and microseconds of its
+		// time and its length, as little-endian 32-bit integers.
+		header := make([]byte, 12)
+		binary.LittleEndian.PutUint32(header[0:], uint32(now.Unix()))
+		binary.LittleEndian.PutUint32(header[4:], uint32(now.Nanosecond()/1000))
+		binary.LittleEndian.PutUint32(header[8:], uint32(len(output)))
+		if _, err := recording.writer.Write(header); err != nil {
+			recording.err = err
+			return
+		}
+		_, recording.err = recording.writer.Write(output)
+	default:
+		if width, height := recording.terminalSize(); width != recording.width || height != recording.height {
+			recording.width, recording.height = width, height
+			recording.writeAsciicastEvent(now, "r", fmt.Sprintf("%vx%v", width, height))
+			if recording.err != nil {
+				return
+			}
+		}
+		recording.writeAsciicastEvent(now, "o", string(output))
+	}
+}
+
+// Close ends the recording and logs where it was saved.
+func (recording *recording) Close() error {
+	recording.mu.Lock()
+	defer recording.mu.Unlock()
+	err := recording.err
+	if flushErr := recording.writer.Flush(); err == nil {
+		err = flushErr
+	}
+	if closeErr := recording.file.Close(); err == nil {
+		err = closeErr
+	}
+	log.WithFields(log.Fields{
+		"client":      recording.session.RemoteAddr,
+		"channel":     "session",
+		"path":        recording.path,
+		"format":      string(recording.format),
+		"bytes":       recording.bytes,
+		"input_bytes": recording.inputBytes,
+		"duration":    time.Since(recording.start).String(),
+	}).Info("Session recorded")
+	return err
+}
+
+// recordingWriter records everything written to the terminal and read from
+// it.
+type recordingWriter struct {
+	io.ReadWriter
+	recording *recording
+}
+
+func (writer recordingWriter) Read(data []byte) (int, error) {
+	n, err := writer.ReadWriter.Read(data)
+	writer.recording.recordInput(time.Now(), data[:n])
+	return n, err
+}
+
+func (writer recordingWriter) Write(data []byte) (int, error) {
+	n, err := writer.ReadWriter.Write(data)
+	writer.recording.record(time.Now(), data[:n])
+	return n, err
+}
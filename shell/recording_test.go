@@ -0,0 +1,68 @@
+package shell
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTtyrecRecording(t *testing.T) {
+	captureLog(t)
+	recording, err := newRecording(newTestSession("root"), t.TempDir(), "ttyrec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2026, time.October, 4, 1, 29, 30, 123456789, time.UTC)
+	recording.record(at, []byte("root@server:~# "))
+	recording.recordInput(at, []byte("id\r"))
+	recording.record(at.Add(time.Second), []byte("uid=0(root)\r\n"))
+	if err := recording.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(recording.path, ".ttyrec") {
+		t.Errorf("recorded to %v, want a .ttyrec file", recording.path)
+	}
+	data, err := ioutil.ReadFile(recording.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Chunks of the seconds, microseconds and length of the output, input
+	// not being recorded in ttyrec.
+	if want := "\xfa\xab\xc1\x6a\x40\xe2\x01\x00\x0f\x00\x00\x00root@server:~# " +
+		"\xfb\xab\xc1\x6a\x40\xe2\x01\x00\x0d\x00\x00\x00uid=0(root)\r\n"; string(data) != want {
+		t.Errorf("recorded %q, want %q", data, want)
+	}
+}
+
+func TestAsciicastRecording(t *testing.T) {
+	captureLog(t)
+	sess := newTestSession("root")
+	sess.ID = "1234"
+	sess.SetEnv("TERM", "xterm")
+	recording, err := newRecording(sess, t.TempDir(), "asciicast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := recording.start
+	recording.record(now, []byte("$ "))
+	recording.recordInput(now.Add(500*time.Millisecond), []byte("id\r"))
+	sess.SetTerminalSize(120, 40)
+	recording.record(now.Add(1250*time.Millisecond), []byte("uid=0(root)\r\n\x1b[0m"))
+	if err := recording.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(recording.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf(`{"version":2,"width":80,"height":24,"timestamp":%v,"title":"root@192.0.2.1:50000 session 1234","env":{"SHELL":"/bin/bash","TERM":"xterm"}}`, recording.start.Unix()) + "\n" +
+		`[0,"o","$ "]` + "\n" +
+		`[0.5,"i","id\r"]` + "\n" +
+		`[1.25,"r","120x40"]` + "\n" +
+		`[1.25,"o","uid=0(root)\r\n\u001b[0m"]` + "\n"
+	if string(data) != want {
+		t.Errorf("recorded %q, want %q", data, want)
+	}
+}
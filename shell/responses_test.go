@@ -0,0 +1,58 @@
+package shell
+
+import (
+	"context"
+	"github.com/longkeyy/sshesame/config"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestResponsesServed(t *testing.T) {
+	hook := captureLog(t)
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"uname":  "Linux gpu-node-3 5.15.0-91-generic x86_64 GNU/Linux\n",
+		"docker": "---\nmatch: docker ps.*\nstderr: Cannot connect to the Docker daemon at unix:///var/run/docker.sock. Is the docker daemon running?\nexit_status: 1\n---\n",
+		"crash":  "---\nexit_signal: SEGV\ncore_dumped: true\n---\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	responses, err := config.LoadResponses(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Shell{Profile: DefaultProfile, Responses: responses}
+	for _, test := range []struct {
+		command        string
+		stdout, stderr string
+		exit           Exit
+		response       string
+	}{
+		{"uname -a", "Linux gpu-node-3 5.15.0-91-generic x86_64 GNU/Linux\n", "", Exit{}, "uname"},
+		{"docker ps -a", "", "Cannot connect to the Docker daemon at unix:///var/run/docker.sock. Is the docker daemon running?\n", Exit{Status: 1}, "docker"},
+		{"crash", "", "", Exit{Status: 139, Signal: "SEGV", CoreDumped: true}, "crash"},
+	} {
+		hook.Reset()
+		channel := &testChannel{}
+		exit, err := Exec(context.Background(), newTestSession("root"), cfg, test.command, channel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if channel.stdout.String() != test.stdout || channel.stderr.String() != test.stderr {
+			t.Errorf("%q wrote %q and %q, want %q and %q", test.command, channel.stdout.String(), channel.stderr.String(), test.stdout, test.stderr)
+		}
+		if exit.Status != test.exit.Status || exit.Signal != test.exit.Signal || exit.CoreDumped != test.exit.CoreDumped {
+			t.Errorf("%q exited with %+v, want %+v", test.command, exit, test.exit)
+		}
+		if entry := hook.LastEntry(); entry == nil || entry.Data["response"] != filepath.Join(dir, test.response) {
+			t.Errorf("%q logged %v, want the response of %v", test.command, entry, test.response)
+		}
+	}
+	// Other commands are still emulated.
+	if stdout, _, _ := run(t, cfg, "echo hello"); stdout != "hello\n" {
+		t.Errorf("echo hello = %q with responses loaded", stdout)
+	}
+}
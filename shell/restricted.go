@@ -0,0 +1,162 @@
+package shell
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// readonlyVariables are the variables rbash doesn't let users change.
+var readonlyVariables = map[string]bool{
+	"BASH_CMDS": true,
+	"BASH_ENV":  true,
+	"ENV":       true,
+	"PATH":      true,
+	"SHELL":     true,
+}
+
+// escapeShells are shells that aren't restricted when started from rbash.
+var escapeShells = map[string]bool{
+	"ash":  true,
+	"bash": true,
+	"csh":  true,
+	"dash": true,
+	"fish": true,
+	"ksh":  true,
+	"sh":   true,
+	"tcsh": true,
+	"zsh":  true,
+}
+
+// escapeInterpreters run code given on their command line with these options,
+// which can start an unrestricted shell.
+var escapeInterpreters = map[string][]string{
+	"lua":     {"-e"},
+	"node":    {"-e"},
+	"perl":    {"-e", "-E"},
+	"php":     {"-r"},
+	"python":  {"-c"},
+	"python2": {"-c"},
+	"python3": {"-c"},
+	"ruby":    {"-e"},
+}
+
+// escapeCommands can start a shell of their own, such as editors and pagers
+// with shell escapes.
+var escapeCommands = map[string]bool{
+	"awk":    true,
+	"ed":     true,
+	"env":    true,
+	"expect": true,
+	"find":   true,
+	"ftp":    true,
+	"gdb":    true,
+	"less":   true,
+	"man":    true,
+	"more":   true,
+	"nmap":   true,
+	"script": true,
+	"vi":     true,
+	"vim":    true,
+}
+
+// restrictedf writes an error reported by a restricted shell, worded like
+// rbash does.
+func (process *process) restrictedf(format string, args ...interface{}) {
+	if process.shell.interactive {
+		process.stderr.WriteString("rbash: ")
+	} else {
+		process.stderr.WriteString("rbash: line 1: ")
+	}
+	fmt.Fprintf(&process.stderr, format, args...)
+}
+
+// restrict enforces the restrictions of rbash on a command. It returns a
+// failed process if the command breaks any, or nil if it may run.
+func (shell *shell) restrict(args []string) *process {
+	process := &process{shell: shell, args: args, status: 1}
+	if name := assignedVariable(args[0]); readonlyVariables[name] {
+		process.restrictedf("%v: readonly variable\n", name)
+		return process
+	}
+	switch args[0] {
+	case "cd", "exec", "enable":
+		process.restrictedf("%v: restricted\n", args[0])
+		return process
+	case "export", "declare", "readonly", "typeset", "unset":
+		for _, arg := range args[1:] {
+			if name := strings.SplitN(arg, "=", 2)[0]; readonlyVariables[name] {
+				process.restrictedf("%v: readonly variable\n", name)
+				return process
+			}
+		}
+	}
+	if strings.Contains(args[0], "/") {
+		process.restrictedf("%v: restricted: cannot specify `/' in command names\n", args[0])
+		return process
+	}
+	for i, arg := range args[1:] {
+		if target, ok := redirection(arg); ok {
+			if target == "" && i+2 < len(args) {
+				target = args[i+2]
+			}
+			process.restrictedf("%v: restricted: cannot redirect output\n", target)
+			return process
+		}
+	}
+	return nil
+}
+
+// assignedVariable returns the name of the variable assigned by word, which
+// may assign an element of an array, or "" if it isn't an assignment.
+func assignedVariable(word string) string {
+	name := strings.SplitN(word, "=", 2)[0]
+	if name == word {
+		return ""
+	}
+	if i := strings.Index(name, "["); i != -1 && strings.HasSuffix(name, "]") {
+		name = name[:i]
+	}
+	if !validName(name) {
+		return ""
+	}
+	return name
+}
+
+// redirection returns whether word redirects output, such as >file, 2>>file
+// or &>file, and the file it redirects to if it's part of word.
+func redirection(word string) (string, bool) {
+	word = strings.TrimLeft(strings.TrimPrefix(word, "&"), "0123456789")
+	if !strings.HasPrefix(word, ">") {
+		return "", false
+	}
+	return strings.TrimLeft(word, ">|"), true
+}
+
+// escapeAttempt returns whether a command is a known way of escaping a
+// restricted shell.
+func escapeAttempt(args []string) bool {
+	name := path.Base(args[0])
+	if escapeShells[name] || escapeCommands[name] {
+		return true
+	}
+	if readonlyVariables[assignedVariable(args[0])] {
+		return true
+	}
+	switch name {
+	case "export", "declare", "readonly", "typeset", "unset":
+		for _, arg := range args[1:] {
+			if readonlyVariables[strings.SplitN(arg, "=", 2)[0]] {
+				return true
+			}
+		}
+	}
+	for _, option := range escapeInterpreters[name] {
+		for _, arg := range args[1:] {
+			if arg == option {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package shell
+
+import (
+	"github.com/longkeyy/sshesame/config"
+	"testing"
+)
+
+func TestRestrictedShell(t *testing.T) {
+	captureLog(t)
+	for _, test := range []struct {
+		command, stderr string
+	}{
+		{"cd /tmp", "rbash: line 1: cd: restricted\n"},
+		{"/bin/ls", "rbash: line 1: /bin/ls: restricted: cannot specify `/' in command names\n"},
+		{"./exploit", "rbash: line 1: ./exploit: restricted: cannot specify `/' in command names\n"},
+		{"echo hi > /tmp/x", "rbash: line 1: /tmp/x: restricted: cannot redirect output\n"},
+		{"echo hi 2>>log", "rbash: line 1: log: restricted: cannot redirect output\n"},
+		{"PATH=/tmp", "rbash: line 1: PATH: readonly variable\n"},
+		{"export SHELL=/bin/bash", "rbash: line 1: SHELL: readonly variable\n"},
+		{"exec bash", "rbash: line 1: exec: restricted\n"},
+	} {
+		stdout, stderr, status := run(t, config.Shell{Restricted: true}, test.command)
+		if status == 0 || stdout != "" || stderr != test.stderr {
+			t.Errorf("%v = %q, %q, %v, want %q", test.command, stdout, stderr, status, test.stderr)
+		}
+	}
+	if stdout, _, status := run(t, config.Shell{Restricted: true}, "echo hi"); status != 0 || stdout != "hi\n" {
+		t.Errorf("echo hi = %q, %v, want it allowed", stdout, status)
+	}
+	if stdout := output(t, newTestShell(newTestSession("root"), config.Shell{Restricted: true}), "echo $SHELL"); stdout != "/bin/rbash\n" {
+		t.Errorf("$SHELL = %q, want /bin/rbash", stdout)
+	}
+	if _, _, status := run(t, config.Shell{}, "cd /tmp"); status != 0 {
+		t.Error("cd restricted in an unrestricted shell")
+	}
+}
+
+func TestRestrictedEscapeAttemptsLogged(t *testing.T) {
+	hook := captureLog(t)
+	for command, escape := range map[string]bool{
+		"bash -i": true,
+		"python -c 'import pty; pty.spawn(\"sh\")'": true,
+		"vi":                                true,
+		"awk 'BEGIN {system(\"/bin/sh\")}'": true,
+		"export PATH=/bin":                  true,
+		"BASH_ENV=/tmp/x":                   true,
+		"ls -la":                            false,
+		"python script.py":                  false,
+	} {
+		hook.Reset()
+		run(t, config.Shell{Restricted: true}, command)
+		entry := lastEntry(hook, "Command executed")
+		if entry == nil {
+			t.Errorf("%v wasn't logged", command)
+			continue
+		}
+		if category := entry.Data["category"]; (category == "restricted_escape_attempt") != escape {
+			t.Errorf("%v logged with category %v, want an escape attempt %v", command, category, escape)
+		}
+	}
+	hook.Reset()
+	run(t, config.Shell{}, "bash -i")
+	if entry := lastEntry(hook, "Command executed"); entry == nil || entry.Data["category"] == "restricted_escape_attempt" {
+		t.Errorf("bash -i in an unrestricted shell logged with %v", entry)
+	}
+}
@@ -0,0 +1,383 @@
+package shell
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/longkeyy/sshesame/config"
+	"github.com/longkeyy/sshesame/session"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// maxSCPLine is the longest control line accepted, beyond which the client
+// is taken for not speaking the protocol.
+const maxSCPLine = 4096
+
+// errSCPProtocol ends transfers the client broke the protocol of.
+var errSCPProtocol = errors.New("protocol error")
+
+// scpServer serves the server side of scp, run with -t to receive files or
+// -f to send them.
+type scpServer struct {
+	*transferFiles
+	input       *bufio.Reader
+	output      io.Writer
+	maxFileSize int
+	recursive   bool
+	times       bool
+	// failed is set once an error was reported to the client, making scp
+	// exit with 1.
+	failed bool
+}
+
+// ServeSCP serves scp run with the arguments args on channel, on the fake
+// filesystem shells see, and returns its exit status. With -t, the files
+// clients upload are put on the host unless they're larger than maxFileSize,
+// or maxTransferSize if it's 0 or larger, and with -f, files are sent from
+// the fake filesystem.
+func ServeSCP(ctx context.Context, sess *session.Session, cfg config.Shell, maxFileSize int, args []string, channel io.ReadWriter) (uint32, error) {
+	server := &scpServer{
+		transferFiles: newTransferFiles(ctx, sess, cfg, "scp"),
+		input:         bufio.NewReader(channel),
+		output:        channel,
+		maxFileSize:   uploadLimit(maxFileSize),
+	}
+	sink, source, dirTarget := false, false, false
+	targets := []string{}
+	for i, arg := range args[1:] {
+		if arg == "--" {
+			targets = args[i+2:]
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			targets = args[i+1:]
+			break
+		}
+		sink = sink || strings.Contains(arg, "t")
+		source = source || strings.Contains(arg, "f")
+		dirTarget = dirTarget || strings.Contains(arg, "d")
+		server.recursive = server.recursive || strings.Contains(arg, "r")
+		server.times = server.times || strings.Contains(arg, "p")
+	}
+	mode := "sink"
+	if source {
+		mode = "source"
+	}
+	resolved := make([]string, len(targets))
+	for i, target := range targets {
+		resolved[i] = server.resolve(target)
+	}
+	log.WithFields(log.Fields{
+		"client":  sess.RemoteAddr,
+		"channel": "session",
+		"mode":    mode,
+		"paths":   resolved,
+	}).Info("SCP transfer started")
+	var err error
+	switch {
+	case sink && !source && len(resolved) == 1:
+		err = server.receive(resolved[0], dirTarget)
+	case source && !sink && len(resolved) != 0:
+		err = server.sendAll(resolved)
+	default:
+		_, err = io.WriteString(server.output, "usage: scp [-346ABCpqrTv] [-c cipher] [-F ssh_config] [-i identity_file] [-J destination] [-l limit] [-o ssh_option] [-P port] [-S program] source ... target\n")
+		return 1, err
+	}
+	if err == errSCPProtocol || err == io.EOF || err == io.ErrUnexpectedEOF {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if server.failed {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// fail reports an error to the client, which carries on with the other files
+// unless fatal is set.
+func (server *scpServer) fail(fatal bool, format string, args ...interface{}) error {
+	server.failed = true
+	code := "\x01"
+	if fatal {
+		code = "\x02"
+	}
+	_, err := fmt.Fprintf(server.output, code+"scp: "+format+"\n", args...)
+	return err
+}
+
+func (server *scpServer) ack() error {
+	_, err := server.output.Write([]byte{0})
+	return err
+}
+
+// readLine reads a control line, without its line break.
+func (server *scpServer) readLine() (string, error) {
+	var line strings.Builder
+	for {
+		b, err := server.input.ReadByte()
+		if err != nil {
+			if err == io.EOF && line.Len() != 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		if b == '\n' {
+			return line.String(), nil
+		}
+		if line.Len() >= maxSCPLine {
+			return "", errSCPProtocol
+		}
+		line.WriteByte(b)
+	}
+}
+
+// response reads the client's answer to a control line or to data, logging
+// the errors it reports. It returns errSCPProtocol for fatal errors and
+// reports whether the client succeeded otherwise.
+func (server *scpServer) response() (bool, error) {
+	b, err := server.input.ReadByte()
+	if err != nil {
+		return false, err
+	}
+	if b == 0 {
+		return true, nil
+	}
+	if b != 1 && b != 2 {
+		return false, errSCPProtocol
+	}
+	message, err := server.readLine()
+	if err != nil {
+		return false, err
+	}
+	server.logClientError(message)
+	if b == 2 {
+		return false, errSCPProtocol
+	}
+	return false, nil
+}
+
+func (server *scpServer) logClientError(message string) {
+	log.WithFields(log.Fields{
+		"client":  server.process.shell.session.RemoteAddr,
+		"channel": "session",
+		"message": message,
+	}).Info("SCP client error received")
+}
+
+// receive receives files to target, which must be a directory if dirTarget
+// is set or several files are sent, as scp -t does.
+func (server *scpServer) receive(target string, dirTarget bool) error {
+	if dirTarget && !server.isDir(target) {
+		return server.fail(true, "%v: Not a directory", target)
+	}
+	if err := server.ack(); err != nil {
+		return err
+	}
+	// dirs are the directories being received into, the last one being the
+	// current one.
+	dirs := []string{}
+	for {
+		line, err := server.readLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if line == "" {
+			return errSCPProtocol
+		}
+		switch line[0] {
+		case 1, 2:
+			server.logClientError(line[1:])
+			if line[0] == 2 {
+				return nil
+			}
+			continue
+		case 'T':
+			if err := server.ack(); err != nil {
+				return err
+			}
+			continue
+		case 'E':
+			if len(dirs) == 0 {
+				return errSCPProtocol
+			}
+			dirs = dirs[:len(dirs)-1]
+			if err := server.ack(); err != nil {
+				return err
+			}
+			continue
+		case 'C', 'D':
+		default:
+			return server.fail(true, "protocol error: unexpected <%c>", line[0])
+		}
+		fields := strings.SplitN(line[1:], " ", 3)
+		if len(fields) != 3 {
+			return server.fail(true, "protocol error: bad mode")
+		}
+		mode, err := strconv.ParseUint(fields[0], 8, 32)
+		if err != nil {
+			return server.fail(true, "protocol error: bad mode")
+		}
+		size, err := strconv.ParseUint(fields[1], 10, 63)
+		if err != nil {
+			return server.fail(true, "protocol error: size not delimited")
+		}
+		name := fields[2]
+		if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+			return server.fail(true, "error: unexpected filename: %v", name)
+		}
+		filePath := target
+		if len(dirs) != 0 {
+			filePath = path.Join(dirs[len(dirs)-1], name)
+		} else if server.isDir(target) {
+			filePath = path.Join(target, name)
+		}
+		if line[0] == 'D' {
+			if !server.recursive {
+				return server.fail(true, "received directory without -r")
+			}
+			if info, exists := server.stat(filePath); exists && !info.dir() {
+				if err := server.fail(false, "%v: Not a directory", filePath); err != nil {
+					return err
+				}
+				continue
+			}
+			if !server.isDir(filePath) {
+				server.process.shell.session.MakeDir(filePath)
+			}
+			dirs = append(dirs, filePath)
+			if err := server.ack(); err != nil {
+				return err
+			}
+			continue
+		}
+		if !server.isDir(path.Dir(filePath)) {
+			if err := server.fail(false, "%v: No such file or directory", filePath); err != nil {
+				return err
+			}
+			continue
+		}
+		if size > uint64(server.maxFileSize) {
+			log.WithFields(log.Fields{
+				"client":        server.process.shell.session.RemoteAddr,
+				"channel":       "session",
+				"path":          filePath,
+				"size":          size,
+				"max_file_size": server.maxFileSize,
+			}).Info("SCP upload discarded")
+			if err := server.fail(false, "%v: No space left on device", filePath); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := server.ack(); err != nil {
+			return err
+		}
+		// The content is buffered as it arrives rather than for the size
+		// announced, which clients needn't send.
+		var content bytes.Buffer
+		if _, err := io.CopyN(&content, server.input, int64(size)); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		if ok, err := server.response(); err != nil || !ok {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		server.upload(filePath, content.Bytes(), "scp")
+		if mode&0111 != 0 {
+			server.process.shell.session.SetExecutable(filePath, true)
+		}
+		if err := server.ack(); err != nil {
+			return err
+		}
+	}
+}
+
+// sendAll sends the files at paths, once the client is ready, as scp -f
+// does.
+func (server *scpServer) sendAll(paths []string) error {
+	if ok, err := server.response(); err != nil || !ok {
+		return err
+	}
+	for _, filePath := range paths {
+		if err := server.send(filePath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send sends the file at filePath, and the contents of directories in
+// recursive mode.
+func (server *scpServer) send(filePath string) error {
+	info, exists := server.stat(filePath)
+	if !exists {
+		return server.fail(false, "%v: No such file or directory", filePath)
+	}
+	name := path.Base(filePath)
+	if server.times {
+		mtime := info.mtime.Unix()
+		if _, err := fmt.Fprintf(server.output, "T%v 0 %v 0\n", mtime, mtime); err != nil {
+			return err
+		}
+		if ok, err := server.response(); err != nil || !ok {
+			return err
+		}
+	}
+	if info.dir() {
+		if !server.recursive {
+			return server.fail(false, "%v: not a regular file", filePath)
+		}
+		if _, err := fmt.Fprintf(server.output, "D%04o 0 %v\n", info.mode&07777, name); err != nil {
+			return err
+		}
+		if ok, err := server.response(); err != nil || !ok {
+			return err
+		}
+		for _, entry := range server.listDir(filePath) {
+			if err := server.send(path.Join(filePath, entry)); err != nil {
+				return err
+			}
+		}
+		if _, err := io.WriteString(server.output, "E\n"); err != nil {
+			return err
+		}
+		_, err := server.response()
+		return err
+	}
+	content := server.content(filePath)
+	if _, err := fmt.Fprintf(server.output, "C%04o %v %v\n", info.mode&07777, len(content), name); err != nil {
+		return err
+	}
+	if ok, err := server.response(); err != nil || !ok {
+		return err
+	}
+	if _, err := io.WriteString(server.output, content+"\x00"); err != nil {
+		return err
+	}
+	if _, err := server.response(); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"client":  server.process.shell.session.RemoteAddr,
+		"channel": "session",
+		"path":    filePath,
+		"size":    len(content),
+	}).Info("SCP file sent")
+	return nil
+}
@@ -0,0 +1,73 @@
+package shell
+
+import (
+	"context"
+	"github.com/longkeyy/sshesame/config"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// scp serves scp with args and the upload limit maxFileSize to a root
+// session sending input, and returns what it wrote and its exit status.
+func scp(t *testing.T, maxFileSize int, args []string, input string) (string, uint32) {
+	t.Helper()
+	channel := &testChannel{input: strings.NewReader(input)}
+	status, err := ServeSCP(context.Background(), newTestSession("root"), config.Shell{Profile: DefaultProfile}, maxFileSize, args, channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return channel.stdout.String(), status
+}
+
+func TestSCPUpload(t *testing.T) {
+	hook := captureLog(t)
+	output, status := scp(t, 0, []string{"scp", "-t", "/root"}, "C0755 6 run.sh\nid -a\n\x00")
+	if output != "\x00\x00\x00" || status != 0 {
+		t.Errorf("scp -t wrote %q and exited with %v", output, status)
+	}
+	entry := lastEntry(hook, "File uploaded")
+	if entry == nil || entry.Data["path"] != "/root/run.sh" || entry.Data["size"] != 6 || entry.Data["source"] != "scp" {
+		t.Errorf("upload logged as %v", entry)
+	}
+}
+
+func TestSCPUploadBounds(t *testing.T) {
+	hook := captureLog(t)
+	for _, test := range []struct {
+		maxFileSize int
+		size        string
+		limit       int
+	}{
+		{0, "1099511627776", maxTransferSize},
+		{1 << 40, "67108865", maxTransferSize},
+		{8, "9", 8},
+	} {
+		hook.Reset()
+		output, status := scp(t, test.maxFileSize, []string{"scp", "-t", "/root"}, "C0644 "+test.size+" big.bin\n")
+		if output != "\x00\x01scp: /root/big.bin: No space left on device\n" || status != 1 {
+			t.Errorf("uploading %v bytes with the limit %v wrote %q and exited with %v", test.size, test.maxFileSize, output, status)
+		}
+		if entry := lastEntry(hook, "SCP upload discarded"); entry == nil || entry.Data["max_file_size"] != test.limit {
+			t.Errorf("uploading %v bytes with the limit %v logged the discarding as %v", test.size, test.maxFileSize, entry)
+		}
+	}
+}
+
+func TestSCPUploadCutShort(t *testing.T) {
+	hook := captureLog(t)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	// The client announces the largest file allowed but sends a few bytes.
+	output, status := scp(t, 0, []string{"scp", "-t", "/root"}, "C0644 67108864 big.bin\nhello")
+	runtime.ReadMemStats(&after)
+	if output != "\x00\x00" || status != 1 {
+		t.Errorf("scp -t wrote %q and exited with %v", output, status)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > maxTransferSize/4 {
+		t.Errorf("receiving 5 bytes allocated %v", allocated)
+	}
+	if entry := lastEntry(hook, "File uploaded"); entry != nil {
+		t.Errorf("a file cut short was uploaded: %v", entry.Data)
+	}
+}
@@ -0,0 +1,697 @@
+package shell
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/longkeyy/sshesame/config"
+	"github.com/longkeyy/sshesame/session"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The packet types, status codes and flags of version 3 of the SFTP
+// protocol, the one OpenSSH speaks.
+const (
+	sftpInit     = 1
+	sftpVersion  = 2
+	sftpOpen     = 3
+	sftpClose    = 4
+	sftpRead     = 5
+	sftpWrite    = 6
+	sftpLstat    = 7
+	sftpFstat    = 8
+	sftpSetstat  = 9
+	sftpFsetstat = 10
+	sftpOpendir  = 11
+	sftpReaddir  = 12
+	sftpRemove   = 13
+	sftpMkdir    = 14
+	sftpRmdir    = 15
+	sftpRealpath = 16
+	sftpStat     = 17
+	sftpRename   = 18
+	sftpReadlink = 19
+	sftpSymlink  = 20
+	sftpStatus   = 101
+	sftpHandle   = 102
+	sftpData     = 103
+	sftpName     = 104
+	sftpAttrs    = 105
+	sftpExtended = 200
+
+	sftpOK               = 0
+	sftpEOF              = 1
+	sftpNoSuchFile       = 2
+	sftpPermissionDenied = 3
+	sftpFailure          = 4
+	sftpOpUnsupported    = 8
+
+	sftpAttrSize        = 0x1
+	sftpAttrUIDGID      = 0x2
+	sftpAttrPermissions = 0x4
+	sftpAttrModTime     = 0x8
+
+	sftpOpenRead   = 0x1
+	sftpOpenWrite  = 0x2
+	sftpOpenAppend = 0x4
+	sftpOpenCreate = 0x8
+	sftpOpenTrunc  = 0x10
+	sftpOpenExcl   = 0x20
+)
+
+const (
+	// maxSFTPPacket is the largest packet accepted, the one of OpenSSH's
+	// sftp-server.
+	maxSFTPPacket = 256 * 1024
+	// maxSFTPRead is the most bytes a read returns.
+	maxSFTPRead = 64 * 1024
+	// sftpReaddirBatch is the most entries a readdir returns.
+	sftpReaddirBatch = 100
+	// maxTransferSize is the largest file clients can upload when no
+	// smaller limit is configured, bounding the memory uploads take.
+	maxTransferSize = 64 * 1024 * 1024
+)
+
+// uploadLimit returns the largest file clients can upload with the limit
+// maxFileSize configured, 0 for none.
+func uploadLimit(maxFileSize int) int {
+	if maxFileSize <= 0 || maxFileSize > maxTransferSize {
+		return maxTransferSize
+	}
+	return maxFileSize
+}
+
+// sftpOperations name the requests in the logs.
+var sftpOperations = map[byte]string{
+	sftpOpen:     "open",
+	sftpClose:    "close",
+	sftpRead:     "read",
+	sftpWrite:    "write",
+	sftpLstat:    "lstat",
+	sftpFstat:    "fstat",
+	sftpSetstat:  "setstat",
+	sftpFsetstat: "fsetstat",
+	sftpOpendir:  "opendir",
+	sftpReaddir:  "readdir",
+	sftpRemove:   "remove",
+	sftpMkdir:    "mkdir",
+	sftpRmdir:    "rmdir",
+	sftpRealpath: "realpath",
+	sftpStat:     "stat",
+	sftpRename:   "rename",
+	sftpReadlink: "readlink",
+	sftpSymlink:  "symlink",
+	sftpExtended: "extended",
+}
+
+// sftpStatuses are the messages of status codes, like sftp-server's, and
+// their names in the logs.
+var sftpStatuses = map[uint32][2]string{
+	sftpOK:               {"Success", "ok"},
+	sftpEOF:              {"End of file", "eof"},
+	sftpNoSuchFile:       {"No such file", "no_such_file"},
+	sftpPermissionDenied: {"Permission denied", "permission_denied"},
+	sftpFailure:          {"Failure", "failure"},
+	sftpOpUnsupported:    {"Operation unsupported", "op_unsupported"},
+}
+
+// sftpOpenFlags name the flags of open requests in the logs.
+var sftpOpenFlags = []struct {
+	flag uint32
+	name string
+}{
+	{sftpOpenRead, "read"},
+	{sftpOpenWrite, "write"},
+	{sftpOpenAppend, "append"},
+	{sftpOpenCreate, "create"},
+	{sftpOpenTrunc, "truncate"},
+	{sftpOpenExcl, "exclusive"},
+}
+
+type sftpPathRequest struct {
+	ID    uint32
+	Path  string
+	Attrs []byte `ssh:"rest"`
+}
+
+type sftpOpenRequest struct {
+	ID    uint32
+	Path  string
+	Flags uint32
+	Attrs []byte `ssh:"rest"`
+}
+
+type sftpHandleRequest struct {
+	ID     uint32
+	Handle string
+	Attrs  []byte `ssh:"rest"`
+}
+
+type sftpReadRequest struct {
+	ID     uint32
+	Handle string
+	Offset uint64
+	Length uint32
+}
+
+type sftpWriteRequest struct {
+	ID     uint32
+	Handle string
+	Offset uint64
+	Data   []byte
+}
+
+type sftpTwoPathRequest struct {
+	ID      uint32
+	Path    string
+	Target  string
+	Unknown []byte `ssh:"rest"`
+}
+
+type sftpExtendedRequest struct {
+	ID   uint32
+	Name string
+	Data []byte `ssh:"rest"`
+}
+
+// sftpPacket builds a packet.
+type sftpPacket []byte
+
+func (packet *sftpPacket) putUint32(value uint32) {
+	*packet = binary.BigEndian.AppendUint32(*packet, value)
+}
+
+func (packet *sftpPacket) putUint64(value uint64) {
+	*packet = binary.BigEndian.AppendUint64(*packet, value)
+}
+
+func (packet *sftpPacket) putString(value string) {
+	packet.putUint32(uint32(len(value)))
+	*packet = append(*packet, value...)
+}
+
+func (info fileInfo) putAttrs(packet *sftpPacket) {
+	packet.putUint32(sftpAttrSize | sftpAttrUIDGID | sftpAttrPermissions | sftpAttrModTime)
+	packet.putUint64(uint64(info.size))
+	packet.putUint32(0)
+	packet.putUint32(0)
+	packet.putUint32(info.mode)
+	packet.putUint32(uint32(info.mtime.Unix()))
+	packet.putUint32(uint32(info.mtime.Unix()))
+}
+
+// longName returns the line ls -l shows for the file called name, as
+// sftp-server formats it.
+func (info fileInfo) longName(name string) string {
+	links := 1
+	if info.dir() {
+		links = 2
+	}
+	return fmt.Sprintf("%v %3v %-8v %-8v %8v %v %v", info.modeString(), links, "root", "root", info.size, info.mtime.Format("Jan _2 15:04"), name)
+}
+
+// sftpFile is a file or directory a client opened.
+type sftpFile struct {
+	path string
+	// content is the content of files opened for reading, and what was
+	// written to those opened for writing.
+	content []byte
+	write   bool
+	// full is set once a write would have made the file too large.
+	full bool
+	dir  bool
+	// entries are the names of the entries of directories not read yet.
+	entries []string
+	// bytesRead and bytesWritten are logged once the file is closed.
+	bytesRead, bytesWritten int
+}
+
+// sftpServer serves the SFTP subsystem on the fake filesystem of a shell.
+type sftpServer struct {
+	*transferFiles
+	channel     io.ReadWriter
+	maxFileSize int
+	handles     map[string]*sftpFile
+	nextHandle  int
+}
+
+// ServeSFTP serves the SFTP subsystem on channel until the client closes it,
+// on the fake filesystem shells see. Every request is logged, reads and
+// writes at the debug level, and the files clients upload are put on the host
+// unless they're larger than maxFileSize, or maxTransferSize if it's 0 or
+// larger, writes beyond it failing like on a full disk.
+func ServeSFTP(ctx context.Context, sess *session.Session, cfg config.Shell, maxFileSize int, channel io.ReadWriter) error {
+	server := &sftpServer{
+		transferFiles: newTransferFiles(ctx, sess, cfg, "sftp-server"),
+		channel:       channel,
+		maxFileSize:   uploadLimit(maxFileSize),
+		handles:       map[string]*sftpFile{},
+	}
+	defer server.closeAll()
+	packetType, payload, err := server.readPacket()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if packetType != sftpInit || len(payload) < 4 {
+		return errors.New("SFTP session didn't start with an init request")
+	}
+	log.WithFields(log.Fields{
+		"client":         sess.RemoteAddr,
+		"channel":        "session",
+		"subsystem":      "sftp",
+		"client_version": binary.BigEndian.Uint32(payload),
+	}).Info("SFTP session started")
+	reply := sftpPacket{sftpVersion}
+	reply.putUint32(3)
+	if err := server.writePacket(reply); err != nil {
+		return err
+	}
+	for {
+		packetType, payload, err := server.readPacket()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := server.handle(packetType, payload); err != nil {
+			return err
+		}
+	}
+}
+
+func (server *sftpServer) readPacket() (byte, []byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(server.channel, header); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header)
+	if length == 0 || length > maxSFTPPacket {
+		return 0, nil, fmt.Errorf("invalid SFTP packet length %v", length)
+	}
+	packet := make([]byte, length)
+	if _, err := io.ReadFull(server.channel, packet); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	return packet[0], packet[1:], nil
+}
+
+func (server *sftpServer) writePacket(packet sftpPacket) error {
+	framed := make(sftpPacket, 0, 4+len(packet))
+	framed.putUint32(uint32(len(packet)))
+	_, err := server.channel.Write(append(framed, packet...))
+	return err
+}
+
+// status returns a status reply to the request with id, and logs it in
+// fields.
+func status(id uint32, code uint32, fields log.Fields) sftpPacket {
+	fields["status"] = sftpStatuses[code][1]
+	reply := sftpPacket{sftpStatus}
+	reply.putUint32(id)
+	reply.putUint32(code)
+	reply.putString(sftpStatuses[code][0])
+	reply.putString("")
+	return reply
+}
+
+// handle answers a request and logs it.
+func (server *sftpServer) handle(packetType byte, payload []byte) error {
+	operation, ok := sftpOperations[packetType]
+	if !ok {
+		operation = strconv.Itoa(int(packetType))
+	}
+	fields := log.Fields{
+		"client":    server.process.shell.session.RemoteAddr,
+		"channel":   "session",
+		"subsystem": "sftp",
+		"operation": operation,
+	}
+	var reply sftpPacket
+	var err error
+	switch packetType {
+	case sftpOpen:
+		request := sftpOpenRequest{}
+		if err = ssh.Unmarshal(payload, &request); err == nil {
+			reply = server.open(request, fields)
+		}
+	case sftpClose:
+		request := sftpHandleRequest{}
+		if err = ssh.Unmarshal(payload, &request); err == nil {
+			reply = server.close(request, fields)
+		}
+	case sftpRead:
+		request := sftpReadRequest{}
+		if err = ssh.Unmarshal(payload, &request); err == nil {
+			reply = server.read(request, fields)
+		}
+	case sftpWrite:
+		request := sftpWriteRequest{}
+		if err = ssh.Unmarshal(payload, &request); err == nil {
+			reply = server.write(request, fields)
+		}
+	case sftpFstat, sftpFsetstat, sftpReaddir:
+		request := sftpHandleRequest{}
+		if err = ssh.Unmarshal(payload, &request); err == nil {
+			reply = server.handleRequest(packetType, request, fields)
+		}
+	case sftpLstat, sftpStat, sftpSetstat, sftpOpendir, sftpRemove, sftpMkdir, sftpRmdir, sftpRealpath, sftpReadlink:
+		request := sftpPathRequest{}
+		if err = ssh.Unmarshal(payload, &request); err == nil {
+			reply = server.pathRequest(packetType, request, fields)
+		}
+	case sftpRename, sftpSymlink:
+		request := sftpTwoPathRequest{}
+		if err = ssh.Unmarshal(payload, &request); err == nil {
+			// Files can't be moved or linked, as if they belonged to
+			// another user.
+			filePath := server.resolve(request.Path)
+			fields["path"] = filePath
+			fields["target"] = request.Target
+			if _, ok := server.stat(filePath); ok || packetType == sftpSymlink {
+				reply = status(request.ID, sftpPermissionDenied, fields)
+			} else {
+				reply = status(request.ID, sftpNoSuchFile, fields)
+			}
+		}
+	case sftpExtended:
+		request := sftpExtendedRequest{}
+		if err = ssh.Unmarshal(payload, &request); err == nil {
+			fields["extension"] = request.Name
+			reply = status(request.ID, sftpOpUnsupported, fields)
+		}
+	default:
+		if len(payload) < 4 {
+			err = errors.New("missing request ID")
+			break
+		}
+		reply = status(binary.BigEndian.Uint32(payload), sftpOpUnsupported, fields)
+	}
+	if err != nil {
+		return fmt.Errorf("malformed SFTP %v request: %w", operation, err)
+	}
+	if packetType == sftpRead || packetType == sftpWrite {
+		log.WithFields(fields).Debug("SFTP request handled")
+	} else {
+		log.WithFields(fields).Info("SFTP request handled")
+	}
+	return server.writePacket(reply)
+}
+
+// addHandle returns a new handle to file.
+func (server *sftpServer) addHandle(file *sftpFile) string {
+	server.nextHandle++
+	handle := strconv.Itoa(server.nextHandle)
+	server.handles[handle] = file
+	return handle
+}
+
+func (server *sftpServer) open(request sftpOpenRequest, fields log.Fields) sftpPacket {
+	filePath := server.resolve(request.Path)
+	fields["path"] = filePath
+	flags := []string{}
+	for _, flag := range sftpOpenFlags {
+		if request.Flags&flag.flag != 0 {
+			flags = append(flags, flag.name)
+		}
+	}
+	fields["flags"] = strings.Join(flags, ",")
+	info, exists := server.stat(filePath)
+	if exists && info.dir() {
+		return status(request.ID, sftpFailure, fields)
+	}
+	file := &sftpFile{path: filePath}
+	if request.Flags&sftpOpenWrite != 0 {
+		if !exists && request.Flags&sftpOpenCreate == 0 {
+			return status(request.ID, sftpNoSuchFile, fields)
+		}
+		if exists && request.Flags&sftpOpenExcl != 0 {
+			return status(request.ID, sftpFailure, fields)
+		}
+		if !server.isDir(path.Dir(filePath)) {
+			return status(request.ID, sftpNoSuchFile, fields)
+		}
+		file.write = true
+		if exists && request.Flags&sftpOpenTrunc == 0 {
+			file.content = []byte(server.content(filePath))
+		}
+	} else {
+		if !exists {
+			return status(request.ID, sftpNoSuchFile, fields)
+		}
+		file.content = []byte(server.content(filePath))
+	}
+	handle := server.addHandle(file)
+	fields["status"] = sftpStatuses[sftpOK][1]
+	reply := sftpPacket{sftpHandle}
+	reply.putUint32(request.ID)
+	reply.putString(handle)
+	return reply
+}
+
+func (server *sftpServer) close(request sftpHandleRequest, fields log.Fields) sftpPacket {
+	file, ok := server.handles[request.Handle]
+	if !ok {
+		return status(request.ID, sftpFailure, fields)
+	}
+	delete(server.handles, request.Handle)
+	fields["path"] = file.path
+	if !file.dir {
+		fields["bytes_read"] = file.bytesRead
+		fields["bytes_written"] = file.bytesWritten
+	}
+	server.closeFile(file)
+	return status(request.ID, sftpOK, fields)
+}
+
+// closeFile puts the files opened for writing on the host, unless writes
+// failed.
+func (server *sftpServer) closeFile(file *sftpFile) {
+	if !file.write {
+		return
+	}
+	if file.full {
+		log.WithFields(log.Fields{
+			"client":        server.process.shell.session.RemoteAddr,
+			"channel":       "session",
+			"subsystem":     "sftp",
+			"path":          file.path,
+			"size":          len(file.content),
+			"max_file_size": server.maxFileSize,
+		}).Info("SFTP upload discarded")
+		return
+	}
+	server.upload(file.path, file.content, "sftp")
+}
+
+// closeAll closes the handles left open when the session ends.
+func (server *sftpServer) closeAll() {
+	handles := make([]string, 0, len(server.handles))
+	for handle := range server.handles {
+		handles = append(handles, handle)
+	}
+	sort.Strings(handles)
+	for _, handle := range handles {
+		server.closeFile(server.handles[handle])
+	}
+}
+
+func (server *sftpServer) read(request sftpReadRequest, fields log.Fields) sftpPacket {
+	file, ok := server.handles[request.Handle]
+	if !ok || file.dir {
+		return status(request.ID, sftpFailure, fields)
+	}
+	fields["path"] = file.path
+	fields["offset"] = request.Offset
+	fields["length"] = request.Length
+	if request.Offset >= uint64(len(file.content)) {
+		return status(request.ID, sftpEOF, fields)
+	}
+	end := request.Offset + uint64(request.Length)
+	if request.Length > maxSFTPRead {
+		end = request.Offset + maxSFTPRead
+	}
+	if end > uint64(len(file.content)) {
+		end = uint64(len(file.content))
+	}
+	data := file.content[request.Offset:end]
+	file.bytesRead += len(data)
+	fields["status"] = sftpStatuses[sftpOK][1]
+	reply := sftpPacket{sftpData}
+	reply.putUint32(request.ID)
+	reply.putString(string(data))
+	return reply
+}
+
+func (server *sftpServer) write(request sftpWriteRequest, fields log.Fields) sftpPacket {
+	file, ok := server.handles[request.Handle]
+	if !ok || !file.write {
+		return status(request.ID, sftpFailure, fields)
+	}
+	fields["path"] = file.path
+	fields["offset"] = request.Offset
+	fields["length"] = len(request.Data)
+	end := request.Offset + uint64(len(request.Data))
+	if end < request.Offset || end > uint64(server.maxFileSize) {
+		file.full = true
+		return status(request.ID, sftpFailure, fields)
+	}
+	if end > uint64(len(file.content)) {
+		file.content = append(file.content, make([]byte, int(end)-len(file.content))...)
+	}
+	copy(file.content[request.Offset:], request.Data)
+	file.bytesWritten += len(request.Data)
+	return status(request.ID, sftpOK, fields)
+}
+
+// handleRequest answers fstat, fsetstat and readdir requests.
+func (server *sftpServer) handleRequest(packetType byte, request sftpHandleRequest, fields log.Fields) sftpPacket {
+	file, ok := server.handles[request.Handle]
+	if !ok {
+		return status(request.ID, sftpFailure, fields)
+	}
+	fields["path"] = file.path
+	switch packetType {
+	case sftpFstat:
+		info := fileInfo{len(file.content), 0100644, time.Now()}
+		if existing, ok := server.stat(file.path); ok && !file.write {
+			info = existing
+		}
+		fields["status"] = sftpStatuses[sftpOK][1]
+		reply := sftpPacket{sftpAttrs}
+		reply.putUint32(request.ID)
+		info.putAttrs(&reply)
+		return reply
+	case sftpFsetstat:
+		server.setstat(file.path, request.Attrs, fields)
+		return status(request.ID, sftpOK, fields)
+	}
+	if !file.dir {
+		return status(request.ID, sftpFailure, fields)
+	}
+	if len(file.entries) == 0 {
+		return status(request.ID, sftpEOF, fields)
+	}
+	batch := file.entries
+	if len(batch) > sftpReaddirBatch {
+		batch = batch[:sftpReaddirBatch]
+	}
+	file.entries = file.entries[len(batch):]
+	fields["entries"] = len(batch)
+	fields["status"] = sftpStatuses[sftpOK][1]
+	reply := sftpPacket{sftpName}
+	reply.putUint32(request.ID)
+	reply.putUint32(uint32(len(batch)))
+	for _, name := range batch {
+		info, ok := server.stat(path.Join(file.path, name))
+		if name == "." || name == ".." || !ok {
+			info = fileInfo{4096, 040755, server.process.shell.system.Boot}
+		}
+		reply.putString(name)
+		reply.putString(info.longName(name))
+		info.putAttrs(&reply)
+	}
+	return reply
+}
+
+// setstat applies a change of permissions in attrs to the file at the
+// absolute filePath, the only attribute kept, and logs the mode.
+func (server *sftpServer) setstat(filePath string, attrs []byte, fields log.Fields) {
+	if len(attrs) < 4 {
+		return
+	}
+	flags := binary.BigEndian.Uint32(attrs)
+	offset := 4
+	if flags&sftpAttrSize != 0 {
+		offset += 8
+	}
+	if flags&sftpAttrUIDGID != 0 {
+		offset += 8
+	}
+	if flags&sftpAttrPermissions == 0 || len(attrs) < offset+4 {
+		return
+	}
+	mode := binary.BigEndian.Uint32(attrs[offset:])
+	fields["mode"] = fmt.Sprintf("%04o", mode&07777)
+	server.process.shell.session.SetExecutable(filePath, mode&0111 != 0)
+}
+
+// pathRequest answers the requests naming a path.
+func (server *sftpServer) pathRequest(packetType byte, request sftpPathRequest, fields log.Fields) sftpPacket {
+	filePath := server.resolve(request.Path)
+	fields["path"] = filePath
+	info, exists := server.stat(filePath)
+	switch packetType {
+	case sftpRealpath:
+		fields["status"] = sftpStatuses[sftpOK][1]
+		reply := sftpPacket{sftpName}
+		reply.putUint32(request.ID)
+		reply.putUint32(1)
+		reply.putString(filePath)
+		reply.putString(filePath)
+		reply.putUint32(0)
+		return reply
+	case sftpMkdir:
+		if exists {
+			return status(request.ID, sftpFailure, fields)
+		}
+		if !server.isDir(path.Dir(filePath)) {
+			return status(request.ID, sftpNoSuchFile, fields)
+		}
+		server.process.shell.session.MakeDir(filePath)
+		return status(request.ID, sftpOK, fields)
+	}
+	if !exists {
+		return status(request.ID, sftpNoSuchFile, fields)
+	}
+	switch packetType {
+	case sftpStat, sftpLstat:
+		fields["status"] = sftpStatuses[sftpOK][1]
+		reply := sftpPacket{sftpAttrs}
+		reply.putUint32(request.ID)
+		info.putAttrs(&reply)
+		return reply
+	case sftpSetstat:
+		server.setstat(filePath, request.Attrs, fields)
+		return status(request.ID, sftpOK, fields)
+	case sftpOpendir:
+		if !info.dir() {
+			return status(request.ID, sftpFailure, fields)
+		}
+		entries := append([]string{".", ".."}, server.listDir(filePath)...)
+		handle := server.addHandle(&sftpFile{path: filePath, entries: entries, dir: true})
+		fields["status"] = sftpStatuses[sftpOK][1]
+		reply := sftpPacket{sftpHandle}
+		reply.putUint32(request.ID)
+		reply.putString(handle)
+		return reply
+	case sftpRemove:
+		if info.dir() {
+			return status(request.ID, sftpFailure, fields)
+		}
+		server.process.shell.session.Remove(filePath)
+		return status(request.ID, sftpOK, fields)
+	case sftpRmdir:
+		if !info.dir() || filePath == "/" || len(server.listDir(filePath)) != 0 {
+			return status(request.ID, sftpFailure, fields)
+		}
+		server.process.shell.session.Remove(filePath)
+		return status(request.ID, sftpOK, fields)
+	}
+	// Readlink, the fake filesystem has no links.
+	return status(request.ID, sftpFailure, fields)
+}
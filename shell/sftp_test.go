@@ -0,0 +1,170 @@
+package shell
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/longkeyy/sshesame/config"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"net"
+	"testing"
+)
+
+// sftpClient speaks SFTP to a server on the other end of conn.
+type sftpClient struct {
+	t    *testing.T
+	conn net.Conn
+	id   uint32
+}
+
+// newSFTPClient serves SFTP to a root session with the upload limit
+// maxFileSize, and returns a client having sent the init request.
+func newSFTPClient(t *testing.T, maxFileSize int) *sftpClient {
+	t.Helper()
+	clientEnd, serverEnd := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- ServeSFTP(context.Background(), newTestSession("root"), config.Shell{Profile: DefaultProfile}, maxFileSize, serverEnd)
+		serverEnd.Close()
+	}()
+	t.Cleanup(func() {
+		clientEnd.Close()
+		<-done
+	})
+	client := &sftpClient{t: t, conn: clientEnd}
+	if packetType, _ := client.send(sftpInit, struct{ Version uint32 }{3}); packetType != sftpVersion {
+		t.Fatalf("init answered with packet type %v", packetType)
+	}
+	return client
+}
+
+// send sends a request of packetType with the fields of request, and returns
+// the reply.
+func (client *sftpClient) send(packetType byte, request interface{}) (byte, []byte) {
+	client.t.Helper()
+	payload := append([]byte{packetType}, ssh.Marshal(request)...)
+	packet := make([]byte, 4, 4+len(payload))
+	binary.BigEndian.PutUint32(packet, uint32(len(payload)))
+	if _, err := client.conn.Write(append(packet, payload...)); err != nil {
+		client.t.Fatal(err)
+	}
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(client.conn, header); err != nil {
+		client.t.Fatal(err)
+	}
+	reply := make([]byte, binary.BigEndian.Uint32(header))
+	if _, err := io.ReadFull(client.conn, reply); err != nil {
+		client.t.Fatal(err)
+	}
+	return reply[0], reply[1:]
+}
+
+// open opens the file at filePath with flags and returns its handle.
+func (client *sftpClient) open(filePath string, flags uint32) string {
+	client.t.Helper()
+	client.id++
+	packetType, reply := client.send(sftpOpen, struct {
+		ID    uint32
+		Path  string
+		Flags uint32
+		Attrs uint32
+	}{client.id, filePath, flags, 0})
+	handle := struct {
+		ID     uint32
+		Handle string
+	}{}
+	if packetType != sftpHandle || ssh.Unmarshal(reply, &handle) != nil {
+		client.t.Fatalf("opening %v answered with packet type %v", filePath, packetType)
+	}
+	return handle.Handle
+}
+
+// write writes data at offset into the file handle is of, and returns the
+// status code of the reply.
+func (client *sftpClient) write(handle string, offset uint64, data []byte) uint32 {
+	client.t.Helper()
+	client.id++
+	return client.status(client.send(sftpWrite, sftpWriteRequest{client.id, handle, offset, data}))
+}
+
+// close closes handle and returns the status code of the reply.
+func (client *sftpClient) close(handle string) uint32 {
+	client.t.Helper()
+	client.id++
+	return client.status(client.send(sftpClose, struct {
+		ID     uint32
+		Handle string
+	}{client.id, handle}))
+}
+
+// status returns the code of a status reply.
+func (client *sftpClient) status(packetType byte, reply []byte) uint32 {
+	client.t.Helper()
+	if packetType != sftpStatus || len(reply) < 8 {
+		client.t.Fatalf("answered with packet type %v, want a status", packetType)
+	}
+	return binary.BigEndian.Uint32(reply[4:])
+}
+
+func TestSFTPUpload(t *testing.T) {
+	hook := captureLog(t)
+	client := newSFTPClient(t, 0)
+	handle := client.open("/root/upload.bin", sftpOpenWrite|sftpOpenCreate|sftpOpenTrunc)
+	// Writes can come out of order, like the pipelined ones of OpenSSH.
+	for _, write := range []struct {
+		offset uint64
+		data   string
+	}{{6, "world"}, {0, "hello "}} {
+		if code := client.write(handle, write.offset, []byte(write.data)); code != sftpOK {
+			t.Errorf("writing %q at %v answered with %v", write.data, write.offset, code)
+		}
+	}
+	if code := client.close(handle); code != sftpOK {
+		t.Errorf("closing answered with %v", code)
+	}
+	sum := sha256.Sum256([]byte("hello world"))
+	entry := lastEntry(hook, "File uploaded")
+	if entry == nil || entry.Data["path"] != "/root/upload.bin" || entry.Data["size"] != 11 || entry.Data["sha256"] != hex.EncodeToString(sum[:]) || entry.Data["source"] != "sftp" {
+		t.Errorf("upload logged as %v", entry)
+	}
+}
+
+func TestSFTPWriteBounds(t *testing.T) {
+	hook := captureLog(t)
+	for _, test := range []struct {
+		maxFileSize int
+		offset      uint64
+		length      int
+		limit       int
+	}{
+		// Offsets so large the end of the write overflows.
+		{0, ^uint64(0), 1, maxTransferSize},
+		{0, ^uint64(0) - 2, 8, maxTransferSize},
+		// Writes far past the end aren't allocated for, even with no limit
+		// configured.
+		{0, 1 << 40, 1, maxTransferSize},
+		{0, maxTransferSize, 1, maxTransferSize},
+		{1 << 40, maxTransferSize, 1, maxTransferSize},
+		{8, 4, 5, 8},
+	} {
+		hook.Reset()
+		client := newSFTPClient(t, test.maxFileSize)
+		handle := client.open("/root/upload.bin", sftpOpenWrite|sftpOpenCreate)
+		if code := client.write(handle, test.offset, make([]byte, test.length)); code != sftpFailure {
+			t.Errorf("writing %v bytes at %v with the limit %v answered with %v, want a failure", test.length, test.offset, test.maxFileSize, code)
+		}
+		// Writes that fit still succeed, but the file is discarded.
+		if code := client.write(handle, 0, []byte("x")); code != sftpOK {
+			t.Errorf("writing within the limit answered with %v", code)
+		}
+		client.close(handle)
+		if entry := lastEntry(hook, "SFTP upload discarded"); entry == nil || entry.Data["size"] != 1 || entry.Data["max_file_size"] != test.limit {
+			t.Errorf("writing %v bytes at %v with the limit %v logged the discarding as %v", test.length, test.offset, test.maxFileSize, entry)
+		}
+		if entry := lastEntry(hook, "File uploaded"); entry != nil {
+			t.Errorf("writing %v bytes at %v with the limit %v uploaded %v", test.length, test.offset, test.maxFileSize, entry.Data)
+		}
+	}
+}
@@ -0,0 +1,513 @@
+// Package shell emulates an interactive shell on session channels.
+package shell
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/longkeyy/sshesame/config"
+	"github.com/longkeyy/sshesame/session"
+	"github.com/longkeyy/sshesame/tracing"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// shell is the state of a single emulated shell session.
+type shell struct {
+	session *session.Session
+	cfg     config.Shell
+	system  *System
+	// span traces the channel, commands are traced as its children.
+	span *tracing.Span
+	// interactive is set for shells running on a terminal.
+	interactive bool
+	exit        bool
+	// status is the exit status of the last command, $?.
+	status int
+	// dir is the working directory, the home directory if empty, and oldDir
+	// the previous one, $OLDPWD.
+	dir, oldDir string
+	// prompt, if set, writes output and asks the client for a line, for
+	// commands prompting for input.
+	prompt func(output []byte, prompt string, echo bool) (string, error)
+}
+
+// process is a single invocation of a command.
+type process struct {
+	shell *shell
+	args  []string
+	// stdin is the input of the process, from a here-document.
+	stdin          string
+	stdout, stderr bytes.Buffer
+	status         int
+	// signal is the signal the process died of, if any.
+	signal       string
+	coreDumped   bool
+	errorMessage string
+}
+
+// Exit is how a command run by an exec request ended.
+type Exit struct {
+	Status uint32
+	// Signal is the signal the command died of, in which case Status is
+	// meaningless.
+	Signal       string
+	CoreDumped   bool
+	ErrorMessage string
+}
+
+func newShell(ctx context.Context, sess *session.Session, cfg config.Shell, interactive bool) *shell {
+	profile := LookupProfile(cfg.Profile)
+	if profile == nil {
+		profile = LookupProfile(DefaultProfile)
+	}
+	system := NewSystem(profile, sess.Seed, sess.Origin, sess.User)
+	system.addAccounts(sess)
+	system.addLogins(sess)
+	system.addHistory(sess)
+	return &shell{
+		session:     sess,
+		cfg:         cfg,
+		system:      system,
+		span:        tracing.SpanFromContext(ctx),
+		interactive: interactive,
+	}
+}
+
+// errIdle is returned by reads from shells that were idle for too long.
+var errIdle = errors.New("timed out waiting for input")
+
+// Run reads command lines from the client until it disconnects or exits the
+// shell. It returns io.EOF if the client closed its end of the channel.
+func Run(ctx context.Context, sess *session.Session, cfg config.Shell, channel ssh.Channel) error {
+	if !sess.PTY() {
+		return runWithoutTerminal(ctx, sess, cfg, channel)
+	}
+	shell := newShell(ctx, sess, cfg, true)
+	var input io.ReadWriter = channel
+	if cfg.RecordingDir != "" {
+		recording, err := newRecording(sess, cfg.RecordingDir, cfg.RecordingFormat)
+		if err != nil {
+			log.Warning("Failed to start recording session:", err.Error())
+		} else {
+			defer func() {
+				if err := recording.Close(); err != nil {
+					log.Warning("Failed to record session:", err.Error())
+				}
+			}()
+			input = recordingWriter{ReadWriter: input, recording: recording}
+		}
+	}
+	if cfg.LogKeystrokes {
+		input = &keystrokeLogger{ReadWriter: input, session: sess}
+	}
+	input = &controlFilter{ReadWriter: input, session: sess}
+	// output writes to the terminal directly, bypassing it.
+	output := input
+	var idle *time.Timer
+	if cfg.IdleTimeout > 0 {
+		// Reads from the channel can't be interrupted, so they go through a
+		// pipe that can be closed once the shell is idle for too long.
+		reader, writer := io.Pipe()
+		go func(input io.Reader) {
+			_, err := io.Copy(writer, input)
+			writer.CloseWithError(err)
+		}(input)
+		idle = time.AfterFunc(cfg.IdleTimeout, func() {
+			writer.CloseWithError(errIdle)
+		})
+		defer idle.Stop()
+		input = struct {
+			io.Reader
+			io.Writer
+		}{reader, input}
+	}
+	prompt := shell.expandPrompt(cfg.Prompt)
+	terminal := terminal.NewTerminal(input, prompt)
+	// The terminal echoes input unless the client turned ECHO off.
+	echo, set := sess.TerminalMode("ECHO")
+	readLine := terminal.ReadLine
+	if set && echo == 0 {
+		readLine = func() (string, error) { return terminal.ReadPassword(prompt) }
+	}
+	// readBody reads the lines of a here-document, after a secondary prompt.
+	readBody := func() (string, error) {
+		if set && echo == 0 {
+			return terminal.ReadPassword("> ")
+		}
+		terminal.SetPrompt("> ")
+		defer terminal.SetPrompt(prompt)
+		return terminal.ReadLine()
+	}
+	shell.prompt = func(output []byte, text string, echo bool) (string, error) {
+		if _, err := terminal.Write(output); err != nil {
+			return "", err
+		}
+		if !echo {
+			return terminal.ReadPassword(text)
+		}
+		terminal.SetPrompt(text)
+		defer terminal.SetPrompt(prompt)
+		return terminal.ReadLine()
+	}
+	if sess.Shutdown != nil {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-sess.Shutdown:
+				// Like the wall message of systemd, which the terminal
+				// prints above the line being edited.
+				fmt.Fprintf(terminal, "\nBroadcast message from root@%v (%v):\n\nThe system will power off now!\n\n", shell.system.Hostname, time.Now().UTC().Format("Mon 2006-01-02 15:04:05 MST"))
+			case <-done:
+			}
+		}()
+	}
+	if cfg.MOTD != nil {
+		if _, err := terminal.Write([]byte(shell.motd(cfg.MOTD))); err != nil {
+			return err
+		}
+	} else if login := shell.system.lastLogin(sess.User); login != nil && shell.system.Profile.Commands == nil {
+		if _, err := fmt.Fprintf(terminal, "Last login: %v from %v\n", login.Start.Format("Mon Jan _2 15:04:05 2006"), login.Host); err != nil {
+			return err
+		}
+	}
+	for {
+		line, err := readLine()
+		if err == errIdle {
+			log.WithFields(log.Fields{
+				"client":       sess.RemoteAddr,
+				"channel":      "session",
+				"idle_timeout": cfg.IdleTimeout.String(),
+			}).Info("Shell timed out")
+			_, err := output.Write([]byte("\r\ntimed out waiting for input: auto-logout\r\n"))
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if idle != nil {
+			idle.Reset(cfg.IdleTimeout)
+		}
+		log.WithFields(log.Fields{
+			"client":  sess.RemoteAddr,
+			"channel": "session",
+			"line":    line,
+		}).Info("Channel input received")
+		shell.remember(line)
+		stdin, err := shell.readInput(line, readBody)
+		if err != nil {
+			return err
+		}
+		process := shell.run(line, stdin)
+		if process == nil {
+			continue
+		}
+		if err := delay(ctx, cfg, process.stdout.Len()+process.stderr.Len()); err != nil {
+			return err
+		}
+		if _, err := terminal.Write(process.stdout.Bytes()); err != nil {
+			return err
+		}
+		if _, err := terminal.Write(process.stderr.Bytes()); err != nil {
+			return err
+		}
+		if shell.exit {
+			return nil
+		}
+		// The prompt shows the working directory, which cd changes.
+		prompt = shell.expandPrompt(cfg.Prompt)
+		terminal.SetPrompt(prompt)
+	}
+}
+
+// runWithoutTerminal runs a shell reading lines from a channel without a
+// pseudo-terminal, which neither prompts nor echoes.
+func runWithoutTerminal(ctx context.Context, sess *session.Session, cfg config.Shell, channel ssh.Channel) error {
+	shell := newShell(ctx, sess, cfg, false)
+	reader := bufio.NewReader(channel)
+	shell.prompt = func(output []byte, prompt string, echo bool) (string, error) {
+		if _, err := channel.Write(append(output, prompt...)); err != nil {
+			return "", err
+		}
+		line, err := reader.ReadString('\n')
+		if line == "" && err != nil {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	readLine := func() (string, error) {
+		line, err := reader.ReadString('\n')
+		if line == "" && err != nil {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	for {
+		line, err := reader.ReadString('\n')
+		if line == "" && err != nil {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		log.WithFields(log.Fields{
+			"client":  sess.RemoteAddr,
+			"channel": "session",
+			"line":    line,
+		}).Info("Channel input received")
+		stdin, inputErr := shell.readInput(line, readLine)
+		if inputErr != nil {
+			return inputErr
+		}
+		process := shell.run(line, stdin)
+		if process != nil {
+			if err := delay(ctx, cfg, process.stdout.Len()+process.stderr.Len()); err != nil {
+				return err
+			}
+			if _, err := channel.Write(process.stdout.Bytes()); err != nil {
+				return err
+			}
+			if _, err := channel.Stderr().Write(process.stderr.Bytes()); err != nil {
+				return err
+			}
+			if shell.exit {
+				return nil
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Exec runs command as requested by an exec request, writing its output to
+// channel, and returns how it ended. A command of several lines is run as a
+// script.
+func Exec(ctx context.Context, sess *session.Session, cfg config.Shell, command string, channel ssh.Channel) (Exit, error) {
+	process := newShell(ctx, sess, cfg, false).runScript(command)
+	if process == nil {
+		return Exit{}, nil
+	}
+	if err := delay(ctx, cfg, process.stdout.Len()+process.stderr.Len()); err != nil {
+		return Exit{}, err
+	}
+	if _, err := channel.Write(process.stdout.Bytes()); err != nil {
+		return Exit{}, err
+	}
+	if _, err := channel.Stderr().Write(process.stderr.Bytes()); err != nil {
+		return Exit{}, err
+	}
+	return Exit{uint32(process.status), process.signal, process.coreDumped, process.errorMessage}, nil
+}
+
+// run executes a command line reading stdin, the body of the here-document
+// it redirects input from if any. Its pipelines are run in turn as their
+// control operators tell, and the commands of a pipeline read the output of
+// the previous one. It returns a process with the output of every pipeline,
+// the errors of every command and how the last one ended, or nil for empty
+// lines.
+func (shell *shell) run(line, stdin string) *process {
+	pipelines := splitList(line)
+	if len(pipelines) == 0 {
+		return nil
+	}
+	if len(pipelines) == 1 && len(pipelines[0].commands) == 1 {
+		return shell.runCommand(pipelines[0].commands[0], "", stdin)
+	}
+	if shell.cfg.Responses.Find(line, nil) != nil || shell.system.Profile.Responses.Find(line, nil) != nil {
+		// Responses matching the whole line answer it at once.
+		return shell.runCommand(line, "", stdin)
+	}
+	var result *process
+	operator := ""
+	for _, pipeline := range pipelines {
+		if shell.exit {
+			break
+		}
+		if result != nil && (operator == "&&" && result.status != 0 || operator == "||" && result.status == 0) {
+			operator = pipeline.operator
+			continue
+		}
+		operator = pipeline.operator
+		process := shell.runPipeline(pipeline.commands, line, stdin)
+		if process == nil {
+			continue
+		}
+		if result == nil {
+			result = process
+			continue
+		}
+		result.stdout.Write(process.stdout.Bytes())
+		result.stderr.Write(process.stderr.Bytes())
+		result.status = process.status
+		result.signal = process.signal
+		result.coreDumped = process.coreDumped
+		result.errorMessage = process.errorMessage
+	}
+	return result
+}
+
+// runPipeline runs the commands of a pipeline of line in turn, the first
+// one, and any redirecting input from a here-document, reading stdin.
+func (shell *shell) runPipeline(commands []string, line, stdin string) *process {
+	var last *process
+	var stderr bytes.Buffer
+	input := stdin
+	for i, command := range commands {
+		if _, _, ok := hereDocument(command); ok && i != 0 {
+			input = stdin
+		}
+		process := shell.runCommand(command, line, input)
+		input = ""
+		if process == nil {
+			continue
+		}
+		stderr.Write(process.stderr.Bytes())
+		input = process.stdout.String()
+		last = process
+	}
+	if last != nil {
+		last.stderr.Reset()
+		last.stderr.Write(stderr.Bytes())
+	}
+	return last
+}
+
+// runCommand executes a simple command of line, or of itself if line is
+// empty, reading stdin, and logs it. It returns nil for empty commands.
+func (shell *shell) runCommand(command, line, stdin string) *process {
+	args, err := split(command, shell.variables())
+	if err != nil {
+		args = strings.Fields(command)
+	}
+	args = withoutHereDocument(args)
+	if len(args) == 0 {
+		return nil
+	}
+	span := shell.span.Child("command", map[string]string{"command": command, "user": shell.session.User})
+	defer span.End()
+	var process *process
+	fields := log.Fields{
+		"client":  shell.session.RemoteAddr,
+		"channel": "session",
+		"command": command,
+	}
+	if line != "" {
+		fields["command_line"] = line
+	}
+	words, redirects := parseRedirections(args)
+	if shell.cfg.Restricted {
+		process = shell.restrict(args)
+	}
+	if process != nil {
+		fields["restricted"] = true
+	} else if response := shell.cfg.Responses.Find(command, args); response != nil {
+		process = shell.respond(args, response)
+		fields["response"] = response.Path
+	} else if response := shell.system.Profile.Responses.Find(command, args); response != nil {
+		profileResponse := *response
+		profileResponse.Stdout = strings.ReplaceAll(response.Stdout, "{hostname}", strings.SplitN(shell.system.Hostname, ".", 2)[0])
+		process = shell.respond(args, &profileResponse)
+		fields["response"] = "profile"
+	} else {
+		process = shell.executeRedirected(words, redirects, stdin)
+	}
+	fields["exit_status"] = process.status
+	span.SetAttribute("exit_status", strconv.Itoa(process.status))
+	if category := classify(args); category != "" {
+		fields["category"] = category
+	} else if expandsDetectionVariable(command) {
+		fields["category"] = "detection_attempt"
+	}
+	if shell.cfg.Restricted && escapeAttempt(args) {
+		fields["category"] = "restricted_escape_attempt"
+	}
+	if lowSignalCommands[args[0]] {
+		log.WithFields(fields).Debug("Command executed")
+	} else {
+		log.WithFields(fields).Info("Command executed")
+	}
+	if len(words) != 0 {
+		if manager, packages := packageInstall(words); manager != "" {
+			shell.logPackageInstall(manager, packages)
+		}
+	}
+	shell.status = process.status
+	return process
+}
+
+func (shell *shell) execute(args []string, stdin string) *process {
+	process := &process{shell: shell, args: args, stdin: stdin}
+	profile := shell.system.Profile
+	if strings.Contains(args[0], "/") && profile.Commands == nil {
+		process.status = process.executePath()
+		return process
+	}
+	command, ok := commands[args[0]]
+	if !ok || !profile.hasCommand(args[0]) {
+		if profile.CommandNotFound != "" {
+			fmt.Fprintf(&process.stderr, profile.CommandNotFound, args[0])
+		} else {
+			process.errorf("%v: command not found\n", args[0])
+		}
+		process.status = 127
+		return process
+	}
+	process.status = command(process)
+	return process
+}
+
+// respond answers a command with a canned response.
+func (shell *shell) respond(args []string, response *config.Response) *process {
+	process := &process{shell: shell, args: args, status: response.ExitStatus}
+	process.stdout.WriteString(response.Stdout)
+	process.stderr.WriteString(response.Stderr)
+	if response.ExitSignal == "" {
+		return process
+	}
+	signal := config.Signals[response.ExitSignal]
+	process.status = 128 + signal.Number
+	if shell.interactive {
+		// The signal only reaches the client for exec requests, shells
+		// report it like bash.
+		if signal.Description != "" {
+			if response.CoreDumped {
+				fmt.Fprintf(&process.stderr, "%v (core dumped)\n", signal.Description)
+			} else {
+				fmt.Fprintln(&process.stderr, signal.Description)
+			}
+		}
+		return process
+	}
+	process.signal = response.ExitSignal
+	process.coreDumped = response.CoreDumped
+	process.errorMessage = response.ErrorMessage
+	return process
+}
+
+// delay waits for the configured command latency, scaled by the size of the
+// output. It returns early with the context's error if ctx is cancelled.
+func delay(ctx context.Context, cfg config.Shell, outputSize int) error {
+	duration := cfg.CommandDelay + cfg.CommandDelayPerKB*time.Duration(outputSize)/1024
+	if cfg.CommandJitter > 0 {
+		duration += time.Duration(rand.Int63n(int64(cfg.CommandJitter)))
+	}
+	if duration <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,153 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"github.com/longkeyy/sshesame/config"
+	"github.com/longkeyy/sshesame/session"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testChannel is a session channel reading input and writing to buffers.
+type testChannel struct {
+	input          io.Reader
+	stdout, stderr bytes.Buffer
+}
+
+func (channel *testChannel) Read(data []byte) (int, error) {
+	if channel.input == nil {
+		return 0, io.EOF
+	}
+	return channel.input.Read(data)
+}
+
+func (channel *testChannel) Write(data []byte) (int, error) {
+	return channel.stdout.Write(data)
+}
+
+func (channel *testChannel) Close() error {
+	return nil
+}
+
+func (channel *testChannel) CloseWrite() error {
+	return nil
+}
+
+func (channel *testChannel) SendRequest(name string, wantReply bool, payload []byte) (bool, error) {
+	return true, nil
+}
+
+func (channel *testChannel) Stderr() io.ReadWriter {
+	return &channel.stderr
+}
+
+// newTestSession returns the session of a client of 192.0.2.1 logged in as
+// user, with a fixed seed so that the fake host is the same every run.
+func newTestSession(user string) *session.Session {
+	sess := session.New(&net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 50000})
+	sess.User = user
+	sess.Seed = 1
+	return sess
+}
+
+// newTestShell returns a shell of sess configured by cfg, the default system
+// profile's if it doesn't set one.
+func newTestShell(sess *session.Session, cfg config.Shell) *shell {
+	if cfg.Profile == "" {
+		cfg.Profile = DefaultProfile
+	}
+	return newShell(context.Background(), sess, cfg, false)
+}
+
+// runScript runs the lines of script in shell, returning their output and
+// the exit status of the last one.
+func runScript(t *testing.T, shell *shell, script string) (string, string, int) {
+	t.Helper()
+	process := shell.runScript(script)
+	if process == nil {
+		t.Fatalf("%q ran no command", script)
+	}
+	return process.stdout.String(), process.stderr.String(), process.status
+}
+
+// run runs command in a new shell of root configured by cfg, returning its
+// output and exit status.
+func run(t *testing.T, cfg config.Shell, command string) (string, string, int) {
+	t.Helper()
+	return runScript(t, newTestShell(newTestSession("root"), cfg), command)
+}
+
+// output returns the output of command run in shell, failing the test if it
+// fails.
+func output(t *testing.T, shell *shell, command string) string {
+	t.Helper()
+	stdout, stderr, status := runScript(t, shell, command)
+	if status != 0 {
+		t.Fatalf("%q exited with %v: %q", command, status, stderr)
+	}
+	return stdout
+}
+
+// captureLog records the entries logged for the rest of the test at every
+// level instead of printing them.
+func captureLog(t *testing.T) *logtest.Hook {
+	hook := logtest.NewGlobal()
+	out, level := log.StandardLogger().Out, log.GetLevel()
+	log.SetOutput(ioutil.Discard)
+	log.SetLevel(log.DebugLevel)
+	t.Cleanup(func() {
+		log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+		log.SetOutput(out)
+		log.SetLevel(level)
+	})
+	return hook
+}
+
+func TestExecDelaysOutput(t *testing.T) {
+	cfg := config.Shell{Profile: DefaultProfile, CommandDelay: 50 * time.Millisecond}
+	channel := &testChannel{}
+	start := time.Now()
+	exit, err := Exec(context.Background(), newTestSession("root"), cfg, "echo hello", channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < cfg.CommandDelay {
+		t.Errorf("output written after %v, want at least %v", elapsed, cfg.CommandDelay)
+	}
+	if exit.Status != 0 || channel.stdout.String() != "hello\n" {
+		t.Errorf("Exec = %v with output %q, want status 0 and \"hello\\n\"", exit.Status, channel.stdout.String())
+	}
+}
+
+func TestExecDelayScalesWithOutput(t *testing.T) {
+	cfg := config.Shell{Profile: DefaultProfile, CommandDelayPerKB: 10 * time.Millisecond}
+	argument := strings.Repeat("x", 10*1024)
+	start := time.Now()
+	if _, err := Exec(context.Background(), newTestSession("root"), cfg, "echo "+argument, &testChannel{}); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("10 KiB of output written after %v, want at least 100ms", elapsed)
+	}
+}
+
+func TestExecDelayEndsWithContext(t *testing.T) {
+	cfg := config.Shell{Profile: DefaultProfile, CommandDelay: time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	channel := &testChannel{}
+	_, err := Exec(ctx, newTestSession("root"), cfg, "echo hello", channel)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Exec = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if channel.stdout.Len() != 0 {
+		t.Errorf("output %q written after the context ended", channel.stdout.String())
+	}
+}
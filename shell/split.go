@@ -0,0 +1,130 @@
+package shell
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	errUnterminatedQuote  = errors.New("unterminated quoted string")
+	errUnterminatedEscape = errors.New("unterminated escape sequence")
+)
+
+// Split tokenizes a command line into its arguments the way a POSIX shell
+// would, honouring single quotes, double quotes and backslash escapes, and
+// dropping backslash-newline line continuations outside single quotes.
+// Expansions and operators are not interpreted.
+func Split(line string) ([]string, error) {
+	return split(line, nil)
+}
+
+// split is like Split, but also expands $NAME and ${NAME} outside single
+// quotes to the value of the variable in env, an unset one to nothing, unless
+// env is nil.
+func split(line string, env map[string]string) ([]string, error) {
+	args := []string{}
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case escaped:
+			escaped = false
+			if r == '\n' {
+				// A line continuation, dropped along with its backslash.
+				break
+			}
+			if quote == '"' && !strings.ContainsRune("$`\"\\", r) {
+				arg.WriteRune('\\')
+			}
+			arg.WriteRune(r)
+			inArg = true
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '$' && quote != '\'' && env != nil && variableLength(runes[i+1:]) > 0:
+			length := variableLength(runes[i+1:])
+			name := strings.Trim(string(runes[i+1:i+1+length]), "{}")
+			arg.WriteString(env[name])
+			// Like in a shell, an unquoted empty expansion is no argument.
+			inArg = inArg || env[name] != ""
+			i += length
+		case quote == '"':
+			switch r {
+			case '"':
+				quote = 0
+			case '\\':
+				escaped = true
+			default:
+				arg.WriteRune(r)
+			}
+		case r == '\\':
+			escaped = true
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if escaped {
+		return nil, errUnterminatedEscape
+	}
+	if quote != 0 {
+		return nil, errUnterminatedQuote
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// specialParameters are the parameters named by a character that can't start
+// a variable name: the positional ones and $$, $?, $# and $-.
+const specialParameters = "0123456789$?#-"
+
+// variableLength returns the length of the variable name at the start of
+// runes, including braces, or 0 if there is none.
+func variableLength(runes []rune) int {
+	braced := len(runes) > 0 && runes[0] == '{'
+	start := 0
+	if braced {
+		start = 1
+	}
+	end := start
+	if end < len(runes) && strings.ContainsRune(specialParameters, runes[end]) {
+		end++
+	} else {
+		for end < len(runes) && isNameRune(runes[end], end == start) {
+			end++
+		}
+	}
+	if end == start {
+		return 0
+	}
+	if braced {
+		if end == len(runes) || runes[end] != '}' {
+			return 0
+		}
+		end++
+	}
+	return end
+}
+
+// isNameRune reports whether r can be part of a variable name.
+func isNameRune(r rune, first bool) bool {
+	return r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || !first && r >= '0' && r <= '9'
+}
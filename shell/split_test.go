@@ -0,0 +1,81 @@
+package shell
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	for _, test := range []struct {
+		line string
+		args []string
+	}{
+		{"", []string{}},
+		{"uname -a", []string{"uname", "-a"}},
+		{"  ls \t -la  /tmp ", []string{"ls", "-la", "/tmp"}},
+		{`echo 'hello world'`, []string{"echo", "hello world"}},
+		{`echo "hello world"`, []string{"echo", "hello world"}},
+		{`echo "it's" 'say "hi"'`, []string{"echo", "it's", `say "hi"`}},
+		{`echo hello\ world`, []string{"echo", "hello world"}},
+		{`echo \"quoted\"`, []string{"echo", `"quoted"`}},
+		{`echo "a \"b\" \$c \d"`, []string{"echo", `a "b" $c \d`}},
+		{`echo 'a \"b\"'`, []string{"echo", `a \"b\"`}},
+		{`echo '' ""`, []string{"echo", "", ""}},
+		{`echo a'b'"c"`, []string{"echo", "abc"}},
+		{`echo $HOME ${HOME}`, []string{"echo", "$HOME", "${HOME}"}},
+		{"echo a\\\nb", []string{"echo", "ab"}},
+		{"echo a \\\n b", []string{"echo", "a", "b"}},
+		{"echo \"a\\\nb\"", []string{"echo", "ab"}},
+		{"echo 'a\\\nb'", []string{"echo", "a\\\nb"}},
+	} {
+		args, err := Split(test.line)
+		if err != nil {
+			t.Errorf("Split(%q) = %v", test.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(args, test.args) {
+			t.Errorf("Split(%q) = %q, want %q", test.line, args, test.args)
+		}
+	}
+}
+
+func TestSplitExpands(t *testing.T) {
+	env := map[string]string{"HOME": "/root", "EMPTY": "", "A1": "b c"}
+	for _, test := range []struct {
+		line string
+		args []string
+	}{
+		{`echo $HOME ${HOME}/x`, []string{"echo", "/root", "/root/x"}},
+		{`echo "$HOME" '$HOME'`, []string{"echo", "/root", "$HOME"}},
+		{`echo $UNSET end`, []string{"echo", "end"}},
+		{`echo $EMPTY "$EMPTY" a$EMPTY`, []string{"echo", "", "a"}},
+		{`echo "$A1" $A1`, []string{"echo", "b c", "b c"}},
+		{`echo $ \$HOME ${HOME`, []string{"echo", "$", "$HOME", "${HOME"}},
+	} {
+		args, err := split(test.line, env)
+		if err != nil {
+			t.Errorf("split(%q) = %v", test.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(args, test.args) {
+			t.Errorf("split(%q) = %q, want %q", test.line, args, test.args)
+		}
+	}
+}
+
+func TestSplitMalformed(t *testing.T) {
+	for _, test := range []struct {
+		line string
+		err  error
+	}{
+		{`echo 'unterminated`, errUnterminatedQuote},
+		{`echo "unterminated`, errUnterminatedQuote},
+		{`echo "it's`, errUnterminatedQuote},
+		{`echo trailing\`, errUnterminatedEscape},
+		{`echo "trailing\`, errUnterminatedEscape},
+	} {
+		if args, err := Split(test.line); err != test.err {
+			t.Errorf("Split(%q) = %q, %v, want %v", test.line, args, err, test.err)
+		}
+	}
+}
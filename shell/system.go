@@ -0,0 +1,202 @@
+package shell
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// System is the identity of the fake host presented to a client. Every command
+// that reveals hardware, memory, uptime or processes derives its output from the
+// same System so that their outputs agree with each other.
+type System struct {
+	// Profile is the distribution, kernel and hardware of the host.
+	Profile  *Profile
+	Hostname string
+	CPUModel string
+	// CPUMHz is 0 for processors that don't report their clock speed.
+	CPUMHz   float64
+	BogoMIPS float64
+	CPUCores int
+	// Memory sizes are in KiB.
+	MemoryTotal, MemoryFree, MemoryBuffers, MemoryCached uint64
+	Boot                                                 time.Time
+	LoadAverage                                          [3]float64
+	Filesystems                                          []Filesystem
+	Processes                                            []Process
+	Users                                                []User
+	Logins                                               []Login
+	// History is the bash history the user logged in as saved before, in
+	// HistoryFile, which is empty if there is none.
+	History     []string
+	HistoryFile string
+	// IP is the host's own address on its private network, a /24 whose first
+	// address is the gateway, reached through the interface with MAC.
+	IP  string
+	MAC string
+	// ReceiveRate and SendRate are the average bytes per second received and
+	// sent on the network since boot.
+	ReceiveRate, SendRate uint64
+	// MachineID and BootID identify the installation and the current boot.
+	MachineID, BootID string
+	// ShellPID is the PID of the shell of the client, the latest process.
+	ShellPID int
+	// DiskID is the GUID of the partition table of the disk.
+	DiskID string
+}
+
+// User is an account on a System.
+type User struct {
+	Name  string
+	UID   int
+	GID   int
+	Home  string
+	Shell string
+	// Hash is the password hash shown in /etc/shadow. Accounts without a
+	// password have * or ! instead.
+	Hash string
+	// Changed is the day of the last password change, in days since the epoch.
+	Changed int
+}
+
+// Filesystem is a mounted filesystem of a System. Sizes are in KiB.
+type Filesystem struct {
+	Device, MountPoint, Type, Options string
+	Size, Used                        uint64
+}
+
+// Process is a process running on a System.
+type Process struct {
+	PID     int
+	User    string
+	Start   time.Time
+	Command string
+}
+
+// hostnames are the hostnames hosts may have, along with the ID of their
+// distribution.
+var hostnames = []string{"web01", "srv-prod-2", "db-backup", "node3", "app-server"}
+
+// NewSystem returns a plausible System following profile derived
+// deterministically from seed, with its boot time relative to now. The System
+// has an account for user.
+func NewSystem(profile *Profile, seed int64, now time.Time, user string) *System {
+	random := rand.New(rand.NewSource(seed))
+	cpu := profile.CPUs[random.Intn(len(profile.CPUs))]
+	cores := 1 << uint(random.Intn(4))
+	memoryTotal := uint64(1<<uint(random.Intn(5)))*1024*1024 - uint64(random.Intn(64*1024)) - 48*1024
+	memoryCached := memoryTotal / uint64(4+random.Intn(4))
+	memoryBuffers := memoryTotal / uint64(40+random.Intn(40))
+	memoryFree := memoryTotal / uint64(2+random.Intn(3))
+	boot := now.Add(-time.Duration(3*24+random.Intn(200*24))*time.Hour - time.Duration(random.Intn(3600))*time.Second).Truncate(time.Second)
+	rootSize := uint64(20+random.Intn(180)) * 1024 * 1024
+	system := &System{
+		Profile:       profile,
+		Hostname:      append(hostnames, profile.id())[random.Intn(len(hostnames)+1)],
+		CPUModel:      cpu.model,
+		CPUMHz:        cpu.mhz,
+		BogoMIPS:      cpu.bogomips,
+		CPUCores:      cores,
+		MemoryTotal:   memoryTotal,
+		MemoryFree:    memoryFree,
+		MemoryBuffers: memoryBuffers,
+		MemoryCached:  memoryCached,
+		Boot:          boot,
+		LoadAverage:   [3]float64{random.Float64() * 0.5, random.Float64() * 0.3, random.Float64() * 0.2},
+		Filesystems: []Filesystem{
+			{"udev", "/dev", "devtmpfs", "rw,nosuid,relatime", memoryTotal / 2, 0},
+			{"tmpfs", "/run", "tmpfs", "rw,nosuid,nodev,noexec,relatime", memoryTotal / 10, 1024 + uint64(random.Intn(1024))},
+			{"/dev/sda1", "/", "ext4", "rw,relatime", rootSize, rootSize / uint64(3+random.Intn(8))},
+			{"tmpfs", "/dev/shm", "tmpfs", "rw,nosuid,nodev", memoryTotal / 2, 0},
+			{"/dev/sda15", "/boot/efi", "vfat", "rw,relatime", 106858, 6186},
+		},
+	}
+	system.IP = fmt.Sprintf("10.%v.%v.%v", random.Intn(256), random.Intn(256), 2+random.Intn(250))
+	changed := int(boot.Unix()/86400) - random.Intn(400)
+	system.Users = []User{
+		{"root", 0, 0, "/root", "/bin/bash", passwordHash(random), changed},
+		{"daemon", 1, 1, "/usr/sbin", "/usr/sbin/nologin", "*", changed - 300},
+		{"bin", 2, 2, "/bin", "/usr/sbin/nologin", "*", changed - 300},
+		{"sys", 3, 3, "/dev", "/usr/sbin/nologin", "*", changed - 300},
+		{"sync", 4, 65534, "/bin", "/bin/sync", "*", changed - 300},
+		{"www-data", 33, 33, "/var/www", "/usr/sbin/nologin", "*", changed - 300},
+		{"nobody", 65534, 65534, "/nonexistent", "/usr/sbin/nologin", "*", changed - 300},
+		{"systemd-network", 100, 102, "/run/systemd", "/usr/sbin/nologin", "*", changed - 300},
+		{"syslog", 104, 110, "/home/syslog", "/usr/sbin/nologin", "*", changed - 300},
+		{"sshd", 105, 65534, "/run/sshd", "/usr/sbin/nologin", "*", changed - 300},
+		{profile.DefaultUser, 1000, 1000, "/home/" + profile.DefaultUser, "/bin/bash", passwordHash(random), changed + random.Intn(30)},
+	}
+	if system.LookupUser(user) == nil && user != "" {
+		system.Users = append(system.Users, User{user, 1001, 1001, "/home/" + user, "/bin/bash", passwordHash(random), changed + random.Intn(30)})
+	}
+	system.Processes = []Process{
+		{1, "root", boot, "/sbin/init"},
+		{2, "root", boot, "[kthreadd]"},
+		{412, "root", boot.Add(3 * time.Second), "/lib/systemd/systemd-journald"},
+		{455, "root", boot.Add(3 * time.Second), "/lib/systemd/systemd-udevd"},
+		{687, "systemd+", boot.Add(5 * time.Second), "/lib/systemd/systemd-networkd"},
+		{702, "root", boot.Add(5 * time.Second), "/usr/sbin/cron -f"},
+		{705, "syslog", boot.Add(5 * time.Second), "/usr/sbin/rsyslogd -n -iNONE"},
+		{731, "root", boot.Add(6 * time.Second), "sshd: /usr/sbin/sshd -D [listener] 0 of 10-100 startups"},
+	}
+	system.MAC = fmt.Sprintf("52:54:00:%02x:%02x:%02x", random.Intn(256), random.Intn(256), random.Intn(256))
+	system.ReceiveRate = uint64(2000 + random.Intn(30000))
+	system.SendRate = system.ReceiveRate / uint64(2+random.Intn(6))
+	system.MachineID = fmt.Sprintf("%016x%016x", random.Uint64(), random.Uint64())
+	system.BootID = fmt.Sprintf("%016x%016x", random.Uint64(), random.Uint64())
+	system.ShellPID = 1000 + random.Intn(30000)
+	system.DiskID = fmt.Sprintf("%08X-%04X-%04X-%04X-%012X", random.Uint32(), random.Intn(1<<16), random.Intn(1<<16), random.Intn(1<<16), random.Int63n(1<<48))
+	return system
+}
+
+const hashAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// passwordHash returns something that looks like a SHA-512 crypt hash but
+// isn't the hash of anything.
+func passwordHash(random *rand.Rand) string {
+	hash := []byte("$6$")
+	for i := 0; i < 16; i++ {
+		hash = append(hash, hashAlphabet[random.Intn(len(hashAlphabet))])
+	}
+	hash = append(hash, '$')
+	for i := 0; i < 86; i++ {
+		hash = append(hash, hashAlphabet[random.Intn(len(hashAlphabet))])
+	}
+	return string(hash)
+}
+
+// LookupUser returns the account called name, or nil if there is none.
+func (system *System) LookupUser(name string) *User {
+	for i := range system.Users {
+		if system.Users[i].Name == name {
+			return &system.Users[i]
+		}
+	}
+	return nil
+}
+
+// IsSystemUser reports whether name is an account the Systems of some profile
+// have, rather than one added for the user a client logged in as.
+func IsSystemUser(name string) bool {
+	for _, profile := range Profiles {
+		if NewSystem(profile, 0, time.Time{}, "").LookupUser(name) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Uptime returns how long the System has been running at now.
+func (system *System) Uptime(now time.Time) time.Duration {
+	return now.Sub(system.Boot)
+}
+
+// MemoryUsed returns the KiB of memory used by processes.
+func (system *System) MemoryUsed() uint64 {
+	return system.MemoryTotal - system.MemoryFree - system.MemoryBuffers - system.MemoryCached
+}
+
+// MemoryAvailable returns the KiB of memory available to new processes.
+func (system *System) MemoryAvailable() uint64 {
+	return system.MemoryFree + system.MemoryCached*9/10 + system.MemoryBuffers
+}
@@ -0,0 +1,69 @@
+package shell
+
+import (
+	"context"
+	"github.com/longkeyy/sshesame/config"
+	"github.com/longkeyy/sshesame/session"
+	log "github.com/sirupsen/logrus"
+)
+
+// transferFiles is the fake filesystem file transfer servers see, the one of
+// shells.
+type transferFiles struct {
+	process *process
+}
+
+// newTransferFiles returns the filesystem of the file transfer server called
+// program.
+func newTransferFiles(ctx context.Context, sess *session.Session, cfg config.Shell, program string) *transferFiles {
+	return &transferFiles{
+		process: &process{shell: newShell(ctx, sess, cfg, false), args: []string{program}},
+	}
+}
+
+// resolve returns the absolute path of name, relative paths being relative to
+// the home directory like for sftp-server and scp.
+func (files *transferFiles) resolve(name string) string {
+	if name == "" {
+		name = "."
+	}
+	return files.process.shell.resolve(name)
+}
+
+// stat describes the file at the absolute filePath, if there's one.
+func (files *transferFiles) stat(filePath string) (fileInfo, bool) {
+	return files.process.stat(filePath)
+}
+
+// listDir returns the names of the entries of the directory at the absolute
+// dir, sorted.
+func (files *transferFiles) listDir(dir string) []string {
+	return files.process.listDir(dir)
+}
+
+// isDir reports whether there's a directory at the absolute dir.
+func (files *transferFiles) isDir(dir string) bool {
+	return files.process.isDir(dir)
+}
+
+// content returns the content of the file at the absolute filePath, which
+// exists, logging the read.
+func (files *transferFiles) content(filePath string) string {
+	content, _ := files.process.fileContent(filePath, true)
+	return content
+}
+
+// upload puts a file uploaded through source, sftp or scp, on the host.
+func (files *transferFiles) upload(filePath string, content []byte, source string) {
+	sess := files.process.shell.session
+	file := sess.AddFile(filePath, content, source)
+	log.WithFields(log.Fields{
+		"client":   sess.RemoteAddr,
+		"channel":  "session",
+		"source":   source,
+		"path":     filePath,
+		"size":     len(content),
+		"sha256":   file.SHA256,
+		"category": "file_upload",
+	}).Info("File uploaded")
+}
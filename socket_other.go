@@ -0,0 +1,24 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package main
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// socketOptionsSupported reports whether SO_REUSEPORT and listen backlogs can
+// be set on this platform.
+const socketOptionsSupported = false
+
+var errSocketOptionsUnsupported = errors.New("SO_REUSEPORT and listen backlogs aren't supported on this platform")
+
+func reusePort(network, address string, conn syscall.RawConn) error {
+	return errSocketOptionsUnsupported
+}
+
+func setBacklog(listener net.Listener, backlog int) error {
+	return errSocketOptionsUnsupported
+}
@@ -0,0 +1,47 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package main
+
+import (
+	"golang.org/x/sys/unix"
+	"net"
+	"syscall"
+)
+
+// socketOptionsSupported reports whether SO_REUSEPORT and listen backlogs can
+// be set on this platform.
+const socketOptionsSupported = true
+
+// reusePort is a net.ListenConfig.Control hook setting SO_REUSEPORT, so that
+// several processes can listen on the same port, the kernel spreading
+// connections between them.
+func reusePort(network, address string, conn syscall.RawConn) error {
+	var optErr error
+	if err := conn.Control(func(fd uintptr) {
+		optErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	}); err != nil {
+		return err
+	}
+	return optErr
+}
+
+// setBacklog listens on the socket of listener again with backlog, which
+// replaces the backlog it listens with.
+func setBacklog(listener net.Listener, backlog int) error {
+	tcpListener, ok := listener.(*net.TCPListener)
+	if !ok {
+		return nil
+	}
+	conn, err := tcpListener.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var listenErr error
+	if err := conn.Control(func(fd uintptr) {
+		listenErr = unix.Listen(int(fd), backlog)
+	}); err != nil {
+		return err
+	}
+	return listenErr
+}
@@ -0,0 +1,62 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package main
+
+import (
+	"net"
+	"runtime"
+	"testing"
+)
+
+// accepted counts the connections listener accepts into counts at index
+// until it's closed.
+func accepted(listener net.Listener, counts chan<- int, index int) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		conn.Close()
+		counts <- index
+	}
+}
+
+func TestReusePort(t *testing.T) {
+	options := socketOptions{ReusePort: true, Backlog: 16}
+	first, err := options.listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	address := first.Addr().String()
+	if listener, err := (socketOptions{}).listen("tcp4", address); err == nil {
+		listener.Close()
+		t.Fatal("bound a port in use without SO_REUSEPORT")
+	}
+	second, err := options.listen("tcp4", address)
+	if err != nil {
+		t.Fatalf("binding the port again with SO_REUSEPORT = %v", err)
+	}
+	defer second.Close()
+	if runtime.GOOS != "linux" {
+		// Only Linux spreads connections between the sockets.
+		return
+	}
+
+	counts := make(chan int)
+	go accepted(first, counts, 0)
+	go accepted(second, counts, 1)
+	seen := [2]int{}
+	for i := 0; i < 200 && (seen[0] == 0 || seen[1] == 0); i++ {
+		conn, err := net.Dial("tcp4", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[<-counts]++
+		conn.Close()
+	}
+	if seen[0] == 0 || seen[1] == 0 {
+		t.Errorf("listeners accepted %v connections, want both to accept some", seen)
+	}
+}
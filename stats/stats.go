@@ -0,0 +1,314 @@
+// Package stats aggregates what clients do across connections.
+package stats
+
+import (
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxEntries bounds every aggregate so that a flood of unique credentials or
+// addresses can't exhaust memory; once full, only existing entries are updated.
+const maxEntries = 100000
+
+// SprayingThreshold is the number of distinct users a single password must be
+// tried against to be reported as password spraying.
+const SprayingThreshold = 5
+
+// ReplayThreshold is the number of distinct addresses a single user and
+// password must be tried from to be reported as replayed.
+const ReplayThreshold = 2
+
+// Host is what is known about a client address.
+type Host struct {
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+	Connections uint64    `json:"connections"`
+}
+
+// Credential is a user and password pair and how often it was tried.
+type Credential struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+	Count    uint64 `json:"count"`
+}
+
+// Stats holds aggregates that are expected to survive restarts.
+type Stats struct {
+	mu sync.Mutex
+	// active and activeChannels aren't snapshotted, connections don't
+	// survive restarts.
+	active         int
+	activeChannels int
+	Hosts          map[string]*Host           `json:"hosts"`
+	Credentials    map[string]*Credential     `json:"credentials"`
+	Spraying       map[string]map[string]bool `json:"spraying"`
+	// Replays are the addresses every user and password was tried from, up
+	// to ReplayThreshold.
+	Replays map[string][]string `json:"replays"`
+}
+
+// New returns empty aggregates.
+func New() *Stats {
+	return &Stats{
+		Hosts:       map[string]*Host{},
+		Credentials: map[string]*Credential{},
+		Spraying:    map[string]map[string]bool{},
+		Replays:     map[string][]string{},
+	}
+}
+
+// Load reads aggregates snapshotted to path. A missing file yields empty
+// aggregates, as does a corrupt one, along with a warning.
+func Load(path string) *Stats {
+	stats := New()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warning("Failed to read stats snapshot, starting fresh:", err.Error())
+		}
+		return stats
+	}
+	if err := json.Unmarshal(data, stats); err != nil {
+		log.Warning("Failed to parse stats snapshot, starting fresh:", err.Error())
+		return New()
+	}
+	if stats.Hosts == nil {
+		stats.Hosts = map[string]*Host{}
+	}
+	if stats.Credentials == nil {
+		stats.Credentials = map[string]*Credential{}
+	}
+	if stats.Spraying == nil {
+		stats.Spraying = map[string]map[string]bool{}
+	}
+	if stats.Replays == nil {
+		stats.Replays = map[string][]string{}
+	}
+	return stats
+}
+
+// Snapshot atomically writes the aggregates to path.
+func (stats *Stats) Snapshot(path string) error {
+	stats.mu.Lock()
+	data, err := json.Marshal(stats)
+	stats.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	temp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
+
+// SnapshotEvery snapshots the aggregates to path every interval until stop is
+// closed, and once more when it is.
+func (stats *Stats) SnapshotEvery(path string, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			if err := stats.Snapshot(path); err != nil {
+				log.Warning("Failed to snapshot stats:", err.Error())
+			}
+			return
+		}
+		if err := stats.Snapshot(path); err != nil {
+			log.Warning("Failed to snapshot stats:", err.Error())
+		}
+	}
+}
+
+func hostKey(addr net.Addr) string {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// RecordConnection records a connection from addr. It reports whether addr
+// was never seen before.
+func (stats *Stats) RecordConnection(addr net.Addr) bool {
+	now := time.Now()
+	key := hostKey(addr)
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	stats.active++
+	host, ok := stats.Hosts[key]
+	if !ok {
+		if len(stats.Hosts) >= maxEntries {
+			return true
+		}
+		host = &Host{FirstSeen: now}
+		stats.Hosts[key] = host
+	}
+	host.LastSeen = now
+	host.Connections++
+	return !ok
+}
+
+// RecordDisconnection must be called whenever a client disconnects.
+func (stats *Stats) RecordDisconnection() {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	stats.active--
+}
+
+// Active returns the number of clients currently connected.
+func (stats *Stats) Active() int {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	return stats.active
+}
+
+// RecordChannelOpened must be called whenever a client opens a channel.
+func (stats *Stats) RecordChannelOpened() {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	stats.activeChannels++
+}
+
+// RecordChannelClosed must be called whenever a channel is closed.
+func (stats *Stats) RecordChannelClosed() {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	stats.activeChannels--
+}
+
+// ActiveChannels returns the number of channels currently open.
+func (stats *Stats) ActiveChannels() int {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	return stats.activeChannels
+}
+
+// RecordPassword records a password authentication attempt. It reports whether
+// the attempt made password become a spraying password, tried against
+// SprayingThreshold distinct users.
+func (stats *Stats) RecordPassword(user, password string) bool {
+	key := user + "\x00" + password
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	credential, ok := stats.Credentials[key]
+	if !ok && len(stats.Credentials) < maxEntries {
+		credential = &Credential{User: user, Password: password}
+		stats.Credentials[key] = credential
+	}
+	if credential != nil {
+		credential.Count++
+	}
+	users, ok := stats.Spraying[password]
+	if !ok {
+		if len(stats.Spraying) >= maxEntries {
+			return false
+		}
+		users = map[string]bool{}
+		stats.Spraying[password] = users
+	}
+	if len(users) >= SprayingThreshold || users[user] {
+		return false
+	}
+	users[user] = true
+	return len(users) == SprayingThreshold
+}
+
+// RecordReplay records the address a user and password were tried from. It
+// reports whether the attempt made them replayed, tried from
+// ReplayThreshold distinct addresses, which it returns.
+func (stats *Stats) RecordReplay(user, password string, addr net.Addr) ([]string, bool) {
+	key := user + "\x00" + password
+	host := hostKey(addr)
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	hosts, ok := stats.Replays[key]
+	if !ok && len(stats.Replays) >= maxEntries {
+		return nil, false
+	}
+	if len(hosts) >= ReplayThreshold {
+		return nil, false
+	}
+	for _, known := range hosts {
+		if known == host {
+			return nil, false
+		}
+	}
+	hosts = append(hosts, host)
+	stats.Replays[key] = hosts
+	if len(hosts) != ReplayThreshold {
+		return nil, false
+	}
+	return append([]string{}, hosts...), true
+}
+
+// TopCredentials returns the n most tried credentials, most tried first.
+func (stats *Stats) TopCredentials(n int) []Credential {
+	stats.mu.Lock()
+	credentials := make([]Credential, 0, len(stats.Credentials))
+	for _, credential := range stats.Credentials {
+		credentials = append(credentials, *credential)
+	}
+	stats.mu.Unlock()
+	sort.Slice(credentials, func(i, j int) bool {
+		if credentials[i].Count != credentials[j].Count {
+			return credentials[i].Count > credentials[j].Count
+		}
+		if credentials[i].User != credentials[j].User {
+			return credentials[i].User < credentials[j].User
+		}
+		return credentials[i].Password < credentials[j].Password
+	})
+	if len(credentials) > n {
+		credentials = credentials[:n]
+	}
+	return credentials
+}
+
+// HostCount is a client address and how often it connected.
+type HostCount struct {
+	Address     string `json:"address"`
+	Connections uint64 `json:"connections"`
+}
+
+// TopHosts returns the n addresses that connected most, most connections
+// first.
+func (stats *Stats) TopHosts(n int) []HostCount {
+	stats.mu.Lock()
+	hosts := make([]HostCount, 0, len(stats.Hosts))
+	for address, host := range stats.Hosts {
+		hosts = append(hosts, HostCount{address, host.Connections})
+	}
+	stats.mu.Unlock()
+	sort.Slice(hosts, func(i, j int) bool {
+		if hosts[i].Connections != hosts[j].Connections {
+			return hosts[i].Connections > hosts[j].Connections
+		}
+		return hosts[i].Address < hosts[j].Address
+	})
+	if len(hosts) > n {
+		hosts = hosts[:n]
+	}
+	return hosts
+}
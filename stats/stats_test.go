@@ -0,0 +1,108 @@
+package stats
+
+import (
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// captureLog records the entries logged for the rest of the test instead
+// of printing them.
+func captureLog(t *testing.T) *logtest.Hook {
+	hook := logtest.NewGlobal()
+	out := log.StandardLogger().Out
+	log.SetOutput(ioutil.Discard)
+	t.Cleanup(func() {
+		log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+		log.SetOutput(out)
+	})
+	return hook
+}
+
+func addr(ip string) net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: 50000}
+}
+
+func TestSnapshotRestored(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.json")
+	stats := New()
+	stats.RecordConnection(addr("192.0.2.1"))
+	stats.RecordConnection(addr("192.0.2.1"))
+	stats.RecordConnection(addr("2001:db8::1"))
+	for _, user := range []string{"root", "admin", "ubuntu", "oracle"} {
+		stats.RecordPassword(user, "123456")
+	}
+	stats.RecordPassword("root", "123456")
+	stats.RecordReplay("root", "123456", addr("192.0.2.1"))
+	if err := stats.Snapshot(path); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := Load(path)
+	if first := restored.RecordConnection(addr("192.0.2.1")); first {
+		t.Error("192.0.2.1 seen for the first time after restoring")
+	}
+	if hosts, want := restored.TopHosts(2), []HostCount{{"192.0.2.1", 3}, {"2001:db8::1", 1}}; !reflect.DeepEqual(hosts, want) {
+		t.Errorf("TopHosts = %v, want %v", hosts, want)
+	}
+	if top := restored.TopCredentials(1); len(top) != 1 || top[0] != (Credential{"root", "123456", 2}) {
+		t.Errorf("TopCredentials = %v, want root:123456 tried twice", top)
+	}
+	if sprayed := restored.RecordPassword("pi", "123456"); !sprayed {
+		t.Errorf("123456 tried against a %vth user after restoring wasn't reported sprayed", SprayingThreshold)
+	}
+	if hosts, replayed := restored.RecordReplay("root", "123456", addr("198.51.100.1")); !replayed || !reflect.DeepEqual(hosts, []string{"192.0.2.1", "198.51.100.1"}) {
+		t.Errorf("RecordReplay = %v, %v, want replayed from the restored address", hosts, replayed)
+	}
+	if restored.Active() != 1 {
+		t.Errorf("Active = %v, want only the connection since restoring", restored.Active())
+	}
+}
+
+func TestLoadMissing(t *testing.T) {
+	hook := captureLog(t)
+	stats := Load(filepath.Join(t.TempDir(), "stats.json"))
+	if len(stats.Hosts) != 0 || len(stats.Credentials) != 0 {
+		t.Errorf("Load = %v, want empty aggregates", stats)
+	}
+	if len(hook.AllEntries()) != 0 {
+		t.Errorf("logged %q for a missing snapshot", hook.LastEntry().Message)
+	}
+}
+
+func TestLoadCorrupt(t *testing.T) {
+	hook := captureLog(t)
+	path := filepath.Join(t.TempDir(), "stats.json")
+	if err := ioutil.WriteFile(path, []byte(`{"hosts": {"192.0.2.1": `), 0600); err != nil {
+		t.Fatal(err)
+	}
+	stats := Load(path)
+	if first := stats.RecordConnection(addr("192.0.2.1")); !first {
+		t.Error("aggregates of a corrupt snapshot loaded")
+	}
+	if entry := hook.LastEntry(); entry == nil || entry.Level != log.WarnLevel {
+		t.Errorf("logged %v, want a warning", entry)
+	}
+}
+
+func TestSnapshotEveryOnStop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.json")
+	stats := New()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		stats.SnapshotEvery(path, time.Hour, stop)
+		close(done)
+	}()
+	stats.RecordConnection(addr("192.0.2.1"))
+	close(stop)
+	<-done
+	if hosts := Load(path).TopHosts(1); len(hosts) != 1 || hosts[0].Address != "192.0.2.1" {
+		t.Errorf("snapshotted %v on stop, want 192.0.2.1", hosts)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// systemdListenFDsStart is the first file descriptor systemd passes sockets
+// from, see sd_listen_fds(3).
+const systemdListenFDsStart = 3
+
+// systemdListener is a listening socket passed by systemd, named by the
+// FileDescriptorName of its socket unit, the unit's name by default.
+type systemdListener struct {
+	name     string
+	listener net.Listener
+}
+
+// systemdListeners returns the listening sockets systemd passed the process
+// with socket activation, in order. It returns none if the process wasn't
+// socket activated, and clears the variables passing them, so that they
+// aren't passed on to child processes.
+func systemdListeners() ([]systemdListener, error) {
+	pid, fds, names := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES")
+	if fds == "" || pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	count, err := strconv.Atoi(fds)
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+	fdNames := strings.Split(names, ":")
+	listeners := []systemdListener{}
+	for i := 0; i < count; i++ {
+		name := ""
+		if i < len(fdNames) {
+			name = fdNames[i]
+		}
+		file := os.NewFile(uintptr(systemdListenFDsStart+i), name)
+		// FileListener duplicates the descriptor, with close-on-exec set.
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("socket %v passed by systemd: %v", systemdListenFDsStart+i, err)
+		}
+		listeners = append(listeners, systemdListener{name, listener})
+	}
+	return listeners, nil
+}
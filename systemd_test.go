@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestSystemdListenersHelper lists the sockets it was passed, when run by
+// TestSystemdListeners as the process systemd activated.
+func TestSystemdListenersHelper(t *testing.T) {
+	if os.Getenv("SSHESAME_SYSTEMD_HELPER") == "" {
+		t.Skip("not run by TestSystemdListeners")
+	}
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	listeners, err := systemdListeners()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, listener := range listeners {
+		fmt.Printf("listener %v %v\n", listener.name, listener.listener.Addr())
+	}
+	if os.Getenv("LISTEN_FDS") != "" || os.Getenv("LISTEN_PID") != "" || os.Getenv("LISTEN_FDNAMES") != "" {
+		t.Error("variables passed on")
+	}
+}
+
+func TestSystemdListeners(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("systemd passes sockets on Unix only")
+	}
+	files := []*os.File{}
+	addrs := []string{}
+	for i := 0; i < 2; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		file, err := listener.(*net.TCPListener).File()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		files = append(files, file)
+		addrs = append(addrs, listener.Addr().String())
+	}
+	// The second socket has no name, LISTEN_FDNAMES naming only the first.
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSystemdListenersHelper$", "-test.v")
+	cmd.Env = append(os.Environ(), "SSHESAME_SYSTEMD_HELPER=1", "LISTEN_FDS=2", "LISTEN_FDNAMES=ssh.socket")
+	cmd.ExtraFiles = files
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %s", err, output)
+	}
+	for _, want := range []string{"listener ssh.socket " + addrs[0] + "\n", "listener  " + addrs[1] + "\n"} {
+		if !strings.Contains(string(output), want) {
+			t.Errorf("listed %q, want %q", output, want)
+		}
+	}
+}
+
+func TestSystemdListenersNotActivated(t *testing.T) {
+	for _, test := range []struct {
+		pid, fds string
+		invalid  bool
+	}{
+		{"", "", false},
+		// Sockets passed to another process, like the parent.
+		{"1", "1", false},
+		{strconv.Itoa(os.Getpid()), "0", false},
+		{strconv.Itoa(os.Getpid()), "-1", true},
+		{strconv.Itoa(os.Getpid()), "one", true},
+	} {
+		os.Setenv("LISTEN_PID", test.pid)
+		os.Setenv("LISTEN_FDS", test.fds)
+		listeners, err := systemdListeners()
+		if test.invalid != (err != nil) || len(listeners) != 0 {
+			t.Errorf("LISTEN_PID=%v LISTEN_FDS=%v: %v, %v", test.pid, test.fds, listeners, err)
+		}
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/longkeyy/sshesame/config"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a new self-signed certificate and its key to files
+// in a temporary directory, returning their paths.
+func writeCertificate(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ssh.example.com"},
+		DNSNames:     []string{"ssh.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestServeTLS(t *testing.T) {
+	hook := captureLog(t)
+	certFile, keyFile := writeCertificate(t)
+	listener, err := listenTLS("127.0.0.1:0", certFile, keyFile, socketOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve(t, listener, &config.Config{Auth: config.Auth{Methods: config.Methods{"password"}}})
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{ServerName: "ssh.example.com", InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshConn, channels, requests, err := ssh.NewClientConn(conn, listener.Addr().String(), &ssh.ClientConfig{
+		User:            "root",
+		Auth:            []ssh.AuthMethod{ssh.Password("password")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		conn.Close()
+		t.Fatal(err)
+	}
+	client := ssh.NewClient(sshConn, channels, requests)
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	if output, err := session.Output("echo hi"); err != nil || string(output) != "hi\n" {
+		t.Errorf("echo hi over TLS = %q, %v", output, err)
+	}
+
+	fields := map[string]map[string]interface{}{}
+	for _, entry := range hook.AllEntries() {
+		fields[entry.Message] = entry.Data
+	}
+	if hello := fields["TLS client hello received"]; hello == nil || hello["server_name"] != "ssh.example.com" {
+		t.Errorf("TLS client hello logged with %v, want server name ssh.example.com", hello)
+	}
+	if handshake := fields["TLS handshake completed"]; handshake == nil || handshake["server_name"] != "ssh.example.com" || handshake["cipher_suite"] != tls.CipherSuiteName(conn.ConnectionState().CipherSuite) {
+		t.Errorf("TLS handshake logged with %v, want the server name and cipher suite", handshake)
+	}
+}
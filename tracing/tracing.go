@@ -0,0 +1,316 @@
+// Package tracing records connections as distributed traces and exports them
+// to an OpenTelemetry collector over OTLP/HTTP with JSON encoding. Each
+// connection is a root span with child spans for its authentication, channels
+// and commands.
+//
+// A nil Tracer or Span does nothing, so tracing costs nothing when disabled.
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/longkeyy/sshesame/outbound"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// queueSize bounds the ended spans waiting to be exported, further ones are
+	// dropped rather than slowing down connections.
+	queueSize = 4096
+	// batchSize is the most spans exported in a single request.
+	batchSize     = 512
+	flushInterval = 5 * time.Second
+	exportTimeout = 10 * time.Second
+)
+
+// Tracer starts spans and exports them once they end.
+type Tracer struct {
+	endpoint    string
+	serviceName string
+	client      *http.Client
+	spans       chan *Span
+	done        chan struct{}
+	closeOnce   sync.Once
+}
+
+// NewTracer returns a tracer exporting spans to the OTLP/HTTP traces endpoint
+// of a collector, e.g. http://localhost:4318/v1/traces, as serviceName. It
+// returns nil, which traces nothing, if endpoint is empty.
+func NewTracer(endpoint, serviceName string) *Tracer {
+	if endpoint == "" {
+		return nil
+	}
+	tracer := &Tracer{
+		endpoint:    endpoint,
+		serviceName: serviceName,
+		client:      outbound.HTTPClient(exportTimeout),
+		spans:       make(chan *Span, queueSize),
+		done:        make(chan struct{}),
+	}
+	go tracer.export()
+	return tracer
+}
+
+// Start starts a root span, the start of a new trace.
+func (tracer *Tracer) Start(name string, attributes map[string]string) *Span {
+	if tracer == nil {
+		return nil
+	}
+	return tracer.start(name, randomID(16), "", attributes)
+}
+
+func (tracer *Tracer) start(name, traceID, parentID string, attributes map[string]string) *Span {
+	span := &Span{
+		tracer:     tracer,
+		name:       name,
+		traceID:    traceID,
+		spanID:     randomID(8),
+		parentID:   parentID,
+		start:      time.Now(),
+		attributes: map[string]string{},
+	}
+	for key, value := range attributes {
+		span.attributes[key] = value
+	}
+	return span
+}
+
+// Close exports the spans that ended and stops the tracer. Spans ending later
+// are dropped.
+func (tracer *Tracer) Close() {
+	if tracer == nil {
+		return
+	}
+	tracer.closeOnce.Do(func() {
+		close(tracer.spans)
+		<-tracer.done
+	})
+}
+
+// Span is a timed operation of a trace.
+type Span struct {
+	tracer                    *Tracer
+	name                      string
+	traceID, spanID, parentID string
+	start                     time.Time
+
+	mu         sync.Mutex
+	end        time.Time
+	attributes map[string]string
+	err        string
+	ended      bool
+}
+
+// Child starts a span as part of this one.
+func (span *Span) Child(name string, attributes map[string]string) *Span {
+	if span == nil {
+		return nil
+	}
+	return span.tracer.start(name, span.traceID, span.spanID, attributes)
+}
+
+// SetAttribute sets an attribute of the span.
+func (span *Span) SetAttribute(key, value string) {
+	if span == nil {
+		return
+	}
+	span.mu.Lock()
+	defer span.mu.Unlock()
+	span.attributes[key] = value
+}
+
+// SetError marks the span as failed with err.
+func (span *Span) SetError(err error) {
+	if span == nil || err == nil {
+		return
+	}
+	span.mu.Lock()
+	defer span.mu.Unlock()
+	span.err = err.Error()
+}
+
+// End ends the span and queues it for export. Only the first call has an
+// effect.
+func (span *Span) End() {
+	if span == nil {
+		return
+	}
+	span.mu.Lock()
+	if span.ended {
+		span.mu.Unlock()
+		return
+	}
+	span.ended = true
+	span.end = time.Now()
+	span.mu.Unlock()
+	defer func() {
+		// The tracer was closed.
+		recover()
+	}()
+	select {
+	case span.tracer.spans <- span:
+	default:
+	}
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpan returns a copy of ctx carrying span.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, span)
+}
+
+// SpanFromContext returns the span carried by ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanContextKey{}).(*Span)
+	return span
+}
+
+// export sends ended spans to the collector in batches until the tracer is
+// closed.
+func (tracer *Tracer) export() {
+	defer close(tracer.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	batch := []*Span{}
+	for {
+		select {
+		case span, ok := <-tracer.spans:
+			if !ok {
+				tracer.send(batch)
+				return
+			}
+			batch = append(batch, span)
+			if len(batch) < batchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+		tracer.send(batch)
+		batch = batch[:0]
+	}
+}
+
+// send exports spans in a single request, dropping them if that fails.
+func (tracer *Tracer) send(spans []*Span) {
+	if len(spans) == 0 {
+		return
+	}
+	body, err := json.Marshal(tracer.request(spans))
+	if err != nil {
+		log.Warning("Failed to encode trace spans:", err.Error())
+		return
+	}
+	response, err := tracer.client.Post(tracer.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Warning("Failed to export trace spans:", err.Error())
+		return
+	}
+	response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		log.Warning("Failed to export trace spans:", fmt.Sprintf("collector responded %v", response.Status))
+	}
+}
+
+// The OTLP/HTTP JSON encoding of an ExportTraceServiceRequest, see
+// https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding. IDs are
+// hex encoded and 64-bit integers are strings.
+type (
+	exportRequest struct {
+		ResourceSpans []resourceSpans `json:"resourceSpans"`
+	}
+	resourceSpans struct {
+		Resource   resource     `json:"resource"`
+		ScopeSpans []scopeSpans `json:"scopeSpans"`
+	}
+	resource struct {
+		Attributes []keyValue `json:"attributes"`
+	}
+	scopeSpans struct {
+		Scope scope      `json:"scope"`
+		Spans []spanData `json:"spans"`
+	}
+	scope struct {
+		Name string `json:"name"`
+	}
+	spanData struct {
+		TraceID           string     `json:"traceId"`
+		SpanID            string     `json:"spanId"`
+		ParentSpanID      string     `json:"parentSpanId,omitempty"`
+		Name              string     `json:"name"`
+		Kind              int        `json:"kind"`
+		StartTimeUnixNano string     `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+		Attributes        []keyValue `json:"attributes,omitempty"`
+		Status            status     `json:"status"`
+	}
+	keyValue struct {
+		Key   string       `json:"key"`
+		Value stringHolder `json:"value"`
+	}
+	stringHolder struct {
+		StringValue string `json:"stringValue"`
+	}
+	status struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+const (
+	spanKindServer = 2
+	statusCodeOK   = 1
+	statusCodeErr  = 2
+)
+
+func (tracer *Tracer) request(spans []*Span) exportRequest {
+	data := make([]spanData, len(spans))
+	for i, span := range spans {
+		span.mu.Lock()
+		data[i] = spanData{
+			TraceID:           span.traceID,
+			SpanID:            span.spanID,
+			ParentSpanID:      span.parentID,
+			Name:              span.name,
+			Kind:              spanKindServer,
+			StartTimeUnixNano: strconv.FormatInt(span.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.end.UnixNano(), 10),
+			Attributes:        keyValues(span.attributes),
+			Status:            status{Code: statusCodeOK},
+		}
+		if span.err != "" {
+			data[i].Status = status{Code: statusCodeErr, Message: span.err}
+		}
+		span.mu.Unlock()
+	}
+	return exportRequest{[]resourceSpans{{
+		Resource: resource{keyValues(map[string]string{"service.name": tracer.serviceName})},
+		ScopeSpans: []scopeSpans{{
+			Scope: scope{"github.com/longkeyy/sshesame/tracing"},
+			Spans: data,
+		}},
+	}}}
+}
+
+func keyValues(attributes map[string]string) []keyValue {
+	keyValues := make([]keyValue, 0, len(attributes))
+	for key, value := range attributes {
+		keyValues = append(keyValues, keyValue{key, stringHolder{value}})
+	}
+	return keyValues
+}
+
+// randomID returns size random bytes, hex encoded.
+func randomID(size int) string {
+	id := make([]byte, size)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
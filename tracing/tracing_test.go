@@ -0,0 +1,92 @@
+package tracing
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// collector is an OTLP/HTTP collector recording the spans exported to it.
+type collector struct {
+	mu      sync.Mutex
+	spans   []spanData
+	service string
+}
+
+func (collector *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var request exportRequest
+	if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" || json.NewDecoder(r.Body).Decode(&request) != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	for _, resourceSpans := range request.ResourceSpans {
+		for _, attribute := range resourceSpans.Resource.Attributes {
+			if attribute.Key == "service.name" {
+				collector.service = attribute.Value.StringValue
+			}
+		}
+		for _, scopeSpans := range resourceSpans.ScopeSpans {
+			collector.spans = append(collector.spans, scopeSpans.Spans...)
+		}
+	}
+}
+
+// attribute returns the value of the attribute of span with key.
+func attribute(span spanData, key string) string {
+	for _, attribute := range span.Attributes {
+		if attribute.Key == key {
+			return attribute.Value.StringValue
+		}
+	}
+	return ""
+}
+
+func TestSpansExported(t *testing.T) {
+	collector := &collector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+	tracer := NewTracer(server.URL, "sshesame-test")
+	root := tracer.Start("connection", map[string]string{"client.address": "192.0.2.1:50000"})
+	child := root.Child("auth", nil)
+	child.SetAttribute("user", "root")
+	child.SetError(errors.New("rejected"))
+	child.End()
+	child.End()
+	root.End()
+	tracer.Close()
+	// Spans ending once the tracer is closed are dropped.
+	root.Child("late", nil).End()
+
+	if collector.service != "sshesame-test" || len(collector.spans) != 2 {
+		t.Fatalf("collected %+v as %q, want 2 spans", collector.spans, collector.service)
+	}
+	auth, connection := collector.spans[0], collector.spans[1]
+	if connection.Name != "connection" || connection.ParentSpanID != "" || len(connection.TraceID) != 32 || len(connection.SpanID) != 16 || attribute(connection, "client.address") != "192.0.2.1:50000" || connection.Status.Code != statusCodeOK {
+		t.Errorf("root span %+v", connection)
+	}
+	if auth.Name != "auth" || auth.TraceID != connection.TraceID || auth.ParentSpanID != connection.SpanID || attribute(auth, "user") != "root" || auth.Status.Code != statusCodeErr || auth.Status.Message != "rejected" {
+		t.Errorf("child span %+v of %+v", auth, connection)
+	}
+	if auth.StartTimeUnixNano > auth.EndTimeUnixNano && len(auth.StartTimeUnixNano) == len(auth.EndTimeUnixNano) {
+		t.Errorf("span ended at %v before starting at %v", auth.EndTimeUnixNano, auth.StartTimeUnixNano)
+	}
+}
+
+func TestTracingDisabled(t *testing.T) {
+	tracer := NewTracer("", "sshesame")
+	if tracer != nil {
+		t.Fatal("tracer returned without an endpoint")
+	}
+	// Nothing panics.
+	span := tracer.Start("connection", nil)
+	span.Child("auth", nil).End()
+	span.SetAttribute("user", "root")
+	span.SetError(errors.New("rejected"))
+	span.End()
+	tracer.Close()
+}
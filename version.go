@@ -0,0 +1,8 @@
+package main
+
+// version and commit identify the build. They are set at build time, e.g.
+// go build -ldflags "-X main.version=v1.2.3 -X main.commit=$(git rev-parse HEAD)".
+var (
+	version = "dev"
+	commit  = "unknown"
+)
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestVersionFlag(t *testing.T) {
+	// The flags are only defined by main, which is run in a child process
+	// so that it can exit.
+	if os.Getenv("SSHESAME_TEST_MAIN") == "1" {
+		version, commit = "v1.2.3", "0123abc"
+		os.Args = []string{"sshesame", "-version"}
+		main()
+		return
+	}
+	command := exec.Command(os.Args[0], "-test.run=^TestVersionFlag$")
+	command.Env = append(os.Environ(), "SSHESAME_TEST_MAIN=1")
+	// The test binary prints its own result after main's output.
+	output, err := command.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "sshesame v1.2.3 (commit 0123abc, " + runtime.Version() + ")\n"; !strings.HasPrefix(string(output), want) {
+		t.Errorf("-version printed %q, want %q", output, want)
+	}
+}